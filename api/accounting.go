@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -28,6 +30,33 @@ type nodeHeartbeatRequest struct {
 	SampledAt         string  `json:"sampledAt"`
 }
 
+type nodeTrafficStatsRequest struct {
+	NodeID       string             `json:"nodeId"`
+	Region       string             `json:"region"`
+	LineCode     string             `json:"lineCode"`
+	XrayRevision string             `json:"xrayRevision"`
+	ResetEpoch   int64              `json:"resetEpoch"`
+	SampledAt    string             `json:"sampledAt"`
+	Stats        []nodeTrafficEntry `json:"stats"`
+}
+
+type nodeTrafficEntry struct {
+	AccountUUID   string `json:"accountUuid"`
+	Email         string `json:"email"`
+	UplinkTotal   int64  `json:"uplinkTotal"`
+	DownlinkTotal int64  `json:"downlinkTotal"`
+}
+
+// nodeTrafficLateSampleWindow bounds how far back an ingested sample may be
+// attributed. Older samples land in the previous minute so that buckets the
+// rating worker may already have billed are never reopened.
+const nodeTrafficLateSampleWindow = time.Minute
+
+// nodeTrafficCheckpointAttempts bounds how often a sample is recomputed when
+// concurrent reports for the same node and account keep moving its
+// checkpoint.
+const nodeTrafficCheckpointAttempts = 3
+
 func parseOptionalTime(value string) (time.Time, error) {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
@@ -350,3 +379,208 @@ func (h *handler) internalNodeHeartbeat(c *gin.Context) {
 
 	c.Status(http.StatusNoContent)
 }
+
+func (h *handler) internalNodeTraffic(c *gin.Context) {
+	var req nodeTrafficStatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid traffic payload")
+		return
+	}
+
+	h.ingestNodeTraffic(c, req)
+}
+
+// ingestNodeTraffic converts cumulative per-account counters reported by a
+// node into minute bucket deltas, advancing the stored checkpoints.
+func (h *handler) ingestNodeTraffic(c *gin.Context, req nodeTrafficStatsRequest) {
+	nodeID := strings.TrimSpace(req.NodeID)
+	if nodeID == "" {
+		respondError(c, http.StatusBadRequest, "node_id_required", "node id is required")
+		return
+	}
+	if req.ResetEpoch < 0 {
+		respondError(c, http.StatusBadRequest, "invalid_reset_epoch", "resetEpoch must not be negative")
+		return
+	}
+
+	sampledAt, err := parseOptionalTime(req.SampledAt)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_sampled_at", "sampledAt must be RFC3339")
+		return
+	}
+	now := time.Now().UTC()
+	if sampledAt.IsZero() || sampledAt.After(now) {
+		sampledAt = now
+	}
+	bucketStart := sampledAt.Truncate(time.Minute)
+	if earliest := now.Add(-nodeTrafficLateSampleWindow).Truncate(time.Minute); bucketStart.Before(earliest) {
+		bucketStart = earliest
+	}
+
+	ctx := c.Request.Context()
+	revision := strings.TrimSpace(req.XrayRevision)
+	accepted := 0
+	resets := 0
+	skipped := make([]string, 0)
+	for _, entry := range req.Stats {
+		reference := firstNonEmpty(strings.TrimSpace(entry.AccountUUID), strings.TrimSpace(entry.Email))
+		if entry.UplinkTotal < 0 || entry.DownlinkTotal < 0 {
+			skipped = append(skipped, reference)
+			continue
+		}
+		accountUUID, ok := h.resolveTrafficAccount(c, entry)
+		if !ok {
+			skipped = append(skipped, reference)
+			continue
+		}
+
+		reset, err := h.recordNodeTrafficSample(ctx, nodeTrafficSample{
+			nodeID:      nodeID,
+			accountUUID: accountUUID,
+			entry:       entry,
+			resetEpoch:  req.ResetEpoch,
+			revision:    revision,
+			region:      strings.TrimSpace(req.Region),
+			lineCode:    strings.TrimSpace(req.LineCode),
+			sampledAt:   sampledAt,
+			bucketStart: bucketStart,
+		})
+		if err != nil {
+			if errors.Is(err, store.ErrTrafficCheckpointChanged) {
+				respondError(c, http.StatusConflict, "checkpoint_conflict", "traffic checkpoint changed concurrently, retry the report")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "traffic_persist_failed", "failed to persist traffic sample")
+			return
+		}
+		if reset {
+			resets++
+		}
+		accepted++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodeId":      nodeID,
+		"accepted":    accepted,
+		"resets":      resets,
+		"skipped":     skipped,
+		"bucketStart": bucketStart,
+	})
+}
+
+type nodeTrafficSample struct {
+	nodeID      string
+	accountUUID string
+	entry       nodeTrafficEntry
+	resetEpoch  int64
+	revision    string
+	region      string
+	lineCode    string
+	sampledAt   time.Time
+	bucketStart time.Time
+}
+
+// recordNodeTrafficSample turns the cumulative counters of sample into a
+// delta against the stored checkpoint, then stores the delta and the new
+// checkpoint together. When another report moved the checkpoint first the
+// delta is recomputed, up to nodeTrafficCheckpointAttempts times. It reports
+// whether the counters were found to have reset.
+func (h *handler) recordNodeTrafficSample(ctx context.Context, sample nodeTrafficSample) (bool, error) {
+	var err error
+	for attempt := 0; attempt < nodeTrafficCheckpointAttempts; attempt++ {
+		checkpoint, lookupErr := h.store.GetTrafficStatCheckpoint(ctx, sample.nodeID, sample.accountUUID)
+		if lookupErr != nil {
+			if !errors.Is(lookupErr, store.ErrUserNotFound) {
+				return false, lookupErr
+			}
+			checkpoint = nil
+		}
+
+		// The first observation of a node/account pair only sets the
+		// checkpoint: its counters may hold traffic from before ingestion
+		// started, which is not billed.
+		entry := sample.entry
+		var uplinkDelta, downlinkDelta int64
+		resetEpoch := sample.resetEpoch
+		reset := false
+		if checkpoint != nil {
+			if trafficCounterReset(checkpoint, entry, sample.resetEpoch, sample.revision) {
+				reset = true
+				uplinkDelta, downlinkDelta = entry.UplinkTotal, entry.DownlinkTotal
+				if resetEpoch <= checkpoint.ResetEpoch {
+					resetEpoch = checkpoint.ResetEpoch + 1
+				}
+			} else {
+				uplinkDelta = entry.UplinkTotal - checkpoint.LastUplinkTotal
+				downlinkDelta = entry.DownlinkTotal - checkpoint.LastDownlinkTotal
+				resetEpoch = checkpoint.ResetEpoch
+			}
+		}
+
+		var bucket *store.TrafficMinuteBucket
+		if uplinkDelta > 0 || downlinkDelta > 0 {
+			bucket = &store.TrafficMinuteBucket{
+				BucketStart:    sample.bucketStart,
+				NodeID:         sample.nodeID,
+				AccountUUID:    sample.accountUUID,
+				Region:         sample.region,
+				LineCode:       sample.lineCode,
+				UplinkBytes:    uplinkDelta,
+				DownlinkBytes:  downlinkDelta,
+				TotalBytes:     uplinkDelta + downlinkDelta,
+				Multiplier:     1,
+				SourceRevision: sample.revision,
+			}
+		}
+
+		err = h.store.RecordTrafficSample(ctx, checkpoint, &store.TrafficStatCheckpoint{
+			NodeID:            sample.nodeID,
+			AccountUUID:       sample.accountUUID,
+			LastUplinkTotal:   entry.UplinkTotal,
+			LastDownlinkTotal: entry.DownlinkTotal,
+			LastSeenAt:        sample.sampledAt,
+			XrayRevision:      sample.revision,
+			ResetEpoch:        resetEpoch,
+		}, bucket)
+		if !errors.Is(err, store.ErrTrafficCheckpointChanged) {
+			return reset, err
+		}
+	}
+	return false, err
+}
+
+// resolveTrafficAccount maps a traffic entry onto a known account UUID. Xray
+// stats are keyed by the client email, so entries without an account UUID are
+// resolved by email.
+func (h *handler) resolveTrafficAccount(c *gin.Context, entry nodeTrafficEntry) (string, bool) {
+	ctx := c.Request.Context()
+	if accountUUID := strings.TrimSpace(entry.AccountUUID); accountUUID != "" {
+		user, err := h.store.GetUserByID(ctx, accountUUID)
+		if err != nil || user == nil {
+			return "", false
+		}
+		return user.ID, true
+	}
+	email := strings.ToLower(strings.TrimSpace(entry.Email))
+	if email == "" {
+		return "", false
+	}
+	user, err := h.store.GetUserByEmail(ctx, email)
+	if err != nil || user == nil {
+		return "", false
+	}
+	return user.ID, true
+}
+
+// trafficCounterReset reports whether the cumulative counters restarted since
+// the checkpoint was taken: the node announced a new reset epoch, Xray was
+// redeployed with a different revision, or a counter went backwards.
+func trafficCounterReset(checkpoint *store.TrafficStatCheckpoint, entry nodeTrafficEntry, resetEpoch int64, revision string) bool {
+	if resetEpoch > checkpoint.ResetEpoch {
+		return true
+	}
+	if revision != "" && checkpoint.XrayRevision != "" && revision != checkpoint.XrayRevision {
+		return true
+	}
+	return entry.UplinkTotal < checkpoint.LastUplinkTotal || entry.DownlinkTotal < checkpoint.LastDownlinkTotal
+}
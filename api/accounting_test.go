@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -83,6 +84,100 @@ func TestAgentUsersUseAccountEmailAsStatsEmail(t *testing.T) {
 	t.Fatalf("expected stats email %q in payload, got %#v", user.Email, payload.Clients)
 }
 
+func TestAgentUsersApplyQuotaEnforcement(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+
+	accounts := map[string]string{}
+	for _, name := range []string{"throttled", "suspended"} {
+		email := name + "@example.com"
+		if err := st.CreateUser(ctx, &store.User{
+			Name:          name,
+			Email:         email,
+			PasswordHash:  "hashed",
+			EmailVerified: true,
+			Role:          store.RoleUser,
+			Level:         store.LevelUser,
+			Active:        true,
+		}); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		user, err := st.GetUserByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("get user: %v", err)
+		}
+		accounts[name] = user.ID
+	}
+
+	if err := st.UpsertAccountQuotaState(ctx, &store.AccountQuotaState{
+		AccountUUID:   accounts["throttled"],
+		ThrottleState: store.ThrottleStateThrottled,
+		SuspendState:  store.SuspendStateActive,
+	}); err != nil {
+		t.Fatalf("upsert throttled state: %v", err)
+	}
+	if err := st.UpsertAccountQuotaState(ctx, &store.AccountQuotaState{
+		AccountUUID:    accounts["suspended"],
+		CurrentBalance: -1,
+		Arrears:        true,
+		ThrottleState:  store.ThrottleStateNormal,
+		SuspendState:   store.SuspendStateSuspended,
+	}); err != nil {
+		t.Fatalf("upsert suspended state: %v", err)
+	}
+
+	registry, err := agentserver.NewRegistry(agentserver.Config{
+		Credentials: []agentserver.Credential{{
+			ID:    "*",
+			Name:  "test-agent",
+			Token: "agent-token",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithAgentRegistry(registry), WithEmailVerification(false))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent-server/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer agent-token")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	var payload struct {
+		Clients []struct {
+			Email     string `json:"email"`
+			Throttled bool   `json:"throttled"`
+		} `json:"clients"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+
+	found := false
+	for _, client := range payload.Clients {
+		switch client.Email {
+		case "suspended@example.com":
+			t.Fatalf("suspended account must not be served to agents: %s", rec.Body.String())
+		case "throttled@example.com":
+			found = true
+			if !client.Throttled {
+				t.Fatalf("expected throttled account to be tagged: %s", rec.Body.String())
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected throttled account in payload, got %s", rec.Body.String())
+	}
+}
+
 func TestAccountUsageAndPolicyEndpoints(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -415,3 +510,232 @@ func TestInternalNetworkIdentitiesEndpoint(t *testing.T) {
 		t.Fatalf("expected exporter identity in payload, got %#v", payload.Identities)
 	}
 }
+
+func TestInternalNodeTrafficComputesDeltasAndResets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "test-internal-token")
+
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+
+	if err := st.CreateUser(ctx, &store.User{
+		Name:          "Traffic User",
+		Email:         "traffic@example.com",
+		PasswordHash:  "hashed",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+		ProxyUUID:     "proxy-traffic-id",
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	user, err := st.GetUserByEmail(ctx, "traffic@example.com")
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false))
+
+	push := func(body string) map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/nodes/traffic", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Service-Token", os.Getenv("INTERNAL_SERVICE_TOKEN"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("traffic ingest status: %d body=%s", rec.Code, rec.Body.String())
+		}
+		var payload map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("decode traffic payload: %v", err)
+		}
+		return payload
+	}
+
+	push(`{"nodeId":"hk-1","region":"hk","lineCode":"premium","xrayRevision":"r1","stats":[{"accountUuid":"` + user.ID + `","uplinkTotal":100,"downlinkTotal":200}]}`)
+	push(`{"nodeId":"hk-1","region":"hk","lineCode":"premium","xrayRevision":"r1","stats":[{"email":"traffic@example.com","uplinkTotal":150,"downlinkTotal":260}]}`)
+	payload := push(`{"nodeId":"hk-1","region":"hk","lineCode":"premium","xrayRevision":"r2","stats":[{"accountUuid":"` + user.ID + `","uplinkTotal":10,"downlinkTotal":20},{"email":"ghost@example.com","uplinkTotal":1,"downlinkTotal":1}]}`)
+	if payload["accepted"] != float64(1) || payload["resets"] != float64(1) {
+		t.Fatalf("unexpected ingest summary: %#v", payload)
+	}
+	if skipped, _ := payload["skipped"].([]any); len(skipped) != 1 || skipped[0] != "ghost@example.com" {
+		t.Fatalf("expected unknown account to be skipped, got %#v", payload["skipped"])
+	}
+
+	buckets, err := st.ListTrafficMinuteBucketsByAccount(ctx, user.ID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	var uplink, downlink int64
+	for _, bucket := range buckets {
+		if bucket.RatingStatus != store.RatingStatusPending || bucket.Region != "hk" || bucket.LineCode != "premium" {
+			t.Fatalf("unexpected bucket: %+v", bucket)
+		}
+		uplink += bucket.UplinkBytes
+		downlink += bucket.DownlinkBytes
+	}
+	// The first report only sets the checkpoint: 50, then 10 after the
+	// revision change reset the counters.
+	if uplink != 60 || downlink != 80 {
+		t.Fatalf("unexpected accumulated traffic: uplink=%d downlink=%d", uplink, downlink)
+	}
+
+	checkpoint, err := st.GetTrafficStatCheckpoint(ctx, "hk-1", user.ID)
+	if err != nil {
+		t.Fatalf("get checkpoint: %v", err)
+	}
+	if checkpoint.LastUplinkTotal != 10 || checkpoint.LastDownlinkTotal != 20 || checkpoint.XrayRevision != "r2" || checkpoint.ResetEpoch != 1 {
+		t.Fatalf("unexpected checkpoint: %+v", checkpoint)
+	}
+}
+
+func TestInternalNodeTrafficFirstObservationOnlySetsCheckpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "test-internal-token")
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	user := &store.User{
+		Name:          "Long Running Node User",
+		Email:         "long-running@example.com",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false))
+
+	push := func(uplink, downlink int64) {
+		t.Helper()
+		body := fmt.Sprintf(`{"nodeId":"sg-1","xrayRevision":"r1","stats":[{"accountUuid":%q,"uplinkTotal":%d,"downlinkTotal":%d}]}`, user.ID, uplink, downlink)
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/nodes/traffic", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Service-Token", os.Getenv("INTERNAL_SERVICE_TOKEN"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("traffic ingest status: %d body=%s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// A node whose Xray has been running for months reports its history.
+	push(40_000_000_000, 90_000_000_000)
+	buckets, err := st.ListTrafficMinuteBucketsByAccount(ctx, user.ID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	if len(buckets) != 0 {
+		t.Fatalf("expected the first observation to record no traffic, got %+v", buckets)
+	}
+	checkpoint, err := st.GetTrafficStatCheckpoint(ctx, "sg-1", user.ID)
+	if err != nil || checkpoint.LastUplinkTotal != 40_000_000_000 || checkpoint.LastDownlinkTotal != 90_000_000_000 {
+		t.Fatalf("expected the first observation to set the checkpoint, got %+v, %v", checkpoint, err)
+	}
+
+	push(40_000_000_100, 90_000_000_300)
+	buckets, err = st.ListTrafficMinuteBucketsByAccount(ctx, user.ID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].UplinkBytes != 100 || buckets[0].DownlinkBytes != 300 {
+		t.Fatalf("expected only traffic after the first observation, got %+v", buckets)
+	}
+}
+
+// racingTrafficStore lets another report move the checkpoint right before
+// the next sample is recorded.
+type racingTrafficStore struct {
+	store.Store
+	competing *store.TrafficStatCheckpoint
+}
+
+func (s *racingTrafficStore) RecordTrafficSample(ctx context.Context, previous, checkpoint *store.TrafficStatCheckpoint, bucket *store.TrafficMinuteBucket) error {
+	if competing := s.competing; competing != nil {
+		s.competing = nil
+		if err := s.Store.RecordTrafficSample(ctx, previous, competing, &store.TrafficMinuteBucket{
+			BucketStart:   bucket.BucketStart,
+			NodeID:        competing.NodeID,
+			AccountUUID:   competing.AccountUUID,
+			UplinkBytes:   competing.LastUplinkTotal - previous.LastUplinkTotal,
+			DownlinkBytes: competing.LastDownlinkTotal - previous.LastDownlinkTotal,
+			TotalBytes:    competing.LastUplinkTotal - previous.LastUplinkTotal + competing.LastDownlinkTotal - previous.LastDownlinkTotal,
+		}); err != nil {
+			return err
+		}
+	}
+	return s.Store.RecordTrafficSample(ctx, previous, checkpoint, bucket)
+}
+
+func TestInternalNodeTrafficRecomputesAfterConcurrentReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "test-internal-token")
+
+	ctx := context.Background()
+	st := &racingTrafficStore{Store: store.NewMemoryStore()}
+	user := &store.User{
+		Name:          "Racing Traffic User",
+		Email:         "racing@example.com",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailVerification(false))
+
+	push := func(uplink, downlink int64) {
+		t.Helper()
+		body := fmt.Sprintf(`{"nodeId":"hk-1","xrayRevision":"r1","stats":[{"accountUuid":%q,"uplinkTotal":%d,"downlinkTotal":%d}]}`, user.ID, uplink, downlink)
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/nodes/traffic", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Service-Token", os.Getenv("INTERNAL_SERVICE_TOKEN"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("traffic ingest status: %d body=%s", rec.Code, rec.Body.String())
+		}
+	}
+
+	push(100, 200)
+	st.competing = &store.TrafficStatCheckpoint{
+		NodeID:            "hk-1",
+		AccountUUID:       user.ID,
+		LastUplinkTotal:   150,
+		LastDownlinkTotal: 260,
+		LastSeenAt:        time.Now().UTC(),
+		XrayRevision:      "r1",
+	}
+	push(180, 300)
+
+	buckets, err := st.ListTrafficMinuteBucketsByAccount(ctx, user.ID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	var uplink, downlink int64
+	for _, bucket := range buckets {
+		uplink += bucket.UplinkBytes
+		downlink += bucket.DownlinkBytes
+	}
+	if uplink != 80 || downlink != 100 {
+		t.Fatalf("expected each byte to be counted once, got uplink=%d downlink=%d", uplink, downlink)
+	}
+	checkpoint, err := st.GetTrafficStatCheckpoint(ctx, "hk-1", user.ID)
+	if err != nil || checkpoint.LastUplinkTotal != 180 || checkpoint.LastDownlinkTotal != 300 {
+		t.Fatalf("unexpected checkpoint: %+v, %v", checkpoint, err)
+	}
+}
@@ -16,14 +16,10 @@ const (
 )
 
 func (h *handler) adminAssume(c *gin.Context) {
-	adminUser, ok := h.requireAdminPermission(c, permissionAdminSettingsWrite)
+	adminUser, ok := h.requireAdminPermission(c, permissionRootAssumeWrite)
 	if !ok {
 		return
 	}
-	if !h.isRootAccount(adminUser) {
-		respondError(c, http.StatusForbidden, "root_only", "root only")
-		return
-	}
 
 	var req struct {
 		Email string `json:"email"`
@@ -56,12 +52,18 @@ func (h *handler) adminAssume(c *gin.Context) {
 		return
 	}
 
-	sandboxToken, expiresAt, err := h.createSession(sandboxUser.ID)
+	sandboxToken, expiresAt, err := h.createSession(c, sandboxUser.ID, sessionAuthAdminAssume)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create sandbox session")
 		return
 	}
 
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAssume,
+		TargetType: auditTargetUser,
+		TargetID:   sandboxUser.ID,
+		Metadata:   map[string]any{"email": sandboxUser.Email},
+	})
 	slog.Info("admin assume sandbox",
 		"event", "admin_assume",
 		"actor_user_id", adminUser.ID,
@@ -81,15 +83,20 @@ func (h *handler) adminAssume(c *gin.Context) {
 }
 
 func (h *handler) adminAssumeRevert(c *gin.Context) {
-	adminUser, ok := h.requireAdminPermission(c, permissionAdminSettingsWrite)
+	adminUser, ok := h.requireAdminPermission(c, permissionRootAssumeWrite)
 	if !ok {
 		return
 	}
-	if !h.isRootAccount(adminUser) {
-		respondError(c, http.StatusForbidden, "root_only", "root only")
-		return
-	}
 
+	event := store.AuditEvent{
+		Action:     auditActionAssumeRevert,
+		TargetType: auditTargetUser,
+		Metadata:   map[string]any{"email": assumeSandboxEmail},
+	}
+	if sandboxUser, err := h.store.GetUserByEmail(c.Request.Context(), assumeSandboxEmail); err == nil {
+		event.TargetID = sandboxUser.ID
+	}
+	h.recordAudit(c, adminUser, event)
 	slog.Info("admin assume revert",
 		"event", "admin_assume_revert",
 		"actor_user_id", adminUser.ID,
@@ -101,12 +108,7 @@ func (h *handler) adminAssumeRevert(c *gin.Context) {
 }
 
 func (h *handler) adminAssumeStatus(c *gin.Context) {
-	adminUser, ok := h.requireAdminPermission(c, permissionAdminSettingsRead)
-	if !ok {
-		return
-	}
-	if !h.isRootAccount(adminUser) {
-		respondError(c, http.StatusForbidden, "root_only", "root only")
+	if _, ok := h.requireAdminPermission(c, permissionRootAssumeRead); !ok {
 		return
 	}
 
@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm"
 
 	"account/internal/model"
+	"account/internal/store"
 )
 
 func (h *handler) getSandboxBinding(c *gin.Context) {
@@ -62,6 +63,12 @@ func (h *handler) bindSandboxNode(c *gin.Context) {
 
 	agentID := strings.TrimSpace(req.Address)
 
+	var previous model.SandboxBinding
+	if err := h.db.WithContext(c.Request.Context()).First(&previous).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed_to_query_binding", "message": err.Error()})
+		return
+	}
+
 	err := h.db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
 		// Clear existing bindings (enforce 1-to-1 for now as per frontend)
 		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&model.SandboxBinding{}).Error; err != nil {
@@ -93,5 +100,13 @@ func (h *handler) bindSandboxNode(c *gin.Context) {
 		}
 	}
 
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionSandboxBind,
+		TargetType: auditTargetAgent,
+		TargetID:   agentID,
+		Before:     map[string]any{"address": previous.AgentID},
+		After:      map[string]any{"address": agentID},
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "sandbox node bound successfully", "address": agentID})
 }
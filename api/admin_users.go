@@ -13,6 +13,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"account/internal/store"
+	"account/internal/webhook"
 )
 
 type createCustomUserRequest struct {
@@ -58,16 +59,11 @@ func generatePasswordHash() (string, error) {
 }
 
 func (h *handler) createCustomUser(c *gin.Context) {
-	requestUser, ok := h.requireAdminPermission(c, permissionAdminUsersRoleWrite)
+	requestUser, ok := h.requireAdminPermission(c, permissionRootUsersCustomUUID)
 	if !ok {
 		return
 	}
 
-	if !h.isRootAccount(requestUser) {
-		respondError(c, http.StatusForbidden, "root_only", "only root account can create custom uuid users")
-		return
-	}
-
 	var req createCustomUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
@@ -141,6 +137,12 @@ func (h *handler) createCustomUser(c *gin.Context) {
 	if err != nil {
 		createdUser = user
 	}
+	if err := h.publishUserCreated(c.Request.Context(), createdUser); err != nil {
+		h.rollbackCreatedUser(c.Request.Context(), createdUser)
+		respondError(c, http.StatusInternalServerError, "user_creation_failed", "failed to create user")
+		return
+	}
+	h.recordUserChange(c, requestUser, auditActionUserCreate, createdUser, nil)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "user_created",
@@ -149,7 +151,8 @@ func (h *handler) createCustomUser(c *gin.Context) {
 }
 
 func (h *handler) pauseUser(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminUsersPause); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminUsersPause)
+	if !ok {
 		return
 	}
 
@@ -159,22 +162,29 @@ func (h *handler) pauseUser(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to find user")
 		return
 	}
-	if h.isRootAccount(user) {
-		respondError(c, http.StatusForbidden, "root_protected", "root account cannot be paused")
+	if h.rejectProtected(c, user, "root account cannot be paused") {
 		return
 	}
 
+	before := auditUserFields(user)
 	user.Active = false
 	if err := h.store.UpdateUser(c.Request.Context(), user); err != nil {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to pause user")
 		return
 	}
+	h.revokeRefreshTokens(c.Request.Context(), user.ID, store.RefreshTokenRevokedUserPaused)
+	h.recordUserChange(c, adminUser, auditActionUserPause, user, before)
+	if err := h.publishUserEvent(c.Request.Context(), webhook.EventUserPaused, user, nil); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "user paused"})
 }
 
 func (h *handler) resumeUser(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminUsersResume); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminUsersResume)
+	if !ok {
 		return
 	}
 
@@ -184,22 +194,28 @@ func (h *handler) resumeUser(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to find user")
 		return
 	}
-	if h.isRootAccount(user) {
-		respondError(c, http.StatusForbidden, "root_protected", "root account is always active")
+	if h.rejectProtected(c, user, "root account is always active") {
 		return
 	}
 
+	before := auditUserFields(user)
 	user.Active = true
 	if err := h.store.UpdateUser(c.Request.Context(), user); err != nil {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to resume user")
 		return
 	}
+	h.recordUserChange(c, adminUser, auditActionUserResume, user, before)
+	if err := h.publishUserEvent(c.Request.Context(), webhook.EventUserResumed, user, nil); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "user resumed"})
 }
 
 func (h *handler) deleteUser(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminUsersDelete); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminUsersDelete)
+	if !ok {
 		return
 	}
 
@@ -209,20 +225,31 @@ func (h *handler) deleteUser(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to find user")
 		return
 	}
-	if h.isRootAccount(user) {
-		respondError(c, http.StatusForbidden, "root_protected", "root account cannot be deleted")
+	if h.rejectProtected(c, user, "root account cannot be deleted") {
 		return
 	}
 	if err := h.store.DeleteUser(c.Request.Context(), userID); err != nil {
 		respondError(c, http.StatusInternalServerError, "delete_failed", "failed to delete user")
 		return
 	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionUserDelete,
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		Before:     auditUserFields(user),
+		Metadata:   map[string]any{"email": user.Email},
+	})
+	if err := h.publishUserEvent(c.Request.Context(), webhook.EventUserDeleted, user, nil); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
 }
 
 func (h *handler) renewProxyUUID(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminUsersRenewUUID); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminUsersRenewUUID)
+	if !ok {
 		return
 	}
 
@@ -241,10 +268,10 @@ func (h *handler) renewProxyUUID(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to find user")
 		return
 	}
-	if h.isRootAccount(user) {
-		respondError(c, http.StatusForbidden, "root_protected", "root account UUID cannot be renewed")
+	if h.rejectProtected(c, user, "root account UUID cannot be renewed") {
 		return
 	}
+	before := auditUserFields(user)
 
 	if req.ExpiresAt != "" {
 		t, err := time.Parse("2006-01-02", req.ExpiresAt)
@@ -267,6 +294,12 @@ func (h *handler) renewProxyUUID(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to renew proxy UUID")
 		return
 	}
+	// The new UUID is a credential, so the diff only records its expiry.
+	h.recordUserChange(c, adminUser, auditActionUserRenewUUID, user, before)
+	if err := h.publishUserEvent(c.Request.Context(), webhook.EventUserUUIDRenewed, user, nil); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "proxy UUID renewed",
@@ -290,7 +323,8 @@ func (h *handler) listBlacklist(c *gin.Context) {
 }
 
 func (h *handler) addToBlacklist(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminBlacklistWrite); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminBlacklistWrite)
+	if !ok {
 		return
 	}
 
@@ -306,12 +340,18 @@ func (h *handler) addToBlacklist(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "add_failed", "failed to add to blacklist")
 		return
 	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionBlacklistAdd,
+		TargetType: auditTargetEmail,
+		TargetID:   strings.ToLower(strings.TrimSpace(req.Email)),
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "email added to blacklist"})
 }
 
 func (h *handler) removeFromBlacklist(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminBlacklistWrite); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminBlacklistWrite)
+	if !ok {
 		return
 	}
 
@@ -320,6 +360,11 @@ func (h *handler) removeFromBlacklist(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "remove_failed", "failed to remove from blacklist")
 		return
 	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionBlacklistRemove,
+		TargetType: auditTargetEmail,
+		TargetID:   strings.ToLower(strings.TrimSpace(email)),
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "email removed from blacklist"})
 }
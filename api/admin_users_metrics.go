@@ -1,44 +1,133 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"account/internal/rbac"
 	"account/internal/service"
 	"account/internal/store"
 )
 
 const (
-	permissionAdminSettingsRead   = "admin.settings.read"
-	permissionAdminSettingsWrite  = "admin.settings.write"
-	permissionAdminUsersMetrics   = "admin.users.metrics.read"
-	permissionAdminUsersListRead  = "admin.users.list.read"
-	permissionAdminAgentsStatus   = "admin.agents.status.read"
-	permissionAdminUsersPause     = "admin.users.pause.write"
-	permissionAdminUsersResume    = "admin.users.resume.write"
-	permissionAdminUsersDelete    = "admin.users.delete.write"
-	permissionAdminUsersRenewUUID = "admin.users.renew_uuid.write"
-	permissionAdminUsersRoleWrite = "admin.users.role.write"
-	permissionAdminBlacklistRead  = "admin.blacklist.read"
-	permissionAdminBlacklistWrite = "admin.blacklist.write"
+	permissionAdminSettingsRead         = "admin.settings.read"
+	permissionAdminSettingsWrite        = "admin.settings.write"
+	permissionAdminUsersMetrics         = "admin.users.metrics.read"
+	permissionAdminUsersListRead        = "admin.users.list.read"
+	permissionAdminAgentsStatus         = "admin.agents.status.read"
+	permissionAdminAgentsWrite          = "admin.agents.write"
+	permissionAdminUsersPause           = "admin.users.pause.write"
+	permissionAdminUsersResume          = "admin.users.resume.write"
+	permissionAdminUsersDelete          = "admin.users.delete.write"
+	permissionAdminUsersRenewUUID       = "admin.users.renew_uuid.write"
+	permissionAdminUsersRoleWrite       = "admin.users.role.write"
+	permissionAdminUsersSessionsRead    = "admin.users.sessions.read"
+	permissionAdminUsersSessionsWrite   = "admin.users.sessions.write"
+	permissionAdminOIDCClientsRead      = "admin.oidc.clients.read"
+	permissionAdminOIDCClientsWrite     = "admin.oidc.clients.write"
+	permissionAdminBlacklistRead        = "admin.blacklist.read"
+	permissionAdminBlacklistWrite       = "admin.blacklist.write"
+	permissionAdminAuditRead            = "admin.audit.read"
+	permissionAdminWebhooksRead         = "admin.webhooks.read"
+	permissionAdminWebhooksWrite        = "admin.webhooks.write"
+	permissionAdminSCIMRead             = "admin.scim.read"
+	permissionAdminSCIMWrite            = "admin.scim.write"
+	permissionAdminTenantsRead          = "admin.tenants.read"
+	permissionAdminTenantsWrite         = "admin.tenants.write"
+	permissionAdminRolesRead            = "admin.roles.read"
+	permissionAdminRolesWrite           = "admin.roles.write"
+	permissionAdminServiceAccountsRead  = "admin.service_accounts.read"
+	permissionAdminServiceAccountsWrite = "admin.service_accounts.write"
 )
 
+// Root permissions are reserved for the root role: they are not in the
+// catalog, and AllPermissions does not cover them, so administrators, custom
+// roles and API tokens cannot hold them.
+const (
+	permissionRootUsersCustomUUID  = "root.users.custom_uuid.write"
+	permissionRootAssumeRead       = "root.assume.read"
+	permissionRootAssumeWrite      = "root.assume.write"
+	permissionRootTenantsBootstrap = "root.tenants.bootstrap"
+	// permissionRootProtected is checked on the target of an admin action:
+	// accounts holding it cannot be paused, resumed, deleted, renewed,
+	// re-roled or have their sessions managed.
+	permissionRootProtected = "root.protected"
+)
+
+var rootPermissions = []string{
+	permissionRootUsersCustomUUID,
+	permissionRootAssumeRead,
+	permissionRootAssumeWrite,
+	permissionRootTenantsBootstrap,
+	permissionRootProtected,
+}
+
+// adminPermissions is the permission catalog, in the order the admin console
+// lists it.
+var adminPermissions = []string{
+	permissionAdminSettingsRead,
+	permissionAdminSettingsWrite,
+	permissionAdminUsersMetrics,
+	permissionAdminUsersListRead,
+	permissionAdminAgentsStatus,
+	permissionAdminAgentsWrite,
+	permissionAdminUsersPause,
+	permissionAdminUsersResume,
+	permissionAdminUsersDelete,
+	permissionAdminUsersRenewUUID,
+	permissionAdminUsersRoleWrite,
+	permissionAdminUsersSessionsRead,
+	permissionAdminUsersSessionsWrite,
+	permissionAdminOIDCClientsRead,
+	permissionAdminOIDCClientsWrite,
+	permissionAdminBlacklistRead,
+	permissionAdminBlacklistWrite,
+	permissionAdminAuditRead,
+	permissionAdminWebhooksRead,
+	permissionAdminWebhooksWrite,
+	permissionAdminSCIMRead,
+	permissionAdminSCIMWrite,
+	permissionAdminTenantsRead,
+	permissionAdminTenantsWrite,
+	permissionAdminRolesRead,
+	permissionAdminRolesWrite,
+	permissionAdminServiceAccountsRead,
+	permissionAdminServiceAccountsWrite,
+}
+
 var defaultOperatorPermissions = map[string]bool{
-	permissionAdminSettingsRead:   true,
-	permissionAdminSettingsWrite:  false,
-	permissionAdminUsersMetrics:   true,
-	permissionAdminUsersListRead:  true,
-	permissionAdminAgentsStatus:   true,
-	permissionAdminUsersPause:     true,
-	permissionAdminUsersResume:    true,
-	permissionAdminUsersDelete:    false,
-	permissionAdminUsersRenewUUID: true,
-	permissionAdminUsersRoleWrite: false,
-	permissionAdminBlacklistRead:  true,
-	permissionAdminBlacklistWrite: true,
+	permissionAdminSettingsRead:         true,
+	permissionAdminSettingsWrite:        false,
+	permissionAdminUsersMetrics:         true,
+	permissionAdminUsersListRead:        true,
+	permissionAdminAgentsStatus:         true,
+	permissionAdminAgentsWrite:          false,
+	permissionAdminUsersPause:           true,
+	permissionAdminUsersResume:          true,
+	permissionAdminUsersDelete:          false,
+	permissionAdminUsersRenewUUID:       true,
+	permissionAdminUsersRoleWrite:       false,
+	permissionAdminUsersSessionsRead:    true,
+	permissionAdminUsersSessionsWrite:   true,
+	permissionAdminOIDCClientsRead:      true,
+	permissionAdminOIDCClientsWrite:     false,
+	permissionAdminBlacklistRead:        true,
+	permissionAdminBlacklistWrite:       true,
+	permissionAdminAuditRead:            true,
+	permissionAdminWebhooksRead:         true,
+	permissionAdminWebhooksWrite:        false,
+	permissionAdminSCIMRead:             true,
+	permissionAdminSCIMWrite:            false,
+	permissionAdminTenantsRead:          true,
+	permissionAdminTenantsWrite:         false,
+	permissionAdminRolesRead:            true,
+	permissionAdminRolesWrite:           false,
+	permissionAdminServiceAccountsRead:  true,
+	permissionAdminServiceAccountsWrite: false,
 }
 
 func (h *handler) adminUsersMetrics(c *gin.Context) {
@@ -67,21 +156,26 @@ func (h *handler) adminUsersMetrics(c *gin.Context) {
 }
 
 func (h *handler) requireAdminPermission(c *gin.Context, permission string) (*store.User, bool) {
-	token := h.resolveSessionToken(c)
-	if token == "" {
-		respondError(c, http.StatusUnauthorized, "session_token_required", "session token is required")
-		return nil, false
-	}
+	return h.authorize(c, permission, rbac.Platform)
+}
 
-	sess, ok := h.lookupSession(token)
-	if !ok {
-		respondError(c, http.StatusUnauthorized, "invalid_session", "session not found or expired")
-		return nil, false
+// authorize resolves the caller, from a session or an API token, and asks
+// the policy engine whether they hold permission on resource. An empty
+// permission admits administrators and operators signed in with a session
+// only.
+func (h *handler) authorize(c *gin.Context, permission string, resource rbac.Resource) (*store.User, bool) {
+	var (
+		user     *store.User
+		ok       bool
+		viaToken bool
+	)
+	if raw := extractToken(c.GetHeader("Authorization")); store.IsAPIToken(raw) {
+		user, ok = h.apiTokenPrincipal(c, raw)
+		viaToken = true
+	} else {
+		user, ok = h.sessionUser(c)
 	}
-
-	user, err := h.store.GetUserByID(c.Request.Context(), sess.userID)
-	if err != nil {
-		respondError(c, http.StatusInternalServerError, "session_user_lookup_failed", "failed to load session user")
+	if !ok {
 		return nil, false
 	}
 	if !user.Active {
@@ -89,82 +183,81 @@ func (h *handler) requireAdminPermission(c *gin.Context, permission string) (*st
 		return nil, false
 	}
 
-	if h.isReadOnlyAccount(user) && c.Request.Method != http.MethodGet {
+	method := c.Request.Method
+	readOnly := h.isReadOnlyAccount(user) || strings.EqualFold(strings.TrimSpace(user.Role), store.RoleReadOnly)
+	if readOnly && method != http.MethodGet && method != http.MethodHead {
 		respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
 		return nil, false
 	}
 
-	if store.IsRootRole(user.Role) {
-		if !strings.EqualFold(strings.TrimSpace(user.Email), store.RootAdminEmail) {
-			respondError(c, http.StatusForbidden, "root_email_enforced", "root role is restricted to admin@svc.plus")
-			return nil, false
-		}
-		return user, true
-	}
-	if strings.EqualFold(strings.TrimSpace(user.Role), store.RoleAdmin) {
-		return user, true
+	if store.IsRootRole(user.Role) && !strings.EqualFold(strings.TrimSpace(user.Email), store.RootAdminEmail) {
+		respondError(c, http.StatusForbidden, "root_email_enforced", "root role is restricted to admin@svc.plus")
+		return nil, false
 	}
 
-	if store.IsOperatorRole(user.Role) {
-		if permission != "" && !h.operatorPermissionAllowed(c, permission) {
-			respondError(c, http.StatusForbidden, "forbidden", "operator permission denied")
-			return nil, false
+	if permission == "" {
+		if !viaToken && (store.IsAdminRole(user.Role) || store.IsOperatorRole(user.Role)) {
+			return user, true
 		}
-		return user, true
+		respondError(c, http.StatusForbidden, "forbidden", "insufficient permissions")
+		return nil, false
 	}
 
-	if strings.EqualFold(strings.TrimSpace(user.Role), store.RoleReadOnly) {
-		method := c.Request.Method
-		if method != http.MethodGet && method != http.MethodHead {
-			respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
-			return nil, false
-		}
-		if permission == "" || !hasPermission(user.Permissions, permission) {
-			respondError(c, http.StatusForbidden, "forbidden", "readonly permission denied")
-			return nil, false
-		}
-		return user, true
+	allowed, err := h.holds(c, user, permission, resource)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "authorization_failed", "failed to evaluate permissions")
+		return nil, false
 	}
-
-	respondError(c, http.StatusForbidden, "forbidden", "insufficient permissions")
-	return nil, false
+	if !allowed {
+		respondError(c, http.StatusForbidden, "forbidden", "insufficient permissions")
+		return nil, false
+	}
+	return user, true
 }
 
-func (h *handler) requireAdminOrOperator(c *gin.Context) (*store.User, bool) {
-	return h.requireAdminPermission(c, "")
+// rejectProtected answers 403 root_protected with message when target holds
+// permissionRootProtected, and reports whether the request was answered.
+func (h *handler) rejectProtected(c *gin.Context, target *store.User, message string) bool {
+	protected, err := h.policy.Can(c.Request.Context(), target, permissionRootProtected, rbac.Platform)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "authorization_failed", "failed to evaluate permissions")
+		return true
+	}
+	if protected {
+		respondError(c, http.StatusForbidden, "root_protected", message)
+		return true
+	}
+	return false
 }
 
-func (h *handler) operatorPermissionAllowed(c *gin.Context, permission string) bool {
-	defaultAllowed := defaultOperatorPermissions[permission]
-	settings, err := service.GetAdminSettings(c.Request.Context())
-	if err != nil {
-		return defaultAllowed
+func (h *handler) sessionUser(c *gin.Context) (*store.User, bool) {
+	token := h.resolveSessionToken(c)
+	if token == "" {
+		respondError(c, http.StatusUnauthorized, "session_token_required", "session token is required")
+		return nil, false
 	}
 
-	module, ok := settings.Matrix[permission]
+	sess, ok := h.lookupSession(token)
 	if !ok {
-		return defaultAllowed
+		respondError(c, http.StatusUnauthorized, "invalid_session", "session not found or expired")
+		return nil, false
 	}
 
-	allowed, ok := module[store.RoleOperator]
-	if !ok {
-		return defaultAllowed
+	user, err := h.store.GetUserByID(c.Request.Context(), sess.userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_user_lookup_failed", "failed to load session user")
+		return nil, false
 	}
-	return allowed
+	return user, true
 }
 
-func hasPermission(permissions []string, target string) bool {
-	target = strings.TrimSpace(target)
-	if target == "" {
-		return false
-	}
-	for _, permission := range permissions {
-		normalized := strings.TrimSpace(permission)
-		if normalized == "*" || strings.EqualFold(normalized, target) {
-			return true
-		}
+// adminSettingsMatrix feeds the admin settings matrix to the policy engine.
+func adminSettingsMatrix(ctx context.Context) (map[string]map[string]bool, error) {
+	settings, err := service.GetAdminSettings(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return false
+	return settings.Matrix, nil
 }
 
 func (h *handler) resolveSessionToken(c *gin.Context) string {
@@ -189,6 +282,16 @@ func registerAdminRoutes(group *gin.RouterGroup, h *handler) {
 	admin := group.Group("/admin")
 	admin.GET("/users/metrics", h.adminUsersMetrics)
 	admin.GET("/agents/status", h.adminAgentStatus)
+	admin.GET("/agents/join-tokens", h.listAgentJoinTokens)
+	admin.POST("/agents/join-tokens", h.createAgentJoinToken)
+	admin.DELETE("/agents/join-tokens/:tokenId", h.deleteAgentJoinToken)
+	admin.GET("/agents", h.listManagedAgents)
+	admin.POST("/agents", h.createManagedAgent)
+	admin.GET("/agents/:agentId", h.getManagedAgent)
+	admin.DELETE("/agents/:agentId", h.deleteManagedAgent)
+	admin.POST("/agents/:agentId/tokens", h.rotateAgentToken)
+	admin.DELETE("/agents/:agentId/tokens/:tokenId", h.revokeAgentToken)
+	admin.DELETE("/agents/:agentId/certificates", h.revokeAgentCertificates)
 	admin.GET("/traffic/nodes", h.adminTrafficNodes)
 	admin.GET("/traffic/accounts/:uuid", h.adminTrafficAccount)
 	admin.GET("/collector/status", h.adminCollectorStatus)
@@ -200,12 +303,77 @@ func registerAdminRoutes(group *gin.RouterGroup, h *handler) {
 	admin.POST("/users/:userId/resume", h.resumeUser)
 	admin.DELETE("/users/:userId", h.deleteUser)
 	admin.POST("/users/:userId/renew-uuid", h.renewProxyUUID)
+	admin.GET("/users/:userId/sessions", h.adminListUserSessions)
+	admin.DELETE("/users/:userId/sessions", h.adminRevokeUserSessions)
+	admin.DELETE("/users/:userId/sessions/:sessionId", h.adminRevokeUserSession)
+	admin.GET("/users/:userId/api-tokens", h.adminListUserAPITokens)
+	admin.DELETE("/users/:userId/api-tokens/:tokenId", h.adminRevokeUserAPIToken)
+
+	// OpenID Connect clients
+	admin.GET("/oidc/clients", h.listOIDCClients)
+	admin.POST("/oidc/clients", h.createOIDCClient)
+	admin.DELETE("/oidc/clients/:clientId", h.deleteOIDCClient)
 
 	// Email blacklist
 	admin.GET("/blacklist", h.listBlacklist)
 	admin.POST("/blacklist", h.addToBlacklist)
 	admin.DELETE("/blacklist/:email", h.removeFromBlacklist)
 
+	// Audit log
+	admin.GET("/audit", h.listAuditEvents)
+
+	// Lifecycle event webhooks
+	admin.GET("/webhooks", h.listWebhooks)
+	admin.POST("/webhooks", h.createWebhook)
+	admin.PATCH("/webhooks/:id", h.updateWebhook)
+	admin.DELETE("/webhooks/:id", h.deleteWebhook)
+	admin.GET("/webhooks/:id/deliveries", h.listWebhookDeliveries)
+	admin.GET("/events", h.listOutboxEvents)
+	admin.GET("/events/:eventId/deliveries", h.listOutboxEventDeliveries)
+	admin.POST("/events/:eventId/replay", h.replayOutboxEvent)
+
+	// Tenants, their domains, members and invitations
+	admin.GET("/tenants", h.listTenants)
+	admin.POST("/tenants", h.createTenant)
+	admin.GET("/tenants/:tenantId", h.getTenant)
+	admin.PATCH("/tenants/:tenantId", h.updateTenant)
+	admin.DELETE("/tenants/:tenantId", h.deleteTenant)
+	admin.GET("/tenants/:tenantId/domains", h.listTenantDomains)
+	admin.POST("/tenants/:tenantId/domains", h.addTenantDomain)
+	admin.PATCH("/tenants/:tenantId/domains/:domainId", h.updateTenantDomain)
+	admin.DELETE("/tenants/:tenantId/domains/:domainId", h.deleteTenantDomain)
+	admin.POST("/tenants/:tenantId/domains/:domainId/verify", h.verifyTenantDomain)
+	admin.GET("/tenants/:tenantId/members", h.listTenantMembers)
+	admin.PATCH("/tenants/:tenantId/members/:userId", h.updateTenantMember)
+	admin.DELETE("/tenants/:tenantId/members/:userId", h.removeTenantMember)
+	admin.GET("/tenants/:tenantId/invitations", h.listTenantInvitations)
+	admin.POST("/tenants/:tenantId/invitations", h.createTenantInvitation)
+	admin.DELETE("/tenants/:tenantId/invitations/:invitationId", h.deleteTenantInvitation)
+
+	// SCIM provisioning tokens
+	admin.GET("/tenants/:tenantId/scim-tokens", h.listSCIMTokens)
+	admin.POST("/tenants/:tenantId/scim-tokens", h.createSCIMToken)
+	admin.DELETE("/tenants/:tenantId/scim-tokens/:tokenId", h.deleteSCIMToken)
+
+	// Roles and role bindings
+	admin.GET("/roles", h.listRoles)
+	admin.POST("/roles", h.createRole)
+	admin.PATCH("/roles/:roleKey", h.updateRole)
+	admin.DELETE("/roles/:roleKey", h.deleteRole)
+	admin.GET("/users/:userId/role-bindings", h.listUserRoleBindings)
+	admin.POST("/users/:userId/role-bindings", h.createUserRoleBinding)
+	admin.DELETE("/users/:userId/role-bindings/:bindingId", h.deleteUserRoleBinding)
+
+	// Service accounts and their API tokens
+	admin.GET("/service-accounts", h.listServiceAccounts)
+	admin.POST("/service-accounts", h.createServiceAccount)
+	admin.GET("/service-accounts/:serviceAccountId", h.getServiceAccount)
+	admin.PATCH("/service-accounts/:serviceAccountId", h.updateServiceAccount)
+	admin.DELETE("/service-accounts/:serviceAccountId", h.deleteServiceAccount)
+	admin.GET("/service-accounts/:serviceAccountId/tokens", h.listServiceAccountTokens)
+	admin.POST("/service-accounts/:serviceAccountId/tokens", h.createServiceAccountToken)
+	admin.DELETE("/service-accounts/:serviceAccountId/tokens/:tokenId", h.deleteServiceAccountToken)
+
 	// Sandbox mode
 	admin.GET("/sandbox/binding", h.getSandboxBinding)
 	admin.POST("/sandbox/bind", h.bindSandboxNode)
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/agentca"
+	"account/internal/agentproto"
+	"account/internal/agentserver"
+	"account/internal/store"
+)
+
+const (
+	defaultAgentJoinTokenTTLHours = 24
+	maxAgentJoinTokenTTLHours     = 7 * 24
+	maxAgentIDLength              = 128
+)
+
+type agentJoinTokenResponse struct {
+	ID        string     `json:"id"`
+	AgentID   string     `json:"agentId"`
+	Groups    []string   `json:"groups"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+}
+
+func newAgentJoinTokenResponse(token *store.AgentJoinToken) agentJoinTokenResponse {
+	groups := token.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return agentJoinTokenResponse{
+		ID:        token.ID,
+		AgentID:   token.AgentID,
+		Groups:    groups,
+		CreatedBy: token.CreatedBy,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		UsedAt:    token.UsedAt,
+	}
+}
+
+type createAgentJoinTokenRequest struct {
+	AgentID        string   `json:"agentId"`
+	Groups         []string `json:"groups"`
+	ExpiresInHours *int     `json:"expiresInHours"`
+}
+
+// authenticateAgent resolves the credential of an agent-server request: a
+// client certificate from the agent CA when one is presented, otherwise the
+// bearer token. Certificates and managed agent tokens yield a bound identity.
+func (h *handler) authenticateAgent(c *gin.Context) (*agentserver.Identity, bool) {
+	if identity, ok := h.presentedAgentCertificate(c); ok {
+		if !h.agentCertificateActive(c, identity) {
+			return nil, false
+		}
+		registered := h.agentRegistry.RegisterAgent(identity.AgentID, identity.Groups)
+		registered.Bound = true
+		return &registered, true
+	}
+
+	token := extractToken(c.GetHeader("Authorization"))
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_token"})
+		return nil, false
+	}
+	credential, ok := h.agentRegistry.Authenticate(token)
+	if !ok || credential == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return nil, false
+	}
+	return credential, true
+}
+
+// presentedAgentCertificate returns the agent named by a client certificate
+// from the agent CA, when the request presented one.
+func (h *handler) presentedAgentCertificate(c *gin.Context) (agentca.Identity, bool) {
+	if h.agentCA == nil || c.Request.TLS == nil {
+		return agentca.Identity{}, false
+	}
+	return h.agentCA.Identify(c.Request.TLS.VerifiedChains)
+}
+
+// agentCertificateActive reports whether a certificate still grants access:
+// its agent must exist in the store and the certificate must have been
+// issued after the agent's revocation cut-off. A deleted agent therefore
+// cannot come back by presenting a certificate it still holds.
+func (h *handler) agentCertificateActive(c *gin.Context, identity agentca.Identity) bool {
+	ctx := c.Request.Context()
+	if _, err := h.store.GetAgent(ctx, identity.AgentID); err != nil {
+		if errors.Is(err, store.ErrAgentNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "certificate_revoked"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "agent_lookup_failed"})
+		return false
+	}
+	revokedBefore, err := h.store.AgentCertificatesRevokedBefore(ctx, identity.AgentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "agent_lookup_failed"})
+		return false
+	}
+	if !revokedBefore.IsZero() && !identity.IssuedAt.After(revokedBefore) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "certificate_revoked"})
+		return false
+	}
+	return true
+}
+
+// resolveAgent picks the agent a request acts for. A shared token may name
+// any agent, which is registered on first use; a bound credential names
+// exactly one agent and a different requested ID is rejected.
+func (h *handler) resolveAgent(c *gin.Context, credential *agentserver.Identity, requestedID string) (agentserver.Identity, bool) {
+	identity := *credential
+	if requestedID == "" || requestedID == identity.ID {
+		return identity, true
+	}
+	if identity.Bound {
+		c.JSON(http.StatusForbidden, gin.H{"error": "agent_id_mismatch"})
+		return agentserver.Identity{}, false
+	}
+	// Shared token scenario: register a concrete agent id so sandbox bindings can target it.
+	return h.agentRegistry.RegisterAgent(requestedID, identity.Groups), true
+}
+
+// enrollAgent exchanges a one-time join token and a CSR for the agent's
+// first client certificate.
+func (h *handler) enrollAgent(c *gin.Context) {
+	if h.agentCA == nil || h.agentRegistry == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "agent_ca_unavailable"})
+		return
+	}
+
+	var req agentproto.EnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "message": err.Error()})
+		return
+	}
+	secret := strings.TrimSpace(req.Token)
+	if !strings.HasPrefix(secret, store.AgentJoinTokenPrefix) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_join_token"})
+		return
+	}
+	// Check the request before spending the token on it.
+	if err := agentca.CheckRequest([]byte(req.CSR)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_csr"})
+		return
+	}
+
+	joinToken, err := h.store.ConsumeAgentJoinToken(c.Request.Context(), hashAPIToken(secret), time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, store.ErrAgentJoinTokenNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_join_token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "enroll_failed"})
+		return
+	}
+
+	// Certificates are only accepted for agents present in the store, so the
+	// agent is persisted before its first request rather than on it.
+	agent := &store.Agent{ID: joinToken.AgentID, Name: joinToken.AgentID, Groups: joinToken.Groups}
+	if err := h.store.CreateAgent(c.Request.Context(), agent); err != nil && !errors.Is(err, store.ErrAgentExists) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "enroll_failed"})
+		return
+	}
+	cert, err := h.agentCA.Issue([]byte(req.CSR), joinToken.AgentID, joinToken.Groups)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "issue_certificate_failed"})
+		return
+	}
+	if err := h.store.RecordAgentCertificate(c.Request.Context(), joinToken.AgentID, cert.NotAfter); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "enroll_failed"})
+		return
+	}
+	h.agentRegistry.RegisterAgent(joinToken.AgentID, joinToken.Groups)
+	h.recordAudit(c, nil, store.AuditEvent{
+		Action:     auditActionAgentEnroll,
+		TargetType: auditTargetAgent,
+		TargetID:   joinToken.AgentID,
+		Metadata:   map[string]any{"joinTokenId": joinToken.ID, "serial": cert.Serial, "expiresAt": cert.NotAfter},
+	})
+
+	c.JSON(http.StatusCreated, h.newAgentCertificateResponse(cert))
+}
+
+// renewAgentCertificate issues a new certificate to an agent authenticated
+// with its current one. Agents holding only a token cannot use it.
+func (h *handler) renewAgentCertificate(c *gin.Context) {
+	if h.agentCA == nil || h.agentRegistry == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "agent_ca_unavailable"})
+		return
+	}
+	identity, ok := h.presentedAgentCertificate(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "certificate_required"})
+		return
+	}
+	if !h.agentCertificateActive(c, identity) {
+		return
+	}
+
+	var req agentproto.RenewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "message": err.Error()})
+		return
+	}
+	cert, err := h.agentCA.Issue([]byte(req.CSR), identity.AgentID, identity.Groups)
+	if err != nil {
+		if errors.Is(err, agentca.ErrInvalidCSR) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_csr"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "issue_certificate_failed"})
+		return
+	}
+	if err := h.store.RecordAgentCertificate(c.Request.Context(), identity.AgentID, cert.NotAfter); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "issue_certificate_failed"})
+		return
+	}
+	h.recordAudit(c, nil, store.AuditEvent{
+		Action:     auditActionAgentCertRenew,
+		TargetType: auditTargetAgent,
+		TargetID:   identity.AgentID,
+		Metadata:   map[string]any{"previousSerial": identity.Serial, "serial": cert.Serial, "expiresAt": cert.NotAfter},
+	})
+
+	c.JSON(http.StatusOK, h.newAgentCertificateResponse(cert))
+}
+
+func (h *handler) newAgentCertificateResponse(cert agentca.Certificate) agentproto.CertificateResponse {
+	return agentproto.CertificateResponse{
+		AgentID:       cert.AgentID,
+		Certificate:   string(cert.PEM),
+		CACertificate: string(h.agentCA.CertificatePEM()),
+		ExpiresAt:     cert.NotAfter,
+	}
+}
+
+func (h *handler) listAgentJoinTokens(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminAgentsStatus); !ok {
+		return
+	}
+	tokens, err := h.store.ListAgentJoinTokens(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_join_tokens_failed", "failed to list agent join tokens")
+		return
+	}
+	responses := make([]agentJoinTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		responses = append(responses, newAgentJoinTokenResponse(&tokens[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"joinTokens": responses})
+}
+
+// createAgentJoinToken issues a one-time token an agent uses to enroll for a
+// client certificate. The token is only returned in this response.
+func (h *handler) createAgentJoinToken(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	if h.agentCA == nil {
+		respondError(c, http.StatusServiceUnavailable, "agent_ca_unavailable", "agent certificate authority is not enabled")
+		return
+	}
+
+	var req createAgentJoinTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	agentID := strings.TrimSpace(req.AgentID)
+	if !validAgentID(agentID) {
+		respondError(c, http.StatusBadRequest, "invalid_agent_id", "agentId is required, at most 128 characters and without spaces or slashes")
+		return
+	}
+	hours := defaultAgentJoinTokenTTLHours
+	if req.ExpiresInHours != nil {
+		hours = *req.ExpiresInHours
+	}
+	if hours < 1 || hours > maxAgentJoinTokenTTLHours {
+		respondError(c, http.StatusBadRequest, "invalid_expiry", "expiresInHours must be between 1 and 168")
+		return
+	}
+
+	secret, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "create_join_token_failed", "failed to generate join token")
+		return
+	}
+	secret = store.AgentJoinTokenPrefix + secret
+
+	token := &store.AgentJoinToken{
+		AgentID:   agentID,
+		Groups:    normalizeAgentGroups(req.Groups),
+		TokenHash: hashAPIToken(secret),
+		CreatedBy: adminUser.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Duration(hours) * time.Hour),
+	}
+	if err := h.store.CreateAgentJoinToken(c.Request.Context(), token); err != nil {
+		respondError(c, http.StatusInternalServerError, "create_join_token_failed", "failed to create join token")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentJoinTokenCreate,
+		TargetType: auditTargetAgentJoinToken,
+		TargetID:   token.ID,
+		After:      map[string]any{"agentId": token.AgentID, "groups": token.Groups, "expiresAt": token.ExpiresAt},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"joinToken": newAgentJoinTokenResponse(token), "token": secret})
+}
+
+func (h *handler) deleteAgentJoinToken(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	tokenID := strings.TrimSpace(c.Param("tokenId"))
+	if err := h.store.DeleteAgentJoinToken(c.Request.Context(), tokenID); err != nil {
+		if errors.Is(err, store.ErrAgentJoinTokenNotFound) {
+			respondError(c, http.StatusNotFound, "join_token_not_found", "agent join token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_join_token_failed", "failed to delete join token")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentJoinTokenRevoke,
+		TargetType: auditTargetAgentJoinToken,
+		TargetID:   tokenID,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// revokeAgentCertificates rejects every certificate issued to an agent so
+// far, for example after one leaked. The agent needs a new join token to
+// enroll again.
+func (h *handler) revokeAgentCertificates(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	agentID := strings.TrimSpace(c.Param("agentId"))
+	if !validAgentID(agentID) {
+		respondError(c, http.StatusBadRequest, "invalid_agent_id", "agentId is required, at most 128 characters and without spaces or slashes")
+		return
+	}
+	revokedBefore := time.Now().UTC()
+	if err := h.store.RevokeAgentCertificates(c.Request.Context(), agentID, revokedBefore); err != nil {
+		respondError(c, http.StatusInternalServerError, "revoke_certificates_failed", "failed to revoke agent certificates")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentCertRevoke,
+		TargetType: auditTargetAgent,
+		TargetID:   agentID,
+		Metadata:   map[string]any{"revokedBefore": revokedBefore},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// validAgentID reports whether id can name an agent: it is also used in
+// admin URLs and certificate subjects.
+func validAgentID(id string) bool {
+	return id != "" && len(id) <= maxAgentIDLength && !strings.ContainsAny(id, " \t\r\n/")
+}
+
+// normalizeAgentGroups trims groups and drops empty and repeated ones.
+func normalizeAgentGroups(groups []string) []string {
+	normalized := make([]string, 0, len(groups))
+	seen := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		group = strings.TrimSpace(group)
+		if group == "" {
+			continue
+		}
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		seen[group] = struct{}{}
+		normalized = append(normalized, group)
+	}
+	return normalized
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/agentca"
+	"account/internal/agentproto"
+	"account/internal/agentserver"
+	"account/internal/store"
+)
+
+func TestAgentEnrollmentAndCertificateAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "")
+
+	caPEM, caKeyPEM, err := agentca.Generate("test agent ca")
+	if err != nil {
+		t.Fatalf("generate ca: %v", err)
+	}
+	ca, err := agentca.New(caPEM, caKeyPEM, 0)
+	if err != nil {
+		t.Fatalf("load ca: %v", err)
+	}
+	registry, err := agentserver.NewRegistry(agentserver.Config{})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithAgentRegistry(registry), WithAgentCA(ca))
+	env := &apiTokenTestEnv{t: t, st: st, router: router}
+	env.user("admin@example.com", store.RoleAdmin, store.LevelAdmin, "admin-session")
+	env.user("operator@example.com", store.RoleOperator, store.LevelOperator, "operator-session")
+
+	createBody := `{"agentId":"edge-1","groups":["hk"," hk ",""]}`
+	env.expect(env.do(http.MethodPost, "/api/admin/agents/join-tokens", "operator-session", createBody), http.StatusForbidden, "operator creates join token")
+	rr := env.do(http.MethodPost, "/api/admin/agents/join-tokens", "admin-session", createBody)
+	env.expect(rr, http.StatusCreated, "create join token")
+	var created struct {
+		JoinToken agentJoinTokenResponse `json:"joinToken"`
+		Token     string                 `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || !strings.HasPrefix(created.Token, store.AgentJoinTokenPrefix) {
+		t.Fatalf("decode join token: %v %s", err, rr.Body.String())
+	}
+	if len(created.JoinToken.Groups) != 1 || created.JoinToken.Groups[0] != "hk" {
+		t.Fatalf("expected normalized groups, got %v", created.JoinToken.Groups)
+	}
+
+	enroll := func(token, csr string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(agentproto.EnrollRequest{Token: token, CSR: csr})
+		return env.do(http.MethodPost, "/api/agent-server/v1/enroll", "", string(body))
+	}
+	env.expect(enroll(created.Token, "not a csr"), http.StatusBadRequest, "enroll with a broken CSR")
+
+	_, csrPEM, err := agentca.NewRequest("spoofed-name")
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	rr = enroll(created.Token, string(csrPEM))
+	env.expect(rr, http.StatusCreated, "enroll")
+	var issued agentproto.CertificateResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil {
+		t.Fatalf("decode certificate: %v", err)
+	}
+	if issued.AgentID != "edge-1" || issued.CACertificate != string(caPEM) {
+		t.Fatalf("unexpected enrollment response: %+v", issued)
+	}
+	env.expect(enroll(created.Token, string(csrPEM)), http.StatusUnauthorized, "reuse join token")
+
+	chains := verifiedChains(t, ca, issued.Certificate)
+	agentRequest := func(method, path, agentID, body string, chains [][]*x509.Certificate) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if agentID != "" {
+			req.Header.Set(agentIDHeader, agentID)
+		}
+		if chains != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: chains}
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := agentRequest(http.MethodGet, "/api/agent-server/v1/users", "", "", chains); code != http.StatusOK {
+		t.Fatalf("expected certificate to authenticate the agent, got %d", code)
+	}
+	if code := agentRequest(http.MethodGet, "/api/agent-server/v1/users", "edge-2", "", chains); code != http.StatusForbidden {
+		t.Fatalf("expected a certificate to act only for its agent, got %d", code)
+	}
+	if code := agentRequest(http.MethodGet, "/api/agent-server/v1/users", "", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected requests without credentials to be rejected, got %d", code)
+	}
+	enrolled := false
+	for _, agent := range registry.Agents() {
+		if agent.ID == "edge-1" && len(agent.Groups) == 1 && agent.Groups[0] == "hk" {
+			enrolled = true
+		}
+	}
+	if !enrolled {
+		t.Fatalf("expected enrolled agent in the registry, got %+v", registry.Agents())
+	}
+
+	_, renewCSR, err := agentca.NewRequest("edge-1")
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	renewBody, _ := json.Marshal(agentproto.RenewRequest{CSR: string(renewCSR)})
+	if code := agentRequest(http.MethodPost, "/api/agent-server/v1/certificate", "", string(renewBody), nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected renewal without a certificate to be rejected, got %d", code)
+	}
+	if code := agentRequest(http.MethodPost, "/api/agent-server/v1/certificate", "", string(renewBody), chains); code != http.StatusOK {
+		t.Fatalf("expected renewal with the current certificate, got %d", code)
+	}
+
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1/certificates", "operator-session", ""), http.StatusForbidden, "operator revokes certificates")
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1/certificates", "admin-session", ""), http.StatusNoContent, "revoke certificates")
+	if code := agentRequest(http.MethodGet, "/api/agent-server/v1/users", "", "", chains); code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked certificate to be rejected, got %d", code)
+	}
+	if code := agentRequest(http.MethodPost, "/api/agent-server/v1/certificate", "", string(renewBody), chains); code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked certificate not to renew, got %d", code)
+	}
+	rr = env.do(http.MethodPost, "/api/admin/agents/join-tokens", "admin-session", createBody)
+	env.expect(rr, http.StatusCreated, "create join token after revocation")
+	var rejoin struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &rejoin); err != nil {
+		t.Fatalf("decode join token: %v", err)
+	}
+	rr = enroll(rejoin.Token, string(csrPEM))
+	env.expect(rr, http.StatusCreated, "enroll after revocation")
+	var reissued agentproto.CertificateResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &reissued); err != nil {
+		t.Fatalf("decode certificate: %v", err)
+	}
+	if code := agentRequest(http.MethodGet, "/api/agent-server/v1/users", "", "", verifiedChains(t, ca, reissued.Certificate)); code != http.StatusOK {
+		t.Fatalf("expected a certificate issued after the revocation to authenticate, got %d", code)
+	}
+
+	rr = env.do(http.MethodGet, "/api/admin/agents/join-tokens", "operator-session", "")
+	env.expect(rr, http.StatusOK, "list join tokens")
+	var listed struct {
+		JoinTokens []agentJoinTokenResponse `json:"joinTokens"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil || len(listed.JoinTokens) != 2 || listed.JoinTokens[0].UsedAt == nil {
+		t.Fatalf("expected the used join token to be listed: %v %s", err, rr.Body.String())
+	}
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/join-tokens/"+created.JoinToken.ID, "admin-session", ""), http.StatusNoContent, "delete join token")
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/join-tokens/"+created.JoinToken.ID, "admin-session", ""), http.StatusNotFound, "delete join token twice")
+
+	for action, want := range map[string]int{
+		auditActionAgentJoinTokenCreate: 2,
+		auditActionAgentEnroll:          2,
+		auditActionAgentCertRenew:       1,
+		auditActionAgentCertRevoke:      1,
+		auditActionAgentJoinTokenRevoke: 1,
+	} {
+		events, err := st.ListAuditEvents(context.Background(), store.AuditEventFilter{ActionPrefix: action})
+		if err != nil || len(events) != want {
+			t.Fatalf("expected %d %s audit events, got %d (%v)", want, action, len(events), err)
+		}
+	}
+}
+
+// verifiedChains builds the chains a TLS listener trusting ca would report
+// for certPEM.
+func verifiedChains(t *testing.T, ca *agentca.Authority, certPEM string) [][]*x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatalf("certificate is not PEM encoded")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	chains, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Fatalf("verify certificate: %v", err)
+	}
+	return chains
+}
@@ -21,15 +21,8 @@ func (h *handler) listAgentUsers(c *gin.Context) {
 		return
 	}
 
-	token := extractToken(c.GetHeader("Authorization"))
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_token"})
-		return
-	}
-
-	credIdentity, ok := h.agentRegistry.Authenticate(token)
-	if !ok || credIdentity == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+	credIdentity, ok := h.authenticateAgent(c)
+	if !ok {
 		return
 	}
 
@@ -37,14 +30,8 @@ func (h *handler) listAgentUsers(c *gin.Context) {
 	if agentID == "" {
 		agentID = strings.TrimSpace(c.Query("agentId"))
 	}
-	if agentID == "" {
-		agentID = credIdentity.ID
-	}
-
-	identity := *credIdentity
-	if agentID != "" && agentID != identity.ID {
-		// Shared token scenario: register a concrete agent id so sandbox bindings can target it.
-		identity = h.agentRegistry.RegisterAgent(agentID, identity.Groups)
+	if _, ok := h.resolveAgent(c, credIdentity, agentID); !ok {
+		return
 	}
 
 	now := time.Now().UTC()
@@ -56,6 +43,16 @@ func (h *handler) listAgentUsers(c *gin.Context) {
 		return
 	}
 
+	quotaStates, err := h.store.ListAccountQuotaStates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "list_quota_states_failed"})
+		return
+	}
+	quotaByAccount := make(map[string]store.AccountQuotaState, len(quotaStates))
+	for _, state := range quotaStates {
+		quotaByAccount[state.AccountUUID] = state
+	}
+
 	for _, u := range users {
 		if !u.Active {
 			continue
@@ -90,10 +87,17 @@ func (h *handler) listAgentUsers(c *gin.Context) {
 		if id == "" {
 			continue
 		}
+		// Suspended accounts are dropped from the data plane; throttled ones
+		// stay connected on the throttled policy level.
+		quota, tracked := quotaByAccount[u.ID]
+		if tracked && quota.SuspendState == store.SuspendStateSuspended {
+			continue
+		}
 		clients = append(clients, xrayconfig.Client{
-			ID:    id,
-			Email: strings.ToLower(strings.TrimSpace(u.Email)),
-			Flow:  xrayconfig.DefaultFlow,
+			ID:        id,
+			Email:     strings.ToLower(strings.TrimSpace(u.Email)),
+			Flow:      xrayconfig.DefaultFlow,
+			Throttled: tracked && quota.ThrottleState == store.ThrottleStateThrottled,
 		})
 	}
 
@@ -110,15 +114,8 @@ func (h *handler) reportAgentStatus(c *gin.Context) {
 		return
 	}
 
-	token := extractToken(c.GetHeader("Authorization"))
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing_token"})
-		return
-	}
-
-	credIdentity, ok := h.agentRegistry.Authenticate(token)
-	if !ok || credIdentity == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+	credIdentity, ok := h.authenticateAgent(c)
+	if !ok {
 		return
 	}
 
@@ -132,13 +129,9 @@ func (h *handler) reportAgentStatus(c *gin.Context) {
 	if agentID == "" {
 		agentID = strings.TrimSpace(c.GetHeader(agentIDHeader))
 	}
-	if agentID == "" {
-		agentID = credIdentity.ID
-	}
-
-	identity := *credIdentity
-	if agentID != "" && agentID != identity.ID {
-		identity = h.agentRegistry.RegisterAgent(agentID, identity.Groups)
+	identity, ok := h.resolveAgent(c, credIdentity, agentID)
+	if !ok {
+		return
 	}
 
 	// Ensure report uses the resolved agent id.
@@ -164,4 +157,39 @@ func (h *handler) reportAgentStatus(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+func (h *handler) reportAgentTraffic(c *gin.Context) {
+	if h.agentRegistry == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "agent_registry_unavailable"})
+		return
+	}
+
+	credIdentity, ok := h.authenticateAgent(c)
+	if !ok {
+		return
+	}
+
+	var req nodeTrafficStatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "message": err.Error()})
+		return
+	}
+
+	identity, ok := h.resolveAgent(c, credIdentity, strings.TrimSpace(c.GetHeader(agentIDHeader)))
+	if !ok {
+		return
+	}
+	// A bound credential speaks for its own agent only; shared tokens may
+	// report for the node they name.
+	nodeID := strings.TrimSpace(req.NodeID)
+	if identity.Bound && nodeID != "" && nodeID != identity.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "agent_id_mismatch"})
+		return
+	}
+	if identity.Bound || nodeID == "" {
+		req.NodeID = identity.ID
+	}
+
+	h.ingestNodeTraffic(c, req)
+}
+
 var _ = agentserver.Identity{}
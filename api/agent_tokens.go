@@ -0,0 +1,368 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+const (
+	defaultAgentTokenGraceMinutes = 60
+	maxAgentTokenGraceMinutes     = 7 * 24 * 60
+)
+
+type managedAgentResponse struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Groups        []string   `json:"groups"`
+	Healthy       bool       `json:"healthy"`
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+	ClientsCount  int        `json:"clientsCount"`
+	SyncRevision  string     `json:"syncRevision,omitempty"`
+	// CertificateExpiresAt is set for agents enrolled through the agent CA.
+	CertificateExpiresAt *time.Time `json:"certificateExpiresAt,omitempty"`
+	Managed              bool       `json:"managed"`
+	ActiveTokens         int        `json:"activeTokens"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+}
+
+// newManagedAgentResponse describes agent. An agent is managed when it holds
+// tokens issued through the admin API, expired ones included.
+func newManagedAgentResponse(agent *store.Agent, tokens []store.AgentToken, now time.Time) managedAgentResponse {
+	groups := agent.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	resp := managedAgentResponse{
+		ID:                   agent.ID,
+		Name:                 agent.Name,
+		Groups:               groups,
+		Healthy:              agent.Healthy,
+		LastHeartbeat:        agent.LastHeartbeat,
+		ClientsCount:         agent.ClientsCount,
+		SyncRevision:         agent.SyncRevision,
+		CertificateExpiresAt: agent.CertificateExpiresAt,
+		CreatedAt:            agent.CreatedAt,
+		UpdatedAt:            agent.UpdatedAt,
+	}
+	for i := range tokens {
+		if tokens[i].AgentID != agent.ID {
+			continue
+		}
+		resp.Managed = true
+		if tokens[i].ActiveAt(now) {
+			resp.ActiveTokens++
+		}
+	}
+	return resp
+}
+
+type agentTokenResponse struct {
+	ID        string     `json:"id"`
+	AgentID   string     `json:"agentId"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Active    bool       `json:"active"`
+}
+
+func newAgentTokenResponse(token *store.AgentToken, now time.Time) agentTokenResponse {
+	return agentTokenResponse{
+		ID:        token.ID,
+		AgentID:   token.AgentID,
+		CreatedBy: token.CreatedBy,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		Active:    token.ActiveAt(now),
+	}
+}
+
+type createManagedAgentRequest struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+}
+
+type rotateAgentTokenRequest struct {
+	GraceMinutes *int `json:"graceMinutes"`
+}
+
+func (h *handler) listManagedAgents(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminAgentsStatus); !ok {
+		return
+	}
+	agents, err := h.store.ListAgents(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_agents_failed", "failed to list agents")
+		return
+	}
+	tokens, err := h.store.ListAgentTokens(c.Request.Context(), "")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_agents_failed", "failed to list agent tokens")
+		return
+	}
+	now := time.Now().UTC()
+	responses := make([]managedAgentResponse, 0, len(agents))
+	for _, agent := range agents {
+		responses = append(responses, newManagedAgentResponse(agent, tokens, now))
+	}
+	c.JSON(http.StatusOK, gin.H{"agents": responses})
+}
+
+func (h *handler) getManagedAgent(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminAgentsStatus); !ok {
+		return
+	}
+	agent, ok := h.loadManagedAgent(c)
+	if !ok {
+		return
+	}
+	tokens, err := h.store.ListAgentTokens(c.Request.Context(), agent.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "get_agent_failed", "failed to list agent tokens")
+		return
+	}
+	now := time.Now().UTC()
+	tokenResponses := make([]agentTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		tokenResponses = append(tokenResponses, newAgentTokenResponse(&tokens[i], now))
+	}
+	c.JSON(http.StatusOK, gin.H{"agent": newManagedAgentResponse(agent, tokens, now), "tokens": tokenResponses})
+}
+
+// createManagedAgent registers an agent and issues its first token. The
+// token is only returned in this response.
+func (h *handler) createManagedAgent(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	if h.agentRegistry == nil {
+		respondError(c, http.StatusServiceUnavailable, "agent_registry_unavailable", "agent registry is not configured")
+		return
+	}
+
+	var req createManagedAgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	agentID := strings.TrimSpace(req.ID)
+	if !validAgentID(agentID) {
+		respondError(c, http.StatusBadRequest, "invalid_agent_id", "id is required, at most 128 characters and without spaces or slashes")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = agentID
+	}
+
+	agent := &store.Agent{ID: agentID, Name: name, Groups: normalizeAgentGroups(req.Groups)}
+	if err := h.store.CreateAgent(c.Request.Context(), agent); err != nil {
+		if errors.Is(err, store.ErrAgentExists) {
+			respondError(c, http.StatusConflict, "agent_exists", "an agent with this id already exists")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "create_agent_failed", "failed to create agent")
+		return
+	}
+	token, secret, ok := h.issueAgentToken(c, adminUser, agentID)
+	if !ok {
+		return
+	}
+	h.reloadAgentRegistry(c)
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentCreate,
+		TargetType: auditTargetAgent,
+		TargetID:   agentID,
+		After:      map[string]any{"name": agent.Name, "groups": agent.Groups},
+		Metadata:   map[string]any{"tokenId": token.ID},
+	})
+
+	now := time.Now().UTC()
+	c.JSON(http.StatusCreated, gin.H{
+		"agent":      newManagedAgentResponse(agent, []store.AgentToken{*token}, now),
+		"agentToken": newAgentTokenResponse(token, now),
+		"token":      secret,
+	})
+}
+
+// deleteManagedAgent removes an agent and its tokens, revokes its
+// certificates and drops its unused join tokens. Only an agent that runs
+// with a shared configured credential reappears on its next report.
+func (h *handler) deleteManagedAgent(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	agent, ok := h.loadManagedAgent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	// Revoke first: if the delete fails the agent is locked out rather than
+	// left half-removed with working certificates.
+	if err := h.store.RevokeAgentCertificates(ctx, agent.ID, time.Now().UTC()); err != nil {
+		respondError(c, http.StatusInternalServerError, "delete_agent_failed", "failed to revoke agent certificates")
+		return
+	}
+	joinTokens, err := h.store.ListAgentJoinTokens(ctx)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "delete_agent_failed", "failed to list agent join tokens")
+		return
+	}
+	for _, token := range joinTokens {
+		if token.AgentID != agent.ID || token.UsedAt != nil {
+			continue
+		}
+		if err := h.store.DeleteAgentJoinToken(ctx, token.ID); err != nil && !errors.Is(err, store.ErrAgentJoinTokenNotFound) {
+			respondError(c, http.StatusInternalServerError, "delete_agent_failed", "failed to delete agent join tokens")
+			return
+		}
+	}
+	if err := h.store.DeleteAgent(ctx, agent.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, "delete_agent_failed", "failed to delete agent")
+		return
+	}
+	if h.agentRegistry != nil {
+		h.agentRegistry.RemoveAgent(agent.ID)
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentDelete,
+		TargetType: auditTargetAgent,
+		TargetID:   agent.ID,
+		Before:     map[string]any{"name": agent.Name, "groups": agent.Groups},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// rotateAgentToken issues a new token for an agent. Its current tokens keep
+// working for graceMinutes so the agent can be reconfigured; 0 cuts them off
+// immediately.
+func (h *handler) rotateAgentToken(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	if h.agentRegistry == nil {
+		respondError(c, http.StatusServiceUnavailable, "agent_registry_unavailable", "agent registry is not configured")
+		return
+	}
+	agent, ok := h.loadManagedAgent(c)
+	if !ok {
+		return
+	}
+
+	var req rotateAgentTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+			return
+		}
+	}
+	grace := defaultAgentTokenGraceMinutes
+	if req.GraceMinutes != nil {
+		grace = *req.GraceMinutes
+	}
+	if grace < 0 || grace > maxAgentTokenGraceMinutes {
+		respondError(c, http.StatusBadRequest, "invalid_grace", "graceMinutes must be between 0 and 10080")
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(time.Duration(grace) * time.Minute)
+	if err := h.store.ExpireAgentTokens(c.Request.Context(), agent.ID, expiresAt); err != nil {
+		respondError(c, http.StatusInternalServerError, "rotate_agent_token_failed", "failed to expire agent tokens")
+		return
+	}
+	token, secret, ok := h.issueAgentToken(c, adminUser, agent.ID)
+	if !ok {
+		return
+	}
+	h.reloadAgentRegistry(c)
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentTokenRotate,
+		TargetType: auditTargetAgentToken,
+		TargetID:   token.ID,
+		Metadata:   map[string]any{"agentId": agent.ID, "previousTokensExpireAt": expiresAt},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"agentToken": newAgentTokenResponse(token, time.Now().UTC()), "token": secret})
+}
+
+func (h *handler) revokeAgentToken(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminAgentsWrite)
+	if !ok {
+		return
+	}
+	agentID := strings.TrimSpace(c.Param("agentId"))
+	tokenID := strings.TrimSpace(c.Param("tokenId"))
+	if err := h.store.DeleteAgentToken(c.Request.Context(), agentID, tokenID); err != nil {
+		if errors.Is(err, store.ErrAgentTokenNotFound) {
+			respondError(c, http.StatusNotFound, "agent_token_not_found", "agent token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "revoke_agent_token_failed", "failed to revoke agent token")
+		return
+	}
+	h.reloadAgentRegistry(c)
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAgentTokenRevoke,
+		TargetType: auditTargetAgentToken,
+		TargetID:   tokenID,
+		Metadata:   map[string]any{"agentId": agentID},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) loadManagedAgent(c *gin.Context) (*store.Agent, bool) {
+	agent, err := h.store.GetAgent(c.Request.Context(), strings.TrimSpace(c.Param("agentId")))
+	if err != nil {
+		if errors.Is(err, store.ErrAgentNotFound) {
+			respondError(c, http.StatusNotFound, "agent_not_found", "agent not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "get_agent_failed", "failed to load agent")
+		return nil, false
+	}
+	return agent, true
+}
+
+// issueAgentToken stores a new token for agentID and returns it with its
+// secret.
+func (h *handler) issueAgentToken(c *gin.Context, adminUser *store.User, agentID string) (*store.AgentToken, string, bool) {
+	secret, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "create_agent_token_failed", "failed to generate agent token")
+		return nil, "", false
+	}
+	secret = store.AgentTokenPrefix + secret
+
+	token := &store.AgentToken{
+		AgentID:   agentID,
+		TokenHash: hashAPIToken(secret),
+		CreatedBy: adminUser.ID,
+	}
+	if err := h.store.CreateAgentToken(c.Request.Context(), token); err != nil {
+		respondError(c, http.StatusInternalServerError, "create_agent_token_failed", "failed to create agent token")
+		return nil, "", false
+	}
+	return token, secret, true
+}
+
+// reloadAgentRegistry applies token changes to this instance right away.
+// Other instances pick them up on their periodic reload.
+func (h *handler) reloadAgentRegistry(c *gin.Context) {
+	if h.agentRegistry == nil {
+		return
+	}
+	if err := h.agentRegistry.Load(c.Request.Context()); err != nil {
+		slog.Warn("failed to reload agent registry", "err", err)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/agentca"
+	"account/internal/agentproto"
+	"account/internal/agentserver"
+	"account/internal/store"
+)
+
+func TestManagedAgentLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "")
+
+	registry, err := agentserver.NewRegistry(agentserver.Config{})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	st := store.NewMemoryStore()
+	registry.SetStore(st)
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithAgentRegistry(registry))
+	env := &apiTokenTestEnv{t: t, st: st, router: router}
+	env.user("admin@example.com", store.RoleAdmin, store.LevelAdmin, "admin-session")
+	env.user("operator@example.com", store.RoleOperator, store.LevelOperator, "operator-session")
+
+	createBody := `{"id":"edge-1","name":"Edge 1","groups":["hk"," hk "]}`
+	env.expect(env.do(http.MethodPost, "/api/admin/agents", "operator-session", createBody), http.StatusForbidden, "operator creates agent")
+	env.expect(env.do(http.MethodPost, "/api/admin/agents", "admin-session", `{"id":"edge/1"}`), http.StatusBadRequest, "create agent with a slash")
+	rr := env.do(http.MethodPost, "/api/admin/agents", "admin-session", createBody)
+	env.expect(rr, http.StatusCreated, "create agent")
+	var created struct {
+		Agent      managedAgentResponse `json:"agent"`
+		AgentToken agentTokenResponse   `json:"agentToken"`
+		Token      string               `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || !strings.HasPrefix(created.Token, store.AgentTokenPrefix) {
+		t.Fatalf("decode agent: %v %s", err, rr.Body.String())
+	}
+	if !created.Agent.Managed || created.Agent.ActiveTokens != 1 || len(created.Agent.Groups) != 1 {
+		t.Fatalf("unexpected agent %+v", created.Agent)
+	}
+	env.expect(env.do(http.MethodPost, "/api/admin/agents", "admin-session", createBody), http.StatusConflict, "create agent twice")
+
+	env.expect(env.do(http.MethodGet, "/api/agent-server/v1/users", created.Token, ""), http.StatusOK, "agent token authenticates")
+	env.expect(env.do(http.MethodGet, "/api/agent-server/v1/users?agentId=edge-2", created.Token, ""), http.StatusForbidden, "agent token acts for another agent")
+	env.expect(env.do(http.MethodPost, "/api/agent-server/v1/traffic", created.Token, `{"nodeId":"edge-2","stats":[]}`), http.StatusForbidden, "agent token reports traffic for another node")
+	env.expect(env.do(http.MethodPost, "/api/agent-server/v1/traffic", created.Token, `{"nodeId":"edge-1","stats":[]}`), http.StatusOK, "agent token reports its own traffic")
+
+	// A fresh registry, as after a restart, restores the token from the store.
+	restarted, err := agentserver.NewRegistry(agentserver.Config{})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	restarted.SetStore(st)
+	if err := restarted.Load(context.Background()); err != nil {
+		t.Fatalf("load registry: %v", err)
+	}
+	if identity, ok := restarted.Authenticate(created.Token); !ok || identity.ID != "edge-1" || !identity.Bound || identity.Name != "Edge 1" {
+		t.Fatalf("expected the restarted registry to accept the token, got %+v %v", identity, ok)
+	}
+
+	rr = env.do(http.MethodPost, "/api/admin/agents/edge-1/tokens", "admin-session", `{"graceMinutes":0}`)
+	env.expect(rr, http.StatusCreated, "rotate token")
+	var rotated struct {
+		AgentToken agentTokenResponse `json:"agentToken"`
+		Token      string             `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &rotated); err != nil || rotated.Token == created.Token {
+		t.Fatalf("decode rotated token: %v %s", err, rr.Body.String())
+	}
+	env.expect(env.do(http.MethodGet, "/api/agent-server/v1/users", created.Token, ""), http.StatusUnauthorized, "rotated-out token")
+	env.expect(env.do(http.MethodGet, "/api/agent-server/v1/users", rotated.Token, ""), http.StatusOK, "rotated token")
+	env.expect(env.do(http.MethodPost, "/api/admin/agents/edge-1/tokens", "admin-session", `{"graceMinutes":-1}`), http.StatusBadRequest, "negative grace")
+
+	rr = env.do(http.MethodGet, "/api/admin/agents", "operator-session", "")
+	env.expect(rr, http.StatusOK, "list agents")
+	var listed struct {
+		Agents []managedAgentResponse `json:"agents"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil || len(listed.Agents) != 1 || listed.Agents[0].ActiveTokens != 1 {
+		t.Fatalf("expected one agent with one active token: %v %s", err, rr.Body.String())
+	}
+	rr = env.do(http.MethodGet, "/api/admin/agents/edge-1", "operator-session", "")
+	env.expect(rr, http.StatusOK, "get agent")
+	var detail struct {
+		Tokens []agentTokenResponse `json:"tokens"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil || len(detail.Tokens) != 2 || detail.Tokens[0].Active || !detail.Tokens[1].Active {
+		t.Fatalf("expected the expired and the new token: %v %s", err, rr.Body.String())
+	}
+
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1/tokens/"+rotated.AgentToken.ID, "admin-session", ""), http.StatusNoContent, "revoke token")
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1/tokens/"+rotated.AgentToken.ID, "admin-session", ""), http.StatusNotFound, "revoke token twice")
+	env.expect(env.do(http.MethodGet, "/api/agent-server/v1/users", rotated.Token, ""), http.StatusUnauthorized, "revoked token")
+
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1", "admin-session", ""), http.StatusNoContent, "delete agent")
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1", "admin-session", ""), http.StatusNotFound, "delete agent twice")
+	for _, agent := range registry.Agents() {
+		if agent.ID == "edge-1" {
+			t.Fatalf("expected the deleted agent to leave the registry")
+		}
+	}
+
+	for _, action := range []string{auditActionAgentCreate, auditActionAgentTokenRotate, auditActionAgentTokenRevoke, auditActionAgentDelete} {
+		events, err := st.ListAuditEvents(context.Background(), store.AuditEventFilter{ActionPrefix: action})
+		if err != nil || len(events) != 1 {
+			t.Fatalf("expected one %s audit event, got %d (%v)", action, len(events), err)
+		}
+	}
+}
+
+func TestDeleteAgentRevokesCertificates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "")
+
+	caPEM, caKeyPEM, err := agentca.Generate("test agent ca")
+	if err != nil {
+		t.Fatalf("generate ca: %v", err)
+	}
+	ca, err := agentca.New(caPEM, caKeyPEM, 0)
+	if err != nil {
+		t.Fatalf("load ca: %v", err)
+	}
+	registry, err := agentserver.NewRegistry(agentserver.Config{})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	st := store.NewMemoryStore()
+	registry.SetStore(st)
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithAgentRegistry(registry), WithAgentCA(ca))
+	env := &apiTokenTestEnv{t: t, st: st, router: router}
+	env.user("admin@example.com", store.RoleAdmin, store.LevelAdmin, "admin-session")
+
+	joinToken := func() string {
+		rr := env.do(http.MethodPost, "/api/admin/agents/join-tokens", "admin-session", `{"agentId":"edge-1"}`)
+		env.expect(rr, http.StatusCreated, "create join token")
+		var created struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("decode join token: %v", err)
+		}
+		return created.Token
+	}
+	_, csrPEM, err := agentca.NewRequest("edge-1")
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	body, _ := json.Marshal(agentproto.EnrollRequest{Token: joinToken(), CSR: string(csrPEM)})
+	rr := env.do(http.MethodPost, "/api/agent-server/v1/enroll", "", string(body))
+	env.expect(rr, http.StatusCreated, "enroll")
+	var issued agentproto.CertificateResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil {
+		t.Fatalf("decode certificate: %v", err)
+	}
+	chains := verifiedChains(t, ca, issued.Certificate)
+	certRequest := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/agent-server/v1/users", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: chains}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := certRequest(); code != http.StatusOK {
+		t.Fatalf("expected the enrolled certificate to authenticate, got %d", code)
+	}
+
+	unused := joinToken()
+	env.expect(env.do(http.MethodDelete, "/api/admin/agents/edge-1", "admin-session", ""), http.StatusNoContent, "delete agent")
+	if code := certRequest(); code != http.StatusUnauthorized {
+		t.Fatalf("expected the deleted agent's certificate to be rejected, got %d", code)
+	}
+	if _, err := st.GetAgent(context.Background(), "edge-1"); !errors.Is(err, store.ErrAgentNotFound) {
+		t.Fatalf("expected the deleted agent to stay deleted, got %v", err)
+	}
+	body, _ = json.Marshal(agentproto.EnrollRequest{Token: unused, CSR: string(csrPEM)})
+	env.expect(env.do(http.MethodPost, "/api/agent-server/v1/enroll", "", string(body)), http.StatusUnauthorized, "enroll with a join token issued before the delete")
+}
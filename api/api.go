@@ -6,30 +6,39 @@ import (
 	"crypto/sha1"
 	"encoding/base32"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"log/slog"
 	"math/big"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"account/internal/agentca"
 	"account/internal/agentproto"
 	"account/internal/agentserver"
 	"account/internal/auth"
+	"account/internal/cache"
+	"account/internal/ratelimit"
+	"account/internal/rbac"
 	"account/internal/service"
 	"account/internal/store"
+	"account/internal/webhook"
 )
 
 const defaultSessionTTL = 24 * time.Hour
@@ -62,37 +71,38 @@ type oauthExchangeCode struct {
 }
 
 type handler struct {
-	store                     store.Store
-	mu                        sync.RWMutex
-	sessionTTL                time.Duration
-	mfaChallenges             map[string]mfaChallenge
-	mfaMu                     sync.RWMutex
-	mfaChallengeTTL           time.Duration
-	totpIssuer                string
-	emailSender               EmailSender
-	emailVerificationEnabled  bool
-	verificationTTL           time.Duration
-	verifications             map[string]emailVerification
-	verificationMu            sync.RWMutex
-	registrationVerifications map[string]registrationVerification
-	registrationMu            sync.RWMutex
-	resetTTL                  time.Duration
-	passwordResets            map[string]passwordReset
-	resetMu                   sync.RWMutex
-	oauthExchangeCodes        map[string]oauthExchangeCode
-	oauthExchangeMu           sync.RWMutex
-	oauthExchangeTTL          time.Duration
-	metricsProvider           service.UserMetricsProvider
-	agentStatusReader         agentStatusReader
-	tokenService              *auth.TokenService
-	oauthProviders            map[string]auth.OAuthProvider
-	oauthFrontendURL          string
-	publicURL                 string
-	xworkmateVaultService     xworkmateVaultService
-	xrayConfigRenderer        func(*store.User) (string, string, []string, error)
-	agentRegistry             agentRegistry
-	db                        *gorm.DB
-	stripe                    *stripeClient
+	store                    store.Store
+	mu                       sync.RWMutex
+	sessionTTL               time.Duration
+	authState                cache.StateStore
+	limiter                  *ratelimit.Limiter
+	policy                   *rbac.Engine
+	rateLimits               RateLimitConfig
+	sessionCache             cache.Cache
+	mfaChallengeTTL          time.Duration
+	totpIssuer               string
+	emailSender              EmailSender
+	emailVerificationEnabled bool
+	verificationTTL          time.Duration
+	resetTTL                 time.Duration
+	oauthExchangeTTL         time.Duration
+	metricsProvider          service.UserMetricsProvider
+	agentStatusReader        agentStatusReader
+	tokenService             *auth.TokenService
+	oidc                     *oidcProvider
+	webauthn                 *webauthn.WebAuthn
+	oauthProviders           map[string]auth.OAuthProvider
+	oauthFrontendURL         string
+	publicURL                string
+	xworkmateVaultService    xworkmateVaultService
+	xrayConfigRenderer       func(*store.User) (string, string, []string, error)
+	agentRegistry            agentRegistry
+	db                       *gorm.DB
+	stripe                   *stripeClient
+	lookupTXT                func(ctx context.Context, name string) ([]string, error)
+	internalCallers          *auth.InternalCallers
+	agentCA                  *agentca.Authority
+	webhookNetworks          []netip.Prefix
 }
 
 type agentRegistry interface {
@@ -103,6 +113,9 @@ type agentRegistry interface {
 	Authenticate(token string) (*agentserver.Identity, bool)
 	RegisterAgent(agentID string, groups []string) agentserver.Identity
 	ReportStatus(agent agentserver.Identity, report agentproto.StatusReport)
+
+	Load(ctx context.Context) error
+	RemoveAgent(agentID string)
 }
 
 type mfaChallenge struct {
@@ -114,6 +127,8 @@ type mfaChallenge struct {
 	totpIssuedAt   time.Time
 	failedAttempts int
 	lockedUntil    time.Time
+	// webauthnSession is set once a passkey assertion has been requested.
+	webauthnSession *webauthn.SessionData
 }
 
 type emailVerification struct {
@@ -157,6 +172,24 @@ func WithSessionTTL(ttl time.Duration) Option {
 	}
 }
 
+// WithAuthStateStore configures where MFA challenges, verification codes,
+// password reset tokens and OAuth exchange codes are kept. A shared backend is
+// required when several replicas serve the same users.
+func WithAuthStateStore(states cache.StateStore) Option {
+	return func(h *handler) {
+		if states != nil {
+			h.authState = states
+		}
+	}
+}
+
+// WithSessionCache places c in front of the store for session token lookups.
+func WithSessionCache(c cache.Cache) Option {
+	return func(h *handler) {
+		h.sessionCache = c
+	}
+}
+
 // WithEmailSender configures the handler to use the provided EmailSender for outbound notifications.
 func WithEmailSender(sender EmailSender) Option {
 	return func(h *handler) {
@@ -294,37 +327,79 @@ func WithStripeConfig(cfg StripeConfig) Option {
 	}
 }
 
+// WithInternalCallers configures the services allowed to call the internal
+// API. Without it only the legacy INTERNAL_SERVICE_TOKEN caller is accepted.
+func WithInternalCallers(callers *auth.InternalCallers) Option {
+	return func(h *handler) {
+		h.internalCallers = callers
+	}
+}
+
+// WithAgentCA enables certificate enrollment for agents. Agents presenting a
+// client certificate issued by ca are authenticated by its subject on the
+// agent-server routes.
+func WithAgentCA(ca *agentca.Authority) Option {
+	return func(h *handler) {
+		h.agentCA = ca
+	}
+}
+
 // RegisterRoutes attaches account service endpoints to the router.
 func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	h := &handler{
-		store:                     store.NewMemoryStore(),
-		sessionTTL:                defaultSessionTTL,
-		mfaChallenges:             make(map[string]mfaChallenge),
-		mfaChallengeTTL:           defaultMFAChallengeTTL,
-		totpIssuer:                defaultTOTPIssuer,
-		emailSender:               noopEmailSender,
-		emailVerificationEnabled:  true,
-		verificationTTL:           defaultEmailVerificationTTL,
-		verifications:             make(map[string]emailVerification),
-		registrationVerifications: make(map[string]registrationVerification),
-		resetTTL:                  defaultPasswordResetTTL,
-		passwordResets:            make(map[string]passwordReset),
-		oauthExchangeCodes:        make(map[string]oauthExchangeCode),
-		oauthExchangeTTL:          defaultOAuthExchangeCodeTTL,
+		store:                    store.NewMemoryStore(),
+		sessionTTL:               defaultSessionTTL,
+		authState:                cache.NewMemoryStateStore(),
+		mfaChallengeTTL:          defaultMFAChallengeTTL,
+		totpIssuer:               defaultTOTPIssuer,
+		emailSender:              noopEmailSender,
+		emailVerificationEnabled: true,
+		verificationTTL:          defaultEmailVerificationTTL,
+		resetTTL:                 defaultPasswordResetTTL,
+		oauthExchangeTTL:         defaultOAuthExchangeCodeTTL,
+		rateLimits:               DefaultRateLimits(),
 	}
 
 	for _, opt := range opts {
 		opt(h)
 	}
+	h.limiter = ratelimit.New(h.authState)
+	if h.internalCallers == nil {
+		h.internalCallers = auth.InternalCallersFromEnv()
+	}
+	if h.agentCA != nil {
+		// Agent certificates name agents, never internal callers.
+		h.internalCallers.IgnoreIssuer(h.agentCA.Certificate())
+	}
+	h.policy = rbac.New(rbac.Config{
+		Store:       h.store,
+		Matrix:      adminSettingsMatrix,
+		Permissions: adminPermissions,
+		Reserved:    rootPermissions,
+		Roles:       builtinRoles(),
+	})
 
 	if h.tokenService != nil && h.store != nil {
 		h.tokenService.SetStore(h.store)
+		h.tokenService.SetAPITokenAuthenticator(h.authenticateAPIToken)
+		if h.sessionCache != nil {
+			h.tokenService.SetSessionCache(h.sessionCache)
+		}
 	}
 
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	r.GET(oidcJWKSPath, h.jwks)
+	r.GET(oidcDiscoveryPath, h.oidcDiscovery)
+
+	// OpenID Connect provider endpoints.
+	r.GET(oidcAuthorizePath, h.oidcAuthorize)
+	r.POST(oidcTokenPath, h.oidcToken)
+	r.GET(oidcUserInfoPath, h.oidcUserInfoEndpoint)
+	r.POST(oidcUserInfoPath, h.oidcUserInfoEndpoint)
+
 	r.GET("/api/ping", func(c *gin.Context) {
 		info := parseImageVersionInfo(os.Getenv("IMAGE"))
 		c.JSON(http.StatusOK, gin.H{
@@ -344,6 +419,9 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 
 	authGroup.POST("/login", h.login)
 	authGroup.POST("/mfa/verify", h.verifyMFALogin)
+	authGroup.POST("/mfa/webauthn/assertion", h.beginWebAuthnAssertion)
+	authGroup.POST("/passkey/login/begin", h.beginPasskeyLogin)
+	authGroup.POST("/passkey/login/finish", h.finishPasskeyLogin)
 
 	// Token exchange endpoint - converts one-time OAuth exchange code to a real session token.
 	authGroup.POST("/token/exchange", h.exchangeToken)
@@ -375,6 +453,17 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 
 	authProtected.GET("/session", h.session)
 	authProtected.DELETE("/session", h.deleteSession)
+	authProtected.GET("/sessions", h.listSessions)
+	authProtected.DELETE("/sessions", h.revokeAllSessions)
+	authProtected.DELETE("/sessions/:id", h.revokeSession)
+	authProtected.GET("/api-tokens", h.listAPITokens)
+	authProtected.POST("/api-tokens", h.createAPIToken)
+	authProtected.DELETE("/api-tokens/:tokenId", h.deleteAPIToken)
+	authProtected.GET("/security/activity", h.listSecurityActivity)
+	authProtected.GET("/identities", h.listIdentities)
+	authProtected.POST("/identities/:provider/link", h.startIdentityLink)
+	authProtected.DELETE("/identities/:id", h.unlinkIdentity)
+	authProtected.POST("/tenant-invitations/accept", h.acceptTenantInvitation)
 	authProtected.GET("/xworkmate/profile", h.getXWorkmateProfile)
 	authProtected.GET("/xworkmate/profile/sync", h.getXWorkmateProfileSync)
 	authProtected.PUT("/xworkmate/profile", h.updateXWorkmateProfile)
@@ -385,6 +474,10 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	authProtected.POST("/mfa/totp/provision", h.provisionTOTP)
 	authProtected.POST("/mfa/totp/verify", h.verifyTOTP)
 	authProtected.POST("/mfa/disable", h.disableMFA)
+	authProtected.POST("/mfa/recovery-codes", h.regenerateMFARecoveryCodes)
+	authProtected.POST("/mfa/webauthn/register/begin", h.beginWebAuthnRegistration)
+	authProtected.POST("/mfa/webauthn/register/finish", h.finishWebAuthnRegistration)
+	authProtected.DELETE("/mfa/webauthn/credentials/:id", h.deleteWebAuthnCredential)
 
 	authProtected.POST("/password/reset", h.requestPasswordReset)
 	authProtected.POST("/password/reset/confirm", h.confirmPasswordReset)
@@ -411,10 +504,14 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	authProtected.POST("/admin/users/:userId/resume", h.resumeUser)
 	authProtected.DELETE("/admin/users/:userId", h.deleteUser)
 	authProtected.POST("/admin/users/:userId/renew-uuid", h.renewProxyUUID)
+	authProtected.GET("/admin/users/:userId/sessions", h.adminListUserSessions)
+	authProtected.DELETE("/admin/users/:userId/sessions", h.adminRevokeUserSessions)
+	authProtected.DELETE("/admin/users/:userId/sessions/:sessionId", h.adminRevokeUserSession)
 	authProtected.POST("/admin/tenants/bootstrap", h.bootstrapTenant)
 	authProtected.GET("/admin/blacklist", h.listBlacklist)
 	authProtected.POST("/admin/blacklist", h.addToBlacklist)
 	authProtected.DELETE("/admin/blacklist/:email", h.removeFromBlacklist)
+	authProtected.GET("/admin/audit", h.listAuditEvents)
 
 	// Sandbox node binding (root-only via permissions guard).
 	authProtected.GET("/admin/sandbox/binding", h.getSandboxBinding)
@@ -431,17 +528,21 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	internalGroup := r.Group("/api/internal")
 
 	r.POST("/api/billing/stripe/webhook", h.stripeWebhook)
-	internalGroup.Use(auth.InternalAuthMiddleware())
+	internalGroup.Use(auth.InternalAuthMiddleware(h.internalCallers))
 	internalGroup.GET("/public-overview", h.internalPublicOverview)
 	internalGroup.GET("/sandbox/guest", h.internalSandboxGuest)
 	internalGroup.GET("/network/identities", h.internalNetworkIdentities)
 	internalGroup.GET("/policy/:accountUUID", h.internalAccountPolicy)
 	internalGroup.POST("/nodes/heartbeat", h.internalNodeHeartbeat)
+	internalGroup.POST("/nodes/traffic", h.internalNodeTraffic)
+
+	// SCIM 2.0 provisioning, authenticated with per-tenant bearer tokens.
+	registerSCIMRoutes(r, h)
 
 	// Public /api routes for admin/management (expected by frontend at /api/admin/...)
 	apiGroup := r.Group("/api")
 	if h.tokenService != nil {
-		apiGroup.Use(h.tokenService.AuthMiddleware())
+		apiGroup.Use(h.tokenService.APITokenAuthMiddleware())
 		apiGroup.Use(auth.RequireActiveUser(h.store))
 	}
 	registerAdminRoutes(apiGroup, h)
@@ -453,6 +554,9 @@ func RegisterRoutes(r *gin.Engine, opts ...Option) {
 	agentServerGroup.GET("/nodes", h.listAgentNodes)
 	agentServerGroup.GET("/users", h.listAgentUsers)
 	agentServerGroup.POST("/status", h.reportAgentStatus)
+	agentServerGroup.POST("/traffic", h.reportAgentTraffic)
+	agentServerGroup.POST("/enroll", h.enrollAgent)
+	agentServerGroup.POST("/certificate", h.renewAgentCertificate)
 
 	accountGroup := r.Group("/api/account")
 	accountGroup.GET("/usage/summary", h.accountUsageSummary)
@@ -473,12 +577,13 @@ type registerRequest struct {
 }
 
 type loginRequest struct {
-	Identifier string `json:"identifier"`
-	Account    string `json:"account"`
-	Username   string `json:"username"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	TOTPCode   string `json:"totpCode"`
+	Identifier   string `json:"identifier"`
+	Account      string `json:"account"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totpCode"`
+	RecoveryCode string `json:"recoveryCode"`
 }
 
 type verificationCodeRequest struct {
@@ -630,6 +735,11 @@ func (h *handler) register(c *gin.Context) {
 		}
 	}
 
+	if err := h.publishUserCreated(c.Request.Context(), user); err != nil {
+		h.rollbackCreatedUser(c.Request.Context(), user)
+		respondError(c, http.StatusInternalServerError, "user_creation_failed", "failed to create user")
+		return
+	}
 	if h.emailVerificationEnabled {
 		h.removeRegistrationVerification(email)
 	}
@@ -650,8 +760,11 @@ func (h *handler) register(c *gin.Context) {
 		},
 	}
 
+	// The trial is best effort, and so is announcing it.
 	if err := h.store.UpsertSubscription(c.Request.Context(), trial); err != nil {
 		slog.Warn("failed to provision onboarding trial", "err", err, "userID", user.ID)
+	} else {
+		_ = h.publishSubscriptionEvent(c.Request.Context(), trial)
 	}
 
 	message := "registration successful"
@@ -721,11 +834,15 @@ func (h *handler) verifyEmail(c *gin.Context) {
 				respondError(c, http.StatusInternalServerError, "verification_failed", "failed to verify email")
 				return
 			}
+			if err := h.publishUserEvent(c.Request.Context(), webhook.EventUserVerified, user, nil); err != nil {
+				respondEventPublishFailed(c)
+				return
+			}
 		}
 
 		h.removeEmailVerification(email)
 
-		sessionToken, expiresAt, err := h.createSession(user.ID)
+		sessionToken, expiresAt, err := h.createSession(c, user.ID, sessionAuthEmailVerification)
 		if err != nil {
 			respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 			return
@@ -799,6 +916,10 @@ func (h *handler) sendEmailVerification(c *gin.Context) {
 		return
 	}
 
+	if !h.consumeRateLimits(c, h.emailRateLimits(c, email)...) {
+		return
+	}
+
 	user, err := h.store.GetUserByEmail(ctx, email)
 	if err == nil {
 		if strings.TrimSpace(user.Email) == "" {
@@ -860,6 +981,9 @@ func (h *handler) requestPasswordReset(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "email_required", "email is required")
 		return
 	}
+	if !h.consumeRateLimits(c, h.emailRateLimits(c, email)...) {
+		return
+	}
 
 	user, err := h.store.GetUserByEmail(c.Request.Context(), email)
 	if err != nil {
@@ -885,6 +1009,7 @@ func (h *handler) requestPasswordReset(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "password_reset_failed", "failed to initiate password reset")
 		return
 	}
+	h.recordUserAudit(c, user, auditActionPasswordResetRequest, nil)
 
 	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists a reset email will be sent"})
 }
@@ -944,6 +1069,7 @@ func (h *handler) confirmPasswordReset(c *gin.Context) {
 		return
 	}
 
+	wasVerified := user.EmailVerified
 	user.PasswordHash = string(hashed)
 	user.EmailVerified = true
 	if err := h.store.UpdateUser(c.Request.Context(), user); err != nil {
@@ -951,10 +1077,18 @@ func (h *handler) confirmPasswordReset(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "password_reset_failed", "failed to reset password")
 		return
 	}
+	if !wasVerified {
+		if err := h.publishUserEvent(c.Request.Context(), webhook.EventUserVerified, user, nil); err != nil {
+			respondEventPublishFailed(c)
+			return
+		}
+	}
 
 	h.removePasswordReset(token)
+	h.revokeRefreshTokens(c.Request.Context(), user.ID, store.RefreshTokenRevokedPasswordChanged)
+	h.recordUserAudit(c, user, auditActionPasswordReset, nil)
 
-	sessionToken, expiresAt, err := h.createSession(user.ID)
+	sessionToken, expiresAt, err := h.createSession(c, user.ID, sessionAuthPasswordReset)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
@@ -971,11 +1105,12 @@ func (h *handler) confirmPasswordReset(c *gin.Context) {
 }
 
 var allowedPermissionMatrixRoles = map[string]struct{}{
-	store.RoleRoot:     {},
-	store.RoleOperator: {},
-	store.RoleUser:     {},
-	store.RoleReadOnly: {},
-	store.RoleAdmin:    {},
+	store.RoleRoot:       {},
+	store.RoleOperator:   {},
+	store.RoleUser:       {},
+	store.RoleReadOnly:   {},
+	store.RoleAdmin:      {},
+	rbac.RoleTenantAdmin: {},
 }
 
 var assignableUserRoles = map[string]struct{}{
@@ -1027,6 +1162,10 @@ func (h *handler) updateAdminSettings(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	previous, err := service.GetAdminSettings(c.Request.Context())
+	if err != nil {
+		previous = service.AdminSettings{}
+	}
 
 	updated, err := service.SaveAdminSettings(c.Request.Context(), service.AdminSettings{
 		Version: req.Version,
@@ -1048,12 +1187,33 @@ func (h *handler) updateAdminSettings(c *gin.Context) {
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	before, after := auditDiff(flattenAdminMatrix(previous.Matrix), flattenAdminMatrix(updated.Matrix))
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionSettingsUpdate,
+		TargetType: auditTargetSettings,
+		TargetID:   "permission_matrix",
+		Before:     before,
+		After:      after,
+		Metadata:   map[string]any{"version": updated.Version},
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"version": updated.Version,
 		"matrix":  updated.Matrix,
 	})
 }
 
+// flattenAdminMatrix keys the permission matrix by "permission/role" so an
+// audit diff lists individual grants.
+func flattenAdminMatrix(matrix map[string]map[string]bool) map[string]any {
+	flat := make(map[string]any)
+	for module, roles := range matrix {
+		for role, allowed := range roles {
+			flat[module+"/"+role] = allowed
+		}
+	}
+	return flat
+}
+
 func normalizeAdminMatrix(in map[string]map[string]bool) (map[string]map[string]bool, error) {
 	if in == nil {
 		return make(map[string]map[string]bool), nil
@@ -1109,15 +1269,23 @@ func (h *handler) login(c *gin.Context) {
 
 	password := strings.TrimSpace(req.Password)
 	totpCode := strings.TrimSpace(req.TOTPCode)
+	recoveryCode := strings.TrimSpace(req.RecoveryCode)
 
 	if identifier == "" {
 		respondError(c, http.StatusBadRequest, "missing_credentials", "identifier is required")
 		return
 	}
 
+	attempt := h.newAttemptReservation(c)
+	defer attempt.release()
+	if !attempt.reserve(h.ipRateLimit(c)) {
+		return
+	}
+
 	user, err := h.findUserByIdentifier(c.Request.Context(), identifier)
 	if err != nil {
 		if errors.Is(err, store.ErrUserNotFound) {
+			attempt.fail()
 			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
 			return
 		}
@@ -1132,8 +1300,15 @@ func (h *handler) login(c *gin.Context) {
 		return
 	}
 
+	accountLimit := h.accountRateLimit(user.ID)
+	if !attempt.reserve(accountLimit) {
+		return
+	}
+
 	if password != "" {
 		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			attempt.fail()
+			h.recordLoginFailure(c, user, sessionAuthPassword, "invalid_credentials")
 			respondError(c, http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
 			return
 		}
@@ -1167,8 +1342,9 @@ func (h *handler) login(c *gin.Context) {
 		}
 	}
 
-	if user.MFAEnabled {
-		if totpCode == "" {
+	if mfaMethods := h.mfaMethods(c.Request.Context(), user); len(mfaMethods) > 0 {
+		usesRecoveryCode := recoveryCode != "" && password != ""
+		if !usesRecoveryCode && (totpCode == "" || !user.MFAEnabled) {
 			mfaTicket, err := h.createMFAChallenge(user.ID)
 			if err != nil {
 				respondError(c, http.StatusInternalServerError, "mfa_challenge_creation_failed", "failed to create mfa challenge")
@@ -1178,8 +1354,9 @@ func (h *handler) login(c *gin.Context) {
 				"message":      "mfa required",
 				"mfaRequired":  true,
 				"mfa_required": true,
-				"mfaMethod":    "totp",
-				"mfa_method":   "totp",
+				"mfaMethod":    mfaMethods[0],
+				"mfa_method":   mfaMethods[0],
+				"mfaMethods":   mfaMethods,
 				"mfaTicket":    mfaTicket,
 				"mfa_ticket":   mfaTicket,
 				// Kept for backward compatibility with existing clients.
@@ -1188,22 +1365,36 @@ func (h *handler) login(c *gin.Context) {
 			return
 		}
 
-		valid, err := totp.ValidateCustom(totpCode, user.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
-			Period:    30,
-			Skew:      1,
-			Digits:    otp.DigitsSix,
-			Algorithm: otp.AlgorithmSHA1,
-		})
-		if err != nil {
-			respondError(c, http.StatusInternalServerError, "invalid_mfa_code", "invalid totp code")
-			return
-		}
-		if !valid {
-			respondError(c, http.StatusUnauthorized, "invalid_mfa_code", "invalid totp code")
-			return
+		authMethod := sessionAuthTOTP
+		if usesRecoveryCode {
+			if err := h.redeemMFARecoveryCode(c, user, recoveryCode); err != nil {
+				attempt.fail()
+				h.recordLoginFailure(c, user, sessionAuthRecoveryCode, "invalid_recovery_code")
+				respondError(c, http.StatusUnauthorized, "invalid_recovery_code", "recovery code is invalid or already used")
+				return
+			}
+			authMethod = sessionAuthRecoveryCode
+		} else {
+			valid, err := totp.ValidateCustom(totpCode, user.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
+				Period:    30,
+				Skew:      1,
+				Digits:    otp.DigitsSix,
+				Algorithm: otp.AlgorithmSHA1,
+			})
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, "invalid_mfa_code", "invalid totp code")
+				return
+			}
+			if !valid {
+				attempt.fail()
+				h.recordLoginFailure(c, user, sessionAuthTOTP, "invalid_mfa_code")
+				respondError(c, http.StatusUnauthorized, "invalid_mfa_code", "invalid totp code")
+				return
+			}
 		}
+		h.resetRateLimits(c, accountLimit)
 
-		token, expiresAt, err := h.createSession(user.ID)
+		token, expiresAt, err := h.createSession(c, user.ID, authMethod)
 		if err != nil {
 			respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 			return
@@ -1211,7 +1402,7 @@ func (h *handler) login(c *gin.Context) {
 
 		h.setSessionCookie(c, token, expiresAt)
 
-		c.JSON(http.StatusOK, gin.H{
+		c.JSON(http.StatusOK, h.attachRefreshToken(c.Request.Context(), gin.H{
 			"message":      "login successful",
 			"token":        token,
 			"access_token": token,
@@ -1220,11 +1411,13 @@ func (h *handler) login(c *gin.Context) {
 			"mfaRequired":  false,
 			"mfa_required": false,
 			"user":         sanitizeUser(user, nil),
-		})
+		}, user))
 		return
 	}
 
-	token, expiresAt, err := h.createSession(user.ID)
+	h.resetRateLimits(c, accountLimit)
+
+	token, expiresAt, err := h.createSession(c, user.ID, sessionAuthPassword)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
@@ -1251,16 +1444,18 @@ func (h *handler) login(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, h.attachRefreshToken(c.Request.Context(), response, user))
 }
 
 func (h *handler) verifyMFALogin(c *gin.Context) {
 	var req struct {
-		MFATicket string `json:"mfa_ticket"`
-		MFAToken  string `json:"mfaToken"`
-		Code      string `json:"code"`
-		TOTPCode  string `json:"totpCode"`
-		Method    string `json:"method"`
+		MFATicket    string          `json:"mfa_ticket"`
+		MFAToken     string          `json:"mfaToken"`
+		Code         string          `json:"code"`
+		TOTPCode     string          `json:"totpCode"`
+		RecoveryCode string          `json:"recoveryCode"`
+		Method       string          `json:"method"`
+		Credential   json.RawMessage `json:"credential"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
@@ -1280,61 +1475,115 @@ func (h *handler) verifyMFALogin(c *gin.Context) {
 	if code == "" {
 		code = strings.TrimSpace(req.TOTPCode)
 	}
-	if code == "" {
-		respondError(c, http.StatusBadRequest, "mfa_code_required", "totp code is required")
-		return
-	}
 
 	method := strings.ToLower(strings.TrimSpace(req.Method))
+	if method == "" && strings.TrimSpace(req.RecoveryCode) != "" {
+		method = mfaMethodRecoveryCode
+	}
 	if method == "" {
-		method = "totp"
+		method = mfaMethodTOTP
 	}
-	if method != "totp" {
+	switch method {
+	case mfaMethodTOTP:
+		if code == "" {
+			respondError(c, http.StatusBadRequest, "mfa_code_required", "totp code is required")
+			return
+		}
+	case mfaMethodRecoveryCode:
+		if recoveryCode := strings.TrimSpace(req.RecoveryCode); recoveryCode != "" {
+			code = recoveryCode
+		}
+		if code == "" {
+			respondError(c, http.StatusBadRequest, "mfa_code_required", "recovery code is required")
+			return
+		}
+	case mfaMethodWebAuthn:
+		if len(req.Credential) == 0 {
+			respondError(c, http.StatusBadRequest, "webauthn_credential_required", "passkey response is required")
+			return
+		}
+	default:
 		respondError(c, http.StatusBadRequest, "unsupported_mfa_method", "unsupported mfa method")
 		return
 	}
 
+	attempt := h.newAttemptReservation(c)
+	defer attempt.release()
+	if !attempt.reserve(h.ipRateLimit(c)) {
+		return
+	}
+
 	challenge, ok := h.lookupMFAChallenge(mfaTicket)
 	if !ok {
+		attempt.fail()
 		respondError(c, http.StatusUnauthorized, "invalid_mfa_ticket", "mfa ticket is invalid or expired")
 		return
 	}
 
-	user, err := h.store.GetUserByID(c.Request.Context(), challenge.userID)
-	if err != nil {
-		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
-		return
-	}
-	if !user.MFAEnabled {
-		respondError(c, http.StatusBadRequest, "mfa_not_enabled", "multi-factor authentication is not enabled")
+	accountLimit := h.accountRateLimit(challenge.userID)
+	if !attempt.reserve(accountLimit) {
 		return
 	}
 
-	valid, err := totp.ValidateCustom(code, user.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
-		Period:    30,
-		Skew:      1,
-		Digits:    otp.DigitsSix,
-		Algorithm: otp.AlgorithmSHA1,
-	})
+	user, err := h.store.GetUserByID(c.Request.Context(), challenge.userID)
 	if err != nil {
-		respondError(c, http.StatusInternalServerError, "invalid_mfa_code", "invalid totp code")
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
 		return
 	}
-	if !valid {
-		respondError(c, http.StatusUnauthorized, "invalid_mfa_code", "invalid totp code")
-		return
+
+	authMethod := sessionAuthTOTP
+	switch method {
+	case mfaMethodWebAuthn:
+		if err := h.verifyWebAuthnAssertion(c.Request.Context(), user, challenge, req.Credential); err != nil {
+			attempt.fail()
+			h.recordLoginFailure(c, user, sessionAuthWebAuthn, "invalid_webauthn_credential")
+			respondError(c, http.StatusUnauthorized, "invalid_webauthn_credential", "passkey could not be verified")
+			return
+		}
+		authMethod = sessionAuthWebAuthn
+	case mfaMethodRecoveryCode:
+		if err := h.redeemMFARecoveryCode(c, user, code); err != nil {
+			attempt.fail()
+			h.recordLoginFailure(c, user, sessionAuthRecoveryCode, "invalid_recovery_code")
+			respondError(c, http.StatusUnauthorized, "invalid_recovery_code", "recovery code is invalid or already used")
+			return
+		}
+		authMethod = sessionAuthRecoveryCode
+	default:
+		if !user.MFAEnabled {
+			respondError(c, http.StatusBadRequest, "mfa_not_enabled", "multi-factor authentication is not enabled")
+			return
+		}
+
+		valid, err := totp.ValidateCustom(code, user.MFATOTPSecret, time.Now().UTC(), totp.ValidateOpts{
+			Period:    30,
+			Skew:      1,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "invalid_mfa_code", "invalid totp code")
+			return
+		}
+		if !valid {
+			attempt.fail()
+			h.recordLoginFailure(c, user, sessionAuthTOTP, "invalid_mfa_code")
+			respondError(c, http.StatusUnauthorized, "invalid_mfa_code", "invalid totp code")
+			return
+		}
 	}
 
+	h.resetRateLimits(c, accountLimit)
 	h.removeMFAChallenge(mfaTicket)
 
-	token, expiresAt, err := h.createSession(user.ID)
+	token, expiresAt, err := h.createSession(c, user.ID, authMethod)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
 	}
 
 	h.setSessionCookie(c, token, expiresAt)
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, h.attachRefreshToken(c.Request.Context(), gin.H{
 		"message":      "login successful",
 		"token":        token,
 		"access_token": token,
@@ -1343,7 +1592,7 @@ func (h *handler) verifyMFALogin(c *gin.Context) {
 		"mfaRequired":  false,
 		"mfa_required": false,
 		"user":         sanitizeUser(user, nil),
-	})
+	}, user))
 }
 
 type tokenRefreshRequest struct {
@@ -1362,17 +1611,27 @@ func (h *handler) refreshToken(c *gin.Context) {
 		return
 	}
 
-	// Refresh access token
-	accessToken, err := h.tokenService.RefreshAccessToken(req.RefreshToken)
+	pair, err := h.tokenService.RefreshTokenPair(c.Request.Context(), req.RefreshToken)
 	if err != nil {
-		respondError(c, http.StatusUnauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			slog.Warn("refresh token reuse detected; token family revoked")
+			respondError(c, http.StatusUnauthorized, "refresh_token_reused", "refresh token was already used; please sign in again")
+		case errors.Is(err, auth.ErrRefreshTokenRevoked):
+			respondError(c, http.StatusUnauthorized, "refresh_token_revoked", "refresh token has been revoked")
+		case errors.Is(err, auth.ErrRefreshTokenInvalid):
+			respondError(c, http.StatusUnauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+		default:
+			respondError(c, http.StatusInternalServerError, "refresh_failed", "failed to refresh token")
+		}
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": accessToken,
-		"token_type":   "Bearer",
-		"expires_in":   int64(h.tokenService.GetAccessTokenExpiry().Seconds()),
+		"access_token":  pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"token_type":    pair.TokenType,
+		"expires_in":    pair.ExpiresIn,
 	})
 }
 
@@ -1410,14 +1669,14 @@ func (h *handler) exchangeToken(c *gin.Context) {
 		expiresIn = 0
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, h.attachRefreshToken(c.Request.Context(), gin.H{
 		"token":        sessionToken,
 		"access_token": sessionToken,
 		"token_type":   "Bearer",
 		"expiresAt":    sess.expiresAt.UTC(),
 		"expires_in":   expiresIn,
 		"user":         sanitizeUser(user, nil),
-	})
+	}, user))
 }
 
 func (h *handler) findUserByIdentifier(ctx context.Context, identifier string) (*store.User, error) {
@@ -1541,7 +1800,7 @@ func (h *handler) requireAuthenticatedUser(c *gin.Context) (*store.User, bool) {
 	return user, true
 }
 
-func (h *handler) createSession(userID string) (string, time.Time, error) {
+func (h *handler) createSession(c *gin.Context, userID, authMethod string) (string, time.Time, error) {
 	token, err := h.newRandomToken()
 	if err != nil {
 		return "", time.Time{}, err
@@ -1552,9 +1811,27 @@ func (h *handler) createSession(userID string) (string, time.Time, error) {
 	}
 	expiresAt := time.Now().Add(ttl)
 
-	if err := h.store.CreateSession(context.Background(), token, userID, expiresAt); err != nil {
+	record := &store.Session{
+		Token:      token,
+		UserID:     userID,
+		UserAgent:  sessionUserAgent(c),
+		IPAddress:  c.ClientIP(),
+		AuthMethod: authMethod,
+		ExpiresAt:  expiresAt,
+	}
+	if err := h.store.CreateSessionRecord(context.Background(), record); err != nil {
 		return "", time.Time{}, err
 	}
+	if h.sessionCache != nil {
+		_ = h.sessionCache.SetSession(context.Background(), token, cache.Session{UserID: userID, ExpiresAt: expiresAt})
+	}
+	// Every session is a sign-in, whichever flow issued it.
+	h.recordAudit(c, &store.User{ID: userID}, store.AuditEvent{
+		Action:     auditActionLogin,
+		TargetType: auditTargetUser,
+		TargetID:   userID,
+		Metadata:   map[string]any{"method": authMethod, "sessionId": record.ID},
+	})
 	return token, expiresAt, nil
 }
 
@@ -1570,6 +1847,11 @@ func (h *handler) setSessionCookie(c *gin.Context, token string, expiresAt time.
 	c.SetCookie(sessionCookieName, token, maxAge, "/", domain, secure, true)
 }
 
+func (h *handler) clearSessionCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookieName, "", -1, "/", h.getCookieDomain(), c.Request.TLS != nil, true)
+}
+
 func (h *handler) getCookieDomain() string {
 	if h.publicURL == "" {
 		return ""
@@ -1590,15 +1872,24 @@ func (h *handler) getCookieDomain() string {
 }
 
 func (h *handler) lookupSession(token string) (session, bool) {
-	userID, expiresAt, err := h.store.GetSession(context.Background(), token)
+	userID, expiresAt, err := cache.LookupSession(context.Background(), h.sessionCache, token, auth.StoreSessionLoader(h.store))
 	if err != nil {
 		return session{}, false
 	}
 	return session{userID: userID, expiresAt: expiresAt}, true
 }
 
+// removeSession deletes the session before evicting it from the cache, so a
+// concurrent lookup cannot cache it again from the store after the eviction.
 func (h *handler) removeSession(token string) {
-	h.store.DeleteSession(context.Background(), token)
+	if err := h.store.DeleteSession(context.Background(), token); err != nil {
+		slog.Warn("failed to delete session", "err", err)
+	}
+	if h.sessionCache != nil {
+		if err := h.sessionCache.DeleteSession(context.Background(), token); err != nil {
+			slog.Warn("failed to evict cached session", "err", err)
+		}
+	}
 }
 
 func (h *handler) issueOAuthExchangeCode(sessionToken string, sessionExpiresAt time.Time) (string, time.Time, error) {
@@ -1615,12 +1906,12 @@ func (h *handler) issueOAuthExchangeCode(sessionToken string, sessionExpiresAt t
 		expiresAt = sessionExpiresAt
 	}
 
-	h.oauthExchangeMu.Lock()
-	defer h.oauthExchangeMu.Unlock()
-	h.oauthExchangeCodes[code] = oauthExchangeCode{
+	if err := h.putAuthState(authStateOAuthExchangeCodes, code, oauthExchangeCode{
 		sessionToken:     sessionToken,
 		sessionExpiresAt: sessionExpiresAt,
 		expiresAt:        expiresAt,
+	}, expiresAt); err != nil {
+		return "", time.Time{}, err
 	}
 	return code, expiresAt, nil
 }
@@ -1631,14 +1922,10 @@ func (h *handler) consumeOAuthExchangeCode(code string) (string, time.Time, bool
 		return "", time.Time{}, false
 	}
 
-	h.oauthExchangeMu.Lock()
-	defer h.oauthExchangeMu.Unlock()
-
-	record, ok := h.oauthExchangeCodes[normalized]
-	if !ok {
+	var record oauthExchangeCode
+	if !h.takeAuthState(authStateOAuthExchangeCodes, normalized, &record) {
 		return "", time.Time{}, false
 	}
-	delete(h.oauthExchangeCodes, normalized)
 
 	if time.Now().After(record.expiresAt) {
 		return "", time.Time{}, false
@@ -1681,32 +1968,51 @@ func clearMFAChallenge(ch *mfaChallenge) {
 	ch.totpIssuedAt = time.Time{}
 	ch.failedAttempts = 0
 	ch.lockedUntil = time.Time{}
+	ch.webauthnSession = nil
 }
 
 func (h *handler) updateMFAChallenge(token string, update func(*mfaChallenge) bool) (mfaChallenge, bool) {
-	h.mfaMu.Lock()
-	defer h.mfaMu.Unlock()
-
-	challenge, ok := h.mfaChallenges[token]
-	if !ok {
-		return mfaChallenge{}, false
-	}
-
-	if time.Now().After(challenge.expiresAt) {
-		clearMFAChallenge(&challenge)
-		delete(h.mfaChallenges, token)
-		return mfaChallenge{}, false
+	if update == nil {
+		var challenge mfaChallenge
+		if !h.getAuthState(authStateMFAChallenges, token, &challenge) {
+			return mfaChallenge{}, false
+		}
+		if time.Now().After(challenge.expiresAt) {
+			h.deleteAuthState(authStateMFAChallenges, token)
+			return mfaChallenge{}, false
+		}
+		return challenge, true
 	}
 
-	if update != nil {
-		if !update(&challenge) {
+	var (
+		challenge mfaChallenge
+		ok        bool
+	)
+	_, err := h.authState.Update(context.Background(), authStateMFAChallenges, token, func(current []byte) ([]byte, time.Duration, error) {
+		challenge, ok = mfaChallenge{}, false
+		if err := json.Unmarshal(current, &challenge); err != nil {
+			return nil, 0, err
+		}
+		if time.Now().After(challenge.expiresAt) || !update(&challenge) {
 			clearMFAChallenge(&challenge)
-			delete(h.mfaChallenges, token)
-			return mfaChallenge{}, false
+			return nil, 0, nil
 		}
-		h.mfaChallenges[token] = challenge
+		next, err := json.Marshal(challenge)
+		if err != nil {
+			return nil, 0, err
+		}
+		ok = true
+		return next, time.Until(challenge.expiresAt), nil
+	})
+	if err != nil {
+		if !errors.Is(err, cache.ErrStateNotFound) {
+			slog.Warn("failed to update mfa challenge", "err", err)
+		}
+		return mfaChallenge{}, false
+	}
+	if !ok {
+		return mfaChallenge{}, false
 	}
-
 	return challenge, true
 }
 
@@ -1717,9 +2023,9 @@ func (h *handler) createMFAChallenge(userID string) (string, error) {
 	}
 	ttl := h.effectiveMFAChallengeTTL()
 	challenge := mfaChallenge{userID: userID, expiresAt: time.Now().Add(ttl)}
-	h.mfaMu.Lock()
-	h.mfaChallenges[token] = challenge
-	h.mfaMu.Unlock()
+	if err := h.putAuthState(authStateMFAChallenges, token, challenge, challenge.expiresAt); err != nil {
+		return "", err
+	}
 	return token, nil
 }
 
@@ -1747,28 +2053,28 @@ func (h *handler) enqueueEmailVerification(ctx context.Context, user *store.User
 		ttl = defaultEmailVerificationTTL
 	}
 
-	h.verificationMu.Lock()
 	var code string
 	var expiresAt time.Time
-	if existing, ok := h.verifications[normalizedEmail]; ok && time.Now().Before(existing.expiresAt) {
+	var existing emailVerification
+	if h.getAuthState(authStateEmailVerifications, normalizedEmail, &existing) && time.Now().Before(existing.expiresAt) {
 		code = existing.code
 		expiresAt = existing.expiresAt
 	} else {
 		var err error
 		code, err = h.newVerificationCode()
 		if err != nil {
-			h.verificationMu.Unlock()
 			return err
 		}
 		expiresAt = time.Now().Add(ttl)
-		h.verifications[normalizedEmail] = emailVerification{
+		if err := h.putAuthState(authStateEmailVerifications, normalizedEmail, emailVerification{
 			userID:    user.ID,
 			email:     normalizedEmail,
 			code:      code,
 			expiresAt: expiresAt,
+		}, expiresAt); err != nil {
+			return err
 		}
 	}
-	h.verificationMu.Unlock()
 
 	name := strings.TrimSpace(user.Name)
 	if name == "" {
@@ -1801,10 +2107,8 @@ func (h *handler) lookupEmailVerification(email string) (emailVerification, bool
 		return emailVerification{}, false
 	}
 
-	h.verificationMu.RLock()
-	verification, ok := h.verifications[email]
-	h.verificationMu.RUnlock()
-	if !ok {
+	var verification emailVerification
+	if !h.getAuthState(authStateEmailVerifications, email, &verification) {
 		return emailVerification{}, false
 	}
 
@@ -1817,9 +2121,7 @@ func (h *handler) lookupEmailVerification(email string) (emailVerification, bool
 }
 
 func (h *handler) removeEmailVerification(email string) {
-	h.verificationMu.Lock()
-	delete(h.verifications, strings.ToLower(strings.TrimSpace(email)))
-	h.verificationMu.Unlock()
+	h.deleteAuthState(authStateEmailVerifications, strings.ToLower(strings.TrimSpace(email)))
 }
 
 func (h *handler) issueRegistrationVerification(ctx context.Context, email string) (registrationVerification, error) {
@@ -1833,14 +2135,13 @@ func (h *handler) issueRegistrationVerification(ctx context.Context, email strin
 		ttl = defaultEmailVerificationTTL
 	}
 
-	h.registrationMu.Lock()
 	var verification registrationVerification
-	if existing, ok := h.registrationVerifications[normalized]; ok && time.Now().Before(existing.expiresAt) {
+	var existing registrationVerification
+	if h.getAuthState(authStateRegistrationVerifications, normalized, &existing) && time.Now().Before(existing.expiresAt) {
 		verification = existing
 	} else {
 		code, err := h.newVerificationCode()
 		if err != nil {
-			h.registrationMu.Unlock()
 			return registrationVerification{}, err
 		}
 		verification = registrationVerification{
@@ -1848,9 +2149,10 @@ func (h *handler) issueRegistrationVerification(ctx context.Context, email strin
 			code:      code,
 			expiresAt: time.Now().Add(ttl),
 		}
-		h.registrationVerifications[normalized] = verification
+		if err := h.putAuthState(authStateRegistrationVerifications, normalized, verification, verification.expiresAt); err != nil {
+			return registrationVerification{}, err
+		}
 	}
-	h.registrationMu.Unlock()
 
 	// [DEBUG] Log the verification code to stdout so we can see it in logs
 	slog.Info("issued registration verification code", "email", normalized, "code", verification.code)
@@ -1896,10 +2198,8 @@ func (h *handler) lookupRegistrationVerification(email string) (registrationVeri
 		return registrationVerification{}, false
 	}
 
-	h.registrationMu.RLock()
-	verification, ok := h.registrationVerifications[email]
-	h.registrationMu.RUnlock()
-	if !ok {
+	var verification registrationVerification
+	if !h.getAuthState(authStateRegistrationVerifications, email, &verification) {
 		return registrationVerification{}, false
 	}
 
@@ -1917,28 +2217,35 @@ func (h *handler) markRegistrationVerified(email string) bool {
 		return false
 	}
 
-	h.registrationMu.Lock()
-	defer h.registrationMu.Unlock()
-
-	verification, ok := h.registrationVerifications[email]
-	if !ok {
-		return false
-	}
-
-	if time.Now().After(verification.expiresAt) {
-		delete(h.registrationVerifications, email)
+	verified := false
+	_, err := h.authState.Update(context.Background(), authStateRegistrationVerifications, email, func(current []byte) ([]byte, time.Duration, error) {
+		var verification registrationVerification
+		if err := json.Unmarshal(current, &verification); err != nil {
+			return nil, 0, err
+		}
+		if time.Now().After(verification.expiresAt) {
+			verified = false
+			return nil, 0, nil
+		}
+		verification.verified = true
+		next, err := json.Marshal(verification)
+		if err != nil {
+			return nil, 0, err
+		}
+		verified = true
+		return next, time.Until(verification.expiresAt), nil
+	})
+	if err != nil {
+		if !errors.Is(err, cache.ErrStateNotFound) {
+			slog.Warn("failed to mark registration verified", "err", err)
+		}
 		return false
 	}
-
-	verification.verified = true
-	h.registrationVerifications[email] = verification
-	return true
+	return verified
 }
 
 func (h *handler) removeRegistrationVerification(email string) {
-	h.registrationMu.Lock()
-	delete(h.registrationVerifications, strings.ToLower(strings.TrimSpace(email)))
-	h.registrationMu.Unlock()
+	h.deleteAuthState(authStateRegistrationVerifications, strings.ToLower(strings.TrimSpace(email)))
 }
 
 func (h *handler) enqueuePasswordReset(ctx context.Context, user *store.User) error {
@@ -1964,9 +2271,9 @@ func (h *handler) enqueuePasswordReset(ctx context.Context, user *store.User) er
 		expiresAt: expiresAt,
 	}
 
-	h.resetMu.Lock()
-	h.passwordResets[token] = reset
-	h.resetMu.Unlock()
+	if err := h.putAuthState(authStatePasswordResets, token, reset, expiresAt); err != nil {
+		return err
+	}
 
 	name := strings.TrimSpace(user.Name)
 	if name == "" {
@@ -1998,10 +2305,8 @@ func (h *handler) lookupPasswordReset(token string) (passwordReset, bool) {
 		return passwordReset{}, false
 	}
 
-	h.resetMu.RLock()
-	reset, ok := h.passwordResets[token]
-	h.resetMu.RUnlock()
-	if !ok {
+	var reset passwordReset
+	if !h.getAuthState(authStatePasswordResets, token, &reset) {
 		return passwordReset{}, false
 	}
 
@@ -2014,32 +2319,28 @@ func (h *handler) lookupPasswordReset(token string) (passwordReset, bool) {
 }
 
 func (h *handler) removePasswordReset(token string) {
-	h.resetMu.Lock()
-	delete(h.passwordResets, strings.TrimSpace(token))
-	h.resetMu.Unlock()
+	h.deleteAuthState(authStatePasswordResets, strings.TrimSpace(token))
 }
 
 func (h *handler) removeMFAChallenge(token string) {
-	h.mfaMu.Lock()
-	if challenge, ok := h.mfaChallenges[token]; ok {
-		clearMFAChallenge(&challenge)
-		delete(h.mfaChallenges, token)
-	}
-	h.mfaMu.Unlock()
+	h.deleteAuthState(authStateMFAChallenges, token)
 }
 
 func (h *handler) removeMFAChallengesForUser(userID string) {
 	if userID == "" {
 		return
 	}
-	h.mfaMu.Lock()
-	for token, challenge := range h.mfaChallenges {
-		if challenge.userID == userID {
-			clearMFAChallenge(&challenge)
-			delete(h.mfaChallenges, token)
+	entries, err := h.authState.Entries(context.Background(), authStateMFAChallenges)
+	if err != nil {
+		slog.Warn("failed to list mfa challenges", "err", err)
+		return
+	}
+	for token, payload := range entries {
+		var challenge mfaChallenge
+		if err := json.Unmarshal(payload, &challenge); err != nil || challenge.userID == userID {
+			h.deleteAuthState(authStateMFAChallenges, token)
 		}
 	}
-	h.mfaMu.Unlock()
 }
 
 func (h *handler) provisionTOTP(c *gin.Context) {
@@ -2283,6 +2584,7 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 	now := time.Now()
 	if !challenge.lockedUntil.IsZero() && now.Before(challenge.lockedUntil) {
 		retryAt := challenge.lockedUntil.UTC()
+		setRetryAfter(c, retryAt.Sub(now))
 		c.JSON(http.StatusTooManyRequests, gin.H{
 			"error":    "mfa_challenge_locked",
 			"message":  "too many invalid mfa attempts, try again later",
@@ -2340,6 +2642,7 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 
 		if !challenge.lockedUntil.IsZero() && now.Before(challenge.lockedUntil) {
 			retryAt := challenge.lockedUntil.UTC()
+			setRetryAfter(c, retryAt.Sub(now))
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":    "mfa_challenge_locked",
 				"message":  "too many invalid mfa attempts, try again later",
@@ -2365,6 +2668,7 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 	if user.MFASecretIssuedAt.IsZero() {
 		user.MFASecretIssuedAt = issuedAt
 	}
+	newlyEnrolled := !user.MFAEnabled
 	user.MFAEnabled = true
 	user.MFAConfirmedAt = confirmationTime
 
@@ -2373,9 +2677,20 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 		return
 	}
 
+	// Recovery codes are shown once, right after enrollment. If issuing them
+	// fails the user can still generate a set from the account page.
+	var recoveryCodes []string
+	if newlyEnrolled {
+		recoveryCodes, err = h.issueMFARecoveryCodes(ctx, user.ID)
+		if err != nil {
+			slog.Warn("failed to issue mfa recovery codes", "err", err, "userID", user.ID)
+		}
+		h.recordUserAudit(c, user, auditActionTOTPEnabled, nil)
+	}
+
 	h.removeMFAChallenge(token)
 
-	sessionToken, expiresAt, err := h.createSession(user.ID)
+	sessionToken, expiresAt, err := h.createSession(c, user.ID, sessionAuthTOTP)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
@@ -2383,12 +2698,16 @@ func (h *handler) verifyTOTP(c *gin.Context) {
 
 	h.setSessionCookie(c, sessionToken, expiresAt)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":   "mfa_verified",
 		"token":     sessionToken,
 		"expiresAt": expiresAt.UTC(),
 		"user":      sanitizeUser(user, nil),
-	})
+	}
+	if len(recoveryCodes) > 0 {
+		response["recoveryCodes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *handler) mfaStatus(c *gin.Context) {
@@ -2441,6 +2760,10 @@ func (h *handler) mfaStatus(c *gin.Context) {
 		}
 	}
 
+	// Enrolled authenticators are only listed to the session owner or the
+	// holder of an MFA ticket, not to identifier lookups.
+	listAuthenticators := user != nil
+
 	if user == nil && identifier != "" {
 		user, err = h.findUserByIdentifier(ctx, identifier)
 		if err != nil {
@@ -2461,8 +2784,25 @@ func (h *handler) mfaStatus(c *gin.Context) {
 	}
 
 	state := buildMFAState(user, challenge)
+	methods := h.mfaMethods(ctx, user)
+	state["methods"] = methods
+	state["webauthnEnabled"] = slices.Contains(methods, mfaMethodWebAuthn)
+	if listAuthenticators {
+		authenticators, err := h.listMFAAuthenticators(ctx, user)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "mfa_status_failed", "failed to load authenticators")
+			return
+		}
+		state["authenticators"] = authenticators
+		remaining, err := h.remainingMFARecoveryCodes(ctx, user.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "mfa_status_failed", "failed to load recovery codes")
+			return
+		}
+		state["recoveryCodesRemaining"] = remaining
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"enabled": user.MFAEnabled,
+		"enabled": len(methods) > 0,
 		"mfa":     state,
 		"user":    sanitizeUser(user, challenge),
 	})
@@ -2544,6 +2884,10 @@ func (h *handler) upsertSubscription(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "subscription_upsert_failed", "failed to persist subscription state")
 		return
 	}
+	if err := h.publishSubscriptionEvent(c.Request.Context(), sub); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"subscription": sanitizeSubscription(sub)})
 }
@@ -2598,6 +2942,10 @@ func (h *handler) cancelSubscription(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "subscription_cancel_failed", "failed to update subscription")
 		return
 	}
+	if err := h.publishSubscriptionEvent(c.Request.Context(), sub); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"subscription": sanitizeSubscription(sub)})
 }
@@ -2746,6 +3094,9 @@ func (h *handler) disableMFA(c *gin.Context) {
 	}
 
 	h.removeMFAChallengesForUser(user.ID)
+	h.revokeRefreshTokens(ctx, user.ID, store.RefreshTokenRevokedMFADisabled)
+	h.dropUnusedMFARecoveryCodes(ctx, user)
+	h.recordUserAudit(c, user, auditActionMFADisabled, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "mfa_disabled",
@@ -2781,6 +3132,14 @@ func (h *handler) oauthCallback(c *gin.Context) {
 		return
 	}
 
+	if nonce := oauthStateNonce(c.Query("state")); nonce != "" {
+		var link oauthLink
+		if h.takeAuthState(authStateOAuthLinks, nonce, &link) && link.Provider == providerName {
+			h.completeIdentityLink(c, nonce, link, provider, code)
+			return
+		}
+	}
+
 	token, err := provider.Exchange(c.Request.Context(), code)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "oauth_exchange_failed", "failed to exchange oauth code")
@@ -2805,7 +3164,12 @@ func (h *handler) oauthCallback(c *gin.Context) {
 
 	var user *store.User
 	ctx := c.Request.Context()
-	existingUser, err := h.store.GetUserByEmail(ctx, profile.Email)
+	// A linked identity signs in to its account even when the provider
+	// email differs from the account email.
+	existingUser, err := h.userForOAuthIdentity(ctx, providerName, profile.ID)
+	if errors.Is(err, store.ErrUserNotFound) {
+		existingUser, err = h.store.GetUserByEmail(ctx, profile.Email)
+	}
 	if err != nil && !errors.Is(err, store.ErrUserNotFound) {
 		respondError(c, http.StatusInternalServerError, "store_error", "database error")
 		return
@@ -2826,6 +3190,11 @@ func (h *handler) oauthCallback(c *gin.Context) {
 			respondError(c, http.StatusInternalServerError, "user_creation_failed", "failed to create user")
 			return
 		}
+		if err := h.publishUserCreated(ctx, user); err != nil {
+			h.rollbackCreatedUser(ctx, user)
+			respondError(c, http.StatusInternalServerError, "user_creation_failed", "failed to create user")
+			return
+		}
 
 		// Provision trial
 		trialExpiresAt := time.Now().UTC().Add(7 * 24 * time.Hour)
@@ -2839,7 +3208,9 @@ func (h *handler) oauthCallback(c *gin.Context) {
 			Status:        "active",
 			Meta:          map[string]any{"expiresAt": trialExpiresAt},
 		}
-		h.store.UpsertSubscription(ctx, trial)
+		if err := h.store.UpsertSubscription(ctx, trial); err == nil {
+			_ = h.publishSubscriptionEvent(ctx, trial)
+		}
 	} else {
 		user = existingUser
 		// Ensure user is verified if they logged in via OAuth
@@ -2847,6 +3218,9 @@ func (h *handler) oauthCallback(c *gin.Context) {
 			user.EmailVerified = true
 			if err := h.store.UpdateUser(ctx, user); err != nil {
 				slog.Warn("failed to update user verification status during oauth", "err", err, "userID", user.ID)
+			} else if err := h.publishUserEvent(ctx, webhook.EventUserVerified, user, nil); err != nil {
+				respondEventPublishFailed(c)
+				return
 			}
 		}
 	}
@@ -2858,13 +3232,12 @@ func (h *handler) oauthCallback(c *gin.Context) {
 		ExternalID: profile.ID,
 	}
 	if err := h.store.CreateIdentity(ctx, identity); err != nil {
-		// Only log error if it's not a "already exists" error
-		if !strings.Contains(err.Error(), "exists") {
+		if !errors.Is(err, store.ErrIdentityExists) {
 			slog.Warn("failed to create identity record during oauth binding", "err", err, "userID", user.ID)
 		}
 	}
 
-	sessionToken, sessionExpiresAt, err := h.createSession(user.ID)
+	sessionToken, sessionExpiresAt, err := h.createSession(c, user.ID, sessionAuthOAuthPrefix+providerName)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
 		return
@@ -2876,6 +3249,15 @@ func (h *handler) oauthCallback(c *gin.Context) {
 		return
 	}
 
+	targetURL := fmt.Sprintf("%s/login?exchange_code=%s",
+		strings.TrimSuffix(h.oauthRedirectFrontendURL(c), "/"),
+		url.QueryEscape(exchangeCode))
+	c.Redirect(http.StatusTemporaryRedirect, targetURL)
+}
+
+// oauthRedirectFrontendURL picks the frontend an OAuth callback returns to,
+// preferring the one recorded in the state parameter.
+func (h *handler) oauthRedirectFrontendURL(c *gin.Context) string {
 	frontendURL := h.validateFrontendURL(parseOAuthStateFrontendURL(c.Query("state")))
 	if frontendURL == "" {
 		frontendURL = h.resolveFrontendURL(c)
@@ -2886,10 +3268,23 @@ func (h *handler) oauthCallback(c *gin.Context) {
 	if frontendURL == "" {
 		frontendURL = "http://localhost:3000"
 	}
-	targetURL := fmt.Sprintf("%s/login?exchange_code=%s",
-		strings.TrimSuffix(frontendURL, "/"),
-		url.QueryEscape(exchangeCode))
-	c.Redirect(http.StatusTemporaryRedirect, targetURL)
+	return frontendURL
+}
+
+// userForOAuthIdentity returns the account a provider identity is linked to,
+// or store.ErrUserNotFound when it is not linked.
+func (h *handler) userForOAuthIdentity(ctx context.Context, provider, externalID string) (*store.User, error) {
+	if strings.TrimSpace(externalID) == "" {
+		return nil, store.ErrUserNotFound
+	}
+	identity, err := h.store.GetIdentity(ctx, provider, externalID)
+	if errors.Is(err, store.ErrIdentityNotFound) {
+		return nil, store.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.store.GetUserByID(ctx, identity.UserID)
 }
 
 func (h *handler) listUsers(c *gin.Context) {
@@ -2912,7 +3307,8 @@ func (h *handler) listUsers(c *gin.Context) {
 }
 
 func (h *handler) updateUserRole(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminUsersRoleWrite); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminUsersRoleWrite)
+	if !ok {
 		return
 	}
 
@@ -2945,11 +3341,11 @@ func (h *handler) updateUserRole(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to fetch user")
 		return
 	}
-	if h.isRootAccount(user) {
-		respondError(c, http.StatusForbidden, "root_protected", "root account role cannot be modified")
+	if h.rejectProtected(c, user, "root account role cannot be modified") {
 		return
 	}
 
+	before := auditUserFields(user)
 	user.Role = role
 	// Role field update will trigger Level update in store if implemented according to plan
 	// In store.go, normalizeUserRoleFields handles it.
@@ -2957,12 +3353,18 @@ func (h *handler) updateUserRole(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to update user")
 		return
 	}
+	h.recordUserChange(c, adminUser, auditActionUserRoleUpdate, user, before)
+	if err := h.publishRoleChange(c.Request.Context(), user, before); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "role updated", "user": sanitizeUser(user, nil)})
 }
 
 func (h *handler) resetUserRole(c *gin.Context) {
-	if _, ok := h.requireAdminPermission(c, permissionAdminUsersRoleWrite); !ok {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminUsersRoleWrite)
+	if !ok {
 		return
 	}
 
@@ -2981,16 +3383,21 @@ func (h *handler) resetUserRole(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to fetch user")
 		return
 	}
-	if h.isRootAccount(user) {
-		respondError(c, http.StatusForbidden, "root_protected", "root account role cannot be modified")
+	if h.rejectProtected(c, user, "root account role cannot be modified") {
 		return
 	}
 
+	before := auditUserFields(user)
 	user.Role = store.RoleUser
 	if err := h.store.UpdateUser(c.Request.Context(), user); err != nil {
 		respondError(c, http.StatusInternalServerError, "update_failed", "failed to update user")
 		return
 	}
+	h.recordUserChange(c, adminUser, auditActionUserRoleReset, user, before)
+	if err := h.publishRoleChange(c.Request.Context(), user, before); err != nil {
+		respondEventPublishFailed(c)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "role reset", "user": sanitizeUser(user, nil)})
 }
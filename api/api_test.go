@@ -3,19 +3,34 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/bcrypt"
@@ -23,8 +38,10 @@ import (
 
 	"account/internal/agentserver"
 	"account/internal/auth"
+	"account/internal/cache"
 	"account/internal/service"
 	"account/internal/store"
+	"account/internal/webhook"
 )
 
 type apiResponse struct {
@@ -2097,3 +2114,2505 @@ func TestAdminUsersMetricsSuccess(t *testing.T) {
 		t.Fatalf("unexpected weekly series: %+v", payload.Series.Weekly)
 	}
 }
+
+func TestPasswordResetAcrossReplicasWithSharedAuthState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	states := cache.NewMemoryStateStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("originalPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if err := st.CreateUser(context.Background(), &store.User{
+		Name:          "Replica User",
+		Email:         "replica@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	mailer := &testEmailSender{}
+	replicaA := gin.New()
+	RegisterRoutes(replicaA, WithStore(st), WithAuthStateStore(states), WithEmailSender(mailer))
+	replicaB := gin.New()
+	RegisterRoutes(replicaB, WithStore(st), WithAuthStateStore(states), WithEmailSender(mailer))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password/reset", strings.NewReader(`{"email":"replica@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	replicaA.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected reset request to return 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	msg, ok := mailer.last()
+	if !ok {
+		t.Fatalf("expected password reset email to be sent")
+	}
+	resetToken := extractTokenFromMessage(t, msg)
+
+	confirmBody := fmt.Sprintf(`{"token":%q,"password":"newSecurePass2"}`, resetToken)
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/password/reset/confirm", strings.NewReader(confirmBody))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	replicaB.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected reset confirmation on the other replica, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/password/reset/confirm", strings.NewReader(confirmBody))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	replicaA.ServeHTTP(rr, req)
+	if rr.Code == http.StatusOK {
+		t.Fatalf("expected reset token to be single use across replicas")
+	}
+}
+
+type sessionLookupCountingStore struct {
+	store.Store
+	mu      sync.Mutex
+	lookups int
+}
+
+func (s *sessionLookupCountingStore) GetSession(ctx context.Context, token string) (string, time.Time, error) {
+	s.mu.Lock()
+	s.lookups++
+	s.mu.Unlock()
+	return s.Store.GetSession(ctx, token)
+}
+
+func (s *sessionLookupCountingStore) sessionLookups() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups
+}
+
+func TestSessionCacheServesAuthenticatedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := &sessionLookupCountingStore{Store: store.NewMemoryStore()}
+	user := &store.User{
+		Name:          "Cached Session",
+		Email:         "cached-session@example.com",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	token := "cached-session-token"
+	if err := st.CreateSession(ctx, token, user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithSessionCache(cache.NewMemoryCache(time.Minute)),
+		WithTokenService(auth.NewTokenService(auth.TokenConfig{
+			PublicToken:   "public-token",
+			RefreshSecret: "refresh-secret",
+			AccessSecret:  "access-secret",
+			AccessExpiry:  time.Hour,
+			RefreshExpiry: time.Hour,
+			Store:         st,
+		})),
+	)
+
+	getSession := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/session", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := getSession(); code != http.StatusOK {
+			t.Fatalf("expected session lookup success, got %d", code)
+		}
+	}
+	if lookups := st.sessionLookups(); lookups != 1 {
+		t.Fatalf("expected a single store lookup behind the cache, got %d", lookups)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/auth/session", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected logout success, got %d", rr.Code)
+	}
+	if code := getSession(); code != http.StatusUnauthorized {
+		t.Fatalf("expected logout to evict the cached session, got %d", code)
+	}
+}
+
+func TestSessionInventoryAndRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("inventoryPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "Inventory User",
+		Email:         "inventory@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	admin := &store.User{
+		Name:          "Inventory Admin",
+		Email:         "inventory-admin@example.com",
+		EmailVerified: true,
+		Role:          store.RoleAdmin,
+		Level:         store.LevelAdmin,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, admin); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	adminToken := "inventory-admin-token"
+	if err := st.CreateSession(ctx, adminToken, admin.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create admin session: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithSessionCache(cache.NewMemoryCache(time.Minute)))
+
+	login := func(userAgent string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"identifier":"inventory@example.com","password":"inventoryPass1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return decodeResponse(t, rr).Token
+	}
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type sessionList struct {
+		Sessions []struct {
+			ID         string `json:"id"`
+			UserAgent  string `json:"userAgent"`
+			AuthMethod string `json:"authMethod"`
+			Current    bool   `json:"current"`
+		} `json:"sessions"`
+	}
+	list := func(path, token string) sessionList {
+		rr := do(http.MethodGet, path, token)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected session list from %s, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		var out sessionList
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode sessions: %v", err)
+		}
+		return out
+	}
+
+	laptop := login("laptop-browser")
+	phone := login("phone-app")
+	tablet := login("tablet-app")
+
+	sessions := list("/api/auth/sessions", laptop)
+	if len(sessions.Sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %+v", sessions)
+	}
+	var phoneID string
+	for _, sess := range sessions.Sessions {
+		if sess.AuthMethod != "password" {
+			t.Fatalf("expected password auth method, got %q", sess.AuthMethod)
+		}
+		if sess.Current != (sess.UserAgent == "laptop-browser") {
+			t.Fatalf("unexpected current flag on %+v", sess)
+		}
+		if sess.UserAgent == "phone-app" {
+			phoneID = sess.ID
+		}
+	}
+
+	if rr := do(http.MethodDelete, "/api/auth/sessions/"+phoneID, adminToken); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other users' sessions to be hidden, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/auth/sessions/"+phoneID, laptop); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected session revocation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/auth/session", phone); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session to be rejected, got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodDelete, "/api/auth/sessions?exceptCurrent=true", laptop); rr.Code != http.StatusOK {
+		t.Fatalf("expected sign out of other sessions, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/auth/session", tablet); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected other sessions to be revoked, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/auth/session", laptop); rr.Code != http.StatusOK {
+		t.Fatalf("expected current session to survive, got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, "/api/auth/admin/users/"+user.ID+"/sessions", laptop); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admins to be denied, got %d", rr.Code)
+	}
+	adminView := list("/api/auth/admin/users/"+user.ID+"/sessions", adminToken)
+	if len(adminView.Sessions) != 1 || adminView.Sessions[0].Current {
+		t.Fatalf("unexpected admin session view: %+v", adminView)
+	}
+	if rr := do(http.MethodDelete, "/api/auth/admin/users/"+user.ID+"/sessions", adminToken); rr.Code != http.StatusOK {
+		t.Fatalf("expected admin revocation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/auth/session", laptop); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected admin revocation to end the session, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/auth/session", adminToken); rr.Code != http.StatusOK {
+		t.Fatalf("expected admin session to be unaffected, got %d", rr.Code)
+	}
+
+	root := &store.User{
+		Name:          "Root",
+		Email:         store.RootAdminEmail,
+		EmailVerified: true,
+		Role:          store.RoleRoot,
+		Level:         store.LevelAdmin,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, root); err != nil {
+		t.Fatalf("create root: %v", err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rr := do(method, "/api/auth/admin/users/"+root.ID+"/sessions", adminToken); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "root_protected") {
+			t.Fatalf("expected %s of root sessions to be refused, got %d: %s", method, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestRefreshTokenRotationAndReuseDetection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("rotationPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "Rotation User",
+		Email:         "rotation@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithTokenService(auth.NewTokenService(auth.TokenConfig{
+			PublicToken:   "public-token",
+			RefreshSecret: "refresh-secret",
+			AccessSecret:  "access-secret",
+			AccessExpiry:  time.Hour,
+			RefreshExpiry: time.Hour,
+			Store:         st,
+		})),
+	)
+
+	login := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"identifier":"rotation@example.com","password":"rotationPass1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.RefreshToken == "" {
+			t.Fatalf("expected refresh token in login response: %s", rr.Body.String())
+		}
+		return body.RefreshToken
+	}
+	refresh := func(token string) (int, string, string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/token/refresh", strings.NewReader(fmt.Sprintf(`{"refresh_token":%q}`, token)))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var body struct {
+			Error        string `json:"error"`
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &body)
+		if rr.Code == http.StatusOK && (body.AccessToken == "" || body.RefreshToken == "") {
+			t.Fatalf("expected rotated token pair: %s", rr.Body.String())
+		}
+		return rr.Code, body.Error, body.RefreshToken
+	}
+
+	first := login()
+	code, _, second := refresh(first)
+	if code != http.StatusOK || second == first {
+		t.Fatalf("expected rotation to issue a new refresh token, got %d", code)
+	}
+	code, _, third := refresh(second)
+	if code != http.StatusOK {
+		t.Fatalf("expected rotated token to refresh, got %d", code)
+	}
+
+	if code, errCode, _ := refresh(first); code != http.StatusUnauthorized || errCode != "refresh_token_reused" {
+		t.Fatalf("expected replay to be detected, got %d %q", code, errCode)
+	}
+	if code, errCode, _ := refresh(third); code != http.StatusUnauthorized || errCode != "refresh_token_revoked" {
+		t.Fatalf("expected replay to revoke the whole family, got %d %q", code, errCode)
+	}
+
+	other := login()
+	user.Active = false
+	if err := st.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("pause user: %v", err)
+	}
+	if code, errCode, _ := refresh(other); code != http.StatusUnauthorized || errCode != "refresh_token_revoked" {
+		t.Fatalf("expected paused user's refresh token to be revoked, got %d %q", code, errCode)
+	}
+}
+
+func TestAsymmetricAccessTokensRotateAndPublishJWKS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("jwksPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "JWKS User",
+		Email:         "jwks@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	keys := auth.NewKeySet()
+	rotator := auth.NewSigningKeyRotator(st, keys, auth.AlgorithmEdDSA, time.Hour)
+	rotator.PublishAhead = 0
+	if err := rotator.Rotate(ctx); err != nil {
+		t.Fatalf("initial rotation: %v", err)
+	}
+	tokenService := auth.NewTokenService(auth.TokenConfig{
+		PublicToken:   "public-token",
+		RefreshSecret: "refresh-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: time.Hour,
+		Store:         st,
+		SigningKeys:   keys,
+	})
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithTokenService(tokenService))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"identifier":"jwks@example.com","password":"jwksPass1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var login struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &login); err != nil || login.RefreshToken == "" {
+		t.Fatalf("expected refresh token in login response: %d %s", rr.Code, rr.Body.String())
+	}
+	refreshToken := login.RefreshToken
+	issueAccessToken := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/token/refresh", strings.NewReader(fmt.Sprintf(`{"refresh_token":%q}`, refreshToken)))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var body struct {
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("expected refresh success, got %d: %s", rr.Code, rr.Body.String())
+		}
+		refreshToken = body.RefreshToken
+		return body.AccessToken
+	}
+	fetchJWKS := func() auth.JWKSet {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+		if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") == "" {
+			t.Fatalf("expected cacheable jwks, got %d", rr.Code)
+		}
+		var set auth.JWKSet
+		if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+			t.Fatalf("decode jwks: %v", err)
+		}
+		return set
+	}
+	// verifyOffline checks a token using nothing but the published JWKS.
+	verifyOffline := func(accessToken string) string {
+		set := fetchJWKS()
+		token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			for _, jwk := range set.Keys {
+				if jwk.KeyID == kid && jwk.KeyType == "OKP" {
+					x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+					return ed25519.PublicKey(x), err
+				}
+			}
+			return nil, fmt.Errorf("kid %q not published", kid)
+		}, jwt.WithValidMethods([]string{auth.AlgorithmEdDSA}))
+		if err != nil || !token.Valid {
+			t.Fatalf("expected token to verify against jwks: %v", err)
+		}
+		return token.Header["kid"].(string)
+	}
+
+	first := issueAccessToken()
+	firstKid := verifyOffline(first)
+
+	rotator.RotateEvery = 0
+	if err := rotator.Rotate(ctx); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if got := len(fetchJWKS().Keys); got != 2 {
+		t.Fatalf("expected old and new key to be published, got %d", got)
+	}
+	second := issueAccessToken()
+	if secondKid := verifyOffline(second); secondKid == firstKid {
+		t.Fatalf("expected rotated key to sign new tokens")
+	}
+	if _, err := tokenService.ValidateAccessToken(first); err != nil {
+		t.Fatalf("expected token from superseded key to keep validating: %v", err)
+	}
+
+	rotator.RetireAfter = 0
+	if err := rotator.Rotate(ctx); err != nil {
+		t.Fatalf("rotate and retire: %v", err)
+	}
+	if got := len(fetchJWKS().Keys); got != 1 {
+		t.Fatalf("expected superseded keys to be retired, got %d published", got)
+	}
+	if _, err := tokenService.ValidateAccessToken(first); err == nil {
+		t.Fatalf("expected token from retired key to be rejected")
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": user.ID, "exp": time.Now().Add(time.Hour).Unix()})
+	forgedString, err := forged.SignedString([]byte(""))
+	if err != nil {
+		t.Fatalf("sign hmac token: %v", err)
+	}
+	if _, err := tokenService.ValidateAccessToken(forgedString); err == nil {
+		t.Fatalf("expected hmac token to be rejected without an access secret")
+	}
+
+	// With an access secret, HS256 tokens only outlive the switch to
+	// asymmetric keys during an explicit transition.
+	legacy, err := auth.NewTokenService(auth.TokenConfig{AccessSecret: "access-secret", AccessExpiry: time.Hour, RefreshExpiry: time.Hour, RefreshSecret: "refresh-secret", Store: st}).GenerateTokenPair(ctx, user.ID, user.Email, []string{user.Role})
+	if err != nil {
+		t.Fatalf("issue hmac token: %v", err)
+	}
+	for _, tc := range []struct {
+		until  time.Time
+		accept bool
+	}{
+		{time.Time{}, false},
+		{time.Now().Add(time.Hour), true},
+		{time.Now().Add(-time.Minute), false},
+	} {
+		service := auth.NewTokenService(auth.TokenConfig{AccessSecret: "access-secret", SigningKeys: keys, HMACTransitionUntil: tc.until})
+		if _, err := service.ValidateAccessToken(legacy.AccessToken); (err == nil) != tc.accept {
+			t.Fatalf("transition until %v: expected accepted=%v, got %v", tc.until, tc.accept, err)
+		}
+	}
+
+	generated, err := auth.GenerateSigningKey(auth.AlgorithmRS256)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	encoded, err := generated.MarshalPEM()
+	if err != nil {
+		t.Fatalf("marshal rsa key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, encoded, 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	loaded, err := auth.LoadSigningKeyFile(path, "")
+	if err != nil {
+		t.Fatalf("load key file: %v", err)
+	}
+	if loaded.Algorithm != auth.AlgorithmRS256 || loaded.ID != generated.ID {
+		t.Fatalf("expected file key to keep its thumbprint kid, got %s %s", loaded.Algorithm, loaded.ID)
+	}
+}
+
+func TestOIDCAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("oidcPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "OIDC User",
+		Email:         "oidc@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Groups:        []string{"ops"},
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := st.EnsureTenant(ctx, &store.Tenant{ID: "tenant-a", Name: "Tenant A", Edition: "private"}); err != nil {
+		t.Fatalf("ensure tenant: %v", err)
+	}
+	if err := st.UpsertTenantMembership(ctx, &store.TenantMembership{TenantID: "tenant-a", UserID: user.ID, Role: store.TenantMembershipRoleAdmin}); err != nil {
+		t.Fatalf("upsert membership: %v", err)
+	}
+
+	secretHash, err := bcrypt.GenerateFromPassword([]byte("client-secret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash client secret: %v", err)
+	}
+	const redirectURI = "https://console.example.com/callback"
+	if err := st.CreateOIDCClient(ctx, &store.OIDCClient{
+		ID:           "console",
+		Name:         "Console",
+		SecretHash:   string(secretHash),
+		RedirectURIs: []string{redirectURI},
+	}); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	signingKey, err := auth.GenerateSigningKey(auth.AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+	const issuer = "https://accounts.example.com"
+	tokenService := auth.NewTokenService(auth.TokenConfig{
+		PublicToken:   "public-token",
+		RefreshSecret: "refresh-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: time.Hour,
+		Store:         st,
+		SigningKeys:   auth.NewKeySet(signingKey),
+	})
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithTokenService(tokenService),
+		WithOIDCProvider(OIDCConfig{Issuer: issuer, LoginURL: "https://console.example.com/login"}),
+	)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	var discovery struct {
+		Issuer        string   `json:"issuer"`
+		TokenEndpoint string   `json:"token_endpoint"`
+		Algorithms    []string `json:"id_token_signing_alg_values_supported"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &discovery); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected discovery document, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if discovery.Issuer != issuer || discovery.TokenEndpoint != issuer+"/oidc/token" || len(discovery.Algorithms) != 1 || discovery.Algorithms[0] != auth.AlgorithmEdDSA {
+		t.Fatalf("unexpected discovery document: %+v", discovery)
+	}
+
+	const verifier = "dBjftJeZ4CVP-mJ92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const challenge = "ngF5GsXcbwljx6u133FFr3Xht9xooA_DuaX_3QwODtc"
+	authorize := func(sessionToken string, extra url.Values) *url.URL {
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"console"},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"openid email profile"},
+			"state":                 {"state-1"},
+			"nonce":                 {"nonce-1"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+		for key, values := range extra {
+			query[key] = values
+		}
+		req := httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+query.Encode(), nil)
+		if sessionToken != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionToken})
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusFound {
+			t.Fatalf("expected authorize redirect, got %d: %s", rr.Code, rr.Body.String())
+		}
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("parse redirect: %v", err)
+		}
+		return location
+	}
+
+	if location := authorize("", nil); location.Host != "console.example.com" || location.Path != "/login" ||
+		!strings.HasPrefix(location.Query().Get("redirect"), issuer+"/oidc/authorize?") {
+		t.Fatalf("expected redirect to sign-in page, got %s", location)
+	}
+	if location := authorize("", url.Values{"prompt": {"none"}}); location.Query().Get("error") != "login_required" || location.Query().Get("state") != "state-1" {
+		t.Fatalf("expected login_required for prompt=none, got %s", location)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/oidc/authorize?client_id=console&redirect_uri=https://evil.example.com/cb", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unregistered redirect uri to be rejected without redirect, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"identifier":"oidc@example.com","password":"oidcPass1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &login); err != nil || login.Token == "" {
+		t.Fatalf("expected session token from login: %d %s", rr.Code, rr.Body.String())
+	}
+
+	exchange := func(code, codeVerifier string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {codeVerifier},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("console", "client-secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	location := authorize(login.Token, nil)
+	if location.Query().Get("state") != "state-1" || location.Query().Get("code") == "" {
+		t.Fatalf("expected authorization code, got %s", location)
+	}
+	if rr := exchange(location.Query().Get("code"), strings.Repeat("x", 43)); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_grant") {
+		t.Fatalf("expected wrong code_verifier to fail, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	code := authorize(login.Token, nil).Query().Get("code")
+	rr = exchange(code, verifier)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected token response, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Scope       string `json:"scope"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &tokens); err != nil || tokens.IDToken == "" || tokens.AccessToken == "" {
+		t.Fatalf("decode token response: %s", rr.Body.String())
+	}
+	if rr := exchange(code, verifier); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected authorization code replay to fail, got %d", rr.Code)
+	}
+
+	var idClaims auth.IDTokenClaims
+	if _, err := jwt.ParseWithClaims(tokens.IDToken, &idClaims, func(token *jwt.Token) (interface{}, error) {
+		return signingKey.Private.Public(), nil
+	}, jwt.WithIssuer(issuer), jwt.WithAudience("console"), jwt.WithValidMethods([]string{auth.AlgorithmEdDSA})); err != nil {
+		t.Fatalf("verify id token: %v", err)
+	}
+	if idClaims.Subject != user.ID || idClaims.Nonce != "nonce-1" || idClaims.Role != store.RoleUser ||
+		len(idClaims.Groups) != 1 || idClaims.Groups[0] != "ops" || idClaims.Email != "oidc@example.com" ||
+		len(idClaims.Tenants) != 1 || idClaims.Tenants[0].ID != "tenant-a" || idClaims.Tenants[0].Role != store.TenantMembershipRoleAdmin ||
+		len(idClaims.AuthMethods) != 1 || idClaims.AuthMethods[0] != "pwd" || idClaims.AuthTime == nil {
+		t.Fatalf("unexpected id token claims: %+v", idClaims)
+	}
+
+	userInfo := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = userInfo(tokens.AccessToken)
+	var info struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil || rr.Code != http.StatusOK || info.Subject != user.ID || info.Email != user.Email || info.Name != user.Name {
+		t.Fatalf("unexpected userinfo response %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := userInfo(login.Token); rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected session token to be rejected by userinfo, got %d", rr.Code)
+	}
+
+	// Client tokens are not first-party credentials, and first-party tokens
+	// are not good for userinfo.
+	if _, err := tokenService.ValidateAccessToken(tokens.AccessToken); err == nil {
+		t.Fatalf("expected a client access token to be rejected as a first-party token")
+	}
+	if _, err := tokenService.ValidateAccessToken(tokens.IDToken); err == nil {
+		t.Fatalf("expected an id token to be rejected as a first-party token")
+	}
+	pair, err := tokenService.GenerateTokenPair(ctx, user.ID, user.Email, []string{user.Role})
+	if err != nil {
+		t.Fatalf("generate token pair: %v", err)
+	}
+	if _, err := tokenService.ValidateAccessToken(pair.AccessToken); err != nil {
+		t.Fatalf("expected a first-party access token to validate: %v", err)
+	}
+	if rr := userInfo(pair.AccessToken); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a first-party access token to be rejected by userinfo, got %d", rr.Code)
+	}
+	if err := st.DeleteOIDCClient(ctx, "console"); err != nil {
+		t.Fatalf("delete client: %v", err)
+	}
+	if rr := userInfo(tokens.AccessToken); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected tokens of a deleted client to be rejected by userinfo, got %d", rr.Code)
+	}
+}
+
+func TestOIDCTokensReportSessionAuthMethods(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	user := &store.User{
+		Name:          "OIDC MFA User",
+		Email:         "oidc-mfa@example.com",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	secretHash, err := bcrypt.GenerateFromPassword([]byte("client-secret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash client secret: %v", err)
+	}
+	const redirectURI = "https://console.example.com/callback"
+	if err := st.CreateOIDCClient(ctx, &store.OIDCClient{
+		ID:           "console",
+		Name:         "Console",
+		SecretHash:   string(secretHash),
+		RedirectURIs: []string{redirectURI},
+	}); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	signingKey, err := auth.GenerateSigningKey(auth.AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+	const issuer = "https://accounts.example.com"
+	tokenService := auth.NewTokenService(auth.TokenConfig{
+		PublicToken:   "public-token",
+		RefreshSecret: "refresh-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: time.Hour,
+		Store:         st,
+		SigningKeys:   auth.NewKeySet(signingKey),
+	})
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithTokenService(tokenService),
+		WithOIDCProvider(OIDCConfig{Issuer: issuer, LoginURL: "https://console.example.com/login"}),
+	)
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return signingKey.Private.Public(), nil
+	}
+
+	const verifier = "dBjftJeZ4CVP-mJ92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const challenge = "ngF5GsXcbwljx6u133FFr3Xht9xooA_DuaX_3QwODtc"
+	cases := []struct {
+		method string
+		amr    []string
+		mfa    bool
+	}{
+		{method: sessionAuthPassword, amr: []string{"pwd"}},
+		{method: sessionAuthTOTP, amr: []string{"pwd", "otp", "mfa"}, mfa: true},
+		{method: sessionAuthWebAuthn, amr: []string{"pwd", "hwk", "mfa"}, mfa: true},
+		{method: sessionAuthRecoveryCode, amr: []string{"pwd", "otp", "mfa"}, mfa: true},
+		{method: sessionAuthPasskey, amr: []string{"hwk", "mfa"}, mfa: true},
+	}
+	for _, tc := range cases {
+		session := &store.Session{
+			Token:      "session-" + tc.method,
+			UserID:     user.ID,
+			AuthMethod: tc.method,
+			ExpiresAt:  time.Now().Add(time.Hour),
+		}
+		if err := st.CreateSessionRecord(ctx, session); err != nil {
+			t.Fatalf("%s: create session: %v", tc.method, err)
+		}
+
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"console"},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"openid"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+		req := httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+query.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Token})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if rr.Code != http.StatusFound || err != nil || location.Query().Get("code") == "" {
+			t.Fatalf("%s: expected authorization code, got %d: %s", tc.method, rr.Code, rr.Header().Get("Location"))
+		}
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {location.Query().Get("code")},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		}
+		req = httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("console", "client-secret")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var tokens struct {
+			AccessToken string `json:"access_token"`
+			IDToken     string `json:"id_token"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &tokens); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected token response, got %d: %s", tc.method, rr.Code, rr.Body.String())
+		}
+
+		var idClaims auth.IDTokenClaims
+		if _, err := jwt.ParseWithClaims(tokens.IDToken, &idClaims, keyFunc, jwt.WithIssuer(issuer), jwt.WithAudience("console")); err != nil {
+			t.Fatalf("%s: verify id token: %v", tc.method, err)
+		}
+		if !slices.Equal(idClaims.AuthMethods, tc.amr) {
+			t.Fatalf("%s: expected amr %v, got %v", tc.method, tc.amr, idClaims.AuthMethods)
+		}
+		var accessClaims auth.Claims
+		if _, err := jwt.ParseWithClaims(tokens.AccessToken, &accessClaims, keyFunc, jwt.WithIssuer(issuer), jwt.WithAudience("console")); err != nil {
+			t.Fatalf("%s: verify access token: %v", tc.method, err)
+		}
+		if accessClaims.MFA != tc.mfa {
+			t.Fatalf("%s: expected mfa_verified %v, got %v", tc.method, tc.mfa, accessClaims.MFA)
+		}
+	}
+}
+
+func TestGenericOIDCProviderSignsInThroughDiscoveredIdP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	idpKey, err := auth.GenerateSigningKey(auth.AlgorithmRS256)
+	if err != nil {
+		t.Fatalf("generate idp key: %v", err)
+	}
+	const clientID = "account-svc"
+	audience := clientID
+
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 idp.URL,
+				"authorization_endpoint": idp.URL + "/authorize",
+				"token_endpoint":         idp.URL + "/token",
+				"userinfo_endpoint":      idp.URL + "/userinfo",
+				"jwks_uri":               idp.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(auth.JWKSet{Keys: []auth.JWK{idpKey.PublicJWK()}})
+		case "/token":
+			if r.FormValue("code") != "idp-code" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			now := time.Now()
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"iss":            idp.URL,
+				"sub":            "idp-user-42",
+				"aud":            audience,
+				"iat":            now.Unix(),
+				"exp":            now.Add(time.Minute).Unix(),
+				"email":          "Idp.User@example.com",
+				"email_verified": "true",
+			})
+			token.Header["kid"] = idpKey.ID
+			idToken, err := token.SignedString(idpKey.Private)
+			if err != nil {
+				t.Errorf("sign id token: %v", err)
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "idp-access-token",
+				"token_type":   "Bearer",
+				"expires_in":   300,
+				"id_token":     idToken,
+			})
+		case "/userinfo":
+			if r.Header.Get("Authorization") != "Bearer idp-access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"sub":                "idp-user-42",
+				"preferred_username": "idp.user",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+
+	provider, err := auth.NewGenericOIDCProvider(context.Background(), auth.GenericOIDCConfig{
+		Name:         "corp",
+		Issuer:       idp.URL + "/",
+		ClientID:     clientID,
+		ClientSecret: "idp-secret",
+		RedirectURL:  "https://accounts.svc.plus/api/auth/oauth/callback/corp",
+	})
+	if err != nil {
+		t.Fatalf("create generic oidc provider: %v", err)
+	}
+	if _, err := auth.NewGenericOIDCProvider(context.Background(), auth.GenericOIDCConfig{
+		Name:     "Bad Name",
+		Issuer:   idp.URL,
+		ClientID: clientID,
+	}); err == nil {
+		t.Fatalf("expected invalid provider name to be rejected")
+	}
+
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithOAuthProviders(map[string]auth.OAuthProvider{"corp": provider}),
+		WithOAuthFrontendURL("https://console.svc.plus"),
+	)
+
+	loginRec := httptest.NewRecorder()
+	router.ServeHTTP(loginRec, httptest.NewRequest(http.MethodGet, "/api/auth/oauth/login/corp", nil))
+	authorizeURL, err := url.Parse(loginRec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authorizeURL.String(), idp.URL+"/authorize") {
+		t.Fatalf("expected redirect to discovered authorize endpoint, got %d %q", loginRec.Code, loginRec.Header().Get("Location"))
+	}
+	if got := authorizeURL.Query().Get("scope"); got != "openid email profile" {
+		t.Fatalf("expected default openid scopes, got %q", got)
+	}
+
+	callback := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oauth/callback/corp?code=idp-code", nil))
+		return rec
+	}
+
+	audience = "someone-else"
+	if rec := callback(); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected id token for another audience to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := st.GetUserByEmail(context.Background(), "idp.user@example.com"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("expected no user for rejected id token, got %v", err)
+	}
+
+	audience = clientID
+	rec := callback()
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected oauth callback redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+	redirectURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || redirectURL.Query().Get("exchange_code") == "" {
+		t.Fatalf("expected exchange code in redirect, got %q", rec.Header().Get("Location"))
+	}
+
+	user, err := st.GetUserByEmail(context.Background(), "idp.user@example.com")
+	if err != nil {
+		t.Fatalf("expected user provisioned from id token claims: %v", err)
+	}
+	if user.Name != "idp.user" || !user.EmailVerified {
+		t.Fatalf("expected name from userinfo and verified email, got %q verified=%v", user.Name, user.EmailVerified)
+	}
+}
+
+func TestLinkListAndUnlinkOAuthIdentities(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("linkingPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	owner := &store.User{
+		Name:          "Link Owner",
+		Email:         "owner@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	oauthOnly := &store.User{
+		Name:          "OAuth Only",
+		Email:         "oauth-only@example.com",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	for _, user := range []*store.User{owner, oauthOnly} {
+		if err := st.CreateUser(ctx, user); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	if err := st.CreateSession(ctx, "owner-token", owner.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create owner session: %v", err)
+	}
+	if err := st.CreateSession(ctx, "oauth-only-token", oauthOnly.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create oauth-only session: %v", err)
+	}
+	oauthOnlyIdentity := &store.Identity{UserID: oauthOnly.ID, Provider: "google", ExternalID: "google-7"}
+	if err := st.CreateIdentity(ctx, oauthOnlyIdentity); err != nil {
+		t.Fatalf("create identity: %v", err)
+	}
+
+	github := &stubOAuthProvider{profile: &auth.OAuthUserProfile{
+		ID:       "gh-1001",
+		Email:    "someone.else@example.com",
+		Name:     "gh-user",
+		Verified: true,
+	}}
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithOAuthProviders(map[string]auth.OAuthProvider{"github": github}),
+		WithOAuthFrontendURL("https://console.svc.plus"),
+	)
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	// link starts a link with token and completes it in a browser that
+	// carries the link cookie when sameBrowser is set.
+	link := func(token string, sameBrowser bool) *url.URL {
+		rr := do(http.MethodPost, "/api/auth/identities/github/link", token)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected link to start, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var body struct {
+			AuthorizationURL string `json:"authorizationUrl"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode link response: %v", err)
+		}
+		authorizeURL, err := url.Parse(body.AuthorizationURL)
+		if err != nil {
+			t.Fatalf("parse authorization url: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oauth/callback/github?code=link-code&state="+url.QueryEscape(authorizeURL.Query().Get("state")), nil)
+		if sameBrowser {
+			for _, cookie := range rr.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+		}
+		callback := httptest.NewRecorder()
+		router.ServeHTTP(callback, req)
+		if callback.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("expected link callback redirect, got %d: %s", callback.Code, callback.Body.String())
+		}
+		target, err := url.Parse(callback.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("parse link redirect: %v", err)
+		}
+		return target
+	}
+
+	if target := link("owner-token", false); target.Query().Get("oauth_link_error") != "link_session_mismatch" {
+		t.Fatalf("expected a link completed in another browser to be refused, got %q", target.String())
+	}
+	if identities, _ := st.ListIdentitiesByUser(ctx, owner.ID); len(identities) != 0 {
+		t.Fatalf("expected no identity after a refused link, got %+v", identities)
+	}
+	if target := link("owner-token", true); target.Path != "/panel/account" || target.Query().Get("oauth_link") != "linked" {
+		t.Fatalf("expected identity to be linked, got %q", target.String())
+	}
+	if _, err := st.GetUserByEmail(ctx, "someone.else@example.com"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("expected linking not to create a user, got %v", err)
+	}
+	if target := link("oauth-only-token", true); target.Query().Get("oauth_link_error") != "identity_already_linked" {
+		t.Fatalf("expected identity linked elsewhere to be rejected, got %q", target.String())
+	}
+
+	rr := do(http.MethodGet, "/api/auth/identities", "owner-token")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected identity list, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Identities []struct {
+			ID         string `json:"id"`
+			Provider   string `json:"provider"`
+			ExternalID string `json:"externalId"`
+		} `json:"identities"`
+		HasPassword bool `json:"hasPassword"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode identities: %v", err)
+	}
+	if len(listed.Identities) != 1 || listed.Identities[0].ExternalID != "gh-1001" || !listed.HasPassword {
+		t.Fatalf("unexpected identity list: %+v", listed)
+	}
+
+	signIn := do(http.MethodGet, "/api/auth/oauth/callback/github?code=signin-code", "")
+	if signIn.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected oauth sign-in redirect, got %d: %s", signIn.Code, signIn.Body.String())
+	}
+	sessions, err := st.ListSessionsByUser(ctx, owner.ID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("expected linked identity to sign in to the owner account, got %d sessions (%v)", len(sessions), err)
+	}
+
+	if rr := do(http.MethodDelete, "/api/auth/identities/"+oauthOnlyIdentity.ID, "owner-token"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected another user's identity to be hidden, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/auth/identities/"+oauthOnlyIdentity.ID, "oauth-only-token"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected last login method to be kept, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodDelete, "/api/auth/identities/"+listed.Identities[0].ID, "owner-token"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected unlink to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if identities, _ := st.ListIdentitiesByUser(ctx, owner.ID); len(identities) != 0 {
+		t.Fatalf("expected identity to be removed, got %+v", identities)
+	}
+}
+
+// softwareAuthenticator is a minimal ES256 WebAuthn authenticator with "none"
+// attestation, used to drive registration and assertion ceremonies.
+type softwareAuthenticator struct {
+	t            *testing.T
+	rpID, origin string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+}
+
+func newSoftwareAuthenticator(t *testing.T, rpID, origin string) *softwareAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate authenticator key: %v", err)
+	}
+	return &softwareAuthenticator{t: t, rpID: rpID, origin: origin, key: key, credentialID: []byte("software-credential-1")}
+}
+
+func (a *softwareAuthenticator) clientData(ceremony string, options json.RawMessage) []byte {
+	var parsed struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &parsed); err != nil || parsed.PublicKey.Challenge == "" {
+		a.t.Fatalf("expected webauthn options with a challenge, got %s", options)
+	}
+	data, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": parsed.PublicKey.Challenge, "origin": a.origin})
+	return data
+}
+
+func (a *softwareAuthenticator) authData(flags byte, counter uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, counter)
+	return append(data, attested...)
+}
+
+func (a *softwareAuthenticator) register(options json.RawMessage) []byte {
+	coseKey, err := cbor.Marshal(map[int]any{
+		1:  2,
+		3:  -7,
+		-1: 1,
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatalf("encode cose key: %v", err)
+	}
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), coseKey...)
+	attestationObject, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(0x45, 0, attested),
+	})
+	if err != nil {
+		a.t.Fatalf("encode attestation object: %v", err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	response, _ := json.Marshal(map[string]any{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(a.clientData("webauthn.create", options)),
+			"attestationObject": encode(attestationObject),
+		},
+	})
+	return response
+}
+
+func (a *softwareAuthenticator) assert(options json.RawMessage, counter uint32, userHandle string) []byte {
+	clientData := a.clientData("webauthn.get", options)
+	authData := a.authData(0x05, counter, nil)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("sign assertion: %v", err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	response, _ := json.Marshal(map[string]any{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode([]byte(userHandle)),
+		},
+	})
+	return response
+}
+
+func TestWebAuthnPasskeyAsSecondFactorAndPasswordlessLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("passkeyPass1"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "Passkey User",
+		Email:         "passkey@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := st.CreateSession(ctx, "passkey-session", user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithWebAuthn(WebAuthnConfig{
+		RPID:      "console.svc.plus",
+		RPOrigins: []string{"https://console.svc.plus"},
+	}))
+	authenticator := newSoftwareAuthenticator(t, "console.svc.plus", "https://console.svc.plus")
+
+	post := func(path, token string, body any) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type ceremonyResponse struct {
+		CeremonyID string          `json:"ceremonyId"`
+		Options    json.RawMessage `json:"options"`
+	}
+	decodeCeremony := func(rr *httptest.ResponseRecorder) ceremonyResponse {
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected ceremony options, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var out ceremonyResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode ceremony: %v", err)
+		}
+		return out
+	}
+
+	registration := decodeCeremony(post("/api/auth/mfa/webauthn/register/begin", "passkey-session", map[string]string{"name": "Laptop"}))
+	rr := post("/api/auth/mfa/webauthn/register/finish", "passkey-session", map[string]any{
+		"ceremonyId": registration.CeremonyID,
+		"credential": json.RawMessage(authenticator.register(registration.Options)),
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected passkey registration, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The password step now requires the passkey as a second factor.
+	rr = post("/api/auth/login", "", map[string]string{"identifier": "passkey@example.com", "password": "passkeyPass1"})
+	var loginResp struct {
+		MFARequired bool     `json:"mfaRequired"`
+		MFAMethods  []string `json:"mfaMethods"`
+		MFATicket   string   `json:"mfaTicket"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &loginResp); err != nil || !loginResp.MFARequired {
+		t.Fatalf("expected mfa challenge after password, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(loginResp.MFAMethods) != 1 || loginResp.MFAMethods[0] != "webauthn" {
+		t.Fatalf("expected webauthn mfa method, got %v", loginResp.MFAMethods)
+	}
+
+	assertion := decodeCeremony(post("/api/auth/mfa/webauthn/assertion", "", map[string]string{"mfa_ticket": loginResp.MFATicket}))
+	rr = post("/api/auth/mfa/verify", "", map[string]any{
+		"mfa_ticket": loginResp.MFATicket,
+		"method":     "webauthn",
+		"credential": json.RawMessage(authenticator.assert(assertion.Options, 1, user.ID)),
+	})
+	if rr.Code != http.StatusOK || decodeResponse(t, rr).Token == "" {
+		t.Fatalf("expected passkey to satisfy the mfa challenge, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	statusReq := httptest.NewRequest(http.MethodGet, "/api/auth/mfa/status", nil)
+	statusReq.Header.Set("Authorization", "Bearer passkey-session")
+	statusRec := httptest.NewRecorder()
+	router.ServeHTTP(statusRec, statusReq)
+	var status struct {
+		Enabled bool `json:"enabled"`
+		MFA     struct {
+			WebAuthnEnabled bool `json:"webauthnEnabled"`
+			Authenticators  []struct {
+				Type       string     `json:"type"`
+				Name       string     `json:"name"`
+				LastUsedAt *time.Time `json:"lastUsedAt"`
+			} `json:"authenticators"`
+		} `json:"mfa"`
+	}
+	if err := json.Unmarshal(statusRec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode mfa status: %v", err)
+	}
+	if !status.Enabled || !status.MFA.WebAuthnEnabled || len(status.MFA.Authenticators) != 1 ||
+		status.MFA.Authenticators[0].Name != "Laptop" || status.MFA.Authenticators[0].LastUsedAt == nil {
+		t.Fatalf("expected enrolled passkey in mfa status, got %s", statusRec.Body.String())
+	}
+
+	passwordless := decodeCeremony(post("/api/auth/passkey/login/begin", "", nil))
+	rr = post("/api/auth/passkey/login/finish", "", map[string]any{
+		"ceremonyId": passwordless.CeremonyID,
+		"credential": json.RawMessage(authenticator.assert(passwordless.Options, 2, user.ID)),
+	})
+	if rr.Code != http.StatusOK || decodeResponse(t, rr).Token == "" {
+		t.Fatalf("expected passwordless passkey login, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A signature counter that does not advance points at a cloned key.
+	replay := decodeCeremony(post("/api/auth/passkey/login/begin", "", nil))
+	rr = post("/api/auth/passkey/login/finish", "", map[string]any{
+		"ceremonyId": replay.CeremonyID,
+		"credential": json.RawMessage(authenticator.assert(replay.Options, 2, user.ID)),
+	})
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected stale signature counter to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	credentials, err := st.ListWebAuthnCredentialsByUser(ctx, user.ID)
+	if err != nil || len(credentials) != 1 || credentials[0].SignCount != 2 {
+		t.Fatalf("expected stored sign counter 2, got %+v (%v)", credentials, err)
+	}
+
+	deleteReq := httptest.NewRequest(http.MethodDelete, "/api/auth/mfa/webauthn/credentials/"+credentials[0].ID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer passkey-session")
+	deleteRec := httptest.NewRecorder()
+	router.ServeHTTP(deleteRec, deleteReq)
+	if deleteRec.Code != http.StatusNoContent {
+		t.Fatalf("expected passkey deletion, got %d: %s", deleteRec.Code, deleteRec.Body.String())
+	}
+	rr = post("/api/auth/login", "", map[string]string{"identifier": "passkey@example.com", "password": "passkeyPass1"})
+	if rr.Code != http.StatusOK || decodeResponse(t, rr).Token == "" {
+		t.Fatalf("expected password login without mfa after removing the passkey, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMFARecoveryCodesReplaceLostTOTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("recoveryPass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "Recovery User",
+		Email:         "recovery@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	router := gin.New()
+	mailer := &testEmailSender{}
+	RegisterRoutes(router, WithStore(st), WithEmailSender(mailer), WithEmailVerification(false))
+
+	post := func(path, token string, body any) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type codesResponse struct {
+		Token         string   `json:"token"`
+		MFATicket     string   `json:"mfaTicket"`
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	decodeCodes := func(rr *httptest.ResponseRecorder) codesResponse {
+		t.Helper()
+		var out codesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	rr := post("/api/auth/login", "", map[string]string{"identifier": user.Email, "password": "recoveryPass1"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login before enrollment, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = post("/api/auth/mfa/totp/provision", "", map[string]string{"token": decodeResponse(t, rr).MFAToken})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected provisioning success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	provisioned := decodeResponse(t, rr)
+
+	waitForStableTOTPWindow(t)
+	code, err := totp.GenerateCodeCustom(provisioned.Secret, time.Now().UTC(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatalf("generate totp code: %v", err)
+	}
+	rr = post("/api/auth/mfa/totp/verify", "", map[string]string{"token": provisioned.MFAToken, "code": code})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected enrollment success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	enrolled := decodeCodes(rr)
+	if len(enrolled.RecoveryCodes) != mfaRecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes after enrollment, got %#v", mfaRecoveryCodeCount, enrolled.RecoveryCodes)
+	}
+	stored, err := st.ListMFARecoveryCodes(ctx, user.ID)
+	if err != nil || len(stored) != mfaRecoveryCodeCount {
+		t.Fatalf("expected stored recovery codes, got %d (%v)", len(stored), err)
+	}
+	for _, storedCode := range stored {
+		if storedCode.CodeHash == enrolled.RecoveryCodes[0] || strings.Contains(storedCode.CodeHash, "-") {
+			t.Fatalf("expected recovery codes to be stored hashed, got %q", storedCode.CodeHash)
+		}
+	}
+
+	// Password plus recovery code signs in directly, is recorded and notified.
+	rr = post("/api/auth/login", "", map[string]string{
+		"identifier":   user.Email,
+		"password":     "recoveryPass1",
+		"recoveryCode": strings.ToUpper(enrolled.RecoveryCodes[0]),
+	})
+	if rr.Code != http.StatusOK || decodeResponse(t, rr).Token == "" {
+		t.Fatalf("expected recovery code login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	notice, ok := mailer.last()
+	if !ok || !strings.Contains(notice.Subject, "recovery code") || !strings.Contains(notice.PlainBody, "9 unused recovery codes") {
+		t.Fatalf("expected recovery code notice email, got %#v", notice)
+	}
+	stored, _ = st.ListMFARecoveryCodes(ctx, user.ID)
+	usedCount := 0
+	for _, storedCode := range stored {
+		if storedCode.UsedAt != nil {
+			usedCount++
+			if storedCode.UsedIP == "" {
+				t.Fatalf("expected used recovery code to record the client ip")
+			}
+		}
+	}
+	if usedCount != 1 {
+		t.Fatalf("expected exactly one used recovery code, got %d", usedCount)
+	}
+
+	rr = post("/api/auth/login", "", map[string]string{
+		"identifier":   user.Email,
+		"password":     "recoveryPass1",
+		"recoveryCode": enrolled.RecoveryCodes[0],
+	})
+	if rr.Code != http.StatusUnauthorized || decodeResponse(t, rr).Error != "invalid_recovery_code" {
+		t.Fatalf("expected reused recovery code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The MFA challenge accepts a recovery code in place of a TOTP code.
+	rr = post("/api/auth/login", "", map[string]string{"identifier": user.Email, "password": "recoveryPass1"})
+	challenge := decodeCodes(rr)
+	if challenge.MFATicket == "" {
+		t.Fatalf("expected mfa challenge, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = post("/api/auth/mfa/verify", "", map[string]string{
+		"mfa_ticket": challenge.MFATicket,
+		"method":     mfaMethodRecoveryCode,
+		"code":       enrolled.RecoveryCodes[1],
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected recovery code verification, got %d: %s", rr.Code, rr.Body.String())
+	}
+	sessionToken := decodeResponse(t, rr).Token
+	sessions, err := st.ListSessionsByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if !slices.ContainsFunc(sessions, func(s store.Session) bool { return s.AuthMethod == sessionAuthRecoveryCode }) {
+		t.Fatalf("expected a session with auth method %q", sessionAuthRecoveryCode)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/mfa/status", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if remaining := decodeResponse(t, rr).MFA["recoveryCodesRemaining"]; remaining != float64(8) {
+		t.Fatalf("expected 8 remaining recovery codes, got %#v", remaining)
+	}
+
+	// Regenerating invalidates every earlier code.
+	rr = post("/api/auth/mfa/recovery-codes", sessionToken, nil)
+	if rr.Code != http.StatusOK || len(decodeCodes(rr).RecoveryCodes) != mfaRecoveryCodeCount {
+		t.Fatalf("expected regenerated recovery codes, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = post("/api/auth/login", "", map[string]string{
+		"identifier":   user.Email,
+		"password":     "recoveryPass1",
+		"recoveryCode": enrolled.RecoveryCodes[2],
+	})
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected superseded recovery code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRateLimitsLockOutAcrossReplicas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemoryStore()
+	states := cache.NewMemoryStateStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("throttledPass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if err := st.CreateUser(context.Background(), &store.User{
+		Name:          "Throttled User",
+		Email:         "throttled@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	limits := DefaultRateLimits()
+	limits.Account.Limit = 3
+	limits.EmailTarget.Limit = 2
+	mailer := &testEmailSender{}
+	replicaA := gin.New()
+	RegisterRoutes(replicaA, WithStore(st), WithAuthStateStore(states), WithEmailSender(mailer), WithRateLimits(limits))
+	replicaB := gin.New()
+	RegisterRoutes(replicaB, WithStore(st), WithAuthStateStore(states), WithEmailSender(mailer), WithRateLimits(limits))
+
+	post := func(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	badLogin := `{"identifier":"throttled@example.com","password":"wrongPass1"}`
+	goodLogin := `{"identifier":"throttled@example.com","password":"throttledPass1"}`
+
+	for i, router := range []*gin.Engine{replicaA, replicaB, replicaA} {
+		if rr := post(router, "/api/auth/login", badLogin); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d: %s", i+1, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := post(replicaB, "/api/auth/login", goodLogin)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected locked account to be refused on the other replica, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header on lockout")
+	}
+	var limited struct {
+		Error      string `json:"error"`
+		RetryAfter int64  `json:"retryAfter"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &limited); err != nil {
+		t.Fatalf("decode rate limit response: %v", err)
+	}
+	if limited.Error != "rate_limited" || limited.RetryAfter <= 0 || limited.RetryAfter > 60 {
+		t.Fatalf("unexpected rate limit response: %+v", limited)
+	}
+
+	resetBody := `{"email":"throttled@example.com"}`
+	for i := range 2 {
+		if rr := post(replicaA, "/api/auth/password/reset", resetBody); rr.Code != http.StatusAccepted {
+			t.Fatalf("reset request %d: expected 202, got %d: %s", i+1, rr.Code, rr.Body.String())
+		}
+	}
+	rr = post(replicaB, "/api/auth/password/reset", resetBody)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected reset emails to be limited per recipient, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := len(mailer.messages); got != 2 {
+		t.Fatalf("expected 2 reset emails, got %d", got)
+	}
+
+	open := gin.New()
+	RegisterRoutes(open, WithStore(st), WithEmailSender(mailer), WithRateLimits(RateLimitConfig{}))
+	for i := range 5 {
+		if rr := post(open, "/api/auth/login", badLogin); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d with limits disabled: expected 401, got %d: %s", i+1, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := post(open, "/api/auth/login", goodLogin); rr.Code != http.StatusOK {
+		t.Fatalf("expected login with limits disabled to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// staleReadStates answers every read as if the key were unset, like a check
+// that ran before another replica's hit landed.
+type staleReadStates struct {
+	cache.StateStore
+}
+
+func (staleReadStates) Get(context.Context, string, string) ([]byte, error) {
+	return nil, cache.ErrStateNotFound
+}
+
+func TestRefusedEmailRequestsLeaveOtherLimitsUncharged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limits := DefaultRateLimits()
+	limits.EmailTarget.Limit = 1
+	limits.EmailIP.Limit = 1
+	router := gin.New()
+	RegisterRoutes(router,
+		WithStore(store.NewMemoryStore()),
+		WithAuthStateStore(staleReadStates{cache.NewMemoryStateStore()}),
+		WithEmailSender(&testEmailSender{}),
+		WithRateLimits(limits),
+	)
+
+	reset := func(email, ip string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/password/reset", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := reset("first@example.com", "192.0.2.1"); code != http.StatusAccepted {
+		t.Fatalf("expected first request to be accepted, got %d", code)
+	}
+	if code := reset("second@example.com", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the client IP limit to refuse, got %d", code)
+	}
+	if code := reset("second@example.com", "192.0.2.2"); code != http.StatusAccepted {
+		t.Fatalf("expected the refused request not to charge its recipient, got %d", code)
+	}
+}
+
+func TestAuditLogRecordsAdminActionsAndSecurityActivity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("auditedPass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &store.User{
+		Name:          "Audited User",
+		Email:         "audited@example.com",
+		PasswordHash:  string(hash),
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	admin := &store.User{
+		Name:          "Audit Admin",
+		Email:         "audit-admin@example.com",
+		EmailVerified: true,
+		Role:          store.RoleAdmin,
+		Level:         store.LevelAdmin,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, admin); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	adminToken := "audit-admin-token"
+	if err := st.CreateSession(ctx, adminToken, admin.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create admin session: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailSender(&testEmailSender{}))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	type auditPage struct {
+		Events []struct {
+			ID        string         `json:"id"`
+			ActorID   string         `json:"actorId"`
+			Action    string         `json:"action"`
+			Outcome   string         `json:"outcome"`
+			TargetID  string         `json:"targetId"`
+			IPAddress string         `json:"ipAddress"`
+			Before    map[string]any `json:"before"`
+			After     map[string]any `json:"after"`
+			Metadata  map[string]any `json:"metadata"`
+		} `json:"events"`
+		NextCursor string `json:"nextCursor"`
+	}
+	list := func(path, token string) auditPage {
+		t.Helper()
+		rr := do(http.MethodGet, path, token, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected %s to succeed, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		var page auditPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode audit page: %v", err)
+		}
+		return page
+	}
+
+	if rr := do(http.MethodPost, "/api/auth/login", "", `{"identifier":"audited@example.com","password":"wrongPass1"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected failed login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := do(http.MethodPost, "/api/auth/login", "", `{"identifier":"audited@example.com","password":"auditedPass1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login success, got %d: %s", rr.Code, rr.Body.String())
+	}
+	userToken := decodeResponse(t, rr).Token
+
+	activity := list("/api/auth/security/activity", userToken)
+	if len(activity.Events) != 2 {
+		t.Fatalf("expected a failed and a successful sign-in, got %+v", activity.Events)
+	}
+	if activity.Events[0].Outcome != store.AuditOutcomeSuccess || activity.Events[0].Metadata["method"] != sessionAuthPassword {
+		t.Fatalf("unexpected sign-in event: %+v", activity.Events[0])
+	}
+	if activity.Events[1].Outcome != store.AuditOutcomeFailure || activity.Events[1].Metadata["reason"] != "invalid_credentials" {
+		t.Fatalf("unexpected failed sign-in event: %+v", activity.Events[1])
+	}
+	if rr := do(http.MethodGet, "/api/auth/admin/audit", userToken, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected standard user to be denied the audit log, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := do(http.MethodPost, "/api/auth/admin/users/"+user.ID+"/role", adminToken, `{"role":"operator"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected role update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/api/auth/admin/users/"+user.ID+"/pause", adminToken, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected pause, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	page := list("/api/auth/admin/audit?action=admin.&targetId="+user.ID, adminToken)
+	if len(page.Events) != 2 {
+		t.Fatalf("expected 2 admin events for the user, got %+v", page.Events)
+	}
+	pause, role := page.Events[0], page.Events[1]
+	if pause.Action != auditActionUserPause || pause.ActorID != admin.ID || pause.IPAddress == "" {
+		t.Fatalf("unexpected pause event: %+v", pause)
+	}
+	if pause.Before["active"] != true || pause.After["active"] != false || len(pause.After) != 1 {
+		t.Fatalf("expected pause diff to contain only active, got before=%v after=%v", pause.Before, pause.After)
+	}
+	if role.Action != auditActionUserRoleUpdate || role.Before["role"] != store.RoleUser || role.After["role"] != store.RoleOperator {
+		t.Fatalf("unexpected role event: %+v", role)
+	}
+
+	first := list("/api/auth/admin/audit?limit=1", adminToken)
+	if len(first.Events) != 1 || first.NextCursor == "" {
+		t.Fatalf("expected one event and a cursor, got %+v", first)
+	}
+	second := list("/api/auth/admin/audit?limit=1&cursor="+first.NextCursor, adminToken)
+	if len(second.Events) != 1 || second.Events[0].ID == first.Events[0].ID {
+		t.Fatalf("expected the next page to continue after the cursor, got %+v", second)
+	}
+}
+
+func TestWebhooksDeliverSignedLifecycleEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	user := &store.User{
+		Name:          "Webhook User",
+		Email:         "webhook-user@example.com",
+		EmailVerified: true,
+		Role:          store.RoleUser,
+		Level:         store.LevelUser,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	admin := &store.User{
+		Name:          "Webhook Admin",
+		Email:         "webhook-admin@example.com",
+		EmailVerified: true,
+		Role:          store.RoleAdmin,
+		Level:         store.LevelAdmin,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, admin); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	adminToken := "webhook-admin-token"
+	if err := st.CreateSession(ctx, adminToken, admin.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create admin session: %v", err)
+	}
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var (
+		mu         sync.Mutex
+		deliveries []received
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, received{header: r.Header.Clone(), body: body})
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailSender(&testEmailSender{}), WithWebhookNetworks([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPost, "/api/admin/webhooks", `{"url":"ftp://example.com/hook"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected non-http url to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/api/admin/webhooks", `{"url":"http://169.254.169.254/latest/meta-data/"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected a link-local url to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/api/admin/webhooks", `{"url":"`+receiver.URL+`","eventTypes":["user.exploded"]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown event type to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := do(http.MethodPost, "/api/admin/webhooks", `{"url":"`+receiver.URL+`","eventTypes":["user.paused","user.resumed"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected webhook creation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Webhook struct {
+			ID string `json:"id"`
+		} `json:"webhook"`
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode webhook: %v", err)
+	}
+	if created.Webhook.ID == "" || !strings.HasPrefix(created.Secret, "whsec_") {
+		t.Fatalf("expected webhook id and secret, got %+v", created)
+	}
+	if strings.Contains(do(http.MethodGet, "/api/admin/webhooks", "").Body.String(), created.Secret) {
+		t.Fatalf("expected secret to be withheld from the webhook list")
+	}
+
+	if rr := do(http.MethodPost, "/api/auth/admin/users/"+user.ID+"/role", `{"role":"operator"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected role update, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/api/auth/admin/users/"+user.ID+"/pause", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected pause, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	type eventList struct {
+		Events []struct {
+			ID        string         `json:"id"`
+			Type      string         `json:"type"`
+			SubjectID string         `json:"subjectId"`
+			Data      map[string]any `json:"data"`
+		} `json:"events"`
+	}
+	var events eventList
+	if err := json.Unmarshal(do(http.MethodGet, "/api/admin/events", "").Body.Bytes(), &events); err != nil {
+		t.Fatalf("decode events: %v", err)
+	}
+	if len(events.Events) != 2 || events.Events[0].Type != webhook.EventUserPaused || events.Events[1].Type != webhook.EventUserRoleChanged {
+		t.Fatalf("expected pause and role change events, got %+v", events.Events)
+	}
+	if events.Events[1].Data["previousRole"] != store.RoleUser || events.Events[1].Data["role"] != store.RoleOperator {
+		t.Fatalf("unexpected role change data: %v", events.Events[1].Data)
+	}
+	pausedEventID := events.Events[0].ID
+
+	dispatcher := webhook.NewDispatcher(st, receiver.Client())
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected only the subscribed pause event to be delivered, got %d (%v)", sent, err)
+	}
+	mu.Lock()
+	first := deliveries[0]
+	mu.Unlock()
+	if first.header.Get(webhook.EventHeader) != webhook.EventUserPaused || first.header.Get(webhook.EventIDHeader) != pausedEventID {
+		t.Fatalf("unexpected delivery headers: %v", first.header)
+	}
+	if !webhook.Verify(created.Secret, first.header.Get(webhook.SignatureHeader), first.body, time.Now(), 5*time.Minute) {
+		t.Fatalf("expected delivery to carry a valid signature")
+	}
+	var payload webhook.Payload
+	if err := json.Unmarshal(first.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.ID != pausedEventID || payload.Subject.ID != user.ID || payload.Data["active"] != false {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	type deliveryList struct {
+		Deliveries []struct {
+			EventID  string `json:"eventId"`
+			Status   string `json:"status"`
+			Attempts int    `json:"attempts"`
+		} `json:"deliveries"`
+	}
+	var log deliveryList
+	if err := json.Unmarshal(do(http.MethodGet, "/api/admin/webhooks/"+created.Webhook.ID+"/deliveries", "").Body.Bytes(), &log); err != nil {
+		t.Fatalf("decode deliveries: %v", err)
+	}
+	if len(log.Deliveries) != 1 || log.Deliveries[0].Status != store.WebhookDeliverySucceeded || log.Deliveries[0].Attempts != 1 {
+		t.Fatalf("expected one successful delivery, got %+v", log.Deliveries)
+	}
+
+	rr = do(http.MethodPost, "/api/admin/events/"+pausedEventID+"/replay", `{"subscriptionId":"`+created.Webhook.ID+`"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected replay to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected the replayed event to be delivered, got %d (%v)", sent, err)
+	}
+	mu.Lock()
+	replayed := deliveries[len(deliveries)-1]
+	mu.Unlock()
+	if replayed.header.Get(webhook.EventIDHeader) != pausedEventID || replayed.header.Get(webhook.DeliveryHeader) == first.header.Get(webhook.DeliveryHeader) {
+		t.Fatalf("expected a new delivery of the same event, got %v", replayed.header)
+	}
+
+	if rr := do(http.MethodDelete, "/api/admin/webhooks/"+created.Webhook.ID, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected webhook deletion, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/admin/webhooks/"+created.Webhook.ID+"/deliveries", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted webhook to be gone, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// flakyOutboxStore fails the next failures outbox appends.
+type flakyOutboxStore struct {
+	store.Store
+	mu       sync.Mutex
+	failures int
+}
+
+func (s *flakyOutboxStore) AppendOutboxEvent(ctx context.Context, event *store.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection reset")
+	}
+	return s.Store.AppendOutboxEvent(ctx, event)
+}
+
+func TestLifecycleEventAppendFailuresFailTheRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "")
+
+	ctx := context.Background()
+	st := &flakyOutboxStore{Store: store.NewMemoryStore()}
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st))
+	env := &apiTokenTestEnv{t: t, st: st, router: router}
+	env.user(store.RootAdminEmail, store.RoleRoot, store.LevelAdmin, "admin-session")
+	target := env.user("target@example.com", store.RoleUser, store.LevelUser, "target-session")
+	events := func() int {
+		t.Helper()
+		listed, err := st.ListOutboxEvents(ctx, "", 0)
+		if err != nil {
+			t.Fatalf("list outbox events: %v", err)
+		}
+		return len(listed)
+	}
+
+	// A passing hiccup is retried.
+	st.failures = outboxAppendAttempts - 1
+	env.expect(env.do(http.MethodPost, "/api/admin/users/"+target.ID+"/pause", "admin-session", ""), http.StatusOK, "pause with a transient outbox failure")
+	if got := events(); got != 1 {
+		t.Fatalf("expected the retried event to be published once, got %d", got)
+	}
+
+	// A lasting failure fails the request instead of dropping the event.
+	st.failures = outboxAppendAttempts
+	rr := env.do(http.MethodPost, "/api/admin/users/"+target.ID+"/resume", "admin-session", "")
+	env.expect(rr, http.StatusInternalServerError, "resume with a failing outbox")
+	if !strings.Contains(rr.Body.String(), "event_publish_failed") {
+		t.Fatalf("expected event_publish_failed, got %s", rr.Body.String())
+	}
+
+	// A creation that cannot be announced is rolled back.
+	st.failures = outboxAppendAttempts
+	env.expect(env.do(http.MethodPost, "/api/admin/users", "admin-session", `{"email":"new@example.com","uuid":"5d2c7a1e-4b3f-4c8d-9e0a-1f2b3c4d5e6f","groups":["User"]}`), http.StatusInternalServerError, "create with a failing outbox")
+	if _, err := st.GetUserByEmail(ctx, "new@example.com"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("expected the unannounced user to be rolled back, got %v", err)
+	}
+	env.expect(env.do(http.MethodPost, "/api/admin/users", "admin-session", `{"email":"new@example.com","uuid":"5d2c7a1e-4b3f-4c8d-9e0a-1f2b3c4d5e6f","groups":["User"]}`), http.StatusCreated, "retried create")
+}
+
+func TestSCIMProvisionsTenantUsersAndGroups(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	for _, tenant := range []*store.Tenant{
+		{ID: "acme", Name: "Acme", Edition: store.TenantPrivateEdition},
+		{ID: "globex", Name: "Globex", Edition: store.TenantPrivateEdition},
+	} {
+		if err := st.EnsureTenant(ctx, tenant); err != nil {
+			t.Fatalf("ensure tenant: %v", err)
+		}
+	}
+	admin := &store.User{
+		Name:          "SCIM Admin",
+		Email:         "scim-admin@example.com",
+		EmailVerified: true,
+		Role:          store.RoleAdmin,
+		Level:         store.LevelAdmin,
+		Active:        true,
+	}
+	if err := st.CreateUser(ctx, admin); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	if err := st.UpsertTenantMembership(ctx, &store.TenantMembership{TenantID: "acme", UserID: admin.ID, Role: store.TenantMembershipRoleAdmin}); err != nil {
+		t.Fatalf("add admin to tenant: %v", err)
+	}
+	adminToken := "scim-admin-token"
+	if err := st.CreateSession(ctx, adminToken, admin.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("create admin session: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailSender(&testEmailSender{}))
+
+	do := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/scim+json")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	issueToken := func(tenantID string) (string, string) {
+		rr := do(http.MethodPost, "/api/admin/tenants/"+tenantID+"/scim-tokens", adminToken, `{"description":"Okta"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected scim token, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var created struct {
+			SCIMToken struct {
+				ID string `json:"id"`
+			} `json:"scimToken"`
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("decode scim token: %v", err)
+		}
+		return created.SCIMToken.ID, created.Token
+	}
+	acmeTokenID, acmeToken := issueToken("acme")
+	_, globexToken := issueToken("globex")
+
+	if rr := do(http.MethodGet, "/scim/v2/Users", "", ""); rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected anonymous scim request to be rejected, got %d", rr.Code)
+	}
+
+	rr := do(http.MethodPost, "/scim/v2/Users", acmeToken, `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "Jane@Acme.example",
+		"externalId": "00u1",
+		"name": {"givenName": "Jane", "familyName": "Doe"},
+		"emails": [{"value": "jane@acme.example", "type": "work", "primary": true}],
+		"active": true
+	}`)
+	if rr.Code != http.StatusCreated || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/scim+json") {
+		t.Fatalf("expected user to be provisioned, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var provisioned struct {
+		ID         string `json:"id"`
+		UserName   string `json:"userName"`
+		ExternalID string `json:"externalId"`
+		Active     bool   `json:"active"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &provisioned); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	if provisioned.UserName != "jane@acme.example" || provisioned.ExternalID != "00u1" || !provisioned.Active {
+		t.Fatalf("unexpected provisioned user: %+v", provisioned)
+	}
+	user, err := st.GetUserByID(ctx, provisioned.ID)
+	if err != nil {
+		t.Fatalf("load provisioned user: %v", err)
+	}
+	if user.Name != "Jane Doe" || user.EmailVerified || user.Role != store.RoleUser {
+		t.Fatalf("unexpected account: %+v", user)
+	}
+	if membership, err := st.GetTenantMembership(ctx, "acme", user.ID); err != nil || membership.ExternalID != "00u1" {
+		t.Fatalf("expected acme membership with externalId, got %+v (%v)", membership, err)
+	}
+
+	rr = do(http.MethodPost, "/scim/v2/Users", acmeToken, `{"userName":"jane@acme.example"}`)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), `"uniqueness"`) {
+		t.Fatalf("expected duplicate userName to conflict, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	type listResponse struct {
+		TotalResults int               `json:"totalResults"`
+		Resources    []json.RawMessage `json:"Resources"`
+	}
+	list := func(path, bearer string) listResponse {
+		t.Helper()
+		rr := do(http.MethodGet, path, bearer, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected list of %s, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		var page listResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode list: %v", err)
+		}
+		return page
+	}
+	if page := list("/scim/v2/Users", acmeToken); page.TotalResults != 1 {
+		t.Fatalf("expected the tenant admin to be left out of scim, got %d users", page.TotalResults)
+	}
+	if page := list("/scim/v2/Users?filter="+url.QueryEscape(`userName eq "JANE@acme.example"`), acmeToken); page.TotalResults != 1 {
+		t.Fatalf("expected filter to find the user, got %d", page.TotalResults)
+	}
+	if page := list("/scim/v2/Users?filter="+url.QueryEscape(`externalId eq "other"`), acmeToken); page.TotalResults != 0 {
+		t.Fatalf("expected filter to exclude the user, got %d", page.TotalResults)
+	}
+	if page := list("/scim/v2/Users", globexToken); page.TotalResults != 0 {
+		t.Fatalf("expected other tenants not to see the user, got %d", page.TotalResults)
+	}
+	if rr := do(http.MethodGet, "/scim/v2/Users/"+user.ID, globexToken, ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected other tenants not to load the user, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName gt "a"`), acmeToken, ""); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalidFilter") {
+		t.Fatalf("expected unsupported filter to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Okta deactivates with a path-less replace, Azure with string booleans.
+	rr = do(http.MethodPatch, "/scim/v2/Users/"+user.ID, acmeToken, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","value":{"active":false}}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected deactivation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if user, _ = st.GetUserByID(ctx, user.ID); user.Active {
+		t.Fatalf("expected deactivated user to be paused")
+	}
+	rr = do(http.MethodPatch, "/scim/v2/Users/"+user.ID, acmeToken, `{"Operations":[
+		{"op":"Replace","path":"active","value":"True"},
+		{"op":"Replace","path":"name.givenName","value":"Janet"},
+		{"op":"Replace","path":"name.familyName","value":"Doe"},
+		{"op":"Add","path":"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department","value":"R&D"}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected patch, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if user, _ = st.GetUserByID(ctx, user.ID); !user.Active || user.Name != "Janet Doe" {
+		t.Fatalf("expected reactivated and renamed user, got %+v", user)
+	}
+	events, err := st.ListOutboxEvents(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("list outbox events: %v", err)
+	}
+	var eventTypes []string
+	for _, event := range events {
+		if event.SubjectID == user.ID {
+			eventTypes = append(eventTypes, event.Type)
+		}
+	}
+	for _, want := range []string{webhook.EventUserCreated, webhook.EventUserPaused, webhook.EventUserResumed} {
+		if !slices.Contains(eventTypes, want) {
+			t.Fatalf("expected %s to be published, got %v", want, eventTypes)
+		}
+	}
+
+	rr = do(http.MethodPost, "/scim/v2/Groups", acmeToken, `{"displayName":"Engineering","members":[{"value":"`+user.ID+`"}]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected group, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var group struct {
+		ID      string `json:"id"`
+		Members []struct {
+			Value string `json:"value"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &group); err != nil {
+		t.Fatalf("decode group: %v", err)
+	}
+	if len(group.Members) != 1 || group.Members[0].Value != user.ID {
+		t.Fatalf("unexpected group members: %+v", group.Members)
+	}
+	if user, _ = st.GetUserByID(ctx, user.ID); !slices.Equal(user.Groups, []string{"Engineering"}) {
+		t.Fatalf("expected group to reach the user, got %v", user.Groups)
+	}
+	if rr := do(http.MethodPost, "/scim/v2/Groups", acmeToken, `{"displayName":"engineering"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("expected duplicate group name to conflict, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, "/scim/v2/Groups", acmeToken, `{"displayName":"Admins","members":[{"value":"`+admin.ID+`"}]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected users outside scim to be rejected as members, got %d", rr.Code)
+	}
+
+	rr = do(http.MethodPatch, "/scim/v2/Groups/"+group.ID, acmeToken, `{"Operations":[
+		{"op":"replace","path":"displayName","value":"Platform"},
+		{"op":"remove","path":"members[value eq \"`+user.ID+`\"]"}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected group patch, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if user, _ = st.GetUserByID(ctx, user.ID); len(user.Groups) != 0 {
+		t.Fatalf("expected member to be removed, got %v", user.Groups)
+	}
+	rr = do(http.MethodPatch, "/scim/v2/Groups/"+group.ID, acmeToken, `{"Operations":[{"op":"add","path":"members","value":[{"value":"`+user.ID+`"}]}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected member add, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if user, _ = st.GetUserByID(ctx, user.ID); !slices.Equal(user.Groups, []string{"Platform"}) {
+		t.Fatalf("expected renamed group on the user, got %v", user.Groups)
+	}
+	if page := list("/scim/v2/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "platform"`), acmeToken); page.TotalResults != 1 || strings.Contains(string(page.Resources[0]), "members") {
+		t.Fatalf("expected group lookup without members, got %+v", page)
+	}
+
+	audit, err := st.ListAuditEvents(ctx, store.AuditEventFilter{TenantID: "acme", ActionPrefix: "scim."})
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(audit) == 0 || audit[0].ActorID != "" || audit[0].Metadata["scimTokenId"] != acmeTokenID {
+		t.Fatalf("expected scim changes attributed to the token, got %+v", audit)
+	}
+
+	if rr := do(http.MethodDelete, "/scim/v2/Users/"+user.ID, acmeToken, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected user deletion, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := st.GetUserByID(ctx, user.ID); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("expected account without tenants to be deleted, got %v", err)
+	}
+
+	if rr := do(http.MethodDelete, "/api/admin/tenants/acme/scim-tokens/"+acmeTokenID, adminToken, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected token revocation, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/scim/v2/Users", acmeToken, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestSCIMOnlyChangesAccountsTheTenantOwns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	for _, tenant := range []*store.Tenant{
+		{ID: "acme", Name: "Acme", Edition: store.TenantPrivateEdition},
+		{ID: "globex", Name: "Globex", Edition: store.TenantPrivateEdition},
+	} {
+		if err := st.EnsureTenant(ctx, tenant); err != nil {
+			t.Fatalf("ensure tenant: %v", err)
+		}
+	}
+	if err := st.CreateTenantDomain(ctx, &store.TenantDomain{TenantID: "acme", Domain: "acme.example", Kind: store.TenantDomainKindCustom, Status: store.TenantDomainStatusVerified}); err != nil {
+		t.Fatalf("create tenant domain: %v", err)
+	}
+	tokens := map[string]string{}
+	for _, tenantID := range []string{"acme", "globex"} {
+		tokens[tenantID] = scimTokenPrefix + tenantID
+		if err := st.CreateSCIMToken(ctx, &store.SCIMToken{TenantID: tenantID, Description: "IdP", TokenHash: hashSCIMToken(tokens[tenantID])}); err != nil {
+			t.Fatalf("create scim token: %v", err)
+		}
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithEmailSender(&testEmailSender{}))
+	do := func(method, path, tenantID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/scim+json")
+		req.Header.Set("Authorization", "Bearer "+tokens[tenantID])
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	member := func(email, tenantID string) *store.User {
+		t.Helper()
+		user := &store.User{Name: email, Email: email, Role: store.RoleUser, Level: store.LevelUser, Active: true}
+		if err := st.CreateUser(ctx, user); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		if err := st.UpsertTenantMembership(ctx, &store.TenantMembership{TenantID: tenantID, UserID: user.ID, Role: store.TenantMembershipRoleUser}); err != nil {
+			t.Fatalf("add user to tenant: %v", err)
+		}
+		return user
+	}
+	patch := func(user *store.User, tenantID, operations string) int {
+		return do(http.MethodPatch, "/scim/v2/Users/"+user.ID, tenantID, `{"Operations":[`+operations+`]}`).Code
+	}
+
+	// An account that joined the tenant on its own keeps its credentials.
+	outsider := member("bob@example.com", "acme")
+	for _, op := range []string{
+		`{"op":"replace","path":"password","value":"hijacked-password"}`,
+		`{"op":"replace","path":"userName","value":"bob@acme.example"}`,
+		`{"op":"replace","path":"active","value":false}`,
+	} {
+		if code := patch(outsider, "acme", op); code != http.StatusForbidden {
+			t.Fatalf("expected %s on an outside account to be refused, got %d", op, code)
+		}
+	}
+	if code := patch(outsider, "acme", `{"op":"replace","path":"externalId","value":"00u9"},{"op":"replace","path":"displayName","value":"Robert"}`); code != http.StatusOK {
+		t.Fatalf("expected membership changes on an outside account, got %d", code)
+	}
+	if user, _ := st.GetUserByID(ctx, outsider.ID); user.Name != "bob@example.com" || !user.Active {
+		t.Fatalf("expected the outside account to be left alone, got %+v", user)
+	}
+	if rr := do(http.MethodDelete, "/scim/v2/Users/"+outsider.ID, "acme", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the outside account to leave the tenant, got %d", rr.Code)
+	}
+	if _, err := st.GetUserByID(ctx, outsider.ID); err != nil {
+		t.Fatalf("expected the outside account to be kept, got %v", err)
+	}
+
+	// An account on a verified domain of the tenant is the tenant's.
+	domainUser := member("carol@acme.example", "acme")
+	if code := patch(domainUser, "acme", `{"op":"replace","path":"active","value":false}`); code != http.StatusOK {
+		t.Fatalf("expected a verified-domain account to be deactivated, got %d", code)
+	}
+
+	// A provisioned account stops being the tenant's once another tenant
+	// shares it.
+	rr := do(http.MethodPost, "/scim/v2/Users", "globex", `{"userName":"dave@example.com"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected user to be provisioned, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var provisioned struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &provisioned); err != nil {
+		t.Fatalf("decode user: %v", err)
+	}
+	dave := &store.User{ID: provisioned.ID}
+	if code := patch(dave, "globex", `{"op":"replace","path":"password","value":"new-password"}`); code != http.StatusOK {
+		t.Fatalf("expected the provisioning tenant to set the password, got %d", code)
+	}
+	if err := st.UpsertTenantMembership(ctx, &store.TenantMembership{TenantID: "acme", UserID: dave.ID, Role: store.TenantMembershipRoleUser}); err != nil {
+		t.Fatalf("add user to second tenant: %v", err)
+	}
+	if code := patch(dave, "globex", `{"op":"replace","path":"password","value":"other-password"}`); code != http.StatusForbidden {
+		t.Fatalf("expected a shared account's password to be refused, got %d", code)
+	}
+	if code := patch(dave, "acme", `{"op":"replace","path":"active","value":false}`); code != http.StatusForbidden {
+		t.Fatalf("expected a tenant that did not provision the account to be refused, got %d", code)
+	}
+}
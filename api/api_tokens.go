@@ -0,0 +1,342 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/rbac"
+	"account/internal/store"
+)
+
+const (
+	apiTokenContextKey          = "apiToken"
+	apiTokenPrincipalContextKey = "apiTokenPrincipal"
+	serviceAccountContextKey    = "serviceAccount"
+
+	// apiTokenTouchInterval bounds how often a token's last use is written
+	// back to the store.
+	apiTokenTouchInterval = time.Minute
+
+	defaultAPITokenTTLDays = 90
+	maxAPITokenTTLDays     = 365
+	maxAPITokenNameLength  = 100
+)
+
+type apiTokenResponse struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	UserID           string     `json:"userId,omitempty"`
+	ServiceAccountID string     `json:"serviceAccountId,omitempty"`
+	TenantID         string     `json:"tenantId,omitempty"`
+	Scopes           []string   `json:"scopes"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	ExpiresAt        time.Time  `json:"expiresAt"`
+	LastUsedAt       *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func newAPITokenResponse(token *store.APIToken) apiTokenResponse {
+	scopes := token.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return apiTokenResponse{
+		ID:               token.ID,
+		Name:             token.Name,
+		UserID:           token.UserID,
+		ServiceAccountID: token.ServiceAccountID,
+		TenantID:         token.TenantID,
+		Scopes:           scopes,
+		CreatedAt:        token.CreatedAt,
+		ExpiresAt:        token.ExpiresAt,
+		LastUsedAt:       token.LastUsedAt,
+	}
+}
+
+func newAPITokenResponses(tokens []store.APIToken) []apiTokenResponse {
+	responses := make([]apiTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		responses = append(responses, newAPITokenResponse(&tokens[i]))
+	}
+	return responses
+}
+
+type createAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	TenantID      string   `json:"tenantId"`
+	ExpiresInDays *int     `json:"expiresInDays"`
+}
+
+// hashAPIToken derives the lookup key of a token. Tokens carry 256 random
+// bits, so an unsalted digest is enough.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIToken resolves the principal behind an API token: the
+// owning user, or a stand-in user for a service account. The token, and the
+// service account if any, are kept on the context for holds and the audit
+// log.
+func (h *handler) authenticateAPIToken(c *gin.Context, raw string) (*store.User, bool) {
+	ctx := c.Request.Context()
+	token, err := h.store.GetAPITokenByHash(ctx, hashAPIToken(raw))
+	if err != nil {
+		if errors.Is(err, store.ErrAPITokenNotFound) {
+			respondError(c, http.StatusUnauthorized, "invalid_api_token", "api token not found or expired")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "api_token_lookup_failed", "failed to verify api token")
+		return nil, false
+	}
+	now := time.Now().UTC()
+	if !now.Before(token.ExpiresAt) {
+		respondError(c, http.StatusUnauthorized, "invalid_api_token", "api token not found or expired")
+		return nil, false
+	}
+
+	var principal *store.User
+	if token.ServiceAccountID != "" {
+		account, err := h.store.GetServiceAccount(ctx, token.ServiceAccountID)
+		if err != nil {
+			if errors.Is(err, store.ErrServiceAccountNotFound) {
+				respondError(c, http.StatusUnauthorized, "invalid_api_token", "api token not found or expired")
+				return nil, false
+			}
+			respondError(c, http.StatusInternalServerError, "api_token_lookup_failed", "failed to verify api token")
+			return nil, false
+		}
+		if account.Disabled {
+			respondError(c, http.StatusForbidden, "service_account_disabled", "service account is disabled")
+			return nil, false
+		}
+		principal = serviceAccountPrincipal(account)
+		c.Set(serviceAccountContextKey, account)
+	} else {
+		user, err := h.store.GetUserByID(ctx, token.UserID)
+		if err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				respondError(c, http.StatusUnauthorized, "invalid_api_token", "api token not found or expired")
+				return nil, false
+			}
+			respondError(c, http.StatusInternalServerError, "api_token_lookup_failed", "failed to verify api token")
+			return nil, false
+		}
+		principal = user
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := h.store.TouchAPIToken(ctx, token.ID, now); err != nil {
+			slog.Warn("failed to record api token use", "err", err, "tokenID", token.ID)
+		}
+	}
+	c.Set(apiTokenContextKey, token)
+	c.Set(apiTokenPrincipalContextKey, principal)
+	return principal, true
+}
+
+// apiTokenPrincipal returns the principal behind raw, reusing the one the
+// auth middleware resolved for this request when there is one.
+func (h *handler) apiTokenPrincipal(c *gin.Context, raw string) (*store.User, bool) {
+	if value, ok := c.Get(apiTokenPrincipalContextKey); ok {
+		return value.(*store.User), true
+	}
+	return h.authenticateAPIToken(c, raw)
+}
+
+// holds reports whether the caller holds permission on resource. Callers
+// using an API token are further limited to the token's scopes; service
+// accounts hold exactly their own permissions.
+func (h *handler) holds(c *gin.Context, actor *store.User, permission string, resource rbac.Resource) (bool, error) {
+	if value, ok := c.Get(apiTokenContextKey); ok {
+		token := value.(*store.APIToken)
+		if !rbac.Scoped(token.Scopes, token.TenantID, permission, resource) {
+			return false, nil
+		}
+		if value, ok := c.Get(serviceAccountContextKey); ok {
+			account := value.(*store.ServiceAccount)
+			return rbac.Scoped(account.Permissions, account.TenantID, permission, resource), nil
+		}
+	}
+	return h.policy.Can(c.Request.Context(), actor, permission, resource)
+}
+
+// issueAPIToken validates req and creates a token for owner. Scopes must be
+// in the catalog and held by actor on the token's resource. It returns the
+// secret, which is only shown once.
+func (h *handler) issueAPIToken(c *gin.Context, actor *store.User, owner store.APITokenOwner, req createAPITokenRequest) (*store.APIToken, string, bool) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		respondError(c, http.StatusBadRequest, "invalid_name", "name is required and must be at most 100 characters")
+		return nil, "", false
+	}
+	if len(req.Scopes) == 0 {
+		respondError(c, http.StatusBadRequest, "invalid_scope", "at least one scope is required")
+		return nil, "", false
+	}
+	for _, scope := range req.Scopes {
+		if !h.policy.KnownPermission(scope) {
+			respondError(c, http.StatusBadRequest, "invalid_scope", "unknown scope: "+strings.TrimSpace(scope))
+			return nil, "", false
+		}
+	}
+	days := defaultAPITokenTTLDays
+	if req.ExpiresInDays != nil {
+		days = *req.ExpiresInDays
+	}
+	if days < 1 || days > maxAPITokenTTLDays {
+		respondError(c, http.StatusBadRequest, "invalid_expiry", "expiresInDays must be between 1 and 365")
+		return nil, "", false
+	}
+
+	resource := rbac.Tenant(req.TenantID)
+	if resource.TenantID != "" {
+		if _, err := h.store.GetTenant(c.Request.Context(), resource.TenantID); err != nil {
+			if errors.Is(err, store.ErrTenantNotFound) {
+				respondError(c, http.StatusNotFound, "tenant_not_found", "tenant not found")
+				return nil, "", false
+			}
+			respondError(c, http.StatusInternalServerError, "tenant_lookup_failed", "failed to load tenant")
+			return nil, "", false
+		}
+	}
+	if !h.requireGrantable(c, actor, req.Scopes, resource) {
+		return nil, "", false
+	}
+
+	secret, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "create_api_token_failed", "failed to generate api token")
+		return nil, "", false
+	}
+	if owner.ServiceAccountID != "" {
+		secret = store.ServiceAccountTokenPrefix + secret
+	} else {
+		secret = store.PersonalAccessTokenPrefix + secret
+	}
+
+	token := &store.APIToken{
+		Name:             name,
+		UserID:           owner.UserID,
+		ServiceAccountID: owner.ServiceAccountID,
+		TenantID:         resource.TenantID,
+		Scopes:           req.Scopes,
+		TokenHash:        hashAPIToken(secret),
+		CreatedBy:        actor.ID,
+		ExpiresAt:        time.Now().UTC().AddDate(0, 0, days),
+	}
+	if err := h.store.CreateAPIToken(c.Request.Context(), token); err != nil {
+		respondError(c, http.StatusInternalServerError, "create_api_token_failed", "failed to create api token")
+		return nil, "", false
+	}
+	return token, secret, true
+}
+
+func (h *handler) listAPITokens(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.store.ListAPITokens(c.Request.Context(), store.APITokenOwner{UserID: user.ID})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_api_tokens_failed", "failed to list api tokens")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": newAPITokenResponses(tokens)})
+}
+
+// createAPIToken issues a personal access token. Its scopes must be
+// permissions the user holds; the token is only returned in this response.
+func (h *handler) createAPIToken(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req createAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	token, secret, ok := h.issueAPIToken(c, user, store.APITokenOwner{UserID: user.ID}, req)
+	if !ok {
+		return
+	}
+	h.recordAudit(c, user, store.AuditEvent{
+		Action:     auditActionAPITokenCreate,
+		TargetType: auditTargetAPIToken,
+		TargetID:   token.ID,
+		TenantID:   token.TenantID,
+		After:      map[string]any{"name": token.Name, "scopes": token.Scopes, "expiresAt": token.ExpiresAt},
+	})
+	c.JSON(http.StatusCreated, gin.H{"apiToken": newAPITokenResponse(token), "token": secret})
+}
+
+func (h *handler) deleteAPIToken(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	tokenID := strings.TrimSpace(c.Param("tokenId"))
+	if err := h.store.DeleteAPIToken(c.Request.Context(), store.APITokenOwner{UserID: user.ID}, tokenID); err != nil {
+		if errors.Is(err, store.ErrAPITokenNotFound) {
+			respondError(c, http.StatusNotFound, "api_token_not_found", "api token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "revoke_api_token_failed", "failed to revoke api token")
+		return
+	}
+	h.recordAudit(c, user, store.AuditEvent{
+		Action:     auditActionAPITokenRevoke,
+		TargetType: auditTargetAPIToken,
+		TargetID:   tokenID,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) adminListUserAPITokens(c *gin.Context) {
+	_, target, ok := h.adminSessionTarget(c, permissionAdminUsersSessionsRead)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.store.ListAPITokens(c.Request.Context(), store.APITokenOwner{UserID: target.ID})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_api_tokens_failed", "failed to list api tokens")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": newAPITokenResponses(tokens)})
+}
+
+func (h *handler) adminRevokeUserAPIToken(c *gin.Context) {
+	adminUser, target, ok := h.adminSessionTarget(c, permissionAdminUsersSessionsWrite)
+	if !ok {
+		return
+	}
+
+	tokenID := strings.TrimSpace(c.Param("tokenId"))
+	if err := h.store.DeleteAPIToken(c.Request.Context(), store.APITokenOwner{UserID: target.ID}, tokenID); err != nil {
+		if errors.Is(err, store.ErrAPITokenNotFound) {
+			respondError(c, http.StatusNotFound, "api_token_not_found", "api token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "revoke_api_token_failed", "failed to revoke api token")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionAdminAPITokenRevoke,
+		TargetType: auditTargetAPIToken,
+		TargetID:   tokenID,
+		Metadata:   map[string]any{"userId": target.ID},
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+type apiTokenTestEnv struct {
+	t      *testing.T
+	st     store.Store
+	router *gin.Engine
+}
+
+func newAPITokenTestEnv(t *testing.T) *apiTokenTestEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	st := store.NewMemoryStore()
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithTokenService(auth.NewTokenService(auth.TokenConfig{
+		PublicToken:   "public-token",
+		RefreshSecret: "refresh-secret",
+		AccessSecret:  "access-secret",
+		AccessExpiry:  time.Hour,
+		RefreshExpiry: time.Hour,
+		Store:         st,
+	})))
+	return &apiTokenTestEnv{t: t, st: st, router: router}
+}
+
+func (env *apiTokenTestEnv) user(email, role string, level int, token string) *store.User {
+	env.t.Helper()
+	ctx := context.Background()
+	user := &store.User{Name: email, Email: email, EmailVerified: true, Role: role, Level: level, Active: true}
+	if err := env.st.CreateUser(ctx, user); err != nil {
+		env.t.Fatalf("create %s: %v", email, err)
+	}
+	if err := env.st.CreateSession(ctx, token, user.ID, time.Now().Add(time.Hour)); err != nil {
+		env.t.Fatalf("create session for %s: %v", email, err)
+	}
+	return user
+}
+
+func (env *apiTokenTestEnv) do(method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	env.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (env *apiTokenTestEnv) expect(rr *httptest.ResponseRecorder, status int, what string) {
+	env.t.Helper()
+	if rr.Code != status {
+		env.t.Fatalf("%s: expected %d, got %d: %s", what, status, rr.Code, rr.Body.String())
+	}
+}
+
+// issued decodes the response of a token creation.
+func (env *apiTokenTestEnv) issued(rr *httptest.ResponseRecorder) (string, string) {
+	env.t.Helper()
+	var payload struct {
+		APIToken struct {
+			ID string `json:"id"`
+		} `json:"apiToken"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil || payload.Token == "" {
+		env.t.Fatalf("decode issued token: %v %s", err, rr.Body.String())
+	}
+	return payload.APIToken.ID, payload.Token
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	env := newAPITokenTestEnv(t)
+	operator := env.user("operator@example.com", store.RoleOperator, store.LevelOperator, "operator-session")
+	sessionsPath := "/api/admin/users/" + operator.ID + "/sessions"
+
+	env.expect(env.do(http.MethodPost, "/api/auth/api-tokens", "operator-session", `{"name":"ci","scopes":["admin.everything"]}`),
+		http.StatusBadRequest, "unknown scope")
+	env.expect(env.do(http.MethodPost, "/api/auth/api-tokens", "operator-session", `{"name":"ci","scopes":["admin.users.delete.write"]}`),
+		http.StatusForbidden, "scope the operator does not hold")
+	env.expect(env.do(http.MethodPost, "/api/auth/api-tokens", "operator-session", `{"name":"ci","scopes":["admin.users.sessions.read"],"expiresInDays":400}`),
+		http.StatusBadRequest, "expiry too far out")
+
+	rr := env.do(http.MethodPost, "/api/auth/api-tokens", "operator-session", `{"name":"ci","scopes":["admin.users.sessions.read"]}`)
+	env.expect(rr, http.StatusCreated, "create token")
+	tokenID, secret := env.issued(rr)
+	if !strings.HasPrefix(secret, store.PersonalAccessTokenPrefix) {
+		t.Fatalf("expected a personal access token, got %q", secret)
+	}
+
+	env.expect(env.do(http.MethodGet, sessionsPath, secret, ""), http.StatusOK, "scoped read")
+	env.expect(env.do(http.MethodDelete, sessionsPath, secret, ""), http.StatusForbidden, "write outside the scopes")
+	env.expect(env.do(http.MethodGet, "/api/admin/roles", secret, ""), http.StatusForbidden, "operator permission outside the scopes")
+	env.expect(env.do(http.MethodPost, "/api/auth/api-tokens", secret, `{"name":"again","scopes":["admin.users.sessions.read"]}`),
+		http.StatusUnauthorized, "token minting tokens")
+	env.expect(env.do(http.MethodGet, "/api/auth/xworkmate/profile", secret, ""), http.StatusUnauthorized, "route without token scopes")
+	env.expect(env.do(http.MethodGet, "/api/admin/users/metrics", "pat_forged", ""), http.StatusUnauthorized, "unknown token")
+
+	rr = env.do(http.MethodGet, "/api/auth/api-tokens", "operator-session", "")
+	env.expect(rr, http.StatusOK, "list tokens")
+	if strings.Contains(rr.Body.String(), secret) || !strings.Contains(rr.Body.String(), `"lastUsedAt"`) {
+		t.Fatalf("expected listed tokens to carry last use and no secret, got %s", rr.Body.String())
+	}
+
+	// Tokens follow the owner: demoting the operator takes the scope away.
+	operator.Role = store.RoleUser
+	operator.Level = store.LevelUser
+	if err := env.st.UpdateUser(context.Background(), operator); err != nil {
+		t.Fatalf("demote operator: %v", err)
+	}
+	env.expect(env.do(http.MethodGet, sessionsPath, secret, ""), http.StatusForbidden, "after demotion")
+
+	operator.Role = store.RoleOperator
+	operator.Level = store.LevelOperator
+	operator.Active = false
+	if err := env.st.UpdateUser(context.Background(), operator); err != nil {
+		t.Fatalf("suspend operator: %v", err)
+	}
+	env.expect(env.do(http.MethodGet, sessionsPath, secret, ""), http.StatusForbidden, "after suspension")
+	operator.Active = true
+	if err := env.st.UpdateUser(context.Background(), operator); err != nil {
+		t.Fatalf("resume operator: %v", err)
+	}
+	env.expect(env.do(http.MethodGet, sessionsPath, secret, ""), http.StatusOK, "after resumption")
+
+	env.expect(env.do(http.MethodDelete, "/api/auth/api-tokens/"+tokenID, "operator-session", ""), http.StatusNoContent, "revoke")
+	env.expect(env.do(http.MethodGet, sessionsPath, secret, ""), http.StatusUnauthorized, "revoked token")
+
+	expired := &store.APIToken{
+		Name:      "old",
+		UserID:    operator.ID,
+		Scopes:    []string{permissionAdminUsersSessionsRead},
+		TokenHash: hashAPIToken("pat_expired"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	if err := env.st.CreateAPIToken(context.Background(), expired); err != nil {
+		t.Fatalf("create expired token: %v", err)
+	}
+	env.expect(env.do(http.MethodGet, sessionsPath, "pat_expired", ""), http.StatusUnauthorized, "expired token")
+}
+
+func TestServiceAccountTokens(t *testing.T) {
+	env := newAPITokenTestEnv(t)
+	ctx := context.Background()
+	env.user("admin@example.com", store.RoleAdmin, store.LevelAdmin, "admin-session")
+	env.user("operator@example.com", store.RoleOperator, store.LevelOperator, "operator-session")
+	for _, id := range []string{"acme", "globex"} {
+		if err := env.st.EnsureTenant(ctx, &store.Tenant{ID: id, Name: id, Edition: store.TenantPrivateEdition}); err != nil {
+			t.Fatalf("ensure tenant %s: %v", id, err)
+		}
+	}
+
+	env.expect(env.do(http.MethodPost, "/api/admin/service-accounts", "operator-session", `{"name":"ci"}`),
+		http.StatusForbidden, "operator without admin.service_accounts.write")
+
+	rr := env.do(http.MethodPost, "/api/admin/service-accounts", "admin-session",
+		`{"name":"idp-sync","tenantId":"acme","permissions":["admin.scim.read","admin.scim.write"]}`)
+	env.expect(rr, http.StatusCreated, "create service account")
+	var created struct {
+		ServiceAccount struct {
+			ID string `json:"id"`
+		} `json:"serviceAccount"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode service account: %v", err)
+	}
+	accountPath := "/api/admin/service-accounts/" + created.ServiceAccount.ID
+	env.expect(env.do(http.MethodPost, "/api/admin/service-accounts", "admin-session", `{"name":"IDP-sync","tenantId":"acme"}`),
+		http.StatusConflict, "duplicate name")
+
+	env.expect(env.do(http.MethodPost, accountPath+"/tokens", "admin-session", `{"name":"sync","scopes":["admin.tenants.read"]}`),
+		http.StatusBadRequest, "scope outside the account's permissions")
+	rr = env.do(http.MethodPost, accountPath+"/tokens", "admin-session", `{"name":"sync","scopes":["admin.scim.read","admin.scim.write"]}`)
+	env.expect(rr, http.StatusCreated, "create service account token")
+	_, secret := env.issued(rr)
+	if !strings.HasPrefix(secret, store.ServiceAccountTokenPrefix) {
+		t.Fatalf("expected a service account token, got %q", secret)
+	}
+
+	env.expect(env.do(http.MethodGet, "/api/admin/tenants/acme/scim-tokens", secret, ""), http.StatusOK, "own tenant")
+	env.expect(env.do(http.MethodGet, "/api/admin/tenants/globex/scim-tokens", secret, ""), http.StatusForbidden, "other tenant")
+	env.expect(env.do(http.MethodGet, "/api/admin/roles", secret, ""), http.StatusForbidden, "platform route")
+	rr = env.do(http.MethodPost, "/api/admin/tenants/acme/scim-tokens", secret, `{"description":"okta"}`)
+	env.expect(rr, http.StatusCreated, "write within the tenant")
+
+	events, err := env.st.ListAuditEvents(ctx, store.AuditEventFilter{ActorID: created.ServiceAccount.ID})
+	if err != nil || len(events) != 1 || events[0].Action != auditActionSCIMTokenCreate || events[0].Metadata["apiTokenId"] == nil {
+		t.Fatalf("expected the service account to be the audited actor, got %+v (%v)", events, err)
+	}
+
+	env.expect(env.do(http.MethodPatch, accountPath, "admin-session", `{"disabled":true}`), http.StatusOK, "disable")
+	env.expect(env.do(http.MethodGet, "/api/admin/tenants/acme/scim-tokens", secret, ""), http.StatusForbidden, "disabled account")
+	env.expect(env.do(http.MethodPatch, accountPath, "admin-session", `{"disabled":false,"permissions":["admin.scim.read"]}`), http.StatusOK, "narrow")
+	env.expect(env.do(http.MethodGet, "/api/admin/tenants/acme/scim-tokens", secret, ""), http.StatusOK, "re-enabled")
+	env.expect(env.do(http.MethodPost, "/api/admin/tenants/acme/scim-tokens", secret, `{"description":"okta"}`),
+		http.StatusForbidden, "permission removed from the account")
+
+	env.expect(env.do(http.MethodDelete, accountPath, "admin-session", ""), http.StatusNoContent, "delete service account")
+	env.expect(env.do(http.MethodGet, "/api/admin/tenants/acme/scim-tokens", secret, ""), http.StatusUnauthorized, "deleted account")
+	env.expect(env.do(http.MethodGet, accountPath, "admin-session", ""), http.StatusNotFound, "deleted account lookup")
+}
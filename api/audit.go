@@ -0,0 +1,376 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+// Audit actions. Actions under auth. concern a user's own credentials and
+// make up their security activity; admin. actions are taken by operators or
+// tenant admins, tenant. actions by users joining a tenant, scim. actions by
+// a tenant's identity provider, agent. actions by agents themselves and
+// internal. actions by internal callers.
+const (
+	auditActionLogin                 = "auth.login"
+	auditActionPasswordResetRequest  = "auth.password.reset_requested"
+	auditActionPasswordReset         = "auth.password.reset"
+	auditActionTOTPEnabled           = "auth.mfa.totp_enabled"
+	auditActionMFADisabled           = "auth.mfa.disabled"
+	auditActionPasskeyAdded          = "auth.mfa.passkey_added"
+	auditActionPasskeyRemoved        = "auth.mfa.passkey_removed"
+	auditActionRecoveryCodesReissued = "auth.mfa.recovery_codes_regenerated"
+	auditActionAPITokenCreate        = "auth.api_token.create"
+	auditActionAPITokenRevoke        = "auth.api_token.revoke"
+
+	auditActionUserCreate       = "admin.user.create"
+	auditActionUserRoleUpdate   = "admin.user.role_update"
+	auditActionUserRoleReset    = "admin.user.role_reset"
+	auditActionUserPause        = "admin.user.pause"
+	auditActionUserResume       = "admin.user.resume"
+	auditActionUserDelete       = "admin.user.delete"
+	auditActionUserRenewUUID    = "admin.user.renew_uuid"
+	auditActionUserSessionsKill = "admin.user.sessions_revoke"
+	auditActionAssume           = "admin.assume"
+	auditActionAssumeRevert     = "admin.assume_revert"
+	auditActionBlacklistAdd     = "admin.blacklist.add"
+	auditActionBlacklistRemove  = "admin.blacklist.remove"
+	auditActionSettingsUpdate   = "admin.settings.update"
+	auditActionHomepageVideo    = "admin.settings.homepage_video_update"
+	auditActionTenantBootstrap  = "admin.tenant.bootstrap"
+	auditActionTenantCreate     = "admin.tenant.create"
+	auditActionTenantUpdate     = "admin.tenant.update"
+	auditActionTenantDelete     = "admin.tenant.delete"
+	auditActionSandboxBind      = "admin.sandbox.bind"
+	auditActionWebhookCreate    = "admin.webhook.create"
+	auditActionWebhookUpdate    = "admin.webhook.update"
+	auditActionWebhookDelete    = "admin.webhook.delete"
+	auditActionEventReplay      = "admin.event.replay"
+	auditActionSCIMTokenCreate  = "admin.scim_token.create"
+	auditActionSCIMTokenRevoke  = "admin.scim_token.revoke"
+
+	auditActionTenantDomainAdd     = "admin.tenant.domain_add"
+	auditActionTenantDomainVerify  = "admin.tenant.domain_verify"
+	auditActionTenantDomainPrimary = "admin.tenant.domain_primary"
+	auditActionTenantDomainRemove  = "admin.tenant.domain_remove"
+	auditActionTenantMemberRole    = "admin.tenant.member_role_update"
+	auditActionTenantMemberRemove  = "admin.tenant.member_remove"
+	auditActionTenantInvite        = "admin.tenant.invitation_create"
+	auditActionTenantInviteRevoke  = "admin.tenant.invitation_revoke"
+	auditActionTenantInviteAccept  = "tenant.invitation.accept"
+
+	auditActionRoleCreate        = "admin.role.create"
+	auditActionRoleUpdate        = "admin.role.update"
+	auditActionRoleDelete        = "admin.role.delete"
+	auditActionRoleBindingCreate = "admin.role_binding.create"
+	auditActionRoleBindingDelete = "admin.role_binding.delete"
+
+	auditActionServiceAccountCreate = "admin.service_account.create"
+	auditActionServiceAccountUpdate = "admin.service_account.update"
+	auditActionServiceAccountDelete = "admin.service_account.delete"
+	auditActionAdminAPITokenCreate  = "admin.api_token.create"
+	auditActionAdminAPITokenRevoke  = "admin.api_token.revoke"
+
+	auditActionAgentCreate          = "admin.agent.create"
+	auditActionAgentDelete          = "admin.agent.delete"
+	auditActionAgentTokenRotate     = "admin.agent.token_rotate"
+	auditActionAgentTokenRevoke     = "admin.agent.token_revoke"
+	auditActionAgentJoinTokenCreate = "admin.agent.join_token_create"
+	auditActionAgentJoinTokenRevoke = "admin.agent.join_token_revoke"
+	auditActionAgentEnroll          = "agent.enroll"
+	auditActionAgentCertRenew       = "agent.certificate_renew"
+	auditActionAgentCertRevoke      = "admin.agent.certificates_revoke"
+
+	auditActionSCIMUserCreate  = "scim.user.create"
+	auditActionSCIMUserUpdate  = "scim.user.update"
+	auditActionSCIMUserDelete  = "scim.user.delete"
+	auditActionSCIMGroupCreate = "scim.group.create"
+	auditActionSCIMGroupUpdate = "scim.group.update"
+	auditActionSCIMGroupDelete = "scim.group.delete"
+
+	auditActionNetworkIdentitiesRead = "internal.network_identities.read"
+
+	auditTargetUser      = "user"
+	auditTargetEmail     = "email"
+	auditTargetSettings  = "settings"
+	auditTargetTenant    = "tenant"
+	auditTargetAgent     = "agent"
+	auditTargetWebhook   = "webhook"
+	auditTargetEvent     = "event"
+	auditTargetSCIMToken = "scim_token"
+	auditTargetGroup     = "group"
+
+	auditTargetTenantDomain     = "tenant_domain"
+	auditTargetTenantInvitation = "tenant_invitation"
+	auditTargetRole             = "role"
+	auditTargetRoleBinding      = "role_binding"
+	auditTargetServiceAccount   = "service_account"
+	auditTargetAPIToken         = "api_token"
+	auditTargetNetworkIdentity  = "network_identities"
+	auditTargetAgentJoinToken   = "agent_join_token"
+	auditTargetAgentToken       = "agent_token"
+
+	auditSecurityActionPrefix = "auth."
+
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// recordAudit appends event on behalf of actor, filling in the request
+// details. The action has already happened, so a failed write is logged and
+// does not fail the request.
+func (h *handler) recordAudit(c *gin.Context, actor *store.User, event store.AuditEvent) {
+	if actor != nil {
+		event.ActorID = actor.ID
+		event.ActorEmail = actor.Email
+	}
+	if event.Outcome == "" {
+		event.Outcome = store.AuditOutcomeSuccess
+	}
+	if event.TenantID == "" {
+		event.TenantID = h.auditTenantID(c)
+	}
+	token, viaToken := c.Get(apiTokenContextKey)
+	caller := auth.GetInternalCaller(c)
+	if viaToken || caller != "" {
+		metadata := make(map[string]any, len(event.Metadata)+1)
+		for key, value := range event.Metadata {
+			metadata[key] = value
+		}
+		if viaToken {
+			metadata["apiTokenId"] = token.(*store.APIToken).ID
+		}
+		if caller != "" {
+			metadata["internalCaller"] = caller
+		}
+		event.Metadata = metadata
+	}
+	event.IPAddress = c.ClientIP()
+	event.UserAgent = sessionUserAgent(c)
+	if err := h.store.AppendAuditEvent(c.Request.Context(), &event); err != nil {
+		slog.Warn("failed to record audit event", "err", err, "action", event.Action, "actorID", event.ActorID, "targetID", event.TargetID)
+	}
+}
+
+// recordUserAudit records an action a user took on their own account.
+func (h *handler) recordUserAudit(c *gin.Context, user *store.User, action string, metadata map[string]any) {
+	h.recordAudit(c, user, store.AuditEvent{
+		Action:     action,
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   metadata,
+	})
+}
+
+// recordLoginFailure records a rejected credential for a known account.
+func (h *handler) recordLoginFailure(c *gin.Context, user *store.User, method, reason string) {
+	h.recordAudit(c, user, store.AuditEvent{
+		Action:     auditActionLogin,
+		Outcome:    store.AuditOutcomeFailure,
+		TargetType: auditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   map[string]any{"method": method, "reason": reason},
+	})
+}
+
+// recordUserChange records an admin action on target, keeping only the user
+// fields it changed.
+func (h *handler) recordUserChange(c *gin.Context, actor *store.User, action string, target *store.User, before map[string]any) {
+	changedBefore, changedAfter := auditDiff(before, auditUserFields(target))
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     action,
+		TargetType: auditTargetUser,
+		TargetID:   target.ID,
+		Before:     changedBefore,
+		After:      changedAfter,
+		Metadata:   map[string]any{"email": target.Email},
+	})
+}
+
+// auditTenantID resolves the tenant served on the request host, if any.
+func (h *handler) auditTenantID(c *gin.Context) string {
+	tenant, _, err := h.store.ResolveTenantByHost(c.Request.Context(), h.resolveTenantHost(c))
+	if err != nil || tenant == nil {
+		return ""
+	}
+	return tenant.ID
+}
+
+// auditUserFields snapshots the user fields admin actions may change.
+// Credentials, including the proxy UUID, are deliberately left out.
+func auditUserFields(user *store.User) map[string]any {
+	if user == nil {
+		return nil
+	}
+	fields := map[string]any{
+		"role":          user.Role,
+		"level":         user.Level,
+		"active":        user.Active,
+		"groups":        append([]string(nil), user.Groups...),
+		"emailVerified": user.EmailVerified,
+		"mfaEnabled":    user.MFAEnabled,
+	}
+	if user.ProxyUUIDExpiresAt != nil {
+		fields["proxyUuidExpiresAt"] = user.ProxyUUIDExpiresAt.UTC()
+	} else {
+		fields["proxyUuidExpiresAt"] = nil
+	}
+	return fields
+}
+
+// auditDiff reduces two snapshots to the keys whose values differ.
+func auditDiff(before, after map[string]any) (map[string]any, map[string]any) {
+	changedBefore := make(map[string]any)
+	changedAfter := make(map[string]any)
+	for key := range before {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			changedBefore[key] = before[key]
+		}
+	}
+	for key := range after {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			changedAfter[key] = after[key]
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+type auditEventResponse struct {
+	ID         string         `json:"id"`
+	TenantID   string         `json:"tenantId,omitempty"`
+	ActorID    string         `json:"actorId,omitempty"`
+	ActorEmail string         `json:"actorEmail,omitempty"`
+	Action     string         `json:"action"`
+	Outcome    string         `json:"outcome"`
+	TargetType string         `json:"targetType,omitempty"`
+	TargetID   string         `json:"targetId,omitempty"`
+	IPAddress  string         `json:"ipAddress"`
+	UserAgent  string         `json:"userAgent"`
+	Before     map[string]any `json:"before,omitempty"`
+	After      map[string]any `json:"after,omitempty"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+}
+
+func newAuditEventResponses(events []store.AuditEvent) []auditEventResponse {
+	responses := make([]auditEventResponse, 0, len(events))
+	for _, event := range events {
+		responses = append(responses, auditEventResponse{
+			ID:         event.ID,
+			TenantID:   event.TenantID,
+			ActorID:    event.ActorID,
+			ActorEmail: event.ActorEmail,
+			Action:     event.Action,
+			Outcome:    event.Outcome,
+			TargetType: event.TargetType,
+			TargetID:   event.TargetID,
+			IPAddress:  event.IPAddress,
+			UserAgent:  event.UserAgent,
+			Before:     event.Before,
+			After:      event.After,
+			Metadata:   event.Metadata,
+			CreatedAt:  event.CreatedAt.UTC(),
+		})
+	}
+	return responses
+}
+
+// parseAuditPage reads the limit and cursor query parameters into filter.
+func parseAuditPage(c *gin.Context, filter *store.AuditEventFilter) bool {
+	filter.Limit = defaultAuditPageSize
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			respondError(c, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+			return false
+		}
+		filter.Limit = min(parsed, maxAuditPageSize)
+	}
+	filter.After = strings.TrimSpace(c.Query("cursor"))
+	return true
+}
+
+// respondAuditPage lists one page of events. One extra event is fetched to
+// tell whether another page follows.
+func (h *handler) respondAuditPage(c *gin.Context, filter store.AuditEventFilter) {
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+	events, err := h.store.ListAuditEvents(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, store.ErrAuditCursorNotFound) {
+			respondError(c, http.StatusBadRequest, "invalid_cursor", "cursor is invalid")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "audit_list_failed", "failed to list audit events")
+		return
+	}
+
+	nextCursor := ""
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		nextCursor = events[pageSize-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events":     newAuditEventResponses(events),
+		"nextCursor": nextCursor,
+	})
+}
+
+// listAuditEvents serves the admin audit log with filtering and paging.
+func (h *handler) listAuditEvents(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminAuditRead); !ok {
+		return
+	}
+
+	filter := store.AuditEventFilter{
+		TenantID:     strings.TrimSpace(c.Query("tenantId")),
+		ActorID:      strings.TrimSpace(c.Query("actorId")),
+		TargetType:   strings.TrimSpace(c.Query("targetType")),
+		TargetID:     strings.TrimSpace(c.Query("targetId")),
+		ActionPrefix: strings.TrimSpace(c.Query("action")),
+		Outcome:      strings.TrimSpace(c.Query("outcome")),
+	}
+	for name, into := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		raw := strings.TrimSpace(c.Query(name))
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_"+name, name+" must be an RFC 3339 timestamp")
+			return
+		}
+		*into = parsed
+	}
+	if !parseAuditPage(c, &filter) {
+		return
+	}
+	h.respondAuditPage(c, filter)
+}
+
+// listSecurityActivity returns the caller's sign-ins and credential changes.
+func (h *handler) listSecurityActivity(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	filter := store.AuditEventFilter{
+		TargetType:   auditTargetUser,
+		TargetID:     user.ID,
+		ActionPrefix: auditSecurityActionPrefix,
+	}
+	if !parseAuditPage(c, &filter) {
+		return
+	}
+	h.respondAuditPage(c, filter)
+}
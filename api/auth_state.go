@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"account/internal/cache"
+)
+
+// Namespaces of the short-lived authentication state kept in the handler's
+// cache.StateStore.
+const (
+	authStateMFAChallenges             = "mfa_challenge"
+	authStateEmailVerifications        = "email_verification"
+	authStateRegistrationVerifications = "registration_verification"
+	authStatePasswordResets            = "password_reset"
+	authStateOAuthExchangeCodes        = "oauth_exchange_code"
+	authStateOIDCCodes                 = "oidc_authorization_code"
+	authStateOAuthLinks                = "oauth_identity_link"
+	authStateWebAuthnCeremonies        = "webauthn_ceremony"
+)
+
+// errAuthStateExpired signals that a record was found but has expired.
+var errAuthStateExpired = errors.New("auth state expired")
+
+type mfaChallengeRecord struct {
+	UserID         string    `json:"userId"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	TOTPSecret     string    `json:"totpSecret,omitempty"`
+	TOTPIssuer     string    `json:"totpIssuer,omitempty"`
+	TOTPAccount    string    `json:"totpAccount,omitempty"`
+	TOTPIssuedAt   time.Time `json:"totpIssuedAt,omitempty"`
+	FailedAttempts int       `json:"failedAttempts,omitempty"`
+	LockedUntil    time.Time `json:"lockedUntil,omitempty"`
+	// WebAuthnSession is the pending passkey assertion for this challenge.
+	WebAuthnSession *webauthn.SessionData `json:"webauthnSession,omitempty"`
+}
+
+func (c mfaChallenge) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mfaChallengeRecord{
+		UserID:          c.userID,
+		ExpiresAt:       c.expiresAt,
+		TOTPSecret:      c.totpSecret,
+		TOTPIssuer:      c.totpIssuer,
+		TOTPAccount:     c.totpAccount,
+		TOTPIssuedAt:    c.totpIssuedAt,
+		FailedAttempts:  c.failedAttempts,
+		LockedUntil:     c.lockedUntil,
+		WebAuthnSession: c.webauthnSession,
+	})
+}
+
+func (c *mfaChallenge) UnmarshalJSON(data []byte) error {
+	var record mfaChallengeRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*c = mfaChallenge{
+		userID:          record.UserID,
+		expiresAt:       record.ExpiresAt,
+		totpSecret:      record.TOTPSecret,
+		totpIssuer:      record.TOTPIssuer,
+		totpAccount:     record.TOTPAccount,
+		totpIssuedAt:    record.TOTPIssuedAt,
+		failedAttempts:  record.FailedAttempts,
+		lockedUntil:     record.LockedUntil,
+		webauthnSession: record.WebAuthnSession,
+	}
+	return nil
+}
+
+type emailVerificationRecord struct {
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (v emailVerification) MarshalJSON() ([]byte, error) {
+	return json.Marshal(emailVerificationRecord{UserID: v.userID, Email: v.email, Code: v.code, ExpiresAt: v.expiresAt})
+}
+
+func (v *emailVerification) UnmarshalJSON(data []byte) error {
+	var record emailVerificationRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*v = emailVerification{userID: record.UserID, email: record.Email, code: record.Code, expiresAt: record.ExpiresAt}
+	return nil
+}
+
+type registrationVerificationRecord struct {
+	Email     string    `json:"email"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Verified  bool      `json:"verified,omitempty"`
+}
+
+func (v registrationVerification) MarshalJSON() ([]byte, error) {
+	return json.Marshal(registrationVerificationRecord{Email: v.email, Code: v.code, ExpiresAt: v.expiresAt, Verified: v.verified})
+}
+
+func (v *registrationVerification) UnmarshalJSON(data []byte) error {
+	var record registrationVerificationRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*v = registrationVerification{email: record.Email, code: record.Code, expiresAt: record.ExpiresAt, verified: record.Verified}
+	return nil
+}
+
+type passwordResetRecord struct {
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (r passwordReset) MarshalJSON() ([]byte, error) {
+	return json.Marshal(passwordResetRecord{UserID: r.userID, Email: r.email, ExpiresAt: r.expiresAt})
+}
+
+func (r *passwordReset) UnmarshalJSON(data []byte) error {
+	var record passwordResetRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*r = passwordReset{userID: record.UserID, email: record.Email, expiresAt: record.ExpiresAt}
+	return nil
+}
+
+type oauthExchangeCodeRecord struct {
+	SessionToken     string    `json:"sessionToken"`
+	SessionExpiresAt time.Time `json:"sessionExpiresAt"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+func (c oauthExchangeCode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(oauthExchangeCodeRecord{SessionToken: c.sessionToken, SessionExpiresAt: c.sessionExpiresAt, ExpiresAt: c.expiresAt})
+}
+
+func (c *oauthExchangeCode) UnmarshalJSON(data []byte) error {
+	var record oauthExchangeCodeRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*c = oauthExchangeCode{sessionToken: record.SessionToken, sessionExpiresAt: record.SessionExpiresAt, expiresAt: record.ExpiresAt}
+	return nil
+}
+
+// putAuthState stores value until expiresAt.
+func (h *handler) putAuthState(namespace, key string, value any, expiresAt time.Time) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return h.authState.Set(context.Background(), namespace, key, payload, time.Until(expiresAt))
+}
+
+// getAuthState loads the record stored under key into target. Backend
+// failures are logged and reported as a miss.
+func (h *handler) getAuthState(namespace, key string, target any) bool {
+	payload, err := h.authState.Get(context.Background(), namespace, key)
+	return decodeAuthState(namespace, payload, err, target)
+}
+
+// takeAuthState atomically loads and removes the record stored under key.
+func (h *handler) takeAuthState(namespace, key string, target any) bool {
+	payload, err := h.authState.Take(context.Background(), namespace, key)
+	return decodeAuthState(namespace, payload, err, target)
+}
+
+func (h *handler) deleteAuthState(namespace, key string) {
+	if err := h.authState.Delete(context.Background(), namespace, key); err != nil {
+		slog.Warn("failed to delete auth state", "namespace", namespace, "err", err)
+	}
+}
+
+func decodeAuthState(namespace string, payload []byte, err error, target any) bool {
+	if err != nil {
+		if !errors.Is(err, cache.ErrStateNotFound) {
+			slog.Warn("failed to load auth state", "namespace", namespace, "err", err)
+		}
+		return false
+	}
+	if err := json.Unmarshal(payload, target); err != nil {
+		slog.Warn("failed to decode auth state", "namespace", namespace, "err", err)
+		return false
+	}
+	return true
+}
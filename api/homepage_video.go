@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"account/internal/service"
+	"account/internal/store"
 )
 
 type homepageVideoEntryPayload struct {
@@ -24,6 +25,17 @@ func toHomepageVideoEntryPayload(entry service.HomepageVideoEntry) homepageVideo
 	}
 }
 
+// flattenHomepageVideoSettings keys entries by domain, with "default" for the
+// fallback entry, so an audit diff lists the entries that changed.
+func flattenHomepageVideoSettings(settings service.HomepageVideoSettings) map[string]any {
+	flat := map[string]any{"default": toHomepageVideoEntryPayload(settings.DefaultEntry)}
+	for _, item := range settings.Overrides {
+		entry := toHomepageVideoEntryPayload(item)
+		flat[entry.Domain] = entry
+	}
+	return flat
+}
+
 func (h *handler) getHomepageVideoPublic(c *gin.Context) {
 	entry, err := service.ResolveHomepageVideoEntry(c.Request.Context(), h.resolveTenantHost(c))
 	if err != nil {
@@ -77,6 +89,11 @@ func (h *handler) updateHomepageVideoSettings(c *gin.Context) {
 		return
 	}
 
+	previous, err := service.GetHomepageVideoSettings(c.Request.Context())
+	if err != nil {
+		previous = service.HomepageVideoSettings{}
+	}
+
 	overrides := make([]service.HomepageVideoEntry, 0, len(req.Overrides))
 	for _, item := range req.Overrides {
 		overrides = append(overrides, service.HomepageVideoEntry{
@@ -106,6 +123,14 @@ func (h *handler) updateHomepageVideoSettings(c *gin.Context) {
 	for _, item := range settings.Overrides {
 		responseOverrides = append(responseOverrides, toHomepageVideoEntryPayload(item))
 	}
+	before, after := auditDiff(flattenHomepageVideoSettings(previous), flattenHomepageVideoSettings(settings))
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionHomepageVideo,
+		TargetType: auditTargetSettings,
+		TargetID:   "homepage_video",
+		Before:     before,
+		After:      after,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"defaultEntry": toHomepageVideoEntryPayload(settings.DefaultEntry),
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+// oauthLinkTTL bounds how long a started link may wait for the provider
+// callback.
+const oauthLinkTTL = 10 * time.Minute
+
+// oauthLinkCookieName holds the state nonce of the link the browser started.
+// The callback only attaches an identity when it comes back to the same
+// browser, so a link URL handed to someone else cannot attach their
+// provider account to the initiating user.
+const oauthLinkCookieName = "xc_oauth_link"
+
+// oauthLink records an authenticated user's request to attach a provider
+// identity. It is keyed by the nonce of the OAuth state parameter.
+type oauthLink struct {
+	UserID    string    `json:"userId"`
+	Provider  string    `json:"provider"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type identityResponse struct {
+	ID         string    `json:"id"`
+	Provider   string    `json:"provider"`
+	ExternalID string    `json:"externalId"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func newIdentityResponses(identities []store.Identity) []identityResponse {
+	responses := make([]identityResponse, 0, len(identities))
+	for _, identity := range identities {
+		responses = append(responses, identityResponse{
+			ID:         identity.ID,
+			Provider:   identity.Provider,
+			ExternalID: identity.ExternalID,
+			CreatedAt:  identity.CreatedAt.UTC(),
+		})
+	}
+	return responses
+}
+
+func oauthStateNonce(state string) string {
+	return strings.SplitN(strings.TrimSpace(state), ".", 2)[0]
+}
+
+func (h *handler) listIdentities(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	identities, err := h.store.ListIdentitiesByUser(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_identities_failed", "failed to list identities")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"identities":  newIdentityResponses(identities),
+		"hasPassword": strings.TrimSpace(user.PasswordHash) != "",
+	})
+}
+
+// startIdentityLink returns the provider authorization URL. The provider
+// redirects back to the regular OAuth callback, which attaches the identity
+// to the current user instead of signing in.
+func (h *handler) startIdentityLink(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	if h.isReadOnlyAccount(user) {
+		respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
+		return
+	}
+
+	providerName := c.Param("provider")
+	provider, ok := h.oauthProviders[providerName]
+	if !ok {
+		respondError(c, http.StatusNotFound, "provider_not_found", "oauth provider not found")
+		return
+	}
+
+	state := buildOAuthState(h.resolveFrontendURL(c))
+	expiresAt := time.Now().Add(oauthLinkTTL)
+	if err := h.putAuthState(authStateOAuthLinks, oauthStateNonce(state), oauthLink{
+		UserID:    user.ID,
+		Provider:  providerName,
+		ExpiresAt: expiresAt,
+	}, expiresAt); err != nil {
+		respondError(c, http.StatusInternalServerError, "identity_link_failed", "failed to start identity link")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthLinkCookieName, oauthStateNonce(state), int(oauthLinkTTL.Seconds()), "/", h.getCookieDomain(), c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"authorizationUrl": provider.AuthCodeURL(state)})
+}
+
+// completeIdentityLink finishes the link started by startIdentityLink under
+// nonce and redirects back to the frontend with the outcome. The link is
+// refused unless the browser carries the cookie startIdentityLink set.
+func (h *handler) completeIdentityLink(c *gin.Context, nonce string, link oauthLink, provider auth.OAuthProvider, code string) {
+	ctx := c.Request.Context()
+	outcome := func(errorCode string) {
+		query := url.Values{"provider": {link.Provider}}
+		if errorCode == "" {
+			query.Set("oauth_link", "linked")
+		} else {
+			query.Set("oauth_link_error", errorCode)
+		}
+		target := fmt.Sprintf("%s/panel/account?%s", strings.TrimSuffix(h.oauthRedirectFrontendURL(c), "/"), query.Encode())
+		c.Redirect(http.StatusTemporaryRedirect, target)
+	}
+
+	cookie, err := c.Cookie(oauthLinkCookieName)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthLinkCookieName, "", -1, "/", h.getCookieDomain(), c.Request.TLS != nil, true)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(nonce)) != 1 {
+		outcome("link_session_mismatch")
+		return
+	}
+	if time.Now().After(link.ExpiresAt) {
+		outcome("link_expired")
+		return
+	}
+	token, err := provider.Exchange(ctx, code)
+	if err != nil {
+		outcome("oauth_exchange_failed")
+		return
+	}
+	profile, err := provider.FetchProfile(ctx, token)
+	if err != nil || strings.TrimSpace(profile.ID) == "" {
+		outcome("fetch_profile_failed")
+		return
+	}
+
+	existing, err := h.store.GetIdentity(ctx, link.Provider, profile.ID)
+	switch {
+	case err == nil && existing.UserID == link.UserID:
+		outcome("")
+		return
+	case err == nil:
+		outcome("identity_already_linked")
+		return
+	case !errors.Is(err, store.ErrIdentityNotFound):
+		outcome("store_error")
+		return
+	}
+
+	identity := &store.Identity{UserID: link.UserID, Provider: link.Provider, ExternalID: profile.ID}
+	if err := h.store.CreateIdentity(ctx, identity); err != nil {
+		if errors.Is(err, store.ErrIdentityExists) {
+			outcome("identity_already_linked")
+			return
+		}
+		slog.Warn("failed to link identity", "err", err, "userID", link.UserID, "provider", link.Provider)
+		outcome("identity_link_failed")
+		return
+	}
+	outcome("")
+}
+
+// unlinkIdentity removes a linked identity unless it is the user's last way
+// to sign in.
+func (h *handler) unlinkIdentity(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	if h.isReadOnlyAccount(user) {
+		respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
+		return
+	}
+
+	ctx := c.Request.Context()
+	identityID := strings.TrimSpace(c.Param("id"))
+	identities, err := h.store.ListIdentitiesByUser(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_identities_failed", "failed to list identities")
+		return
+	}
+	found := false
+	for _, identity := range identities {
+		if identity.ID == identityID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "identity_not_found", "identity not found")
+		return
+	}
+	if strings.TrimSpace(user.PasswordHash) == "" && len(identities) <= 1 {
+		passkeys, err := h.store.ListWebAuthnCredentialsByUser(ctx, user.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "list_identities_failed", "failed to list sign-in methods")
+			return
+		}
+		if len(passkeys) == 0 {
+			respondError(c, http.StatusConflict, "last_login_method", "set a password or link another provider before unlinking this identity")
+			return
+		}
+	}
+
+	if _, err := h.store.DeleteIdentity(ctx, user.ID, identityID); err != nil {
+		if errors.Is(err, store.ErrIdentityNotFound) {
+			respondError(c, http.StatusNotFound, "identity_not_found", "identity not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "unlink_identity_failed", "failed to unlink identity")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+func TestInternalCallersRoutesAndRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "")
+
+	now := time.Now()
+	callers, err := auth.NewInternalCallers([]auth.InternalCaller{
+		{
+			Name: "xray-exporter",
+			Tokens: []auth.InternalCallerToken{
+				{Token: "exporter-old", NotAfter: now.Add(-time.Minute)},
+				{Token: "exporter-current", NotAfter: now.Add(time.Hour)},
+				{Token: "exporter-next", NotBefore: now.Add(-time.Minute)},
+				{Token: "exporter-future", NotBefore: now.Add(time.Hour)},
+			},
+			Routes: []string{"GET /api/internal/network/identities"},
+		},
+		{
+			Name:   "node-agent",
+			Tokens: []auth.InternalCallerToken{{Token: "agent-token"}},
+			Routes: []string{"/api/internal/nodes/*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("build internal callers: %v", err)
+	}
+
+	st := store.NewMemoryStore()
+	if err := st.CreateUser(context.Background(), &store.User{
+		Name: "Proxy User", Email: "proxy@example.com", EmailVerified: true,
+		Role: store.RoleUser, Level: store.LevelUser, Active: true, ProxyUUID: "proxy-id",
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st), WithInternalCallers(callers))
+
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set(auth.InternalServiceTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"missing token", http.MethodGet, "/api/internal/network/identities", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/api/internal/network/identities", "nope", http.StatusUnauthorized},
+		{"rotated out", http.MethodGet, "/api/internal/network/identities", "exporter-old", http.StatusUnauthorized},
+		{"not yet valid", http.MethodGet, "/api/internal/network/identities", "exporter-future", http.StatusUnauthorized},
+		{"current token", http.MethodGet, "/api/internal/network/identities", "exporter-current", http.StatusOK},
+		{"overlapping next token", http.MethodGet, "/api/internal/network/identities", "exporter-next", http.StatusOK},
+		{"route outside the list", http.MethodGet, "/api/internal/sandbox/guest", "exporter-current", http.StatusForbidden},
+		{"other caller", http.MethodGet, "/api/internal/network/identities", "agent-token", http.StatusForbidden},
+		{"prefix route", http.MethodPost, "/api/internal/nodes/heartbeat", "agent-token", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if got := call(tc.method, tc.path, tc.token); got != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, got)
+		}
+	}
+
+	events, err := st.ListAuditEvents(context.Background(), store.AuditEventFilter{ActionPrefix: auditActionNetworkIdentitiesRead})
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected one audit event per identities export, got %d", len(events))
+	}
+	for _, event := range events {
+		if event.Metadata["internalCaller"] != "xray-exporter" {
+			t.Fatalf("expected the caller in the audit metadata, got %+v", event.Metadata)
+		}
+	}
+}
+
+func TestInternalCallersWithoutConfiguration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "")
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(store.NewMemoryStore()))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/network/identities", nil)
+	req.Header.Set(auth.InternalServiceTokenHeader, "anything")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected unconfigured internal API to be unavailable, got %d", rec.Code)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
 )
 
 type internalNetworkIdentity struct {
@@ -46,6 +48,14 @@ func (h *handler) internalNetworkIdentities(c *gin.Context) {
 		})
 	}
 
+	// The export holds every active user's email, so record which caller
+	// took it.
+	h.recordAudit(c, nil, store.AuditEvent{
+		Action:     auditActionNetworkIdentitiesRead,
+		TargetType: auditTargetNetworkIdentity,
+		Metadata:   map[string]any{"count": len(identities)},
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"generatedAt": time.Now().UTC(),
 		"identities":  identities,
@@ -1,25 +1,25 @@
 package api
 
 import (
-	"os"
-	"strings"
-
 	"github.com/gin-gonic/gin"
-)
 
-const internalServiceTokenHeader = "X-Service-Token"
+	"account/internal/auth"
+)
 
-func isInternalServiceRequest(c *gin.Context) bool {
-	if c == nil {
+// isInternalServiceRequest reports whether a public route is being called by
+// an internal caller allowed to use it, such as the Console BFF resolving the
+// sandbox user. The caller is recorded on the request for logs and audit.
+func (h *handler) isInternalServiceRequest(c *gin.Context) bool {
+	if c == nil || h.internalCallers.Empty() {
 		return false
 	}
-	token := strings.TrimSpace(c.GetHeader(internalServiceTokenHeader))
-	if token == "" {
+	identity, err := h.internalCallers.Authenticate(c.Request)
+	if err != nil {
 		return false
 	}
-	expected := strings.TrimSpace(os.Getenv("INTERNAL_SERVICE_TOKEN"))
-	if expected == "" {
+	if !h.internalCallers.Allows(identity.Caller, c.Request.Method, c.FullPath()) {
 		return false
 	}
-	return token == expected
+	auth.SetInternalCaller(c, identity.Caller)
+	return true
 }
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+)
+
+// jwksMaxAge bounds how long verifiers cache the key set. It must stay below
+// the signing key publish-ahead window so new keys are seen before use.
+const jwksMaxAge = "public, max-age=900"
+
+// jwks publishes the public access token signing keys so other services can
+// verify tokens offline.
+func (h *handler) jwks(c *gin.Context) {
+	set := auth.JWKSet{Keys: []auth.JWK{}}
+	if h.tokenService != nil {
+		set = h.tokenService.JWKS()
+	}
+	c.Header("Cache-Control", jwksMaxAge)
+	c.JSON(http.StatusOK, set)
+}
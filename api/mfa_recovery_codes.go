@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+const (
+	mfaMethodRecoveryCode = "recovery_code"
+
+	mfaRecoveryCodeCount  = 10
+	mfaRecoveryCodeLength = 10
+	// mfaRecoveryCodeAlphabet has 32 symbols so every random byte maps to one
+	// without bias; l and o are left out to avoid confusion with 1 and 0.
+	mfaRecoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+)
+
+// generateMFARecoveryCodes returns a fresh set of codes formatted for
+// display (xxxxx-xxxxx) together with the hashes to store.
+func generateMFARecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+	hashes := make([]string, 0, mfaRecoveryCodeCount)
+	buf := make([]byte, mfaRecoveryCodeLength)
+	for range mfaRecoveryCodeCount {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for i, v := range buf {
+			if i == mfaRecoveryCodeLength/2 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(mfaRecoveryCodeAlphabet[int(v)%len(mfaRecoveryCodeAlphabet)])
+		}
+		code := b.String()
+		codes = append(codes, code)
+		hashes = append(hashes, hashMFARecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashMFARecoveryCode hashes a code after dropping case, spaces and dashes.
+// Codes carry 50 random bits, so an unsalted SHA-256 is enough and keeps
+// lookups a single indexed query.
+func hashMFARecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueMFARecoveryCodes replaces the user's recovery codes and returns the
+// new plaintext codes. They are never retrievable again.
+func (h *handler) issueMFARecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes, hashes, err := generateMFARecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.store.ReplaceMFARecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// dropUnusedMFARecoveryCodes removes the user's recovery codes once no
+// second factor is left for them to stand in for.
+func (h *handler) dropUnusedMFARecoveryCodes(ctx context.Context, user *store.User) {
+	if len(h.mfaMethods(ctx, user)) > 0 {
+		return
+	}
+	if err := h.store.ReplaceMFARecoveryCodes(ctx, user.ID, nil); err != nil {
+		slog.Warn("failed to remove mfa recovery codes", "err", err, "userID", user.ID)
+	}
+}
+
+func (h *handler) remainingMFARecoveryCodes(ctx context.Context, userID string) (int, error) {
+	codes, err := h.store.ListMFARecoveryCodes(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	remaining := 0
+	for _, code := range codes {
+		if code.UsedAt == nil {
+			remaining++
+		}
+	}
+	return remaining, nil
+}
+
+// redeemMFARecoveryCode spends one of the user's recovery codes in place of
+// a second factor, recording where it was used and emailing the user.
+func (h *handler) redeemMFARecoveryCode(c *gin.Context, user *store.User, code string) error {
+	ctx := c.Request.Context()
+	used, err := h.store.ConsumeMFARecoveryCode(ctx, user.ID, hashMFARecoveryCode(code), c.ClientIP(), sessionUserAgent(c), time.Now())
+	if err != nil {
+		return err
+	}
+
+	remaining, err := h.remainingMFARecoveryCodes(ctx, user.ID)
+	if err != nil {
+		slog.Warn("failed to count remaining mfa recovery codes", "err", err, "userID", user.ID)
+	}
+	slog.Info("mfa recovery code used", "userID", user.ID, "codeID", used.ID, "ip", used.UsedIP, "remaining", remaining)
+	if err := h.sendMFARecoveryCodeNotice(ctx, user, used, remaining); err != nil {
+		slog.Warn("failed to send mfa recovery code notice", "err", err, "userID", user.ID)
+	}
+	return nil
+}
+
+func (h *handler) sendMFARecoveryCodeNotice(ctx context.Context, user *store.User, used *store.MFARecoveryCode, remaining int) error {
+	email := strings.TrimSpace(user.Email)
+	if email == "" {
+		return nil
+	}
+
+	name := strings.TrimSpace(user.Name)
+	if name == "" {
+		name = "there"
+	}
+	usedAt := time.Now().UTC()
+	if used.UsedAt != nil {
+		usedAt = used.UsedAt.UTC()
+	}
+	ip := used.UsedIP
+	if ip == "" {
+		ip = "an unknown address"
+	}
+
+	subject := "A recovery code was used to sign in to your XControl account"
+	plainBody := fmt.Sprintf("Hello %s,\n\nA multi-factor recovery code was used to sign in to your XControl account at %s UTC from %s.\nYou have %d unused recovery codes left.\n\nIf this was not you, change your password and generate new recovery codes immediately.\n", name, usedAt.Format(time.RFC3339), ip, remaining)
+	htmlBody := fmt.Sprintf("<p>Hello %s,</p><p>A multi-factor recovery code was used to sign in to your XControl account at %s UTC from %s.</p><p>You have <strong>%d</strong> unused recovery codes left.</p><p>If this was not you, change your password and generate new recovery codes immediately.</p>", html.EscapeString(name), usedAt.Format(time.RFC3339), html.EscapeString(ip), remaining)
+
+	return h.emailSender.Send(ctx, EmailMessage{
+		To:        []string{email},
+		Subject:   subject,
+		PlainBody: plainBody,
+		HTMLBody:  htmlBody,
+	})
+}
+
+// regenerateMFARecoveryCodes invalidates the caller's recovery codes and
+// returns a new set.
+func (h *handler) regenerateMFARecoveryCodes(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	if h.isReadOnlyAccount(user) {
+		respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if len(h.mfaMethods(ctx, user)) == 0 {
+		respondError(c, http.StatusBadRequest, "mfa_not_enabled", "multi-factor authentication is not enabled")
+		return
+	}
+
+	codes, err := h.issueMFARecoveryCodes(ctx, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "recovery_codes_failed", "failed to generate recovery codes")
+		return
+	}
+	h.recordUserAudit(c, user, auditActionRecoveryCodesReissued, nil)
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
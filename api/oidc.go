@@ -0,0 +1,572 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+const (
+	defaultOIDCCodeTTL = time.Minute
+
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	oidcJWKSPath      = "/.well-known/jwks.json"
+	oidcAuthorizePath = "/oidc/authorize"
+	oidcTokenPath     = "/oidc/token"
+	oidcUserInfoPath  = "/oidc/userinfo"
+)
+
+// OIDCConfig enables the built-in OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer is the public base URL of this service, for example
+	// https://accounts.svc.plus. The provider stays disabled without it.
+	Issuer string
+	// LoginURL is the sign-in page users without a session are sent to. The
+	// authorization request to resume is passed in the redirect query
+	// parameter. It defaults to {OAuth frontend URL}/login.
+	LoginURL string
+	// CodeTTL bounds how long an authorization code can be redeemed.
+	CodeTTL time.Duration
+}
+
+type oidcProvider struct {
+	issuer   string
+	loginURL string
+	codeTTL  time.Duration
+}
+
+// WithOIDCProvider enables the OpenID Connect provider endpoints.
+func WithOIDCProvider(cfg OIDCConfig) Option {
+	return func(h *handler) {
+		issuer := strings.TrimRight(strings.TrimSpace(cfg.Issuer), "/")
+		if issuer == "" {
+			h.oidc = nil
+			return
+		}
+		codeTTL := cfg.CodeTTL
+		if codeTTL <= 0 {
+			codeTTL = defaultOIDCCodeTTL
+		}
+		h.oidc = &oidcProvider{
+			issuer:   issuer,
+			loginURL: strings.TrimSpace(cfg.LoginURL),
+			codeTTL:  codeTTL,
+		}
+	}
+}
+
+// oidcAuthorizationCode is the state kept between /authorize and /token.
+type oidcAuthorizationCode struct {
+	ClientID      string    `json:"clientId"`
+	RedirectURI   string    `json:"redirectUri"`
+	UserID        string    `json:"userId"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"codeChallenge"`
+	AuthTime      time.Time `json:"authTime"`
+	AuthMethod    string    `json:"authMethod,omitempty"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+// oidcError writes an OAuth 2.0 error response (RFC 6749 section 5.2).
+func oidcError(c *gin.Context, status int, code, description string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// oidcAvailable reports whether the provider can serve requests. ID tokens
+// must be verifiable by relying parties, so asymmetric signing keys are
+// required.
+func (h *handler) oidcAvailable(c *gin.Context) bool {
+	if h.oidc == nil {
+		respondError(c, http.StatusNotFound, "oidc_disabled", "openid connect provider is not enabled")
+		return false
+	}
+	if h.tokenService == nil || len(h.tokenService.JWKS().Keys) == 0 {
+		respondError(c, http.StatusServiceUnavailable, "oidc_unavailable", "openid connect requires asymmetric token signing keys")
+		return false
+	}
+	return true
+}
+
+func (h *handler) oidcDiscovery(c *gin.Context) {
+	if !h.oidcAvailable(c) {
+		return
+	}
+
+	algorithms := make([]string, 0, 2)
+	for _, key := range h.tokenService.JWKS().Keys {
+		if !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	issuer := h.oidc.issuer
+	c.Header("Cache-Control", jwksMaxAge)
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + oidcAuthorizePath,
+		"token_endpoint":                        issuer + oidcTokenPath,
+		"userinfo_endpoint":                     issuer + oidcUserInfoPath,
+		"jwks_uri":                              issuer + oidcJWKSPath,
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algorithms,
+		"scopes_supported":                      auth.SupportedOIDCScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{auth.PKCEMethodS256},
+		"prompt_values_supported":               []string{"none", "login"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr", "azp",
+			"name", "updated_at", "email", "email_verified", "role", "groups", "tenants",
+		},
+	})
+}
+
+// oidcAuthorize implements the authorization code flow with mandatory PKCE.
+// Authentication reuses the regular session: users without one are sent to
+// the sign-in page, which handles passwords, MFA and OAuth logins, and come
+// back here once the session cookie is set.
+func (h *handler) oidcAuthorize(c *gin.Context) {
+	if !h.oidcAvailable(c) {
+		return
+	}
+	ctx := c.Request.Context()
+	query := c.Request.URL.Query()
+
+	client, err := h.store.GetOIDCClient(ctx, query.Get("client_id"))
+	if err != nil {
+		if errors.Is(err, store.ErrOIDCClientNotFound) {
+			oidcError(c, http.StatusBadRequest, "invalid_client", "unknown client_id")
+			return
+		}
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to load client")
+		return
+	}
+	requestedRedirectURI := query.Get("redirect_uri")
+	redirectURI, ok := oidcRedirectURI(client, requestedRedirectURI)
+	if !ok {
+		// Never redirect to an unregistered URI, not even with an error.
+		oidcError(c, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+		return
+	}
+
+	state := query.Get("state")
+	fail := func(code, description string) {
+		redirectOIDC(c, redirectURI, url.Values{"error": {code}, "error_description": {description}}, state)
+	}
+
+	if query.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	scope := oidcGrantedScope(client, query.Get("scope"))
+	if !auth.HasScope(scope, auth.ScopeOpenID) {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != auth.PKCEMethodS256 {
+		fail("invalid_request", "PKCE with code_challenge_method=S256 is required")
+		return
+	}
+
+	prompt := query.Get("prompt")
+	sess, user := h.oidcSessionUser(c)
+	needsLogin := sess == nil || prompt == "login"
+	if sess != nil && query.Get("max_age") != "" {
+		maxAge, err := strconv.Atoi(query.Get("max_age"))
+		if err != nil || maxAge < 0 {
+			fail("invalid_request", "max_age must be a non-negative integer")
+			return
+		}
+		if time.Since(sess.CreatedAt) > time.Duration(maxAge)*time.Second {
+			needsLogin = true
+		}
+	}
+	if needsLogin {
+		if prompt == "none" {
+			fail("login_required", "the user is not signed in")
+			return
+		}
+		h.redirectToOIDCLogin(c, query)
+		return
+	}
+	if !user.Active {
+		fail("access_denied", "the account has been suspended")
+		return
+	}
+
+	code, err := h.newRandomToken()
+	if err != nil {
+		fail("server_error", "failed to issue authorization code")
+		return
+	}
+	record := oidcAuthorizationCode{
+		ClientID:      client.ID,
+		RedirectURI:   requestedRedirectURI,
+		UserID:        user.ID,
+		Scope:         scope,
+		Nonce:         query.Get("nonce"),
+		CodeChallenge: query.Get("code_challenge"),
+		AuthTime:      sess.CreatedAt.UTC(),
+		AuthMethod:    sess.AuthMethod,
+		ExpiresAt:     time.Now().Add(h.oidc.codeTTL),
+	}
+	if err := h.putAuthState(authStateOIDCCodes, code, record, record.ExpiresAt); err != nil {
+		fail("server_error", "failed to issue authorization code")
+		return
+	}
+	redirectOIDC(c, redirectURI, url.Values{"code": {code}}, state)
+}
+
+// oidcSessionUser resolves the signed-in user from the session cookie only;
+// accepting a token from the authorization URL would let a crafted link sign
+// the victim into the attacker's account at the relying party.
+func (h *handler) oidcSessionUser(c *gin.Context) (*store.Session, *store.User) {
+	token, err := c.Cookie(sessionCookieName)
+	token = strings.TrimSpace(token)
+	if err != nil || token == "" {
+		return nil, nil
+	}
+	sess, ok := h.lookupSession(token)
+	if !ok || !time.Now().Before(sess.expiresAt) {
+		return nil, nil
+	}
+	user, err := h.store.GetUserByID(c.Request.Context(), sess.userID)
+	if err != nil {
+		return nil, nil
+	}
+
+	record := &store.Session{UserID: user.ID, CreatedAt: time.Now()}
+	if sessions, err := h.store.ListSessionsByUser(c.Request.Context(), user.ID); err == nil {
+		for i := range sessions {
+			if sessions[i].Token == token {
+				record = &sessions[i]
+				break
+			}
+		}
+	}
+	return record, user
+}
+
+func (h *handler) redirectToOIDCLogin(c *gin.Context, query url.Values) {
+	resume := url.Values{}
+	for key, values := range query {
+		// Dropping prompt keeps prompt=login from looping after sign-in.
+		if key != "prompt" {
+			resume[key] = values
+		}
+	}
+	returnTo := h.oidc.issuer + oidcAuthorizePath + "?" + resume.Encode()
+
+	loginURL := h.oidc.loginURL
+	if loginURL == "" {
+		frontendURL := strings.TrimSpace(h.oauthFrontendURL)
+		if frontendURL == "" {
+			frontendURL = "http://localhost:3000"
+		}
+		loginURL = strings.TrimSuffix(frontendURL, "/") + "/login"
+	}
+	target, err := url.Parse(loginURL)
+	if err != nil {
+		oidcError(c, http.StatusInternalServerError, "server_error", "invalid login url")
+		return
+	}
+	values := target.Query()
+	values.Set("redirect", returnTo)
+	target.RawQuery = values.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+func redirectOIDC(c *gin.Context, redirectURI string, params url.Values, state string) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		oidcError(c, http.StatusBadRequest, "invalid_request", "invalid redirect_uri")
+		return
+	}
+	values := target.Query()
+	for key, value := range params {
+		values[key] = value
+	}
+	if state != "" {
+		values.Set("state", state)
+	}
+	target.RawQuery = values.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// oidcRedirectURI matches the requested redirect URI exactly against the
+// registered ones. It may be omitted when the client registered only one.
+func oidcRedirectURI(client *store.OIDCClient, requested string) (string, bool) {
+	if requested == "" {
+		if len(client.RedirectURIs) == 1 {
+			return client.RedirectURIs[0], true
+		}
+		return "", false
+	}
+	if slices.Contains(client.RedirectURIs, requested) {
+		return requested, true
+	}
+	return "", false
+}
+
+// oidcGrantedScope keeps the requested scopes the provider supports and the
+// client is allowed to use. Clients without a scope list may use all of them.
+func oidcGrantedScope(client *store.OIDCClient, requested string) string {
+	granted := make([]string, 0, len(auth.SupportedOIDCScopes))
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(auth.SupportedOIDCScopes, scope) || slices.Contains(granted, scope) {
+			continue
+		}
+		if len(client.Scopes) > 0 && !slices.Contains(client.Scopes, scope) {
+			continue
+		}
+		granted = append(granted, scope)
+	}
+	return strings.Join(granted, " ")
+}
+
+func (h *handler) oidcToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	if !h.oidcAvailable(c) {
+		return
+	}
+	ctx := c.Request.Context()
+
+	client, ok := h.authenticateOIDCClient(c)
+	if !ok {
+		return
+	}
+	if c.PostForm("grant_type") != "authorization_code" {
+		oidcError(c, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		return
+	}
+	code := c.PostForm("code")
+	if code == "" {
+		oidcError(c, http.StatusBadRequest, "invalid_request", "code is required")
+		return
+	}
+
+	var record oidcAuthorizationCode
+	if !h.takeAuthState(authStateOIDCCodes, code, &record) || !time.Now().Before(record.ExpiresAt) {
+		oidcError(c, http.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+		return
+	}
+	if record.ClientID != client.ID || record.RedirectURI != c.PostForm("redirect_uri") {
+		oidcError(c, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect_uri")
+		return
+	}
+	if !auth.VerifyPKCE(record.CodeChallenge, auth.PKCEMethodS256, c.PostForm("code_verifier")) {
+		oidcError(c, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	user, err := h.store.GetUserByID(ctx, record.UserID)
+	if err != nil || !user.Active {
+		oidcError(c, http.StatusBadRequest, "invalid_grant", "the user is no longer allowed to sign in")
+		return
+	}
+	info, err := h.oidcUserInfo(ctx, user, record.Scope)
+	if err != nil {
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to load user claims")
+		return
+	}
+
+	now := time.Now()
+	expiry := h.tokenService.GetAccessTokenExpiry()
+	accessToken, err := h.tokenService.SignJWT(auth.Claims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Roles:    []string{user.Role},
+		MFA:      oidcMultiFactor(record.AuthMethod),
+		ClientID: client.ID,
+		Scope:    record.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    h.oidc.issuer,
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+		},
+	})
+	if err != nil {
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to sign access token")
+		return
+	}
+	idToken, err := h.tokenService.SignJWT(auth.IDTokenClaims{
+		Nonce:           record.Nonce,
+		AuthTime:        jwt.NewNumericDate(record.AuthTime),
+		AuthMethods:     oidcAuthMethods(record.AuthMethod),
+		AuthorizedParty: client.ID,
+		UserInfo:        info,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    h.oidc.issuer,
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+		},
+	})
+	if err != nil {
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to sign id token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(expiry.Seconds()),
+		"id_token":     idToken,
+		"scope":        record.Scope,
+	})
+}
+
+// authenticateOIDCClient accepts client_secret_basic, client_secret_post and,
+// for public clients, a bare client_id.
+func (h *handler) authenticateOIDCClient(c *gin.Context) (*store.OIDCClient, bool) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes the credentials.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	reject := func() (*store.OIDCClient, bool) {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oidc"`)
+		}
+		oidcError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return nil, false
+	}
+
+	client, err := h.store.GetOIDCClient(c.Request.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, store.ErrOIDCClientNotFound) {
+			return reject()
+		}
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to load client")
+		return nil, false
+	}
+	if client.Public() {
+		if secret != "" {
+			return reject()
+		}
+		return client, true
+	}
+	if secret == "" || bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) != nil {
+		return reject()
+	}
+	return client, true
+}
+
+func (h *handler) oidcUserInfoEndpoint(c *gin.Context) {
+	if !h.oidcAvailable(c) {
+		return
+	}
+	invalid := func(description string) {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oidcError(c, http.StatusUnauthorized, "invalid_token", description)
+	}
+
+	claims, err := h.tokenService.ValidateClientAccessToken(extractToken(c.GetHeader("Authorization")), h.oidc.issuer)
+	if err != nil || !auth.HasScope(claims.Scope, auth.ScopeOpenID) {
+		invalid("the access token is invalid or was not issued for userinfo")
+		return
+	}
+	if _, err := h.store.GetOIDCClient(c.Request.Context(), claims.ClientID); err != nil {
+		invalid("the client the access token was issued to no longer exists")
+		return
+	}
+	user, err := h.store.GetUserByID(c.Request.Context(), claims.Subject)
+	if err != nil || !user.Active {
+		invalid("the user is no longer allowed to sign in")
+		return
+	}
+	info, err := h.oidcUserInfo(c.Request.Context(), user, claims.Scope)
+	if err != nil {
+		oidcError(c, http.StatusInternalServerError, "server_error", "failed to load user claims")
+		return
+	}
+	c.JSON(http.StatusOK, struct {
+		Subject string `json:"sub"`
+		auth.UserInfo
+	}{Subject: user.ID, UserInfo: info})
+}
+
+// oidcUserInfo builds the claims released for scope.
+func (h *handler) oidcUserInfo(ctx context.Context, user *store.User, scope string) (auth.UserInfo, error) {
+	info := auth.UserInfo{
+		Role:    user.Role,
+		Groups:  append([]string{}, user.Groups...),
+		Tenants: []auth.TenantClaim{},
+	}
+	if auth.HasScope(scope, auth.ScopeProfile) {
+		info.Name = user.Name
+		if !user.UpdatedAt.IsZero() {
+			info.UpdatedAt = user.UpdatedAt.Unix()
+		}
+	}
+	if auth.HasScope(scope, auth.ScopeEmail) {
+		verified := user.EmailVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+
+	memberships, err := h.store.ListTenantMembershipsByUser(ctx, user.ID)
+	if err != nil {
+		return auth.UserInfo{}, err
+	}
+	for _, membership := range memberships {
+		info.Tenants = append(info.Tenants, auth.TenantClaim{
+			ID:   membership.TenantID,
+			Name: membership.TenantName,
+			Role: membership.Role,
+		})
+	}
+	return info, nil
+}
+
+// oidcAuthMethods maps session auth methods onto RFC 8176 amr values.
+// Recovery codes are one-time passwords; passkeys are user-verified hardware
+// keys and so count as multiple factors on their own.
+func oidcAuthMethods(method string) []string {
+	switch method {
+	case sessionAuthPassword:
+		return []string{"pwd"}
+	case sessionAuthTOTP, sessionAuthRecoveryCode:
+		return []string{"pwd", "otp", "mfa"}
+	case sessionAuthWebAuthn:
+		return []string{"pwd", "hwk", "mfa"}
+	case sessionAuthPasskey:
+		return []string{"hwk", "mfa"}
+	default:
+		return nil
+	}
+}
+
+// oidcMultiFactor reports whether a session signed in with more than one
+// factor.
+func oidcMultiFactor(method string) bool {
+	return slices.Contains(oidcAuthMethods(method), "mfa")
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+const oidcClientIDLength = 32
+
+type oidcClientRequest struct {
+	ClientID     string   `json:"clientId"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	// Public clients (SPAs, native apps) get no secret and rely on PKCE.
+	Public bool `json:"public"`
+}
+
+type oidcClientResponse struct {
+	ClientID     string    `json:"clientId"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirectUris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func newOIDCClientResponse(client *store.OIDCClient) oidcClientResponse {
+	return oidcClientResponse{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		RedirectURIs: append([]string{}, client.RedirectURIs...),
+		Scopes:       append([]string{}, client.Scopes...),
+		Public:       client.Public(),
+		CreatedAt:    client.CreatedAt.UTC(),
+		UpdatedAt:    client.UpdatedAt.UTC(),
+	}
+}
+
+// validOIDCRedirectURI accepts https URIs, http on loopback hosts for local
+// development, and private-use schemes such as com.example.app:/callback for
+// native apps (RFC 8252). Fragments are never allowed.
+func validOIDCRedirectURI(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		host := parsed.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	default:
+		return strings.Contains(parsed.Scheme, ".")
+	}
+}
+
+func (h *handler) listOIDCClients(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminOIDCClientsRead); !ok {
+		return
+	}
+
+	clients, err := h.store.ListOIDCClients(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_oidc_clients_failed", "failed to list oidc clients")
+		return
+	}
+	responses := make([]oidcClientResponse, 0, len(clients))
+	for i := range clients {
+		responses = append(responses, newOIDCClientResponse(&clients[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": responses})
+}
+
+// createOIDCClient registers a client. The generated secret of a
+// confidential client is only returned in this response.
+func (h *handler) createOIDCClient(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminOIDCClientsWrite); !ok {
+		return
+	}
+
+	var req oidcClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	if len(req.RedirectURIs) == 0 {
+		respondError(c, http.StatusBadRequest, "invalid_redirect_uri", "at least one redirect uri is required")
+		return
+	}
+	for _, redirectURI := range req.RedirectURIs {
+		if !validOIDCRedirectURI(strings.TrimSpace(redirectURI)) {
+			respondError(c, http.StatusBadRequest, "invalid_redirect_uri", "redirect uris must be https, loopback http or a private-use scheme without a fragment")
+			return
+		}
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(auth.SupportedOIDCScopes, strings.TrimSpace(scope)) {
+			respondError(c, http.StatusBadRequest, "invalid_scope", "unsupported scope "+scope)
+			return
+		}
+	}
+
+	client := &store.OIDCClient{
+		ID:           strings.TrimSpace(req.ClientID),
+		Name:         strings.TrimSpace(req.Name),
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+	}
+	if client.ID == "" {
+		id, err := h.newRandomToken()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "create_oidc_client_failed", "failed to generate client id")
+			return
+		}
+		client.ID = id[:oidcClientIDLength]
+	}
+
+	var secret string
+	if !req.Public {
+		var err error
+		secret, err = h.newRandomToken()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "create_oidc_client_failed", "failed to generate client secret")
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "create_oidc_client_failed", "failed to hash client secret")
+			return
+		}
+		client.SecretHash = string(hash)
+	}
+
+	if err := h.store.CreateOIDCClient(c.Request.Context(), client); err != nil {
+		if errors.Is(err, store.ErrOIDCClientExists) {
+			respondError(c, http.StatusConflict, "oidc_client_exists", "client id is already registered")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "create_oidc_client_failed", "failed to register oidc client")
+		return
+	}
+
+	response := gin.H{"client": newOIDCClientResponse(client)}
+	if secret != "" {
+		response["clientSecret"] = secret
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *handler) deleteOIDCClient(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminOIDCClientsWrite); !ok {
+		return
+	}
+
+	if err := h.store.DeleteOIDCClient(c.Request.Context(), c.Param("clientId")); err != nil {
+		if errors.Is(err, store.ErrOIDCClientNotFound) {
+			respondError(c, http.StatusNotFound, "oidc_client_not_found", "oidc client not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_oidc_client_failed", "failed to delete oidc client")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
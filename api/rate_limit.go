@@ -0,0 +1,169 @@
+package api
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/ratelimit"
+)
+
+// RateLimitConfig holds the throttling rules applied to sign-in and email
+// endpoints. A rule with a zero Limit is disabled.
+type RateLimitConfig struct {
+	// Account limits failed password, TOTP, passkey and recovery code
+	// attempts per account.
+	Account ratelimit.Rule
+	// IP limits failed sign-in attempts per client IP across all accounts.
+	IP ratelimit.Rule
+	// EmailTarget limits verification and password reset emails per
+	// recipient address.
+	EmailTarget ratelimit.Rule
+	// EmailIP limits verification and password reset requests per client IP.
+	EmailIP ratelimit.Rule
+}
+
+// DefaultRateLimits returns the limits used when none are configured.
+func DefaultRateLimits() RateLimitConfig {
+	return RateLimitConfig{
+		Account:     ratelimit.Rule{Name: "account", Limit: 5, Window: 15 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour},
+		IP:          ratelimit.Rule{Name: "ip", Limit: 50, Window: 15 * time.Minute, Lockout: 5 * time.Minute, MaxLockout: time.Hour},
+		EmailTarget: ratelimit.Rule{Name: "email_target", Limit: 5, Window: time.Hour},
+		EmailIP:     ratelimit.Rule{Name: "email_ip", Limit: 20, Window: time.Hour},
+	}
+}
+
+// WithRateLimits replaces the default throttling rules. Counters are kept in
+// the auth state store, so they are shared by replicas using a shared one.
+func WithRateLimits(cfg RateLimitConfig) Option {
+	return func(h *handler) {
+		h.rateLimits = cfg
+	}
+}
+
+type rateLimitKey struct {
+	rule ratelimit.Rule
+	key  string
+}
+
+func (h *handler) accountRateLimit(userID string) rateLimitKey {
+	return rateLimitKey{rule: h.rateLimits.Account, key: userID}
+}
+
+func (h *handler) ipRateLimit(c *gin.Context) rateLimitKey {
+	return rateLimitKey{rule: h.rateLimits.IP, key: c.ClientIP()}
+}
+
+// emailRateLimits limits outbound mail per recipient and per requester.
+func (h *handler) emailRateLimits(c *gin.Context, email string) []rateLimitKey {
+	return []rateLimitKey{
+		{rule: h.rateLimits.EmailTarget, key: email},
+		{rule: h.rateLimits.EmailIP, key: c.ClientIP()},
+	}
+}
+
+// consumeRateLimits records a hit against every key, responding 429 and
+// returning false when one of them is over its limit. Every key is reserved
+// before any is charged, so a refusal leaves the other keys untouched.
+func (h *handler) consumeRateLimits(c *gin.Context, keys ...rateLimitKey) bool {
+	reservation := h.newAttemptReservation(c)
+	if !reservation.reserve(keys...) {
+		reservation.release()
+		return false
+	}
+	reservation.fail()
+	return true
+}
+
+// attemptReservation tracks the rate limit hits reserved for one sign-in
+// attempt. Hits are reserved before credentials are verified; the attempt
+// then either fails, committing them, or is released, refunding the ones that
+// were not committed.
+type attemptReservation struct {
+	h    *handler
+	c    *gin.Context
+	keys []rateLimitKey
+}
+
+func (h *handler) newAttemptReservation(c *gin.Context) *attemptReservation {
+	return &attemptReservation{h: h, c: c}
+}
+
+// reserve records a pending attempt against every key, responding 429 and
+// returning false once one of them is locked or already full. Store errors
+// fail open so an unavailable state store cannot lock everyone out.
+func (r *attemptReservation) reserve(keys ...rateLimitKey) bool {
+	for _, k := range keys {
+		decision, err := r.h.limiter.Reserve(r.c.Request.Context(), k.rule, k.key)
+		if err != nil {
+			slog.Warn("rate limit update failed", "err", err, "rule", k.rule.Name)
+			continue
+		}
+		if !decision.Allowed {
+			respondRateLimited(r.c, decision.RetryAfter)
+			return false
+		}
+		r.keys = append(r.keys, k)
+	}
+	return true
+}
+
+// fail counts the attempt as failed against every reserved key.
+func (r *attemptReservation) fail() {
+	for _, k := range r.keys {
+		decision, err := r.h.limiter.Commit(r.c.Request.Context(), k.rule, k.key)
+		if err != nil {
+			slog.Warn("rate limit update failed", "err", err, "rule", k.rule.Name)
+			continue
+		}
+		if !decision.Allowed {
+			slog.Warn("rate limit lockout", "rule", k.rule.Name, "key", k.key, "retryAfter", decision.RetryAfter)
+		}
+	}
+	r.keys = nil
+}
+
+// release refunds every hit that was not committed by fail. It is deferred
+// by the caller so that successful attempts and requests rejected for other
+// reasons do not count against the limits.
+func (r *attemptReservation) release() {
+	for _, k := range r.keys {
+		if err := r.h.limiter.Refund(r.c.Request.Context(), k.rule, k.key); err != nil {
+			slog.Warn("rate limit refund failed", "err", err, "rule", k.rule.Name)
+		}
+	}
+	r.keys = nil
+}
+
+// resetRateLimits clears recent failures after a successful attempt.
+func (h *handler) resetRateLimits(c *gin.Context, keys ...rateLimitKey) {
+	for _, k := range keys {
+		if err := h.limiter.Reset(c.Request.Context(), k.rule, k.key); err != nil {
+			slog.Warn("rate limit reset failed", "err", err, "rule", k.rule.Name)
+		}
+	}
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounding up.
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) int64 {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	return seconds
+}
+
+func respondRateLimited(c *gin.Context, retryAfter time.Duration) {
+	seconds := setRetryAfter(c, retryAfter)
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "rate_limited",
+		"message":    "too many attempts, try again later",
+		"retryAt":    time.Now().Add(time.Duration(seconds) * time.Second).UTC(),
+		"retryAfter": seconds,
+	})
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+// attachRefreshToken adds a rotating refresh token to a sign-in response when
+// the JWT token service is configured. Failures only cost the client the
+// ability to refresh, so they are logged rather than failing the sign-in.
+func (h *handler) attachRefreshToken(ctx context.Context, response gin.H, user *store.User) gin.H {
+	if h.tokenService == nil || user == nil {
+		return response
+	}
+	pair, err := h.tokenService.GenerateTokenPair(ctx, user.ID, user.Email, []string{user.Role})
+	if err != nil {
+		slog.Warn("failed to issue refresh token", "err", err, "userID", user.ID)
+		return response
+	}
+	response["refresh_token"] = pair.RefreshToken
+	return response
+}
+
+// revokeRefreshTokens invalidates every refresh token family of the user,
+// for example after a credential change.
+func (h *handler) revokeRefreshTokens(ctx context.Context, userID, reason string) {
+	count, err := h.store.RevokeRefreshTokensByUser(ctx, userID, reason)
+	if err != nil {
+		slog.Error("failed to revoke refresh tokens", "err", err, "userID", userID, "reason", reason)
+		return
+	}
+	if count > 0 {
+		slog.Info("revoked refresh tokens", "userID", userID, "reason", reason, "count", count)
+	}
+}
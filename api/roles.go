@@ -0,0 +1,478 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/rbac"
+	"account/internal/store"
+)
+
+// customRoleKeyPattern keeps custom role keys short and URL-safe.
+var customRoleKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{1,62}$`)
+
+// builtinRoles are the roles defined by the service. The operator and
+// tenant_admin permission sets are defaults that the admin settings matrix
+// can override; root and admin always hold everything, and root alone holds
+// the reserved root permissions.
+func builtinRoles() []rbac.Role {
+	operator := make([]string, 0, len(defaultOperatorPermissions))
+	for _, permission := range adminPermissions {
+		if defaultOperatorPermissions[permission] {
+			operator = append(operator, permission)
+		}
+	}
+	return []rbac.Role{
+		{Key: store.RoleRoot, Description: "single root account", Permissions: append([]string{rbac.AllPermissions}, rootPermissions...)},
+		{Key: store.RoleAdmin, Description: "platform administrator", Permissions: []string{rbac.AllPermissions}},
+		{Key: store.RoleOperator, Description: "operation role with configurable permissions", Permissions: operator, Configurable: true},
+		{Key: store.RoleUser, Description: "standard subscription user"},
+		{Key: store.RoleReadOnly, Description: "read-only experience account holding the permissions listed on the account"},
+		{
+			Key:          rbac.RoleTenantAdmin,
+			Description:  "admin member of a tenant, scoped to that tenant",
+			Permissions:  []string{permissionAdminTenantsRead, permissionAdminTenantsWrite},
+			Configurable: true,
+		},
+	}
+}
+
+type roleResponse struct {
+	Key          string     `json:"key"`
+	Description  string     `json:"description"`
+	Permissions  []string   `json:"permissions"`
+	BuiltIn      bool       `json:"builtIn"`
+	Configurable bool       `json:"configurable,omitempty"`
+	CreatedAt    *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt    *time.Time `json:"updatedAt,omitempty"`
+}
+
+func newCustomRoleResponse(role *store.Role) roleResponse {
+	createdAt, updatedAt := role.CreatedAt, role.UpdatedAt
+	return roleResponse{
+		Key:         role.Key,
+		Description: role.Description,
+		Permissions: append([]string{}, role.Permissions...),
+		CreatedAt:   &createdAt,
+		UpdatedAt:   &updatedAt,
+	}
+}
+
+type roleBindingResponse struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Role      string    `json:"role"`
+	TenantID  string    `json:"tenantId,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newRoleBindingResponse(binding *store.RoleBinding) roleBindingResponse {
+	return roleBindingResponse{
+		ID:        binding.ID,
+		UserID:    binding.UserID,
+		Role:      binding.RoleKey,
+		TenantID:  binding.TenantID,
+		CreatedBy: binding.CreatedBy,
+		CreatedAt: binding.CreatedAt,
+	}
+}
+
+// listRoles returns the built-in roles with their effective permissions,
+// followed by the custom roles, and the permission catalog.
+func (h *handler) listRoles(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminRolesRead); !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	responses := make([]roleResponse, 0)
+	for _, role := range h.policy.BuiltInRoles() {
+		permissions, err := h.policy.Permissions(ctx, role.Key)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "list_roles_failed", "failed to list roles")
+			return
+		}
+		responses = append(responses, roleResponse{
+			Key:          role.Key,
+			Description:  role.Description,
+			Permissions:  append([]string{}, permissions...),
+			BuiltIn:      true,
+			Configurable: role.Configurable,
+		})
+	}
+	custom, err := h.store.ListRoles(ctx)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_roles_failed", "failed to list roles")
+		return
+	}
+	for i := range custom {
+		responses = append(responses, newCustomRoleResponse(&custom[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": responses, "permissions": h.policy.PermissionCatalog()})
+}
+
+type roleRequest struct {
+	Key         string    `json:"key"`
+	Description *string   `json:"description"`
+	Permissions *[]string `json:"permissions"`
+}
+
+// validRolePermissions rejects permissions outside the catalog.
+func (h *handler) validRolePermissions(c *gin.Context, permissions []string) bool {
+	for _, permission := range permissions {
+		if !h.policy.KnownPermission(permission) {
+			respondError(c, http.StatusBadRequest, "invalid_permission", "unknown permission: "+strings.TrimSpace(permission))
+			return false
+		}
+	}
+	return true
+}
+
+// requireGrantable stops an actor from handing out permissions they do not
+// hold on the resource themselves.
+func (h *handler) requireGrantable(c *gin.Context, actor *store.User, permissions []string, resource rbac.Resource) bool {
+	for _, permission := range permissions {
+		allowed, err := h.holds(c, actor, permission, resource)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "authorization_failed", "failed to evaluate permissions")
+			return false
+		}
+		if !allowed {
+			respondError(c, http.StatusForbidden, "permission_not_grantable", "cannot grant a permission you do not hold: "+permission)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *handler) createRole(c *gin.Context) {
+	actor, ok := h.requireAdminPermission(c, permissionAdminRolesWrite)
+	if !ok {
+		return
+	}
+
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	key := strings.ToLower(strings.TrimSpace(req.Key))
+	if !customRoleKeyPattern.MatchString(key) {
+		respondError(c, http.StatusBadRequest, "invalid_role_key", "role key must be 2-63 lowercase letters, digits, '.', '_' or '-' starting with a letter")
+		return
+	}
+	if _, builtin := h.policy.BuiltIn(key); builtin {
+		respondError(c, http.StatusConflict, "role_exists", "role already exists")
+		return
+	}
+	role := &store.Role{Key: key}
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		role.Permissions = *req.Permissions
+	}
+	if !h.validRolePermissions(c, role.Permissions) || !h.requireGrantable(c, actor, role.Permissions, rbac.Platform) {
+		return
+	}
+
+	if err := h.store.CreateRole(c.Request.Context(), role); err != nil {
+		if errors.Is(err, store.ErrRoleExists) {
+			respondError(c, http.StatusConflict, "role_exists", "role already exists")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "create_role_failed", "failed to create role")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionRoleCreate,
+		TargetType: auditTargetRole,
+		TargetID:   role.Key,
+		After:      map[string]any{"description": role.Description, "permissions": role.Permissions},
+	})
+	c.JSON(http.StatusCreated, gin.H{"role": newCustomRoleResponse(role)})
+}
+
+// loadCustomRole loads the role named by the roleKey path parameter. Built-in
+// roles are refused: their permissions are set through the admin settings
+// matrix.
+func (h *handler) loadCustomRole(c *gin.Context) (*store.Role, bool) {
+	key := strings.TrimSpace(c.Param("roleKey"))
+	if _, builtin := h.policy.BuiltIn(key); builtin {
+		respondError(c, http.StatusConflict, "role_builtin", "built-in roles are configured through the admin settings matrix")
+		return nil, false
+	}
+	role, err := h.store.GetRole(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, store.ErrRoleNotFound) {
+			respondError(c, http.StatusNotFound, "role_not_found", "role not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "role_lookup_failed", "failed to load role")
+		return nil, false
+	}
+	return role, true
+}
+
+func (h *handler) updateRole(c *gin.Context) {
+	actor, ok := h.requireAdminPermission(c, permissionAdminRolesWrite)
+	if !ok {
+		return
+	}
+
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	role, ok := h.loadCustomRole(c)
+	if !ok {
+		return
+	}
+	before := map[string]any{"description": role.Description, "permissions": role.Permissions}
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		added := make([]string, 0)
+		for _, permission := range *req.Permissions {
+			if !slices.Contains(role.Permissions, strings.TrimSpace(permission)) {
+				added = append(added, permission)
+			}
+		}
+		if !h.validRolePermissions(c, added) || !h.requireGrantable(c, actor, added, rbac.Platform) {
+			return
+		}
+		role.Permissions = *req.Permissions
+	}
+
+	if err := h.store.UpdateRole(c.Request.Context(), role); err != nil {
+		if errors.Is(err, store.ErrRoleNotFound) {
+			respondError(c, http.StatusNotFound, "role_not_found", "role not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "update_role_failed", "failed to update role")
+		return
+	}
+	changedBefore, changedAfter := auditDiff(before, map[string]any{"description": role.Description, "permissions": role.Permissions})
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionRoleUpdate,
+		TargetType: auditTargetRole,
+		TargetID:   role.Key,
+		Before:     changedBefore,
+		After:      changedAfter,
+	})
+	c.JSON(http.StatusOK, gin.H{"role": newCustomRoleResponse(role)})
+}
+
+// deleteRole removes a custom role and every binding to it.
+func (h *handler) deleteRole(c *gin.Context) {
+	actor, ok := h.requireAdminPermission(c, permissionAdminRolesWrite)
+	if !ok {
+		return
+	}
+
+	role, ok := h.loadCustomRole(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteRole(c.Request.Context(), role.Key); err != nil {
+		if errors.Is(err, store.ErrRoleNotFound) {
+			respondError(c, http.StatusNotFound, "role_not_found", "role not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_role_failed", "failed to delete role")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionRoleDelete,
+		TargetType: auditTargetRole,
+		TargetID:   role.Key,
+		Before:     map[string]any{"description": role.Description, "permissions": role.Permissions},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) loadRoleBindingUser(c *gin.Context) (*store.User, bool) {
+	user, err := h.store.GetUserByID(c.Request.Context(), strings.TrimSpace(c.Param("userId")))
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to load user")
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *handler) listUserRoleBindings(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminRolesRead); !ok {
+		return
+	}
+
+	user, ok := h.loadRoleBindingUser(c)
+	if !ok {
+		return
+	}
+	bindings, err := h.store.ListRoleBindings(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_role_bindings_failed", "failed to list role bindings")
+		return
+	}
+	responses := make([]roleBindingResponse, 0, len(bindings))
+	for i := range bindings {
+		responses = append(responses, newRoleBindingResponse(&bindings[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"role": user.Role, "bindings": responses})
+}
+
+// createUserRoleBinding grants a custom role to a user, platform-wide or,
+// with tenantId, within a tenant the user belongs to.
+func (h *handler) createUserRoleBinding(c *gin.Context) {
+	actor, ok := h.requireAdminPermission(c, permissionAdminRolesWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role     string `json:"role"`
+		TenantID string `json:"tenantId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	user, ok := h.loadRoleBindingUser(c)
+	if !ok {
+		return
+	}
+	if _, builtin := h.policy.BuiltIn(req.Role); builtin {
+		respondError(c, http.StatusBadRequest, "role_not_bindable", "built-in roles are assigned through the account role or tenant membership")
+		return
+	}
+	ctx := c.Request.Context()
+	role, err := h.store.GetRole(ctx, req.Role)
+	if err != nil {
+		if errors.Is(err, store.ErrRoleNotFound) {
+			respondError(c, http.StatusNotFound, "role_not_found", "role not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "role_lookup_failed", "failed to load role")
+		return
+	}
+
+	resource := rbac.Platform
+	if tenantID := strings.TrimSpace(req.TenantID); tenantID != "" {
+		if !h.requireTenantMember(c, tenantID, user.ID) {
+			return
+		}
+		resource = rbac.Tenant(tenantID)
+	}
+	if !h.requireGrantable(c, actor, role.Permissions, resource) {
+		return
+	}
+
+	binding := &store.RoleBinding{
+		UserID:    user.ID,
+		RoleKey:   role.Key,
+		TenantID:  resource.TenantID,
+		CreatedBy: actor.ID,
+	}
+	if err := h.store.CreateRoleBinding(ctx, binding); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRoleBindingExists):
+			respondError(c, http.StatusConflict, "role_binding_exists", "user already holds this role")
+		case errors.Is(err, store.ErrRoleNotFound):
+			respondError(c, http.StatusNotFound, "role_not_found", "role not found")
+		case errors.Is(err, store.ErrUserNotFound):
+			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		default:
+			respondError(c, http.StatusInternalServerError, "create_role_binding_failed", "failed to create role binding")
+		}
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   binding.TenantID,
+		Action:     auditActionRoleBindingCreate,
+		TargetType: auditTargetRoleBinding,
+		TargetID:   binding.ID,
+		After:      map[string]any{"userId": user.ID, "role": binding.RoleKey, "tenantId": binding.TenantID},
+	})
+	c.JSON(http.StatusCreated, gin.H{"binding": newRoleBindingResponse(binding)})
+}
+
+// requireTenantMember checks that the tenant exists and that userID belongs
+// to it.
+func (h *handler) requireTenantMember(c *gin.Context, tenantID, userID string) bool {
+	ctx := c.Request.Context()
+	if _, err := h.store.GetTenant(ctx, tenantID); err != nil {
+		if errors.Is(err, store.ErrTenantNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_not_found", "tenant not found")
+			return false
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_lookup_failed", "failed to load tenant")
+		return false
+	}
+	if _, err := h.store.GetTenantMembership(ctx, tenantID, userID); err != nil {
+		if errors.Is(err, store.ErrTenantMembershipNotFound) {
+			respondError(c, http.StatusBadRequest, "tenant_membership_required", "user is not a member of the tenant")
+			return false
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_membership_lookup_failed", "failed to load tenant membership")
+		return false
+	}
+	return true
+}
+
+func (h *handler) deleteUserRoleBinding(c *gin.Context) {
+	actor, ok := h.requireAdminPermission(c, permissionAdminRolesWrite)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := strings.TrimSpace(c.Param("userId"))
+	bindingID := strings.TrimSpace(c.Param("bindingId"))
+	binding, err := h.findRoleBinding(ctx, userID, bindingID)
+	if err == nil {
+		err = h.store.DeleteRoleBinding(ctx, userID, bindingID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrRoleBindingNotFound) {
+			respondError(c, http.StatusNotFound, "role_binding_not_found", "role binding not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_role_binding_failed", "failed to delete role binding")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   binding.TenantID,
+		Action:     auditActionRoleBindingDelete,
+		TargetType: auditTargetRoleBinding,
+		TargetID:   binding.ID,
+		Before:     map[string]any{"userId": binding.UserID, "role": binding.RoleKey, "tenantId": binding.TenantID},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) findRoleBinding(ctx context.Context, userID, id string) (*store.RoleBinding, error) {
+	bindings, err := h.store.ListRoleBindings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bindings {
+		if bindings[i].ID == id {
+			return &bindings[i], nil
+		}
+	}
+	return nil, store.ErrRoleBindingNotFound
+}
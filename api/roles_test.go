@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+func TestCustomRolesAndBindings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	newUser := func(name, email, role, token string) *store.User {
+		user := &store.User{
+			Name:          name,
+			Email:         email,
+			EmailVerified: true,
+			Role:          role,
+			Level:         store.LevelUser,
+			Active:        true,
+		}
+		if role == store.RoleAdmin {
+			user.Level = store.LevelAdmin
+		}
+		if role == store.RoleOperator {
+			user.Level = store.LevelOperator
+		}
+		if err := st.CreateUser(ctx, user); err != nil {
+			t.Fatalf("create %s: %v", email, err)
+		}
+		if err := st.CreateSession(ctx, token, user.ID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("create session for %s: %v", email, err)
+		}
+		return user
+	}
+	newUser("Admin", "admin@example.com", store.RoleAdmin, "admin-token")
+	newUser("Operator", "operator@example.com", store.RoleOperator, "operator-token")
+	support := newUser("Support", "support@example.com", store.RoleUser, "support-token")
+
+	if err := st.EnsureTenant(ctx, &store.Tenant{ID: "acme", Name: "Acme", Edition: store.TenantPrivateEdition}); err != nil {
+		t.Fatalf("ensure tenant: %v", err)
+	}
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, status int, what string) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("%s: expected %d, got %d: %s", what, status, rr.Code, rr.Body.String())
+		}
+	}
+	sessionsPath := "/api/admin/users/" + support.ID + "/sessions"
+	bindingsPath := "/api/admin/users/" + support.ID + "/role-bindings"
+
+	expect(do(http.MethodPost, "/api/admin/roles", "operator-token", `{"key":"support","permissions":["admin.users.sessions.read"]}`),
+		http.StatusForbidden, "operator without admin.roles.write")
+	expect(do(http.MethodPost, "/api/admin/roles", "admin-token", `{"key":"operator"}`), http.StatusConflict, "built-in key")
+	expect(do(http.MethodPost, "/api/admin/roles", "admin-token", `{"key":"Support Desk"}`), http.StatusBadRequest, "invalid key")
+	expect(do(http.MethodPost, "/api/admin/roles", "admin-token", `{"key":"support","permissions":["admin.everything"]}`),
+		http.StatusBadRequest, "unknown permission")
+	expect(do(http.MethodPost, "/api/admin/roles", "admin-token", `{"key":"support","description":"Support desk","permissions":["admin.users.sessions.read"]}`),
+		http.StatusCreated, "create role")
+	expect(do(http.MethodPost, "/api/admin/roles", "admin-token", `{"key":"support"}`), http.StatusConflict, "duplicate role")
+
+	rr := do(http.MethodGet, "/api/admin/roles", "operator-token", "")
+	expect(rr, http.StatusOK, "operator lists roles")
+	var listed struct {
+		Roles []struct {
+			Key         string   `json:"key"`
+			Permissions []string `json:"permissions"`
+			BuiltIn     bool     `json:"builtIn"`
+		} `json:"roles"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode roles: %v", err)
+	}
+	roles := map[string]bool{}
+	for _, role := range listed.Roles {
+		roles[role.Key] = role.BuiltIn
+	}
+	if builtIn, ok := roles["operator"]; !ok || !builtIn {
+		t.Fatalf("expected built-in operator role, got %+v", listed.Roles)
+	}
+	if builtIn, ok := roles["support"]; !ok || builtIn {
+		t.Fatalf("expected custom support role, got %+v", listed.Roles)
+	}
+	if len(listed.Permissions) != len(adminPermissions) {
+		t.Fatalf("expected the permission catalog, got %v", listed.Permissions)
+	}
+
+	expect(do(http.MethodGet, sessionsPath, "support-token", ""), http.StatusForbidden, "support before binding")
+	expect(do(http.MethodPost, bindingsPath, "admin-token", `{"role":"operator"}`), http.StatusBadRequest, "bind built-in role")
+	expect(do(http.MethodPost, bindingsPath, "admin-token", `{"role":"support","tenantId":"acme"}`),
+		http.StatusBadRequest, "bind within a tenant the user is not in")
+	rr = do(http.MethodPost, bindingsPath, "admin-token", `{"role":"support"}`)
+	expect(rr, http.StatusCreated, "bind role")
+	var created struct {
+		Binding struct {
+			ID string `json:"id"`
+		} `json:"binding"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || created.Binding.ID == "" {
+		t.Fatalf("decode binding: %v %s", err, rr.Body.String())
+	}
+	expect(do(http.MethodPost, bindingsPath, "admin-token", `{"role":"support"}`), http.StatusConflict, "duplicate binding")
+
+	expect(do(http.MethodGet, sessionsPath, "support-token", ""), http.StatusOK, "support after binding")
+	expect(do(http.MethodDelete, sessionsPath, "support-token", ""), http.StatusForbidden, "support revoking sessions")
+
+	expect(do(http.MethodPatch, "/api/admin/roles/operator", "admin-token", `{"description":"x"}`), http.StatusConflict, "update built-in role")
+	expect(do(http.MethodPatch, "/api/admin/roles/support", "admin-token", `{"permissions":["admin.users.sessions.read","admin.users.sessions.write"]}`),
+		http.StatusOK, "extend role")
+	expect(do(http.MethodDelete, sessionsPath, "support-token", ""), http.StatusOK, "support revoking sessions after update")
+
+	// Revoking the sessions above signed support out as well.
+	if err := st.CreateSession(ctx, "support-token", support.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("recreate support session: %v", err)
+	}
+	expect(do(http.MethodDelete, bindingsPath+"/"+created.Binding.ID, "support-token", ""), http.StatusForbidden, "support removing binding")
+	expect(do(http.MethodDelete, bindingsPath+"/"+created.Binding.ID, "admin-token", ""), http.StatusNoContent, "remove binding")
+	expect(do(http.MethodDelete, bindingsPath+"/"+created.Binding.ID, "admin-token", ""), http.StatusNotFound, "remove binding twice")
+	expect(do(http.MethodGet, sessionsPath, "support-token", ""), http.StatusForbidden, "support after unbinding")
+
+	// Tenant-scoped bindings grant permissions on that tenant only.
+	if err := st.CreateRole(ctx, &store.Role{Key: "scim-manager", Permissions: []string{permissionAdminSCIMRead, permissionAdminSCIMWrite}}); err != nil {
+		t.Fatalf("create scim role: %v", err)
+	}
+	if err := st.UpsertTenantMembership(ctx, &store.TenantMembership{TenantID: "acme", UserID: support.ID, Role: store.TenantMembershipRoleUser}); err != nil {
+		t.Fatalf("add membership: %v", err)
+	}
+	expect(do(http.MethodPost, bindingsPath, "admin-token", `{"role":"scim-manager","tenantId":"acme"}`), http.StatusCreated, "bind within tenant")
+	expect(do(http.MethodGet, "/api/admin/tenants/acme/scim-tokens", "support-token", ""), http.StatusOK, "tenant-scoped scim access")
+	expect(do(http.MethodGet, "/api/admin/tenants/other/scim-tokens", "support-token", ""), http.StatusForbidden, "other tenant")
+
+	expect(do(http.MethodDelete, "/api/admin/roles/scim-manager", "admin-token", ""), http.StatusNoContent, "delete role")
+	expect(do(http.MethodGet, "/api/admin/tenants/acme/scim-tokens", "support-token", ""), http.StatusForbidden, "after role deletion")
+	rr = do(http.MethodGet, bindingsPath, "admin-token", "")
+	expect(rr, http.StatusOK, "list bindings")
+	if strings.Contains(rr.Body.String(), "scim-manager") {
+		t.Fatalf("expected bindings of a deleted role to be removed, got %s", rr.Body.String())
+	}
+}
+
+func TestRootPermissionsAreReservedForRoot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	newUser := func(email, role, token string) *store.User {
+		user := &store.User{Name: email, Email: email, EmailVerified: true, Role: role, Level: store.LevelAdmin, Active: true}
+		if err := st.CreateUser(ctx, user); err != nil {
+			t.Fatalf("create %s: %v", email, err)
+		}
+		if err := st.CreateSession(ctx, token, user.ID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("create session for %s: %v", email, err)
+		}
+		return user
+	}
+	root := newUser(store.RootAdminEmail, store.RoleRoot, "root-token")
+	newUser("admin@example.com", store.RoleAdmin, "admin-token")
+
+	router := gin.New()
+	RegisterRoutes(router, WithStore(st))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, status int, what string) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("%s: expected %d, got %d: %s", what, status, rr.Code, rr.Body.String())
+		}
+	}
+
+	expect(do(http.MethodGet, "/api/auth/admin/assume/status", "admin-token", ""), http.StatusForbidden, "admin assume status")
+	expect(do(http.MethodGet, "/api/auth/admin/assume/status", "root-token", ""), http.StatusOK, "root assume status")
+	expect(do(http.MethodPost, "/api/auth/admin/users", "admin-token", `{"email":"custom@example.com"}`), http.StatusForbidden, "admin custom uuid user")
+	expect(do(http.MethodPost, "/api/admin/roles", "admin-token", `{"key":"assumer","permissions":["`+permissionRootAssumeRead+`"]}`),
+		http.StatusBadRequest, "role with a root permission")
+
+	rr := do(http.MethodPost, "/api/auth/admin/users/"+root.ID+"/role", "admin-token", `{"role":"user"}`)
+	expect(rr, http.StatusForbidden, "admin demoting root")
+	if !strings.Contains(rr.Body.String(), "root_protected") {
+		t.Fatalf("expected root_protected, got %s", rr.Body.String())
+	}
+}
@@ -18,7 +18,7 @@ func (h *handler) getSandboxBindingPublic(c *gin.Context) {
 	// Allow it when either:
 	// - the caller is an authenticated user (normal case), or
 	// - the caller is the trusted Console BFF (internal service token).
-	if !isInternalServiceRequest(c) {
+	if !h.isInternalServiceRequest(c) {
 		if _, ok := h.requireAuthenticatedUser(c); !ok {
 			return
 		}
@@ -0,0 +1,1533 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"account/internal/rbac"
+	"account/internal/scim"
+	"account/internal/store"
+	"account/internal/webhook"
+)
+
+const (
+	scimBasePath        = "/scim/v2"
+	scimTokenPrefix     = "scim_"
+	scimTokenContextKey = "scimToken"
+
+	defaultSCIMPageSize = 100
+	maxSCIMPageSize     = 200
+
+	// scimTokenTouchInterval bounds how often a token's last use is written
+	// back, since a provisioning cycle sends its requests in a burst.
+	scimTokenTouchInterval = time.Minute
+)
+
+// registerSCIMRoutes mounts the SCIM 2.0 service provider. Every request is
+// scoped to the tenant of its bearer token: users are the tenant's members
+// and groups are the tenant's provisioned groups.
+func registerSCIMRoutes(r *gin.Engine, h *handler) {
+	scimGroup := r.Group(scimBasePath)
+	scimGroup.Use(h.requireSCIMToken)
+	scimGroup.GET("/ServiceProviderConfig", h.scimServiceProviderConfig)
+	scimGroup.GET("/ResourceTypes", h.scimResourceTypes)
+
+	scimGroup.GET("/Users", h.scimListUsers)
+	scimGroup.POST("/Users", h.scimCreateUser)
+	scimGroup.GET("/Users/:id", h.scimGetUser)
+	scimGroup.PUT("/Users/:id", h.scimReplaceUser)
+	scimGroup.PATCH("/Users/:id", h.scimPatchUser)
+	scimGroup.DELETE("/Users/:id", h.scimDeleteUser)
+
+	scimGroup.GET("/Groups", h.scimListGroups)
+	scimGroup.POST("/Groups", h.scimCreateGroup)
+	scimGroup.GET("/Groups/:id", h.scimGetGroup)
+	scimGroup.PUT("/Groups/:id", h.scimReplaceGroup)
+	scimGroup.PATCH("/Groups/:id", h.scimPatchGroup)
+	scimGroup.DELETE("/Groups/:id", h.scimDeleteGroup)
+}
+
+type scimTokenResponse struct {
+	ID          string     `json:"id"`
+	TenantID    string     `json:"tenantId"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func newSCIMTokenResponse(token *store.SCIMToken) scimTokenResponse {
+	return scimTokenResponse{
+		ID:          token.ID,
+		TenantID:    token.TenantID,
+		Description: token.Description,
+		CreatedAt:   token.CreatedAt,
+		LastUsedAt:  token.LastUsedAt,
+	}
+}
+
+type createSCIMTokenRequest struct {
+	Description string `json:"description"`
+}
+
+// hashSCIMToken derives the lookup key of a token. Tokens carry 256 random
+// bits, so an unsalted digest is enough.
+func hashSCIMToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *handler) listSCIMTokens(c *gin.Context) {
+	_, tenant, ok := h.requireTenantManager(c, permissionAdminSCIMRead)
+	if !ok {
+		return
+	}
+	tokens, err := h.store.ListSCIMTokens(c.Request.Context(), tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_scim_tokens_failed", "failed to list scim tokens")
+		return
+	}
+	responses := make([]scimTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		responses = append(responses, newSCIMTokenResponse(&tokens[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": responses})
+}
+
+// createSCIMToken issues a bearer token for the tenant's identity provider.
+// The token is only returned in this response.
+func (h *handler) createSCIMToken(c *gin.Context) {
+	adminUser, ok := h.authorize(c, permissionAdminSCIMWrite, rbac.Tenant(c.Param("tenantId")))
+	if !ok {
+		return
+	}
+
+	var req createSCIMTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	description := strings.TrimSpace(req.Description)
+	if description == "" {
+		respondError(c, http.StatusBadRequest, "invalid_description", "description is required")
+		return
+	}
+	tenant, ok := h.loadTenant(c)
+	if !ok {
+		return
+	}
+	secret, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "create_scim_token_failed", "failed to generate scim token")
+		return
+	}
+	secret = scimTokenPrefix + secret
+
+	token := &store.SCIMToken{
+		TenantID:    tenant.ID,
+		Description: description,
+		TokenHash:   hashSCIMToken(secret),
+	}
+	if err := h.store.CreateSCIMToken(c.Request.Context(), token); err != nil {
+		respondError(c, http.StatusInternalServerError, "create_scim_token_failed", "failed to create scim token")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionSCIMTokenCreate,
+		TargetType: auditTargetSCIMToken,
+		TargetID:   token.ID,
+		TenantID:   tenant.ID,
+		After:      map[string]any{"description": token.Description},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"scimToken": newSCIMTokenResponse(token), "token": secret})
+}
+
+func (h *handler) deleteSCIMToken(c *gin.Context) {
+	adminUser, ok := h.authorize(c, permissionAdminSCIMWrite, rbac.Tenant(c.Param("tenantId")))
+	if !ok {
+		return
+	}
+
+	tenantID := strings.TrimSpace(c.Param("tenantId"))
+	tokenID := strings.TrimSpace(c.Param("tokenId"))
+	if err := h.store.DeleteSCIMToken(c.Request.Context(), tenantID, tokenID); err != nil {
+		if errors.Is(err, store.ErrSCIMTokenNotFound) {
+			respondError(c, http.StatusNotFound, "scim_token_not_found", "scim token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_scim_token_failed", "failed to delete scim token")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionSCIMTokenRevoke,
+		TargetType: auditTargetSCIMToken,
+		TargetID:   tokenID,
+		TenantID:   tenantID,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) loadTenant(c *gin.Context) (*store.Tenant, bool) {
+	tenant, err := h.store.GetTenant(c.Request.Context(), strings.TrimSpace(c.Param("tenantId")))
+	if err != nil {
+		if errors.Is(err, store.ErrTenantNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_not_found", "tenant not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_lookup_failed", "failed to load tenant")
+		return nil, false
+	}
+	return tenant, true
+}
+
+// requireSCIMToken authenticates the tenant's identity provider. A token
+// stops working as soon as it is revoked or its tenant is removed.
+func (h *handler) requireSCIMToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	raw := extractToken(c.GetHeader("Authorization"))
+	if !strings.HasPrefix(raw, scimTokenPrefix) {
+		respondSCIMUnauthorized(c)
+		return
+	}
+	token, err := h.store.GetSCIMTokenByHash(ctx, hashSCIMToken(raw))
+	if err != nil {
+		if errors.Is(err, store.ErrSCIMTokenNotFound) {
+			respondSCIMUnauthorized(c)
+			return
+		}
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to verify token")
+		return
+	}
+	if _, err := h.store.GetTenant(ctx, token.TenantID); err != nil {
+		if errors.Is(err, store.ErrTenantNotFound) {
+			respondSCIMUnauthorized(c)
+			return
+		}
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to verify token")
+		return
+	}
+
+	now := time.Now().UTC()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= scimTokenTouchInterval {
+		if err := h.store.TouchSCIMToken(ctx, token.ID, now); err != nil {
+			slog.Warn("failed to record scim token use", "err", err, "tokenID", token.ID)
+		}
+	}
+	c.Set(scimTokenContextKey, token)
+	c.Next()
+}
+
+func scimTokenFromContext(c *gin.Context) *store.SCIMToken {
+	return c.MustGet(scimTokenContextKey).(*store.SCIMToken)
+}
+
+func writeSCIM(c *gin.Context, status int, body any) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, scim.MediaType+"; charset=utf-8", payload)
+}
+
+func respondSCIMError(c *gin.Context, status int, scimType, detail string) {
+	writeSCIM(c, status, scim.NewError(status, scimType, detail))
+	c.Abort()
+}
+
+func respondSCIMUnauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	respondSCIMError(c, http.StatusUnauthorized, "", "a valid scim token is required")
+}
+
+// respondSCIMPatchError reports an error from applying PATCH operations,
+// which are scim.Error values when the request is at fault.
+func respondSCIMPatchError(c *gin.Context, err error) {
+	var scimErr scim.Error
+	if errors.As(err, &scimErr) {
+		status, _ := strconv.Atoi(scimErr.Status)
+		respondSCIMError(c, status, scimErr.ScimType, scimErr.Detail)
+		return
+	}
+	respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+}
+
+func (h *handler) scimBaseURL() string {
+	return strings.TrimRight(h.publicURL, "/") + scimBasePath
+}
+
+func (h *handler) scimLocation(resource, id string) string {
+	return h.scimBaseURL() + "/" + resource + "/" + id
+}
+
+// parseSCIMQuery reads the filter and pagination parameters of a list
+// request.
+func parseSCIMQuery(c *gin.Context) (scim.Filter, int, int, bool) {
+	filter, err := scim.ParseFilter(c.Query("filter"))
+	if err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidFilter, err.Error())
+		return nil, 0, 0, false
+	}
+	startIndex, count, err := scim.ParsePagination(c.Query("startIndex"), c.Query("count"), defaultSCIMPageSize, maxSCIMPageSize)
+	if err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return nil, 0, 0, false
+	}
+	return filter, startIndex, count, true
+}
+
+func (h *handler) scimServiceProviderConfig(c *gin.Context) {
+	writeSCIM(c, http.StatusOK, scim.ServiceProviderConfig(maxSCIMPageSize))
+}
+
+func (h *handler) scimResourceTypes(c *gin.Context) {
+	resourceTypes := scim.ResourceTypes(h.scimBaseURL())
+	writeSCIM(c, http.StatusOK, scim.NewListResponse(resourceTypes, 1, len(resourceTypes)))
+}
+
+// scimMember is a tenant member as SCIM sees it.
+type scimMember struct {
+	membership store.TenantMembership
+	user       *store.User
+}
+
+// scimManageable reports whether SCIM may see and change user. Operators,
+// administrators and the root account are managed by platform
+// administrators only, so they are left out of SCIM altogether.
+func (h *handler) scimManageable(user *store.User) bool {
+	return strings.EqualFold(user.Role, store.RoleUser) && !h.isRootAccount(user)
+}
+
+// scimOwnsAccount reports whether the tenant may change the account of
+// member itself: its email, password and active state, and deleting it. It
+// may for accounts it provisioned that belong to no other tenant, and for
+// accounts whose email is on one of its verified domains. Everyone else
+// joined with an account of their own, so SCIM only manages their
+// membership and groups.
+func (h *handler) scimOwnsAccount(ctx context.Context, member *scimMember) (bool, error) {
+	if member.membership.Provisioned {
+		memberships, err := h.store.ListTenantMembershipsByUser(ctx, member.user.ID)
+		if err != nil {
+			return false, err
+		}
+		if len(memberships) == 1 && memberships[0].TenantID == member.membership.TenantID {
+			return true, nil
+		}
+	}
+	at := strings.LastIndex(member.user.Email, "@")
+	if at < 0 {
+		return false, nil
+	}
+	domains, err := h.store.ListTenantDomains(ctx, member.membership.TenantID)
+	if err != nil {
+		return false, err
+	}
+	emailDomain := member.user.Email[at+1:]
+	for _, domain := range domains {
+		if domain.Status == store.TenantDomainStatusVerified && strings.EqualFold(domain.Domain, emailDomain) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listSCIMMembers loads the users of a tenant that SCIM manages.
+func (h *handler) listSCIMMembers(c *gin.Context, tenantID string) ([]scimMember, bool) {
+	ctx := c.Request.Context()
+	memberships, err := h.store.ListTenantMemberships(ctx, tenantID)
+	if err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to list users")
+		return nil, false
+	}
+	members := make([]scimMember, 0, len(memberships))
+	for _, membership := range memberships {
+		user, err := h.store.GetUserByID(ctx, membership.UserID)
+		if err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				continue
+			}
+			respondSCIMError(c, http.StatusInternalServerError, "", "failed to list users")
+			return nil, false
+		}
+		if h.scimManageable(user) {
+			members = append(members, scimMember{membership: membership, user: user})
+		}
+	}
+	return members, true
+}
+
+// loadSCIMMember loads the user named by the id path parameter.
+func (h *handler) loadSCIMMember(c *gin.Context, tenantID string) (*scimMember, bool) {
+	ctx := c.Request.Context()
+	membership, err := h.store.GetTenantMembership(ctx, tenantID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, store.ErrTenantMembershipNotFound) {
+			respondSCIMError(c, http.StatusNotFound, "", "user not found")
+			return nil, false
+		}
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to load user")
+		return nil, false
+	}
+	user, err := h.store.GetUserByID(ctx, membership.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			respondSCIMError(c, http.StatusNotFound, "", "user not found")
+			return nil, false
+		}
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to load user")
+		return nil, false
+	}
+	if !h.scimManageable(user) {
+		respondSCIMError(c, http.StatusNotFound, "", "user not found")
+		return nil, false
+	}
+	return &scimMember{membership: *membership, user: user}, true
+}
+
+func (h *handler) listSCIMGroups(c *gin.Context, tenantID string) ([]store.SCIMGroup, bool) {
+	groups, err := h.store.ListSCIMGroups(c.Request.Context(), tenantID)
+	if err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to list groups")
+		return nil, false
+	}
+	return groups, true
+}
+
+func (h *handler) loadSCIMGroup(c *gin.Context, tenantID string) (*store.SCIMGroup, bool) {
+	group, err := h.store.GetSCIMGroup(c.Request.Context(), tenantID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, store.ErrSCIMGroupNotFound) {
+			respondSCIMError(c, http.StatusNotFound, "", "group not found")
+			return nil, false
+		}
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to load group")
+		return nil, false
+	}
+	return group, true
+}
+
+func hasGroup(groups []string, name string) bool {
+	return slices.ContainsFunc(groups, func(group string) bool {
+		return strings.EqualFold(group, name)
+	})
+}
+
+// scimUserResource maps a member onto the User resource: userName and the
+// single email are the account email, and displayName is the account name.
+func (h *handler) scimUserResource(member scimMember, groups []store.SCIMGroup) scim.User {
+	user := member.user
+	active := user.Active
+	resource := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          user.ID,
+		ExternalID:  member.membership.ExternalID,
+		UserName:    user.Email,
+		Name:        &scim.Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt.UTC(),
+			LastModified: user.UpdatedAt.UTC(),
+			Location:     h.scimLocation("Users", user.ID),
+		},
+	}
+	for _, group := range groups {
+		if hasGroup(user.Groups, group.DisplayName) {
+			resource.Groups = append(resource.Groups, scim.GroupRef{
+				Value:   group.ID,
+				Ref:     h.scimLocation("Groups", group.ID),
+				Display: group.DisplayName,
+			})
+		}
+	}
+	return resource
+}
+
+// scimUserValues exposes the filterable attributes of a User resource.
+func scimUserValues(resource scim.User) func(string) []string {
+	return func(attribute string) []string {
+		switch attribute {
+		case "id":
+			return []string{resource.ID}
+		case "username":
+			return []string{resource.UserName}
+		case "externalid":
+			return []string{resource.ExternalID}
+		case "displayname", "name.formatted":
+			return []string{resource.DisplayName}
+		case "emails", "emails.value":
+			values := make([]string, 0, len(resource.Emails))
+			for _, email := range resource.Emails {
+				values = append(values, email.Value)
+			}
+			return values
+		case "active":
+			return []string{strconv.FormatBool(resource.Active != nil && *resource.Active)}
+		case "groups", "groups.value", "groups.display":
+			values := make([]string, 0, len(resource.Groups))
+			for _, group := range resource.Groups {
+				if attribute == "groups.display" {
+					values = append(values, group.Display)
+				} else {
+					values = append(values, group.Value)
+				}
+			}
+			return values
+		}
+		return nil
+	}
+}
+
+// resolveSCIMUserIdentity works out the account email and name a desired
+// resource asks for. Both can be given in two places, so whichever changed
+// relative to current wins, preferring userName and displayName. A new user
+// is resolved against the zero resource.
+func resolveSCIMUserIdentity(current, desired scim.User) (string, string, error) {
+	email := current.UserName
+	userName := strings.TrimSpace(desired.UserName)
+	userNameChanged := userName != "" && !strings.EqualFold(userName, current.UserName)
+	primaryEmail := desired.PrimaryEmail()
+	switch {
+	case userNameChanged && strings.Contains(userName, "@"):
+		email = userName
+	case primaryEmail != "" && !strings.EqualFold(primaryEmail, current.PrimaryEmail()):
+		email = primaryEmail
+	case userNameChanged:
+		return "", "", errors.New("userName must be an email address")
+	}
+	email = strings.ToLower(email)
+	if !strings.Contains(email, "@") {
+		return "", "", errors.New("userName must be an email address")
+	}
+
+	name := current.DisplayName
+	if displayName := strings.TrimSpace(desired.DisplayName); displayName != "" && displayName != current.DisplayName {
+		name = displayName
+	} else if display := desired.Name.Display(); display != "" && display != current.Name.Display() {
+		name = display
+	}
+	if name == "" {
+		name = email
+	}
+	return email, name, nil
+}
+
+// hashSCIMPassword validates and hashes a password set by the identity
+// provider, using the rules of self-service registration.
+func hashSCIMPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "password must be at least 8 characters")
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// saveSCIMUser writes user, falling back to the email as the account name
+// when the requested name is taken: names are unique across the service,
+// while identity providers freely reuse display names.
+func (h *handler) saveSCIMUser(c *gin.Context, user *store.User, create bool) error {
+	save := h.store.UpdateUser
+	if create {
+		save = h.store.CreateUser
+	}
+	err := save(c.Request.Context(), user)
+	if errors.Is(err, store.ErrNameExists) && user.Name != user.Email {
+		user.Name = user.Email
+		err = save(c.Request.Context(), user)
+	}
+	return err
+}
+
+func respondSCIMUserSaveError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrEmailExists):
+		respondSCIMError(c, http.StatusConflict, scim.ErrorUniqueness, "a user with this userName already exists")
+	case errors.Is(err, store.ErrNameExists):
+		respondSCIMError(c, http.StatusConflict, scim.ErrorUniqueness, "a user with this displayName already exists")
+	case errors.Is(err, store.ErrInvalidName):
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is invalid")
+	default:
+		respondSCIMPatchError(c, err)
+	}
+}
+
+func (h *handler) checkSCIMEmailAllowed(c *gin.Context, email string) bool {
+	blacklisted, err := h.store.IsBlacklisted(c.Request.Context(), email)
+	if err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to verify email status")
+		return false
+	}
+	if blacklisted {
+		respondSCIMError(c, http.StatusForbidden, "", "this email address is blocked")
+		return false
+	}
+	return true
+}
+
+// auditSCIMUserFields extends the admin snapshot with the attributes SCIM
+// manages.
+func auditSCIMUserFields(user *store.User, externalID string) map[string]any {
+	fields := auditUserFields(user)
+	fields["email"] = user.Email
+	fields["name"] = user.Name
+	fields["externalId"] = externalID
+	return fields
+}
+
+// recordSCIMAudit records a change made by the tenant's identity provider.
+// There is no acting user; the token is named in the metadata instead.
+func (h *handler) recordSCIMAudit(c *gin.Context, action, targetType, targetID string, before, after map[string]any) {
+	token := scimTokenFromContext(c)
+	if before != nil && after != nil {
+		before, after = auditDiff(before, after)
+	}
+	h.recordAudit(c, nil, store.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		TenantID:   token.TenantID,
+		Before:     before,
+		After:      after,
+		Metadata:   map[string]any{"scimTokenId": token.ID},
+	})
+}
+
+func (h *handler) scimListUsers(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	filter, startIndex, count, ok := parseSCIMQuery(c)
+	if !ok {
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+	groups, ok := h.listSCIMGroups(c, token.TenantID)
+	if !ok {
+		return
+	}
+
+	resources := make([]scim.User, 0, len(members))
+	for _, member := range members {
+		resource := h.scimUserResource(member, groups)
+		if filter.Matches(scimUserValues(resource)) {
+			resources = append(resources, resource)
+		}
+	}
+	writeSCIM(c, http.StatusOK, scim.NewListResponse(resources, startIndex, count))
+}
+
+func (h *handler) scimGetUser(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	member, ok := h.loadSCIMMember(c, token.TenantID)
+	if !ok {
+		return
+	}
+	groups, ok := h.listSCIMGroups(c, token.TenantID)
+	if !ok {
+		return
+	}
+	writeSCIM(c, http.StatusOK, h.scimUserResource(*member, groups))
+}
+
+// scimCreateUser provisions an account and makes it a member of the tenant.
+// The identity provider vouches for the user, not for the mailbox, so the
+// email starts out unverified.
+func (h *handler) scimCreateUser(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	ctx := c.Request.Context()
+
+	var req scim.User
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request payload")
+		return
+	}
+	email, name, err := resolveSCIMUserIdentity(scim.User{}, req)
+	if err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return
+	}
+	if !h.checkSCIMEmailAllowed(c, email) {
+		return
+	}
+	var passwordHash string
+	if req.Password != "" {
+		passwordHash, err = hashSCIMPassword(req.Password)
+	} else {
+		passwordHash, err = generatePasswordHash()
+	}
+	if err != nil {
+		respondSCIMPatchError(c, err)
+		return
+	}
+
+	user := &store.User{
+		Name:         name,
+		Email:        email,
+		PasswordHash: passwordHash,
+		Level:        store.LevelUser,
+		Role:         store.RoleUser,
+		Active:       true,
+	}
+	if err := h.saveSCIMUser(c, user, true); err != nil {
+		respondSCIMUserSaveError(c, err)
+		return
+	}
+	membership := &store.TenantMembership{
+		TenantID:    token.TenantID,
+		UserID:      user.ID,
+		Role:        store.TenantMembershipRoleUser,
+		Provisioned: true,
+	}
+	if err := h.store.UpsertTenantMembership(ctx, membership); err != nil {
+		if deleteErr := h.store.DeleteUser(ctx, user.ID); deleteErr != nil {
+			slog.Warn("failed to roll back scim user", "err", deleteErr, "userID", user.ID)
+		}
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to add user to tenant")
+		return
+	}
+	externalID := strings.TrimSpace(req.ExternalID)
+	if externalID != "" {
+		if err := h.store.SetTenantMembershipExternalID(ctx, token.TenantID, user.ID, externalID); err != nil {
+			respondSCIMError(c, http.StatusInternalServerError, "", "failed to store externalId")
+			return
+		}
+	}
+	if req.Active != nil && !*req.Active {
+		user.Active = false
+		if err := h.store.UpdateUser(ctx, user); err != nil {
+			respondSCIMError(c, http.StatusInternalServerError, "", "failed to deactivate user")
+			return
+		}
+	}
+
+	member := scimMember{membership: *membership, user: user}
+	if created, err := h.store.GetUserByID(ctx, user.ID); err == nil {
+		member.user = created
+	}
+	member.membership.ExternalID = externalID
+	if err := h.publishUserCreated(ctx, member.user); err != nil {
+		if err := h.store.DeleteTenantMembership(ctx, token.TenantID, user.ID); err != nil {
+			slog.Warn("failed to roll back scim membership", "err", err, "userID", user.ID)
+		}
+		h.rollbackCreatedUser(ctx, user)
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to publish user event")
+		return
+	}
+	h.recordSCIMAudit(c, auditActionSCIMUserCreate, auditTargetUser, user.ID, nil, auditSCIMUserFields(member.user, externalID))
+
+	resource := h.scimUserResource(member, nil)
+	c.Header("Location", resource.Meta.Location)
+	writeSCIM(c, http.StatusCreated, resource)
+}
+
+func (h *handler) scimReplaceUser(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	var req scim.User
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request payload")
+		return
+	}
+	member, ok := h.loadSCIMMember(c, token.TenantID)
+	if !ok {
+		return
+	}
+	groups, ok := h.listSCIMGroups(c, token.TenantID)
+	if !ok {
+		return
+	}
+	current := h.scimUserResource(*member, groups)
+	if req.Active == nil {
+		req.Active = current.Active
+	}
+	h.updateSCIMUser(c, member, groups, current, req)
+}
+
+func (h *handler) scimPatchUser(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	var req scim.PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return
+	}
+	member, ok := h.loadSCIMMember(c, token.TenantID)
+	if !ok {
+		return
+	}
+	groups, ok := h.listSCIMGroups(c, token.TenantID)
+	if !ok {
+		return
+	}
+
+	current := h.scimUserResource(*member, groups)
+	desired := current
+	// Name parts are patched onto an empty name, so that changing only the
+	// given name is noticed even though the account keeps a single name.
+	desired.Name = &scim.Name{}
+	desired.Emails = slices.Clone(current.Emails)
+	for _, op := range req.Operations {
+		if err := applySCIMUserOperation(&desired, op); err != nil {
+			respondSCIMPatchError(c, err)
+			return
+		}
+	}
+	h.updateSCIMUser(c, member, groups, current, desired)
+}
+
+// applySCIMUserOperation applies one PATCH operation to resource. Attributes
+// the service does not keep, such as enterprise extension attributes, are
+// ignored so that identity providers can send their full attribute mapping.
+func applySCIMUserOperation(resource *scim.User, op scim.PatchOperation) error {
+	if op.Path != "" {
+		path, err := scim.ParsePath(op.Path)
+		if err != nil {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, err.Error())
+		}
+		return setSCIMUserAttribute(resource, op.Op, path, op.Value)
+	}
+
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &attributes); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "value must be an object when path is omitted")
+	}
+	for key, value := range attributes {
+		path, err := scim.ParsePath(key)
+		if err != nil {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, err.Error())
+		}
+		if err := setSCIMUserAttribute(resource, op.Op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setSCIMUserAttribute(resource *scim.User, op string, path scim.Path, value json.RawMessage) error {
+	if op == scim.OpRemove {
+		switch path.Attribute {
+		case "externalid":
+			resource.ExternalID = ""
+		case "username", "active", "password":
+			return scim.NewError(http.StatusBadRequest, scim.ErrorMutability, path.Attribute+" cannot be removed")
+		}
+		return nil
+	}
+
+	switch path.Attribute {
+	case "active":
+		active, err := decodeSCIMBool(value)
+		if err != nil {
+			return err
+		}
+		resource.Active = &active
+	case "username":
+		return decodeSCIMString(value, &resource.UserName)
+	case "displayname":
+		return decodeSCIMString(value, &resource.DisplayName)
+	case "externalid":
+		return decodeSCIMString(value, &resource.ExternalID)
+	case "password":
+		return decodeSCIMString(value, &resource.Password)
+	case "name":
+		switch path.SubAttribute {
+		case "":
+			if err := json.Unmarshal(value, resource.Name); err != nil {
+				return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "name must be an object")
+			}
+		case "formatted":
+			return decodeSCIMString(value, &resource.Name.Formatted)
+		case "givenname":
+			return decodeSCIMString(value, &resource.Name.GivenName)
+		case "familyname":
+			return decodeSCIMString(value, &resource.Name.FamilyName)
+		}
+	case "emails":
+		switch {
+		case path.SubAttribute == "value":
+			var email string
+			if err := decodeSCIMString(value, &email); err != nil {
+				return err
+			}
+			resource.Emails = []scim.Email{{Value: email, Primary: true}}
+		case path.SubAttribute == "" && path.Filter == nil:
+			var emails []scim.Email
+			if err := json.Unmarshal(value, &emails); err != nil {
+				return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "emails must be an array")
+			}
+			resource.Emails = emails
+		}
+	}
+	return nil
+}
+
+func decodeSCIMString(value json.RawMessage, target *string) error {
+	var decoded string
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "value must be a string")
+	}
+	*target = strings.TrimSpace(decoded)
+	return nil
+}
+
+// decodeSCIMBool accepts a JSON boolean or, as some identity providers send,
+// a string holding one.
+func decodeSCIMBool(value json.RawMessage) (bool, error) {
+	var decoded bool
+	if err := json.Unmarshal(value, &decoded); err == nil {
+		return decoded, nil
+	}
+	var raw string
+	if err := json.Unmarshal(value, &raw); err == nil {
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(raw)); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "value must be a boolean")
+}
+
+// updateSCIMUser stores the differences between current and desired.
+// Deactivating a user pauses the account the same way an administrator
+// would, revoking its refresh tokens. For accounts the tenant does not own
+// (see scimOwnsAccount) changing the email, password or active state is
+// refused and the name is left alone.
+func (h *handler) updateSCIMUser(c *gin.Context, member *scimMember, groups []store.SCIMGroup, current, desired scim.User) {
+	ctx := c.Request.Context()
+	user := member.user
+	email, name, err := resolveSCIMUserIdentity(current, desired)
+	if err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return
+	}
+	owned, err := h.scimOwnsAccount(ctx, member)
+	if err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to load user memberships")
+		return
+	}
+	if !owned {
+		if email != user.Email || desired.Password != "" || (desired.Active != nil && *desired.Active != user.Active) {
+			respondSCIMError(c, http.StatusForbidden, "", "the account is not managed by this tenant; only its externalId and groups can be changed")
+			return
+		}
+		name = user.Name
+	}
+
+	before := auditSCIMUserFields(user, member.membership.ExternalID)
+	wasActive := user.Active
+	if email != user.Email {
+		if !h.checkSCIMEmailAllowed(c, email) {
+			return
+		}
+		user.Email = email
+		user.EmailVerified = false
+	}
+	user.Name = name
+	if desired.Active != nil {
+		user.Active = *desired.Active
+	}
+	passwordChanged := desired.Password != ""
+	if passwordChanged {
+		hashed, err := hashSCIMPassword(desired.Password)
+		if err != nil {
+			respondSCIMPatchError(c, err)
+			return
+		}
+		user.PasswordHash = hashed
+	}
+	if err := h.saveSCIMUser(c, user, false); err != nil {
+		respondSCIMUserSaveError(c, err)
+		return
+	}
+	externalID := strings.TrimSpace(desired.ExternalID)
+	if externalID != member.membership.ExternalID {
+		if err := h.store.SetTenantMembershipExternalID(ctx, member.membership.TenantID, user.ID, externalID); err != nil {
+			respondSCIMError(c, http.StatusInternalServerError, "", "failed to store externalId")
+			return
+		}
+		member.membership.ExternalID = externalID
+	}
+
+	switch {
+	case wasActive && !user.Active:
+		h.revokeRefreshTokens(ctx, user.ID, store.RefreshTokenRevokedUserPaused)
+	case passwordChanged:
+		h.revokeRefreshTokens(ctx, user.ID, store.RefreshTokenRevokedPasswordChanged)
+	}
+	after := auditSCIMUserFields(user, externalID)
+	if passwordChanged {
+		before["passwordChanged"], after["passwordChanged"] = false, true
+	}
+	h.recordSCIMAudit(c, auditActionSCIMUserUpdate, auditTargetUser, user.ID, before, after)
+	var publishErr error
+	switch {
+	case wasActive && !user.Active:
+		publishErr = h.publishUserEvent(ctx, webhook.EventUserPaused, user, nil)
+	case !wasActive && user.Active:
+		publishErr = h.publishUserEvent(ctx, webhook.EventUserResumed, user, nil)
+	}
+	if publishErr != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to publish user event")
+		return
+	}
+
+	writeSCIM(c, http.StatusOK, h.scimUserResource(*member, groups))
+}
+
+// scimDeleteUser removes the user from the tenant. An account the tenant
+// owns is deleted once it belongs to no tenant; otherwise it only loses the
+// tenant's groups.
+func (h *handler) scimDeleteUser(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	ctx := c.Request.Context()
+	member, ok := h.loadSCIMMember(c, token.TenantID)
+	if !ok {
+		return
+	}
+	groups, ok := h.listSCIMGroups(c, token.TenantID)
+	if !ok {
+		return
+	}
+	user := member.user
+	before := auditSCIMUserFields(user, member.membership.ExternalID)
+	owned, err := h.scimOwnsAccount(ctx, member)
+	if err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to load user memberships")
+		return
+	}
+
+	if err := h.store.DeleteTenantMembership(ctx, token.TenantID, user.ID); err != nil && !errors.Is(err, store.ErrTenantMembershipNotFound) {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to remove user from tenant")
+		return
+	}
+	remaining, err := h.store.ListTenantMembershipsByUser(ctx, user.ID)
+	if err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to load user memberships")
+		return
+	}
+
+	if owned && len(remaining) == 0 {
+		if err := h.store.DeleteUser(ctx, user.ID); err != nil {
+			respondSCIMError(c, http.StatusInternalServerError, "", "failed to delete user")
+			return
+		}
+		h.recordSCIMAudit(c, auditActionSCIMUserDelete, auditTargetUser, user.ID, before, nil)
+		if err := h.publishUserEvent(ctx, webhook.EventUserDeleted, user, nil); err != nil {
+			respondSCIMError(c, http.StatusInternalServerError, "", "failed to publish user event")
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if kept := withoutSCIMGroups(user.Groups, groups); len(kept) != len(user.Groups) {
+		user.Groups = kept
+		if err := h.store.UpdateUser(ctx, user); err != nil {
+			slog.Warn("failed to drop tenant groups of removed scim user", "err", err, "userID", user.ID)
+		}
+	}
+	h.recordSCIMAudit(c, auditActionSCIMUserDelete, auditTargetUser, user.ID, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// withoutSCIMGroups returns names without those of the given provisioned
+// groups, for a user who leaves their tenant.
+func withoutSCIMGroups(names []string, groups []store.SCIMGroup) []string {
+	kept := make([]string, 0, len(names))
+	for _, name := range names {
+		if !slices.ContainsFunc(groups, func(group store.SCIMGroup) bool { return strings.EqualFold(group.DisplayName, name) }) {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// scimGroupResource maps a provisioned group onto the Group resource. Its
+// members are the tenant users whose groups include its name.
+func (h *handler) scimGroupResource(group store.SCIMGroup, members []scimMember) scim.Group {
+	resource := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          group.ID,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     []scim.Member{},
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt.UTC(),
+			LastModified: group.UpdatedAt.UTC(),
+			Location:     h.scimLocation("Groups", group.ID),
+		},
+	}
+	for _, member := range members {
+		if hasGroup(member.user.Groups, group.DisplayName) {
+			resource.Members = append(resource.Members, scim.Member{
+				Value:   member.user.ID,
+				Ref:     h.scimLocation("Users", member.user.ID),
+				Display: member.user.Name,
+			})
+		}
+	}
+	return resource
+}
+
+// scimGroupValues exposes the filterable attributes of a Group resource.
+func scimGroupValues(resource scim.Group) func(string) []string {
+	return func(attribute string) []string {
+		switch attribute {
+		case "id":
+			return []string{resource.ID}
+		case "displayname":
+			return []string{resource.DisplayName}
+		case "externalid":
+			return []string{resource.ExternalID}
+		case "members", "members.value":
+			values := make([]string, 0, len(resource.Members))
+			for _, member := range resource.Members {
+				values = append(values, member.Value)
+			}
+			return values
+		}
+		return nil
+	}
+}
+
+// scimExcludesMembers reports whether the client asked to leave members out,
+// as identity providers do when they only need to find a group.
+func scimExcludesMembers(c *gin.Context) bool {
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+// scimGroupMemberIDs returns the memberships of a group, keyed by user ID.
+func scimGroupMemberIDs(group store.SCIMGroup, members []scimMember) map[string]bool {
+	ids := make(map[string]bool)
+	for _, member := range members {
+		if hasGroup(member.user.Groups, group.DisplayName) {
+			ids[member.user.ID] = true
+		}
+	}
+	return ids
+}
+
+// decodeSCIMMembers reads member references given as an array or, from some
+// identity providers, a single object, and checks each names a tenant user.
+func decodeSCIMMembers(value json.RawMessage, members []scimMember) ([]string, error) {
+	var refs []scim.Member
+	if err := json.Unmarshal(value, &refs); err != nil {
+		var ref scim.Member
+		if err := json.Unmarshal(value, &ref); err != nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "members must be an array of member references")
+		}
+		refs = []scim.Member{ref}
+	}
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		id := strings.TrimSpace(ref.Value)
+		if !slices.ContainsFunc(members, func(member scimMember) bool { return member.user.ID == id }) {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, fmt.Sprintf("member %q is not a user of this tenant", id))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// syncSCIMGroupMembers makes exactly the users in desired carry name in
+// their groups, dropping previous from everyone after a rename. A
+// provisioned group owns its name within the tenant, so tenant users
+// outside desired lose it even if an administrator assigned it.
+func (h *handler) syncSCIMGroupMembers(c *gin.Context, members []scimMember, previous, name string, desired map[string]bool) error {
+	for _, member := range members {
+		user := member.user
+		groups := make([]string, 0, len(user.Groups)+1)
+		for _, group := range user.Groups {
+			if strings.EqualFold(group, name) || (previous != "" && strings.EqualFold(group, previous)) {
+				continue
+			}
+			groups = append(groups, group)
+		}
+		if desired[user.ID] {
+			groups = append(groups, name)
+		}
+		if slices.Equal(groups, user.Groups) {
+			continue
+		}
+		user.Groups = normalizeGroups(groups)
+		if err := h.store.UpdateUser(c.Request.Context(), user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditSCIMGroupFields(group *store.SCIMGroup, memberIDs map[string]bool) map[string]any {
+	members := make([]string, 0, len(memberIDs))
+	for id, member := range memberIDs {
+		if member {
+			members = append(members, id)
+		}
+	}
+	slices.Sort(members)
+	return map[string]any{
+		"displayName": group.DisplayName,
+		"externalId":  group.ExternalID,
+		"members":     members,
+	}
+}
+
+func respondSCIMGroupSaveError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrSCIMGroupExists):
+		respondSCIMError(c, http.StatusConflict, scim.ErrorUniqueness, "a group with this displayName already exists")
+	case errors.Is(err, store.ErrSCIMGroupNotFound):
+		respondSCIMError(c, http.StatusNotFound, "", "group not found")
+	default:
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to save group")
+	}
+}
+
+func (h *handler) scimListGroups(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	filter, startIndex, count, ok := parseSCIMQuery(c)
+	if !ok {
+		return
+	}
+	groups, ok := h.listSCIMGroups(c, token.TenantID)
+	if !ok {
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+
+	excludeMembers := scimExcludesMembers(c)
+	resources := make([]scim.Group, 0, len(groups))
+	for _, group := range groups {
+		resource := h.scimGroupResource(group, members)
+		if !filter.Matches(scimGroupValues(resource)) {
+			continue
+		}
+		if excludeMembers {
+			resource.Members = nil
+		}
+		resources = append(resources, resource)
+	}
+	writeSCIM(c, http.StatusOK, scim.NewListResponse(resources, startIndex, count))
+}
+
+func (h *handler) scimGetGroup(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	group, ok := h.loadSCIMGroup(c, token.TenantID)
+	if !ok {
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+	resource := h.scimGroupResource(*group, members)
+	if scimExcludesMembers(c) {
+		resource.Members = nil
+	}
+	writeSCIM(c, http.StatusOK, resource)
+}
+
+func (h *handler) scimCreateGroup(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	var req scim.Group
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request payload")
+		return
+	}
+	group := &store.SCIMGroup{
+		TenantID:    token.TenantID,
+		DisplayName: strings.TrimSpace(req.DisplayName),
+		ExternalID:  strings.TrimSpace(req.ExternalID),
+	}
+	if group.DisplayName == "" {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is required")
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+	desired, err := scimMemberSet(req.Members, members)
+	if err != nil {
+		respondSCIMPatchError(c, err)
+		return
+	}
+
+	if err := h.store.CreateSCIMGroup(c.Request.Context(), group); err != nil {
+		respondSCIMGroupSaveError(c, err)
+		return
+	}
+	if err := h.syncSCIMGroupMembers(c, members, "", group.DisplayName, desired); err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to update group members")
+		return
+	}
+	h.recordSCIMAudit(c, auditActionSCIMGroupCreate, auditTargetGroup, group.ID, nil, auditSCIMGroupFields(group, desired))
+
+	resource := h.scimGroupResource(*group, members)
+	c.Header("Location", resource.Meta.Location)
+	writeSCIM(c, http.StatusCreated, resource)
+}
+
+// scimMemberSet checks the member references of a Group resource.
+func scimMemberSet(refs []scim.Member, members []scimMember) (map[string]bool, error) {
+	desired := make(map[string]bool, len(refs))
+	if len(refs) == 0 {
+		return desired, nil
+	}
+	raw, err := json.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := decodeSCIMMembers(raw, members)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		desired[id] = true
+	}
+	return desired, nil
+}
+
+func (h *handler) scimReplaceGroup(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	var req scim.Group
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request payload")
+		return
+	}
+	displayName := strings.TrimSpace(req.DisplayName)
+	if displayName == "" {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is required")
+		return
+	}
+	group, ok := h.loadSCIMGroup(c, token.TenantID)
+	if !ok {
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+	desired, err := scimMemberSet(req.Members, members)
+	if err != nil {
+		respondSCIMPatchError(c, err)
+		return
+	}
+	h.updateSCIMGroup(c, group, members, displayName, strings.TrimSpace(req.ExternalID), desired)
+}
+
+func (h *handler) scimPatchGroup(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	var req scim.PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondSCIMError(c, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return
+	}
+	group, ok := h.loadSCIMGroup(c, token.TenantID)
+	if !ok {
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+
+	patch := scimGroupPatch{
+		displayName: group.DisplayName,
+		externalID:  group.ExternalID,
+		members:     scimGroupMemberIDs(*group, members),
+	}
+	for _, op := range req.Operations {
+		if err := patch.apply(op, members); err != nil {
+			respondSCIMPatchError(c, err)
+			return
+		}
+	}
+	h.updateSCIMGroup(c, group, members, patch.displayName, patch.externalID, patch.members)
+}
+
+// scimGroupPatch accumulates the effect of PATCH operations on a group.
+type scimGroupPatch struct {
+	displayName string
+	externalID  string
+	members     map[string]bool
+}
+
+func (p *scimGroupPatch) apply(op scim.PatchOperation, members []scimMember) error {
+	if op.Path == "" {
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attributes); err != nil {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "value must be an object when path is omitted")
+		}
+		for key, value := range attributes {
+			path, err := scim.ParsePath(key)
+			if err != nil {
+				return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, err.Error())
+			}
+			if err := p.set(op.Op, path, value, members); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	path, err := scim.ParsePath(op.Path)
+	if err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, err.Error())
+	}
+	return p.set(op.Op, path, op.Value, members)
+}
+
+func (p *scimGroupPatch) set(op string, path scim.Path, value json.RawMessage, members []scimMember) error {
+	switch path.Attribute {
+	case "displayname":
+		if op == scim.OpRemove {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorMutability, "displayName cannot be removed")
+		}
+		var displayName string
+		if err := decodeSCIMString(value, &displayName); err != nil {
+			return err
+		}
+		if displayName == "" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is required")
+		}
+		p.displayName = displayName
+	case "externalid":
+		if op == scim.OpRemove {
+			p.externalID = ""
+			return nil
+		}
+		return decodeSCIMString(value, &p.externalID)
+	case "members":
+		return p.setMembers(op, path, value, members)
+	}
+	return nil
+}
+
+// setMembers handles the member operations identity providers send: add or
+// remove a list of references, remove the members matching a value filter
+// such as members[value eq "id"], or replace the whole list.
+func (p *scimGroupPatch) setMembers(op string, path scim.Path, value json.RawMessage, members []scimMember) error {
+	if op == scim.OpRemove {
+		switch {
+		case path.Filter != nil:
+			for id := range p.members {
+				if path.Filter.Matches(func(attribute string) []string {
+					if attribute == "value" {
+						return []string{id}
+					}
+					return nil
+				}) {
+					delete(p.members, id)
+				}
+			}
+		case len(value) > 0:
+			ids, err := decodeSCIMMembers(value, members)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				delete(p.members, id)
+			}
+		default:
+			clear(p.members)
+		}
+		return nil
+	}
+
+	if path.Filter != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, "member filters are only supported for remove")
+	}
+	ids, err := decodeSCIMMembers(value, members)
+	if err != nil {
+		return err
+	}
+	if op == scim.OpReplace {
+		clear(p.members)
+	}
+	for _, id := range ids {
+		p.members[id] = true
+	}
+	return nil
+}
+
+// updateSCIMGroup stores a group's new name, externalId and members,
+// renaming the group in its members' groups.
+func (h *handler) updateSCIMGroup(c *gin.Context, group *store.SCIMGroup, members []scimMember, displayName, externalID string, desired map[string]bool) {
+	before := auditSCIMGroupFields(group, scimGroupMemberIDs(*group, members))
+	previous := group.DisplayName
+	if displayName != group.DisplayName || externalID != group.ExternalID {
+		group.DisplayName = displayName
+		group.ExternalID = externalID
+		if err := h.store.UpdateSCIMGroup(c.Request.Context(), group); err != nil {
+			respondSCIMGroupSaveError(c, err)
+			return
+		}
+	}
+	if err := h.syncSCIMGroupMembers(c, members, previous, group.DisplayName, desired); err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to update group members")
+		return
+	}
+	h.recordSCIMAudit(c, auditActionSCIMGroupUpdate, auditTargetGroup, group.ID, before, auditSCIMGroupFields(group, desired))
+
+	resource := h.scimGroupResource(*group, members)
+	if scimExcludesMembers(c) {
+		resource.Members = nil
+	}
+	writeSCIM(c, http.StatusOK, resource)
+}
+
+func (h *handler) scimDeleteGroup(c *gin.Context) {
+	token := scimTokenFromContext(c)
+	group, ok := h.loadSCIMGroup(c, token.TenantID)
+	if !ok {
+		return
+	}
+	members, ok := h.listSCIMMembers(c, token.TenantID)
+	if !ok {
+		return
+	}
+	before := auditSCIMGroupFields(group, scimGroupMemberIDs(*group, members))
+	if err := h.syncSCIMGroupMembers(c, members, "", group.DisplayName, nil); err != nil {
+		respondSCIMError(c, http.StatusInternalServerError, "", "failed to update group members")
+		return
+	}
+	if err := h.store.DeleteSCIMGroup(c.Request.Context(), token.TenantID, group.ID); err != nil {
+		respondSCIMGroupSaveError(c, err)
+		return
+	}
+	h.recordSCIMAudit(c, auditActionSCIMGroupDelete, auditTargetGroup, group.ID, before, nil)
+	c.Status(http.StatusNoContent)
+}
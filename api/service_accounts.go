@@ -0,0 +1,358 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/rbac"
+	"account/internal/store"
+)
+
+// serviceAccountRole is the role of the stand-in user a service account acts
+// as. It is not a built-in role and grants nothing by itself.
+const serviceAccountRole = "service_account"
+
+const maxServiceAccountNameLength = 100
+
+type serviceAccountResponse struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	TenantID    string    `json:"tenantId,omitempty"`
+	Permissions []string  `json:"permissions"`
+	Disabled    bool      `json:"disabled"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func newServiceAccountResponse(account *store.ServiceAccount) serviceAccountResponse {
+	permissions := account.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return serviceAccountResponse{
+		ID:          account.ID,
+		Name:        account.Name,
+		Description: account.Description,
+		TenantID:    account.TenantID,
+		Permissions: permissions,
+		Disabled:    account.Disabled,
+		CreatedBy:   account.CreatedBy,
+		CreatedAt:   account.CreatedAt,
+		UpdatedAt:   account.UpdatedAt,
+	}
+}
+
+// serviceAccountPrincipal is the user a service account acts as in handlers
+// and the audit log. Its permissions come from holds, never from its role.
+func serviceAccountPrincipal(account *store.ServiceAccount) *store.User {
+	return &store.User{
+		ID:     account.ID,
+		Name:   account.Name,
+		Role:   serviceAccountRole,
+		Level:  store.LevelUser,
+		Active: true,
+	}
+}
+
+func serviceAccountResource(account *store.ServiceAccount) rbac.Resource {
+	return rbac.Tenant(account.TenantID)
+}
+
+func validServiceAccountName(c *gin.Context, name string) bool {
+	if name == "" || len(name) > maxServiceAccountNameLength {
+		respondError(c, http.StatusBadRequest, "invalid_name", "name is required and must be at most 100 characters")
+		return false
+	}
+	return true
+}
+
+// loadServiceAccount authorizes permission on the service account named in
+// the path, which may belong to a tenant. Unknown accounts are checked
+// against the platform so that they do not leak to unauthorized callers.
+func (h *handler) loadServiceAccount(c *gin.Context, permission string) (*store.User, *store.ServiceAccount, bool) {
+	account, err := h.store.GetServiceAccount(c.Request.Context(), strings.TrimSpace(c.Param("serviceAccountId")))
+	if err != nil && !errors.Is(err, store.ErrServiceAccountNotFound) {
+		respondError(c, http.StatusInternalServerError, "service_account_lookup_failed", "failed to load service account")
+		return nil, nil, false
+	}
+	resource := rbac.Platform
+	if account != nil {
+		resource = serviceAccountResource(account)
+	}
+	actor, ok := h.authorize(c, permission, resource)
+	if !ok {
+		return nil, nil, false
+	}
+	if account == nil {
+		respondError(c, http.StatusNotFound, "service_account_not_found", "service account not found")
+		return nil, nil, false
+	}
+	return actor, account, true
+}
+
+func (h *handler) listServiceAccounts(c *gin.Context) {
+	tenantID := strings.TrimSpace(c.Query("tenantId"))
+	if _, ok := h.authorize(c, permissionAdminServiceAccountsRead, rbac.Tenant(tenantID)); !ok {
+		return
+	}
+
+	accounts, err := h.store.ListServiceAccounts(c.Request.Context(), tenantID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_service_accounts_failed", "failed to list service accounts")
+		return
+	}
+	responses := make([]serviceAccountResponse, 0, len(accounts))
+	for i := range accounts {
+		responses = append(responses, newServiceAccountResponse(&accounts[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"serviceAccounts": responses})
+}
+
+func (h *handler) createServiceAccount(c *gin.Context) {
+	var req struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		TenantID    string   `json:"tenantId"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	resource := rbac.Tenant(req.TenantID)
+	actor, ok := h.authorize(c, permissionAdminServiceAccountsWrite, resource)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if !validServiceAccountName(c, name) || !h.validRolePermissions(c, req.Permissions) {
+		return
+	}
+	ctx := c.Request.Context()
+	if resource.TenantID != "" {
+		if _, err := h.store.GetTenant(ctx, resource.TenantID); err != nil {
+			if errors.Is(err, store.ErrTenantNotFound) {
+				respondError(c, http.StatusNotFound, "tenant_not_found", "tenant not found")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "tenant_lookup_failed", "failed to load tenant")
+			return
+		}
+	}
+	if !h.requireGrantable(c, actor, req.Permissions, resource) {
+		return
+	}
+
+	account := &store.ServiceAccount{
+		Name:        name,
+		Description: req.Description,
+		TenantID:    resource.TenantID,
+		Permissions: req.Permissions,
+		CreatedBy:   actor.ID,
+	}
+	if err := h.store.CreateServiceAccount(ctx, account); err != nil {
+		if errors.Is(err, store.ErrServiceAccountExists) {
+			respondError(c, http.StatusConflict, "service_account_exists", "a service account with this name already exists")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "create_service_account_failed", "failed to create service account")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionServiceAccountCreate,
+		TargetType: auditTargetServiceAccount,
+		TargetID:   account.ID,
+		TenantID:   account.TenantID,
+		After:      map[string]any{"name": account.Name, "permissions": account.Permissions},
+	})
+	c.JSON(http.StatusCreated, gin.H{"serviceAccount": newServiceAccountResponse(account)})
+}
+
+func (h *handler) getServiceAccount(c *gin.Context) {
+	_, account, ok := h.loadServiceAccount(c, permissionAdminServiceAccountsRead)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"serviceAccount": newServiceAccountResponse(account)})
+}
+
+// updateServiceAccount changes the name, description, permissions or
+// disabled flag of a service account. Disabling it stops its tokens without
+// revoking them.
+func (h *handler) updateServiceAccount(c *gin.Context) {
+	actor, account, ok := h.loadServiceAccount(c, permissionAdminServiceAccountsWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        *string   `json:"name"`
+		Description *string   `json:"description"`
+		Permissions *[]string `json:"permissions"`
+		Disabled    *bool     `json:"disabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+
+	before := map[string]any{"name": account.Name, "description": account.Description, "permissions": account.Permissions, "disabled": account.Disabled}
+	updated := *account
+	if req.Name != nil {
+		updated.Name = strings.TrimSpace(*req.Name)
+		if !validServiceAccountName(c, updated.Name) {
+			return
+		}
+	}
+	if req.Description != nil {
+		updated.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		if !h.validRolePermissions(c, *req.Permissions) {
+			return
+		}
+		added := make([]string, 0, len(*req.Permissions))
+		for _, permission := range *req.Permissions {
+			if !slices.Contains(account.Permissions, strings.TrimSpace(permission)) {
+				added = append(added, permission)
+			}
+		}
+		if !h.requireGrantable(c, actor, added, serviceAccountResource(account)) {
+			return
+		}
+		updated.Permissions = *req.Permissions
+	}
+	if req.Disabled != nil {
+		updated.Disabled = *req.Disabled
+	}
+
+	if err := h.store.UpdateServiceAccount(c.Request.Context(), &updated); err != nil {
+		switch {
+		case errors.Is(err, store.ErrServiceAccountExists):
+			respondError(c, http.StatusConflict, "service_account_exists", "a service account with this name already exists")
+		case errors.Is(err, store.ErrServiceAccountNotFound):
+			respondError(c, http.StatusNotFound, "service_account_not_found", "service account not found")
+		default:
+			respondError(c, http.StatusInternalServerError, "update_service_account_failed", "failed to update service account")
+		}
+		return
+	}
+	after := map[string]any{"name": updated.Name, "description": updated.Description, "permissions": updated.Permissions, "disabled": updated.Disabled}
+	before, after = auditDiff(before, after)
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionServiceAccountUpdate,
+		TargetType: auditTargetServiceAccount,
+		TargetID:   updated.ID,
+		TenantID:   updated.TenantID,
+		Before:     before,
+		After:      after,
+	})
+	c.JSON(http.StatusOK, gin.H{"serviceAccount": newServiceAccountResponse(&updated)})
+}
+
+// deleteServiceAccount removes a service account and revokes its tokens.
+func (h *handler) deleteServiceAccount(c *gin.Context) {
+	actor, account, ok := h.loadServiceAccount(c, permissionAdminServiceAccountsWrite)
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteServiceAccount(c.Request.Context(), account.ID); err != nil {
+		if errors.Is(err, store.ErrServiceAccountNotFound) {
+			respondError(c, http.StatusNotFound, "service_account_not_found", "service account not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_service_account_failed", "failed to delete service account")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionServiceAccountDelete,
+		TargetType: auditTargetServiceAccount,
+		TargetID:   account.ID,
+		TenantID:   account.TenantID,
+		Before:     map[string]any{"name": account.Name, "permissions": account.Permissions},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) listServiceAccountTokens(c *gin.Context) {
+	_, account, ok := h.loadServiceAccount(c, permissionAdminServiceAccountsRead)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.store.ListAPITokens(c.Request.Context(), store.APITokenOwner{ServiceAccountID: account.ID})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_api_tokens_failed", "failed to list api tokens")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": newAPITokenResponses(tokens)})
+}
+
+// createServiceAccountToken issues a token for a service account. Its scopes
+// must be a subset of the account's permissions and it is bound to the
+// account's tenant; the token is only returned in this response.
+func (h *handler) createServiceAccountToken(c *gin.Context) {
+	actor, account, ok := h.loadServiceAccount(c, permissionAdminServiceAccountsWrite)
+	if !ok {
+		return
+	}
+
+	var req createAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(account.Permissions, strings.TrimSpace(scope)) {
+			respondError(c, http.StatusBadRequest, "invalid_scope", "scope is not a permission of the service account: "+strings.TrimSpace(scope))
+			return
+		}
+	}
+	req.TenantID = account.TenantID
+	token, secret, ok := h.issueAPIToken(c, actor, store.APITokenOwner{ServiceAccountID: account.ID}, req)
+	if !ok {
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionAdminAPITokenCreate,
+		TargetType: auditTargetAPIToken,
+		TargetID:   token.ID,
+		TenantID:   token.TenantID,
+		After:      map[string]any{"serviceAccountId": account.ID, "name": token.Name, "scopes": token.Scopes, "expiresAt": token.ExpiresAt},
+	})
+	c.JSON(http.StatusCreated, gin.H{"apiToken": newAPITokenResponse(token), "token": secret})
+}
+
+func (h *handler) deleteServiceAccountToken(c *gin.Context) {
+	actor, account, ok := h.loadServiceAccount(c, permissionAdminServiceAccountsWrite)
+	if !ok {
+		return
+	}
+
+	tokenID := strings.TrimSpace(c.Param("tokenId"))
+	if err := h.store.DeleteAPIToken(c.Request.Context(), store.APITokenOwner{ServiceAccountID: account.ID}, tokenID); err != nil {
+		if errors.Is(err, store.ErrAPITokenNotFound) {
+			respondError(c, http.StatusNotFound, "api_token_not_found", "api token not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "revoke_api_token_failed", "failed to revoke api token")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		Action:     auditActionAdminAPITokenRevoke,
+		TargetType: auditTargetAPIToken,
+		TargetID:   tokenID,
+		TenantID:   account.TenantID,
+		Metadata:   map[string]any{"serviceAccountId": account.ID},
+	})
+	c.Status(http.StatusNoContent)
+}
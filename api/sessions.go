@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+)
+
+// Auth methods recorded on sessions.
+const (
+	sessionAuthPassword          = "password"
+	sessionAuthTOTP              = "mfa_totp"
+	sessionAuthWebAuthn          = "mfa_webauthn"
+	sessionAuthRecoveryCode      = "mfa_recovery_code"
+	sessionAuthPasskey           = "passkey"
+	sessionAuthEmailVerification = "email_verification"
+	sessionAuthPasswordReset     = "password_reset"
+	sessionAuthAdminAssume       = "admin_assume"
+	sessionAuthOAuthPrefix       = "oauth:"
+
+	maxSessionUserAgentLength = 512
+)
+
+type sessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	AuthMethod string    `json:"authMethod"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+func newSessionResponses(sessions []store.Session, currentToken string) []sessionResponse {
+	responses := make([]sessionResponse, 0, len(sessions))
+	for _, sess := range sessions {
+		responses = append(responses, sessionResponse{
+			ID:         sess.ID,
+			UserAgent:  sess.UserAgent,
+			IPAddress:  sess.IPAddress,
+			AuthMethod: sess.AuthMethod,
+			CreatedAt:  sess.CreatedAt.UTC(),
+			LastSeenAt: sess.LastSeenAt.UTC(),
+			ExpiresAt:  sess.ExpiresAt.UTC(),
+			Current:    currentToken != "" && sess.Token == currentToken,
+		})
+	}
+	return responses
+}
+
+func sessionUserAgent(c *gin.Context) string {
+	userAgent := strings.TrimSpace(c.Request.UserAgent())
+	if len(userAgent) > maxSessionUserAgentLength {
+		userAgent = userAgent[:maxSessionUserAgentLength]
+	}
+	return userAgent
+}
+
+// evictCachedSessions drops revoked sessions from the session cache so they
+// stop authenticating immediately.
+func (h *handler) evictCachedSessions(ctx context.Context, sessions []store.Session) {
+	if h.sessionCache == nil {
+		return
+	}
+	for _, sess := range sessions {
+		if err := h.sessionCache.DeleteSession(ctx, sess.Token); err != nil {
+			slog.Warn("failed to evict cached session", "err", err, "sessionID", sess.ID)
+		}
+	}
+}
+
+func (h *handler) listSessions(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.store.ListSessionsByUser(c.Request.Context(), user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_sessions_failed", "failed to list sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": newSessionResponses(sessions, h.resolveSessionToken(c))})
+}
+
+func (h *handler) revokeSession(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	removed, err := h.store.DeleteSessionByID(c.Request.Context(), user.ID, strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		if errors.Is(err, store.ErrSessionNotFound) {
+			respondError(c, http.StatusNotFound, "session_not_found", "session not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "revoke_session_failed", "failed to revoke session")
+		return
+	}
+	h.evictCachedSessions(c.Request.Context(), []store.Session{*removed})
+
+	if removed.Token == h.resolveSessionToken(c) {
+		h.clearSessionCookie(c)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// revokeAllSessions signs the user out everywhere. With exceptCurrent=true
+// the session making the request is kept.
+func (h *handler) revokeAllSessions(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	keep := ""
+	if strings.EqualFold(strings.TrimSpace(c.Query("exceptCurrent")), "true") {
+		keep = h.resolveSessionToken(c)
+	}
+	removed, err := h.store.DeleteSessionsByUser(c.Request.Context(), user.ID, keep)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "revoke_sessions_failed", "failed to revoke sessions")
+		return
+	}
+	h.evictCachedSessions(c.Request.Context(), removed)
+
+	if keep == "" {
+		h.revokeRefreshTokens(c.Request.Context(), user.ID, store.RefreshTokenRevokedSignedOut)
+		h.clearSessionCookie(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": len(removed)})
+}
+
+func (h *handler) adminSessionTarget(c *gin.Context, permission string) (*store.User, *store.User, bool) {
+	adminUser, ok := h.requireAdminPermission(c, permission)
+	if !ok {
+		return nil, nil, false
+	}
+
+	target, err := h.store.GetUserByID(c.Request.Context(), strings.TrimSpace(c.Param("userId")))
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+			return nil, nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "user_lookup_failed", "failed to find user")
+		return nil, nil, false
+	}
+	if h.rejectProtected(c, target, "root account sessions cannot be managed") {
+		return nil, nil, false
+	}
+	return adminUser, target, true
+}
+
+func (h *handler) adminListUserSessions(c *gin.Context) {
+	_, target, ok := h.adminSessionTarget(c, permissionAdminUsersSessionsRead)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.store.ListSessionsByUser(c.Request.Context(), target.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_sessions_failed", "failed to list sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": newSessionResponses(sessions, h.resolveSessionToken(c))})
+}
+
+func (h *handler) adminRevokeUserSession(c *gin.Context) {
+	adminUser, target, ok := h.adminSessionTarget(c, permissionAdminUsersSessionsWrite)
+	if !ok {
+		return
+	}
+
+	removed, err := h.store.DeleteSessionByID(c.Request.Context(), target.ID, strings.TrimSpace(c.Param("sessionId")))
+	if err != nil {
+		if errors.Is(err, store.ErrSessionNotFound) {
+			respondError(c, http.StatusNotFound, "session_not_found", "session not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "revoke_session_failed", "failed to revoke session")
+		return
+	}
+	h.evictCachedSessions(c.Request.Context(), []store.Session{*removed})
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionUserSessionsKill,
+		TargetType: auditTargetUser,
+		TargetID:   target.ID,
+		Metadata:   map[string]any{"email": target.Email, "sessionIds": []string{removed.ID}},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) adminRevokeUserSessions(c *gin.Context) {
+	adminUser, target, ok := h.adminSessionTarget(c, permissionAdminUsersSessionsWrite)
+	if !ok {
+		return
+	}
+
+	removed, err := h.store.DeleteSessionsByUser(c.Request.Context(), target.ID, "")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "revoke_sessions_failed", "failed to revoke sessions")
+		return
+	}
+	h.evictCachedSessions(c.Request.Context(), removed)
+	h.revokeRefreshTokens(c.Request.Context(), target.ID, store.RefreshTokenRevokedSignedOut)
+	sessionIDs := make([]string, 0, len(removed))
+	for _, sess := range removed {
+		sessionIDs = append(sessionIDs, sess.ID)
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionUserSessionsKill,
+		TargetType: auditTargetUser,
+		TargetID:   target.ID,
+		Metadata:   map[string]any{"email": target.Email, "sessionIds": sessionIDs},
+	})
+	c.JSON(http.StatusOK, gin.H{"revoked": len(removed)})
+}
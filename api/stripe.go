@@ -507,7 +507,7 @@ func (h *handler) handleStripeEvent(ctx context.Context, event stripeEvent) erro
 				"user_email":   session.Metadata["user_email"],
 			}),
 		}
-		return h.store.UpsertSubscription(ctx, sub)
+		return h.upsertSubscriptionAndPublish(ctx, sub)
 	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
 		var subscription stripeSubscription
 		if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
@@ -578,7 +578,7 @@ func (h *handler) upsertStripeSubscription(ctx context.Context, source *stripeSu
 		cancelledAt := time.Now().UTC()
 		subscription.CancelledAt = &cancelledAt
 	}
-	return h.store.UpsertSubscription(ctx, subscription)
+	return h.upsertSubscriptionAndPublish(ctx, subscription)
 }
 
 func ParseStripeAllowedPriceIDs(value string) []string {
@@ -0,0 +1,1059 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/rbac"
+	"account/internal/store"
+)
+
+const (
+	defaultTenantInvitationTTL = 7 * 24 * time.Hour
+
+	// A custom domain is verified by publishing the domain's token as a TXT
+	// record on the challenge name below it.
+	tenantDomainChallengeLabel  = "_xcontrol-challenge"
+	tenantDomainChallengePrefix = "xcontrol-domain-verification="
+
+	tenantInvitationStatusPending  = "pending"
+	tenantInvitationStatusAccepted = "accepted"
+	tenantInvitationStatusExpired  = "expired"
+)
+
+// WithDomainTXTLookup replaces the DNS lookup used to verify custom tenant
+// domains. It exists primarily to make domain verification testable.
+func WithDomainTXTLookup(lookup func(ctx context.Context, name string) ([]string, error)) Option {
+	return func(h *handler) {
+		if lookup != nil {
+			h.lookupTXT = lookup
+		}
+	}
+}
+
+type tenantResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Edition   string    `json:"edition"`
+	Domain    string    `json:"domain,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type tenantDomainResponse struct {
+	ID           string                    `json:"id"`
+	Domain       string                    `json:"domain"`
+	Kind         string                    `json:"kind"`
+	IsPrimary    bool                      `json:"isPrimary"`
+	Status       string                    `json:"status"`
+	Verification *tenantDomainVerification `json:"verification,omitempty"`
+	CreatedAt    time.Time                 `json:"createdAt"`
+	UpdatedAt    time.Time                 `json:"updatedAt"`
+}
+
+// tenantDomainVerification is the DNS record that proves control of a
+// pending domain.
+type tenantDomainVerification struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type tenantMemberResponse struct {
+	UserID     string    `json:"userId"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	Role       string    `json:"role"`
+	ExternalID string    `json:"externalId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type tenantInvitationResponse struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Status     string     `json:"status"`
+	InvitedBy  string     `json:"invitedBy,omitempty"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func newTenantResponse(tenant *store.Tenant, primaryDomain string) tenantResponse {
+	return tenantResponse{
+		ID:        tenant.ID,
+		Name:      tenant.Name,
+		Edition:   tenant.Edition,
+		Domain:    primaryDomain,
+		CreatedAt: tenant.CreatedAt,
+		UpdatedAt: tenant.UpdatedAt,
+	}
+}
+
+func newTenantDomainResponse(domain *store.TenantDomain) tenantDomainResponse {
+	response := tenantDomainResponse{
+		ID:        domain.ID,
+		Domain:    domain.Domain,
+		Kind:      domain.Kind,
+		IsPrimary: domain.IsPrimary,
+		Status:    domain.Status,
+		CreatedAt: domain.CreatedAt,
+		UpdatedAt: domain.UpdatedAt,
+	}
+	if domain.Status == store.TenantDomainStatusPending && domain.VerificationToken != "" {
+		response.Verification = &tenantDomainVerification{
+			Type:  "TXT",
+			Name:  tenantDomainChallengeLabel + "." + domain.Domain,
+			Value: tenantDomainChallengePrefix + domain.VerificationToken,
+		}
+	}
+	return response
+}
+
+func newTenantInvitationResponse(invitation *store.TenantInvitation, now time.Time) tenantInvitationResponse {
+	status := tenantInvitationStatusPending
+	switch {
+	case invitation.AcceptedAt != nil:
+		status = tenantInvitationStatusAccepted
+	case !now.Before(invitation.ExpiresAt):
+		status = tenantInvitationStatusExpired
+	}
+	return tenantInvitationResponse{
+		ID:         invitation.ID,
+		Email:      invitation.Email,
+		Role:       invitation.Role,
+		Status:     status,
+		InvitedBy:  invitation.InvitedBy,
+		ExpiresAt:  invitation.ExpiresAt,
+		AcceptedAt: invitation.AcceptedAt,
+		CreatedAt:  invitation.CreatedAt,
+	}
+}
+
+func hashTenantInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireTenantManager authorises an action on the tenant named by the
+// tenantId path parameter and loads it. Besides platform roles, the policy
+// engine admits the tenant's own admins and roles bound within the tenant.
+func (h *handler) requireTenantManager(c *gin.Context, permission string) (*store.User, *store.Tenant, bool) {
+	user, ok := h.authorize(c, permission, rbac.Tenant(c.Param("tenantId")))
+	if !ok {
+		return nil, nil, false
+	}
+	tenant, ok := h.loadTenant(c)
+	if !ok {
+		return nil, nil, false
+	}
+	return user, tenant, true
+}
+
+// primaryTenantDomain returns the tenant's primary domain, or "" when it has
+// none.
+func (h *handler) primaryTenantDomain(ctx context.Context, tenantID string) (string, error) {
+	domains, err := h.store.ListTenantDomains(ctx, tenantID)
+	if err != nil {
+		return "", err
+	}
+	for _, domain := range domains {
+		if domain.IsPrimary {
+			return domain.Domain, nil
+		}
+	}
+	return "", nil
+}
+
+// provisionTenant creates a private tenant with a generated primary domain.
+func (h *handler) provisionTenant(ctx context.Context, name string) (*store.Tenant, string, error) {
+	domain, err := store.GenerateRandomTenantDomain()
+	if err != nil {
+		return nil, "", err
+	}
+	tenant := &store.Tenant{
+		Name:    strings.TrimSpace(name),
+		Edition: store.TenantPrivateEdition,
+	}
+	if err := h.store.EnsureTenant(ctx, tenant); err != nil {
+		return nil, "", err
+	}
+	if err := h.store.EnsureTenantDomain(ctx, &store.TenantDomain{
+		TenantID:  tenant.ID,
+		Domain:    domain,
+		Kind:      store.TenantDomainKindGenerated,
+		IsPrimary: true,
+		Status:    store.TenantDomainStatusVerified,
+	}); err != nil {
+		return nil, "", err
+	}
+	return tenant, domain, nil
+}
+
+func (h *handler) listTenants(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminTenantsRead); !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenants, err := h.store.ListTenants(ctx)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_tenants_failed", "failed to list tenants")
+		return
+	}
+	responses := make([]tenantResponse, 0, len(tenants))
+	for i := range tenants {
+		domain, err := h.primaryTenantDomain(ctx, tenants[i].ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "list_tenants_failed", "failed to list tenant domains")
+			return
+		}
+		responses = append(responses, newTenantResponse(&tenants[i], domain))
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": responses})
+}
+
+// createTenant creates an empty private tenant. Its first admin is added by
+// invitation.
+func (h *handler) createTenant(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "tenant_name_required", "name is required")
+		return
+	}
+
+	tenant, domain, err := h.provisionTenant(c.Request.Context(), name)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_create_failed", "failed to create tenant")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantCreate,
+		TargetType: auditTargetTenant,
+		TargetID:   tenant.ID,
+		After:      map[string]any{"name": tenant.Name, "edition": tenant.Edition, "domain": domain},
+	})
+	c.JSON(http.StatusCreated, gin.H{"tenant": newTenantResponse(tenant, domain)})
+}
+
+func (h *handler) getTenant(c *gin.Context) {
+	_, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsRead)
+	if !ok {
+		return
+	}
+
+	domains, err := h.store.ListTenantDomains(c.Request.Context(), tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_domains_read_failed", "failed to list tenant domains")
+		return
+	}
+	primary := ""
+	responses := make([]tenantDomainResponse, 0, len(domains))
+	for i := range domains {
+		if domains[i].IsPrimary {
+			primary = domains[i].Domain
+		}
+		responses = append(responses, newTenantDomainResponse(&domains[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant": newTenantResponse(tenant, primary), "domains": responses})
+}
+
+func (h *handler) updateTenant(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		respondError(c, http.StatusBadRequest, "tenant_name_required", "name is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	previous := tenant.Name
+	tenant.Name = name
+	if err := h.store.EnsureTenant(ctx, tenant); err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_update_failed", "failed to update tenant")
+		return
+	}
+	if previous != tenant.Name {
+		h.recordAudit(c, actor, store.AuditEvent{
+			TenantID:   tenant.ID,
+			Action:     auditActionTenantUpdate,
+			TargetType: auditTargetTenant,
+			TargetID:   tenant.ID,
+			Before:     map[string]any{"name": previous},
+			After:      map[string]any{"name": tenant.Name},
+		})
+	}
+	domain, err := h.primaryTenantDomain(ctx, tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_domains_read_failed", "failed to list tenant domains")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant": newTenantResponse(tenant, domain)})
+}
+
+// deleteTenant removes a tenant with everything that belongs to it. Member
+// accounts are kept but lose the tenant's provisioned groups.
+func (h *handler) deleteTenant(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+	tenant, ok := h.loadTenant(c)
+	if !ok {
+		return
+	}
+	if tenant.ID == store.SharedXWorkmateTenantID {
+		respondError(c, http.StatusConflict, "tenant_protected", "the shared tenant cannot be deleted")
+		return
+	}
+
+	ctx := c.Request.Context()
+	groups, err := h.store.ListSCIMGroups(ctx, tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_delete_failed", "failed to load tenant groups")
+		return
+	}
+	memberships, err := h.store.ListTenantMemberships(ctx, tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_delete_failed", "failed to load tenant members")
+		return
+	}
+	if err := h.store.DeleteTenant(ctx, tenant.ID); err != nil {
+		if errors.Is(err, store.ErrTenantNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_not_found", "tenant not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_delete_failed", "failed to delete tenant")
+		return
+	}
+	for _, membership := range memberships {
+		h.dropTenantGroups(ctx, membership.UserID, groups)
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantDelete,
+		TargetType: auditTargetTenant,
+		TargetID:   tenant.ID,
+		Before:     map[string]any{"name": tenant.Name, "edition": tenant.Edition, "members": len(memberships)},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// dropTenantGroups removes a tenant's provisioned groups from a user who
+// left it. The membership is already gone, so failures are only logged.
+func (h *handler) dropTenantGroups(ctx context.Context, userID string, groups []store.SCIMGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	user, err := h.store.GetUserByID(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, store.ErrUserNotFound) {
+			slog.Warn("failed to load former tenant member", "err", err, "userID", userID)
+		}
+		return
+	}
+	kept := withoutSCIMGroups(user.Groups, groups)
+	if len(kept) == len(user.Groups) {
+		return
+	}
+	user.Groups = kept
+	if err := h.store.UpdateUser(ctx, user); err != nil {
+		slog.Warn("failed to drop tenant groups of former member", "err", err, "userID", userID)
+	}
+}
+
+func (h *handler) listTenantDomains(c *gin.Context) {
+	_, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsRead)
+	if !ok {
+		return
+	}
+
+	domains, err := h.store.ListTenantDomains(c.Request.Context(), tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_domains_read_failed", "failed to list tenant domains")
+		return
+	}
+	responses := make([]tenantDomainResponse, 0, len(domains))
+	for i := range domains {
+		responses = append(responses, newTenantDomainResponse(&domains[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"domains": responses})
+}
+
+// addTenantDomain registers a custom domain. It stays pending, and does not
+// resolve to the tenant, until verifyTenantDomain finds its DNS record.
+func (h *handler) addTenantDomain(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Domain string `json:"domain"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	hostname, ok := normalizeCustomTenantDomain(payload.Domain)
+	if !ok {
+		respondError(c, http.StatusBadRequest, "invalid_domain", "domain must be a hostname outside svc.plus")
+		return
+	}
+	token, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_domain_create_failed", "failed to generate verification token")
+		return
+	}
+
+	domain := &store.TenantDomain{
+		TenantID:          tenant.ID,
+		Domain:            hostname,
+		Kind:              store.TenantDomainKindCustom,
+		Status:            store.TenantDomainStatusPending,
+		VerificationToken: token,
+	}
+	if err := h.store.CreateTenantDomain(c.Request.Context(), domain); err != nil {
+		if errors.Is(err, store.ErrTenantDomainExists) {
+			respondError(c, http.StatusConflict, "tenant_domain_exists", "domain is already registered")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_domain_create_failed", "failed to create tenant domain")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantDomainAdd,
+		TargetType: auditTargetTenantDomain,
+		TargetID:   domain.ID,
+		After:      map[string]any{"domain": domain.Domain, "status": domain.Status},
+	})
+	c.JSON(http.StatusCreated, gin.H{"domain": newTenantDomainResponse(domain)})
+}
+
+func (h *handler) loadTenantDomain(c *gin.Context, tenantID string) (*store.TenantDomain, bool) {
+	domain, err := h.store.GetTenantDomain(c.Request.Context(), tenantID, strings.TrimSpace(c.Param("domainId")))
+	if err != nil {
+		if errors.Is(err, store.ErrTenantDomainNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_domain_not_found", "tenant domain not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_domain_read_failed", "failed to load tenant domain")
+		return nil, false
+	}
+	return domain, true
+}
+
+// verifyTenantDomain moves a pending domain to verified once its challenge
+// TXT record is published. Verifying a verified domain is a no-op.
+func (h *handler) verifyTenantDomain(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+	domain, ok := h.loadTenantDomain(c, tenant.ID)
+	if !ok {
+		return
+	}
+	if domain.Status == store.TenantDomainStatusVerified {
+		c.JSON(http.StatusOK, gin.H{"domain": newTenantDomainResponse(domain)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	lookup := h.lookupTXT
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupTXT
+	}
+	want := tenantDomainChallengePrefix + domain.VerificationToken
+	records, err := lookup(ctx, tenantDomainChallengeLabel+"."+domain.Domain)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		respondError(c, http.StatusBadGateway, "domain_lookup_failed", "failed to look up the verification record")
+		return
+	}
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			found = true
+			break
+		}
+	}
+	if !found {
+		respondError(c, http.StatusConflict, "domain_verification_failed", "verification record not found")
+		return
+	}
+
+	if err := h.store.SetTenantDomainStatus(ctx, tenant.ID, domain.ID, store.TenantDomainStatusVerified); err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_domain_update_failed", "failed to verify tenant domain")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantDomainVerify,
+		TargetType: auditTargetTenantDomain,
+		TargetID:   domain.ID,
+		Before:     map[string]any{"status": domain.Status},
+		After:      map[string]any{"status": store.TenantDomainStatusVerified},
+		Metadata:   map[string]any{"domain": domain.Domain},
+	})
+	if domain, ok = h.loadTenantDomain(c, tenant.ID); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"domain": newTenantDomainResponse(domain)})
+}
+
+// updateTenantDomain makes a verified domain the tenant's primary one.
+func (h *handler) updateTenantDomain(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		IsPrimary *bool `json:"isPrimary"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	if payload.IsPrimary == nil || !*payload.IsPrimary {
+		respondError(c, http.StatusBadRequest, "invalid_request", "isPrimary must be true; make another domain primary instead")
+		return
+	}
+	domain, ok := h.loadTenantDomain(c, tenant.ID)
+	if !ok {
+		return
+	}
+	if domain.Status != store.TenantDomainStatusVerified {
+		respondError(c, http.StatusConflict, "tenant_domain_unverified", "only verified domains can be primary")
+		return
+	}
+
+	if !domain.IsPrimary {
+		ctx := c.Request.Context()
+		previous, err := h.primaryTenantDomain(ctx, tenant.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "tenant_domains_read_failed", "failed to list tenant domains")
+			return
+		}
+		if err := h.store.SetPrimaryTenantDomain(ctx, tenant.ID, domain.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, "tenant_domain_update_failed", "failed to update tenant domain")
+			return
+		}
+		h.recordAudit(c, actor, store.AuditEvent{
+			TenantID:   tenant.ID,
+			Action:     auditActionTenantDomainPrimary,
+			TargetType: auditTargetTenantDomain,
+			TargetID:   domain.ID,
+			Before:     map[string]any{"primary": previous},
+			After:      map[string]any{"primary": domain.Domain},
+		})
+		domain.IsPrimary = true
+	}
+	c.JSON(http.StatusOK, gin.H{"domain": newTenantDomainResponse(domain)})
+}
+
+// deleteTenantDomain removes a domain other than the primary one.
+func (h *handler) deleteTenantDomain(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+	domain, ok := h.loadTenantDomain(c, tenant.ID)
+	if !ok {
+		return
+	}
+	if domain.IsPrimary {
+		respondError(c, http.StatusConflict, "tenant_domain_primary", "make another domain primary before removing this one")
+		return
+	}
+	if err := h.store.DeleteTenantDomain(c.Request.Context(), tenant.ID, domain.ID); err != nil {
+		if errors.Is(err, store.ErrTenantDomainNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_domain_not_found", "tenant domain not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_domain_delete_failed", "failed to delete tenant domain")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantDomainRemove,
+		TargetType: auditTargetTenantDomain,
+		TargetID:   domain.ID,
+		Before:     map[string]any{"domain": domain.Domain, "status": domain.Status},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) listTenantMembers(c *gin.Context) {
+	_, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsRead)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	memberships, err := h.store.ListTenantMemberships(ctx, tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_members_read_failed", "failed to list tenant members")
+		return
+	}
+	responses := make([]tenantMemberResponse, 0, len(memberships))
+	for _, membership := range memberships {
+		user, err := h.store.GetUserByID(ctx, membership.UserID)
+		if err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				continue
+			}
+			respondError(c, http.StatusInternalServerError, "tenant_members_read_failed", "failed to load tenant member")
+			return
+		}
+		responses = append(responses, tenantMemberResponse{
+			UserID:     user.ID,
+			Email:      user.Email,
+			Name:       user.Name,
+			Role:       membership.Role,
+			ExternalID: membership.ExternalID,
+			CreatedAt:  membership.CreatedAt,
+			UpdatedAt:  membership.UpdatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"members": responses})
+}
+
+func (h *handler) loadTenantMembership(c *gin.Context, tenantID string) (*store.TenantMembership, bool) {
+	membership, err := h.store.GetTenantMembership(c.Request.Context(), tenantID, strings.TrimSpace(c.Param("userId")))
+	if err != nil {
+		if errors.Is(err, store.ErrTenantMembershipNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_member_not_found", "tenant member not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_member_read_failed", "failed to load tenant member")
+		return nil, false
+	}
+	return membership, true
+}
+
+// isLastTenantAdmin reports whether membership is the only admin left in its
+// tenant. Tenants keep at least one admin so they stay manageable without a
+// platform operator.
+func (h *handler) isLastTenantAdmin(ctx context.Context, membership *store.TenantMembership) (bool, error) {
+	if membership.Role != store.TenantMembershipRoleAdmin {
+		return false, nil
+	}
+	memberships, err := h.store.ListTenantMemberships(ctx, membership.TenantID)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range memberships {
+		if other.UserID != membership.UserID && other.Role == store.TenantMembershipRoleAdmin {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (h *handler) updateTenantMember(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	role, ok := parseTenantMembershipRole(payload.Role)
+	if !ok {
+		respondError(c, http.StatusBadRequest, "invalid_role", "role must be admin or user")
+		return
+	}
+	membership, ok := h.loadTenantMembership(c, tenant.ID)
+	if !ok {
+		return
+	}
+	if membership.Role == role {
+		c.JSON(http.StatusOK, gin.H{"member": gin.H{"userId": membership.UserID, "role": membership.Role}})
+		return
+	}
+
+	ctx := c.Request.Context()
+	last, err := h.isLastTenantAdmin(ctx, membership)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_members_read_failed", "failed to list tenant members")
+		return
+	}
+	if last {
+		respondError(c, http.StatusConflict, "last_tenant_admin", "a tenant must keep at least one admin")
+		return
+	}
+	previous := membership.Role
+	membership.Role = role
+	if err := h.store.UpsertTenantMembership(ctx, membership); err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_member_update_failed", "failed to update tenant member")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantMemberRole,
+		TargetType: auditTargetUser,
+		TargetID:   membership.UserID,
+		Before:     map[string]any{"role": previous},
+		After:      map[string]any{"role": membership.Role},
+	})
+	c.JSON(http.StatusOK, gin.H{"member": gin.H{"userId": membership.UserID, "role": membership.Role}})
+}
+
+// removeTenantMember takes a user out of the tenant. The account is kept but
+// loses the tenant's provisioned groups.
+func (h *handler) removeTenantMember(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+	membership, ok := h.loadTenantMembership(c, tenant.ID)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	last, err := h.isLastTenantAdmin(ctx, membership)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_members_read_failed", "failed to list tenant members")
+		return
+	}
+	if last {
+		respondError(c, http.StatusConflict, "last_tenant_admin", "a tenant must keep at least one admin")
+		return
+	}
+	groups, err := h.store.ListSCIMGroups(ctx, tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_member_delete_failed", "failed to load tenant groups")
+		return
+	}
+	if err := h.store.DeleteTenantMembership(ctx, tenant.ID, membership.UserID); err != nil {
+		if errors.Is(err, store.ErrTenantMembershipNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_member_not_found", "tenant member not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_member_delete_failed", "failed to remove tenant member")
+		return
+	}
+	h.dropTenantGroups(ctx, membership.UserID, groups)
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantMemberRemove,
+		TargetType: auditTargetUser,
+		TargetID:   membership.UserID,
+		Before:     map[string]any{"role": membership.Role},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) listTenantInvitations(c *gin.Context) {
+	_, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsRead)
+	if !ok {
+		return
+	}
+
+	invitations, err := h.store.ListTenantInvitations(c.Request.Context(), tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_invitations_read_failed", "failed to list tenant invitations")
+		return
+	}
+	now := time.Now().UTC()
+	responses := make([]tenantInvitationResponse, 0, len(invitations))
+	for i := range invitations {
+		responses = append(responses, newTenantInvitationResponse(&invitations[i], now))
+	}
+	c.JSON(http.StatusOK, gin.H{"invitations": responses})
+}
+
+// createTenantInvitation emails a single-use token that lets the owner of
+// the address join the tenant. Inviting an address again replaces its
+// pending invitation.
+func (h *handler) createTenantInvitation(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+	if email == "" || !strings.Contains(email, "@") {
+		respondError(c, http.StatusBadRequest, "invalid_email", "a valid email is required")
+		return
+	}
+	role := store.TenantMembershipRoleUser
+	if strings.TrimSpace(payload.Role) != "" {
+		if role, ok = parseTenantMembershipRole(payload.Role); !ok {
+			respondError(c, http.StatusBadRequest, "invalid_role", "role must be admin or user")
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	if user, err := h.store.GetUserByEmail(ctx, email); err == nil {
+		if _, err := h.store.GetTenantMembership(ctx, tenant.ID, user.ID); err == nil {
+			respondError(c, http.StatusConflict, "tenant_member_exists", "this user is already a member of the tenant")
+			return
+		}
+	}
+	existing, err := h.store.ListTenantInvitations(ctx, tenant.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_create_failed", "failed to list tenant invitations")
+		return
+	}
+
+	token, err := h.newRandomToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_create_failed", "failed to generate invitation token")
+		return
+	}
+	invitation := &store.TenantInvitation{
+		TenantID:  tenant.ID,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashTenantInvitationToken(token),
+		InvitedBy: actor.ID,
+		ExpiresAt: time.Now().UTC().Add(defaultTenantInvitationTTL),
+	}
+	if err := h.store.CreateTenantInvitation(ctx, invitation); err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_create_failed", "failed to create tenant invitation")
+		return
+	}
+	if err := h.sendTenantInvitationEmail(ctx, tenant, invitation, token); err != nil {
+		if deleteErr := h.store.DeleteTenantInvitation(ctx, tenant.ID, invitation.ID); deleteErr != nil {
+			slog.Warn("failed to discard unsent tenant invitation", "err", deleteErr, "invitationID", invitation.ID)
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_email_failed", "failed to send invitation email")
+		return
+	}
+	for _, previous := range existing {
+		if previous.Email == email && previous.AcceptedAt == nil {
+			if err := h.store.DeleteTenantInvitation(ctx, tenant.ID, previous.ID); err != nil && !errors.Is(err, store.ErrTenantInvitationNotFound) {
+				slog.Warn("failed to discard replaced tenant invitation", "err", err, "invitationID", previous.ID)
+			}
+		}
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantInvite,
+		TargetType: auditTargetTenantInvitation,
+		TargetID:   invitation.ID,
+		After:      map[string]any{"email": invitation.Email, "role": invitation.Role, "expiresAt": invitation.ExpiresAt},
+	})
+	c.JSON(http.StatusCreated, gin.H{"invitation": newTenantInvitationResponse(invitation, time.Now().UTC())})
+}
+
+func (h *handler) sendTenantInvitationEmail(ctx context.Context, tenant *store.Tenant, invitation *store.TenantInvitation, token string) error {
+	expires := invitation.ExpiresAt.UTC().Format(time.RFC3339)
+	subject := fmt.Sprintf("You're invited to join %s on XControl", tenant.Name)
+	plainBody := fmt.Sprintf("Hello,\n\nYou have been invited to join %s on XControl as %s. Sign in with this email address and accept the invitation with the following token: %s\n\nThis invitation expires at %s UTC.\nIf you were not expecting it you can ignore this email.\n", tenant.Name, invitation.Role, token, expires)
+	htmlBody := fmt.Sprintf("<p>Hello,</p><p>You have been invited to join <strong>%s</strong> on XControl as %s. Sign in with this email address and accept the invitation with the following token:</p><p><strong>%s</strong></p><p>This invitation expires at %s UTC.</p><p>If you were not expecting it you can ignore this email.</p>", html.EscapeString(tenant.Name), invitation.Role, token, expires)
+
+	return h.emailSender.Send(ctx, EmailMessage{
+		To:        []string{invitation.Email},
+		Subject:   subject,
+		PlainBody: plainBody,
+		HTMLBody:  htmlBody,
+	})
+}
+
+func (h *handler) deleteTenantInvitation(c *gin.Context) {
+	actor, tenant, ok := h.requireTenantManager(c, permissionAdminTenantsWrite)
+	if !ok {
+		return
+	}
+
+	invitationID := strings.TrimSpace(c.Param("invitationId"))
+	if err := h.store.DeleteTenantInvitation(c.Request.Context(), tenant.ID, invitationID); err != nil {
+		if errors.Is(err, store.ErrTenantInvitationNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_invitation_not_found", "tenant invitation not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_delete_failed", "failed to delete tenant invitation")
+		return
+	}
+	h.recordAudit(c, actor, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantInviteRevoke,
+		TargetType: auditTargetTenantInvitation,
+		TargetID:   invitationID,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// acceptTenantInvitation adds the signed-in user to the inviting tenant. The
+// user must own the invited address, with the email verified. A user who is
+// already a member keeps the higher of the two roles.
+func (h *handler) acceptTenantInvitation(c *gin.Context) {
+	user, ok := h.currentAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Token) == "" {
+		respondError(c, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	invitation, err := h.store.GetTenantInvitationByHash(ctx, hashTenantInvitationToken(strings.TrimSpace(payload.Token)))
+	if err != nil {
+		if errors.Is(err, store.ErrTenantInvitationNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_invitation_not_found", "invitation not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_read_failed", "failed to load invitation")
+		return
+	}
+	if invitation.AcceptedAt != nil {
+		respondError(c, http.StatusConflict, "tenant_invitation_accepted", "invitation has already been accepted")
+		return
+	}
+	if !time.Now().UTC().Before(invitation.ExpiresAt) {
+		respondError(c, http.StatusBadRequest, "tenant_invitation_expired", "invitation has expired")
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(user.Email), invitation.Email) {
+		respondError(c, http.StatusForbidden, "tenant_invitation_email_mismatch", "invitation was sent to another email address")
+		return
+	}
+	if !user.EmailVerified {
+		respondError(c, http.StatusForbidden, "email_not_verified", "verify your email address before accepting the invitation")
+		return
+	}
+	tenant, err := h.store.GetTenant(ctx, invitation.TenantID)
+	if err != nil {
+		if errors.Is(err, store.ErrTenantNotFound) {
+			respondError(c, http.StatusNotFound, "tenant_not_found", "tenant not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_lookup_failed", "failed to load tenant")
+		return
+	}
+
+	role := invitation.Role
+	if existing, err := h.store.GetTenantMembership(ctx, tenant.ID, user.ID); err == nil && existing.Role == store.TenantMembershipRoleAdmin {
+		role = store.TenantMembershipRoleAdmin
+	}
+	if err := h.store.MarkTenantInvitationAccepted(ctx, invitation.ID, time.Now().UTC()); err != nil {
+		if errors.Is(err, store.ErrTenantInvitationNotFound) {
+			respondError(c, http.StatusConflict, "tenant_invitation_accepted", "invitation has already been accepted")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "tenant_invitation_accept_failed", "failed to accept invitation")
+		return
+	}
+	if err := h.store.UpsertTenantMembership(ctx, &store.TenantMembership{
+		TenantID: tenant.ID,
+		UserID:   user.ID,
+		Role:     role,
+	}); err != nil {
+		respondError(c, http.StatusInternalServerError, "tenant_membership_create_failed", "failed to create tenant membership")
+		return
+	}
+	h.recordAudit(c, user, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantInviteAccept,
+		TargetType: auditTargetTenantInvitation,
+		TargetID:   invitation.ID,
+		After:      map[string]any{"role": role},
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"membership": gin.H{
+			"tenantId":   tenant.ID,
+			"tenantName": tenant.Name,
+			"role":       role,
+		},
+	})
+}
+
+// parseTenantMembershipRole accepts exactly the tenant roles, unlike
+// store.NormalizeTenantMembershipRole which maps anything unknown to user.
+func parseTenantMembershipRole(value string) (string, bool) {
+	switch role := strings.ToLower(strings.TrimSpace(value)); role {
+	case store.TenantMembershipRoleAdmin, store.TenantMembershipRoleUser:
+		return role, true
+	default:
+		return "", false
+	}
+}
+
+// normalizeCustomTenantDomain validates a hostname a tenant wants to bring.
+// The svc.plus namespace is reserved for the shared tenant and generated
+// domains.
+func normalizeCustomTenantDomain(value string) (string, bool) {
+	hostname := store.NormalizeHostname(value)
+	if len(hostname) > 253 || store.IsSharedTenantHost(hostname) || net.ParseIP(hostname) != nil {
+		return "", false
+	}
+	if hostname == store.SharedXWorkmateDomain || strings.HasSuffix(hostname, "."+store.SharedXWorkmateDomain) {
+		return "", false
+	}
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return "", false
+			}
+		}
+	}
+	return hostname, true
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/auth"
+	"account/internal/store"
+)
+
+func TestTenantManagementLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	newUser := func(name, email, role, token string) *store.User {
+		user := &store.User{
+			Name:          name,
+			Email:         email,
+			EmailVerified: true,
+			Role:          role,
+			Level:         store.LevelUser,
+			Active:        true,
+		}
+		if role == store.RoleAdmin {
+			user.Level = store.LevelAdmin
+		}
+		if err := st.CreateUser(ctx, user); err != nil {
+			t.Fatalf("create %s: %v", email, err)
+		}
+		if err := st.CreateSession(ctx, token, user.ID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("create session for %s: %v", email, err)
+		}
+		return user
+	}
+	newUser("Platform Admin", "platform-admin@example.com", store.RoleAdmin, "platform-admin-token")
+	tenantAdmin := newUser("Tenant Admin", "owner@acme.example", store.RoleUser, "tenant-admin-token")
+	invitee := newUser("Invitee", "invitee@acme.example", store.RoleUser, "invitee-token")
+	newUser("Outsider", "outsider@example.com", store.RoleUser, "outsider-token")
+
+	txtRecords := map[string][]string{}
+	sender := &testEmailSender{}
+	router := gin.New()
+	RegisterRoutes(
+		router,
+		WithStore(st),
+		WithEmailSender(sender),
+		WithTokenService(auth.NewTokenService(auth.TokenConfig{
+			PublicToken:   "public-token",
+			RefreshSecret: "refresh-secret",
+			AccessSecret:  "access-secret",
+			AccessExpiry:  time.Hour,
+			RefreshExpiry: time.Hour,
+			Store:         st,
+		})),
+		WithDomainTXTLookup(func(ctx context.Context, name string) ([]string, error) {
+			return txtRecords[name], nil
+		}),
+	)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, status int, what string) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("%s: expected %d, got %d: %s", what, status, rr.Code, rr.Body.String())
+		}
+	}
+	type domainPayload struct {
+		ID           string `json:"id"`
+		Domain       string `json:"domain"`
+		IsPrimary    bool   `json:"isPrimary"`
+		Status       string `json:"status"`
+		Verification *struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"verification"`
+	}
+
+	rr := do(http.MethodPost, "/api/admin/tenants", "platform-admin-token", `{"name":"Acme"}`)
+	expect(rr, http.StatusCreated, "create tenant")
+	var created struct {
+		Tenant struct {
+			ID     string `json:"id"`
+			Domain string `json:"domain"`
+		} `json:"tenant"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode tenant: %v", err)
+	}
+	tenantPath := "/api/admin/tenants/" + created.Tenant.ID
+	if !strings.HasSuffix(created.Tenant.Domain, ".svc.plus") {
+		t.Fatalf("expected a generated primary domain, got %q", created.Tenant.Domain)
+	}
+	if err := st.UpsertTenantMembership(ctx, &store.TenantMembership{TenantID: created.Tenant.ID, UserID: tenantAdmin.ID, Role: store.TenantMembershipRoleAdmin}); err != nil {
+		t.Fatalf("add tenant admin: %v", err)
+	}
+
+	expect(do(http.MethodGet, "/api/admin/tenants", "tenant-admin-token", ""), http.StatusForbidden, "tenant admin lists tenants")
+	expect(do(http.MethodGet, tenantPath+"/members", "outsider-token", ""), http.StatusForbidden, "outsider lists members")
+	expect(do(http.MethodGet, tenantPath, "tenant-admin-token", ""), http.StatusOK, "tenant admin reads tenant")
+	if rr := do(http.MethodGet, "/api/admin/tenants", "platform-admin-token", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), created.Tenant.ID) {
+		t.Fatalf("expected tenant in list, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Custom domains stay pending until their TXT record is published.
+	expect(do(http.MethodPost, tenantPath+"/domains", "tenant-admin-token", `{"domain":"evil.svc.plus"}`), http.StatusBadRequest, "reserved domain")
+	rr = do(http.MethodPost, tenantPath+"/domains", "tenant-admin-token", `{"domain":"Login.Acme.example"}`)
+	expect(rr, http.StatusCreated, "add domain")
+	var added struct {
+		Domain domainPayload `json:"domain"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &added); err != nil {
+		t.Fatalf("decode domain: %v", err)
+	}
+	if added.Domain.Domain != "login.acme.example" || added.Domain.Status != store.TenantDomainStatusPending || added.Domain.Verification == nil {
+		t.Fatalf("expected pending domain with a challenge, got %+v", added.Domain)
+	}
+	domainPath := tenantPath + "/domains/" + added.Domain.ID
+	expect(do(http.MethodPost, tenantPath+"/domains", "tenant-admin-token", `{"domain":"login.acme.example"}`), http.StatusConflict, "duplicate domain")
+	expect(do(http.MethodPatch, domainPath, "tenant-admin-token", `{"isPrimary":true}`), http.StatusConflict, "primary before verification")
+	expect(do(http.MethodPost, domainPath+"/verify", "tenant-admin-token", ""), http.StatusConflict, "verify without record")
+
+	txtRecords[added.Domain.Verification.Name] = []string{"unrelated", added.Domain.Verification.Value}
+	rr = do(http.MethodPost, domainPath+"/verify", "tenant-admin-token", "")
+	expect(rr, http.StatusOK, "verify domain")
+	if !strings.Contains(rr.Body.String(), `"status":"verified"`) || strings.Contains(rr.Body.String(), "verification") {
+		t.Fatalf("expected verified domain without a challenge, got %s", rr.Body.String())
+	}
+	if resolved, _, err := st.ResolveTenantByHost(ctx, "login.acme.example"); err != nil || resolved.ID != created.Tenant.ID {
+		t.Fatalf("expected verified domain to resolve, got %v %v", resolved, err)
+	}
+	expect(do(http.MethodPatch, domainPath, "tenant-admin-token", `{"isPrimary":true}`), http.StatusOK, "make domain primary")
+	expect(do(http.MethodDelete, domainPath, "tenant-admin-token", ""), http.StatusConflict, "delete primary domain")
+
+	rr = do(http.MethodGet, tenantPath+"/domains", "tenant-admin-token", "")
+	expect(rr, http.StatusOK, "list domains")
+	var listed struct {
+		Domains []domainPayload `json:"domains"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode domains: %v", err)
+	}
+	if len(listed.Domains) != 2 || listed.Domains[0].ID != added.Domain.ID || listed.Domains[1].IsPrimary {
+		t.Fatalf("expected the custom domain to be the only primary, got %+v", listed.Domains)
+	}
+	expect(do(http.MethodDelete, tenantPath+"/domains/"+listed.Domains[1].ID, "tenant-admin-token", ""), http.StatusNoContent, "delete generated domain")
+
+	// Invitations are emailed and bound to the invited address.
+	expect(do(http.MethodPost, tenantPath+"/invitations", "tenant-admin-token", `{"email":"invitee@acme.example","role":"owner"}`), http.StatusBadRequest, "unknown role")
+	expect(do(http.MethodPost, tenantPath+"/invitations", "tenant-admin-token", `{"email":"owner@acme.example"}`), http.StatusConflict, "invite existing member")
+	expect(do(http.MethodPost, tenantPath+"/invitations", "tenant-admin-token", `{"email":"Invitee@Acme.example"}`), http.StatusCreated, "invite")
+	first, _ := sender.last()
+	expect(do(http.MethodPost, tenantPath+"/invitations", "tenant-admin-token", `{"email":"invitee@acme.example","role":"admin"}`), http.StatusCreated, "invite again")
+	msg, ok := sender.last()
+	if !ok || len(msg.To) != 1 || msg.To[0] != "invitee@acme.example" || !strings.Contains(msg.Subject, "Acme") {
+		t.Fatalf("expected invitation email, got %+v", msg)
+	}
+	token := extractTokenFromMessage(t, msg)
+
+	expect(do(http.MethodPost, "/api/auth/tenant-invitations/accept", "invitee-token", `{"token":"`+extractTokenFromMessage(t, first)+`"}`), http.StatusNotFound, "accept replaced invitation")
+	expect(do(http.MethodPost, "/api/auth/tenant-invitations/accept", "outsider-token", `{"token":"`+token+`"}`), http.StatusForbidden, "accept as another user")
+	rr = do(http.MethodPost, "/api/auth/tenant-invitations/accept", "invitee-token", `{"token":"`+token+`"}`)
+	expect(rr, http.StatusOK, "accept invitation")
+	if !strings.Contains(rr.Body.String(), `"role":"admin"`) {
+		t.Fatalf("expected admin membership, got %s", rr.Body.String())
+	}
+	expect(do(http.MethodPost, "/api/auth/tenant-invitations/accept", "invitee-token", `{"token":"`+token+`"}`), http.StatusConflict, "accept twice")
+	if rr := do(http.MethodGet, tenantPath+"/invitations", "tenant-admin-token", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"accepted"`) {
+		t.Fatalf("expected accepted invitation in list, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Roles change freely as long as an admin remains.
+	rr = do(http.MethodGet, tenantPath+"/members", "invitee-token", "")
+	expect(rr, http.StatusOK, "new admin lists members")
+	if !strings.Contains(rr.Body.String(), "invitee@acme.example") || !strings.Contains(rr.Body.String(), "owner@acme.example") {
+		t.Fatalf("expected both members, got %s", rr.Body.String())
+	}
+	expect(do(http.MethodPatch, tenantPath+"/members/"+invitee.ID, "tenant-admin-token", `{"role":"user"}`), http.StatusOK, "demote invitee")
+	expect(do(http.MethodPatch, tenantPath+"/members/"+tenantAdmin.ID, "tenant-admin-token", `{"role":"user"}`), http.StatusConflict, "demote last admin")
+	expect(do(http.MethodDelete, tenantPath+"/members/"+tenantAdmin.ID, "tenant-admin-token", ""), http.StatusConflict, "remove last admin")
+	expect(do(http.MethodDelete, tenantPath+"/members/"+invitee.ID, "tenant-admin-token", ""), http.StatusNoContent, "remove member")
+	if _, err := st.GetTenantMembership(ctx, created.Tenant.ID, invitee.ID); err == nil {
+		t.Fatalf("expected membership to be removed")
+	}
+	expect(do(http.MethodGet, tenantPath+"/members", "invitee-token", ""), http.StatusForbidden, "removed member lists members")
+
+	// Deleting is reserved to platform administrators and spares the shared tenant.
+	expect(do(http.MethodDelete, tenantPath, "tenant-admin-token", ""), http.StatusForbidden, "tenant admin deletes tenant")
+	expect(do(http.MethodDelete, tenantPath, "platform-admin-token", ""), http.StatusNoContent, "delete tenant")
+	expect(do(http.MethodGet, tenantPath, "platform-admin-token", ""), http.StatusNotFound, "read deleted tenant")
+	if _, _, err := st.ResolveTenantByHost(ctx, "login.acme.example"); err == nil {
+		t.Fatalf("expected deleted tenant domain to stop resolving")
+	}
+	if err := st.EnsureTenant(ctx, &store.Tenant{ID: store.SharedXWorkmateTenantID, Name: store.SharedXWorkmateTenantName, Edition: store.SharedPublicTenantEdition}); err != nil {
+		t.Fatalf("ensure shared tenant: %v", err)
+	}
+	expect(do(http.MethodDelete, "/api/admin/tenants/"+store.SharedXWorkmateTenantID, "platform-admin-token", ""), http.StatusConflict, "delete shared tenant")
+}
@@ -221,7 +221,7 @@ func (h *handler) resolveAgentNodeUser(c *gin.Context) (*store.User, bool) {
 	if token == "" {
 		// Console Guest/Demo path: allow the trusted Console BFF to resolve the
 		// sandbox user without requiring an end-user session.
-		if isInternalServiceRequest(c) {
+		if h.isInternalServiceRequest(c) {
 			sandboxUser, err := h.store.GetUserByEmail(c.Request.Context(), sandboxUserEmail)
 			if err != nil {
 				if errors.Is(err, store.ErrUserNotFound) {
@@ -0,0 +1,555 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"account/internal/store"
+)
+
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodWebAuthn = "webauthn"
+
+	// webauthnCeremonyTTL bounds how long a registration or passkey login
+	// may take between its begin and finish calls.
+	webauthnCeremonyTTL        = 5 * time.Minute
+	maxWebAuthnCredentialName  = 64
+	defaultWebAuthnDisplayName = "Cloud-Neutral Toolkit"
+)
+
+// WebAuthnConfig configures passkeys. RPID is the domain the credentials are
+// scoped to and RPOrigins lists the frontend origins allowed to use them.
+type WebAuthnConfig struct {
+	RPID          string
+	RPDisplayName string
+	RPOrigins     []string
+}
+
+// WithWebAuthn enables passkey registration, passkey second factors and
+// passwordless passkey login.
+func WithWebAuthn(cfg WebAuthnConfig) Option {
+	return func(h *handler) {
+		displayName := strings.TrimSpace(cfg.RPDisplayName)
+		if displayName == "" {
+			displayName = defaultWebAuthnDisplayName
+		}
+		relyingParty, err := webauthn.New(&webauthn.Config{
+			RPID:          strings.TrimSpace(cfg.RPID),
+			RPDisplayName: displayName,
+			RPOrigins:     cfg.RPOrigins,
+		})
+		if err != nil {
+			slog.Warn("webauthn disabled: invalid relying party configuration", "err", err)
+			h.webauthn = nil
+			return
+		}
+		h.webauthn = relyingParty
+	}
+}
+
+// webauthnCeremony keeps the server side of a registration or passkey login
+// between its begin and finish calls.
+type webauthnCeremony struct {
+	UserID    string               `json:"userId,omitempty"`
+	Name      string               `json:"name,omitempty"`
+	Session   webauthn.SessionData `json:"session"`
+	ExpiresAt time.Time            `json:"expiresAt"`
+}
+
+// webauthnUser adapts a store user and its credentials to webauthn.User. The
+// user handle is the account ID.
+type webauthnUser struct {
+	user        *store.User
+	credentials []store.WebAuthnCredential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	if email := strings.TrimSpace(u.user.Email); email != "" {
+		return email
+	}
+	return u.user.Name
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	if name := strings.TrimSpace(u.user.Name); name != "" {
+		return name
+	}
+	return u.WebAuthnName()
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		id, err := base64.RawURLEncoding.DecodeString(stored.ID)
+		if err != nil {
+			continue
+		}
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, transport := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    stored.AAGUID,
+				SignCount: stored.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+type webauthnCredentialResponse struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	Synced     bool       `json:"synced"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func newWebAuthnCredentialResponses(credentials []store.WebAuthnCredential) []webauthnCredentialResponse {
+	responses := make([]webauthnCredentialResponse, 0, len(credentials))
+	for _, credential := range credentials {
+		transports := credential.Transports
+		if transports == nil {
+			transports = []string{}
+		}
+		responses = append(responses, webauthnCredentialResponse{
+			Type:       mfaMethodWebAuthn,
+			ID:         credential.ID,
+			Name:       credential.Name,
+			Transports: transports,
+			Synced:     credential.BackupState,
+			CreatedAt:  credential.CreatedAt.UTC(),
+			LastUsedAt: credential.LastUsedAt,
+		})
+	}
+	return responses
+}
+
+func (h *handler) requireWebAuthn(c *gin.Context) bool {
+	if h.webauthn == nil {
+		respondError(c, http.StatusServiceUnavailable, "webauthn_unavailable", "passkeys are not configured")
+		return false
+	}
+	return true
+}
+
+func (h *handler) loadWebAuthnUser(ctx context.Context, user *store.User) (*webauthnUser, error) {
+	credentials, err := h.store.ListWebAuthnCredentialsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+// mfaMethods lists the second factors enrolled by user. A user with any
+// method must complete an MFA challenge after the password step.
+func (h *handler) mfaMethods(ctx context.Context, user *store.User) []string {
+	methods := make([]string, 0, 2)
+	if user.MFAEnabled {
+		methods = append(methods, mfaMethodTOTP)
+	}
+	credentials, err := h.store.ListWebAuthnCredentialsByUser(ctx, user.ID)
+	if err != nil {
+		slog.Warn("failed to list webauthn credentials", "err", err, "userID", user.ID)
+	} else if len(credentials) > 0 {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+	return methods
+}
+
+// listMFAAuthenticators describes the TOTP authenticator, when confirmed,
+// followed by the user's passkeys.
+func (h *handler) listMFAAuthenticators(ctx context.Context, user *store.User) ([]any, error) {
+	credentials, err := h.store.ListWebAuthnCredentialsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	authenticators := make([]any, 0, len(credentials)+1)
+	if user.MFAEnabled {
+		totpAuthenticator := gin.H{"type": mfaMethodTOTP}
+		if !user.MFAConfirmedAt.IsZero() {
+			totpAuthenticator["createdAt"] = user.MFAConfirmedAt.UTC()
+		}
+		authenticators = append(authenticators, totpAuthenticator)
+	}
+	for _, credential := range newWebAuthnCredentialResponses(credentials) {
+		authenticators = append(authenticators, credential)
+	}
+	return authenticators, nil
+}
+
+func (h *handler) startWebAuthnCeremony(ceremony webauthnCeremony) (string, error) {
+	id, err := h.newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	ceremony.ExpiresAt = time.Now().Add(webauthnCeremonyTTL)
+	if err := h.putAuthState(authStateWebAuthnCeremonies, id, ceremony, ceremony.ExpiresAt); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (h *handler) takeWebAuthnCeremony(id string) (webauthnCeremony, bool) {
+	var ceremony webauthnCeremony
+	if strings.TrimSpace(id) == "" || !h.takeAuthState(authStateWebAuthnCeremonies, id, &ceremony) {
+		return webauthnCeremony{}, false
+	}
+	if time.Now().After(ceremony.ExpiresAt) {
+		return webauthnCeremony{}, false
+	}
+	return ceremony, true
+}
+
+// recordWebAuthnAssertion persists the counter from a validated assertion.
+// A counter that did not advance means the credential may have been cloned,
+// so the assertion is rejected.
+func (h *handler) recordWebAuthnAssertion(ctx context.Context, userID string, credential *webauthn.Credential) error {
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	if credential.Authenticator.CloneWarning {
+		slog.Warn("webauthn signature counter did not advance; possible cloned authenticator", "userID", userID, "credentialID", id)
+		return errors.New("webauthn signature counter did not advance")
+	}
+	return h.store.UpdateWebAuthnCredentialUsage(ctx, id, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now())
+}
+
+func (h *handler) beginWebAuthnRegistration(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	if h.isReadOnlyAccount(user) {
+		respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+			return
+		}
+	}
+	name := strings.TrimSpace(req.Name)
+	if len(name) > maxWebAuthnCredentialName {
+		name = name[:maxWebAuthnCredentialName]
+	}
+
+	ctx := c.Request.Context()
+	waUser, err := h.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_registration_failed", "failed to load passkeys")
+		return
+	}
+	creation, session, err := h.webauthn.BeginRegistration(waUser,
+		webauthn.WithExclusions(webauthn.Credentials(waUser.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_registration_failed", "failed to start passkey registration")
+		return
+	}
+	ceremonyID, err := h.startWebAuthnCeremony(webauthnCeremony{UserID: user.ID, Name: name, Session: *session})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_registration_failed", "failed to start passkey registration")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ceremonyId": ceremonyID, "options": creation})
+}
+
+func (h *handler) finishWebAuthnRegistration(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CeremonyID string          `json:"ceremonyId"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Credential) == 0 {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	ceremony, ok := h.takeWebAuthnCeremony(req.CeremonyID)
+	if !ok || ceremony.UserID != user.ID {
+		respondError(c, http.StatusBadRequest, "invalid_webauthn_ceremony", "passkey registration is invalid or expired")
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_webauthn_credential", "invalid passkey registration response")
+		return
+	}
+	ctx := c.Request.Context()
+	waUser, err := h.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_registration_failed", "failed to load passkeys")
+		return
+	}
+	credential, err := h.webauthn.CreateCredential(waUser, ceremony.Session, parsed)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_webauthn_credential", "passkey registration could not be verified")
+		return
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	name := ceremony.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	stored := &store.WebAuthnCredential{
+		ID:              base64.RawURLEncoding.EncodeToString(credential.ID),
+		UserID:          user.ID,
+		Name:            name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if err := h.store.CreateWebAuthnCredential(ctx, stored); err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialExists) {
+			respondError(c, http.StatusConflict, "webauthn_credential_exists", "passkey is already registered")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "webauthn_registration_failed", "failed to save passkey")
+		return
+	}
+	h.recordUserAudit(c, user, auditActionPasskeyAdded, map[string]any{"credentialId": stored.ID, "name": stored.Name})
+	c.JSON(http.StatusCreated, gin.H{"credential": newWebAuthnCredentialResponses([]store.WebAuthnCredential{*stored})[0]})
+}
+
+func (h *handler) deleteWebAuthnCredential(c *gin.Context) {
+	user, ok := h.requireAuthenticatedUser(c)
+	if !ok {
+		return
+	}
+	if h.isReadOnlyAccount(user) {
+		respondError(c, http.StatusForbidden, "read_only_account", "demo account is read-only")
+		return
+	}
+
+	ctx := c.Request.Context()
+	credentialID := strings.TrimSpace(c.Param("id"))
+	if err := h.store.DeleteWebAuthnCredential(ctx, user.ID, credentialID); err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialNotFound) {
+			respondError(c, http.StatusNotFound, "webauthn_credential_not_found", "passkey not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "webauthn_credential_delete_failed", "failed to delete passkey")
+		return
+	}
+	h.dropUnusedMFARecoveryCodes(ctx, user)
+	h.recordUserAudit(c, user, auditActionPasskeyRemoved, map[string]any{"credentialId": credentialID})
+	c.Status(http.StatusNoContent)
+}
+
+// beginWebAuthnAssertion issues assertion options for a pending MFA
+// challenge. The ceremony is kept on the challenge so /mfa/verify can accept
+// the passkey in place of a TOTP code.
+func (h *handler) beginWebAuthnAssertion(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	var req struct {
+		MFATicket string `json:"mfa_ticket"`
+		MFAToken  string `json:"mfaToken"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	mfaTicket := strings.TrimSpace(req.MFATicket)
+	if mfaTicket == "" {
+		mfaTicket = strings.TrimSpace(req.MFAToken)
+	}
+	challenge, ok := h.lookupMFAChallenge(mfaTicket)
+	if mfaTicket == "" || !ok {
+		respondError(c, http.StatusUnauthorized, "invalid_mfa_ticket", "mfa ticket is invalid or expired")
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.store.GetUserByID(ctx, challenge.userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to authenticate user")
+		return
+	}
+	waUser, err := h.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "authentication_failed", "failed to load passkeys")
+		return
+	}
+	if len(waUser.credentials) == 0 {
+		respondError(c, http.StatusBadRequest, "webauthn_not_enrolled", "no passkeys are registered for this account")
+		return
+	}
+	assertion, session, err := h.webauthn.BeginLogin(waUser)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_assertion_failed", "failed to start passkey verification")
+		return
+	}
+	if _, ok := h.updateMFAChallenge(mfaTicket, func(ch *mfaChallenge) bool {
+		ch.webauthnSession = session
+		return true
+	}); !ok {
+		respondError(c, http.StatusUnauthorized, "invalid_mfa_ticket", "mfa ticket is invalid or expired")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"options": assertion})
+}
+
+// verifyWebAuthnAssertion validates a passkey answer to the MFA challenge
+// created by beginWebAuthnAssertion.
+func (h *handler) verifyWebAuthnAssertion(ctx context.Context, user *store.User, challenge mfaChallenge, response []byte) error {
+	if h.webauthn == nil || challenge.webauthnSession == nil {
+		return errors.New("no passkey verification is pending")
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return err
+	}
+	waUser, err := h.loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	credential, err := h.webauthn.ValidateLogin(waUser, *challenge.webauthnSession, parsed)
+	if err != nil {
+		return err
+	}
+	return h.recordWebAuthnAssertion(ctx, user.ID, credential)
+}
+
+func (h *handler) beginPasskeyLogin(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	assertion, session, err := h.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_assertion_failed", "failed to start passkey login")
+		return
+	}
+	ceremonyID, err := h.startWebAuthnCeremony(webauthnCeremony{Session: *session})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "webauthn_assertion_failed", "failed to start passkey login")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ceremonyId": ceremonyID, "options": assertion})
+}
+
+// finishPasskeyLogin signs a user in with a discoverable passkey. The
+// passkey proves possession and user verification, so no MFA challenge
+// follows.
+func (h *handler) finishPasskeyLogin(c *gin.Context) {
+	if !h.requireWebAuthn(c) {
+		return
+	}
+	var req struct {
+		CeremonyID string          `json:"ceremonyId"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Credential) == 0 {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	ceremony, ok := h.takeWebAuthnCeremony(req.CeremonyID)
+	if !ok || ceremony.UserID != "" {
+		respondError(c, http.StatusBadRequest, "invalid_webauthn_ceremony", "passkey login is invalid or expired")
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_webauthn_credential", "invalid passkey response")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var found *webauthnUser
+	_, credential, err := h.webauthn.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		user, err := h.store.GetUserByID(ctx, string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		found, err = h.loadWebAuthnUser(ctx, user)
+		return found, err
+	}, ceremony.Session, parsed)
+	if err != nil || found == nil {
+		respondError(c, http.StatusUnauthorized, "invalid_webauthn_credential", "passkey could not be verified")
+		return
+	}
+	user := found.user
+	if strings.EqualFold(strings.TrimSpace(user.Email), sandboxUserEmail) {
+		respondError(c, http.StatusForbidden, "sandbox_no_login", "sandbox login is disabled")
+		return
+	}
+	if err := h.recordWebAuthnAssertion(ctx, user.ID, credential); err != nil {
+		respondError(c, http.StatusUnauthorized, "invalid_webauthn_credential", "passkey could not be verified")
+		return
+	}
+	if !user.Active {
+		respondError(c, http.StatusForbidden, "account_suspended", "your account has been suspended")
+		return
+	}
+	if strings.TrimSpace(user.Email) != "" && !user.EmailVerified {
+		respondError(c, http.StatusUnauthorized, "email_not_verified", "email must be verified before login")
+		return
+	}
+
+	token, expiresAt, err := h.createSession(c, user.ID, sessionAuthPasskey)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "session_creation_failed", "failed to create session")
+		return
+	}
+	h.setSessionCookie(c, token, expiresAt)
+	c.JSON(http.StatusOK, h.attachRefreshToken(ctx, gin.H{
+		"message":      "login successful",
+		"token":        token,
+		"access_token": token,
+		"expiresAt":    expiresAt.UTC(),
+		"expires_in":   int64(time.Until(expiresAt).Seconds()),
+		"mfaRequired":  false,
+		"mfa_required": false,
+		"user":         sanitizeUser(user, nil),
+	}, user))
+}
@@ -0,0 +1,606 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"account/internal/store"
+	"account/internal/webhook"
+)
+
+const (
+	webhookSubjectUser         = "user"
+	webhookSubjectSubscription = "subscription"
+
+	webhookSecretPrefix = "whsec_"
+
+	defaultWebhookListSize = 50
+	maxWebhookListSize     = 200
+
+	outboxAppendAttempts = 3
+	outboxAppendBackoff  = 100 * time.Millisecond
+)
+
+// publishEvent appends a lifecycle event to the outbox for webhook delivery.
+// It is written once the change has been made, so a failed append is retried
+// a few times before the error is returned. Callers then fail the request
+// rather than drop the event silently; creations roll the new record back.
+func (h *handler) publishEvent(ctx context.Context, eventType, subjectType, subjectID string, data map[string]any) error {
+	var err error
+	for attempt := range outboxAppendAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * outboxAppendBackoff):
+			}
+		}
+		event := &store.OutboxEvent{
+			Type:        eventType,
+			SubjectType: subjectType,
+			SubjectID:   subjectID,
+			Data:        data,
+		}
+		if err = h.store.AppendOutboxEvent(ctx, event); err == nil {
+			return nil
+		}
+	}
+	slog.Error("failed to publish lifecycle event", "err", err, "type", eventType, "subjectID", subjectID)
+	return err
+}
+
+// respondEventPublishFailed reports a change that was saved but could not be
+// announced.
+func respondEventPublishFailed(c *gin.Context) {
+	respondError(c, http.StatusInternalServerError, "event_publish_failed", "the change was saved but its event could not be published")
+}
+
+// rollbackCreatedUser deletes a user whose creation could not be announced,
+// so the request can be retried.
+func (h *handler) rollbackCreatedUser(ctx context.Context, user *store.User) {
+	if err := h.store.DeleteUser(ctx, user.ID); err != nil {
+		slog.Warn("failed to roll back unannounced user", "err", err, "userID", user.ID)
+	}
+}
+
+// publishUserEvent publishes eventType about user, merging extra into the
+// user snapshot.
+func (h *handler) publishUserEvent(ctx context.Context, eventType string, user *store.User, extra map[string]any) error {
+	if user == nil {
+		return nil
+	}
+	data := webhookUserData(user)
+	maps.Copy(data, extra)
+	return h.publishEvent(ctx, eventType, webhookSubjectUser, user.ID, data)
+}
+
+// publishUserCreated announces a new user, followed by user.verified when
+// the account starts out with a verified email.
+func (h *handler) publishUserCreated(ctx context.Context, user *store.User) error {
+	if err := h.publishUserEvent(ctx, webhook.EventUserCreated, user, nil); err != nil {
+		return err
+	}
+	if user != nil && user.EmailVerified {
+		return h.publishUserEvent(ctx, webhook.EventUserVerified, user, nil)
+	}
+	return nil
+}
+
+// publishRoleChange announces a role change; before is the audit snapshot
+// taken ahead of the update.
+func (h *handler) publishRoleChange(ctx context.Context, user *store.User, before map[string]any) error {
+	if before["role"] == user.Role {
+		return nil
+	}
+	return h.publishUserEvent(ctx, webhook.EventUserRoleChanged, user, map[string]any{"previousRole": before["role"]})
+}
+
+// upsertSubscriptionAndPublish stores a subscription change received from a
+// billing provider and announces it. Both steps are safe to repeat, so an
+// error makes the provider deliver the change again.
+func (h *handler) upsertSubscriptionAndPublish(ctx context.Context, subscription *store.Subscription) error {
+	if err := h.store.UpsertSubscription(ctx, subscription); err != nil {
+		return err
+	}
+	return h.publishSubscriptionEvent(ctx, subscription)
+}
+
+// publishSubscriptionEvent announces that a subscription was created or
+// changed.
+func (h *handler) publishSubscriptionEvent(ctx context.Context, subscription *store.Subscription) error {
+	if subscription == nil {
+		return nil
+	}
+	data := map[string]any{
+		"id":         subscription.ID,
+		"userId":     subscription.UserID,
+		"provider":   subscription.Provider,
+		"kind":       subscription.Kind,
+		"planId":     subscription.PlanID,
+		"externalId": subscription.ExternalID,
+		"status":     subscription.Status,
+	}
+	if subscription.CancelledAt != nil {
+		data["cancelledAt"] = subscription.CancelledAt.UTC()
+	}
+	return h.publishEvent(ctx, webhook.EventSubscriptionUpdated, webhookSubjectSubscription, subscription.ID, data)
+}
+
+// webhookUserData snapshots the user fields downstream systems track. As in
+// the audit log, the proxy UUID is a credential and only its expiry is sent.
+func webhookUserData(user *store.User) map[string]any {
+	data := map[string]any{
+		"id":            user.ID,
+		"email":         user.Email,
+		"name":          user.Name,
+		"role":          user.Role,
+		"level":         user.Level,
+		"groups":        append([]string{}, user.Groups...),
+		"active":        user.Active,
+		"emailVerified": user.EmailVerified,
+	}
+	if user.ProxyUUIDExpiresAt != nil {
+		data["proxyUuidExpiresAt"] = user.ProxyUUIDExpiresAt.UTC()
+	}
+	return data
+}
+
+type webhookRequest struct {
+	URL         *string   `json:"url"`
+	Description *string   `json:"description"`
+	EventTypes  *[]string `json:"eventTypes"`
+	Active      *bool     `json:"active"`
+	// RotateSecret replaces the signing secret on update.
+	RotateSecret bool `json:"rotateSecret"`
+}
+
+type webhookResponse struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	EventTypes  []string  `json:"eventTypes"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func newWebhookResponse(subscription *store.WebhookSubscription) webhookResponse {
+	return webhookResponse{
+		ID:          subscription.ID,
+		URL:         subscription.URL,
+		Description: subscription.Description,
+		EventTypes:  append([]string{}, subscription.EventTypes...),
+		Active:      subscription.Active,
+		CreatedAt:   subscription.CreatedAt.UTC(),
+		UpdatedAt:   subscription.UpdatedAt.UTC(),
+	}
+}
+
+type webhookDeliveryResponse struct {
+	ID             string     `json:"id"`
+	EventID        string     `json:"eventId"`
+	SubscriptionID string     `json:"subscriptionId"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt,omitempty"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func newWebhookDeliveryResponses(deliveries []store.WebhookDelivery) []webhookDeliveryResponse {
+	responses := make([]webhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response := webhookDeliveryResponse{
+			ID:             delivery.ID,
+			EventID:        delivery.EventID,
+			SubscriptionID: delivery.SubscriptionID,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastAttemptAt:  delivery.LastAttemptAt,
+			ResponseStatus: delivery.ResponseStatus,
+			LastError:      delivery.LastError,
+			DeliveredAt:    delivery.DeliveredAt,
+			CreatedAt:      delivery.CreatedAt.UTC(),
+		}
+		if delivery.Status == store.WebhookDeliveryPending {
+			next := delivery.NextAttemptAt.UTC()
+			response.NextAttemptAt = &next
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+type outboxEventResponse struct {
+	ID          string         `json:"id"`
+	Type        string         `json:"type"`
+	SubjectType string         `json:"subjectType"`
+	SubjectID   string         `json:"subjectId"`
+	Data        map[string]any `json:"data"`
+	CreatedAt   time.Time      `json:"createdAt"`
+}
+
+// WithWebhookNetworks lets webhook subscriptions reach the given private,
+// loopback or link-local networks, which are refused by default.
+func WithWebhookNetworks(networks []netip.Prefix) Option {
+	return func(h *handler) {
+		h.webhookNetworks = networks
+	}
+}
+
+// validWebhookURL accepts absolute http and https URLs without a fragment.
+// Plain http is allowed for subscribers on allowed internal networks. Hosts
+// given as addresses, and localhost, are checked against the networks here;
+// names are checked by the dispatcher once resolved.
+func (h *handler) validWebhookURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" || parsed.Fragment != "" {
+		return false
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return webhook.AddressAllowed(netip.AddrFrom4([4]byte{127, 0, 0, 1}), h.webhookNetworks) ||
+			webhook.AddressAllowed(netip.IPv6Loopback(), h.webhookNetworks)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return webhook.AddressAllowed(addr, h.webhookNetworks)
+	}
+	return true
+}
+
+// applyWebhookRequest copies the fields set in req onto subscription.
+func (h *handler) applyWebhookRequest(c *gin.Context, req webhookRequest, subscription *store.WebhookSubscription) bool {
+	if req.URL != nil {
+		subscription.URL = strings.TrimSpace(*req.URL)
+	}
+	if !h.validWebhookURL(subscription.URL) {
+		respondError(c, http.StatusBadRequest, "invalid_url", "url must be an absolute http or https url on an allowed network")
+		return false
+	}
+	if req.Description != nil {
+		subscription.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.EventTypes != nil {
+		for _, eventType := range *req.EventTypes {
+			if !slices.Contains(webhook.EventTypes, strings.TrimSpace(eventType)) {
+				respondError(c, http.StatusBadRequest, "invalid_event_type", "unsupported event type "+eventType)
+				return false
+			}
+		}
+		subscription.EventTypes = *req.EventTypes
+	}
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+	return true
+}
+
+func (h *handler) newWebhookSecret() (string, error) {
+	token, err := h.newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + token, nil
+}
+
+// parseWebhookListLimit reads the limit query parameter.
+func parseWebhookListLimit(c *gin.Context) (int, bool) {
+	raw := strings.TrimSpace(c.Query("limit"))
+	if raw == "" {
+		return defaultWebhookListSize, true
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed <= 0 {
+		respondError(c, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+		return 0, false
+	}
+	return min(parsed, maxWebhookListSize), true
+}
+
+func (h *handler) listWebhooks(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminWebhooksRead); !ok {
+		return
+	}
+
+	subscriptions, err := h.store.ListWebhookSubscriptions(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_webhooks_failed", "failed to list webhooks")
+		return
+	}
+	responses := make([]webhookResponse, 0, len(subscriptions))
+	for i := range subscriptions {
+		responses = append(responses, newWebhookResponse(&subscriptions[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": responses, "eventTypes": webhook.EventTypes})
+}
+
+// createWebhook registers an endpoint. The signing secret is only returned in
+// this response and when it is rotated.
+func (h *handler) createWebhook(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminWebhooksWrite)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	subscription := &store.WebhookSubscription{Active: true}
+	if !h.applyWebhookRequest(c, req, subscription) {
+		return
+	}
+	secret, err := h.newWebhookSecret()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "create_webhook_failed", "failed to generate webhook secret")
+		return
+	}
+	subscription.Secret = secret
+
+	if err := h.store.CreateWebhookSubscription(c.Request.Context(), subscription); err != nil {
+		respondError(c, http.StatusInternalServerError, "create_webhook_failed", "failed to create webhook")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionWebhookCreate,
+		TargetType: auditTargetWebhook,
+		TargetID:   subscription.ID,
+		After:      auditWebhookFields(subscription),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"webhook": newWebhookResponse(subscription), "secret": secret})
+}
+
+func (h *handler) updateWebhook(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminWebhooksWrite)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+		return
+	}
+	subscription, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+	before := auditWebhookFields(subscription)
+	if !h.applyWebhookRequest(c, req, subscription) {
+		return
+	}
+	var secret string
+	if req.RotateSecret {
+		var err error
+		if secret, err = h.newWebhookSecret(); err != nil {
+			respondError(c, http.StatusInternalServerError, "update_webhook_failed", "failed to generate webhook secret")
+			return
+		}
+		subscription.Secret = secret
+	}
+
+	if err := h.store.UpdateWebhookSubscription(c.Request.Context(), subscription); err != nil {
+		if errors.Is(err, store.ErrWebhookNotFound) {
+			respondError(c, http.StatusNotFound, "webhook_not_found", "webhook not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "update_webhook_failed", "failed to update webhook")
+		return
+	}
+	changedBefore, changedAfter := auditDiff(before, auditWebhookFields(subscription))
+	event := store.AuditEvent{
+		Action:     auditActionWebhookUpdate,
+		TargetType: auditTargetWebhook,
+		TargetID:   subscription.ID,
+		Before:     changedBefore,
+		After:      changedAfter,
+	}
+	if req.RotateSecret {
+		event.Metadata = map[string]any{"secretRotated": true}
+	}
+	h.recordAudit(c, adminUser, event)
+
+	response := gin.H{"webhook": newWebhookResponse(subscription)}
+	if secret != "" {
+		response["secret"] = secret
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *handler) deleteWebhook(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminWebhooksWrite)
+	if !ok {
+		return
+	}
+
+	subscription, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteWebhookSubscription(c.Request.Context(), subscription.ID); err != nil {
+		if errors.Is(err, store.ErrWebhookNotFound) {
+			respondError(c, http.StatusNotFound, "webhook_not_found", "webhook not found")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, "delete_webhook_failed", "failed to delete webhook")
+		return
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionWebhookDelete,
+		TargetType: auditTargetWebhook,
+		TargetID:   subscription.ID,
+		Before:     auditWebhookFields(subscription),
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// listWebhookDeliveries serves the delivery log of one webhook.
+func (h *handler) listWebhookDeliveries(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminWebhooksRead); !ok {
+		return
+	}
+
+	subscription, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+	limit, ok := parseWebhookListLimit(c)
+	if !ok {
+		return
+	}
+	h.respondWebhookDeliveries(c, store.WebhookDeliveryFilter{
+		SubscriptionID: subscription.ID,
+		Status:         strings.TrimSpace(c.Query("status")),
+		Limit:          limit,
+	})
+}
+
+func (h *handler) listOutboxEvents(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminWebhooksRead); !ok {
+		return
+	}
+
+	limit, ok := parseWebhookListLimit(c)
+	if !ok {
+		return
+	}
+	events, err := h.store.ListOutboxEvents(c.Request.Context(), strings.TrimSpace(c.Query("type")), limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_events_failed", "failed to list events")
+		return
+	}
+	responses := make([]outboxEventResponse, 0, len(events))
+	for _, event := range events {
+		data := event.Data
+		if data == nil {
+			data = map[string]any{}
+		}
+		responses = append(responses, outboxEventResponse{
+			ID:          event.ID,
+			Type:        event.Type,
+			SubjectType: event.SubjectType,
+			SubjectID:   event.SubjectID,
+			Data:        data,
+			CreatedAt:   event.CreatedAt.UTC(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"events": responses})
+}
+
+func (h *handler) listOutboxEventDeliveries(c *gin.Context) {
+	if _, ok := h.requireAdminPermission(c, permissionAdminWebhooksRead); !ok {
+		return
+	}
+
+	event, err := h.store.GetOutboxEvent(c.Request.Context(), c.Param("eventId"))
+	if err != nil {
+		respondOutboxEventError(c, err)
+		return
+	}
+	limit, ok := parseWebhookListLimit(c)
+	if !ok {
+		return
+	}
+	h.respondWebhookDeliveries(c, store.WebhookDeliveryFilter{EventID: event.ID, Limit: limit})
+}
+
+// replayOutboxEvent queues an event for delivery again, to one webhook when
+// subscriptionId is given and otherwise to every webhook that accepts it.
+func (h *handler) replayOutboxEvent(c *gin.Context) {
+	adminUser, ok := h.requireAdminPermission(c, permissionAdminWebhooksWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, "invalid_request", "invalid request payload")
+			return
+		}
+	}
+
+	deliveries, err := h.store.EnqueueWebhookDeliveries(c.Request.Context(), c.Param("eventId"), req.SubscriptionID)
+	if err != nil {
+		if errors.Is(err, store.ErrWebhookNotFound) {
+			respondError(c, http.StatusNotFound, "webhook_not_found", "webhook not found")
+			return
+		}
+		respondOutboxEventError(c, err)
+		return
+	}
+	metadata := map[string]any{"deliveries": len(deliveries)}
+	if req.SubscriptionID != "" {
+		metadata["subscriptionId"] = strings.TrimSpace(req.SubscriptionID)
+	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		Action:     auditActionEventReplay,
+		TargetType: auditTargetEvent,
+		TargetID:   c.Param("eventId"),
+		Metadata:   metadata,
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"deliveries": newWebhookDeliveryResponses(deliveries)})
+}
+
+func (h *handler) loadWebhook(c *gin.Context) (*store.WebhookSubscription, bool) {
+	subscription, err := h.store.GetWebhookSubscription(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, store.ErrWebhookNotFound) {
+			respondError(c, http.StatusNotFound, "webhook_not_found", "webhook not found")
+			return nil, false
+		}
+		respondError(c, http.StatusInternalServerError, "webhook_lookup_failed", "failed to load webhook")
+		return nil, false
+	}
+	return subscription, true
+}
+
+func (h *handler) respondWebhookDeliveries(c *gin.Context, filter store.WebhookDeliveryFilter) {
+	deliveries, err := h.store.ListWebhookDeliveries(c.Request.Context(), filter)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "list_deliveries_failed", "failed to list webhook deliveries")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": newWebhookDeliveryResponses(deliveries)})
+}
+
+func respondOutboxEventError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrOutboxEventNotFound) {
+		respondError(c, http.StatusNotFound, "event_not_found", "event not found")
+		return
+	}
+	respondError(c, http.StatusInternalServerError, "event_lookup_failed", "failed to load event")
+}
+
+// auditWebhookFields snapshots a webhook for the audit log, leaving out its
+// secret.
+func auditWebhookFields(subscription *store.WebhookSubscription) map[string]any {
+	return map[string]any{
+		"url":         subscription.URL,
+		"description": subscription.Description,
+		"eventTypes":  append([]string{}, subscription.EventTypes...),
+		"active":      subscription.Active,
+	}
+}
@@ -976,14 +976,10 @@ func (h *handler) deleteXWorkmateSecret(c *gin.Context) {
 }
 
 func (h *handler) bootstrapTenant(c *gin.Context) {
-	adminUser, ok := h.requireAdminPermission(c, permissionAdminSettingsWrite)
+	adminUser, ok := h.requireAdminPermission(c, permissionRootTenantsBootstrap)
 	if !ok {
 		return
 	}
-	if !h.isRootAccount(adminUser) {
-		respondError(c, http.StatusForbidden, "root_only", "root only")
-		return
-	}
 
 	var payload struct {
 		Name        string `json:"name"`
@@ -1011,36 +1007,18 @@ func (h *handler) bootstrapTenant(c *gin.Context) {
 		return
 	}
 
-	domain, err := store.GenerateRandomTenantDomain()
-	if err != nil {
-		respondError(c, http.StatusInternalServerError, "tenant_domain_generation_failed", "failed to generate tenant domain")
-		return
-	}
-
-	tenant := &store.Tenant{
-		Name:    strings.TrimSpace(payload.Name),
-		Edition: store.TenantPrivateEdition,
-	}
-	if tenant.Name == "" {
-		tenant.Name = member.Name
-		if tenant.Name == "" {
-			tenant.Name = member.Email
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = member.Name
+		if name == "" {
+			name = member.Email
 		}
 	}
-	if err := h.store.EnsureTenant(c.Request.Context(), tenant); err != nil {
+	tenant, domain, err := h.provisionTenant(c.Request.Context(), name)
+	if err != nil {
 		respondError(c, http.StatusInternalServerError, "tenant_create_failed", "failed to create tenant")
 		return
 	}
-	if err := h.store.EnsureTenantDomain(c.Request.Context(), &store.TenantDomain{
-		TenantID:  tenant.ID,
-		Domain:    domain,
-		Kind:      store.TenantDomainKindGenerated,
-		IsPrimary: true,
-		Status:    store.TenantDomainStatusVerified,
-	}); err != nil {
-		respondError(c, http.StatusInternalServerError, "tenant_domain_create_failed", "failed to create tenant domain")
-		return
-	}
 	if err := h.store.UpsertTenantMembership(c.Request.Context(), &store.TenantMembership{
 		TenantID: tenant.ID,
 		UserID:   member.ID,
@@ -1049,6 +1027,13 @@ func (h *handler) bootstrapTenant(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, "tenant_membership_create_failed", "failed to create tenant membership")
 		return
 	}
+	h.recordAudit(c, adminUser, store.AuditEvent{
+		TenantID:   tenant.ID,
+		Action:     auditActionTenantBootstrap,
+		TargetType: auditTargetTenant,
+		TargetID:   tenant.ID,
+		After:      map[string]any{"name": tenant.Name, "edition": tenant.Edition, "domain": domain, "adminUserId": member.ID},
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"tenant": gin.H{
@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"strings"
@@ -26,13 +27,17 @@ import (
 
 	"account/api"
 	"account/config"
+	"account/internal/agentca"
 	"account/internal/agentmode"
 	"account/internal/agentserver"
 	"account/internal/auth"
+	"account/internal/cache"
 	"account/internal/mailer"
 	"account/internal/model"
+	"account/internal/ratelimit"
 	"account/internal/service"
 	"account/internal/store"
+	"account/internal/webhook"
 	"account/internal/xrayconfig"
 )
 
@@ -710,6 +715,16 @@ func applyRBACSchema(ctx context.Context, db *gorm.DB, driver string) error {
   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   PRIMARY KEY (role_key, permission_key)
+)`,
+		`ALTER TABLE public.rbac_roles ADD COLUMN IF NOT EXISTS custom BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE IF NOT EXISTS public.rbac_role_bindings (
+  id TEXT PRIMARY KEY,
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  role_key TEXT NOT NULL REFERENCES public.rbac_roles(role_key) ON DELETE CASCADE,
+  tenant_id TEXT NOT NULL DEFAULT '',
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (user_uuid, role_key, tenant_id)
 )`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS users_single_root_role_uk ON public.users ((lower(role))) WHERE lower(role) = 'root'`,
 		`DO $$
@@ -753,8 +768,24 @@ VALUES
   ('admin.users.delete.write', 'delete users'),
   ('admin.users.renew_uuid.write', 'renew user proxy uuid'),
   ('admin.users.role.write', 'update/reset user role'),
+  ('admin.users.sessions.read', 'read user sessions'),
+  ('admin.users.sessions.write', 'revoke user sessions'),
+  ('admin.oidc.clients.read', 'read oidc clients'),
+  ('admin.oidc.clients.write', 'register and delete oidc clients'),
   ('admin.blacklist.read', 'read blacklist'),
-  ('admin.blacklist.write', 'update blacklist')
+  ('admin.blacklist.write', 'update blacklist'),
+  ('admin.audit.read', 'read audit log'),
+  ('admin.webhooks.read', 'read webhook subscriptions and deliveries'),
+  ('admin.webhooks.write', 'manage webhook subscriptions and replay events'),
+  ('admin.scim.read', 'list tenant scim tokens'),
+  ('admin.scim.write', 'issue and revoke tenant scim tokens'),
+  ('admin.tenants.read', 'read tenants, domains, members and invitations'),
+  ('admin.tenants.write', 'manage tenants, domains, members and invitations'),
+  ('admin.roles.read', 'read roles and role bindings'),
+  ('admin.roles.write', 'manage custom roles and role bindings'),
+  ('admin.service_accounts.read', 'read service accounts and their tokens'),
+  ('admin.service_accounts.write', 'manage service accounts and issue and revoke their tokens'),
+  ('admin.agents.write', 'issue agent join tokens and manage agent credentials')
 ON CONFLICT (permission_key) DO NOTHING`,
 		`INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled)
 SELECT 'operator', permission_key, true
@@ -816,6 +847,36 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 		}
 	}()
 
+	authStates, authStatesCleanup, err := openAuthStateStore(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("auth state store: %w", err)
+	}
+	defer func() {
+		if err := authStatesCleanup(context.Background()); err != nil {
+			logger.Error("failed to close auth state store", "err", err)
+		}
+	}()
+	go runAuthStatePurge(ctx, authStates, logger)
+
+	sessionCache, sessionCacheCleanup, err := cache.NewSessionCache(ctx, cache.SessionCacheConfig{
+		Driver: cfg.Session.Cache,
+		Redis: cache.RESPOptions{
+			Addr:     cfg.Session.Redis.Addr,
+			Password: cfg.Session.Redis.Password,
+			DB:       cfg.Session.Redis.DB,
+		},
+	})
+	if err != nil {
+		// The cache is an optimisation; sessions still resolve from the store.
+		logger.Warn("session cache disabled", "driver", cfg.Session.Cache, "err", err)
+	}
+	defer func() {
+		if err := sessionCacheCleanup(context.Background()); err != nil {
+			logger.Error("failed to close session cache", "err", err)
+		}
+	}()
+	go runSessionCachePurge(ctx, sessionCache, logger)
+
 	gormDB, gormCleanup, err := openAdminSettingsDB(cfg.Store)
 	if err != nil {
 		return err
@@ -869,7 +930,11 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 	r.Use(func(c *gin.Context) {
 		start := time.Now()
 		c.Next()
-		logger.Info("request", "method", c.Request.Method, "path", c.FullPath(), "status", c.Writer.Status(), "latency", time.Since(start))
+		attrs := []any{"method", c.Request.Method, "path", c.FullPath(), "status", c.Writer.Status(), "latency", time.Since(start)}
+		if caller := auth.GetInternalCaller(c); caller != "" {
+			attrs = append(attrs, "internalCaller", caller)
+		}
+		logger.Info("request", attrs...)
 	})
 
 	var emailSender api.EmailSender
@@ -930,14 +995,27 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 			refreshExpiry = 168 * time.Hour // 7 days
 		}
 
+		signingKeys, keyRotator, err := openSigningKeys(ctx, cfg.Auth.Token, st, accessExpiry)
+		if err != nil {
+			return fmt.Errorf("access token signing keys: %w", err)
+		}
+		if keyRotator != nil {
+			go runSigningKeyRotation(ctx, keyRotator, logger)
+		}
+
 		tokenService = auth.NewTokenService(auth.TokenConfig{
-			PublicToken:   cfg.Auth.Token.PublicToken,
-			RefreshSecret: cfg.Auth.Token.RefreshSecret,
-			AccessSecret:  cfg.Auth.Token.AccessSecret,
-			AccessExpiry:  accessExpiry,
-			RefreshExpiry: refreshExpiry,
+			PublicToken:         cfg.Auth.Token.PublicToken,
+			RefreshSecret:       cfg.Auth.Token.RefreshSecret,
+			AccessSecret:        cfg.Auth.Token.AccessSecret,
+			AccessExpiry:        accessExpiry,
+			RefreshExpiry:       refreshExpiry,
+			SigningKeys:         signingKeys,
+			HMACTransitionUntil: cfg.Auth.Token.HMACTransitionUntil,
 		})
 		logger.Info("token service initialized", "auth_enabled", cfg.Auth.Enable)
+		if signingKeys != nil && time.Now().Before(cfg.Auth.Token.HMACTransitionUntil) {
+			logger.Warn("HS256 access tokens are still accepted during the signing transition", "until", cfg.Auth.Token.HMACTransitionUntil)
+		}
 	}
 
 	if err := applyRBACSchema(ctx, gormDB, cfg.Store.Driver); err != nil {
@@ -982,6 +1060,23 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 		}
 	}
 
+	var agentCA *agentca.Authority
+	if cfg.Agents.CA.Enabled {
+		agentCA, err = agentca.LoadOrCreate(cfg.Agents.CA.CertFile, cfg.Agents.CA.KeyFile, cfg.Agents.CA.CertificateTTL)
+		if err != nil {
+			return fmt.Errorf("load agent ca: %w", err)
+		}
+		logger.Info("agent certificate authority enabled", "certFile", cfg.Agents.CA.CertFile, "certificateTtl", agentCA.TTL())
+	}
+	if agentRegistry == nil {
+		// Managed agents authenticate with tokens loaded from the store and
+		// enrolled agents with certificates, so neither needs configuration.
+		agentRegistry, err = agentserver.NewRegistry(agentserver.Config{})
+		if err != nil {
+			return err
+		}
+	}
+
 	if agentRegistry != nil {
 		agentRegistry.SetStore(st)
 		agentRegistry.SetLogger(logger.With("component", "agent-registry"))
@@ -1014,6 +1109,16 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 		go runAgentCleanup(ctx, st, logger)
 	}
 
+	go runTrafficRating(ctx, st, logger)
+	go runAccountScheduler(ctx, st, cfg.Scheduler, logger)
+	webhookNetworks, err := webhook.ParseNetworks(cfg.Webhooks.AllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("configure webhook networks: %w", err)
+	}
+	if !cfg.Webhooks.Disabled {
+		go runWebhookDispatcher(ctx, st, cfg.Webhooks, webhookNetworks, logger)
+	}
+
 	var stopXraySync func(context.Context) error
 	if cfg.Xray.Sync.Enabled {
 		syncInterval := cfg.Xray.Sync.Interval
@@ -1096,6 +1201,8 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 	options := []api.Option{
 		api.WithStore(st),
 		api.WithSessionTTL(cfg.Session.TTL),
+		api.WithAuthStateStore(authStates),
+		api.WithSessionCache(sessionCache),
 		api.WithEmailSender(emailSender),
 		api.WithEmailVerification(emailVerificationEnabled),
 		api.WithTokenService(tokenService),
@@ -1148,9 +1255,100 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 				redirectURL,
 			)
 		}
+		for _, providerCfg := range cfg.Auth.OAuth.Providers {
+			name := strings.TrimSpace(providerCfg.Name)
+			if _, exists := oauthProviders[name]; exists {
+				logger.Warn("duplicate oauth provider name; skipping", "provider", name)
+				continue
+			}
+			redirectURL := strings.TrimSpace(providerCfg.RedirectURL)
+			if redirectURL == "" {
+				redirectURL = strings.TrimSuffix(strings.TrimSpace(cfg.Server.PublicURL), "/") + "/api/auth/oauth/callback/" + name
+			}
+			discoveryCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+			provider, err := auth.NewGenericOIDCProvider(discoveryCtx, auth.GenericOIDCConfig{
+				Name:         name,
+				Issuer:       providerCfg.Issuer,
+				ClientID:     providerCfg.ClientID,
+				ClientSecret: providerCfg.ClientSecret,
+				RedirectURL:  redirectURL,
+				Scopes:       providerCfg.Scopes,
+				Claims: auth.OIDCClaimMapping{
+					Subject:       providerCfg.SubjectClaim,
+					Email:         providerCfg.EmailClaim,
+					EmailVerified: providerCfg.EmailVerifiedClaim,
+					Name:          providerCfg.NameClaim,
+				},
+				TrustEmail: providerCfg.TrustEmail,
+			})
+			cancel()
+			if err != nil {
+				// An unreachable IdP must not keep the service from starting.
+				logger.Warn("failed to initialize oidc identity provider; skipping", "provider", name, "err", err)
+				continue
+			}
+			oauthProviders[name] = provider
+			logger.Info("oidc identity provider enabled", "provider", name, "issuer", providerCfg.Issuer)
+		}
 	}
 	options = append(options, api.WithOAuthProviders(oauthProviders))
+	if cfg.Auth.OIDC.Enable {
+		issuer := strings.TrimSpace(cfg.Auth.OIDC.Issuer)
+		if issuer == "" {
+			issuer = strings.TrimSpace(cfg.Server.PublicURL)
+		}
+		if issuer == "" {
+			logger.Warn("openid connect provider disabled: no issuer or server public url configured")
+		} else {
+			options = append(options, api.WithOIDCProvider(api.OIDCConfig{
+				Issuer:   issuer,
+				LoginURL: cfg.Auth.OIDC.LoginURL,
+				CodeTTL:  cfg.Auth.OIDC.CodeTTL,
+			}))
+			logger.Info("openid connect provider enabled", "issuer", issuer)
+		}
+	}
+	if cfg.Auth.WebAuthn.Enable {
+		origins := cfg.Auth.WebAuthn.RPOrigins
+		if len(origins) == 0 && strings.TrimSpace(cfg.Auth.OAuth.FrontendURL) != "" {
+			origins = []string{strings.TrimSuffix(strings.TrimSpace(cfg.Auth.OAuth.FrontendURL), "/")}
+		}
+		rpID := strings.TrimSpace(cfg.Auth.WebAuthn.RPID)
+		if rpID == "" && len(origins) > 0 {
+			if parsed, err := url.Parse(origins[0]); err == nil {
+				rpID = parsed.Hostname()
+			}
+		}
+		if rpID == "" || len(origins) == 0 {
+			logger.Warn("webauthn disabled: no relying party id or origins configured")
+		} else {
+			options = append(options, api.WithWebAuthn(api.WebAuthnConfig{
+				RPID:          rpID,
+				RPDisplayName: cfg.Auth.WebAuthn.RPDisplayName,
+				RPOrigins:     origins,
+			}))
+			logger.Info("webauthn passkeys enabled", "rpId", rpID, "origins", origins)
+		}
+	}
+	options = append(options, api.WithRateLimits(rateLimitsFromConfig(cfg.Auth.RateLimit)))
+	if cfg.Auth.RateLimit.Disable {
+		logger.Warn("sign-in and email rate limits disabled")
+	}
+	internalCallers, err := internalCallersFromConfig(cfg.Internal)
+	if err != nil {
+		return fmt.Errorf("configure internal callers: %w", err)
+	}
+	if internalCallers.Empty() {
+		logger.Warn("no internal callers configured; /api/internal is unavailable")
+	} else {
+		logger.Info("configured internal callers", "callers", internalCallers.Names())
+	}
+	options = append(options, api.WithInternalCallers(internalCallers))
+	options = append(options, api.WithWebhookNetworks(webhookNetworks))
 	options = append(options, api.WithAgentRegistry(agentRegistry))
+	if agentCA != nil {
+		options = append(options, api.WithAgentCA(agentCA))
+	}
 	options = append(options, api.WithGormDB(gormDB))
 
 	// Pre-load sandbox bindings from database into the registry
@@ -1237,6 +1435,15 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 			tlsConfig.ClientCAs = pool
 			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 		}
+		if agentCA != nil {
+			// Agent certificates are optional at the handshake: browsers and
+			// agents that have not enrolled yet connect without one.
+			if tlsConfig.ClientCAs == nil {
+				tlsConfig.ClientCAs = x509.NewCertPool()
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			tlsConfig.ClientCAs.AddCert(agentCA.Certificate())
+		}
 	} else {
 		if certFile != "" || keyFile != "" {
 			logger.Info("TLS disabled; certificate paths will be ignored", "certFile", certFile, "keyFile", keyFile)
@@ -1244,6 +1451,9 @@ func runServer(ctx context.Context, cfg *config.Config, logger *slog.Logger) err
 		if clientCAFile != "" {
 			logger.Warn("client CA configured but TLS is disabled; ignoring", "clientCAFile", clientCAFile)
 		}
+		if agentCA != nil {
+			logger.Warn("agent CA enabled but TLS is disabled; agents can enroll but not authenticate with certificates")
+		}
 	}
 
 	srv := &http.Server{
@@ -1404,6 +1614,250 @@ func runAgentCleanup(ctx context.Context, st store.Store, logger *slog.Logger) {
 	}
 }
 
+// openAuthStateStore builds the store for short-lived auth state. Unless
+// configured otherwise it follows the business store, so replicas sharing a
+// database also share MFA, verification and reset state.
+func openAuthStateStore(ctx context.Context, cfg *config.Config) (cache.StateStore, func(context.Context) error, error) {
+	driver := strings.ToLower(strings.TrimSpace(cfg.Session.State))
+	if driver == "" {
+		switch strings.ToLower(strings.TrimSpace(cfg.Store.Driver)) {
+		case "postgres", "postgresql", "pgx":
+			driver = "postgres"
+		default:
+			driver = "memory"
+		}
+	}
+	return cache.NewStateStore(ctx, cache.StateConfig{
+		Driver: driver,
+		DSN:    cfg.Store.DSN,
+		Redis: cache.RESPOptions{
+			Addr:     cfg.Session.Redis.Addr,
+			Password: cfg.Session.Redis.Password,
+			DB:       cfg.Session.Redis.DB,
+		},
+	})
+}
+
+// openSigningKeys prepares asymmetric access token signing. Configured key
+// files take precedence; otherwise an asymmetric algorithm generates keys into
+// the store and returns the rotator that keeps them fresh. HS256 returns a nil
+// key set.
+func openSigningKeys(ctx context.Context, tokenCfg config.Token, st store.Store, accessExpiry time.Duration) (*auth.KeySet, *auth.SigningKeyRotator, error) {
+	algorithm, err := auth.NormalizeAlgorithm(tokenCfg.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(tokenCfg.SigningKeys) > 0 {
+		keys := make([]*auth.SigningKey, 0, len(tokenCfg.SigningKeys))
+		for _, file := range tokenCfg.SigningKeys {
+			key, err := auth.LoadSigningKeyFile(strings.TrimSpace(file.File), file.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, key)
+		}
+		return auth.NewKeySet(keys...), nil, nil
+	}
+
+	if algorithm == auth.AlgorithmHS256 {
+		return nil, nil, nil
+	}
+
+	keys := auth.NewKeySet()
+	// Keep superseded keys published a little past the last token they signed.
+	rotator := auth.NewSigningKeyRotator(st, keys, algorithm, accessExpiry+auth.DefaultKeyPublishAhead)
+	if tokenCfg.KeyRotation > 0 {
+		rotator.RotateEvery = tokenCfg.KeyRotation
+	}
+	if err := rotator.Rotate(ctx); err != nil {
+		return nil, nil, err
+	}
+	return keys, rotator, nil
+}
+
+func runSigningKeyRotation(ctx context.Context, rotator *auth.SigningKeyRotator, logger *slog.Logger) {
+	// Check well inside the publish-ahead window so keys generated by other
+	// replicas are loaded before they start signing.
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rotateCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := rotator.Rotate(rotateCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to rotate access token signing keys", "err", err)
+			}
+		}
+	}
+}
+
+func runAuthStatePurge(ctx context.Context, states cache.StateStore, logger *slog.Logger) {
+	purger, ok := states.(interface {
+		PurgeExpired(ctx context.Context) (int64, error)
+	})
+	if !ok {
+		// Backends such as Redis expire keys on their own.
+		return
+	}
+
+	// Drop expired auth state every 10 minutes
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			count, err := purger.PurgeExpired(purgeCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to purge expired auth state", "err", err)
+			} else if count > 0 {
+				logger.Info("purged expired auth state", "count", count)
+			}
+		}
+	}
+}
+
+func runSessionCachePurge(ctx context.Context, sessions cache.Cache, logger *slog.Logger) {
+	purger, ok := sessions.(interface {
+		PurgeExpired(ctx context.Context) (int64, error)
+	})
+	if !ok {
+		// Redis expires cached sessions on its own.
+		return
+	}
+
+	// Drop expired cached sessions every minute
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgeCtx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
+			count, err := purger.PurgeExpired(purgeCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to purge expired cached sessions", "err", err)
+			} else if count > 0 {
+				logger.Debug("purged expired cached sessions", "count", count)
+			}
+		}
+	}
+}
+
+func runTrafficRating(ctx context.Context, st store.Store, logger *slog.Logger) {
+	// Rate settled minute buckets and enforce quota states every minute
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	rater := service.NewTrafficRater(st)
+	enforcer := service.NewQuotaEnforcer(st)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rateCtx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
+			count, err := rater.RatePending(rateCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to rate traffic buckets", "rated", count, "err", err)
+			} else if count > 0 {
+				logger.Info("rated traffic buckets", "count", count)
+			}
+
+			enforceCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			changed, err := enforcer.Enforce(enforceCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to enforce account quota states", "changed", changed, "err", err)
+			} else if changed > 0 {
+				logger.Info("updated account quota states", "count", changed)
+			}
+		}
+	}
+}
+
+func runAccountScheduler(ctx context.Context, st store.Store, cfg config.Scheduler, logger *slog.Logger) {
+	// Refresh account policy snapshots every minute
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	scheduler := service.NewAccountScheduler(st)
+	scheduler.MinHealthScore = cfg.MinHealthScore
+	scheduler.PlanNodeGroups = cfg.PlanNodeGroups
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduleCtx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
+			count, err := scheduler.Schedule(scheduleCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to refresh account policies", "written", count, "err", err)
+			} else if count > 0 {
+				logger.Info("refreshed account policies", "count", count)
+			}
+		}
+	}
+}
+
+func runWebhookDispatcher(ctx context.Context, st store.Store, cfg config.Webhooks, networks []netip.Prefix, logger *slog.Logger) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = webhook.DefaultTimeout
+	}
+	dispatcher := webhook.NewDispatcher(st, webhook.NewClient(timeout, networks))
+	if cfg.MaxAttempts > 0 {
+		dispatcher.MaxAttempts = cfg.MaxAttempts
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A batch is sent concurrently, so one request timeout plus
+			// bookkeeping bounds each round.
+			dispatchCtx, cancel := context.WithTimeout(context.Background(), 3*timeout)
+			count, err := dispatcher.Dispatch(dispatchCtx)
+			cancel()
+
+			if err != nil {
+				logger.Warn("failed to dispatch webhooks", "delivered", count, "err", err)
+			} else if count > 0 {
+				logger.Debug("dispatched webhooks", "count", count)
+			}
+		}
+	}
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "xcontrol-account",
 	Short: "Start the xcontrol account service",
@@ -1487,6 +1941,7 @@ func openAdminSettingsDB(cfg config.Store) (*gorm.DB, func(context.Context) erro
 		&model.Tenant{},
 		&model.TenantDomain{},
 		&model.TenantMembership{},
+		&model.TenantInvitation{},
 		&model.XWorkmateProfile{},
 	); err != nil {
 		return nil, nil, err
@@ -1704,3 +2159,70 @@ func deriveRedirectAddr(addr string) string {
 	}
 	return net.JoinHostPort(host, port)
 }
+
+// internalCallersFromConfig builds the internal callers registry. The legacy
+// INTERNAL_SERVICE_TOKEN caller is added unless disabled so existing
+// deployments keep working while they move to named callers.
+func internalCallersFromConfig(cfg config.Internal) (*auth.InternalCallers, error) {
+	callers := make([]auth.InternalCaller, 0, len(cfg.Callers)+1)
+	for _, caller := range cfg.Callers {
+		tokens := make([]auth.InternalCallerToken, 0, len(caller.Tokens))
+		for _, token := range caller.Tokens {
+			secret := token.Token
+			if env := strings.TrimSpace(token.TokenEnv); env != "" {
+				if strings.TrimSpace(secret) != "" {
+					return nil, fmt.Errorf("internal caller %s: token and tokenEnv are mutually exclusive", caller.Name)
+				}
+				secret = os.Getenv(env)
+				if strings.TrimSpace(secret) == "" {
+					return nil, fmt.Errorf("internal caller %s: environment variable %s is empty", caller.Name, env)
+				}
+			}
+			tokens = append(tokens, auth.InternalCallerToken{
+				Token:     secret,
+				SHA256:    token.SHA256,
+				NotBefore: token.NotBefore,
+				NotAfter:  token.NotAfter,
+			})
+		}
+		callers = append(callers, auth.InternalCaller{
+			Name:       caller.Name,
+			Tokens:     tokens,
+			Identities: caller.Identities,
+			Routes:     caller.Routes,
+		})
+	}
+	if token := strings.TrimSpace(os.Getenv("INTERNAL_SERVICE_TOKEN")); token != "" && !cfg.DisableLegacyToken {
+		callers = append(callers, auth.LegacyInternalCaller(token))
+	}
+	return auth.NewInternalCallers(callers)
+}
+
+// rateLimitsFromConfig overlays the configured overrides on the default rate
+// limits.
+func rateLimitsFromConfig(cfg config.RateLimit) api.RateLimitConfig {
+	if cfg.Disable {
+		return api.RateLimitConfig{}
+	}
+	limits := api.DefaultRateLimits()
+	overrideRateLimitRule(&limits.Account, cfg.Account)
+	overrideRateLimitRule(&limits.IP, cfg.IP)
+	overrideRateLimitRule(&limits.EmailTarget, cfg.EmailTarget)
+	overrideRateLimitRule(&limits.EmailIP, cfg.EmailIP)
+	return limits
+}
+
+func overrideRateLimitRule(rule *ratelimit.Rule, override config.RateLimitRule) {
+	if override.Limit > 0 {
+		rule.Limit = override.Limit
+	}
+	if override.Window > 0 {
+		rule.Window = override.Window
+	}
+	if override.Lockout > 0 {
+		rule.Lockout = override.Lockout
+	}
+	if override.MaxLockout > 0 {
+		rule.MaxLockout = override.MaxLockout
+	}
+}
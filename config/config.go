@@ -30,6 +30,9 @@ type Config struct {
 	Agent         Agent         `yaml:"agent"`
 	Agents        Agents        `yaml:"agents"`
 	ReviewAccount ReviewAccount `yaml:"reviewAccount"`
+	Webhooks      Webhooks      `yaml:"webhooks"`
+	Scheduler     Scheduler     `yaml:"scheduler"`
+	Internal      Internal      `yaml:"internal"`
 }
 
 // Server defines HTTP server configuration.
@@ -73,6 +76,22 @@ type Store struct {
 // Session defines session management configuration.
 type Session struct {
 	TTL time.Duration `yaml:"ttl"`
+	// State selects where short-lived auth state (MFA challenges,
+	// verification codes, password resets, OAuth exchange codes) is kept:
+	// memory, postgres or redis. It defaults to postgres when the store
+	// driver is postgres and to memory otherwise.
+	State string `yaml:"state"`
+	// Cache puts a session token cache in front of the store: memory or
+	// redis. It is disabled when empty.
+	Cache string `yaml:"cache"`
+	Redis Redis  `yaml:"redis"`
+}
+
+// Redis defines how to reach a Redis-compatible server.
+type Redis struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
 }
 
 // Auth defines authentication configuration.
@@ -80,6 +99,56 @@ type Auth struct {
 	Enable bool  `yaml:"enable"`
 	Token  Token `yaml:"token"`
 	OAuth  OAuth `yaml:"oauth"`
+	OIDC   OIDC  `yaml:"oidc"`
+	// WebAuthn enables passkeys as a second factor and for passwordless login.
+	WebAuthn WebAuthn `yaml:"webauthn"`
+	// RateLimit throttles sign-in attempts and verification or reset emails.
+	RateLimit RateLimit `yaml:"rateLimit"`
+}
+
+// RateLimit overrides the built-in throttling rules. Limits are on unless
+// Disable is set; zero fields keep the defaults.
+type RateLimit struct {
+	Disable bool `yaml:"disable"`
+	// Account limits failed sign-in and MFA attempts per account.
+	Account RateLimitRule `yaml:"account"`
+	// IP limits failed sign-in and MFA attempts per client IP.
+	IP RateLimitRule `yaml:"ip"`
+	// EmailTarget limits verification and password reset emails per recipient.
+	EmailTarget RateLimitRule `yaml:"emailTarget"`
+	// EmailIP limits verification and password reset requests per client IP.
+	EmailIP RateLimitRule `yaml:"emailIp"`
+}
+
+// RateLimitRule allows Limit attempts per sliding Window. With Lockout set,
+// reaching the limit locks the key, doubling per repeat up to MaxLockout.
+type RateLimitRule struct {
+	Limit      int           `yaml:"limit"`
+	Window     time.Duration `yaml:"window"`
+	Lockout    time.Duration `yaml:"lockout"`
+	MaxLockout time.Duration `yaml:"maxLockout"`
+}
+
+// WebAuthn configures the passkey relying party.
+type WebAuthn struct {
+	Enable bool `yaml:"enable"`
+	// RPID defaults to the host of auth.oauth.frontendUrl. Passkeys are bound
+	// to it, so changing it invalidates every registered passkey.
+	RPID          string `yaml:"rpId"`
+	RPDisplayName string `yaml:"rpDisplayName"`
+	// RPOrigins defaults to auth.oauth.frontendUrl.
+	RPOrigins []string `yaml:"rpOrigins"`
+}
+
+// OIDC configures the built-in OpenID Connect provider. It requires
+// asymmetric token signing (auth.token.algorithm RS256 or EdDSA).
+type OIDC struct {
+	Enable bool `yaml:"enable"`
+	// Issuer defaults to server.publicUrl.
+	Issuer string `yaml:"issuer"`
+	// LoginURL defaults to {auth.oauth.frontendUrl}/login.
+	LoginURL string        `yaml:"loginUrl"`
+	CodeTTL  time.Duration `yaml:"codeTTL"`
 }
 
 // OAuth defines OAuth2 configuration for multiple providers.
@@ -88,6 +157,9 @@ type OAuth struct {
 	FrontendURL string        `yaml:"frontendUrl"`
 	GitHub      OAuthProvider `yaml:"github"`
 	Google      OAuthProvider `yaml:"google"`
+	// Providers lists generic OpenID Connect identity providers whose
+	// endpoints are discovered from their issuer.
+	Providers []OIDCIdentityProvider `yaml:"providers"`
 }
 
 // OAuthProvider defines configuration for a single OAuth2 provider.
@@ -97,6 +169,26 @@ type OAuthProvider struct {
 	RedirectURL  string `yaml:"redirectUrl"`
 }
 
+// OIDCIdentityProvider configures an external OpenID Connect identity
+// provider, signed in through /api/auth/oauth/login/{name}.
+type OIDCIdentityProvider struct {
+	Name         string `yaml:"name"`
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// RedirectURL defaults to {server.publicUrl}/api/auth/oauth/callback/{name}.
+	RedirectURL string   `yaml:"redirectUrl"`
+	Scopes      []string `yaml:"scopes"`
+	// Claim overrides for IdPs that do not use the standard claim names.
+	SubjectClaim       string `yaml:"subjectClaim"`
+	EmailClaim         string `yaml:"emailClaim"`
+	EmailVerifiedClaim string `yaml:"emailVerifiedClaim"`
+	NameClaim          string `yaml:"nameClaim"`
+	// TrustEmail treats every email from this IdP as verified. OAuth sign-in
+	// links accounts by email, so only enable it for IdPs that own the domain.
+	TrustEmail bool `yaml:"trustEmail"`
+}
+
 // Token defines token authentication configuration.
 type Token struct {
 	PublicToken   string        `yaml:"publicToken"`
@@ -104,6 +196,26 @@ type Token struct {
 	AccessSecret  string        `yaml:"accessSecret"`
 	AccessExpiry  time.Duration `yaml:"accessExpiry"`
 	RefreshExpiry time.Duration `yaml:"refreshExpiry"`
+	// Algorithm selects access token signing: "HS256" (default, uses
+	// AccessSecret), "RS256" or "EdDSA".
+	Algorithm string `yaml:"algorithm"`
+	// SigningKeys loads asymmetric keys from PEM files. The first entry
+	// signs; the others are only published for verification. When empty and
+	// Algorithm is asymmetric, keys are generated into the store and rotated
+	// every KeyRotation.
+	SigningKeys []SigningKeyFile `yaml:"signingKeys"`
+	KeyRotation time.Duration    `yaml:"keyRotation"`
+	// HMACTransitionUntil keeps HS256 access tokens signed with
+	// AccessSecret valid until this time after switching Algorithm to an
+	// asymmetric one. Unset, they are rejected right away.
+	HMACTransitionUntil time.Time `yaml:"hmacTransitionUntil"`
+}
+
+// SigningKeyFile references a PEM encoded private key. ID defaults to the
+// key's RFC 7638 thumbprint.
+type SigningKeyFile struct {
+	ID   string `yaml:"id"`
+	File string `yaml:"file"`
 }
 
 // SMTP defines outbound SMTP configuration used for transactional email.
@@ -152,16 +264,56 @@ type Agent struct {
 	StatusInterval time.Duration `yaml:"statusInterval"`
 	SyncInterval   time.Duration `yaml:"syncInterval"`
 	TLS            AgentTLS      `yaml:"tls"`
+	Stats          AgentStats    `yaml:"stats"`
 }
 
 // AgentTLS configures TLS behaviour for the agent HTTP client.
 type AgentTLS struct {
 	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+	// CertFile and KeyFile hold the client certificate issued by the
+	// controller's agent CA. When they do not exist yet the agent enrolls
+	// with JoinToken and writes them; afterwards the certificate replaces
+	// apiToken and is renewed automatically.
+	CertFile  string `yaml:"certFile"`
+	KeyFile   string `yaml:"keyFile"`
+	JoinToken string `yaml:"joinToken"`
+	// RenewBefore is how long before expiry the certificate is renewed.
+	// Defaults to a third of its lifetime.
+	RenewBefore time.Duration `yaml:"renewBefore"`
+}
+
+// AgentStats configures collection of per-user traffic counters from the
+// local Xray StatsService. Counters are queried with Command (defaulting to
+// "xray api statsquery") against APIServer and pushed to the controller.
+// Reports that cannot be delivered are spooled under SpoolDir and replayed
+// once the controller is reachable again.
+type AgentStats struct {
+	Enabled   bool          `yaml:"enabled"`
+	Interval  time.Duration `yaml:"interval"`
+	APIServer string        `yaml:"apiServer"`
+	Command   []string      `yaml:"command"`
+	SpoolDir  string        `yaml:"spoolDir"`
+	NodeID    string        `yaml:"nodeId"`
+	Region    string        `yaml:"region"`
+	LineCode  string        `yaml:"lineCode"`
 }
 
 // Agents describes the controller-side agent configuration.
 type Agents struct {
 	Credentials []AgentCredential `yaml:"credentials"`
+	CA          AgentCA           `yaml:"ca"`
+}
+
+// AgentCA configures the built-in certificate authority that issues client
+// certificates to enrolled agents. The CA is generated into CertFile and
+// KeyFile on first start; replicas must share both files.
+type AgentCA struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// CertificateTTL is the lifetime of issued agent certificates. Defaults
+	// to 24h.
+	CertificateTTL time.Duration `yaml:"certificateTtl"`
 }
 
 // ReviewAccount controls the built-in readonly review user intended for App
@@ -175,6 +327,70 @@ type ReviewAccount struct {
 	Permissions []string `yaml:"permissions"`
 }
 
+// Webhooks configures delivery of lifecycle events to the webhook
+// subscriptions managed through the admin API. Events are always recorded in
+// the outbox; Disabled only stops this instance from sending them.
+type Webhooks struct {
+	Disabled bool `yaml:"disabled"`
+	// Interval is how often queued deliveries are polled. Defaults to 10s.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout bounds a single delivery request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxAttempts is the number of attempts before a delivery is marked
+	// failed. Defaults to 10.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// AllowedNetworks lists the CIDRs or addresses subscriptions may reach
+	// although they are private, loopback or link-local. Deliveries to such
+	// addresses are refused otherwise.
+	AllowedNetworks []string `yaml:"allowedNetworks"`
+}
+
+// Scheduler tunes how account policy snapshots pick node groups.
+type Scheduler struct {
+	// MinHealthScore is the lowest health score a node may report and still
+	// count as healthy. Defaults to 0.
+	MinHealthScore float64 `yaml:"minHealthScore"`
+	// PlanNodeGroups restricts the node groups available to a subscription
+	// plan, keyed by plan id or "default" for accounts without an active
+	// subscription. Plans without an entry may use every group.
+	PlanNodeGroups map[string][]string `yaml:"planNodeGroups"`
+}
+
+// Internal lists the services allowed to call /api/internal/*. Each caller
+// authenticates with its own tokens or mTLS client certificate and may only
+// use the routes it lists.
+type Internal struct {
+	Callers []InternalCaller `yaml:"callers"`
+	// DisableLegacyToken stops accepting INTERNAL_SERVICE_TOKEN as a caller
+	// with access to every internal route.
+	DisableLegacyToken bool `yaml:"disableLegacyToken"`
+}
+
+// InternalCaller is one internal service and what it may call.
+type InternalCaller struct {
+	Name string `yaml:"name"`
+	// Tokens may overlap in time so a secret can be rotated: add the new
+	// token, roll it out to the caller, then give the old one a notAfter.
+	Tokens []InternalCallerToken `yaml:"tokens"`
+	// Identities are client certificate names (CN, DNS or URI SAN) accepted
+	// when server.tls.clientCAFile verifies the caller's certificate.
+	Identities []string `yaml:"identities"`
+	// Routes are "/api/internal/..." or "GET /api/internal/..." route
+	// templates; a trailing * matches any suffix.
+	Routes []string `yaml:"routes"`
+}
+
+// InternalCallerToken is a token accepted from an internal caller. Set Token,
+// TokenEnv (the name of an environment variable holding the token) or
+// SHA256 (the hex digest of the token).
+type InternalCallerToken struct {
+	Token     string    `yaml:"token"`
+	TokenEnv  string    `yaml:"tokenEnv"`
+	SHA256    string    `yaml:"sha256"`
+	NotBefore time.Time `yaml:"notBefore"`
+	NotAfter  time.Time `yaml:"notAfter"`
+}
+
 // AgentCredential represents a single agent identity authorised to call the
 // controller API.
 type AgentCredential struct {
@@ -0,0 +1,372 @@
+// Package agentca is the built-in certificate authority that issues
+// short-lived client certificates to agents. An agent enrolls once with a
+// join token and a certificate signing request, then authenticates to the
+// agent-server routes with the issued certificate and renews it before it
+// expires.
+package agentca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCertificateTTL is the lifetime of issued agent certificates.
+	DefaultCertificateTTL = 24 * time.Hour
+	// MinCertificateTTL bounds how short a configured lifetime may be.
+	MinCertificateTTL = 10 * time.Minute
+
+	// agentOrganization marks the subject of every issued agent certificate.
+	agentOrganization = "xcontrol agents"
+	caLifetime        = 10 * 365 * 24 * time.Hour
+	clockSkew         = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidCSR is returned when a certificate signing request cannot be
+	// parsed or its signature does not verify.
+	ErrInvalidCSR = errors.New("invalid certificate signing request")
+)
+
+// Certificate is an issued agent certificate.
+type Certificate struct {
+	AgentID   string
+	Serial    string
+	PEM       []byte
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Identity is the agent named by a verified client certificate. IssuedAt is
+// when the certificate was signed and is compared against revocations.
+type Identity struct {
+	AgentID  string
+	Groups   []string
+	Serial   string
+	IssuedAt time.Time
+	NotAfter time.Time
+}
+
+// Authority signs agent certificates.
+type Authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// New builds an authority from a PEM encoded CA certificate and private key.
+// A zero ttl selects DefaultCertificateTTL.
+func New(certPEM, keyPEM []byte, ttl time.Duration) (*Authority, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("agent ca certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse agent ca certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("agent ca certificate is not a CA")
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		ttl = DefaultCertificateTTL
+	}
+	if ttl < MinCertificateTTL {
+		return nil, fmt.Errorf("agent certificate ttl must be at least %s", MinCertificateTTL)
+	}
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		key:     key,
+		ttl:     ttl,
+		now:     time.Now,
+	}, nil
+}
+
+// LoadOrCreate loads the CA from certFile and keyFile, generating a new CA
+// and writing both files first when neither exists yet.
+func LoadOrCreate(certFile, keyFile string, ttl time.Duration) (*Authority, error) {
+	certFile = strings.TrimSpace(certFile)
+	keyFile = strings.TrimSpace(keyFile)
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("agent ca certFile and keyFile are required")
+	}
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		var err error
+		certPEM, keyPEM, err = Generate(agentOrganization + " CA")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(keyFile, keyPEM, 0o600); err != nil {
+			return nil, err
+		}
+		if err := writeFile(certFile, certPEM, 0o644); err != nil {
+			return nil, err
+		}
+	} else if certErr != nil {
+		return nil, fmt.Errorf("read agent ca certificate: %w", certErr)
+	} else if keyErr != nil {
+		return nil, fmt.Errorf("read agent ca key: %w", keyErr)
+	}
+	return New(certPEM, keyPEM, ttl)
+}
+
+// Generate creates a self-signed ECDSA P-256 CA and returns its certificate
+// and private key in PEM form.
+func Generate(commonName string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{agentOrganization}},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// NewRequest generates an ECDSA P-256 key for an agent and a certificate
+// signing request for it, both PEM encoded.
+func NewRequest(agentID string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: strings.TrimSpace(agentID)},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), nil
+}
+
+// CertificatePEM returns the CA certificate.
+func (a *Authority) CertificatePEM() []byte {
+	return append([]byte(nil), a.certPEM...)
+}
+
+// Certificate returns the parsed CA certificate.
+func (a *Authority) Certificate() *x509.Certificate {
+	return a.cert
+}
+
+// TTL returns the lifetime of issued certificates.
+func (a *Authority) TTL() time.Duration {
+	return a.ttl
+}
+
+// Issue signs csrPEM for agentID. The subject is set by the authority, not
+// taken from the request: the common name is the agent ID and the
+// organizational units are its groups.
+func (a *Authority) Issue(csrPEM []byte, agentID string, groups []string) (Certificate, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return Certificate{}, errors.New("agent id is required")
+	}
+	csr, err := parseRequest(csrPEM)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	now := a.now().UTC()
+	serial, err := issueSerial(now)
+	if err != nil {
+		return Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         agentID,
+			Organization:       []string{agentOrganization},
+			OrganizationalUnit: append([]string(nil), groups...),
+		},
+		NotBefore:   now.Add(-clockSkew),
+		NotAfter:    now.Add(a.ttl),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, csr.PublicKey, a.key)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("sign agent certificate: %w", err)
+	}
+	return Certificate{
+		AgentID:   agentID,
+		Serial:    serial.Text(16),
+		PEM:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		NotBefore: template.NotBefore,
+		NotAfter:  template.NotAfter,
+	}, nil
+}
+
+// CheckRequest reports whether csrPEM is a well-formed, self-signed
+// certificate signing request, so callers can reject it before spending a
+// join token.
+func CheckRequest(csrPEM []byte) error {
+	_, err := parseRequest(csrPEM)
+	return err
+}
+
+func parseRequest(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, ErrInvalidCSR
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil || csr.CheckSignature() != nil {
+		return nil, ErrInvalidCSR
+	}
+	return csr, nil
+}
+
+// Issued reports whether one of the verified chains ends at this CA.
+func (a *Authority) Issued(chains [][]*x509.Certificate) bool {
+	_, ok := a.chain(chains)
+	return ok
+}
+
+// Identify returns the agent named by the first verified chain that ends at
+// this CA. Chains from other CAs trusted by the listener are ignored.
+func (a *Authority) Identify(chains [][]*x509.Certificate) (Identity, bool) {
+	leaf, ok := a.chain(chains)
+	if !ok {
+		return Identity{}, false
+	}
+	agentID := strings.TrimSpace(leaf.Subject.CommonName)
+	if agentID == "" {
+		return Identity{}, false
+	}
+	return Identity{
+		AgentID:  agentID,
+		Groups:   append([]string(nil), leaf.Subject.OrganizationalUnit...),
+		Serial:   leaf.SerialNumber.Text(16),
+		IssuedAt: issuedAt(leaf),
+		NotAfter: leaf.NotAfter,
+	}, true
+}
+
+func (a *Authority) chain(chains [][]*x509.Certificate) (*x509.Certificate, bool) {
+	if a == nil {
+		return nil, false
+	}
+	for _, chain := range chains {
+		if len(chain) < 2 {
+			continue
+		}
+		if bytes.Equal(chain[len(chain)-1].Raw, a.cert.Raw) {
+			return chain[0], true
+		}
+	}
+	return nil, false
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("agent ca key is not PEM encoded")
+	}
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse agent ca key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("agent ca key cannot sign")
+	}
+	return signer, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// issueSerial returns a serial whose high bits are the issuance time in
+// nanoseconds followed by 64 random bits. Certificate validity is only
+// encoded to the second, so revocations read the exact time from here.
+func issueSerial(now time.Time) (*big.Int, error) {
+	random, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	serial := new(big.Int).Lsh(big.NewInt(now.UnixNano()), 64)
+	return serial.Or(serial, random), nil
+}
+
+// issuedAt reads the issuance time back from the serial of leaf. Serials
+// that carry no plausible time fall back to the end of the back-dated
+// validity start.
+func issuedAt(leaf *x509.Certificate) time.Time {
+	fallback := leaf.NotBefore.Add(clockSkew).UTC()
+	nanos := new(big.Int).Rsh(leaf.SerialNumber, 64)
+	if nanos.IsInt64() {
+		at := time.Unix(0, nanos.Int64()).UTC()
+		if !at.Before(leaf.NotBefore) && at.Before(fallback.Add(time.Second)) {
+			return at
+		}
+	}
+	return fallback
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
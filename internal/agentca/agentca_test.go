@@ -0,0 +1,120 @@
+package agentca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAuthority(t *testing.T) *Authority {
+	t.Helper()
+	certPEM, keyPEM, err := Generate("test agent ca")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	ca, err := New(certPEM, keyPEM, time.Hour)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return ca
+}
+
+func chainsFor(t *testing.T, ca *Authority, certPEM []byte) [][]*x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	chains, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Fatalf("verify certificate: %v", err)
+	}
+	return chains
+}
+
+func TestIssueAndIdentify(t *testing.T) {
+	ca := newTestAuthority(t)
+	_, csrPEM, err := NewRequest("requested-name")
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+
+	before := time.Now()
+	cert, err := ca.Issue(csrPEM, "edge-1", []string{"hk", "premium"})
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	after := time.Now()
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime < time.Hour || lifetime > time.Hour+clockSkew {
+		t.Fatalf("unexpected certificate lifetime %s", lifetime)
+	}
+
+	identity, ok := ca.Identify(chainsFor(t, ca, cert.PEM))
+	if !ok {
+		t.Fatalf("expected the certificate to be identified")
+	}
+	if identity.AgentID != "edge-1" || len(identity.Groups) != 2 || identity.Serial != cert.Serial {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if identity.IssuedAt.Before(before) || identity.IssuedAt.After(after) {
+		t.Fatalf("expected the issuance time to be read from the serial, got %s", identity.IssuedAt)
+	}
+
+	other := newTestAuthority(t)
+	if _, ok := other.Identify(chainsFor(t, ca, cert.PEM)); ok {
+		t.Fatalf("expected a chain from another CA to be ignored")
+	}
+}
+
+func TestIssueRejectsInvalidRequests(t *testing.T) {
+	ca := newTestAuthority(t)
+	if _, err := ca.Issue([]byte("garbage"), "edge-1", nil); !errors.Is(err, ErrInvalidCSR) {
+		t.Fatalf("expected ErrInvalidCSR, got %v", err)
+	}
+	if err := CheckRequest(nil); !errors.Is(err, ErrInvalidCSR) {
+		t.Fatalf("expected ErrInvalidCSR for an empty request, got %v", err)
+	}
+	if _, err := New(ca.CertificatePEM(), nil, 0); err == nil {
+		t.Fatalf("expected a missing key to be rejected")
+	}
+}
+
+func TestLoadOrCreate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca", "agent-ca.crt")
+	keyFile := filepath.Join(dir, "ca", "agent-ca.key")
+
+	created, err := LoadOrCreate(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.TTL() != DefaultCertificateTTL {
+		t.Fatalf("expected default ttl, got %s", created.TTL())
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a private key file, got %v %v", info, err)
+	}
+
+	loaded, err := LoadOrCreate(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !loaded.Certificate().Equal(created.Certificate()) {
+		t.Fatalf("expected the generated CA to be reused")
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatalf("remove key: %v", err)
+	}
+	if _, err := LoadOrCreate(certFile, keyFile, 0); err == nil {
+		t.Fatalf("expected a missing key next to an existing certificate to fail")
+	}
+}
@@ -0,0 +1,227 @@
+package agentmode
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"account/internal/agentca"
+	"account/internal/agentproto"
+)
+
+// certificateRetryInterval spaces out renewal attempts after a failure.
+const certificateRetryInterval = time.Minute
+
+// certificateSource holds the client certificate the agent presents to the
+// controller. It is swapped in place on renewal so the HTTP client picks up
+// the new certificate on its next handshake.
+type certificateSource struct {
+	certFile string
+	keyFile  string
+	now      func() time.Time
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// loadCertificateSource reads the certificate from certFile and keyFile.
+// Missing files are not an error: the agent enrolls and writes them.
+func loadCertificateSource(certFile, keyFile string) (*certificateSource, error) {
+	certFile = strings.TrimSpace(certFile)
+	keyFile = strings.TrimSpace(keyFile)
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("agent.tls.certFile and agent.tls.keyFile must be set together")
+	}
+	s := &certificateSource{certFile: certFile, keyFile: keyFile, now: time.Now}
+
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		return s, nil
+	}
+	if certErr != nil {
+		return nil, fmt.Errorf("read agent certificate: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, fmt.Errorf("read agent key: %w", keyErr)
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	s.cert = cert
+	return s, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate. Without
+// a valid certificate no certificate is sent, which lets the agent enroll.
+func (s *certificateSource) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if !s.Valid() {
+		return &tls.Certificate{}, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// Valid reports whether a certificate is loaded and has not expired.
+func (s *certificateSource) Valid() bool {
+	leaf := s.leaf()
+	return leaf != nil && s.now().Before(leaf.NotAfter)
+}
+
+// AgentID returns the agent named by the certificate.
+func (s *certificateSource) AgentID() string {
+	if leaf := s.leaf(); leaf != nil {
+		return strings.TrimSpace(leaf.Subject.CommonName)
+	}
+	return ""
+}
+
+// NotAfter returns when the certificate expires.
+func (s *certificateSource) NotAfter() time.Time {
+	if leaf := s.leaf(); leaf != nil {
+		return leaf.NotAfter
+	}
+	return time.Time{}
+}
+
+func (s *certificateSource) leaf() *x509.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil
+	}
+	return s.cert.Leaf
+}
+
+// enroll obtains the first certificate with a one-time join token.
+func (s *certificateSource) enroll(ctx context.Context, client *Client, joinToken, agentID string) error {
+	keyPEM, csrPEM, err := agentca.NewRequest(agentID)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Enroll(ctx, agentproto.EnrollRequest{Token: joinToken, CSR: string(csrPEM)})
+	if err != nil {
+		return err
+	}
+	return s.install([]byte(resp.Certificate), keyPEM)
+}
+
+// renew replaces the certificate with a new one and a fresh key.
+func (s *certificateSource) renew(ctx context.Context, client *Client) error {
+	keyPEM, csrPEM, err := agentca.NewRequest(s.AgentID())
+	if err != nil {
+		return err
+	}
+	resp, err := client.RenewCertificate(ctx, agentproto.RenewRequest{CSR: string(csrPEM)})
+	if err != nil {
+		return err
+	}
+	if err := s.install([]byte(resp.Certificate), keyPEM); err != nil {
+		return err
+	}
+	// Pooled connections were authenticated with the previous certificate.
+	client.http.CloseIdleConnections()
+	return nil
+}
+
+// install writes the key and certificate and starts presenting them.
+func (s *certificateSource) install(certPEM, keyPEM []byte) error {
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.keyFile, keyPEM, 0o600); err != nil {
+		return fmt.Errorf("write agent key: %w", err)
+	}
+	if err := writeFileAtomic(s.certFile, certPEM, 0o644); err != nil {
+		return fmt.Errorf("write agent certificate: %w", err)
+	}
+	s.mu.Lock()
+	s.cert = cert
+	s.mu.Unlock()
+	return nil
+}
+
+// rotate renews the certificate renewBefore ahead of its expiry until ctx is
+// cancelled. Failed renewals are retried every certificateRetryInterval while
+// the current certificate is still valid.
+func (s *certificateSource) rotate(ctx context.Context, client *Client, renewBefore time.Duration, logger *slog.Logger) {
+	failed := false
+	for {
+		leaf := s.leaf()
+		if leaf == nil {
+			return
+		}
+		delay := renewalTime(leaf.NotBefore, leaf.NotAfter, renewBefore).Sub(s.now())
+		if failed && delay < certificateRetryInterval {
+			delay = certificateRetryInterval
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := s.renew(ctx, client); err != nil {
+			failed = true
+			logger.Warn("failed to renew agent certificate", "err", err, "expiresAt", leaf.NotAfter)
+			continue
+		}
+		failed = false
+		logger.Info("renewed agent certificate", "expiresAt", s.NotAfter())
+	}
+}
+
+// renewalTime is when a certificate valid from notBefore to notAfter should
+// be renewed. A non-positive renewBefore, or one longer than the lifetime,
+// renews once two thirds of the lifetime have passed.
+func renewalTime(notBefore, notAfter time.Time, renewBefore time.Duration) time.Time {
+	lifetime := notAfter.Sub(notBefore)
+	if renewBefore <= 0 || renewBefore >= lifetime {
+		renewBefore = lifetime / 3
+	}
+	return notAfter.Add(-renewBefore)
+}
+
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load agent certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse agent certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+	return &cert, nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
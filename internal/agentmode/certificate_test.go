@@ -0,0 +1,104 @@
+package agentmode
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"account/internal/agentca"
+	"account/internal/agentproto"
+)
+
+func TestCertificateSourceEnrollsAndReloads(t *testing.T) {
+	caPEM, caKeyPEM, err := agentca.Generate("test agent ca")
+	if err != nil {
+		t.Fatalf("generate ca: %v", err)
+	}
+	ca, err := agentca.New(caPEM, caKeyPEM, time.Hour)
+	if err != nil {
+		t.Fatalf("load ca: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/agent-server/v1/enroll" {
+			http.NotFound(w, r)
+			return
+		}
+		var req agentproto.EnrollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token != "ajt_secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		cert, err := ca.Issue([]byte(req.CSR), "edge-1", nil)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(agentproto.CertificateResponse{AgentID: cert.AgentID, Certificate: string(cert.PEM), ExpiresAt: cert.NotAfter})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "agent.crt")
+	keyFile := filepath.Join(dir, "agent.key")
+	certs, err := loadCertificateSource(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load missing certificate: %v", err)
+	}
+	if certs.Valid() {
+		t.Fatalf("expected no certificate before enrollment")
+	}
+	if cert, err := certs.GetClientCertificate(nil); err != nil || len(cert.Certificate) != 0 {
+		t.Fatalf("expected an empty client certificate before enrollment, got %v %v", cert, err)
+	}
+
+	client, err := NewClient(server.URL, "", ClientOptions{GetClientCertificate: certs.GetClientCertificate})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if err := certs.enroll(context.Background(), client, "ajt_wrong", "edge-1"); err == nil {
+		t.Fatalf("expected enrollment with a wrong token to fail")
+	}
+	if err := certs.enroll(context.Background(), client, "ajt_secret", "edge-1"); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if !certs.Valid() || certs.AgentID() != "edge-1" {
+		t.Fatalf("expected an enrolled certificate for edge-1, got %q", certs.AgentID())
+	}
+
+	reloaded, err := loadCertificateSource(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !reloaded.Valid() || !reloaded.NotAfter().Equal(certs.NotAfter()) {
+		t.Fatalf("expected the certificate to be read back from disk")
+	}
+	reloaded.now = func() time.Time { return certs.NotAfter().Add(time.Second) }
+	if reloaded.Valid() {
+		t.Fatalf("expected an expired certificate to be invalid")
+	}
+}
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(24 * time.Hour)
+
+	cases := []struct {
+		renewBefore time.Duration
+		want        time.Time
+	}{
+		{0, notBefore.Add(16 * time.Hour)},
+		{2 * time.Hour, notAfter.Add(-2 * time.Hour)},
+		{48 * time.Hour, notBefore.Add(16 * time.Hour)},
+	}
+	for _, tc := range cases {
+		if got := renewalTime(notBefore, notAfter, tc.renewBefore); !got.Equal(tc.want) {
+			t.Errorf("renewBefore %s: expected %s, got %s", tc.renewBefore, tc.want, got)
+		}
+	}
+}
@@ -23,6 +23,9 @@ type ClientOptions struct {
 	InsecureSkipVerify bool
 	UserAgent          string
 	AgentID            string
+	// GetClientCertificate supplies the client certificate presented to the
+	// controller. When set, the token may be empty.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
 }
 
 // Client issues authenticated requests against the controller.
@@ -35,6 +38,7 @@ type Client struct {
 }
 
 // NewClient constructs a client for the provided controller URL and token.
+// An agent authenticating with a client certificate may pass an empty token.
 func NewClient(baseURL, token string, opts ClientOptions) (*Client, error) {
 	trimmedURL := strings.TrimSpace(baseURL)
 	if trimmedURL == "" {
@@ -45,7 +49,7 @@ func NewClient(baseURL, token string, opts ClientOptions) (*Client, error) {
 		return nil, fmt.Errorf("parse controller url: %w", err)
 	}
 	token = strings.TrimSpace(token)
-	if token == "" {
+	if token == "" && opts.GetClientCertificate == nil {
 		return nil, errors.New("controller token is required")
 	}
 
@@ -57,11 +61,12 @@ func NewClient(baseURL, token string, opts ClientOptions) (*Client, error) {
 	transport := http.DefaultTransport
 	if t, ok := transport.(*http.Transport); ok {
 		clone := t.Clone()
-		if opts.InsecureSkipVerify {
+		if opts.InsecureSkipVerify || opts.GetClientCertificate != nil {
 			if clone.TLSClientConfig == nil {
 				clone.TLSClientConfig = &tls.Config{}
 			}
-			clone.TLSClientConfig.InsecureSkipVerify = true
+			clone.TLSClientConfig.InsecureSkipVerify = opts.InsecureSkipVerify
+			clone.TLSClientConfig.GetClientCertificate = opts.GetClientCertificate
 		}
 		transport = clone
 	}
@@ -149,8 +154,108 @@ func (c *Client) ReportStatus(ctx context.Context, report agentproto.StatusRepor
 	return nil
 }
 
+// ReportTraffic submits cumulative per-user traffic counters to the
+// controller. Rejections of the payload itself are returned as
+// *ControllerError so callers can tell them apart from transient failures.
+func (c *Client) ReportTraffic(ctx context.Context, report agentproto.TrafficReport) error {
+	endpoint, err := url.JoinPath(c.baseURL.String(), "/api/agent-server/v1/traffic")
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("encode traffic report: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	c.applyHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<14))
+		return &ControllerError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
+
+// Enroll exchanges a join token and a certificate signing request for the
+// agent's first client certificate.
+func (c *Client) Enroll(ctx context.Context, request agentproto.EnrollRequest) (agentproto.CertificateResponse, error) {
+	return c.requestCertificate(ctx, "/api/agent-server/v1/enroll", request)
+}
+
+// RenewCertificate requests a new client certificate. The request must be
+// authenticated with the current, still valid certificate.
+func (c *Client) RenewCertificate(ctx context.Context, request agentproto.RenewRequest) (agentproto.CertificateResponse, error) {
+	return c.requestCertificate(ctx, "/api/agent-server/v1/certificate", request)
+}
+
+func (c *Client) requestCertificate(ctx context.Context, path string, payload any) (agentproto.CertificateResponse, error) {
+	endpoint, err := url.JoinPath(c.baseURL.String(), path)
+	if err != nil {
+		return agentproto.CertificateResponse{}, err
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return agentproto.CertificateResponse{}, fmt.Errorf("encode certificate request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(buf))
+	if err != nil {
+		return agentproto.CertificateResponse{}, err
+	}
+	c.applyHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return agentproto.CertificateResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<14))
+		return agentproto.CertificateResponse{}, &ControllerError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+
+	var certificate agentproto.CertificateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&certificate); err != nil {
+		return agentproto.CertificateResponse{}, fmt.Errorf("decode certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+// ControllerError describes a non-2xx response returned by the controller.
+type ControllerError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *ControllerError) Error() string {
+	return fmt.Sprintf("controller returned %s: %s", e.Status, e.Body)
+}
+
+// Permanent reports whether retrying the same request cannot succeed.
+func (e *ControllerError) Permanent() bool {
+	return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+}
+
 func (c *Client) applyHeaders(req *http.Request) {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 	req.Header.Set("User-Agent", c.userAgent)
 	if c.agentID != "" {
 		req.Header.Set("X-Agent-ID", c.agentID)
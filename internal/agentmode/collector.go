@@ -0,0 +1,196 @@
+package agentmode
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"account/internal/agentproto"
+)
+
+const (
+	defaultStatsInterval    = time.Minute
+	defaultDeliveryAttempts = 3
+	defaultDeliveryBackoff  = 2 * time.Second
+)
+
+type trafficReporter interface {
+	ReportTraffic(ctx context.Context, report agentproto.TrafficReport) error
+}
+
+// trafficCollector periodically samples per-user Xray counters and ships them
+// to the controller. Deliveries are retried with backoff; reports that still
+// fail are spooled and replayed in order before the next fresh sample.
+type trafficCollector struct {
+	reporter trafficReporter
+	querier  StatsQuerier
+	spool    *trafficSpool
+	logger   *slog.Logger
+
+	nodeID   string
+	region   string
+	lineCode string
+
+	attempts int
+	backoff  time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	last       map[string]agentproto.UserTraffic
+	resetEpoch int64
+}
+
+func newTrafficCollector(reporter trafficReporter, querier StatsQuerier, spool *trafficSpool, logger *slog.Logger) *trafficCollector {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &trafficCollector{
+		reporter: reporter,
+		querier:  querier,
+		spool:    spool,
+		logger:   logger,
+		attempts: defaultDeliveryAttempts,
+		backoff:  defaultDeliveryBackoff,
+		now:      time.Now,
+		last:     make(map[string]agentproto.UserTraffic),
+	}
+}
+
+func (c *trafficCollector) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.collect(ctx); err != nil && ctx.Err() == nil {
+				c.logger.Warn("failed to ship xray traffic stats", "err", err, "spooled", c.spool.Len())
+			}
+		}
+	}
+}
+
+// collect takes one sample and delivers it together with any spooled
+// reports.
+func (c *trafficCollector) collect(ctx context.Context) error {
+	stats, err := c.querier.QueryUserTraffic(ctx)
+	if err != nil {
+		return err
+	}
+	report := c.buildReport(stats)
+
+	if err := c.flush(ctx); err != nil {
+		if spoolErr := c.spool.Push(report); spoolErr != nil {
+			return errors.Join(err, spoolErr)
+		}
+		return err
+	}
+	if err := c.deliver(ctx, report); err != nil {
+		var controllerErr *ControllerError
+		if errors.As(err, &controllerErr) && controllerErr.Permanent() {
+			return err
+		}
+		if spoolErr := c.spool.Push(report); spoolErr != nil {
+			return errors.Join(err, spoolErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// buildReport stamps the sample and bumps the reset epoch when any counter
+// went backwards since the previous sample, which happens whenever Xray is
+// restarted.
+func (c *trafficCollector) buildReport(stats []agentproto.UserTraffic) agentproto.TrafficReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reset := false
+	current := make(map[string]agentproto.UserTraffic, len(stats))
+	for _, entry := range stats {
+		if previous, ok := c.last[entry.Email]; ok {
+			if entry.UplinkTotal < previous.UplinkTotal || entry.DownlinkTotal < previous.DownlinkTotal {
+				reset = true
+			}
+		}
+		current[entry.Email] = entry
+	}
+	if reset {
+		c.resetEpoch++
+	}
+	c.last = current
+
+	return agentproto.TrafficReport{
+		NodeID:     c.nodeID,
+		Region:     c.region,
+		LineCode:   c.lineCode,
+		ResetEpoch: c.resetEpoch,
+		SampledAt:  c.now().UTC(),
+		Stats:      append([]agentproto.UserTraffic(nil), stats...),
+	}
+}
+
+func (c *trafficCollector) flush(ctx context.Context) error {
+	pending, err := c.spool.Pending()
+	if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		if err := c.deliver(ctx, entry.Report); err != nil {
+			var controllerErr *ControllerError
+			if !errors.As(err, &controllerErr) || !controllerErr.Permanent() {
+				return err
+			}
+			c.logger.Warn("dropping spooled traffic report rejected by controller", "sampledAt", entry.Report.SampledAt, "err", err)
+		}
+		if err := c.spool.Ack(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *trafficCollector) deliver(ctx context.Context, report agentproto.TrafficReport) error {
+	attempts := c.attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	backoff := c.backoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = c.reporter.ReportTraffic(ctx, report)
+		if err == nil {
+			return nil
+		}
+		var controllerErr *ControllerError
+		if errors.As(err, &controllerErr) && controllerErr.Permanent() {
+			return err
+		}
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+func statsNodeID(configured, agentID string) string {
+	if id := strings.TrimSpace(configured); id != "" {
+		return id
+	}
+	return strings.TrimSpace(agentID)
+}
@@ -0,0 +1,147 @@
+package agentmode
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"account/internal/agentproto"
+)
+
+func TestParseStatsQueryOutput(t *testing.T) {
+	payload := []byte(`{"stat":[
+		{"name":"user>>>Alice@example.com>>>traffic>>>uplink","value":"120"},
+		{"name":"user>>>alice@example.com>>>traffic>>>downlink","value":340},
+		{"name":"user>>>bob@example.com>>>traffic>>>downlink"},
+		{"name":"inbound>>>api>>>traffic>>>uplink","value":"9"}
+	]}`)
+
+	stats, err := parseStatsQueryOutput(payload)
+	if err != nil {
+		t.Fatalf("parse stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 users, got %#v", stats)
+	}
+	if stats[0] != (agentproto.UserTraffic{Email: "alice@example.com", UplinkTotal: 120, DownlinkTotal: 340}) {
+		t.Fatalf("unexpected alice stats: %#v", stats[0])
+	}
+	if stats[1] != (agentproto.UserTraffic{Email: "bob@example.com"}) {
+		t.Fatalf("unexpected bob stats: %#v", stats[1])
+	}
+}
+
+func TestCommandStatsQuerierBuildsStatsQueryInvocation(t *testing.T) {
+	var got []string
+	querier := NewCommandStatsQuerier(nil, "")
+	querier.runner = func(_ context.Context, cmd []string) ([]byte, error) {
+		got = cmd
+		return []byte(`{}`), nil
+	}
+	if _, err := querier.QueryUserTraffic(context.Background()); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	want := []string{"xray", "api", "statsquery", "--server=127.0.0.1:10085", "-pattern", "user>>>"}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected command %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected command %v", got)
+		}
+	}
+}
+
+func TestTrafficCollectorSpoolsAndReplaysReports(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		healthy  bool
+		received []agentproto.TrafficReport
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/agent-server/v1/traffic" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var report agentproto.TrafficReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("decode report: %v", err)
+		}
+		received = append(received, report)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "agent-token", ClientOptions{AgentID: "edge-1"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	spool, err := newTrafficSpool(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("new spool: %v", err)
+	}
+
+	samples := [][]agentproto.UserTraffic{
+		{{Email: "a@example.com", UplinkTotal: 100, DownlinkTotal: 200}},
+		{{Email: "a@example.com", UplinkTotal: 150, DownlinkTotal: 260}},
+		{{Email: "a@example.com", UplinkTotal: 10, DownlinkTotal: 20}},
+	}
+	next := 0
+	querier := StatsQuerierFunc(func(context.Context) ([]agentproto.UserTraffic, error) {
+		sample := samples[next]
+		next++
+		return sample, nil
+	})
+
+	collector := newTrafficCollector(client, querier, spool, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	collector.nodeID = "edge-1"
+	collector.backoff = 0
+
+	ctx := context.Background()
+	if err := collector.collect(ctx); err == nil {
+		t.Fatalf("expected delivery failure while controller is down")
+	}
+	if err := collector.collect(ctx); err == nil {
+		t.Fatalf("expected delivery failure while controller is down")
+	}
+	if spool.Len() != 2 {
+		t.Fatalf("expected 2 spooled reports, got %d", spool.Len())
+	}
+
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+	if err := collector.collect(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if spool.Len() != 0 {
+		t.Fatalf("expected spool to drain, got %d", spool.Len())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 3 {
+		t.Fatalf("expected 3 delivered reports, got %d", len(received))
+	}
+	for i, report := range received {
+		if report.NodeID != "edge-1" {
+			t.Fatalf("report %d: unexpected node id %q", i, report.NodeID)
+		}
+		if report.Stats[0].UplinkTotal != samples[i][0].UplinkTotal {
+			t.Fatalf("report %d delivered out of order: %#v", i, report)
+		}
+	}
+	if received[1].ResetEpoch != 0 || received[2].ResetEpoch != 1 {
+		t.Fatalf("expected counter drop to bump reset epoch, got %d then %d", received[1].ResetEpoch, received[2].ResetEpoch)
+	}
+}
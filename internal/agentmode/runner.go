@@ -20,6 +20,9 @@ type Options struct {
 	Logger *slog.Logger
 	Agent  config.Agent
 	Xray   config.Xray
+	// StatsQuerier overrides how per-user traffic counters are read when
+	// agent.stats is enabled. When nil the xray CLI is used.
+	StatsQuerier StatsQuerier
 }
 
 // Run launches the agent mode control loop. It blocks until the context is
@@ -39,8 +42,16 @@ func Run(ctx context.Context, opts Options) error {
 		return errors.New("agent.controllerUrl is required")
 	}
 	token := strings.TrimSpace(opts.Agent.APIToken)
-	if token == "" {
-		return errors.New("agent.apiToken is required")
+	var certs *certificateSource
+	if opts.Agent.TLS.CertFile != "" || opts.Agent.TLS.KeyFile != "" {
+		var err error
+		certs, err = loadCertificateSource(opts.Agent.TLS.CertFile, opts.Agent.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+	}
+	if token == "" && certs == nil {
+		return errors.New("agent.apiToken or agent.tls.certFile is required")
 	}
 
 	syncInterval := opts.Agent.SyncInterval
@@ -66,19 +77,57 @@ func Run(ctx context.Context, opts Options) error {
 		outputPath = "/usr/local/etc/xray/config.json"
 	}
 
-	client, err := NewClient(controllerURL, token, ClientOptions{
+	clientOpts := ClientOptions{
 		Timeout:            httpTimeout,
 		InsecureSkipVerify: opts.Agent.TLS.InsecureSkipVerify,
 		UserAgent:          buildUserAgent(opts.Agent.ID),
 		AgentID:            opts.Agent.ID,
-	})
+	}
+	if certs != nil {
+		clientOpts.GetClientCertificate = certs.GetClientCertificate
+	}
+	client, err := NewClient(controllerURL, token, clientOpts)
 	if err != nil {
 		return err
 	}
 
+	agentID := opts.Agent.ID
+	if certs != nil {
+		if !certs.Valid() {
+			joinToken := strings.TrimSpace(opts.Agent.TLS.JoinToken)
+			if joinToken == "" {
+				return errors.New("agent.tls.joinToken is required to enroll the agent certificate")
+			}
+			if err := certs.enroll(ctx, client, joinToken, opts.Agent.ID); err != nil {
+				return fmt.Errorf("enroll agent certificate: %w", err)
+			}
+			logger.Info("enrolled agent certificate", "agentId", certs.AgentID(), "expiresAt", certs.NotAfter())
+		}
+		// The certificate names the agent; the controller rejects any other ID.
+		agentID = certs.AgentID()
+		client.agentID = agentID
+	}
+
 	tracker := newSyncTracker()
 	source := NewHTTPClientSource(client, tracker)
 
+	statsCfg := opts.Agent.Stats
+	var collector *trafficCollector
+	if statsCfg.Enabled {
+		querier := opts.StatsQuerier
+		if querier == nil {
+			querier = NewCommandStatsQuerier(statsCfg.Command, statsCfg.APIServer)
+		}
+		spool, err := newTrafficSpool(statsCfg.SpoolDir, 0)
+		if err != nil {
+			return err
+		}
+		collector = newTrafficCollector(client, querier, spool, logger.With("component", "agent-xray-stats"))
+		collector.nodeID = statsNodeID(statsCfg.NodeID, agentID)
+		collector.region = strings.TrimSpace(statsCfg.Region)
+		collector.lineCode = strings.TrimSpace(statsCfg.LineCode)
+	}
+
 	generators := []xrayconfig.Generator{
 		{
 			Definition: xrayconfig.XHTTPDefinition(),
@@ -147,8 +196,22 @@ func Run(ctx context.Context, opts Options) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runStatusReporter(reporterCtx, client, tracker, statusInterval, syncInterval, opts.Agent.ID, logger)
+		runStatusReporter(reporterCtx, client, tracker, statusInterval, syncInterval, agentID, statsCfg, logger)
 	}()
+	if certs != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs.rotate(reporterCtx, client, opts.Agent.TLS.RenewBefore, logger.With("component", "agent-certificate"))
+		}()
+	}
+	if collector != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collector.run(reporterCtx, statsCfg.Interval)
+		}()
+	}
 
 	<-ctx.Done()
 	reporterCancel()
@@ -164,13 +227,13 @@ func buildUserAgent(id string) string {
 	return fmt.Sprintf("xcontrol-agent/%s", id)
 }
 
-func runStatusReporter(ctx context.Context, client *Client, tracker *syncTracker, interval, syncInterval time.Duration, agentID string, logger *slog.Logger) {
+func runStatusReporter(ctx context.Context, client *Client, tracker *syncTracker, interval, syncInterval time.Duration, agentID string, stats config.AgentStats, logger *slog.Logger) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	send := func() {
 		snapshot := tracker.Snapshot()
-		report := buildStatusReport(snapshot, syncInterval, agentID)
+		report := buildStatusReport(snapshot, syncInterval, agentID, stats)
 		if err := client.ReportStatus(ctx, report); err != nil {
 			logger.Warn("failed to report agent status", "err", err)
 		}
@@ -188,7 +251,7 @@ func runStatusReporter(ctx context.Context, client *Client, tracker *syncTracker
 	}
 }
 
-func buildStatusReport(snapshot trackerSnapshot, syncInterval time.Duration, agentID string) agentproto.StatusReport {
+func buildStatusReport(snapshot trackerSnapshot, syncInterval time.Duration, agentID string, stats config.AgentStats) agentproto.StatusReport {
 	healthy := snapshot.LastError == "" && !snapshot.LastSuccess.IsZero()
 
 	running := false
@@ -215,8 +278,14 @@ func buildStatusReport(snapshot trackerSnapshot, syncInterval time.Duration, age
 				copy := *lastSyncPtr
 				return &copy
 			}(),
+			StatsEnabled: stats.Enabled,
 		},
 	}
+	if stats.Enabled {
+		report.Xray.NodeID = statsNodeID(stats.NodeID, agentID)
+		report.Xray.Region = strings.TrimSpace(stats.Region)
+		report.Xray.LineCode = strings.TrimSpace(stats.LineCode)
+	}
 
 	return report
 }
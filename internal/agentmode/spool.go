@@ -0,0 +1,169 @@
+package agentmode
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"account/internal/agentproto"
+)
+
+const (
+	defaultSpoolLimit = 1440
+	spoolFilePrefix   = "traffic-"
+	spoolFileSuffix   = ".json"
+)
+
+// trafficSpool buffers traffic reports the controller could not accept. With
+// a directory configured the reports survive agent restarts; otherwise they
+// are kept in memory. The oldest reports are dropped once limit is reached.
+type trafficSpool struct {
+	mu      sync.Mutex
+	dir     string
+	limit   int
+	pending []agentproto.TrafficReport
+}
+
+type spooledReport struct {
+	Path   string
+	Report agentproto.TrafficReport
+}
+
+func newTrafficSpool(dir string, limit int) (*trafficSpool, error) {
+	if limit <= 0 {
+		limit = defaultSpoolLimit
+	}
+	dir = strings.TrimSpace(dir)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create traffic spool dir: %w", err)
+		}
+	}
+	return &trafficSpool{dir: dir, limit: limit}, nil
+}
+
+// Push appends a report to the spool.
+func (s *trafficSpool) Push(report agentproto.TrafficReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		s.pending = append(s.pending, report)
+		if overflow := len(s.pending) - s.limit; overflow > 0 {
+			s.pending = append([]agentproto.TrafficReport(nil), s.pending[overflow:]...)
+		}
+		return nil
+	}
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("encode spooled report: %w", err)
+	}
+	name := fmt.Sprintf("%s%020d%s", spoolFilePrefix, time.Now().UTC().UnixNano(), spoolFileSuffix)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, payload, 0o600); err != nil {
+		return fmt.Errorf("write spooled report: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("commit spooled report: %w", err)
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for len(files) > s.limit {
+		_ = os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// Pending returns the spooled reports, oldest first.
+func (s *trafficSpool) Pending() ([]spooledReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		result := make([]spooledReport, 0, len(s.pending))
+		for _, report := range s.pending {
+			result = append(result, spooledReport{Report: report})
+		}
+		return result, nil
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]spooledReport, 0, len(files))
+	for _, path := range files {
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read spooled report: %w", err)
+		}
+		var report agentproto.TrafficReport
+		if err := json.Unmarshal(payload, &report); err != nil {
+			// A truncated file can never be delivered; drop it.
+			_ = os.Remove(path)
+			continue
+		}
+		result = append(result, spooledReport{Path: path, Report: report})
+	}
+	return result, nil
+}
+
+// Ack removes a delivered report from the spool.
+func (s *trafficSpool) Ack(entry spooledReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		if len(s.pending) > 0 {
+			s.pending = s.pending[1:]
+		}
+		return nil
+	}
+	if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove spooled report: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of spooled reports.
+func (s *trafficSpool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		return len(s.pending)
+	}
+	files, err := s.files()
+	if err != nil {
+		return 0
+	}
+	return len(files)
+}
+
+func (s *trafficSpool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("list traffic spool: %w", err)
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, spoolFilePrefix) || !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(s.dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
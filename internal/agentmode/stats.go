@@ -0,0 +1,167 @@
+package agentmode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"account/internal/agentproto"
+)
+
+const (
+	defaultStatsAPIServer = "127.0.0.1:10085"
+	userStatPrefix        = "user>>>"
+)
+
+// StatsQuerier reads cumulative per-user traffic counters from the local Xray
+// instance. Implementations must not reset the counters.
+type StatsQuerier interface {
+	QueryUserTraffic(ctx context.Context) ([]agentproto.UserTraffic, error)
+}
+
+// StatsQuerierFunc adapts a function to the StatsQuerier interface.
+type StatsQuerierFunc func(ctx context.Context) ([]agentproto.UserTraffic, error)
+
+// QueryUserTraffic implements StatsQuerier.
+func (f StatsQuerierFunc) QueryUserTraffic(ctx context.Context) ([]agentproto.UserTraffic, error) {
+	return f(ctx)
+}
+
+// CommandStatsQuerier queries the Xray StatsService through the xray CLI
+// ("xray api statsquery"), which keeps the agent free of the Xray gRPC
+// client while still reading the same counters.
+type CommandStatsQuerier struct {
+	// Command is the CLI invocation up to, but excluding, the flags. When
+	// empty it defaults to ["xray", "api", "statsquery"].
+	Command []string
+	// Server is the address of the Xray API inbound.
+	Server string
+
+	runner func(ctx context.Context, cmd []string) ([]byte, error)
+}
+
+// NewCommandStatsQuerier constructs a querier for the provided command and
+// Xray API address.
+func NewCommandStatsQuerier(command []string, server string) *CommandStatsQuerier {
+	return &CommandStatsQuerier{Command: append([]string(nil), command...), Server: server}
+}
+
+// QueryUserTraffic implements StatsQuerier.
+func (q *CommandStatsQuerier) QueryUserTraffic(ctx context.Context) ([]agentproto.UserTraffic, error) {
+	cmd := append([]string(nil), q.Command...)
+	if len(cmd) == 0 {
+		cmd = []string{"xray", "api", "statsquery"}
+	}
+	server := strings.TrimSpace(q.Server)
+	if server == "" {
+		server = defaultStatsAPIServer
+	}
+	cmd = append(cmd, "--server="+server, "-pattern", userStatPrefix)
+
+	runner := q.runner
+	if runner == nil {
+		runner = runStatsCommand
+	}
+	output, err := runner(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("query xray stats: %w", err)
+	}
+	return parseStatsQueryOutput(output)
+}
+
+func runStatsCommand(ctx context.Context, cmd []string) ([]byte, error) {
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	output, err := c.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// parseStatsQueryOutput decodes the JSON printed by "xray api statsquery" and
+// folds the "user>>>{email}>>>traffic>>>{uplink|downlink}" counters into one
+// entry per user. int64 values are rendered as strings by protojson, so both
+// string and numeric values are accepted.
+func parseStatsQueryOutput(payload []byte) ([]agentproto.UserTraffic, error) {
+	var response struct {
+		Stat []struct {
+			Name  string          `json:"name"`
+			Value json.RawMessage `json:"value"`
+		} `json:"stat"`
+	}
+	if len(bytes.TrimSpace(payload)) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return nil, fmt.Errorf("decode xray stats: %w", err)
+	}
+
+	byEmail := make(map[string]*agentproto.UserTraffic)
+	for _, stat := range response.Stat {
+		parts := strings.Split(stat.Name, ">>>")
+		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+			continue
+		}
+		email := strings.ToLower(strings.TrimSpace(parts[1]))
+		if email == "" {
+			continue
+		}
+		value, err := parseStatValue(stat.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decode xray stat %s: %w", stat.Name, err)
+		}
+		entry, ok := byEmail[email]
+		if !ok {
+			entry = &agentproto.UserTraffic{Email: email}
+			byEmail[email] = entry
+		}
+		switch parts[3] {
+		case "uplink":
+			entry.UplinkTotal = value
+		case "downlink":
+			entry.DownlinkTotal = value
+		}
+	}
+
+	result := make([]agentproto.UserTraffic, 0, len(byEmail))
+	for _, entry := range byEmail {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Email < result[j].Email
+	})
+	return result, nil
+}
+
+func parseStatValue(raw json.RawMessage) (int64, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" || trimmed == "null" {
+		return 0, nil
+	}
+	if strings.HasPrefix(trimmed, `"`) {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return 0, err
+		}
+		trimmed = text
+	}
+	value, err := strconv.ParseInt(trimmed, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, errors.New("negative counter")
+	}
+	return value, nil
+}
@@ -39,3 +39,44 @@ type XrayStatus struct {
 	StatsEnabled bool       `json:"statsEnabled"`
 	XrayRevision string     `json:"xrayRevision,omitempty"`
 }
+
+// TrafficReport carries cumulative per-user Xray traffic counters sampled on
+// a node. The controller converts consecutive reports into per-minute deltas.
+type TrafficReport struct {
+	NodeID       string        `json:"nodeId,omitempty"`
+	Region       string        `json:"region,omitempty"`
+	LineCode     string        `json:"lineCode,omitempty"`
+	XrayRevision string        `json:"xrayRevision,omitempty"`
+	ResetEpoch   int64         `json:"resetEpoch"`
+	SampledAt    time.Time     `json:"sampledAt"`
+	Stats        []UserTraffic `json:"stats"`
+}
+
+// UserTraffic holds the cumulative uplink and downlink byte counters of a
+// single Xray client, identified by the email used in the Xray config.
+type UserTraffic struct {
+	Email         string `json:"email"`
+	UplinkTotal   int64  `json:"uplinkTotal"`
+	DownlinkTotal int64  `json:"downlinkTotal"`
+}
+
+// EnrollRequest exchanges a one-time join token and a PEM encoded
+// certificate signing request for the agent's first client certificate.
+type EnrollRequest struct {
+	Token string `json:"token"`
+	CSR   string `json:"csr"`
+}
+
+// RenewRequest asks for a new client certificate. It is sent over mTLS with
+// the current certificate.
+type RenewRequest struct {
+	CSR string `json:"csr"`
+}
+
+// CertificateResponse carries a client certificate issued by the agent CA.
+type CertificateResponse struct {
+	AgentID       string    `json:"agentId"`
+	Certificate   string    `json:"certificate"`
+	CACertificate string    `json:"caCertificate"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
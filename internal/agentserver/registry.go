@@ -3,6 +3,7 @@ package agentserver
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"sort"
 	"strings"
@@ -32,6 +33,16 @@ type Identity struct {
 	ID     string
 	Name   string
 	Groups []string
+	// Bound is set when the credential names a single agent, such as a
+	// managed agent token or a client certificate, and so cannot act for
+	// other agent IDs.
+	Bound bool
+}
+
+// storedToken is a managed agent token loaded from the store.
+type storedToken struct {
+	agentID   string
+	expiresAt *time.Time
 }
 
 // StatusSnapshot captures the last reported status for an agent.
@@ -45,6 +56,7 @@ type StatusSnapshot struct {
 type Registry struct {
 	mu            sync.RWMutex
 	credentials   map[[32]byte]Identity
+	stored        map[[32]byte]storedToken
 	byID          map[string]Identity
 	statuses      map[string]StatusSnapshot
 	sandboxAgents map[string]bool
@@ -57,6 +69,7 @@ type Registry struct {
 func NewRegistry(cfg Config) (*Registry, error) {
 	r := &Registry{
 		credentials:   make(map[[32]byte]Identity),
+		stored:        make(map[[32]byte]storedToken),
 		byID:          make(map[string]Identity),
 		statuses:      make(map[string]StatusSnapshot),
 		sandboxAgents: make(map[string]bool),
@@ -110,7 +123,8 @@ func (r *Registry) SetLogger(logger *slog.Logger) {
 }
 
 // Authenticate validates the provided token and returns the associated agent
-// identity when successful.
+// identity when successful. Configured credentials are checked first, then
+// the unexpired tokens of managed agents loaded from the store.
 func (r *Registry) Authenticate(token string) (*Identity, bool) {
 	token = strings.TrimSpace(token)
 	if token == "" {
@@ -119,14 +133,22 @@ func (r *Registry) Authenticate(token string) (*Identity, bool) {
 	digest := sha256.Sum256([]byte(token))
 
 	r.mu.RLock()
-	identity, ok := r.credentials[digest]
-	r.mu.RUnlock()
+	defer r.mu.RUnlock()
+	if identity, ok := r.credentials[digest]; ok {
+		copy := identity
+		return &copy, true
+	}
+
+	stored, ok := r.stored[digest]
+	if !ok || (stored.expiresAt != nil && !time.Now().Before(*stored.expiresAt)) {
+		return nil, false
+	}
+	identity, ok := r.byID[stored.agentID]
 	if !ok {
 		return nil, false
 	}
-
-	copy := identity
-	return &copy, true
+	identity.Bound = true
+	return &identity, true
 }
 
 // ReportStatus records the status report for the provided agent identity.
@@ -205,7 +227,9 @@ func (r *Registry) RegisterAgent(agentID string, groups []string) Identity {
 	return identity
 }
 
-// Load populates the registry from the persistence store.
+// Load populates the registry from the persistence store. The tokens of
+// managed agents are replaced with the stored set, so rotated and revoked
+// tokens stop working once the registry reloads.
 func (r *Registry) Load(ctx context.Context) error {
 	if r.store == nil {
 		return nil
@@ -214,9 +238,35 @@ func (r *Registry) Load(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	tokens, err := r.store.ListAgentTokens(ctx, "")
+	if err != nil {
+		return err
+	}
+	stored := make(map[[32]byte]storedToken, len(tokens))
+	for _, token := range tokens {
+		raw, err := hex.DecodeString(token.TokenHash)
+		if err != nil || len(raw) != sha256.Size {
+			r.logger.Warn("skipping agent token with malformed hash", "agent", token.AgentID, "token", token.ID)
+			continue
+		}
+		var digest [32]byte
+		copy(digest[:], raw)
+		stored[digest] = storedToken{agentID: token.AgentID, expiresAt: token.ExpiresAt}
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.stored = stored
+	managed := make(map[string]bool, len(stored))
+	for _, token := range stored {
+		managed[token.agentID] = true
+	}
 	for _, a := range agents {
+		if managed[a.ID] {
+			// The store is authoritative for managed agents, so edits made
+			// through the admin API replace the identity held in memory.
+			r.byID[a.ID] = Identity{ID: a.ID, Name: a.Name, Groups: normalizeStrings(a.Groups)}
+		}
 		if _, exists := r.byID[a.ID]; !exists {
 			identity := Identity{
 				ID:     a.ID,
@@ -244,6 +294,26 @@ func (r *Registry) Load(ctx context.Context) error {
 	return nil
 }
 
+// RemoveAgent forgets a deleted agent together with its status and managed
+// tokens. Agents defined in configuration keep their credentials.
+func (r *Registry) RemoveAgent(agentID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.statuses, agentID)
+	for digest, token := range r.stored {
+		if token.agentID == agentID {
+			delete(r.stored, digest)
+		}
+	}
+	for _, identity := range r.credentials {
+		if identity.ID == agentID {
+			return
+		}
+	}
+	delete(r.byID, agentID)
+}
+
 // Statuses returns the latest status snapshot for all agents sorted by ID.
 func (r *Registry) Statuses() []StatusSnapshot {
 	r.mu.RLock()
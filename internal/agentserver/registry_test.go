@@ -1,10 +1,14 @@
 package agentserver
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 	"time"
 
 	"account/internal/agentproto"
+	"account/internal/store"
 )
 
 func TestNewRegistryValidation(t *testing.T) {
@@ -102,3 +106,56 @@ func TestRegistryAuthenticateAndStatus(t *testing.T) {
 		t.Fatalf("expected updated timestamp to be after initial time")
 	}
 }
+
+func TestRegistryLoadsManagedAgentTokens(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	if err := st.CreateAgent(ctx, &store.Agent{ID: "edge", Name: "Edge", Groups: []string{"hk"}}); err != nil {
+		t.Fatalf("create agent: %v", err)
+	}
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	expired := time.Now().Add(-time.Minute)
+	for _, token := range []*store.AgentToken{
+		{AgentID: "edge", TokenHash: hash("agt_current")},
+		{AgentID: "edge", TokenHash: hash("agt_old"), ExpiresAt: &expired},
+	} {
+		if err := st.CreateAgentToken(ctx, token); err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+	}
+
+	registry, err := NewRegistry(Config{Credentials: []Credential{{ID: "*", Token: "shared"}}})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	registry.SetStore(st)
+	if _, ok := registry.Authenticate("agt_current"); ok {
+		t.Fatalf("expected stored tokens to be unknown before Load")
+	}
+	if err := registry.Load(ctx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	identity, ok := registry.Authenticate("agt_current")
+	if !ok || identity.ID != "edge" || !identity.Bound || len(identity.Groups) != 1 {
+		t.Fatalf("expected a bound identity for edge, got %+v %v", identity, ok)
+	}
+	if _, ok := registry.Authenticate("agt_old"); ok {
+		t.Fatalf("expected an expired token to be rejected")
+	}
+	if shared, ok := registry.Authenticate("shared"); !ok || shared.Bound {
+		t.Fatalf("expected the configured shared credential to stay unbound, got %+v %v", shared, ok)
+	}
+
+	registry.RemoveAgent("edge")
+	if _, ok := registry.Authenticate("agt_current"); ok {
+		t.Fatalf("expected a removed agent's token to be rejected")
+	}
+	registry.RemoveAgent("*")
+	if _, ok := registry.Authenticate("shared"); !ok {
+		t.Fatalf("expected configured credentials to survive RemoveAgent")
+	}
+}
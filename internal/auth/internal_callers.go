@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// InternalServiceTokenHeader carries an internal caller's token.
+const InternalServiceTokenHeader = "X-Service-Token"
+
+// LegacyInternalCallerName names the caller built from INTERNAL_SERVICE_TOKEN.
+const LegacyInternalCallerName = "legacy"
+
+// Ways an internal caller can authenticate.
+const (
+	InternalAuthToken = "token"
+	InternalAuthMTLS  = "mtls"
+)
+
+// InternalCaller describes a service allowed to call the internal API.
+type InternalCaller struct {
+	Name string
+	// Tokens are accepted in the X-Service-Token header. Several tokens can
+	// be valid at once so a secret can be rotated without downtime.
+	Tokens []InternalCallerToken
+	// Identities are verified client certificate names (subject common
+	// name, DNS or URI SANs) that authenticate the caller over mTLS.
+	Identities []string
+	// Routes lists the routes the caller may use, as "/path" or
+	// "METHOD /path" with gin route templates such as
+	// /api/internal/policy/:accountUUID. A trailing "*" matches any suffix
+	// and "*" on its own matches every route.
+	Routes []string
+}
+
+// InternalCallerToken is one token of an internal caller. Either Token or
+// SHA256, the hex digest of the token, must be set. NotBefore and NotAfter
+// bound the window in which the token is accepted; zero values leave that
+// side open.
+type InternalCallerToken struct {
+	Token     string
+	SHA256    string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// InternalIdentity is an authenticated internal caller.
+type InternalIdentity struct {
+	Caller string
+	Method string
+}
+
+// ErrInternalCallerUnknown is returned when a request carries no credential
+// that matches a configured internal caller.
+var ErrInternalCallerUnknown = errors.New("unknown internal caller")
+
+// InternalCallers authenticates internal callers and checks which routes
+// they may use.
+type InternalCallers struct {
+	callers []internalCaller
+	ignored [][]byte
+	now     func() time.Time
+}
+
+type internalCaller struct {
+	name       string
+	tokens     []internalCallerToken
+	identities map[string]struct{}
+	routes     []internalRoute
+}
+
+type internalCallerToken struct {
+	digest    [sha256.Size]byte
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+type internalRoute struct {
+	method string
+	path   string
+	prefix bool
+}
+
+// NewInternalCallers validates callers and builds a registry. Caller names
+// must be unique and every caller needs at least one token or identity and
+// one route.
+func NewInternalCallers(callers []InternalCaller) (*InternalCallers, error) {
+	r := &InternalCallers{now: time.Now}
+	seenNames := make(map[string]struct{}, len(callers))
+	seenTokens := make(map[[sha256.Size]byte]struct{})
+	for _, caller := range callers {
+		name := strings.TrimSpace(caller.Name)
+		if name == "" {
+			return nil, errors.New("internal caller name is required")
+		}
+		if _, exists := seenNames[name]; exists {
+			return nil, fmt.Errorf("duplicate internal caller: %s", name)
+		}
+		seenNames[name] = struct{}{}
+
+		entry := internalCaller{name: name, identities: make(map[string]struct{})}
+		for _, token := range caller.Tokens {
+			digest, err := internalTokenDigest(token)
+			if err != nil {
+				return nil, fmt.Errorf("internal caller %s: %w", name, err)
+			}
+			if _, exists := seenTokens[digest]; exists {
+				return nil, fmt.Errorf("internal caller %s: token is shared with another caller", name)
+			}
+			seenTokens[digest] = struct{}{}
+			if !token.NotAfter.IsZero() && !token.NotBefore.IsZero() && !token.NotAfter.After(token.NotBefore) {
+				return nil, fmt.Errorf("internal caller %s: token notAfter must be after notBefore", name)
+			}
+			entry.tokens = append(entry.tokens, internalCallerToken{
+				digest:    digest,
+				notBefore: token.NotBefore,
+				notAfter:  token.NotAfter,
+			})
+		}
+		for _, identity := range caller.Identities {
+			if identity = strings.TrimSpace(identity); identity != "" {
+				entry.identities[identity] = struct{}{}
+			}
+		}
+		if len(entry.tokens) == 0 && len(entry.identities) == 0 {
+			return nil, fmt.Errorf("internal caller %s needs a token or an mTLS identity", name)
+		}
+		for _, raw := range caller.Routes {
+			route, err := parseInternalRoute(raw)
+			if err != nil {
+				return nil, fmt.Errorf("internal caller %s: %w", name, err)
+			}
+			entry.routes = append(entry.routes, route)
+		}
+		if len(entry.routes) == 0 {
+			return nil, fmt.Errorf("internal caller %s has no routes", name)
+		}
+		r.callers = append(r.callers, entry)
+	}
+	return r, nil
+}
+
+// LegacyInternalCaller returns the caller for the shared INTERNAL_SERVICE_TOKEN
+// secret. It may use every route, so deployments should replace it with
+// named callers and turn it off.
+func LegacyInternalCaller(token string) InternalCaller {
+	return InternalCaller{
+		Name:   LegacyInternalCallerName,
+		Tokens: []InternalCallerToken{{Token: token}},
+		Routes: []string{"*"},
+	}
+}
+
+// InternalCallersFromEnv builds a registry holding only the legacy caller
+// when INTERNAL_SERVICE_TOKEN is set, and an empty registry otherwise.
+func InternalCallersFromEnv() *InternalCallers {
+	var callers []InternalCaller
+	if token := strings.TrimSpace(os.Getenv("INTERNAL_SERVICE_TOKEN")); token != "" {
+		callers = append(callers, LegacyInternalCaller(token))
+	}
+	// A lone legacy caller is always valid.
+	registry, _ := NewInternalCallers(callers)
+	return registry
+}
+
+// Empty reports whether no caller is configured.
+func (r *InternalCallers) Empty() bool {
+	return r == nil || len(r.callers) == 0
+}
+
+// Names lists the configured caller names.
+func (r *InternalCallers) Names() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.callers))
+	for _, caller := range r.callers {
+		names = append(names, caller.name)
+	}
+	return names
+}
+
+// IgnoreIssuer stops client certificates that chain to ca from
+// authenticating internal callers. It is used for CAs that issue
+// certificates to other principals, such as the agent CA, whose subjects
+// must not be mistaken for internal caller identities.
+func (r *InternalCallers) IgnoreIssuer(ca *x509.Certificate) {
+	if r == nil || ca == nil {
+		return
+	}
+	r.ignored = append(r.ignored, ca.Raw)
+}
+
+// Authenticate identifies the caller of req from its verified client
+// certificate or its X-Service-Token header. Tokens are compared in constant
+// time against every configured token, and only tokens inside their validity
+// window are accepted.
+func (r *InternalCallers) Authenticate(req *http.Request) (InternalIdentity, error) {
+	if r == nil || req == nil {
+		return InternalIdentity{}, ErrInternalCallerUnknown
+	}
+	if name, ok := r.authenticateCertificate(req); ok {
+		return InternalIdentity{Caller: name, Method: InternalAuthMTLS}, nil
+	}
+
+	token := strings.TrimSpace(req.Header.Get(InternalServiceTokenHeader))
+	if token == "" {
+		return InternalIdentity{}, ErrInternalCallerUnknown
+	}
+	digest := sha256.Sum256([]byte(token))
+	now := r.now()
+	match := -1
+	for i, caller := range r.callers {
+		for _, candidate := range caller.tokens {
+			equal := subtle.ConstantTimeCompare(digest[:], candidate.digest[:]) == 1
+			if equal && candidate.activeAt(now) && match < 0 {
+				match = i
+			}
+		}
+	}
+	if match < 0 {
+		return InternalIdentity{}, ErrInternalCallerUnknown
+	}
+	return InternalIdentity{Caller: r.callers[match].name, Method: InternalAuthToken}, nil
+}
+
+func (r *InternalCallers) authenticateCertificate(req *http.Request) (string, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	for _, chain := range req.TLS.VerifiedChains {
+		root := chain[len(chain)-1]
+		for _, ignored := range r.ignored {
+			if bytes.Equal(root.Raw, ignored) {
+				return "", false
+			}
+		}
+	}
+	leaf := req.TLS.VerifiedChains[0][0]
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	for _, uri := range leaf.URIs {
+		names = append(names, uri.String())
+	}
+	for _, caller := range r.callers {
+		for _, name := range names {
+			if _, ok := caller.identities[name]; ok && name != "" {
+				return caller.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Allows reports whether caller may use the route template path with method.
+func (r *InternalCallers) Allows(caller, method, path string) bool {
+	if r == nil {
+		return false
+	}
+	for _, entry := range r.callers {
+		if entry.name != caller {
+			continue
+		}
+		for _, route := range entry.routes {
+			if route.matches(method, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t internalCallerToken) activeAt(now time.Time) bool {
+	if !t.notBefore.IsZero() && now.Before(t.notBefore) {
+		return false
+	}
+	if !t.notAfter.IsZero() && !now.Before(t.notAfter) {
+		return false
+	}
+	return true
+}
+
+func (route internalRoute) matches(method, path string) bool {
+	if route.method != "" && !strings.EqualFold(route.method, method) {
+		return false
+	}
+	if route.prefix {
+		return strings.HasPrefix(path, route.path)
+	}
+	return path == route.path
+}
+
+func internalTokenDigest(token InternalCallerToken) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	secret := strings.TrimSpace(token.Token)
+	hexDigest := strings.TrimSpace(token.SHA256)
+	switch {
+	case secret != "" && hexDigest != "":
+		return digest, errors.New("token and sha256 are mutually exclusive")
+	case secret != "":
+		return sha256.Sum256([]byte(secret)), nil
+	case hexDigest != "":
+		raw, err := hex.DecodeString(hexDigest)
+		if err != nil || len(raw) != sha256.Size {
+			return digest, errors.New("sha256 must be a hex-encoded SHA-256 digest")
+		}
+		copy(digest[:], raw)
+		return digest, nil
+	default:
+		return digest, errors.New("token or sha256 is required")
+	}
+}
+
+func parseInternalRoute(raw string) (internalRoute, error) {
+	fields := strings.Fields(raw)
+	var route internalRoute
+	switch len(fields) {
+	case 1:
+		route.path = fields[0]
+	case 2:
+		route.method = strings.ToUpper(fields[0])
+		route.path = fields[1]
+	default:
+		return route, fmt.Errorf("invalid route %q", raw)
+	}
+	if route.path == "*" {
+		route.path = ""
+		route.prefix = true
+		return route, nil
+	}
+	if !strings.HasPrefix(route.path, "/") {
+		return route, fmt.Errorf("route %q must start with /", raw)
+	}
+	if strings.HasSuffix(route.path, "*") {
+		route.path = strings.TrimSuffix(route.path, "*")
+		route.prefix = true
+	}
+	return route, nil
+}
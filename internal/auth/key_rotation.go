@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"account/internal/store"
+)
+
+// Defaults for store-backed signing key rotation.
+const (
+	DefaultKeyRotationInterval = 30 * 24 * time.Hour
+	DefaultKeyPublishAhead     = time.Hour
+)
+
+// SigningKeyStore is the persistence needed by SigningKeyRotator.
+type SigningKeyStore interface {
+	CreateSigningKey(ctx context.Context, key *store.SigningKey) error
+	ListSigningKeys(ctx context.Context) ([]store.SigningKey, error)
+	RetireSigningKey(ctx context.Context, id string, retiredAt time.Time) error
+}
+
+// SigningKeyRotator generates signing keys into the store and keeps Keys in
+// sync with them. A new key is published PublishAhead before it starts
+// signing so verifiers caching the JWKS pick it up in time, and a superseded
+// key stays published for RetireAfter (at least the access token lifetime)
+// so tokens it signed keep verifying.
+type SigningKeyRotator struct {
+	Store        SigningKeyStore
+	Keys         *KeySet
+	Algorithm    string
+	RotateEvery  time.Duration
+	PublishAhead time.Duration
+	RetireAfter  time.Duration
+
+	now func() time.Time
+}
+
+// NewSigningKeyRotator constructs a rotator with default intervals.
+func NewSigningKeyRotator(st SigningKeyStore, keys *KeySet, algorithm string, retireAfter time.Duration) *SigningKeyRotator {
+	return &SigningKeyRotator{
+		Store:        st,
+		Keys:         keys,
+		Algorithm:    algorithm,
+		RotateEvery:  DefaultKeyRotationInterval,
+		PublishAhead: DefaultKeyPublishAhead,
+		RetireAfter:  retireAfter,
+		now:          time.Now,
+	}
+}
+
+// Rotate creates a key when none exists or the newest is due for rotation,
+// retires keys superseded for longer than RetireAfter, and reloads Keys from
+// the store. Replicas racing on the same rotation may each add a key; the
+// newest one wins and the others retire on the next pass.
+func (r *SigningKeyRotator) Rotate(ctx context.Context) error {
+	if r == nil || r.Store == nil || r.Keys == nil {
+		return errors.New("signing key rotator is not configured")
+	}
+	now := r.now().UTC()
+
+	stored, err := r.Store.ListSigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("list signing keys: %w", err)
+	}
+
+	var newest *store.SigningKey
+	for i := range stored {
+		if stored[i].Algorithm != r.Algorithm {
+			continue
+		}
+		if newest == nil || stored[i].NotBefore.After(newest.NotBefore) {
+			newest = &stored[i]
+		}
+	}
+	if newest == nil || now.Sub(newest.NotBefore) >= r.RotateEvery {
+		notBefore := now
+		if newest != nil {
+			// Keep signing with the current key while the new one propagates.
+			notBefore = now.Add(r.PublishAhead)
+		}
+		created, err := r.generate(ctx, notBefore)
+		if err != nil {
+			return err
+		}
+		stored = append(stored, *created)
+	}
+
+	if err := r.retireSuperseded(ctx, stored, now); err != nil {
+		return err
+	}
+	return r.reload(ctx)
+}
+
+func (r *SigningKeyRotator) generate(ctx context.Context, notBefore time.Time) (*store.SigningKey, error) {
+	key, err := GenerateSigningKey(r.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("generate signing key: %w", err)
+	}
+	encoded, err := key.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+	record := &store.SigningKey{
+		ID:            key.ID,
+		Algorithm:     key.Algorithm,
+		PrivateKeyPEM: string(encoded),
+		NotBefore:     notBefore,
+	}
+	if err := r.Store.CreateSigningKey(ctx, record); err != nil {
+		return nil, fmt.Errorf("store signing key: %w", err)
+	}
+	return record, nil
+}
+
+// retireSuperseded retires every key older than the active signer once the
+// signer has been active for RetireAfter.
+func (r *SigningKeyRotator) retireSuperseded(ctx context.Context, stored []store.SigningKey, now time.Time) error {
+	var signer *store.SigningKey
+	for i := range stored {
+		if stored[i].NotBefore.After(now) {
+			continue
+		}
+		if signer == nil || stored[i].NotBefore.After(signer.NotBefore) {
+			signer = &stored[i]
+		}
+	}
+	if signer == nil || now.Sub(signer.NotBefore) < r.RetireAfter {
+		return nil
+	}
+	for _, key := range stored {
+		if key.ID != signer.ID && key.NotBefore.Before(signer.NotBefore) {
+			if err := r.Store.RetireSigningKey(ctx, key.ID, now); err != nil {
+				return fmt.Errorf("retire signing key %s: %w", key.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *SigningKeyRotator) reload(ctx context.Context) error {
+	stored, err := r.Store.ListSigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("list signing keys: %w", err)
+	}
+	keys := make([]*SigningKey, 0, len(stored))
+	for _, record := range stored {
+		key, err := ParseSigningKeyPEM([]byte(record.PrivateKeyPEM), record.ID)
+		if err != nil {
+			return fmt.Errorf("parse signing key %s: %w", record.ID, err)
+		}
+		key.NotBefore = record.NotBefore
+		keys = append(keys, key)
+	}
+	r.Keys.Replace(keys)
+	return nil
+}
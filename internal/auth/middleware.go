@@ -2,13 +2,14 @@ package auth
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"account/internal/cache"
 	"account/internal/store"
 )
 
@@ -21,11 +22,17 @@ func RequireActiveUser(s store.Store) gin.HandlerFunc {
 			return
 		}
 
-		user, err := s.GetUserByID(c.Request.Context(), userID)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
-			c.Abort()
-			return
+		// API token principals were loaded by the middleware; service
+		// accounts have no user record to look up.
+		user, ok := c.Request.Context().Value(principalKey).(*store.User)
+		if !ok {
+			var err error
+			user, err = s.GetUserByID(c.Request.Context(), userID)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+				c.Abort()
+				return
+			}
 		}
 
 		if !user.Active {
@@ -45,16 +52,36 @@ func RequireActiveUser(s store.Store) gin.HandlerFunc {
 type contextKey string
 
 const (
-	userIDKey    contextKey = "user_id"
-	emailKey     contextKey = "email"
-	rolesKey     contextKey = "roles"
-	mfaKey       contextKey = "mfa_verified"
-	bearerPrefix            = "Bearer "
+	userIDKey         contextKey = "user_id"
+	emailKey          contextKey = "email"
+	rolesKey          contextKey = "roles"
+	mfaKey            contextKey = "mfa_verified"
+	internalCallerKey contextKey = "internal_caller"
+	principalKey      contextKey = "api_token_principal"
+	bearerPrefix                 = "Bearer "
 )
 
+// APITokenAuthenticator resolves the principal behind an API token secret:
+// the owning user, or a stand-in user for a service account. It keeps the
+// token on c for the handlers that evaluate its scopes, and responds itself
+// when the token is rejected.
+type APITokenAuthenticator func(c *gin.Context, token string) (*store.User, bool)
+
 // AuthMiddleware is a middleware that validates JWT access tokens
-// with a fallback to database-backed session tokens.
+// with a fallback to database-backed session tokens. API tokens are refused;
+// routes that evaluate token scopes use APITokenAuthMiddleware instead.
 func (s *TokenService) AuthMiddleware() gin.HandlerFunc {
+	return s.authMiddleware(false)
+}
+
+// APITokenAuthMiddleware is AuthMiddleware for routes that also accept API
+// tokens. The token is authenticated here and its principal put on the
+// context like a session user's; the handlers still check the token's scopes.
+func (s *TokenService) APITokenAuthMiddleware() gin.HandlerFunc {
+	return s.authMiddleware(true)
+}
+
+func (s *TokenService) authMiddleware(acceptAPITokens bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var token string
 		authHeader := c.GetHeader("Authorization")
@@ -76,6 +103,29 @@ func (s *TokenService) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if store.IsAPIToken(token) {
+			if !acceptAPITokens || s.apiTokens == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "api tokens are not accepted on this route",
+				})
+				c.Abort()
+				return
+			}
+			principal, ok := s.apiTokens(c, token)
+			if !ok {
+				c.Abort()
+				return
+			}
+			ctx := context.WithValue(c.Request.Context(), userIDKey, principal.ID)
+			ctx = context.WithValue(ctx, emailKey, principal.Email)
+			ctx = context.WithValue(ctx, rolesKey, []string{principal.Role})
+			ctx = context.WithValue(ctx, mfaKey, false)
+			ctx = context.WithValue(ctx, principalKey, principal)
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+			return
+		}
+
 		// 1. Try JWT validation first.
 		claims, err := s.ValidateAccessToken(token)
 		if err == nil {
@@ -91,7 +141,7 @@ func (s *TokenService) AuthMiddleware() gin.HandlerFunc {
 
 		// 2. Fallback to database session store if JWT fails and store is available.
 		if s.store != nil {
-			userID, expiresAt, err := s.store.GetSession(c.Request.Context(), token)
+			userID, expiresAt, err := cache.LookupSession(c.Request.Context(), s.sessionCache, token, StoreSessionLoader(s.store))
 			if err == nil && time.Now().Before(expiresAt) {
 				// Valid session found in store.
 				user, err := s.store.GetUserByID(c.Request.Context(), userID)
@@ -118,31 +168,54 @@ func (s *TokenService) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// InternalAuthMiddleware validates internal service-to-service authentication
-// using a shared token from the X-Service-Token header
-func InternalAuthMiddleware() gin.HandlerFunc {
+// StoreSessionLoader resolves session tokens from st and records activity on
+// the session. Behind a session cache this refreshes last-seen times once per
+// cache period instead of on every request.
+func StoreSessionLoader(st store.Store) cache.SessionLoader {
+	return func(ctx context.Context, token string) (string, time.Time, error) {
+		userID, expiresAt, err := st.GetSession(ctx, token)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if err := st.TouchSession(ctx, token, time.Now()); err != nil {
+			slog.Warn("failed to record session activity", "err", err)
+		}
+		return userID, expiresAt, nil
+	}
+}
+
+// InternalAuthMiddleware authenticates internal service-to-service calls
+// against the callers registry and only lets each caller through to the
+// routes it is allowed to use. The caller's name is kept in the request
+// context for logging and audit.
+func InternalAuthMiddleware(callers *InternalCallers) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		serviceToken := c.GetHeader("X-Service-Token")
-		if serviceToken == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "missing service token",
+		if callers.Empty() {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "internal service callers not configured",
 			})
 			c.Abort()
 			return
 		}
 
-		expectedToken := os.Getenv("INTERNAL_SERVICE_TOKEN")
-		if expectedToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "internal service token not configured",
+		identity, err := callers.Authenticate(c.Request)
+		if err != nil {
+			message := "invalid service token"
+			if c.GetHeader(InternalServiceTokenHeader) == "" {
+				message = "missing service token"
+			}
+			slog.Warn("rejected internal request", "path", c.FullPath(), "ip", c.ClientIP(), "reason", message)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": message,
 			})
 			c.Abort()
 			return
 		}
 
-		if serviceToken != expectedToken {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid service token",
+		if !callers.Allows(identity.Caller, c.Request.Method, c.FullPath()) {
+			slog.Warn("internal caller used a route it is not allowed to", "caller", identity.Caller, "method", c.Request.Method, "path", c.FullPath())
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "route not allowed for internal caller",
 			})
 			c.Abort()
 			return
@@ -153,6 +226,7 @@ func InternalAuthMiddleware() gin.HandlerFunc {
 		ctx = context.WithValue(ctx, emailKey, "internal@system.service")
 		ctx = context.WithValue(ctx, rolesKey, []string{"internal_service"})
 		c.Request = c.Request.WithContext(ctx)
+		SetInternalCaller(c, identity.Caller)
 
 		c.Next()
 	}
@@ -218,6 +292,18 @@ func GetUserID(c *gin.Context) string {
 	return userID.(string)
 }
 
+// GetInternalCaller returns the name of the internal caller that made the
+// request, or an empty string for other requests.
+func GetInternalCaller(c *gin.Context) string {
+	caller, _ := c.Request.Context().Value(internalCallerKey).(string)
+	return caller
+}
+
+// SetInternalCaller records the internal caller that made the request.
+func SetInternalCaller(c *gin.Context, caller string) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), internalCallerKey, caller))
+}
+
 // GetEmail extracts email from context
 func GetEmail(c *gin.Context) string {
 	email := c.Request.Context().Value(emailKey)
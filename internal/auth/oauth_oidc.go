@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcHTTPTimeout = 10 * time.Second
+	// oidcKeyRefreshInterval limits JWKS refetches triggered by unknown kids.
+	oidcKeyRefreshInterval = time.Minute
+	oidcMaxResponseBytes   = 1 << 20
+)
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ID token algorithms accepted from external identity providers.
+var oidcIDTokenAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDCClaimMapping names the claims used to build an OAuthUserProfile. Empty
+// fields fall back to the standard OpenID Connect claim names.
+type OIDCClaimMapping struct {
+	Subject       string
+	Email         string
+	EmailVerified string
+	Name          string
+}
+
+// GenericOIDCConfig configures an external OpenID Connect identity provider
+// such as Microsoft Entra ID, GitLab or Keycloak.
+type GenericOIDCConfig struct {
+	// Name identifies the provider in /api/auth/oauth/{login,callback}/:provider
+	// and in identity records.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes defaults to openid, email and profile.
+	Scopes []string
+	Claims OIDCClaimMapping
+	// TrustEmail treats the email claim as verified for IdPs that do not
+	// send email_verified. Only enable it when the IdP controls the domain,
+	// because OAuth sign-in links accounts by email.
+	TrustEmail bool
+	HTTPClient *http.Client
+}
+
+// GenericOIDCProvider signs users in with any OpenID Connect provider. The
+// endpoints are discovered from the issuer and ID tokens are verified against
+// the provider's published keys.
+type GenericOIDCProvider struct {
+	baseProvider
+	issuer      string
+	userInfoURL string
+	keys        *remoteKeySet
+	claims      OIDCClaimMapping
+	trustEmail  bool
+	httpClient  *http.Client
+}
+
+type oidcDiscoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewGenericOIDCProvider fetches the issuer's discovery document and
+// constructs the provider.
+func NewGenericOIDCProvider(ctx context.Context, cfg GenericOIDCConfig) (*GenericOIDCProvider, error) {
+	name := strings.TrimSpace(cfg.Name)
+	if !providerNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid oidc provider name %q", cfg.Name)
+	}
+	issuer := strings.TrimRight(strings.TrimSpace(cfg.Issuer), "/")
+	if issuer == "" || strings.TrimSpace(cfg.ClientID) == "" {
+		return nil, fmt.Errorf("oidc provider %s: issuer and client id are required", name)
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: oidcHTTPTimeout}
+	}
+
+	var doc oidcDiscoveryDocument
+	if err := getJSON(ctx, httpClient, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc provider %s: discovery: %w", name, err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc provider %s: discovery issuer %q does not match %q", name, doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc provider %s: discovery document is missing endpoints", name)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{ScopeOpenID, ScopeEmail, ScopeProfile}
+	}
+	return &GenericOIDCProvider{
+		baseProvider: baseProvider{
+			name: name,
+			config: &oauth2.Config{
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				RedirectURL:  cfg.RedirectURL,
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  doc.AuthorizationEndpoint,
+					TokenURL: doc.TokenEndpoint,
+				},
+				Scopes: scopes,
+			},
+		},
+		issuer:      doc.Issuer,
+		userInfoURL: doc.UserInfoEndpoint,
+		keys:        &remoteKeySet{uri: doc.JWKSURI, client: httpClient},
+		claims:      cfg.Claims,
+		trustEmail:  cfg.TrustEmail,
+		httpClient:  httpClient,
+	}, nil
+}
+
+// Exchange redeems the authorization code using the provider's HTTP client.
+func (p *GenericOIDCProvider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return p.config.Exchange(context.WithValue(ctx, oauth2.HTTPClient, p.httpClient), code)
+}
+
+// FetchProfile verifies the ID token returned with token, merges in claims
+// from the userinfo endpoint and maps them onto an OAuthUserProfile.
+func (p *GenericOIDCProvider) FetchProfile(ctx context.Context, token *oauth2.Token) (*OAuthUserProfile, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	claims, err := p.verifyIDToken(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token: %w", err)
+	}
+
+	if p.userInfoURL != "" {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+		var userInfo map[string]any
+		if err := getJSON(ctx, p.config.Client(ctx, token), p.userInfoURL, &userInfo); err != nil {
+			return nil, fmt.Errorf("fetch userinfo: %w", err)
+		}
+		if sub, _ := userInfo["sub"].(string); sub != "" && sub != claims["sub"] {
+			return nil, errors.New("userinfo subject does not match the id token")
+		}
+		// The signed ID token wins over userinfo on conflicts.
+		for key, value := range userInfo {
+			if _, exists := claims[key]; !exists {
+				claims[key] = value
+			}
+		}
+	}
+
+	profile := &OAuthUserProfile{
+		ID:    stringClaim(claims, p.claims.Subject, "sub"),
+		Email: stringClaim(claims, p.claims.Email, "email"),
+		Name:  stringClaim(claims, p.claims.Name, "name"),
+	}
+	if profile.ID == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if profile.Name == "" {
+		profile.Name = stringClaim(claims, "preferred_username", "")
+	}
+	profile.Verified = p.trustEmail || boolClaim(claims, p.claims.EmailVerified, "email_verified")
+	return profile, nil
+}
+
+func (p *GenericOIDCProvider) verifyIDToken(ctx context.Context, raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	},
+		jwt.WithValidMethods(oidcIDTokenAlgorithms),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// With several audiences the token must have been issued to us.
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+			return nil, errors.New("id token was issued to another party")
+		}
+	}
+	return claims, nil
+}
+
+func stringClaim(claims jwt.MapClaims, name, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	if name == "" {
+		return ""
+	}
+	value, _ := claims[name].(string)
+	return strings.TrimSpace(value)
+}
+
+// boolClaim reads a boolean claim; some providers send "true" as a string.
+func boolClaim(claims jwt.MapClaims, name, fallback string) bool {
+	if name == "" {
+		name = fallback
+	}
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	default:
+		return false
+	}
+}
+
+// remoteKeySet caches an identity provider's JWKS and refetches it when a
+// token names an unknown key, which is how providers roll their keys.
+type remoteKeySet struct {
+	uri    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func (r *remoteKeySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key, ok := r.lookup(kid); ok {
+		return key, nil
+	}
+	if !r.fetchedAt.IsZero() && time.Since(r.fetchedAt) < oidcKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set JWKSet
+	if err := getJSON(ctx, r.client, r.uri, &set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// Skip key types we cannot use rather than failing the set.
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	r.keys = keys
+	r.fetchedAt = time.Now()
+
+	if key, ok := r.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds kid; tokens without a kid are accepted when the set has a
+// single key.
+func (r *remoteKeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key, true
+		}
+	}
+	key, ok := r.keys[kid]
+	return key, ok
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(target)
+}
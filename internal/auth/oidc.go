@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect scopes understood by the provider.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedOIDCScopes lists the scopes advertised in the discovery document.
+var SupportedOIDCScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// PKCEMethodS256 is the only code challenge method accepted; "plain" offers
+// no protection once the authorization request leaks.
+const PKCEMethodS256 = "S256"
+
+// TenantClaim describes one tenant membership in ID tokens and userinfo.
+type TenantClaim struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Role string `json:"role"`
+}
+
+// UserInfo holds the user claims shared by ID tokens and the userinfo
+// endpoint. Role, Groups and Tenants are always released with the openid
+// scope so relying parties can authorize without extra calls.
+type UserInfo struct {
+	Name          string        `json:"name,omitempty"`
+	UpdatedAt     int64         `json:"updated_at,omitempty"`
+	Email         string        `json:"email,omitempty"`
+	EmailVerified *bool         `json:"email_verified,omitempty"`
+	Role          string        `json:"role,omitempty"`
+	Groups        []string      `json:"groups"`
+	Tenants       []TenantClaim `json:"tenants"`
+}
+
+// IDTokenClaims is the payload of an OpenID Connect ID token.
+type IDTokenClaims struct {
+	Nonce           string           `json:"nonce,omitempty"`
+	AuthTime        *jwt.NumericDate `json:"auth_time,omitempty"`
+	AuthMethods     []string         `json:"amr,omitempty"`
+	AuthorizedParty string           `json:"azp,omitempty"`
+	UserInfo
+	jwt.RegisteredClaims
+}
+
+// HasScope reports whether the space separated scope contains want.
+func HasScope(scope, want string) bool {
+	for _, value := range strings.Fields(scope) {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge recorded
+// with the authorization request (RFC 7636).
+func VerifyPKCE(challenge, method, verifier string) bool {
+	if method != PKCEMethodS256 || challenge == "" || len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,368 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported access token signing algorithms.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+// ErrSigningKeysUnavailable is returned when a token has to be signed with an
+// asymmetric key but only the shared HMAC secret is configured.
+var ErrSigningKeysUnavailable = errors.New("asymmetric signing keys are not configured")
+
+// SigningKey is an asymmetric key used to sign access tokens. ID is published
+// as the JWT "kid" header.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	NotBefore time.Time
+}
+
+// NormalizeAlgorithm maps configuration spellings onto the supported
+// algorithm names. An empty value selects HS256.
+func NormalizeAlgorithm(algorithm string) (string, error) {
+	switch strings.ToUpper(strings.TrimSpace(algorithm)) {
+	case "", "HS256":
+		return AlgorithmHS256, nil
+	case "RS256":
+		return AlgorithmRS256, nil
+	case "EDDSA", "ED25519":
+		return AlgorithmEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// GenerateSigningKey creates a new key for algorithm.
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var signer crypto.Signer
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+	default:
+		return nil, fmt.Errorf("cannot generate %s signing key", algorithm)
+	}
+	return newSigningKey(signer, "")
+}
+
+// ParseSigningKeyPEM decodes a PKCS#8 or PKCS#1 (RSA) private key. When id is
+// empty the RFC 7638 thumbprint of the public key is used, so replicas
+// loading the same file agree on the kid.
+func ParseSigningKeyPEM(data []byte, id string) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return newSigningKey(signer, id)
+}
+
+// LoadSigningKeyFile reads a PEM private key from path.
+func LoadSigningKeyFile(path, id string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseSigningKeyPEM(data, id)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// MarshalPEM encodes the private key as PKCS#8.
+func (k *SigningKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSigningKey(signer crypto.Signer, id string) (*SigningKey, error) {
+	key := &SigningKey{Private: signer}
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < rsaKeyBits {
+			return nil, fmt.Errorf("rsa key must be at least %d bits", rsaKeyBits)
+		}
+		key.Algorithm = AlgorithmRS256
+	case ed25519.PublicKey:
+		key.Algorithm = AlgorithmEdDSA
+	default:
+		return nil, errors.New("signing key must be RSA or Ed25519")
+	}
+
+	key.ID = strings.TrimSpace(id)
+	if key.ID == "" {
+		thumbprint, err := key.thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// JWK is the public half of a signing key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the verification key of an RSA, EC or Ed25519 JWK.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(value string) ([]byte, error) {
+		if value == "" {
+			return nil, errors.New("missing key parameter")
+		}
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("rsa modulus: %w", err)
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("rsa exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+			return nil, errors.New("rsa exponent is too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("ec x: %w", err)
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("ec y: %w", err)
+		}
+		// Uncompressed SEC 1 point so the curve check happens on parse.
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) > size || len(y) > size {
+			return nil, errors.New("ec coordinates do not match the curve")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		copy(point[1+size-len(x):1+size], x)
+		copy(point[1+2*size-len(y):], y)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("ed25519 x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK returns the JSON Web Key for verifying tokens signed by k.
+func (k *SigningKey) PublicJWK() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch pub := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of the public key.
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk := k.PublicJWK()
+	var members any
+	if jwk.KeyType == "RSA" {
+		// Lexicographic member order as required by RFC 7638.
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// KeySet holds the signing keys currently published. Several keys can be
+// active at once so tokens signed before a rotation keep verifying.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+	now  func() time.Time
+}
+
+// NewKeySet constructs a key set from keys.
+func NewKeySet(keys ...*SigningKey) *KeySet {
+	set := &KeySet{now: time.Now}
+	set.Replace(keys)
+	return set
+}
+
+// Replace swaps the published keys.
+func (s *KeySet) Replace(keys []*SigningKey) {
+	sorted := make([]*SigningKey, 0, len(keys))
+	for _, key := range keys {
+		if key != nil {
+			sorted = append(sorted, key)
+		}
+	}
+	// Newest first so Signer picks the most recently activated key.
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].NotBefore.After(sorted[j].NotBefore)
+	})
+
+	s.mu.Lock()
+	s.keys = sorted
+	s.mu.Unlock()
+}
+
+// Signer returns the newest key whose NotBefore has passed.
+func (s *KeySet) Signer() (*SigningKey, bool) {
+	now := s.now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if !key.NotBefore.After(now) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Lookup finds a published key by kid.
+func (s *KeySet) Lookup(kid string) (*SigningKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWKS returns the public keys, including keys that are published ahead of
+// becoming the signer.
+func (s *KeySet) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := JWKSet{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		set.Keys = append(set.Keys, key.PublicJWK())
+	}
+	return set
+}
@@ -1,11 +1,15 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 
+	"account/internal/cache"
 	"account/internal/store"
 )
 
@@ -24,9 +28,16 @@ type Claims struct {
 	Email  string   `json:"email"`
 	Roles  []string `json:"roles"`
 	MFA    bool     `json:"mfa_verified"`
+	// ClientID and Scope are set on tokens issued to OpenID Connect clients.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// accessTokenAudience is the audience of first-party access tokens. Tokens
+// issued to OpenID Connect clients carry the client ID instead.
+const accessTokenAudience = "xcontrol-access"
+
 // TokenService handles token generation and validation
 type TokenService struct {
 	publicToken   string
@@ -35,6 +46,10 @@ type TokenService struct {
 	accessExpiry  time.Duration
 	refreshExpiry time.Duration
 	store         store.Store
+	sessionCache  cache.Cache
+	signingKeys   *KeySet
+	hmacUntil     time.Time
+	apiTokens     APITokenAuthenticator
 }
 
 // TokenConfig holds configuration for token service
@@ -45,6 +60,15 @@ type TokenConfig struct {
 	AccessExpiry  time.Duration
 	RefreshExpiry time.Duration
 	Store         store.Store
+	SessionCache  cache.Cache
+	// SigningKeys switches access tokens to asymmetric signing. Without it
+	// tokens are signed with AccessSecret (HS256).
+	SigningKeys *KeySet
+	// HMACTransitionUntil keeps HS256 access tokens signed with AccessSecret
+	// valid until the given time after switching to SigningKeys, so tokens
+	// issued before the switch can expire naturally. When zero they are
+	// rejected as soon as SigningKeys is set.
+	HMACTransitionUntil time.Time
 }
 
 // NewTokenService creates a new TokenService instance
@@ -56,6 +80,9 @@ func NewTokenService(config TokenConfig) *TokenService {
 		accessExpiry:  config.AccessExpiry,
 		refreshExpiry: config.RefreshExpiry,
 		store:         config.Store,
+		sessionCache:  config.SessionCache,
+		signingKeys:   config.SigningKeys,
+		hmacUntil:     config.HMACTransitionUntil,
 	}
 }
 
@@ -64,6 +91,46 @@ func (s *TokenService) SetStore(st store.Store) {
 	s.store = st
 }
 
+// SetSessionCache sets the cache consulted before the store for session tokens.
+func (s *TokenService) SetSessionCache(c cache.Cache) {
+	s.sessionCache = c
+}
+
+// SetAPITokenAuthenticator sets how APITokenAuthMiddleware resolves API
+// tokens.
+func (s *TokenService) SetAPITokenAuthenticator(fn APITokenAuthenticator) {
+	s.apiTokens = fn
+}
+
+// SignJWT signs claims with the active asymmetric signing key and sets the
+// kid header. It returns ErrSigningKeysUnavailable when tokens are signed with
+// the shared HMAC secret.
+func (s *TokenService) SignJWT(claims jwt.Claims) (string, error) {
+	if s.signingKeys == nil {
+		return "", ErrSigningKeysUnavailable
+	}
+	key, ok := s.signingKeys.Signer()
+	if !ok {
+		return "", errors.New("no active signing key")
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// JWKS returns the public keys verifiers need for access tokens. It is empty
+// when tokens are signed with the shared HMAC secret.
+func (s *TokenService) JWKS() JWKSet {
+	if s.signingKeys == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return s.signingKeys.JWKS()
+}
+
 // ValidatePublicToken validates the public token
 func (s *TokenService) ValidatePublicToken(publicToken string) bool {
 	return publicToken == s.publicToken
@@ -74,13 +141,40 @@ func (s *TokenService) GeneratePublicToken(userID, email string, roles []string)
 	return s.publicToken
 }
 
-// GenerateTokenPair generates a new token pair
-func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (*TokenPair, error) {
+// Refresh token errors.
+var (
+	ErrRefreshTokenInvalid     = errors.New("invalid refresh token")
+	ErrRefreshTokenRevoked     = errors.New("refresh token revoked")
+	ErrRefreshTokenReused      = errors.New("refresh token reuse detected")
+	ErrRefreshStoreUnavailable = errors.New("refresh token store is not configured")
+)
+
+// GenerateTokenPair issues an access token and starts a new refresh token
+// family for the user.
+func (s *TokenService) GenerateTokenPair(ctx context.Context, userID, email string, roles []string) (*TokenPair, error) {
+	if s.store == nil {
+		return nil, ErrRefreshStoreUnavailable
+	}
+
+	record := &store.RefreshToken{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(s.refreshExpiry).UTC(),
+	}
+	record.FamilyID = record.ID
+	if err := s.store.CreateRefreshToken(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to record refresh token: %w", err)
+	}
+	return s.signTokenPair(record, userID, email, roles)
+}
+
+func (s *TokenService) signTokenPair(record *store.RefreshToken, userID, email string, roles []string) (*TokenPair, error) {
 	// Generate refresh token (JWT)
 	refreshClaims := jwt.RegisteredClaims{
+		ID:        record.ID,
 		Subject:   userID,
 		Audience:  []string{"xcontrol-refresh"},
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.refreshExpiry)),
+		ExpiresAt: jwt.NewNumericDate(record.ExpiresAt),
 		NotBefore: jwt.NewNumericDate(time.Now()),
 		Issuer:    "xcontrol-account",
 	}
@@ -91,7 +185,21 @@ func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (
 		return nil, fmt.Errorf("failed to sign refresh token: %w", err)
 	}
 
-	// Generate access token (JWT)
+	accessTokenString, err := s.signAccessToken(userID, email, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		PublicToken:  s.publicToken,
+		AccessToken:  accessTokenString,
+		RefreshToken: refreshTokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessExpiry.Seconds()),
+	}, nil
+}
+
+func (s *TokenService) signAccessToken(userID, email string, roles []string) (string, error) {
 	claims := Claims{
 		UserID: userID,
 		Email:  email,
@@ -99,36 +207,54 @@ func (s *TokenService) GenerateTokenPair(userID, email string, roles []string) (
 		MFA:    true, // Assume MFA is verified for now
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID,
-			Audience:  []string{"xcontrol-access"},
+			Audience:  []string{accessTokenAudience},
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.accessExpiry)),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "xcontrol-account",
 		},
 	}
 
+	if s.signingKeys != nil {
+		return s.SignJWT(claims)
+	}
+
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	accessTokenString, err := accessToken.SignedString([]byte(s.accessSecret))
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign access token: %w", err)
+		return "", fmt.Errorf("failed to sign access token: %w", err)
 	}
-
-	return &TokenPair{
-		PublicToken:  s.publicToken,
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
-		TokenType:    "Bearer",
-		ExpiresIn:    int64(s.accessExpiry.Seconds()),
-	}, nil
+	return accessTokenString, nil
 }
 
-// ValidateAccessToken validates and parses an access token
+// ValidateAccessToken validates and parses a first-party access token.
+// Tokens issued to OpenID Connect clients are signed with the same keys but
+// are rejected here: they are only good for the userinfo endpoint.
 func (s *TokenService) ValidateAccessToken(accessToken string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.accessSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, s.accessKey,
+		jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithAudience(accessTokenAudience))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse access token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid access token")
+	}
+	if claims.ClientID != "" {
+		return nil, fmt.Errorf("access token was issued to client %q", claims.ClientID)
+	}
+
+	return claims, nil
+}
+
+// ValidateClientAccessToken validates an access token issued by issuer to an
+// OpenID Connect client. The audience must be exactly that client, so
+// first-party tokens are rejected.
+func (s *TokenService) ValidateClientAccessToken(accessToken, issuer string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, s.accessKey,
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithIssuer(issuer))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse access token: %w", err)
 	}
@@ -137,12 +263,110 @@ func (s *TokenService) ValidateAccessToken(accessToken string) (*Claims, error)
 	if !ok || !token.Valid {
 		return nil, fmt.Errorf("invalid access token")
 	}
+	if claims.ClientID == "" || len(claims.Audience) != 1 || claims.Audience[0] != claims.ClientID {
+		return nil, fmt.Errorf("access token was not issued to a client")
+	}
 
 	return claims, nil
 }
 
-// RefreshAccessToken generates a new access token using refresh token
-func (s *TokenService) RefreshAccessToken(refreshToken string) (string, error) {
+// accessKey resolves the verification key for an access token. Tokens with a
+// kid must match a published signing key. HS256 tokens need the access
+// secret and, once asymmetric keys are configured, are only accepted until
+// the transition deadline.
+func (s *TokenService) accessKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if s.accessSecret == "" {
+			return nil, errors.New("hmac access tokens are not accepted")
+		}
+		if s.signingKeys != nil && !time.Now().Before(s.hmacUntil) {
+			return nil, errors.New("hmac access tokens are no longer accepted")
+		}
+		return []byte(s.accessSecret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if s.signingKeys == nil || kid == "" {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	key, ok := s.signingKeys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("signing key %q does not use %s", kid, token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// RefreshTokenPair rotates a refresh token: the presented token is consumed
+// and a new pair in the same family is returned. Presenting a token that was
+// already rotated revokes the whole family, since either the legitimate
+// client or an attacker is replaying a stolen copy.
+func (s *TokenService) RefreshTokenPair(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if s.store == nil {
+		return nil, ErrRefreshStoreUnavailable
+	}
+
+	claims, err := s.parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.store.GetRefreshToken(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrRefreshTokenNotFound) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+	if record.UserID != claims.Subject {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if record.RevokedAt != nil {
+		return nil, ErrRefreshTokenRevoked
+	}
+	if record.UsedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, record)
+	}
+
+	user, err := s.store.GetUserByID(ctx, record.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+	if !user.Active {
+		if _, err := s.store.RevokeRefreshTokenFamily(ctx, record.FamilyID, store.RefreshTokenRevokedUserPaused); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenRevoked
+	}
+
+	next := &store.RefreshToken{
+		ID:        uuid.NewString(),
+		ExpiresAt: time.Now().Add(s.refreshExpiry).UTC(),
+	}
+	if err := s.store.RotateRefreshToken(ctx, record.ID, next); err != nil {
+		if errors.Is(err, store.ErrRefreshTokenConsumed) {
+			// Lost a race against another refresh with the same token.
+			return nil, s.revokeReusedFamily(ctx, record)
+		}
+		return nil, err
+	}
+
+	return s.signTokenPair(next, user.ID, user.Email, []string{user.Role})
+}
+
+func (s *TokenService) revokeReusedFamily(ctx context.Context, record *store.RefreshToken) error {
+	if _, err := s.store.RevokeRefreshTokenFamily(ctx, record.FamilyID, store.RefreshTokenRevokedReuse); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func (s *TokenService) parseRefreshToken(refreshToken string) (*jwt.RegisteredClaims, error) {
 	token, err := jwt.ParseWithClaims(refreshToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -150,45 +374,23 @@ func (s *TokenService) RefreshAccessToken(refreshToken string) (string, error) {
 		return []byte(s.refreshSecret), nil
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to parse refresh token: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrRefreshTokenInvalid, err)
 	}
 
 	claims, ok := token.Claims.(*jwt.RegisteredClaims)
 	if !ok || !token.Valid {
-		return "", fmt.Errorf("invalid refresh token")
+		return nil, ErrRefreshTokenInvalid
 	}
 
 	// Verify issuer and audience
-	if claims.Issuer != "xcontrol-account" {
-		return "", fmt.Errorf("invalid token issuer")
+	if claims.Issuer != "xcontrol-account" || !contains(claims.Audience, "xcontrol-refresh") {
+		return nil, ErrRefreshTokenInvalid
 	}
-
-	if !contains(claims.Audience, "xcontrol-refresh") {
-		return "", fmt.Errorf("invalid token audience")
+	if claims.ID == "" {
+		// Stateless refresh tokens issued before rotation was introduced.
+		return nil, ErrRefreshTokenInvalid
 	}
-
-	// Generate new access token
-	newClaims := Claims{
-		UserID: claims.Subject,
-		Email:  "", // Will be populated from user store
-		Roles:  []string{"user"},
-		MFA:    true,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   claims.Subject,
-			Audience:  []string{"xcontrol-access"},
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.accessExpiry)),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "xcontrol-account",
-		},
-	}
-
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(s.accessSecret))
-	if err != nil {
-		return "", fmt.Errorf("failed to sign access token: %w", err)
-	}
-
-	return accessTokenString, nil
+	return claims, nil
 }
 
 // GetAccessTokenExpiry returns the access token expiry duration
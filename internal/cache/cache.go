@@ -1,6 +1,102 @@
 package cache
 
-// Cache defines session cache behavior.
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultSessionMaxTTL bounds how long a session stays cached, which also
+// bounds how long a session removed behind the cache's back (for example by
+// deleting the user) keeps authenticating.
+const DefaultSessionMaxTTL = 5 * time.Minute
+
+// ErrCacheMiss is returned when a session is not cached.
+var ErrCacheMiss = errors.New("cache miss")
+
+// Session is the cached view of a session token.
+type Session struct {
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Cache defines session cache behavior. Entries never outlive the session
+// they describe.
 type Cache interface {
-	SetSession(token string, userID int64) error
+	// GetSession returns the cached session for token or ErrCacheMiss.
+	GetSession(ctx context.Context, token string) (Session, error)
+	// SetSession caches session until its expiry, capped by the cache's
+	// maximum TTL.
+	SetSession(ctx context.Context, token string, session Session) error
+	// DeleteSession drops token from the cache.
+	DeleteSession(ctx context.Context, token string) error
+	// TouchSession moves the expiry of a cached session. It returns
+	// ErrCacheMiss when the token is not cached.
+	TouchSession(ctx context.Context, token string, expiresAt time.Time) error
+}
+
+// SessionLoader reads a session from the authoritative store.
+type SessionLoader func(ctx context.Context, token string) (userID string, expiresAt time.Time, err error)
+
+// LookupSession reads token through c, falling back to load on a miss and
+// caching the result. A nil cache always uses load. Cache failures are not
+// fatal; the store stays the source of truth.
+func LookupSession(ctx context.Context, c Cache, token string, load SessionLoader) (string, time.Time, error) {
+	if c != nil {
+		if session, err := c.GetSession(ctx, token); err == nil {
+			return session.UserID, session.ExpiresAt, nil
+		}
+	}
+
+	userID, expiresAt, err := load(ctx, token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if c != nil && time.Now().Before(expiresAt) {
+		_ = c.SetSession(ctx, token, Session{UserID: userID, ExpiresAt: expiresAt})
+	}
+	return userID, expiresAt, nil
+}
+
+// SessionCacheConfig selects and configures a session cache.
+type SessionCacheConfig struct {
+	// Driver is memory, redis, or empty / none to disable caching.
+	Driver    string
+	Redis     RESPOptions
+	KeyPrefix string
+	MaxTTL    time.Duration
+}
+
+// NewSessionCache builds the session cache selected by cfg. It returns a nil
+// cache when caching is disabled; the cleanup function is always safe to call.
+func NewSessionCache(ctx context.Context, cfg SessionCacheConfig) (Cache, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	switch strings.ToLower(strings.TrimSpace(cfg.Driver)) {
+	case "", "none":
+		return nil, noop, nil
+	case "memory":
+		return NewMemoryCache(cfg.MaxTTL), noop, nil
+	case "redis":
+		client, err := NewRESPClient(cfg.Redis)
+		if err != nil {
+			return nil, noop, err
+		}
+		if err := client.Ping(ctx); err != nil {
+			client.Close()
+			return nil, noop, err
+		}
+		return NewRedisCache(client, cfg.KeyPrefix, cfg.MaxTTL), func(context.Context) error { return client.Close() }, nil
+	default:
+		return nil, noop, fmt.Errorf("unsupported session cache driver %q", cfg.Driver)
+	}
+}
+
+func sessionCacheTTL(expiresAt time.Time, maxTTL time.Duration, now time.Time) time.Duration {
+	ttl := expiresAt.Sub(now)
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
 }
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionCache(t *testing.T) {
+	testSessionCache(t, NewMemoryCache(time.Minute))
+}
+
+func TestRedisSessionCache(t *testing.T) {
+	server := newFakeRESPServer(t, "")
+	client, err := NewRESPClient(RESPOptions{Addr: server.Addr()})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	defer client.Close()
+
+	testSessionCache(t, NewRedisCache(client, "", time.Minute))
+}
+
+func TestLookupSessionFillsCache(t *testing.T) {
+	ctx := context.Background()
+	sessions := NewMemoryCache(time.Minute)
+	expiresAt := time.Now().Add(time.Hour)
+
+	loads := 0
+	load := func(context.Context, string) (string, time.Time, error) {
+		loads++
+		return "user-1", expiresAt, nil
+	}
+	for i := 0; i < 2; i++ {
+		userID, _, err := LookupSession(ctx, sessions, "token", load)
+		if err != nil || userID != "user-1" {
+			t.Fatalf("lookup: %q, %v", userID, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected one store load, got %d", loads)
+	}
+
+	missing := errors.New("not found")
+	if _, _, err := LookupSession(ctx, nil, "other", func(context.Context, string) (string, time.Time, error) {
+		return "", time.Time{}, missing
+	}); !errors.Is(err, missing) {
+		t.Fatalf("expected loader error, got %v", err)
+	}
+}
+
+func testSessionCache(t *testing.T, sessions Cache) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := sessions.GetSession(ctx, "missing"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := sessions.TouchSession(ctx, "missing", time.Now().Add(time.Hour)); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected touch of missing session to miss, got %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := sessions.SetSession(ctx, "token", Session{UserID: "user-1", ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	session, err := sessions.GetSession(ctx, "token")
+	if err != nil || session.UserID != "user-1" || !session.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("get: %+v, %v", session, err)
+	}
+
+	extended := expiresAt.Add(time.Hour)
+	if err := sessions.TouchSession(ctx, "token", extended); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if session, err := sessions.GetSession(ctx, "token"); err != nil || !session.ExpiresAt.Equal(extended) {
+		t.Fatalf("expected touched expiry, got %+v, %v", session, err)
+	}
+
+	if err := sessions.DeleteSession(ctx, "token"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := sessions.GetSession(ctx, "token"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected deleted session to miss, got %v", err)
+	}
+
+	if err := sessions.SetSession(ctx, "short", Session{UserID: "user-2", ExpiresAt: time.Now().Add(20 * time.Millisecond)}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := sessions.GetSession(ctx, "short"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected expired session to miss, got %v", err)
+	}
+
+	if err := sessions.SetSession(ctx, "expired", Session{UserID: "user-3", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("set expired: %v", err)
+	}
+	if _, err := sessions.GetSession(ctx, "expired"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected already expired session not to be cached, got %v", err)
+	}
+}
+
+func TestMemorySessionCachePurgesExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	sessions := NewMemoryCache(time.Minute)
+	sessions.now = func() time.Time { return now }
+
+	for _, token := range []string{"a", "b"} {
+		if err := sessions.SetSession(ctx, token, Session{UserID: "user-1", ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("set %s: %v", token, err)
+		}
+	}
+	if err := sessions.SetSession(ctx, "c", Session{UserID: "user-1", ExpiresAt: now.Add(2 * time.Minute)}); err != nil {
+		t.Fatalf("set c: %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if removed, err := sessions.PurgeExpired(ctx); err != nil || removed != 0 {
+		t.Fatalf("expected nothing to purge yet, got %d, %v", removed, err)
+	}
+	if err := sessions.TouchSession(ctx, "a", now.Add(time.Hour)); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+
+	now = now.Add(45 * time.Second)
+	if removed, err := sessions.PurgeExpired(ctx); err != nil || removed != 2 {
+		t.Fatalf("expected the two untouched entries to be purged, got %d, %v", removed, err)
+	}
+	if _, err := sessions.GetSession(ctx, "a"); err != nil {
+		t.Fatalf("expected the touched entry to stay cached, got %v", err)
+	}
+}
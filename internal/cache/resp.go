@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRESPDialTimeout = 5 * time.Second
+	defaultRESPIOTimeout   = 5 * time.Second
+	defaultRESPMaxIdle     = 8
+)
+
+// ErrClientClosed is returned when a command is issued on a closed client.
+var ErrClientClosed = errors.New("resp client is closed")
+
+// RESPError is an error reply returned by the server.
+type RESPError string
+
+func (e RESPError) Error() string { return string(e) }
+
+// RESPOptions configures a RESPClient.
+type RESPOptions struct {
+	Addr        string
+	Password    string
+	DB          int
+	DialTimeout time.Duration
+	IOTimeout   time.Duration
+	MaxIdle     int
+}
+
+// RESPClient is a small Redis protocol (RESP2) client with a pool of idle
+// connections. It speaks to Redis and to compatible servers such as Valkey,
+// KeyDB or Dragonfly. Replies are decoded to string (simple strings), int64
+// (integers), []byte (bulk strings), []any (arrays) or nil.
+type RESPClient struct {
+	opts RESPOptions
+
+	mu     sync.Mutex
+	idle   []*respConn
+	closed bool
+}
+
+// NewRESPClient constructs a client for opts.Addr. Connections are opened
+// lazily on first use.
+func NewRESPClient(opts RESPOptions) (*RESPClient, error) {
+	opts.Addr = strings.TrimSpace(opts.Addr)
+	if opts.Addr == "" {
+		return nil, errors.New("resp address is required")
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultRESPDialTimeout
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = defaultRESPIOTimeout
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = defaultRESPMaxIdle
+	}
+	return &RESPClient{opts: opts}, nil
+}
+
+// Do runs a single command and returns its decoded reply. Error replies are
+// returned as RESPError.
+func (c *RESPClient) Do(ctx context.Context, args ...string) (any, error) {
+	var reply any
+	err := c.withConn(ctx, func(conn *respConn) error {
+		var err error
+		reply, err = conn.do(args...)
+		return err
+	})
+	return reply, err
+}
+
+// Ping checks connectivity.
+func (c *RESPClient) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Close closes all idle connections. Connections in use are closed when
+// they are returned.
+func (c *RESPClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var errs []error
+	for _, conn := range c.idle {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.idle = nil
+	return errors.Join(errs...)
+}
+
+// withConn runs fn on one pooled connection, which lets callers issue
+// WATCH/MULTI/EXEC sequences. Connections that saw an I/O error are
+// discarded instead of being returned to the pool.
+func (c *RESPClient) withConn(ctx context.Context, fn func(conn *respConn) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.opts.IOTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	err = fn(conn)
+	interrupted := !stop()
+
+	var replyErr RESPError
+	if interrupted || (err != nil && !errors.As(err, &replyErr)) {
+		conn.Close()
+		if interrupted && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	c.release(conn)
+	return err
+}
+
+func (c *RESPClient) acquire(ctx context.Context) (*respConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", c.opts.Addr, err)
+	}
+	conn := &respConn{Conn: netConn, r: bufio.NewReader(netConn)}
+	if err := conn.SetDeadline(time.Now().Add(c.opts.IOTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.opts.Password != "" {
+		if _, err := conn.do("AUTH", c.opts.Password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("select db: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *RESPClient) release(conn *respConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= c.opts.MaxIdle {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *respConn) do(args ...string) (any, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return readRESPReply(c.r)
+}
+
+func (c *respConn) send(args ...string) error {
+	if len(args) == 0 {
+		return errors.New("resp command is empty")
+	}
+	var b strings.Builder
+	b.WriteString("*")
+	b.WriteString(strconv.Itoa(len(args)))
+	b.WriteString("\r\n")
+	for _, arg := range args {
+		b.WriteString("$")
+		b.WriteString(strconv.Itoa(len(arg)))
+		b.WriteString("\r\n")
+		b.WriteString(arg)
+		b.WriteString("\r\n")
+	}
+	_, err := io.WriteString(c.Conn, b.String())
+	return err
+}
+
+func readRESPReply(r *bufio.Reader) (any, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("resp: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RESPError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("resp: invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("resp: invalid array length %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			item, err := readRESPReply(r)
+			var replyErr RESPError
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			if err != nil {
+				item = replyErr
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("resp: unexpected reply type %q", line[0])
+	}
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// respBytes converts a bulk or simple string reply to bytes. It returns
+// false for nil replies.
+func respBytes(reply any) ([]byte, bool, error) {
+	switch v := reply.(type) {
+	case nil:
+		return nil, false, nil
+	case []byte:
+		return v, true, nil
+	case string:
+		return []byte(v), true, nil
+	default:
+		return nil, false, fmt.Errorf("resp: unexpected reply %T", reply)
+	}
+}
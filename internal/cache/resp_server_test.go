@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRESPServer implements the subset of Redis commands used by this
+// package so the RESP client and the redis-backed stores can be exercised
+// without a real server.
+type fakeRESPServer struct {
+	t        *testing.T
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	values   map[string]string
+	expiry   map[string]time.Time
+	versions map[string]int
+	commands []string
+}
+
+func newFakeRESPServer(t *testing.T, password string) *fakeRESPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &fakeRESPServer{
+		t:        t,
+		listener: listener,
+		password: password,
+		values:   make(map[string]string),
+		expiry:   make(map[string]time.Time),
+		versions: make(map[string]int),
+	}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakeRESPServer) Addr() string { return s.listener.Addr().String() }
+
+func (s *fakeRESPServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Touch bumps the version of key, as a concurrent writer would.
+func (s *fakeRESPServer) Touch(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[key]++
+}
+
+func (s *fakeRESPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+type fakeRESPSession struct {
+	authed  bool
+	inMulti bool
+	queued  [][]string
+	watched map[string]int
+}
+
+func (s *fakeRESPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	session := &fakeRESPSession{authed: s.password == ""}
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, s.dispatch(session, args)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRESPServer) dispatch(session *fakeRESPSession, args []string) string {
+	name := strings.ToUpper(args[0])
+	s.mu.Lock()
+	s.commands = append(s.commands, name)
+	s.mu.Unlock()
+
+	switch name {
+	case "AUTH":
+		if len(args) == 2 && args[1] == s.password {
+			session.authed = true
+			return "+OK\r\n"
+		}
+		return "-WRONGPASS invalid password\r\n"
+	}
+	if !session.authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	switch name {
+	case "MULTI":
+		session.inMulti = true
+		session.queued = nil
+		return "+OK\r\n"
+	case "DISCARD":
+		session.inMulti = false
+		session.queued = nil
+		session.watched = nil
+		return "+OK\r\n"
+	case "EXEC":
+		session.inMulti = false
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, version := range session.watched {
+			if s.versions[key] != version {
+				session.watched = nil
+				return "*-1\r\n"
+			}
+		}
+		session.watched = nil
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(session.queued))
+		for _, queued := range session.queued {
+			b.WriteString(s.execLocked(queued))
+		}
+		return b.String()
+	case "WATCH":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if session.watched == nil {
+			session.watched = make(map[string]int)
+		}
+		for _, key := range args[1:] {
+			session.watched[key] = s.versions[key]
+		}
+		return "+OK\r\n"
+	case "UNWATCH":
+		session.watched = nil
+		return "+OK\r\n"
+	}
+
+	if session.inMulti {
+		session.queued = append(session.queued, args)
+		return "+QUEUED\r\n"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.execLocked(args)
+}
+
+func (s *fakeRESPServer) execLocked(args []string) string {
+	s.expireLocked()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := s.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "MGET":
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			if value, ok := s.values[key]; ok {
+				b.WriteString(bulk(value))
+			} else {
+				b.WriteString("$-1\r\n")
+			}
+		}
+		return b.String()
+	case "SET":
+		key := args[1]
+		s.values[key] = args[2]
+		delete(s.expiry, key)
+		s.versions[key]++
+		for i := 3; i+1 < len(args); i += 2 {
+			amount, _ := strconv.ParseInt(args[i+1], 10, 64)
+			switch strings.ToUpper(args[i]) {
+			case "PX":
+				s.expiry[key] = time.Now().Add(time.Duration(amount) * time.Millisecond)
+			case "EX":
+				s.expiry[key] = time.Now().Add(time.Duration(amount) * time.Second)
+			}
+		}
+		return "+OK\r\n"
+	case "DEL":
+		removed := 0
+		for _, key := range args[1:] {
+			if _, ok := s.values[key]; ok {
+				removed++
+				delete(s.values, key)
+				delete(s.expiry, key)
+				s.versions[key]++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "PEXPIRE":
+		if _, ok := s.values[args[1]]; !ok {
+			return ":0\r\n"
+		}
+		amount, _ := strconv.ParseInt(args[2], 10, 64)
+		s.expiry[args[1]] = time.Now().Add(time.Duration(amount) * time.Millisecond)
+		return ":1\r\n"
+	case "SCAN":
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for key := range s.values {
+			if ok, _ := path.Match(pattern, key); ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString("*2\r\n")
+		b.WriteString(bulk("0"))
+		fmt.Fprintf(&b, "*%d\r\n", len(keys))
+		for _, key := range keys {
+			b.WriteString(bulk(key))
+		}
+		return b.String()
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func (s *fakeRESPServer) expireLocked() {
+	now := time.Now()
+	for key, at := range s.expiry {
+		if !now.Before(at) {
+			delete(s.values, key)
+			delete(s.expiry, key)
+			s.versions[key]++
+		}
+	}
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readRESPReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) == 0 {
+		return nil, errors.New("expected command array")
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		value, _, err := respBytes(item)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(value)
+	}
+	return args, nil
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryCacheEntry struct {
+	session    Session
+	evictAfter time.Time
+}
+
+// MemoryCache is a process-local session cache. With several replicas a
+// logout only clears the cache of the replica that served it, so prefer
+// RedisCache there. Entries are dropped when read after they expire; call
+// PurgeExpired periodically to drop the ones that are never read again.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	maxTTL  time.Duration
+	now     func() time.Time
+}
+
+// NewMemoryCache constructs an empty cache. A non-positive maxTTL defaults
+// to DefaultSessionMaxTTL.
+func NewMemoryCache(maxTTL time.Duration) *MemoryCache {
+	if maxTTL <= 0 {
+		maxTTL = DefaultSessionMaxTTL
+	}
+	return &MemoryCache{
+		entries: make(map[string]memoryCacheEntry),
+		maxTTL:  maxTTL,
+		now:     time.Now,
+	}
+}
+
+// GetSession implements Cache.
+func (c *MemoryCache) GetSession(ctx context.Context, token string) (Session, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[token]
+	if !ok {
+		return Session{}, ErrCacheMiss
+	}
+	if !c.now().Before(entry.evictAfter) {
+		delete(c.entries, token)
+		return Session{}, ErrCacheMiss
+	}
+	return entry.session, nil
+}
+
+// SetSession implements Cache.
+func (c *MemoryCache) SetSession(ctx context.Context, token string, session Session) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(token, session)
+	return nil
+}
+
+// DeleteSession implements Cache.
+func (c *MemoryCache) DeleteSession(ctx context.Context, token string) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, token)
+	return nil
+}
+
+// TouchSession implements Cache.
+func (c *MemoryCache) TouchSession(ctx context.Context, token string, expiresAt time.Time) error {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[token]
+	if !ok || !c.now().Before(entry.evictAfter) {
+		delete(c.entries, token)
+		return ErrCacheMiss
+	}
+	entry.session.ExpiresAt = expiresAt
+	c.store(token, entry.session)
+	return nil
+}
+
+// PurgeExpired drops expired entries and returns how many were removed.
+func (c *MemoryCache) PurgeExpired(ctx context.Context) (int64, error) {
+	_ = ctx
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	var removed int64
+	for token, entry := range c.entries {
+		if !now.Before(entry.evictAfter) {
+			delete(c.entries, token)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (c *MemoryCache) store(token string, session Session) {
+	now := c.now()
+	ttl := sessionCacheTTL(session.ExpiresAt, c.maxTTL, now)
+	if ttl <= 0 {
+		delete(c.entries, token)
+		return
+	}
+	c.entries[token] = memoryCacheEntry{session: session, evictAfter: now.Add(ttl)}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+const defaultSessionKeyPrefix = "account:session:"
+
+// RedisCache keeps sessions in a Redis-compatible server as JSON values
+// under {prefix}{token} with a PX expiry.
+type RedisCache struct {
+	client *RESPClient
+	prefix string
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// NewRedisCache constructs a session cache on top of client. An empty prefix
+// defaults to "account:session:" and a non-positive maxTTL to
+// DefaultSessionMaxTTL.
+func NewRedisCache(client *RESPClient, prefix string, maxTTL time.Duration) *RedisCache {
+	if prefix == "" {
+		prefix = defaultSessionKeyPrefix
+	}
+	if maxTTL <= 0 {
+		maxTTL = DefaultSessionMaxTTL
+	}
+	return &RedisCache{client: client, prefix: prefix, maxTTL: maxTTL, now: time.Now}
+}
+
+// GetSession implements Cache.
+func (c *RedisCache) GetSession(ctx context.Context, token string) (Session, error) {
+	reply, err := c.client.Do(ctx, "GET", c.prefix+token)
+	if err != nil {
+		return Session{}, err
+	}
+	payload, ok, err := respBytes(reply)
+	if err != nil {
+		return Session{}, err
+	}
+	if !ok {
+		return Session{}, ErrCacheMiss
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return Session{}, err
+	}
+	if !c.now().Before(session.ExpiresAt) {
+		return Session{}, ErrCacheMiss
+	}
+	return session, nil
+}
+
+// SetSession implements Cache.
+func (c *RedisCache) SetSession(ctx context.Context, token string, session Session) error {
+	ttl := sessionCacheTTL(session.ExpiresAt, c.maxTTL, c.now())
+	if ttl <= 0 {
+		return c.DeleteSession(ctx, token)
+	}
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Do(ctx, "SET", c.prefix+token, string(payload), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+// DeleteSession implements Cache.
+func (c *RedisCache) DeleteSession(ctx context.Context, token string) error {
+	_, err := c.client.Do(ctx, "DEL", c.prefix+token)
+	return err
+}
+
+// TouchSession implements Cache.
+func (c *RedisCache) TouchSession(ctx context.Context, token string, expiresAt time.Time) error {
+	session, err := c.GetSession(ctx, token)
+	if err != nil {
+		return err
+	}
+	session.ExpiresAt = expiresAt
+	return c.SetSession(ctx, token, session)
+}
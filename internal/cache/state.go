@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// ErrStateNotFound is returned when a key is absent or has expired.
+var ErrStateNotFound = errors.New("state not found")
+
+// StateStore keeps short-lived authentication state such as MFA challenges,
+// verification codes, password reset tokens and OAuth exchange codes. Entries
+// are grouped by namespace and expire after their TTL, so a shared backend
+// lets several replicas serve the same flow.
+type StateStore interface {
+	// Get returns the value stored under key or ErrStateNotFound.
+	Get(ctx context.Context, namespace, key string) ([]byte, error)
+	// Set stores value for ttl. A non-positive ttl removes the key.
+	Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error
+	// Update atomically replaces the value stored under key with the result
+	// of fn. fn returning a nil value or a non-positive ttl removes the key.
+	// ErrStateNotFound is returned, without calling fn, when key is absent.
+	Update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error)
+	// Upsert behaves like Update but calls fn with a nil current value when
+	// key is absent, so counters can be created and advanced atomically.
+	Upsert(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error)
+	// Take atomically returns and removes the value stored under key.
+	Take(ctx context.Context, namespace, key string) ([]byte, error)
+	// Delete removes key. Removing an absent key is not an error.
+	Delete(ctx context.Context, namespace, key string) error
+	// Entries returns every live entry of namespace.
+	Entries(ctx context.Context, namespace string) (map[string][]byte, error)
+}
+
+// StateConfig describes how to construct a StateStore.
+type StateConfig struct {
+	// Driver is one of memory, postgres or redis.
+	Driver string
+	// DSN is the PostgreSQL connection string used by the postgres driver.
+	DSN string
+	// Redis configures the redis driver.
+	Redis RESPOptions
+	// KeyPrefix namespaces the keys written by the redis driver.
+	KeyPrefix string
+}
+
+// NewStateStore creates a StateStore based on the provided configuration.
+func NewStateStore(ctx context.Context, cfg StateConfig) (StateStore, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	switch driver := strings.ToLower(strings.TrimSpace(cfg.Driver)); driver {
+	case "", "memory":
+		return NewMemoryStateStore(), noop, nil
+	case "postgres", "postgresql", "pgx":
+		if strings.TrimSpace(cfg.DSN) == "" {
+			return nil, nil, errors.New("state store dsn is required for postgres driver")
+		}
+		db, err := sql.Open("pgx", cfg.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return NewPostgresStateStore(db), func(context.Context) error { return db.Close() }, nil
+	case "redis":
+		client, err := NewRESPClient(cfg.Redis)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := client.Ping(ctx); err != nil {
+			client.Close()
+			return nil, nil, err
+		}
+		return NewRedisStateStore(client, cfg.KeyPrefix), func(context.Context) error { return client.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported state store driver %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryStateEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryStateStore is a process-local StateStore. It is suitable for single
+// replica deployments and tests.
+type MemoryStateStore struct {
+	mu      sync.Mutex
+	entries map[string]map[string]memoryStateEntry
+	now     func() time.Time
+}
+
+// NewMemoryStateStore constructs an empty in-memory state store.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		entries: make(map[string]map[string]memoryStateEntry),
+		now:     time.Now,
+	}
+}
+
+// Get implements StateStore.
+func (s *MemoryStateStore) Get(ctx context.Context, namespace, key string) ([]byte, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.lookup(namespace, key)
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	return cloneBytes(entry.value), nil
+}
+
+// Set implements StateStore.
+func (s *MemoryStateStore) Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store(namespace, key, value, ttl)
+	return nil
+}
+
+// Update implements StateStore.
+func (s *MemoryStateStore) Update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error) {
+	return s.update(ctx, namespace, key, fn, false)
+}
+
+// Upsert implements StateStore.
+func (s *MemoryStateStore) Upsert(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error) {
+	return s.update(ctx, namespace, key, fn, true)
+}
+
+func (s *MemoryStateStore) update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error), upsert bool) ([]byte, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.lookup(namespace, key)
+	if !ok && !upsert {
+		return nil, ErrStateNotFound
+	}
+	next, ttl, err := fn(cloneBytes(entry.value))
+	if err != nil {
+		return nil, err
+	}
+	s.store(namespace, key, next, ttl)
+	return next, nil
+}
+
+// Take implements StateStore.
+func (s *MemoryStateStore) Take(ctx context.Context, namespace, key string) ([]byte, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.lookup(namespace, key)
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	delete(s.entries[namespace], key)
+	return entry.value, nil
+}
+
+// Delete implements StateStore.
+func (s *MemoryStateStore) Delete(ctx context.Context, namespace, key string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries[namespace], key)
+	return nil
+}
+
+// Entries implements StateStore.
+func (s *MemoryStateStore) Entries(ctx context.Context, namespace string) (map[string][]byte, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	result := make(map[string][]byte)
+	for key, entry := range s.entries[namespace] {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries[namespace], key)
+			continue
+		}
+		result[key] = cloneBytes(entry.value)
+	}
+	return result, nil
+}
+
+// PurgeExpired drops expired entries and returns how many were removed.
+func (s *MemoryStateStore) PurgeExpired(ctx context.Context) (int64, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var removed int64
+	for _, entries := range s.entries {
+		for key, entry := range entries {
+			if !now.Before(entry.expiresAt) {
+				delete(entries, key)
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStateStore) lookup(namespace, key string) (memoryStateEntry, bool) {
+	entry, ok := s.entries[namespace][key]
+	if !ok {
+		return memoryStateEntry{}, false
+	}
+	if !s.now().Before(entry.expiresAt) {
+		delete(s.entries[namespace], key)
+		return memoryStateEntry{}, false
+	}
+	return entry, true
+}
+
+func (s *MemoryStateStore) store(namespace, key string, value []byte, ttl time.Duration) {
+	if value == nil || ttl <= 0 {
+		delete(s.entries[namespace], key)
+		return
+	}
+	entries, ok := s.entries[namespace]
+	if !ok {
+		entries = make(map[string]memoryStateEntry)
+		s.entries[namespace] = entries
+	}
+	entries[key] = memoryStateEntry{value: cloneBytes(value), expiresAt: s.now().Add(ttl)}
+}
+
+func cloneBytes(src []byte) []byte {
+	if src == nil {
+		return nil
+	}
+	return append([]byte(nil), src...)
+}
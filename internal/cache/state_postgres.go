@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStateStore keeps state in the auth_ephemeral_state table created by
+// sql/20260412_auth_ephemeral_state.sql. Expiry is checked on read; expired
+// rows are removed by PurgeExpired.
+type PostgresStateStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewPostgresStateStore constructs a state store backed by db.
+func NewPostgresStateStore(db *sql.DB) *PostgresStateStore {
+	return &PostgresStateStore{db: db, now: time.Now}
+}
+
+// Get implements StateStore.
+func (s *PostgresStateStore) Get(ctx context.Context, namespace, key string) ([]byte, error) {
+	const query = `
+		SELECT value FROM auth_ephemeral_state
+		WHERE namespace = $1 AND key = $2 AND expires_at > $3`
+	var value []byte
+	if err := s.db.QueryRowContext(ctx, query, namespace, key, s.now().UTC()).Scan(&value); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set implements StateStore.
+func (s *PostgresStateStore) Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error {
+	return s.write(ctx, s.db, namespace, key, value, ttl)
+}
+
+// Update implements StateStore.
+func (s *PostgresStateStore) Update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error) {
+	return s.update(ctx, namespace, key, fn, false)
+}
+
+// Upsert implements StateStore. An already expired placeholder row is
+// inserted first so that concurrent callers serialize on its row lock.
+func (s *PostgresStateStore) Upsert(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error) {
+	return s.update(ctx, namespace, key, fn, true)
+}
+
+func (s *PostgresStateStore) update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error), upsert bool) ([]byte, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := s.now().UTC()
+	if upsert {
+		const placeholder = `
+			INSERT INTO auth_ephemeral_state (namespace, key, value, expires_at)
+			VALUES ($1, $2, ''::bytea, $3)
+			ON CONFLICT (namespace, key) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, placeholder, namespace, key, now); err != nil {
+			return nil, err
+		}
+	}
+
+	const query = `
+		SELECT value, expires_at FROM auth_ephemeral_state
+		WHERE namespace = $1 AND key = $2
+		FOR UPDATE`
+	var (
+		current   []byte
+		expiresAt time.Time
+	)
+	if err := tx.QueryRowContext(ctx, query, namespace, key).Scan(&current, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+	if !now.Before(expiresAt) {
+		if !upsert {
+			return nil, ErrStateNotFound
+		}
+		current = nil
+	}
+
+	next, ttl, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(ctx, tx, namespace, key, next, ttl); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// Take implements StateStore.
+func (s *PostgresStateStore) Take(ctx context.Context, namespace, key string) ([]byte, error) {
+	const query = `
+		DELETE FROM auth_ephemeral_state
+		WHERE namespace = $1 AND key = $2
+		RETURNING value, expires_at`
+	var (
+		value     []byte
+		expiresAt time.Time
+	)
+	if err := s.db.QueryRowContext(ctx, query, namespace, key).Scan(&value, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrStateNotFound
+		}
+		return nil, err
+	}
+	if !s.now().Before(expiresAt) {
+		return nil, ErrStateNotFound
+	}
+	return value, nil
+}
+
+// Delete implements StateStore.
+func (s *PostgresStateStore) Delete(ctx context.Context, namespace, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_ephemeral_state WHERE namespace = $1 AND key = $2`, namespace, key)
+	return err
+}
+
+// Entries implements StateStore.
+func (s *PostgresStateStore) Entries(ctx context.Context, namespace string) (map[string][]byte, error) {
+	const query = `
+		SELECT key, value FROM auth_ephemeral_state
+		WHERE namespace = $1 AND expires_at > $2`
+	rows, err := s.db.QueryContext(ctx, query, namespace, s.now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]byte)
+	for rows.Next() {
+		var (
+			key   string
+			value []byte
+		)
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
+
+// PurgeExpired deletes expired rows and returns how many were removed.
+func (s *PostgresStateStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_ephemeral_state WHERE expires_at <= $1`, s.now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *PostgresStateStore) write(ctx context.Context, db sqlExecer, namespace, key string, value []byte, ttl time.Duration) error {
+	if value == nil || ttl <= 0 {
+		_, err := db.ExecContext(ctx, `DELETE FROM auth_ephemeral_state WHERE namespace = $1 AND key = $2`, namespace, key)
+		return err
+	}
+	const query = `
+		INSERT INTO auth_ephemeral_state (namespace, key, value, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (namespace, key) DO UPDATE SET
+			value = EXCLUDED.value,
+			expires_at = EXCLUDED.expires_at`
+	_, err := db.ExecContext(ctx, query, namespace, key, value, s.now().UTC().Add(ttl))
+	return err
+}
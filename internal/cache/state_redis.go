@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStateKeyPrefix   = "account:state:"
+	redisUpdateMaxAttempts  = 5
+	redisEntriesScanCount   = "200"
+	redisStateKeySeparator  = ":"
+	redisGlobSpecialCharset = `*?[]\`
+)
+
+// errStateConflict is returned when an optimistic update keeps losing races.
+var errStateConflict = errors.New("state update conflict")
+
+// RedisStateStore keeps state in a Redis-compatible server. Keys are written
+// as {prefix}{namespace}:{key} with a PX expiry, and Update relies on
+// WATCH/MULTI/EXEC for atomicity.
+type RedisStateStore struct {
+	client *RESPClient
+	prefix string
+}
+
+// NewRedisStateStore constructs a state store on top of client. An empty
+// prefix defaults to "account:state:".
+func NewRedisStateStore(client *RESPClient, prefix string) *RedisStateStore {
+	if prefix == "" {
+		prefix = defaultStateKeyPrefix
+	}
+	return &RedisStateStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStateStore) key(namespace, key string) string {
+	return s.prefix + namespace + redisStateKeySeparator + key
+}
+
+// Get implements StateStore.
+func (s *RedisStateStore) Get(ctx context.Context, namespace, key string) ([]byte, error) {
+	reply, err := s.client.Do(ctx, "GET", s.key(namespace, key))
+	if err != nil {
+		return nil, err
+	}
+	value, ok, err := respBytes(reply)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	return value, nil
+}
+
+// Set implements StateStore.
+func (s *RedisStateStore) Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error {
+	_, err := s.client.Do(ctx, writeStateCommand(s.key(namespace, key), value, ttl)...)
+	return err
+}
+
+// Update implements StateStore.
+func (s *RedisStateStore) Update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error) {
+	return s.update(ctx, namespace, key, fn, false)
+}
+
+// Upsert implements StateStore. WATCH also fails the transaction when
+// another client creates the key, so creation races are retried too.
+func (s *RedisStateStore) Upsert(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error)) ([]byte, error) {
+	return s.update(ctx, namespace, key, fn, true)
+}
+
+func (s *RedisStateStore) update(ctx context.Context, namespace, key string, fn func(current []byte) ([]byte, time.Duration, error), upsert bool) ([]byte, error) {
+	fullKey := s.key(namespace, key)
+	for attempt := 0; attempt < redisUpdateMaxAttempts; attempt++ {
+		var (
+			next      []byte
+			committed bool
+		)
+		err := s.client.withConn(ctx, func(conn *respConn) error {
+			if _, err := conn.do("WATCH", fullKey); err != nil {
+				return err
+			}
+			reply, err := conn.do("GET", fullKey)
+			if err != nil {
+				return err
+			}
+			current, ok, err := respBytes(reply)
+			if err != nil || (!ok && !upsert) {
+				if _, unwatchErr := conn.do("UNWATCH"); unwatchErr != nil {
+					return unwatchErr
+				}
+				if err != nil {
+					return err
+				}
+				return ErrStateNotFound
+			}
+
+			var ttl time.Duration
+			next, ttl, err = fn(current)
+			if err != nil {
+				if _, unwatchErr := conn.do("UNWATCH"); unwatchErr != nil {
+					return unwatchErr
+				}
+				return err
+			}
+
+			if _, err := conn.do("MULTI"); err != nil {
+				return err
+			}
+			if _, err := conn.do(writeStateCommand(fullKey, next, ttl)...); err != nil {
+				_, _ = conn.do("DISCARD")
+				return err
+			}
+			result, err := conn.do("EXEC")
+			if err != nil {
+				return err
+			}
+			committed = result != nil
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if committed {
+			return next, nil
+		}
+	}
+	return nil, errStateConflict
+}
+
+// Take implements StateStore.
+func (s *RedisStateStore) Take(ctx context.Context, namespace, key string) ([]byte, error) {
+	fullKey := s.key(namespace, key)
+	var reply any
+	err := s.client.withConn(ctx, func(conn *respConn) error {
+		if _, err := conn.do("MULTI"); err != nil {
+			return err
+		}
+		if _, err := conn.do("GET", fullKey); err != nil {
+			_, _ = conn.do("DISCARD")
+			return err
+		}
+		if _, err := conn.do("DEL", fullKey); err != nil {
+			_, _ = conn.do("DISCARD")
+			return err
+		}
+		var err error
+		reply, err = conn.do("EXEC")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	results, ok := reply.([]any)
+	if !ok || len(results) == 0 {
+		return nil, fmt.Errorf("resp: unexpected EXEC reply %T", reply)
+	}
+	value, found, err := respBytes(results[0])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrStateNotFound
+	}
+	return value, nil
+}
+
+// Delete implements StateStore.
+func (s *RedisStateStore) Delete(ctx context.Context, namespace, key string) error {
+	_, err := s.client.Do(ctx, "DEL", s.key(namespace, key))
+	return err
+}
+
+// Entries implements StateStore.
+func (s *RedisStateStore) Entries(ctx context.Context, namespace string) (map[string][]byte, error) {
+	prefix := s.key(namespace, "")
+	pattern := escapeGlob(prefix) + "*"
+
+	result := make(map[string][]byte)
+	cursor := "0"
+	for {
+		reply, err := s.client.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", redisEntriesScanCount)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("resp: unexpected SCAN reply %T", reply)
+		}
+		next, _, err := respBytes(page[0])
+		if err != nil {
+			return nil, err
+		}
+		keys, _ := page[1].([]any)
+		if len(keys) > 0 {
+			args := make([]string, 0, len(keys)+1)
+			args = append(args, "MGET")
+			for _, raw := range keys {
+				key, _, err := respBytes(raw)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, string(key))
+			}
+			values, err := s.client.Do(ctx, args...)
+			if err != nil {
+				return nil, err
+			}
+			items, _ := values.([]any)
+			for i, raw := range items {
+				value, found, err := respBytes(raw)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					result[strings.TrimPrefix(args[i+1], prefix)] = value
+				}
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return result, nil
+		}
+	}
+}
+
+func writeStateCommand(key string, value []byte, ttl time.Duration) []string {
+	if value == nil || ttl <= 0 {
+		return []string{"DEL", key}
+	}
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	return []string{"SET", key, string(value), "PX", strconv.FormatInt(ms, 10)}
+}
+
+func escapeGlob(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(redisGlobSpecialCharset, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStateStore(t *testing.T) {
+	testStateStore(t, NewMemoryStateStore())
+}
+
+func TestRedisStateStore(t *testing.T) {
+	server := newFakeRESPServer(t, "secret")
+	client, err := NewRESPClient(RESPOptions{Addr: server.Addr(), Password: "secret"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	defer client.Close()
+
+	testStateStore(t, NewRedisStateStore(client, ""))
+}
+
+func TestRedisStateStoreRetriesConflictingUpdates(t *testing.T) {
+	server := newFakeRESPServer(t, "")
+	client, err := NewRESPClient(RESPOptions{Addr: server.Addr()})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	states := NewRedisStateStore(client, "test:")
+	if err := states.Set(ctx, "ns", "key", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	calls := 0
+	next, err := states.Update(ctx, "ns", "key", func(current []byte) ([]byte, time.Duration, error) {
+		calls++
+		if calls == 1 {
+			// Simulate another replica writing between WATCH and EXEC.
+			server.Touch("test:ns:key")
+		}
+		return append(current, '+'), time.Minute, nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if calls != 2 || string(next) != "1+" {
+		t.Fatalf("expected one retry, got %d calls and %q", calls, next)
+	}
+}
+
+func testStateStore(t *testing.T, states StateStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := states.Get(ctx, "ns", "missing"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("expected ErrStateNotFound, got %v", err)
+	}
+
+	if err := states.Set(ctx, "ns", "a", []byte("alpha"), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := states.Set(ctx, "other", "a", []byte("other"), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	value, err := states.Get(ctx, "ns", "a")
+	if err != nil || string(value) != "alpha" {
+		t.Fatalf("get: %q, %v", value, err)
+	}
+
+	updated, err := states.Update(ctx, "ns", "a", func(current []byte) ([]byte, time.Duration, error) {
+		return append(current, "-beta"...), time.Minute, nil
+	})
+	if err != nil || string(updated) != "alpha-beta" {
+		t.Fatalf("update: %q, %v", updated, err)
+	}
+	if _, err := states.Update(ctx, "ns", "missing", func([]byte) ([]byte, time.Duration, error) {
+		t.Fatalf("update callback must not run for missing keys")
+		return nil, 0, nil
+	}); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("expected ErrStateNotFound, got %v", err)
+	}
+
+	for i, want := range []string{"1", "11"} {
+		counted, err := states.Upsert(ctx, "counter", "k", func(current []byte) ([]byte, time.Duration, error) {
+			if i == 0 && current != nil {
+				t.Fatalf("expected nil value for a missing key, got %q", current)
+			}
+			return append(current, '1'), time.Minute, nil
+		})
+		if err != nil || string(counted) != want {
+			t.Fatalf("upsert %d: %q, %v", i, counted, err)
+		}
+	}
+
+	if err := states.Set(ctx, "ns", "b", []byte("bravo"), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	entries, err := states.Entries(ctx, "ns")
+	if err != nil {
+		t.Fatalf("entries: %v", err)
+	}
+	if len(entries) != 2 || string(entries["a"]) != "alpha-beta" || string(entries["b"]) != "bravo" {
+		t.Fatalf("unexpected entries: %q", entries)
+	}
+
+	taken, err := states.Take(ctx, "ns", "b")
+	if err != nil || string(taken) != "bravo" {
+		t.Fatalf("take: %q, %v", taken, err)
+	}
+	if _, err := states.Take(ctx, "ns", "b"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("expected second take to miss, got %v", err)
+	}
+
+	if _, err := states.Update(ctx, "ns", "a", func([]byte) ([]byte, time.Duration, error) {
+		return nil, 0, nil
+	}); err != nil {
+		t.Fatalf("update to delete: %v", err)
+	}
+	if _, err := states.Get(ctx, "ns", "a"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("expected deleted key to miss, got %v", err)
+	}
+
+	if err := states.Set(ctx, "ns", "short", []byte("x"), 20*time.Millisecond); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := states.Get(ctx, "ns", "short"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("expected expired key to miss, got %v", err)
+	}
+
+	if err := states.Delete(ctx, "other", "a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := states.Delete(ctx, "other", "a"); err != nil {
+		t.Fatalf("delete of absent key: %v", err)
+	}
+}
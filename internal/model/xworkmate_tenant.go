@@ -26,14 +26,15 @@ func (tenant *Tenant) BeforeCreate(tx *gorm.DB) error {
 }
 
 type TenantDomain struct {
-	ID        string    `gorm:"column:id;type:text;primaryKey"`
-	TenantID  string    `gorm:"column:tenant_id;type:text;not null;index"`
-	Domain    string    `gorm:"column:domain;type:text;not null;uniqueIndex"`
-	Kind      string    `gorm:"column:kind;type:text;not null"`
-	IsPrimary bool      `gorm:"column:is_primary;not null;default:false"`
-	Status    string    `gorm:"column:status;type:text;not null;index"`
-	CreatedAt time.Time `gorm:"column:created_at;not null;autoCreateTime"`
-	UpdatedAt time.Time `gorm:"column:updated_at;not null;autoUpdateTime"`
+	ID                string    `gorm:"column:id;type:text;primaryKey"`
+	TenantID          string    `gorm:"column:tenant_id;type:text;not null;index"`
+	Domain            string    `gorm:"column:domain;type:text;not null;uniqueIndex"`
+	Kind              string    `gorm:"column:kind;type:text;not null"`
+	IsPrimary         bool      `gorm:"column:is_primary;not null;default:false"`
+	Status            string    `gorm:"column:status;type:text;not null;index"`
+	VerificationToken string    `gorm:"column:verification_token;type:text;not null;default:''"`
+	CreatedAt         time.Time `gorm:"column:created_at;not null;autoCreateTime"`
+	UpdatedAt         time.Time `gorm:"column:updated_at;not null;autoUpdateTime"`
 }
 
 func (TenantDomain) TableName() string { return "tenant_domains" }
@@ -46,15 +47,38 @@ func (domain *TenantDomain) BeforeCreate(tx *gorm.DB) error {
 }
 
 type TenantMembership struct {
-	TenantID  string    `gorm:"column:tenant_id;type:text;primaryKey"`
-	UserID    string    `gorm:"column:user_id;type:text;primaryKey"`
-	Role      string    `gorm:"column:role;type:text;not null;index"`
-	CreatedAt time.Time `gorm:"column:created_at;not null;autoCreateTime"`
-	UpdatedAt time.Time `gorm:"column:updated_at;not null;autoUpdateTime"`
+	TenantID    string    `gorm:"column:tenant_id;type:text;primaryKey"`
+	UserID      string    `gorm:"column:user_id;type:text;primaryKey"`
+	Role        string    `gorm:"column:role;type:text;not null;index"`
+	ExternalID  string    `gorm:"column:external_id;type:text;not null;default:''"`
+	Provisioned bool      `gorm:"column:provisioned;not null;default:false"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null;autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;not null;autoUpdateTime"`
 }
 
 func (TenantMembership) TableName() string { return "tenant_memberships" }
 
+type TenantInvitation struct {
+	ID         string     `gorm:"column:id;type:text;primaryKey"`
+	TenantID   string     `gorm:"column:tenant_id;type:text;not null;index"`
+	Email      string     `gorm:"column:email;type:text;not null"`
+	Role       string     `gorm:"column:role;type:text;not null"`
+	TokenHash  string     `gorm:"column:token_hash;type:text;not null;uniqueIndex"`
+	InvitedBy  string     `gorm:"column:invited_by;type:text;not null;default:''"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at;not null"`
+	AcceptedAt *time.Time `gorm:"column:accepted_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at;not null;autoCreateTime"`
+}
+
+func (TenantInvitation) TableName() string { return "tenant_invitations" }
+
+func (invitation *TenantInvitation) BeforeCreate(tx *gorm.DB) error {
+	if strings.TrimSpace(invitation.ID) == "" {
+		invitation.ID = uuid.NewString()
+	}
+	return nil
+}
+
 type XWorkmateProfile struct {
 	ID                 string                   `gorm:"column:id;type:text;primaryKey"`
 	TenantID           string                   `gorm:"column:tenant_id;type:text;not null;uniqueIndex:idx_xworkmate_profiles_scope"`
@@ -0,0 +1,331 @@
+// Package ratelimit throttles authentication attempts and outbound email.
+// Counters live in a cache.StateStore so that every replica sharing the
+// store enforces the same limits.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"account/internal/cache"
+)
+
+const namespacePrefix = "ratelimit:"
+
+// Rule describes one limit. At most Limit hits are allowed in any sliding
+// Window. When Lockout is set, reaching the limit locks the key for Lockout,
+// doubling with every further lockout up to MaxLockout; without it the key is
+// simply refused until the oldest hit leaves the window.
+type Rule struct {
+	Name       string
+	Limit      int
+	Window     time.Duration
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// Enabled reports whether the rule limits anything.
+func (r Rule) Enabled() bool {
+	return r.Limit > 0 && r.Window > 0
+}
+
+// Decision is the outcome of a check. RetryAfter is set when Allowed is false.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+func allow() Decision {
+	return Decision{Allowed: true}
+}
+
+// record holds the hits of one key. Hits are settled failures and email
+// sends; Pending are attempts reserved by Reserve that have not been
+// committed or refunded yet. Both count towards the limit.
+type record struct {
+	Hits        []time.Time `json:"hits,omitempty"`
+	Pending     []time.Time `json:"pending,omitempty"`
+	LockedUntil time.Time   `json:"lockedUntil,omitempty"`
+	Lockouts    int         `json:"lockouts,omitempty"`
+}
+
+// Limiter evaluates rules against a StateStore.
+type Limiter struct {
+	states cache.StateStore
+	now    func() time.Time
+}
+
+// New returns a Limiter that keeps its counters in states.
+func New(states cache.StateStore) *Limiter {
+	return &Limiter{states: states, now: time.Now}
+}
+
+// Check reports whether key may make another attempt without recording one.
+func (l *Limiter) Check(ctx context.Context, rule Rule, key string) (Decision, error) {
+	if l == nil || !rule.Enabled() || strings.TrimSpace(key) == "" {
+		return allow(), nil
+	}
+	raw, err := l.states.Get(ctx, namespacePrefix+rule.Name, normalizeKey(key))
+	if err != nil {
+		if errors.Is(err, cache.ErrStateNotFound) {
+			return allow(), nil
+		}
+		return allow(), err
+	}
+	var rec record
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return allow(), nil
+	}
+	now := l.now()
+	rec.prune(rule, now)
+	return rec.decide(rule, now), nil
+}
+
+// Allow records a hit for key if it is within its limit. It suits actions
+// that are limited whether or not they succeed, such as sending email.
+func (l *Limiter) Allow(ctx context.Context, rule Rule, key string) (Decision, error) {
+	return l.hit(ctx, rule, key, true)
+}
+
+// Fail records a failed attempt for key, locking it once the rule's limit is
+// reached. The returned decision applies to the next attempt.
+func (l *Limiter) Fail(ctx context.Context, rule Rule, key string) (Decision, error) {
+	return l.hit(ctx, rule, key, false)
+}
+
+// Reserve atomically records a pending attempt for key before its outcome is
+// known, refusing it while the key is locked or while earlier attempts,
+// pending or failed, already fill the window. Every allowed reservation must
+// be settled with Commit when the attempt fails or Refund when it does not
+// count, so that concurrent attempts cannot all pass a check made before any
+// of them was recorded.
+func (l *Limiter) Reserve(ctx context.Context, rule Rule, key string) (Decision, error) {
+	if l == nil || !rule.Enabled() || strings.TrimSpace(key) == "" {
+		return allow(), nil
+	}
+	decision := allow()
+	_, err := l.states.Upsert(ctx, namespacePrefix+rule.Name, normalizeKey(key), func(current []byte) ([]byte, time.Duration, error) {
+		var rec record
+		if len(current) > 0 {
+			_ = json.Unmarshal(current, &rec)
+		}
+		now := l.now()
+		rec.prune(rule, now)
+		if decision = rec.decide(rule, now); !decision.Allowed {
+			return rec.encode(rule, now)
+		}
+		if rec.count() >= rule.Limit {
+			decision = Decision{RetryAfter: rec.oldest().Add(rule.Window).Sub(now)}
+			return rec.encode(rule, now)
+		}
+		rec.Pending = append(rec.Pending, now)
+		return rec.encode(rule, now)
+	})
+	if err != nil {
+		return allow(), err
+	}
+	return decision, nil
+}
+
+// Commit turns a reserved attempt into a failure, locking key once the
+// rule's limit is reached. The returned decision applies to the next attempt.
+func (l *Limiter) Commit(ctx context.Context, rule Rule, key string) (Decision, error) {
+	if l == nil || !rule.Enabled() || strings.TrimSpace(key) == "" {
+		return allow(), nil
+	}
+	decision := allow()
+	_, err := l.states.Update(ctx, namespacePrefix+rule.Name, normalizeKey(key), func(current []byte) ([]byte, time.Duration, error) {
+		var rec record
+		_ = json.Unmarshal(current, &rec)
+		now := l.now()
+		rec.prune(rule, now)
+		if n := len(rec.Pending); n > 0 {
+			rec.Hits = append(rec.Hits, rec.Pending[0])
+			rec.Pending = rec.Pending[1:]
+		} else {
+			rec.Hits = append(rec.Hits, now)
+		}
+		if rec.count() >= rule.Limit && rule.Lockout > 0 {
+			rec.Lockouts++
+			rec.LockedUntil = now.Add(rule.lockoutFor(rec.Lockouts))
+			rec.Hits = nil
+		}
+		decision = rec.decide(rule, now)
+		return rec.encode(rule, now)
+	})
+	if err != nil && !errors.Is(err, cache.ErrStateNotFound) {
+		return allow(), err
+	}
+	return decision, nil
+}
+
+// Refund gives back a reserved attempt that did not fail.
+func (l *Limiter) Refund(ctx context.Context, rule Rule, key string) error {
+	if l == nil || !rule.Enabled() || strings.TrimSpace(key) == "" {
+		return nil
+	}
+	_, err := l.states.Update(ctx, namespacePrefix+rule.Name, normalizeKey(key), func(current []byte) ([]byte, time.Duration, error) {
+		var rec record
+		_ = json.Unmarshal(current, &rec)
+		now := l.now()
+		rec.prune(rule, now)
+		if n := len(rec.Pending); n > 0 {
+			rec.Pending = rec.Pending[:n-1]
+		}
+		return rec.encode(rule, now)
+	})
+	if errors.Is(err, cache.ErrStateNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Reset forgets key's recent hits, for example after a successful sign-in.
+// Attempts still pending are kept, as they are settled by Commit or Refund,
+// and so is the escalation history until the record expires.
+func (l *Limiter) Reset(ctx context.Context, rule Rule, key string) error {
+	if l == nil || !rule.Enabled() || strings.TrimSpace(key) == "" {
+		return nil
+	}
+	_, err := l.states.Update(ctx, namespacePrefix+rule.Name, normalizeKey(key), func(current []byte) ([]byte, time.Duration, error) {
+		var rec record
+		if err := json.Unmarshal(current, &rec); err != nil {
+			return nil, 0, nil
+		}
+		now := l.now()
+		rec.prune(rule, now)
+		rec.Hits = nil
+		return rec.encode(rule, now)
+	})
+	if errors.Is(err, cache.ErrStateNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (l *Limiter) hit(ctx context.Context, rule Rule, key string, refuseOverLimit bool) (Decision, error) {
+	if l == nil || !rule.Enabled() || strings.TrimSpace(key) == "" {
+		return allow(), nil
+	}
+	decision := allow()
+	_, err := l.states.Upsert(ctx, namespacePrefix+rule.Name, normalizeKey(key), func(current []byte) ([]byte, time.Duration, error) {
+		var rec record
+		if len(current) > 0 {
+			_ = json.Unmarshal(current, &rec)
+		}
+		now := l.now()
+		rec.prune(rule, now)
+
+		if refuseOverLimit {
+			if decision = rec.decide(rule, now); !decision.Allowed {
+				return rec.encode(rule, now)
+			}
+		}
+
+		rec.Hits = append(rec.Hits, now)
+		if rec.count() >= rule.Limit && rule.Lockout > 0 {
+			rec.Lockouts++
+			rec.LockedUntil = now.Add(rule.lockoutFor(rec.Lockouts))
+			rec.Hits = nil
+		}
+		if !refuseOverLimit {
+			decision = rec.decide(rule, now)
+		}
+		return rec.encode(rule, now)
+	})
+	if err != nil {
+		return allow(), err
+	}
+	return decision, nil
+}
+
+// lockoutFor returns the lockout for the n-th consecutive lockout.
+func (r Rule) lockoutFor(n int) time.Duration {
+	lockout := r.Lockout
+	for i := 1; i < n && (r.MaxLockout <= 0 || lockout < r.MaxLockout); i++ {
+		lockout *= 2
+	}
+	if r.MaxLockout > 0 && lockout > r.MaxLockout {
+		return r.MaxLockout
+	}
+	return lockout
+}
+
+func (rec *record) prune(rule Rule, now time.Time) {
+	rec.Hits = pruneHits(rec.Hits, now.Add(-rule.Window))
+	rec.Pending = pruneHits(rec.Pending, now.Add(-rule.Window))
+}
+
+func pruneHits(hits []time.Time, cutoff time.Time) []time.Time {
+	kept := hits[:0]
+	for _, hit := range hits {
+		if hit.After(cutoff) {
+			kept = append(kept, hit)
+		}
+	}
+	return kept
+}
+
+// count returns the hits counting towards the limit, pending ones included.
+func (rec record) count() int {
+	return len(rec.Hits) + len(rec.Pending)
+}
+
+// oldest returns the earliest hit, pending or not. The record must not be
+// empty.
+func (rec record) oldest() time.Time {
+	switch {
+	case len(rec.Pending) == 0:
+		return rec.Hits[0]
+	case len(rec.Hits) == 0 || rec.Pending[0].Before(rec.Hits[0]):
+		return rec.Pending[0]
+	}
+	return rec.Hits[0]
+}
+
+func (rec record) decide(rule Rule, now time.Time) Decision {
+	if now.Before(rec.LockedUntil) {
+		return Decision{RetryAfter: rec.LockedUntil.Sub(now)}
+	}
+	if rule.Lockout <= 0 && rec.count() >= rule.Limit {
+		return Decision{RetryAfter: rec.oldest().Add(rule.Window).Sub(now)}
+	}
+	return allow()
+}
+
+// encode serializes the record with a TTL long enough to cover its window,
+// any active lockout and, after a lockout, the escalation memory.
+func (rec record) encode(rule Rule, now time.Time) ([]byte, time.Duration, error) {
+	expiresAt := now
+	if n := len(rec.Hits); n > 0 {
+		expiresAt = rec.Hits[n-1].Add(rule.Window)
+	}
+	if n := len(rec.Pending); n > 0 && rec.Pending[n-1].Add(rule.Window).After(expiresAt) {
+		expiresAt = rec.Pending[n-1].Add(rule.Window)
+	}
+	if rec.Lockouts > 0 {
+		memory := rule.MaxLockout
+		if memory < rule.Window {
+			memory = rule.Window
+		}
+		if until := rec.LockedUntil.Add(memory); until.After(expiresAt) {
+			expiresAt = until
+		}
+	}
+	ttl := expiresAt.Sub(now)
+	if ttl <= 0 {
+		return nil, 0, nil
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return nil, 0, err
+	}
+	return raw, ttl, nil
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
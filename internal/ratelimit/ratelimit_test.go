@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"account/internal/cache"
+)
+
+func newTestLimiter() (*Limiter, *time.Time) {
+	now := time.Date(2026, 4, 24, 12, 0, 0, 0, time.UTC)
+	limiter := New(cache.NewMemoryStateStore())
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestFailLocksOutAndEscalates(t *testing.T) {
+	ctx := context.Background()
+	limiter, now := newTestLimiter()
+	rule := Rule{Name: "account", Limit: 3, Window: 10 * time.Minute, Lockout: time.Minute, MaxLockout: 3 * time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if d, err := limiter.Fail(ctx, rule, "User@Example.com"); err != nil || !d.Allowed {
+			t.Fatalf("failure %d: expected to stay allowed, got %+v, %v", i, d, err)
+		}
+	}
+	d, _ := limiter.Fail(ctx, rule, "user@example.com")
+	if d.Allowed || d.RetryAfter != time.Minute {
+		t.Fatalf("expected a one minute lockout on the third failure, got %+v", d)
+	}
+	if d, _ := limiter.Check(ctx, rule, "user@example.com"); d.Allowed {
+		t.Fatalf("expected check to report the lockout")
+	}
+	if d, _ := limiter.Check(ctx, rule, "other@example.com"); !d.Allowed {
+		t.Fatalf("expected other keys to be unaffected")
+	}
+
+	*now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		limiter.Fail(ctx, rule, "user@example.com")
+	}
+	if d, _ := limiter.Fail(ctx, rule, "user@example.com"); d.RetryAfter != 2*time.Minute {
+		t.Fatalf("expected the second lockout to double, got %+v", d)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		d, _ = limiter.Fail(ctx, rule, "user@example.com")
+	}
+	if d.RetryAfter != 3*time.Minute {
+		t.Fatalf("expected lockout to be capped at MaxLockout, got %+v", d)
+	}
+}
+
+func TestAllowUsesSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter, now := newTestLimiter()
+	rule := Rule{Name: "email", Limit: 2, Window: 10 * time.Minute}
+
+	limiter.Allow(ctx, rule, "a@example.com")
+	*now = now.Add(4 * time.Minute)
+	limiter.Allow(ctx, rule, "a@example.com")
+
+	d, _ := limiter.Allow(ctx, rule, "a@example.com")
+	if d.Allowed || d.RetryAfter != 6*time.Minute {
+		t.Fatalf("expected refusal until the oldest hit leaves the window, got %+v", d)
+	}
+
+	*now = now.Add(6 * time.Minute)
+	if d, _ := limiter.Allow(ctx, rule, "a@example.com"); !d.Allowed {
+		t.Fatalf("expected a slot once the oldest hit expired, got %+v", d)
+	}
+}
+
+func TestResetClearsHits(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+	rule := Rule{Name: "account", Limit: 2, Window: time.Hour, Lockout: time.Minute}
+
+	limiter.Fail(ctx, rule, "user")
+	if err := limiter.Reset(ctx, rule, "user"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if d, _ := limiter.Fail(ctx, rule, "user"); !d.Allowed {
+		t.Fatalf("expected reset to clear earlier failures, got %+v", d)
+	}
+}
+
+func TestResetKeepsPendingAttempts(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+	rule := Rule{Name: "account", Limit: 2, Window: time.Hour, Lockout: time.Minute}
+
+	limiter.Reserve(ctx, rule, "user")
+	limiter.Reserve(ctx, rule, "user")
+	if err := limiter.Reset(ctx, rule, "user"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if d, _ := limiter.Reserve(ctx, rule, "user"); d.Allowed {
+		t.Fatalf("expected in-flight attempts to survive a reset")
+	}
+	if d, _ := limiter.Commit(ctx, rule, "user"); d.Allowed || d.RetryAfter != time.Minute {
+		t.Fatalf("expected committing after a reset to still lock the key, got %+v", d)
+	}
+}
+
+func TestReserveCountsPendingAttempts(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+	rule := Rule{Name: "account", Limit: 3, Window: time.Hour, Lockout: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		if d, err := limiter.Reserve(ctx, rule, "user"); err != nil || !d.Allowed {
+			t.Fatalf("reservation %d: expected to be allowed, got %+v, %v", i, d, err)
+		}
+	}
+	if d, _ := limiter.Reserve(ctx, rule, "user"); d.Allowed {
+		t.Fatalf("expected pending attempts to fill the window")
+	}
+
+	if err := limiter.Refund(ctx, rule, "user"); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if d, _ := limiter.Reserve(ctx, rule, "user"); !d.Allowed {
+		t.Fatalf("expected a refunded attempt to free a slot, got %+v", d)
+	}
+	if d, _ := limiter.Commit(ctx, rule, "user"); d.Allowed || d.RetryAfter != time.Minute {
+		t.Fatalf("expected committing a full window to lock the key, got %+v", d)
+	}
+}
+
+func TestRefundDoesNotCountSuccessfulAttempts(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+	rule := Rule{Name: "account", Limit: 3, Window: time.Hour, Lockout: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		limiter.Reserve(ctx, rule, "user")
+		if d, _ := limiter.Commit(ctx, rule, "user"); !d.Allowed {
+			t.Fatalf("failure %d: expected to stay allowed, got %+v", i, d)
+		}
+	}
+	if d, _ := limiter.Reserve(ctx, rule, "user"); !d.Allowed {
+		t.Fatalf("expected the last attempt to be allowed, got %+v", d)
+	}
+	limiter.Refund(ctx, rule, "user")
+	if d, _ := limiter.Check(ctx, rule, "user"); !d.Allowed {
+		t.Fatalf("expected a refunded attempt not to lock the key, got %+v", d)
+	}
+}
@@ -0,0 +1,272 @@
+// Package rbac is the policy engine behind admin authorization. A role is a
+// named permission set. A user holds the built-in role of its account, the
+// custom roles bound to it, and tenant_admin on every tenant it administers;
+// Can reports whether any of those roles grants a permission on a resource.
+package rbac
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"account/internal/store"
+)
+
+const (
+	// RoleTenantAdmin is held on a tenant by its admin members.
+	RoleTenantAdmin = "tenant_admin"
+	// AllPermissions grants every permission.
+	AllPermissions = "*"
+)
+
+// Resource is what a permission is checked against. The zero value is the
+// platform as a whole; a TenantID narrows the check to one tenant, which
+// brings that tenant's bindings and tenant_admin into play.
+type Resource struct {
+	TenantID string
+}
+
+// Platform is the platform-wide resource.
+var Platform = Resource{}
+
+// Tenant returns the resource for one tenant.
+func Tenant(id string) Resource {
+	return Resource{TenantID: strings.TrimSpace(id)}
+}
+
+// Role is a built-in role. Configurable roles take their permissions from the
+// admin settings matrix where it has an entry for the permission and the
+// role, and from Permissions otherwise.
+type Role struct {
+	Key          string
+	Description  string
+	Permissions  []string
+	Configurable bool
+}
+
+// Store is the part of store.Store the engine reads.
+type Store interface {
+	GetRole(ctx context.Context, key string) (*store.Role, error)
+	ListRoleBindings(ctx context.Context, userID string) ([]store.RoleBinding, error)
+	GetTenantMembership(ctx context.Context, tenantID, userID string) (*store.TenantMembership, error)
+}
+
+// MatrixFunc returns the admin settings matrix, keyed by permission and then
+// by role.
+type MatrixFunc func(ctx context.Context) (map[string]map[string]bool, error)
+
+// Config describes an engine.
+type Config struct {
+	Store Store
+	// Matrix may be nil, in which case built-in roles keep their default
+	// permissions. Matrix entries outside Permissions are ignored.
+	Matrix MatrixFunc
+	// Permissions is the catalog of permissions a role can hold.
+	Permissions []string
+	// Reserved permissions are only held by the built-in roles that list
+	// them. AllPermissions does not cover them and they stay out of the
+	// catalog, so custom roles, the matrix and API token scopes cannot
+	// grant them.
+	Reserved []string
+	// Roles are the built-in roles. Their keys cannot be used by custom
+	// roles.
+	Roles []Role
+}
+
+// Engine evaluates permissions.
+type Engine struct {
+	store       Store
+	matrix      MatrixFunc
+	permissions []string
+	known       map[string]struct{}
+	reserved    map[string]struct{}
+	builtin     map[string]Role
+	order       []string
+}
+
+// New creates an engine from cfg.
+func New(cfg Config) *Engine {
+	e := &Engine{
+		store:    cfg.Store,
+		matrix:   cfg.Matrix,
+		known:    make(map[string]struct{}, len(cfg.Permissions)),
+		reserved: make(map[string]struct{}, len(cfg.Reserved)),
+		builtin:  make(map[string]Role, len(cfg.Roles)),
+	}
+	for _, permission := range cfg.Reserved {
+		if permission = strings.TrimSpace(permission); permission != "" {
+			e.reserved[permission] = struct{}{}
+		}
+	}
+	for _, permission := range cfg.Permissions {
+		permission = strings.TrimSpace(permission)
+		if _, ok := e.known[permission]; ok || permission == "" {
+			continue
+		}
+		if _, ok := e.reserved[permission]; ok {
+			continue
+		}
+		e.known[permission] = struct{}{}
+		e.permissions = append(e.permissions, permission)
+	}
+	for _, role := range cfg.Roles {
+		role.Key = strings.ToLower(strings.TrimSpace(role.Key))
+		if _, ok := e.builtin[role.Key]; !ok {
+			e.order = append(e.order, role.Key)
+		}
+		e.builtin[role.Key] = role
+	}
+	return e
+}
+
+// KnownPermission reports whether permission is in the catalog.
+func (e *Engine) KnownPermission(permission string) bool {
+	_, ok := e.known[strings.TrimSpace(permission)]
+	return ok
+}
+
+// PermissionCatalog returns the catalog in the order it was configured.
+func (e *Engine) PermissionCatalog() []string {
+	return append([]string(nil), e.permissions...)
+}
+
+// BuiltIn returns the built-in role with the given key.
+func (e *Engine) BuiltIn(key string) (Role, bool) {
+	role, ok := e.builtin[strings.ToLower(strings.TrimSpace(key))]
+	return role, ok
+}
+
+// BuiltInRoles returns the built-in roles in the order they were configured.
+func (e *Engine) BuiltInRoles() []Role {
+	roles := make([]Role, 0, len(e.order))
+	for _, key := range e.order {
+		roles = append(roles, e.builtin[key])
+	}
+	return roles
+}
+
+// Permissions returns the effective permissions of a role: for a built-in
+// role after applying the matrix, for a custom role as stored.
+func (e *Engine) Permissions(ctx context.Context, key string) ([]string, error) {
+	if role, ok := e.BuiltIn(key); ok {
+		return e.builtinPermissions(ctx, role), nil
+	}
+	role, err := e.store.GetRole(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return role.Permissions, nil
+}
+
+// Can reports whether user holds permission on resource. Inactive users and
+// root-role accounts other than the designated root email hold nothing.
+// Tenant bindings and tenant_admin only count while the user is still a
+// member of the tenant, and the shared tenant has no tenant admins. A
+// reserved permission is only held through the built-in role that lists it.
+func (e *Engine) Can(ctx context.Context, user *store.User, permission string, resource Resource) (bool, error) {
+	permission = strings.TrimSpace(permission)
+	if user == nil || !user.Active || permission == "" {
+		return false, nil
+	}
+	role := strings.ToLower(strings.TrimSpace(user.Role))
+	if store.IsRootRole(role) && !strings.EqualFold(strings.TrimSpace(user.Email), store.RootAdminEmail) {
+		return false, nil
+	}
+	if _, ok := e.reserved[permission]; ok {
+		builtin, ok := e.builtin[role]
+		return ok && slices.Contains(builtin.Permissions, permission), nil
+	}
+
+	if builtin, ok := e.builtin[role]; ok && grants(e.builtinPermissions(ctx, builtin), permission) {
+		return true, nil
+	}
+	if role == store.RoleReadOnly && grants(user.Permissions, permission) {
+		return true, nil
+	}
+
+	var membership *store.TenantMembership
+	if resource.TenantID != "" {
+		var err error
+		membership, err = e.store.GetTenantMembership(ctx, resource.TenantID, user.ID)
+		if errors.Is(err, store.ErrTenantMembershipNotFound) {
+			membership = nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if membership != nil && membership.Role == store.TenantMembershipRoleAdmin && resource.TenantID != store.SharedXWorkmateTenantID {
+		if builtin, ok := e.builtin[RoleTenantAdmin]; ok && grants(e.builtinPermissions(ctx, builtin), permission) {
+			return true, nil
+		}
+	}
+
+	bindings, err := e.store.ListRoleBindings(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, binding := range bindings {
+		if binding.TenantID != "" && (binding.TenantID != resource.TenantID || membership == nil) {
+			continue
+		}
+		permissions, err := e.Permissions(ctx, binding.RoleKey)
+		if errors.Is(err, store.ErrRoleNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if grants(permissions, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Scoped reports whether scopes include permission on resource. It applies
+// to the permission lists of API tokens and service accounts, which are not
+// roles: a list bound to a tenant only reaches that tenant.
+func Scoped(scopes []string, tenantID, permission string, resource Resource) bool {
+	permission = strings.TrimSpace(permission)
+	if permission == "" {
+		return false
+	}
+	if tenantID = strings.TrimSpace(tenantID); tenantID != "" && tenantID != resource.TenantID {
+		return false
+	}
+	return grants(scopes, permission)
+}
+
+func (e *Engine) builtinPermissions(ctx context.Context, role Role) []string {
+	if !role.Configurable || e.matrix == nil {
+		return role.Permissions
+	}
+	matrix, err := e.matrix(ctx)
+	if err != nil || len(matrix) == 0 {
+		return role.Permissions
+	}
+
+	permissions := make([]string, 0, len(role.Permissions))
+	for _, permission := range role.Permissions {
+		if enabled, ok := matrix[permission][role.Key]; ok && !enabled {
+			continue
+		}
+		permissions = append(permissions, permission)
+	}
+	for _, permission := range e.permissions {
+		if matrix[permission][role.Key] && !grants(role.Permissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+func grants(permissions []string, target string) bool {
+	for _, permission := range permissions {
+		permission = strings.TrimSpace(permission)
+		if permission == AllPermissions || strings.EqualFold(permission, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"account/internal/store"
+)
+
+func newTestEngine(t *testing.T, matrix map[string]map[string]bool) (*Engine, store.Store) {
+	t.Helper()
+	st := store.NewMemoryStore()
+	engine := New(Config{
+		Store: st,
+		Matrix: func(context.Context) (map[string]map[string]bool, error) {
+			if matrix == nil {
+				return nil, errors.New("matrix unavailable")
+			}
+			return matrix, nil
+		},
+		Permissions: []string{"users.read", "users.write", "tenants.read", "tenants.write", "scim.read", "root.assume"},
+		Reserved:    []string{"root.assume"},
+		Roles: []Role{
+			{Key: store.RoleRoot, Permissions: []string{AllPermissions, "root.assume"}},
+			{Key: store.RoleAdmin, Permissions: []string{AllPermissions}},
+			{Key: store.RoleOperator, Permissions: []string{"users.read"}, Configurable: true},
+			{Key: store.RoleUser},
+			{Key: store.RoleReadOnly},
+			{Key: RoleTenantAdmin, Permissions: []string{"tenants.read", "tenants.write"}, Configurable: true},
+		},
+	})
+	return engine, st
+}
+
+func newTestUser(t *testing.T, st store.Store, email, role string) *store.User {
+	t.Helper()
+	user := &store.User{Name: email, Email: email, Role: role, Active: true}
+	if err := st.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("create user %s: %v", email, err)
+	}
+	user.Active = true
+	return user
+}
+
+func expectCan(t *testing.T, engine *Engine, user *store.User, permission string, resource Resource, want bool) {
+	t.Helper()
+	got, err := engine.Can(context.Background(), user, permission, resource)
+	if err != nil {
+		t.Fatalf("can %s %s: %v", user.Email, permission, err)
+	}
+	if got != want {
+		t.Fatalf("can %s %s on %+v: expected %v, got %v", user.Email, permission, resource, want, got)
+	}
+}
+
+func TestBuiltinRolesFollowTheMatrix(t *testing.T) {
+	engine, st := newTestEngine(t, map[string]map[string]bool{
+		"users.read":   {store.RoleOperator: false},
+		"users.write":  {store.RoleOperator: true, store.RoleUser: true},
+		"registration": {store.RoleOperator: true},
+	})
+	operator := newTestUser(t, st, "operator@example.com", store.RoleOperator)
+	user := newTestUser(t, st, "user@example.com", store.RoleUser)
+
+	expectCan(t, engine, operator, "users.read", Platform, false)
+	expectCan(t, engine, operator, "users.write", Platform, true)
+	expectCan(t, engine, user, "users.write", Platform, false)
+
+	permissions, err := engine.Permissions(context.Background(), store.RoleOperator)
+	if err != nil {
+		t.Fatalf("operator permissions: %v", err)
+	}
+	if len(permissions) != 1 || permissions[0] != "users.write" {
+		t.Fatalf("expected matrix entries outside the catalog to be ignored, got %v", permissions)
+	}
+}
+
+func TestDefaultsApplyWithoutMatrix(t *testing.T) {
+	engine, st := newTestEngine(t, nil)
+	operator := newTestUser(t, st, "operator@example.com", store.RoleOperator)
+	readonly := newTestUser(t, st, "demo@example.com", store.RoleReadOnly)
+	readonly.Permissions = []string{"users.read"}
+
+	expectCan(t, engine, operator, "users.read", Platform, true)
+	expectCan(t, engine, operator, "users.write", Platform, false)
+	expectCan(t, engine, readonly, "users.read", Platform, true)
+	expectCan(t, engine, readonly, "users.write", Platform, false)
+}
+
+func TestRootRequiresTheRootEmail(t *testing.T) {
+	engine, st := newTestEngine(t, nil)
+	root := newTestUser(t, st, store.RootAdminEmail, store.RoleRoot)
+	impostor := &store.User{ID: "impostor", Email: "root@example.com", Role: store.RoleRoot, Active: true}
+
+	expectCan(t, engine, root, "anything.at.all", Platform, true)
+	expectCan(t, engine, impostor, "users.read", Platform, false)
+
+	root.Active = false
+	expectCan(t, engine, root, "users.read", Platform, false)
+}
+
+func TestReservedPermissionsNeedAnExplicitGrant(t *testing.T) {
+	ctx := context.Background()
+	engine, st := newTestEngine(t, map[string]map[string]bool{
+		"root.assume": {store.RoleOperator: true},
+	})
+	root := newTestUser(t, st, store.RootAdminEmail, store.RoleRoot)
+	admin := newTestUser(t, st, "admin@example.com", store.RoleAdmin)
+	operator := newTestUser(t, st, "operator@example.com", store.RoleOperator)
+	readonly := newTestUser(t, st, "demo@example.com", store.RoleReadOnly)
+	readonly.Permissions = []string{AllPermissions, "root.assume"}
+
+	if engine.KnownPermission("root.assume") {
+		t.Fatalf("expected reserved permissions to stay out of the catalog")
+	}
+	if err := st.CreateRole(ctx, &store.Role{Key: "assume", Permissions: []string{"root.assume"}}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	if err := st.CreateRoleBinding(ctx, &store.RoleBinding{UserID: admin.ID, RoleKey: "assume"}); err != nil {
+		t.Fatalf("bind role: %v", err)
+	}
+
+	expectCan(t, engine, root, "root.assume", Platform, true)
+	expectCan(t, engine, admin, "root.assume", Platform, false)
+	expectCan(t, engine, admin, "users.write", Platform, true)
+	expectCan(t, engine, operator, "root.assume", Platform, false)
+	expectCan(t, engine, readonly, "root.assume", Platform, false)
+}
+
+func TestTenantAdminsAndTenantBindings(t *testing.T) {
+	ctx := context.Background()
+	engine, st := newTestEngine(t, nil)
+	admin := newTestUser(t, st, "admin@acme.example", store.RoleUser)
+	member := newTestUser(t, st, "member@acme.example", store.RoleUser)
+
+	for _, tenant := range []*store.Tenant{
+		{ID: "acme", Name: "Acme", Edition: store.TenantPrivateEdition},
+		{ID: "globex", Name: "Globex", Edition: store.TenantPrivateEdition},
+		{ID: store.SharedXWorkmateTenantID, Name: "Shared", Edition: store.SharedPublicTenantEdition},
+	} {
+		if err := st.EnsureTenant(ctx, tenant); err != nil {
+			t.Fatalf("ensure tenant %s: %v", tenant.ID, err)
+		}
+	}
+	for _, membership := range []*store.TenantMembership{
+		{TenantID: "acme", UserID: admin.ID, Role: store.TenantMembershipRoleAdmin},
+		{TenantID: store.SharedXWorkmateTenantID, UserID: admin.ID, Role: store.TenantMembershipRoleAdmin},
+		{TenantID: "acme", UserID: member.ID, Role: store.TenantMembershipRoleUser},
+	} {
+		if err := st.UpsertTenantMembership(ctx, membership); err != nil {
+			t.Fatalf("upsert membership: %v", err)
+		}
+	}
+
+	expectCan(t, engine, admin, "tenants.write", Tenant("acme"), true)
+	expectCan(t, engine, admin, "tenants.write", Tenant("globex"), false)
+	expectCan(t, engine, admin, "tenants.write", Platform, false)
+	expectCan(t, engine, admin, "tenants.read", Tenant(store.SharedXWorkmateTenantID), false)
+	expectCan(t, engine, admin, "scim.read", Tenant("acme"), false)
+
+	if err := st.CreateRole(ctx, &store.Role{Key: "scim-viewer", Permissions: []string{"scim.read"}}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	for _, tenantID := range []string{"acme", "globex"} {
+		if err := st.CreateRoleBinding(ctx, &store.RoleBinding{UserID: member.ID, RoleKey: "scim-viewer", TenantID: tenantID}); err != nil {
+			t.Fatalf("bind role in %s: %v", tenantID, err)
+		}
+	}
+	expectCan(t, engine, member, "scim.read", Tenant("acme"), true)
+	expectCan(t, engine, member, "scim.read", Platform, false)
+	// The globex binding does not count: member does not belong to globex.
+	expectCan(t, engine, member, "scim.read", Tenant("globex"), false)
+
+	if err := st.DeleteTenantMembership(ctx, "acme", member.ID); err != nil {
+		t.Fatalf("delete membership: %v", err)
+	}
+	expectCan(t, engine, member, "scim.read", Tenant("acme"), false)
+}
+
+func TestPlatformBindingsApplyEverywhere(t *testing.T) {
+	ctx := context.Background()
+	engine, st := newTestEngine(t, nil)
+	user := newTestUser(t, st, "support@example.com", store.RoleUser)
+
+	if err := st.CreateRole(ctx, &store.Role{Key: "support", Permissions: []string{"users.read"}}); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	expectCan(t, engine, user, "users.read", Platform, false)
+	if err := st.CreateRoleBinding(ctx, &store.RoleBinding{UserID: user.ID, RoleKey: "support"}); err != nil {
+		t.Fatalf("bind role: %v", err)
+	}
+	expectCan(t, engine, user, "users.read", Platform, true)
+	expectCan(t, engine, user, "users.read", Tenant("acme"), true)
+	expectCan(t, engine, user, "users.write", Platform, false)
+
+	if err := st.DeleteRole(ctx, "support"); err != nil {
+		t.Fatalf("delete role: %v", err)
+	}
+	expectCan(t, engine, user, "users.read", Platform, false)
+}
+
+func TestScopedListsStayWithinTheirTenant(t *testing.T) {
+	scopes := []string{"users.read", "scim.read"}
+
+	if !Scoped(scopes, "", "users.read", Platform) || !Scoped(scopes, "", "users.read", Tenant("acme")) {
+		t.Fatal("expected platform scopes to apply everywhere")
+	}
+	if Scoped(scopes, "", "users.write", Platform) {
+		t.Fatal("expected permissions outside the scopes to be refused")
+	}
+	if !Scoped(scopes, "acme", "scim.read", Tenant("acme")) {
+		t.Fatal("expected tenant scopes to apply to their tenant")
+	}
+	if Scoped(scopes, "acme", "scim.read", Tenant("globex")) || Scoped(scopes, "acme", "scim.read", Platform) {
+		t.Fatal("expected tenant scopes to stay within their tenant")
+	}
+	if Scoped([]string{AllPermissions}, "", "", Platform) {
+		t.Fatal("expected an empty permission to be refused")
+	}
+}
@@ -0,0 +1,274 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Filter operators.
+const (
+	OperatorEqual      = "eq"
+	OperatorNotEqual   = "ne"
+	OperatorContains   = "co"
+	OperatorStartsWith = "sw"
+	OperatorEndsWith   = "ew"
+	OperatorPresent    = "pr"
+)
+
+// Comparison tests one attribute. Attribute is lower-cased with
+// sub-attributes joined by a dot, as in "emails.value"; Value is empty for
+// the presence operator.
+type Comparison struct {
+	Attribute string
+	Operator  string
+	Value     string
+}
+
+// Filter is a parsed filter expression in disjunctive form: it matches when
+// every comparison of any one clause does. The zero Filter matches
+// everything.
+type Filter [][]Comparison
+
+// ParseFilter parses attribute comparisons joined by "and" and "or", with
+// "and" binding tighter. Parentheses, "not" and complex attribute filters
+// are not supported. Attributes may carry the core schema URN as a prefix.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	var (
+		filter Filter
+		clause []Comparison
+	)
+	for i := 0; i < len(tokens); {
+		if tokens[i].quoted {
+			return nil, fmt.Errorf("expected an attribute, got %q", tokens[i].text)
+		}
+		comparison := Comparison{Attribute: normalizeAttribute(tokens[i].text)}
+		if i+1 >= len(tokens) || tokens[i+1].quoted {
+			return nil, fmt.Errorf("expected an operator after %q", tokens[i].text)
+		}
+		comparison.Operator = strings.ToLower(tokens[i+1].text)
+		i += 2
+		switch comparison.Operator {
+		case OperatorPresent:
+		case OperatorEqual, OperatorNotEqual, OperatorContains, OperatorStartsWith, OperatorEndsWith:
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("expected a value after %q", comparison.Operator)
+			}
+			comparison.Value = tokens[i].text
+			if !tokens[i].quoted {
+				comparison.Value = strings.ToLower(comparison.Value)
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("unsupported operator %q", comparison.Operator)
+		}
+		clause = append(clause, comparison)
+
+		if i == len(tokens) {
+			break
+		}
+		if tokens[i].quoted || i+1 == len(tokens) {
+			return nil, fmt.Errorf("unexpected %q", tokens[i].text)
+		}
+		switch strings.ToLower(tokens[i].text) {
+		case "and":
+		case "or":
+			filter = append(filter, clause)
+			clause = nil
+		default:
+			return nil, fmt.Errorf("expected \"and\" or \"or\", got %q", tokens[i].text)
+		}
+		i++
+	}
+	return append(filter, clause), nil
+}
+
+// Matches reports whether a resource matches. values returns the values of
+// an attribute named as in Comparison.Attribute; comparisons ignore case.
+func (f Filter) Matches(values func(attribute string) []string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, clause := range f {
+		matched := true
+		for _, comparison := range clause {
+			if !comparison.matches(values(comparison.Attribute)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Equality returns the value of a filter consisting of the single comparison
+// `attribute eq value`, the shape identity providers use to look resources
+// up.
+func (f Filter) Equality(attribute string) (string, bool) {
+	if len(f) != 1 || len(f[0]) != 1 {
+		return "", false
+	}
+	comparison := f[0][0]
+	if comparison.Attribute != attribute || comparison.Operator != OperatorEqual {
+		return "", false
+	}
+	return comparison.Value, true
+}
+
+func (c Comparison) matches(values []string) bool {
+	want := strings.ToLower(c.Value)
+	if c.Operator == OperatorNotEqual {
+		for _, value := range values {
+			if strings.ToLower(value) == want {
+				return false
+			}
+		}
+		return true
+	}
+	for _, value := range values {
+		got := strings.ToLower(value)
+		switch c.Operator {
+		case OperatorPresent:
+			if got != "" {
+				return true
+			}
+		case OperatorEqual:
+			if got == want {
+				return true
+			}
+		case OperatorContains:
+			if strings.Contains(got, want) {
+				return true
+			}
+		case OperatorStartsWith:
+			if strings.HasPrefix(got, want) {
+				return true
+			}
+		case OperatorEndsWith:
+			if strings.HasSuffix(got, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Path is the target of a PATCH operation, such as "active",
+// "name.givenName", `members[value eq "2819c223"]` or
+// `emails[type eq "work"].value`. Names are lower-cased.
+type Path struct {
+	Attribute    string
+	Filter       Filter
+	SubAttribute string
+}
+
+// ParsePath parses a PATCH path.
+func ParsePath(raw string) (Path, error) {
+	raw = strings.TrimSpace(raw)
+	var path Path
+	if open := strings.IndexByte(raw, '['); open >= 0 {
+		end := strings.LastIndexByte(raw, ']')
+		if end < open {
+			return Path{}, fmt.Errorf("unterminated value filter in %q", raw)
+		}
+		filter, err := ParseFilter(raw[open+1 : end])
+		if err != nil {
+			return Path{}, err
+		}
+		path.Filter = filter
+		rest := raw[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return Path{}, fmt.Errorf("invalid sub-attribute in %q", raw)
+			}
+			path.SubAttribute = strings.ToLower(rest[1:])
+		}
+		raw = raw[:open]
+	}
+	attribute := normalizeAttribute(raw)
+	if attribute == "" {
+		return Path{}, errors.New("empty path")
+	}
+	if dot := strings.IndexByte(attribute, '.'); dot >= 0 {
+		if path.SubAttribute != "" {
+			return Path{}, fmt.Errorf("invalid path %q", raw)
+		}
+		attribute, path.SubAttribute = attribute[:dot], attribute[dot+1:]
+	}
+	path.Attribute = attribute
+	return path, nil
+}
+
+// normalizeAttribute lower-cases an attribute name and drops a core schema
+// URN prefix.
+func normalizeAttribute(name string) string {
+	name = strings.TrimSpace(name)
+	for _, schema := range []string{SchemaUser, SchemaGroup} {
+		if len(name) > len(schema) && strings.EqualFold(name[:len(schema)+1], schema+":") {
+			name = name[len(schema)+1:]
+			break
+		}
+	}
+	return strings.ToLower(name)
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr); end++ {
+				if expr[end] == '\\' {
+					end++
+					continue
+				}
+				if expr[end] == '"' {
+					break
+				}
+			}
+			if end >= len(expr) {
+				return nil, errors.New("unterminated string in filter")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(expr[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %w", err)
+			}
+			tokens = append(tokens, filterToken{text: value, quoted: true})
+			i = end + 1
+		case c == '(' || c == ')':
+			return nil, errors.New("grouping is not supported")
+		default:
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t\n\r\"()", rune(expr[end])) {
+				end++
+			}
+			word := expr[i:end]
+			if strings.ContainsAny(word, "[]") {
+				return nil, errors.New("complex attribute filters are not supported")
+			}
+			tokens = append(tokens, filterToken{text: word})
+			i = end
+		}
+	}
+	return tokens, nil
+}
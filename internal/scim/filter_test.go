@@ -0,0 +1,160 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func userValues(values map[string][]string) func(string) []string {
+	return func(attribute string) []string { return values[attribute] }
+}
+
+func TestParseFilterMatches(t *testing.T) {
+	resource := userValues(map[string][]string{
+		"username":     {"Alice@Example.com"},
+		"externalid":   {"00u1"},
+		"emails.value": {"alice@example.com", "alice@home.example"},
+		"active":       {"true"},
+	})
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`userName eq "alice@example.com"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "ALICE@example.com"`, true},
+		{`userName eq "bob@example.com"`, false},
+		{`userName ne "bob@example.com"`, true},
+		{`emails.value ew "@home.example"`, true},
+		{`userName sw "alice" and active eq true`, true},
+		{`userName sw "alice" and active eq false`, false},
+		{`externalId eq "nope" or externalId eq "00u1"`, true},
+		{`displayName pr`, false},
+		{`externalId pr`, true},
+		{`userName co "and"`, false},
+		{``, true},
+	}
+	for _, tc := range cases {
+		filter, err := ParseFilter(tc.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.expr, err)
+		}
+		if got := filter.Matches(resource); got != tc.want {
+			t.Fatalf("%q matched %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterRejectsUnsupportedExpressions(t *testing.T) {
+	for _, expr := range []string{
+		`userName`,
+		`userName eq`,
+		`userName gt "a"`,
+		`(userName eq "a")`,
+		`emails[type eq "work"]`,
+		`userName eq "a" "and" active eq true`,
+		`userName eq "a" and`,
+		`userName eq "unterminated`,
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Fatalf("expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestFilterEquality(t *testing.T) {
+	filter, err := ParseFilter(`userName eq "Alice@example.com"`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if value, ok := filter.Equality("username"); !ok || value != "Alice@example.com" {
+		t.Fatalf("expected equality lookup, got %q %v", value, ok)
+	}
+	if _, ok := filter.Equality("externalid"); ok {
+		t.Fatalf("expected no equality lookup for another attribute")
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	path, err := ParsePath(`members[value eq "2819c223"]`)
+	if err != nil {
+		t.Fatalf("parse member path: %v", err)
+	}
+	if path.Attribute != "members" || path.SubAttribute != "" {
+		t.Fatalf("unexpected member path: %+v", path)
+	}
+	if !path.Filter.Matches(userValues(map[string][]string{"value": {"2819c223"}})) {
+		t.Fatalf("expected member filter to match its value")
+	}
+
+	path, err = ParsePath(`emails[type eq "work"].value`)
+	if err != nil {
+		t.Fatalf("parse email path: %v", err)
+	}
+	if path.Attribute != "emails" || path.SubAttribute != "value" || path.Filter == nil {
+		t.Fatalf("unexpected email path: %+v", path)
+	}
+
+	path, err = ParsePath("name.givenName")
+	if err != nil {
+		t.Fatalf("parse name path: %v", err)
+	}
+	if path.Attribute != "name" || path.SubAttribute != "givenname" {
+		t.Fatalf("unexpected name path: %+v", path)
+	}
+
+	for _, raw := range []string{"", `members[value eq "x"`, `emails[type eq "work"]value`} {
+		if _, err := ParsePath(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestPatchRequestValidate(t *testing.T) {
+	var req PatchRequest
+	body := `{"schemas":["` + SchemaPatchOp + `"],"Operations":[{"op":"Replace","path":"active","value":false}]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if err := req.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if req.Operations[0].Op != OpReplace {
+		t.Fatalf("expected op to be normalised, got %q", req.Operations[0].Op)
+	}
+
+	for _, ops := range [][]PatchOperation{
+		nil,
+		{{Op: "add", Path: "members"}},
+		{{Op: "remove"}},
+		{{Op: "move", Path: "active", Value: json.RawMessage("true")}},
+	} {
+		req := PatchRequest{Operations: ops}
+		if err := req.Validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", ops)
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+	start, count, err := ParsePagination("", "", 100, 200)
+	if err != nil || start != 1 || count != 100 {
+		t.Fatalf("unexpected defaults: %d %d %v", start, count, err)
+	}
+	start, count, err = ParsePagination("0", "500", 100, 200)
+	if err != nil || start != 1 || count != 200 {
+		t.Fatalf("unexpected clamping: %d %d %v", start, count, err)
+	}
+	if _, _, err := ParsePagination("x", "", 100, 200); err == nil {
+		t.Fatalf("expected a non-numeric startIndex to be rejected")
+	}
+
+	page := NewListResponse([]string{"a", "b", "c"}, 2, 5)
+	if page.TotalResults != 3 || page.ItemsPerPage != 2 || page.Resources[0] != "b" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	page = NewListResponse([]string{"a"}, 5, 5)
+	if page.ItemsPerPage != 0 || page.Resources == nil {
+		t.Fatalf("expected an empty page past the end: %+v", page)
+	}
+}
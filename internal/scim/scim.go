@@ -0,0 +1,288 @@
+// Package scim implements the protocol side of a SCIM 2.0 service provider
+// (RFC 7643 and RFC 7644): resource representations, list and error
+// messages, filter expressions and PATCH operations. How resources map onto
+// accounts is left to the caller.
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaType is the content type of SCIM requests and responses.
+const MediaType = "application/scim+json"
+
+// Schema URNs.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Error types reported in the scimType member of an error response.
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+	ErrorNoTarget      = "noTarget"
+)
+
+// Error is the body of a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError builds an error response body.
+func NewError(status int, scimType, detail string) Error {
+	return Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// Error returns the detail message, so handlers can pass an Error through
+// helpers that return error.
+func (e Error) Error() string {
+	return e.Detail
+}
+
+// Meta describes a resource.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// User is the core User resource. Password is write-only and never
+// returned.
+type User struct {
+	Schemas     []string   `json:"schemas"`
+	ID          string     `json:"id,omitempty"`
+	ExternalID  string     `json:"externalId,omitempty"`
+	UserName    string     `json:"userName"`
+	Name        *Name      `json:"name,omitempty"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Emails      []Email    `json:"emails,omitempty"`
+	Active      *bool      `json:"active,omitempty"`
+	Password    string     `json:"password,omitempty"`
+	Groups      []GroupRef `json:"groups,omitempty"`
+	Meta        *Meta      `json:"meta,omitempty"`
+}
+
+// Name holds the components of a user's name.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Display returns the formatted name, or the given and family names joined.
+func (n *Name) Display() string {
+	if n == nil {
+		return ""
+	}
+	if formatted := strings.TrimSpace(n.Formatted); formatted != "" {
+		return formatted
+	}
+	return strings.TrimSpace(strings.TrimSpace(n.GivenName) + " " + strings.TrimSpace(n.FamilyName))
+}
+
+// Email is one of a user's addresses.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// PrimaryEmail returns the primary address, or the first one when none is
+// marked primary.
+func (u *User) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return strings.TrimSpace(email.Value)
+		}
+	}
+	if len(u.Emails) > 0 {
+		return strings.TrimSpace(u.Emails[0].Value)
+	}
+	return ""
+}
+
+// GroupRef is a read-only reference from a user to a group it belongs to.
+type GroupRef struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Group is the core Group resource.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Member references a user in a group.
+type Member struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// ListResponse is the body of a query response.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources starting at the 1-based
+// startIndex and holding at most count of them.
+func NewListResponse[T any](resources []T, startIndex, count int) ListResponse {
+	page := make([]any, 0)
+	if from := startIndex - 1; from < len(resources) {
+		for _, resource := range resources[from:min(from+count, len(resources))] {
+			page = append(page, resource)
+		}
+	}
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// ParsePagination reads the startIndex and count query parameters. As RFC
+// 7644 asks, values below 1 are treated as 1 and a negative count as 0;
+// count is capped at maxCount.
+func ParsePagination(startIndex, count string, defaultCount, maxCount int) (int, int, error) {
+	start, size := 1, defaultCount
+	if raw := strings.TrimSpace(startIndex); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, 0, errors.New("startIndex must be an integer")
+		}
+		start = max(parsed, 1)
+	}
+	if raw := strings.TrimSpace(count); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, 0, errors.New("count must be an integer")
+		}
+		size = max(parsed, 0)
+	}
+	return start, min(size, maxCount), nil
+}
+
+// Patch operations.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single change. Value is left raw because its shape
+// depends on the path.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Validate checks the operations are well formed. Operation names are
+// normalised to lower case, since some identity providers capitalise them.
+func (r *PatchRequest) Validate() error {
+	if len(r.Operations) == 0 {
+		return errors.New("at least one operation is required")
+	}
+	for i := range r.Operations {
+		op := &r.Operations[i]
+		op.Op = strings.ToLower(strings.TrimSpace(op.Op))
+		op.Path = strings.TrimSpace(op.Path)
+		switch op.Op {
+		case OpAdd, OpReplace:
+			if len(op.Value) == 0 {
+				return fmt.Errorf("operation %d: %s requires a value", i+1, op.Op)
+			}
+		case OpRemove:
+			if op.Path == "" {
+				return fmt.Errorf("operation %d: remove requires a path", i+1)
+			}
+		default:
+			return fmt.Errorf("operation %d: unsupported op %q", i+1, op.Op)
+		}
+	}
+	return nil
+}
+
+// ServiceProviderConfig advertises the optional features a provider
+// supports.
+func ServiceProviderConfig(maxResults int) map[string]any {
+	unsupported := map[string]any{"supported": false}
+	return map[string]any{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          map[string]any{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": maxResults},
+		"changePassword": map[string]any{"supported": true},
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Per-tenant SCIM token sent in the Authorization header",
+			"primary":     true,
+		}},
+	}
+}
+
+// ResourceTypes describes the User and Group endpoints below baseURL.
+func ResourceTypes(baseURL string) []map[string]any {
+	resourceType := func(name, endpoint, schema string) map[string]any {
+		return map[string]any{
+			"schemas":  []string{SchemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": endpoint,
+			"schema":   schema,
+			"meta": map[string]any{
+				"resourceType": "ResourceType",
+				"location":     baseURL + "/ResourceTypes/" + name,
+			},
+		}
+	}
+	return []map[string]any{
+		resourceType("User", "/Users", SchemaUser),
+		resourceType("Group", "/Groups", SchemaGroup),
+	}
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"account/internal/store"
+)
+
+const (
+	AuthStateActive    = "active"
+	AuthStateSuspended = "suspended"
+
+	RateProfileStandard  = "standard"
+	RateProfileThrottled = "throttled"
+
+	ConnProfileStandard = "standard"
+
+	StrategyEWMA     = "ewma"
+	StrategyFailover = "failover"
+
+	// DegradeModeNone means at least one healthy node group is eligible.
+	DegradeModeNone = "none"
+	// DegradeModeBestEffort offers every plan-eligible group because none of
+	// them is currently healthy.
+	DegradeModeBestEffort = "best_effort"
+	// DegradeModeDeny leaves the account without any node group.
+	DegradeModeDeny = "deny"
+
+	SchedulerDecisionAllow    = "allow"
+	SchedulerDecisionThrottle = "throttle"
+	SchedulerDecisionDegrade  = "degrade"
+	SchedulerDecisionDeny     = "deny"
+
+	// DefaultPlanKey selects PlanNodeGroups for accounts without an active
+	// subscription.
+	DefaultPlanKey = "default"
+
+	defaultPolicyTTL        = 10 * time.Minute
+	defaultNodeStaleAfter   = 5 * time.Minute
+	defaultNodeGroupKey     = "default"
+	policyVersionHashLength = 16
+)
+
+// ErrSchedulerStoreNotConfigured is returned when the scheduler lacks a store.
+var ErrSchedulerStoreNotConfigured = errors.New("account scheduler store is not configured")
+
+// AccountSchedulerStore captures the persistence operations required to
+// derive account policy snapshots. Per-account state is read in bulk once
+// per run rather than once per account.
+type AccountSchedulerStore interface {
+	ListUsers(ctx context.Context) ([]store.User, error)
+	ListSubscriptions(ctx context.Context) ([]store.Subscription, error)
+	ListAccountQuotaStates(ctx context.Context) ([]store.AccountQuotaState, error)
+	ListLatestNodeHealthSnapshots(ctx context.Context) ([]store.NodeHealthSnapshot, error)
+	ListAccountPolicySnapshots(ctx context.Context) ([]store.AccountPolicySnapshot, error)
+	UpsertAccountPolicySnapshot(ctx context.Context, snapshot *store.AccountPolicySnapshot) error
+	InsertSchedulerDecision(ctx context.Context, decision *store.SchedulerDecision) error
+}
+
+// AccountScheduler combines node health, account quota state and the
+// subscription plan into versioned account policy snapshots. A snapshot is
+// written, and a scheduler decision recorded, whenever its content changes or
+// the current one is past half of its lifetime.
+type AccountScheduler struct {
+	Store AccountSchedulerStore
+	// PolicyTTL is the lifetime of an emitted snapshot.
+	PolicyTTL time.Duration
+	// NodeStaleAfter treats nodes without a fresh health sample as unhealthy.
+	NodeStaleAfter time.Duration
+	// MinHealthScore is the lowest health score a node may report and still
+	// count as healthy.
+	MinHealthScore float64
+	// PlanNodeGroups restricts the node groups available to a subscription
+	// plan, keyed by plan id or DefaultPlanKey. Plans without an entry may
+	// use every group.
+	PlanNodeGroups map[string][]string
+
+	now func() time.Time
+}
+
+// NewAccountScheduler constructs a scheduler with default snapshot lifetime
+// and node staleness window.
+func NewAccountScheduler(st AccountSchedulerStore) *AccountScheduler {
+	return &AccountScheduler{
+		Store:          st,
+		PolicyTTL:      defaultPolicyTTL,
+		NodeStaleAfter: defaultNodeStaleAfter,
+		now:            time.Now,
+	}
+}
+
+// NodeGroupKey returns the node group a health snapshot belongs to: its
+// pricing group, falling back to the line code and then the region.
+func NodeGroupKey(snapshot store.NodeHealthSnapshot) string {
+	for _, candidate := range []string{snapshot.PricingGroup, snapshot.LineCode, snapshot.Region} {
+		if key := strings.TrimSpace(candidate); key != "" {
+			return key
+		}
+	}
+	return defaultNodeGroupKey
+}
+
+type nodeGroupHealth struct {
+	name         string
+	healthyNodes int
+	scoreTotal   float64
+}
+
+func (g nodeGroupHealth) averageScore() float64 {
+	if g.healthyNodes == 0 {
+		return 0
+	}
+	return g.scoreTotal / float64(g.healthyNodes)
+}
+
+// Schedule refreshes the policy snapshot of every active account and returns
+// the number of snapshots written.
+func (s *AccountScheduler) Schedule(ctx context.Context) (int, error) {
+	if s == nil || s.Store == nil {
+		return 0, ErrSchedulerStoreNotConfigured
+	}
+
+	users, err := s.Store.ListUsers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list users: %w", err)
+	}
+	subscriptions, err := s.Store.ListSubscriptions(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list subscriptions: %w", err)
+	}
+	quotaStates, err := s.Store.ListAccountQuotaStates(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list quota states: %w", err)
+	}
+	policies, err := s.Store.ListAccountPolicySnapshots(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list policy snapshots: %w", err)
+	}
+	nodes, err := s.Store.ListLatestNodeHealthSnapshots(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list node health: %w", err)
+	}
+
+	subscriptionsByAccount := make(map[string][]store.Subscription)
+	for _, subscription := range subscriptions {
+		subscriptionsByAccount[subscription.UserID] = append(subscriptionsByAccount[subscription.UserID], subscription)
+	}
+	quotaByAccount := make(map[string]store.AccountQuotaState, len(quotaStates))
+	for _, state := range quotaStates {
+		quotaByAccount[state.AccountUUID] = state
+	}
+	policyByAccount := make(map[string]store.AccountPolicySnapshot, len(policies))
+	for _, policy := range policies {
+		policyByAccount[policy.AccountUUID] = policy
+	}
+	now := s.currentTime().UTC()
+	groups := s.rankNodeGroups(nodes, now)
+
+	written := 0
+	for _, user := range users {
+		if !user.Active || strings.TrimSpace(user.ID) == "" {
+			continue
+		}
+		var quota *store.AccountQuotaState
+		if state, ok := quotaByAccount[user.ID]; ok {
+			quota = &state
+		}
+		var current *store.AccountPolicySnapshot
+		if policy, ok := policyByAccount[user.ID]; ok {
+			current = &policy
+		}
+
+		snapshot, decision := s.buildPolicy(user.ID, activePlanID(subscriptionsByAccount[user.ID]), quota, groups)
+		emitted, err := s.emit(ctx, current, snapshot, decision, now)
+		if err != nil {
+			return written, err
+		}
+		if emitted {
+			written++
+		}
+	}
+	return written, nil
+}
+
+// rankNodeGroups returns every known node group, healthy groups first and
+// ordered by their average health score.
+func (s *AccountScheduler) rankNodeGroups(nodes []store.NodeHealthSnapshot, now time.Time) []nodeGroupHealth {
+	byName := make(map[string]*nodeGroupHealth)
+	for _, node := range nodes {
+		name := NodeGroupKey(node)
+		group, ok := byName[name]
+		if !ok {
+			group = &nodeGroupHealth{name: name}
+			byName[name] = group
+		}
+		if !s.nodeHealthy(node, now) {
+			continue
+		}
+		group.healthyNodes++
+		group.scoreTotal += node.HealthScore
+	}
+
+	groups := make([]nodeGroupHealth, 0, len(byName))
+	for _, group := range byName {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if (groups[i].healthyNodes > 0) != (groups[j].healthyNodes > 0) {
+			return groups[i].healthyNodes > 0
+		}
+		if groups[i].averageScore() != groups[j].averageScore() {
+			return groups[i].averageScore() > groups[j].averageScore()
+		}
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
+
+func (s *AccountScheduler) nodeHealthy(node store.NodeHealthSnapshot, now time.Time) bool {
+	if !node.Healthy || node.HealthScore < s.MinHealthScore {
+		return false
+	}
+	if s.NodeStaleAfter > 0 && now.Sub(node.SampledAt) > s.NodeStaleAfter {
+		return false
+	}
+	return true
+}
+
+func (s *AccountScheduler) buildPolicy(accountUUID, planID string, quota *store.AccountQuotaState, groups []nodeGroupHealth) (*store.AccountPolicySnapshot, *store.SchedulerDecision) {
+	snapshot := &store.AccountPolicySnapshot{
+		AccountUUID:        accountUUID,
+		AuthState:          AuthStateActive,
+		RateProfile:        RateProfileStandard,
+		ConnProfile:        ConnProfileStandard,
+		EligibleNodeGroups: []string{},
+		PreferredStrategy:  StrategyEWMA,
+		DegradeMode:        DegradeModeNone,
+	}
+	if planID != "" {
+		snapshot.ConnProfile = planID
+	}
+	verdict := SchedulerDecisionAllow
+
+	switch {
+	case quota != nil && quota.SuspendState == store.SuspendStateSuspended:
+		snapshot.AuthState = AuthStateSuspended
+		snapshot.DegradeMode = DegradeModeDeny
+		verdict = SchedulerDecisionDeny
+	default:
+		if quota != nil && quota.ThrottleState == store.ThrottleStateThrottled {
+			snapshot.RateProfile = RateProfileThrottled
+			verdict = SchedulerDecisionThrottle
+		}
+
+		allowed := s.planAllows(planID)
+		var healthy, known []string
+		for _, group := range groups {
+			if !allowed(group.name) {
+				continue
+			}
+			known = append(known, group.name)
+			if group.healthyNodes > 0 {
+				healthy = append(healthy, group.name)
+			}
+		}
+		switch {
+		case len(healthy) > 0:
+			snapshot.EligibleNodeGroups = healthy
+		case len(known) > 0:
+			snapshot.EligibleNodeGroups = known
+			snapshot.PreferredStrategy = StrategyFailover
+			snapshot.DegradeMode = DegradeModeBestEffort
+			verdict = SchedulerDecisionDegrade
+		default:
+			snapshot.DegradeMode = DegradeModeDeny
+			verdict = SchedulerDecisionDeny
+		}
+	}
+	snapshot.PolicyVersion = policyVersion(snapshot)
+
+	decision := &store.SchedulerDecision{
+		AccountUUID: accountUUID,
+		Strategy:    snapshot.PreferredStrategy,
+		Decision:    verdict,
+	}
+	if len(snapshot.EligibleNodeGroups) > 0 {
+		decision.NodeGroup = snapshot.EligibleNodeGroups[0]
+	}
+	return snapshot, decision
+}
+
+func (s *AccountScheduler) planAllows(planID string) func(string) bool {
+	key := planID
+	if key == "" {
+		key = DefaultPlanKey
+	}
+	groups, ok := s.PlanNodeGroups[key]
+	if !ok {
+		return func(string) bool { return true }
+	}
+	allowed := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		allowed[strings.TrimSpace(group)] = struct{}{}
+	}
+	return func(name string) bool {
+		_, ok := allowed[name]
+		return ok
+	}
+}
+
+// emit persists snapshot unless current, the stored one, carries the same
+// version and is still within the first half of its lifetime.
+func (s *AccountScheduler) emit(ctx context.Context, current, snapshot *store.AccountPolicySnapshot, decision *store.SchedulerDecision, now time.Time) (bool, error) {
+	ttl := s.PolicyTTL
+	if ttl <= 0 {
+		ttl = defaultPolicyTTL
+	}
+
+	if current != nil && current.PolicyVersion == snapshot.PolicyVersion && current.ExpiresAt.Sub(now) > ttl/2 {
+		return false, nil
+	}
+
+	snapshot.ExpiresAt = now.Add(ttl)
+	if err := s.Store.UpsertAccountPolicySnapshot(ctx, snapshot); err != nil {
+		return false, fmt.Errorf("write policy snapshot for %s: %w", snapshot.AccountUUID, err)
+	}
+	decision.GeneratedAt = now
+	if err := s.Store.InsertSchedulerDecision(ctx, decision); err != nil {
+		return true, fmt.Errorf("record scheduler decision for %s: %w", snapshot.AccountUUID, err)
+	}
+	return true, nil
+}
+
+func (s *AccountScheduler) currentTime() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// policyVersion derives a stable version from the policy content so that an
+// unchanged policy keeps its version across refreshes.
+func policyVersion(snapshot *store.AccountPolicySnapshot) string {
+	payload, _ := json.Marshal([]any{
+		snapshot.AuthState,
+		snapshot.RateProfile,
+		snapshot.ConnProfile,
+		snapshot.EligibleNodeGroups,
+		snapshot.PreferredStrategy,
+		snapshot.DegradeMode,
+	})
+	sum := sha256.Sum256(payload)
+	return "policy-" + hex.EncodeToString(sum[:])[:policyVersionHashLength]
+}
+
+// activePlanID returns the plan of the most recently updated active
+// subscription.
+func activePlanID(subscriptions []store.Subscription) string {
+	var (
+		planID  string
+		updated time.Time
+	)
+	for _, subscription := range subscriptions {
+		if !subscriptionActive(subscription) {
+			continue
+		}
+		if planID == "" || subscription.UpdatedAt.After(updated) {
+			planID = strings.TrimSpace(subscription.PlanID)
+			updated = subscription.UpdatedAt
+		}
+	}
+	return planID
+}
+
+func subscriptionActive(subscription store.Subscription) bool {
+	if strings.TrimSpace(subscription.PlanID) == "" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(subscription.Status)) {
+	case "active", "trialing", "paid":
+		return true
+	default:
+		return false
+	}
+}
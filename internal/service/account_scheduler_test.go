@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+func TestAccountSchedulerEmitsVersionedPolicies(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	now := time.Date(2026, time.April, 10, 12, 0, 0, 0, time.UTC)
+
+	accounts := map[string]string{}
+	for _, name := range []string{"premium", "throttled", "suspended"} {
+		email := name + "@example.com"
+		if err := st.CreateUser(ctx, &store.User{Name: name, Email: email, Active: true}); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		user, err := st.GetUserByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("get user: %v", err)
+		}
+		accounts[name] = user.ID
+	}
+	if err := st.UpsertSubscription(ctx, &store.Subscription{
+		UserID:     accounts["premium"],
+		Provider:   "stripe",
+		PlanID:     "pro",
+		ExternalID: "sub_pro",
+		Status:     "active",
+	}); err != nil {
+		t.Fatalf("upsert subscription: %v", err)
+	}
+	for _, state := range []store.AccountQuotaState{
+		{AccountUUID: accounts["throttled"], ThrottleState: store.ThrottleStateThrottled, SuspendState: store.SuspendStateActive},
+		{AccountUUID: accounts["suspended"], ThrottleState: store.ThrottleStateNormal, SuspendState: store.SuspendStateSuspended},
+	} {
+		state := state
+		if err := st.UpsertAccountQuotaState(ctx, &state); err != nil {
+			t.Fatalf("upsert quota state: %v", err)
+		}
+	}
+	for _, node := range []store.NodeHealthSnapshot{
+		{NodeID: "hk-1", PricingGroup: "hk-premium", Healthy: true, HealthScore: 0.9, SampledAt: now},
+		{NodeID: "sg-1", PricingGroup: "sg-standard", Healthy: true, HealthScore: 0.6, SampledAt: now},
+		{NodeID: "us-1", PricingGroup: "us-standard", Healthy: true, HealthScore: 0.95, SampledAt: now.Add(-time.Hour)},
+	} {
+		node := node
+		if err := st.UpsertNodeHealthSnapshot(ctx, &node); err != nil {
+			t.Fatalf("upsert node health: %v", err)
+		}
+	}
+
+	scheduler := NewAccountScheduler(st)
+	scheduler.now = func() time.Time { return now }
+	scheduler.PlanNodeGroups = map[string][]string{
+		DefaultPlanKey: {"sg-standard", "us-standard"},
+	}
+
+	written, err := scheduler.Schedule(ctx)
+	if err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if written != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", written)
+	}
+
+	premium, err := st.GetLatestAccountPolicySnapshot(ctx, accounts["premium"])
+	if err != nil {
+		t.Fatalf("get premium policy: %v", err)
+	}
+	// The stale us-standard node sorts last despite its higher score.
+	if got := premium.EligibleNodeGroups; len(got) != 2 || got[0] != "hk-premium" || got[1] != "sg-standard" {
+		t.Fatalf("unexpected premium node groups: %v", got)
+	}
+	if premium.ConnProfile != "pro" || premium.DegradeMode != DegradeModeNone || !premium.ExpiresAt.Equal(now.Add(defaultPolicyTTL)) {
+		t.Fatalf("unexpected premium policy: %+v", premium)
+	}
+
+	throttled, err := st.GetLatestAccountPolicySnapshot(ctx, accounts["throttled"])
+	if err != nil {
+		t.Fatalf("get throttled policy: %v", err)
+	}
+	if throttled.RateProfile != RateProfileThrottled || len(throttled.EligibleNodeGroups) != 1 || throttled.EligibleNodeGroups[0] != "sg-standard" {
+		t.Fatalf("unexpected throttled policy: %+v", throttled)
+	}
+
+	suspended, err := st.GetLatestAccountPolicySnapshot(ctx, accounts["suspended"])
+	if err != nil {
+		t.Fatalf("get suspended policy: %v", err)
+	}
+	if suspended.AuthState != AuthStateSuspended || suspended.DegradeMode != DegradeModeDeny || len(suspended.EligibleNodeGroups) != 0 {
+		t.Fatalf("unexpected suspended policy: %+v", suspended)
+	}
+
+	decisions, err := st.ListRecentSchedulerDecisions(ctx, 0)
+	if err != nil {
+		t.Fatalf("list decisions: %v", err)
+	}
+	verdicts := map[string]string{}
+	for _, decision := range decisions {
+		verdicts[decision.AccountUUID] = decision.Decision
+	}
+	if verdicts[accounts["premium"]] != SchedulerDecisionAllow || verdicts[accounts["throttled"]] != SchedulerDecisionThrottle || verdicts[accounts["suspended"]] != SchedulerDecisionDeny {
+		t.Fatalf("unexpected decisions: %+v", decisions)
+	}
+
+	// An unchanged policy is not rewritten until half of its lifetime passed.
+	now = now.Add(time.Minute)
+	if written, err := scheduler.Schedule(ctx); err != nil || written != 0 {
+		t.Fatalf("expected no rewrites, got %d (%v)", written, err)
+	}
+
+	// Losing every healthy node degrades to best effort with a new version.
+	if err := st.UpsertNodeHealthSnapshot(ctx, &store.NodeHealthSnapshot{NodeID: "sg-1", PricingGroup: "sg-standard", Healthy: false, SampledAt: now}); err != nil {
+		t.Fatalf("upsert node health: %v", err)
+	}
+	if _, err := scheduler.Schedule(ctx); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	degraded, err := st.GetLatestAccountPolicySnapshot(ctx, accounts["throttled"])
+	if err != nil {
+		t.Fatalf("get throttled policy: %v", err)
+	}
+	if degraded.DegradeMode != DegradeModeBestEffort || degraded.PreferredStrategy != StrategyFailover {
+		t.Fatalf("unexpected degraded policy: %+v", degraded)
+	}
+	if degraded.PolicyVersion == throttled.PolicyVersion {
+		t.Fatalf("expected policy version to change, still %s", degraded.PolicyVersion)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"account/internal/store"
+)
+
+// ErrQuotaStoreNotConfigured is returned when the quota enforcer lacks a store.
+var ErrQuotaStoreNotConfigured = errors.New("quota enforcement store is not configured")
+
+// QuotaEnforcementStore captures the persistence operations required to
+// re-evaluate account quota states.
+type QuotaEnforcementStore interface {
+	ListAccountQuotaStates(ctx context.Context) ([]store.AccountQuotaState, error)
+	UpdateAccountQuotaState(ctx context.Context, accountUUID string, update func(state *store.AccountQuotaState) bool) (bool, error)
+	GetAccountBillingProfile(ctx context.Context, accountUUID string) (*store.AccountBillingProfile, error)
+}
+
+// EvaluateQuotaState returns the throttle and suspend states an account should
+// be in. Accounts in arrears are suspended. Accounts whose package includes
+// quota are throttled once that quota is used up; accounts without included
+// quota are pay-as-you-go and never throttled.
+func EvaluateQuotaState(state store.AccountQuotaState, profile *store.AccountBillingProfile) (throttleState, suspendState string) {
+	throttleState = store.ThrottleStateNormal
+	if profile != nil && profile.IncludedQuotaBytes > 0 && state.RemainingIncludedQuota <= 0 {
+		throttleState = store.ThrottleStateThrottled
+	}
+	suspendState = store.SuspendStateActive
+	if state.Arrears || state.CurrentBalance < 0 {
+		suspendState = store.SuspendStateSuspended
+	}
+	return throttleState, suspendState
+}
+
+// applyQuotaPolicy updates state in place and reports whether the throttle or
+// suspend state changed.
+func applyQuotaPolicy(state *store.AccountQuotaState, profile *store.AccountBillingProfile) bool {
+	throttleState, suspendState := EvaluateQuotaState(*state, profile)
+	if state.ThrottleState == throttleState && state.SuspendState == suspendState {
+		return false
+	}
+	state.ThrottleState = throttleState
+	state.SuspendState = suspendState
+	return true
+}
+
+// QuotaEnforcer sweeps all account quota states and moves accounts between
+// normal, throttled and suspended according to EvaluateQuotaState. The rater
+// applies the same policy as it bills; the sweep catches changes that happen
+// outside rating, such as top-ups or billing profile updates.
+type QuotaEnforcer struct {
+	Store QuotaEnforcementStore
+
+	now func() time.Time
+}
+
+// NewQuotaEnforcer constructs an enforcer backed by st.
+func NewQuotaEnforcer(st QuotaEnforcementStore) *QuotaEnforcer {
+	return &QuotaEnforcer{Store: st, now: time.Now}
+}
+
+// Enforce re-evaluates every account quota state and returns the number of
+// accounts whose state changed.
+func (e *QuotaEnforcer) Enforce(ctx context.Context) (int, error) {
+	if e == nil || e.Store == nil {
+		return 0, ErrQuotaStoreNotConfigured
+	}
+
+	states, err := e.Store.ListAccountQuotaStates(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list quota states: %w", err)
+	}
+
+	changed := 0
+	for _, listed := range states {
+		accountUUID := listed.AccountUUID
+		profile, err := e.Store.GetAccountBillingProfile(ctx, accountUUID)
+		if err != nil {
+			if !errors.Is(err, store.ErrUserNotFound) {
+				return changed, fmt.Errorf("load billing profile for %s: %w", accountUUID, err)
+			}
+			profile = nil
+		}
+		// The policy is applied to the locked current state rather than the
+		// listed copy, so balance and quota written by a concurrent rater
+		// are kept.
+		updated, err := e.Store.UpdateAccountQuotaState(ctx, accountUUID, func(state *store.AccountQuotaState) bool {
+			if !applyQuotaPolicy(state, profile) {
+				return false
+			}
+			state.EffectiveAt = e.currentTime().UTC()
+			return true
+		})
+		if err != nil {
+			if errors.Is(err, store.ErrUserNotFound) {
+				continue
+			}
+			return changed, fmt.Errorf("update quota state for %s: %w", accountUUID, err)
+		}
+		if updated {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func (e *QuotaEnforcer) currentTime() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+func TestQuotaEnforcerTransitionsAccounts(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+
+	const (
+		exhausted = "acc-exhausted"
+		arrears   = "acc-arrears"
+		payg      = "acc-payg"
+		recovered = "acc-recovered"
+	)
+	for _, accountUUID := range []string{exhausted, arrears, recovered} {
+		if err := st.UpsertAccountBillingProfile(ctx, &store.AccountBillingProfile{
+			AccountUUID:        accountUUID,
+			PackageName:        "standard",
+			IncludedQuotaBytes: 1000,
+			PricingRuleVersion: "pricing-v2",
+		}); err != nil {
+			t.Fatalf("upsert billing profile: %v", err)
+		}
+	}
+	for _, state := range []store.AccountQuotaState{
+		{AccountUUID: exhausted, RemainingIncludedQuota: 0, CurrentBalance: 5, ThrottleState: store.ThrottleStateNormal, SuspendState: store.SuspendStateActive},
+		{AccountUUID: arrears, RemainingIncludedQuota: 0, CurrentBalance: -2, Arrears: true, ThrottleState: store.ThrottleStateNormal, SuspendState: store.SuspendStateActive},
+		{AccountUUID: payg, RemainingIncludedQuota: 0, CurrentBalance: 1, ThrottleState: store.ThrottleStateNormal, SuspendState: store.SuspendStateActive},
+		{AccountUUID: recovered, RemainingIncludedQuota: 500, CurrentBalance: 3, ThrottleState: store.ThrottleStateThrottled, SuspendState: store.SuspendStateSuspended},
+	} {
+		state := state
+		if err := st.UpsertAccountQuotaState(ctx, &state); err != nil {
+			t.Fatalf("upsert quota state: %v", err)
+		}
+	}
+
+	now := time.Date(2026, time.April, 10, 12, 0, 0, 0, time.UTC)
+	enforcer := NewQuotaEnforcer(st)
+	enforcer.now = func() time.Time { return now }
+
+	changed, err := enforcer.Enforce(ctx)
+	if err != nil {
+		t.Fatalf("enforce: %v", err)
+	}
+	if changed != 3 {
+		t.Fatalf("expected 3 changed accounts, got %d", changed)
+	}
+
+	want := map[string][2]string{
+		exhausted: {store.ThrottleStateThrottled, store.SuspendStateActive},
+		arrears:   {store.ThrottleStateThrottled, store.SuspendStateSuspended},
+		payg:      {store.ThrottleStateNormal, store.SuspendStateActive},
+		recovered: {store.ThrottleStateNormal, store.SuspendStateActive},
+	}
+	for accountUUID, states := range want {
+		state, err := st.GetAccountQuotaState(ctx, accountUUID)
+		if err != nil {
+			t.Fatalf("get quota state %s: %v", accountUUID, err)
+		}
+		if state.ThrottleState != states[0] || state.SuspendState != states[1] {
+			t.Fatalf("%s: expected %s/%s, got %s/%s", accountUUID, states[0], states[1], state.ThrottleState, state.SuspendState)
+		}
+	}
+
+	if changed, err := enforcer.Enforce(ctx); err != nil || changed != 0 {
+		t.Fatalf("expected second pass to be a no-op, got %d (%v)", changed, err)
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"account/internal/store"
+)
+
+const (
+	// LedgerEntryTypeTrafficCharge marks ledger entries produced by rating traffic
+	// minute buckets.
+	LedgerEntryTypeTrafficCharge = "traffic_charge"
+	// DefaultPricingRuleVersion matches the column default of
+	// account_billing_profiles and is used when an account has no profile.
+	DefaultPricingRuleVersion = "pricing-default-v1"
+
+	defaultRatingBatchSize   = 500
+	defaultRatingSettleDelay = 2 * time.Minute
+	defaultPeakStartHour     = 19
+	defaultPeakEndHour       = 23
+)
+
+// ErrRatingStoreNotConfigured is returned when the traffic rater lacks a store.
+var ErrRatingStoreNotConfigured = errors.New("traffic rating store is not configured")
+
+// TrafficRatingStore captures the persistence operations required to turn
+// pending traffic minute buckets into billing ledger entries.
+type TrafficRatingStore interface {
+	ListPendingTrafficMinuteBuckets(ctx context.Context, before time.Time, limit int) ([]store.TrafficMinuteBucket, error)
+	MarkTrafficMinuteBucketRated(ctx context.Context, bucket *store.TrafficMinuteBucket) error
+	RecordTrafficCharge(ctx context.Context, bucket *store.TrafficMinuteBucket, entry *store.BillingLedgerEntry, initial *store.AccountQuotaState, charge func(state *store.AccountQuotaState)) error
+	GetAccountBillingProfile(ctx context.Context, accountUUID string) (*store.AccountBillingProfile, error)
+}
+
+// TrafficRater prices pending traffic minute buckets against the account
+// billing profile, appends the result to the billing ledger and keeps the
+// account quota state in step. Each charge covers the bytes a bucket gained
+// since it was last billed, under its own source key, so traffic that
+// reaches a bucket after it was rated is billed by a later pass. Rating is
+// idempotent per charge and pricing rule version. Quota states are
+// re-evaluated with EvaluateQuotaState as they change.
+type TrafficRater struct {
+	Store TrafficRatingStore
+	// BatchSize bounds the number of buckets rated per pass.
+	BatchSize int
+	// SettleDelay keeps buckets that may still receive counters out of a
+	// pass. Only buckets that started at least this long ago are rated.
+	SettleDelay time.Duration
+	// PeakStartHour and PeakEndHour define the UTC hour range [start, end)
+	// billed with the peak multiplier. Other hours use the off-peak one.
+	PeakStartHour int
+	PeakEndHour   int
+
+	now func() time.Time
+}
+
+// NewTrafficRater constructs a rater with default batch size, settle delay
+// and peak window.
+func NewTrafficRater(st TrafficRatingStore) *TrafficRater {
+	return &TrafficRater{
+		Store:         st,
+		BatchSize:     defaultRatingBatchSize,
+		SettleDelay:   defaultRatingSettleDelay,
+		PeakStartHour: defaultPeakStartHour,
+		PeakEndHour:   defaultPeakEndHour,
+		now:           time.Now,
+	}
+}
+
+// TrafficBucketSourceKey returns the ledger source key identifying a minute
+// bucket.
+func TrafficBucketSourceKey(bucket store.TrafficMinuteBucket) string {
+	return fmt.Sprintf("traffic:%s:%s:%s:%s:%s",
+		bucket.BucketStart.UTC().Format(time.RFC3339),
+		strings.TrimSpace(bucket.NodeID),
+		strings.TrimSpace(bucket.AccountUUID),
+		strings.TrimSpace(bucket.Region),
+		strings.TrimSpace(bucket.LineCode),
+	)
+}
+
+// trafficChargeSourceKey identifies the charge for the unbilled bytes of
+// bucket. The first charge uses the bucket source key; later ones append
+// the byte count already billed.
+func trafficChargeSourceKey(bucket store.TrafficMinuteBucket) string {
+	key := TrafficBucketSourceKey(bucket)
+	if bucket.BilledBytes > 0 {
+		key += fmt.Sprintf(":+%d", bucket.BilledBytes)
+	}
+	return key
+}
+
+// RatePending rates one batch of settled pending buckets and returns the
+// number of buckets moved to the rated state.
+func (r *TrafficRater) RatePending(ctx context.Context) (int, error) {
+	if r == nil || r.Store == nil {
+		return 0, ErrRatingStoreNotConfigured
+	}
+
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRatingBatchSize
+	}
+	settleDelay := r.SettleDelay
+	if settleDelay < 0 {
+		settleDelay = 0
+	}
+
+	before := r.currentTime().Add(-settleDelay)
+	buckets, err := r.Store.ListPendingTrafficMinuteBuckets(ctx, before, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("list pending buckets: %w", err)
+	}
+
+	rated := 0
+	for _, bucket := range buckets {
+		ok, err := r.rateBucket(ctx, bucket)
+		if err != nil {
+			return rated, fmt.Errorf("rate bucket %s: %w", TrafficBucketSourceKey(bucket), err)
+		}
+		if ok {
+			rated++
+		}
+	}
+	return rated, nil
+}
+
+// rateBucket bills the bytes of bucket not billed yet and reports whether
+// the bucket ended up rated. A bucket that changed since it was listed stays
+// pending for the next pass.
+func (r *TrafficRater) rateBucket(ctx context.Context, bucket store.TrafficMinuteBucket) (bool, error) {
+	profile, err := r.loadProfile(ctx, bucket.AccountUUID)
+	if err != nil {
+		return false, err
+	}
+
+	multiplier := r.multiplier(profile, bucket.BucketStart)
+	bucket.Multiplier = multiplier
+	unbilled := bucket.TotalBytes - bucket.BilledBytes
+	if unbilled <= 0 {
+		return r.markRated(ctx, &bucket)
+	}
+
+	ratedBytes := int64(math.Round(float64(unbilled) * multiplier))
+	if ratedBytes < 0 {
+		ratedBytes = 0
+	}
+	entry := &store.BillingLedgerEntry{
+		AccountUUID:        bucket.AccountUUID,
+		BucketStart:        bucket.BucketStart.UTC(),
+		BucketEnd:          bucket.BucketStart.UTC().Add(time.Minute),
+		EntryType:          LedgerEntryTypeTrafficCharge,
+		RatedBytes:         ratedBytes,
+		PricingRuleVersion: profile.PricingRuleVersion,
+		SourceKey:          trafficChargeSourceKey(bucket),
+	}
+	initial := &store.AccountQuotaState{
+		AccountUUID:            bucket.AccountUUID,
+		RemainingIncludedQuota: profile.IncludedQuotaBytes,
+		ThrottleState:          store.ThrottleStateNormal,
+		SuspendState:           store.SuspendStateActive,
+	}
+	now := r.currentTime().UTC()
+	// The charge is applied to the state as stored when the transaction
+	// locks it, never to a copy read earlier.
+	charge := func(state *store.AccountQuotaState) {
+		covered := ratedBytes
+		if state.RemainingIncludedQuota < covered {
+			covered = state.RemainingIncludedQuota
+		}
+		if covered < 0 {
+			covered = 0
+		}
+		amount := float64(ratedBytes-covered) * profile.BasePricePerByte
+		balance := state.CurrentBalance - amount
+
+		entry.AmountDelta = -amount
+		entry.BalanceAfter = balance
+		state.RemainingIncludedQuota -= covered
+		state.CurrentBalance = balance
+		state.Arrears = balance < 0
+		applyQuotaPolicy(state, profile)
+		bucketEnd := entry.BucketEnd
+		if state.LastRatedBucketAt == nil || bucketEnd.After(*state.LastRatedBucketAt) {
+			state.LastRatedBucketAt = &bucketEnd
+		}
+		state.EffectiveAt = now
+	}
+
+	err = r.Store.RecordTrafficCharge(ctx, &bucket, entry, initial, charge)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, store.ErrTrafficBucketChanged):
+		return false, nil
+	case errors.Is(err, store.ErrLedgerEntryExists):
+		// Billed before the bucket tracked its billed bytes.
+		return r.markRated(ctx, &bucket)
+	default:
+		return false, fmt.Errorf("record traffic charge: %w", err)
+	}
+}
+
+func (r *TrafficRater) markRated(ctx context.Context, bucket *store.TrafficMinuteBucket) (bool, error) {
+	if err := r.Store.MarkTrafficMinuteBucketRated(ctx, bucket); err != nil {
+		if errors.Is(err, store.ErrTrafficBucketChanged) {
+			return false, nil
+		}
+		return false, fmt.Errorf("mark bucket rated: %w", err)
+	}
+	return true, nil
+}
+
+func (r *TrafficRater) loadProfile(ctx context.Context, accountUUID string) (*store.AccountBillingProfile, error) {
+	profile, err := r.Store.GetAccountBillingProfile(ctx, accountUUID)
+	if err != nil {
+		if !errors.Is(err, store.ErrUserNotFound) {
+			return nil, fmt.Errorf("load billing profile: %w", err)
+		}
+		profile = &store.AccountBillingProfile{AccountUUID: accountUUID}
+	}
+	if strings.TrimSpace(profile.PricingRuleVersion) == "" {
+		profile.PricingRuleVersion = DefaultPricingRuleVersion
+	}
+	return profile, nil
+}
+
+func (r *TrafficRater) multiplier(profile *store.AccountBillingProfile, bucketStart time.Time) float64 {
+	timeMultiplier := profile.OffPeakMultiplier
+	if r.isPeak(bucketStart) {
+		timeMultiplier = profile.PeakMultiplier
+	}
+	return positiveOrOne(profile.RegionMultiplier) * positiveOrOne(profile.LineMultiplier) * positiveOrOne(timeMultiplier)
+}
+
+func (r *TrafficRater) isPeak(at time.Time) bool {
+	start, end := r.PeakStartHour, r.PeakEndHour
+	if start == end {
+		return false
+	}
+	hour := at.UTC().Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	// Window wraps past midnight, e.g. 22 -> 2.
+	return hour >= start || hour < end
+}
+
+func (r *TrafficRater) currentTime() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+func positiveOrOne(value float64) float64 {
+	if value <= 0 {
+		return 1
+	}
+	return value
+}
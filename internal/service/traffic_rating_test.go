@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+func TestTrafficRaterRatesPendingBucketsIdempotently(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	now := time.Date(2026, time.April, 10, 12, 0, 0, 0, time.UTC)
+	accountUUID := "9f6a4c1e-8d1b-4a55-9c6e-2f3b0c1d2e3f"
+
+	if err := st.UpsertAccountBillingProfile(ctx, &store.AccountBillingProfile{
+		AccountUUID:        accountUUID,
+		PackageName:        "standard",
+		IncludedQuotaBytes: 1000,
+		BasePricePerByte:   0.01,
+		RegionMultiplier:   2,
+		LineMultiplier:     1,
+		PeakMultiplier:     3,
+		OffPeakMultiplier:  1,
+		PricingRuleVersion: "pricing-v2",
+	}); err != nil {
+		t.Fatalf("upsert billing profile: %v", err)
+	}
+
+	offPeak := now.Add(-10 * time.Minute)
+	peak := time.Date(2026, time.April, 9, 20, 0, 0, 0, time.UTC)
+	unsettled := now.Add(-30 * time.Second).Truncate(time.Minute)
+	for _, bucket := range []store.TrafficMinuteBucket{
+		{BucketStart: offPeak, NodeID: "hk-1", AccountUUID: accountUUID, TotalBytes: 400, Multiplier: 1},
+		{BucketStart: peak, NodeID: "hk-1", AccountUUID: accountUUID, TotalBytes: 100, Multiplier: 1},
+		{BucketStart: unsettled, NodeID: "hk-1", AccountUUID: accountUUID, TotalBytes: 50, Multiplier: 1},
+	} {
+		bucket := bucket
+		if err := st.UpsertTrafficMinuteBucket(ctx, &bucket); err != nil {
+			t.Fatalf("upsert bucket: %v", err)
+		}
+	}
+
+	rater := NewTrafficRater(st)
+	rater.now = func() time.Time { return now }
+
+	rated, err := rater.RatePending(ctx)
+	if err != nil {
+		t.Fatalf("rate pending: %v", err)
+	}
+	if rated != 2 {
+		t.Fatalf("expected 2 rated buckets, got %d", rated)
+	}
+
+	// Peak bucket first: 100 bytes * 2 (region) * 3 (peak) = 600, fully
+	// covered by the included quota. Off-peak bucket: 400 * 2 = 800, of which
+	// 400 is covered and 400 billed at 0.01.
+	state, err := st.GetAccountQuotaState(ctx, accountUUID)
+	if err != nil {
+		t.Fatalf("get quota state: %v", err)
+	}
+	if state.RemainingIncludedQuota != 0 {
+		t.Fatalf("expected included quota to be exhausted, got %d", state.RemainingIncludedQuota)
+	}
+	if math.Abs(state.CurrentBalance-(-4)) > 1e-9 {
+		t.Fatalf("expected balance -4, got %v", state.CurrentBalance)
+	}
+	if !state.Arrears {
+		t.Fatalf("expected account to be in arrears")
+	}
+	if state.LastRatedBucketAt == nil || !state.LastRatedBucketAt.Equal(offPeak.Add(time.Minute)) {
+		t.Fatalf("unexpected last rated bucket: %v", state.LastRatedBucketAt)
+	}
+
+	ledger, err := st.ListBillingLedgerByAccount(ctx, accountUUID, 0)
+	if err != nil {
+		t.Fatalf("list ledger: %v", err)
+	}
+	if len(ledger) != 2 {
+		t.Fatalf("expected 2 ledger entries, got %d", len(ledger))
+	}
+	for _, entry := range ledger {
+		if entry.PricingRuleVersion != "pricing-v2" || entry.EntryType != LedgerEntryTypeTrafficCharge {
+			t.Fatalf("unexpected ledger entry: %+v", entry)
+		}
+	}
+
+	buckets, err := st.ListTrafficMinuteBucketsByAccount(ctx, accountUUID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	for _, bucket := range buckets {
+		want := store.RatingStatusRated
+		if bucket.BucketStart.Equal(unsettled) {
+			want = store.RatingStatusPending
+		}
+		if bucket.RatingStatus != want {
+			t.Fatalf("bucket %s: expected status %q, got %q", bucket.BucketStart, want, bucket.RatingStatus)
+		}
+	}
+
+	// Re-queuing an already billed bucket must not bill it twice.
+	requeued := store.TrafficMinuteBucket{BucketStart: offPeak, NodeID: "hk-1", AccountUUID: accountUUID, TotalBytes: 400, Multiplier: 1}
+	if err := st.UpsertTrafficMinuteBucket(ctx, &requeued); err != nil {
+		t.Fatalf("requeue bucket: %v", err)
+	}
+	if rated, err := rater.RatePending(ctx); err != nil || rated != 1 {
+		t.Fatalf("expected requeued bucket to be marked rated, got %d (%v)", rated, err)
+	}
+	ledger, err = st.ListBillingLedgerByAccount(ctx, accountUUID, 0)
+	if err != nil {
+		t.Fatalf("list ledger: %v", err)
+	}
+	if len(ledger) != 2 {
+		t.Fatalf("expected ledger to stay at 2 entries, got %d", len(ledger))
+	}
+	state, err = st.GetAccountQuotaState(ctx, accountUUID)
+	if err != nil {
+		t.Fatalf("get quota state: %v", err)
+	}
+	if math.Abs(state.CurrentBalance-(-4)) > 1e-9 {
+		t.Fatalf("expected balance to stay at -4, got %v", state.CurrentBalance)
+	}
+}
+
+// failingChargeStore fails the next RecordTrafficCharge call.
+type failingChargeStore struct {
+	store.Store
+	fail bool
+}
+
+func (s *failingChargeStore) RecordTrafficCharge(ctx context.Context, bucket *store.TrafficMinuteBucket, entry *store.BillingLedgerEntry, initial *store.AccountQuotaState, charge func(state *store.AccountQuotaState)) error {
+	if s.fail {
+		s.fail = false
+		return errors.New("connection reset")
+	}
+	return s.Store.RecordTrafficCharge(ctx, bucket, entry, initial, charge)
+}
+
+func TestTrafficRaterRetriesFailedCharges(t *testing.T) {
+	ctx := context.Background()
+	st := &failingChargeStore{Store: store.NewMemoryStore(), fail: true}
+	now := time.Date(2026, time.April, 10, 12, 0, 0, 0, time.UTC)
+	accountUUID := "0b7e2f4a-3c5d-4e6f-8a9b-1c2d3e4f5a6b"
+
+	if err := st.UpsertAccountBillingProfile(ctx, &store.AccountBillingProfile{
+		AccountUUID:        accountUUID,
+		IncludedQuotaBytes: 1000,
+		BasePricePerByte:   0.01,
+	}); err != nil {
+		t.Fatalf("upsert billing profile: %v", err)
+	}
+	bucket := store.TrafficMinuteBucket{BucketStart: now.Add(-10 * time.Minute), NodeID: "hk-1", AccountUUID: accountUUID, TotalBytes: 400, Multiplier: 1}
+	if err := st.UpsertTrafficMinuteBucket(ctx, &bucket); err != nil {
+		t.Fatalf("upsert bucket: %v", err)
+	}
+
+	rater := NewTrafficRater(st)
+	rater.now = func() time.Time { return now }
+	if _, err := rater.RatePending(ctx); err == nil {
+		t.Fatalf("expected the failed charge to fail the pass")
+	}
+	if ledger, _ := st.ListBillingLedgerByAccount(ctx, accountUUID, 0); len(ledger) != 0 {
+		t.Fatalf("expected no ledger entry after a failed charge, got %d", len(ledger))
+	}
+	if _, err := st.GetAccountQuotaState(ctx, accountUUID); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("expected no quota state after a failed charge, got %v", err)
+	}
+
+	if rated, err := rater.RatePending(ctx); err != nil || rated != 1 {
+		t.Fatalf("expected the retry to rate the bucket, got %d (%v)", rated, err)
+	}
+	state, err := st.GetAccountQuotaState(ctx, accountUUID)
+	if err != nil || state.RemainingIncludedQuota != 600 {
+		t.Fatalf("expected the charge to reach the quota once, got %+v (%v)", state, err)
+	}
+}
+
+func TestTrafficRaterBillsBytesArrivingAfterRating(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	now := time.Date(2026, time.April, 10, 12, 0, 0, 0, time.UTC)
+	accountUUID := "5d1c7a2e-4b3f-4e8a-9c0d-6e7f8a9b0c1d"
+
+	if err := st.UpsertAccountBillingProfile(ctx, &store.AccountBillingProfile{
+		AccountUUID:      accountUUID,
+		BasePricePerByte: 0.01,
+	}); err != nil {
+		t.Fatalf("upsert billing profile: %v", err)
+	}
+	bucket := store.TrafficMinuteBucket{BucketStart: now.Add(-10 * time.Minute), NodeID: "hk-1", AccountUUID: accountUUID}
+	late := bucket
+	bucket.TotalBytes = 100
+	if err := st.AccumulateTrafficMinuteBucket(ctx, &bucket); err != nil {
+		t.Fatalf("accumulate bucket: %v", err)
+	}
+
+	rater := NewTrafficRater(st)
+	rater.now = func() time.Time { return now }
+	if rated, err := rater.RatePending(ctx); err != nil || rated != 1 {
+		t.Fatalf("expected the bucket to be rated, got %d (%v)", rated, err)
+	}
+
+	late.TotalBytes = 50
+	if err := st.AccumulateTrafficMinuteBucket(ctx, &late); err != nil {
+		t.Fatalf("accumulate late bytes: %v", err)
+	}
+	if rated, err := rater.RatePending(ctx); err != nil || rated != 1 {
+		t.Fatalf("expected the late bytes to be rated, got %d (%v)", rated, err)
+	}
+
+	ledger, err := st.ListBillingLedgerByAccount(ctx, accountUUID, 0)
+	if err != nil {
+		t.Fatalf("list ledger: %v", err)
+	}
+	if len(ledger) != 2 || ledger[0].SourceKey == ledger[1].SourceKey {
+		t.Fatalf("expected two charges under distinct source keys, got %+v", ledger)
+	}
+	state, err := st.GetAccountQuotaState(ctx, accountUUID)
+	if err != nil {
+		t.Fatalf("get quota state: %v", err)
+	}
+	if math.Abs(state.CurrentBalance-(-1.5)) > 1e-9 {
+		t.Fatalf("expected all 150 bytes to be billed, got balance %v", state.CurrentBalance)
+	}
+}
+
+// toppingUpStore credits the account just before the charge is recorded, as
+// a concurrent writer on another replica would.
+type toppingUpStore struct {
+	store.Store
+}
+
+func (s *toppingUpStore) RecordTrafficCharge(ctx context.Context, bucket *store.TrafficMinuteBucket, entry *store.BillingLedgerEntry, initial *store.AccountQuotaState, charge func(state *store.AccountQuotaState)) error {
+	state, err := s.Store.GetAccountQuotaState(ctx, entry.AccountUUID)
+	if err != nil {
+		return err
+	}
+	state.CurrentBalance += 10
+	if err := s.Store.UpsertAccountQuotaState(ctx, state); err != nil {
+		return err
+	}
+	return s.Store.RecordTrafficCharge(ctx, bucket, entry, initial, charge)
+}
+
+func TestTrafficRaterKeepsConcurrentQuotaChanges(t *testing.T) {
+	ctx := context.Background()
+	st := &toppingUpStore{Store: store.NewMemoryStore()}
+	now := time.Date(2026, time.April, 10, 12, 0, 0, 0, time.UTC)
+	accountUUID := "7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c0d"
+
+	if err := st.UpsertAccountBillingProfile(ctx, &store.AccountBillingProfile{
+		AccountUUID:      accountUUID,
+		BasePricePerByte: 0.01,
+	}); err != nil {
+		t.Fatalf("upsert billing profile: %v", err)
+	}
+	if err := st.UpsertAccountQuotaState(ctx, &store.AccountQuotaState{
+		AccountUUID:   accountUUID,
+		ThrottleState: store.ThrottleStateNormal,
+		SuspendState:  store.SuspendStateActive,
+	}); err != nil {
+		t.Fatalf("upsert quota state: %v", err)
+	}
+	bucket := store.TrafficMinuteBucket{BucketStart: now.Add(-10 * time.Minute), NodeID: "hk-1", AccountUUID: accountUUID, TotalBytes: 100}
+	if err := st.AccumulateTrafficMinuteBucket(ctx, &bucket); err != nil {
+		t.Fatalf("accumulate bucket: %v", err)
+	}
+
+	rater := NewTrafficRater(st)
+	rater.now = func() time.Time { return now }
+	if rated, err := rater.RatePending(ctx); err != nil || rated != 1 {
+		t.Fatalf("expected the bucket to be rated, got %d (%v)", rated, err)
+	}
+	state, err := st.GetAccountQuotaState(ctx, accountUUID)
+	if err != nil {
+		t.Fatalf("get quota state: %v", err)
+	}
+	if math.Abs(state.CurrentBalance-9) > 1e-9 || state.Arrears {
+		t.Fatalf("expected the top-up to survive the charge, got %+v", state)
+	}
+}
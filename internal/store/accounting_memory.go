@@ -98,7 +98,39 @@ func (s *memoryStore) UpsertTrafficStatCheckpoint(ctx context.Context, checkpoin
 	_ = ctx
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.upsertTrafficStatCheckpointLocked(checkpoint)
+}
+
+func (s *memoryStore) RecordTrafficSample(ctx context.Context, previous, checkpoint *TrafficStatCheckpoint, bucket *TrafficMinuteBucket) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if checkpoint == nil {
+		return errors.New("checkpoint is required")
+	}
+	current, ok := s.trafficStatCheckpoints[checkpointKey(checkpoint.NodeID, checkpoint.AccountUUID)]
+	if ok != (previous != nil) || (ok && !sameTrafficCounters(current, previous)) {
+		return ErrTrafficCheckpointChanged
+	}
+	if bucket != nil {
+		if err := s.accumulateTrafficMinuteBucketLocked(bucket); err != nil {
+			return err
+		}
+	}
+	return s.upsertTrafficStatCheckpointLocked(checkpoint)
+}
 
+// sameTrafficCounters reports whether two checkpoints record the same
+// counter readings.
+func sameTrafficCounters(a, b *TrafficStatCheckpoint) bool {
+	return a.LastUplinkTotal == b.LastUplinkTotal &&
+		a.LastDownlinkTotal == b.LastDownlinkTotal &&
+		a.ResetEpoch == b.ResetEpoch &&
+		strings.TrimSpace(a.XrayRevision) == strings.TrimSpace(b.XrayRevision)
+}
+
+func (s *memoryStore) upsertTrafficStatCheckpointLocked(checkpoint *TrafficStatCheckpoint) error {
 	copy := cloneCheckpoint(checkpoint)
 	if copy == nil {
 		return errors.New("checkpoint is required")
@@ -159,7 +191,48 @@ func (s *memoryStore) UpsertTrafficMinuteBucket(ctx context.Context, bucket *Tra
 	if strings.TrimSpace(copy.RatingStatus) == "" {
 		copy.RatingStatus = RatingStatusPending
 	}
-	s.trafficMinuteBuckets[bucketKey(copy.BucketStart, copy.NodeID, copy.AccountUUID, copy.Region, copy.LineCode)] = copy
+	key := bucketKey(copy.BucketStart, copy.NodeID, copy.AccountUUID, copy.Region, copy.LineCode)
+	copy.BilledBytes = 0
+	if existing, ok := s.trafficMinuteBuckets[key]; ok {
+		copy.BilledBytes = existing.BilledBytes
+	}
+	s.trafficMinuteBuckets[key] = copy
+	return nil
+}
+
+func (s *memoryStore) AccumulateTrafficMinuteBucket(ctx context.Context, bucket *TrafficMinuteBucket) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accumulateTrafficMinuteBucketLocked(bucket)
+}
+
+func (s *memoryStore) accumulateTrafficMinuteBucketLocked(bucket *TrafficMinuteBucket) error {
+	copy := cloneBucket(bucket)
+	if copy == nil {
+		return errors.New("bucket is required")
+	}
+	now := time.Now().UTC()
+	key := bucketKey(copy.BucketStart, copy.NodeID, copy.AccountUUID, copy.Region, copy.LineCode)
+	if existing, ok := s.trafficMinuteBuckets[key]; ok {
+		copy.UplinkBytes += existing.UplinkBytes
+		copy.DownlinkBytes += existing.DownlinkBytes
+		copy.TotalBytes += existing.TotalBytes
+		copy.BilledBytes = existing.BilledBytes
+		copy.CreatedAt = existing.CreatedAt
+	} else {
+		copy.BilledBytes = 0
+	}
+	if copy.CreatedAt.IsZero() {
+		copy.CreatedAt = now
+	}
+	if copy.Multiplier == 0 {
+		copy.Multiplier = 1
+	}
+	copy.UpdatedAt = now
+	copy.RatingStatus = RatingStatusPending
+	s.trafficMinuteBuckets[key] = copy
+	*bucket = *cloneBucket(copy)
 	return nil
 }
 
@@ -202,15 +275,117 @@ func (s *memoryStore) ListTrafficMinuteBuckets(ctx context.Context) ([]TrafficMi
 	return result, nil
 }
 
+func (s *memoryStore) ListPendingTrafficMinuteBuckets(ctx context.Context, before time.Time, limit int) ([]TrafficMinuteBucket, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]TrafficMinuteBucket, 0)
+	for _, bucket := range s.trafficMinuteBuckets {
+		if bucket.RatingStatus != RatingStatusPending {
+			continue
+		}
+		if !before.IsZero() && !bucket.BucketStart.Before(before) {
+			continue
+		}
+		result = append(result, *cloneBucket(bucket))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].BucketStart.Equal(result[j].BucketStart) {
+			return bucketKey(result[i].BucketStart, result[i].NodeID, result[i].AccountUUID, result[i].Region, result[i].LineCode) <
+				bucketKey(result[j].BucketStart, result[j].NodeID, result[j].AccountUUID, result[j].Region, result[j].LineCode)
+		}
+		return result[i].BucketStart.Before(result[j].BucketStart)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
 func (s *memoryStore) InsertBillingLedgerEntry(ctx context.Context, entry *BillingLedgerEntry) error {
 	_ = ctx
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.insertBillingLedgerEntryLocked(entry)
+}
+
+func (s *memoryStore) MarkTrafficMinuteBucketRated(ctx context.Context, bucket *TrafficMinuteBucket) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	stored, err := s.pendingTrafficMinuteBucketLocked(bucket)
+	if err != nil {
+		return err
+	}
+	s.markTrafficMinuteBucketRatedLocked(stored, bucket)
+	return nil
+}
+
+func (s *memoryStore) pendingTrafficMinuteBucketLocked(bucket *TrafficMinuteBucket) (*TrafficMinuteBucket, error) {
+	if bucket == nil {
+		return nil, errors.New("bucket is required")
+	}
+	stored, ok := s.trafficMinuteBuckets[bucketKey(bucket.BucketStart, bucket.NodeID, bucket.AccountUUID, bucket.Region, bucket.LineCode)]
+	if !ok || stored.RatingStatus != RatingStatusPending || stored.TotalBytes != bucket.TotalBytes {
+		return nil, ErrTrafficBucketChanged
+	}
+	return stored, nil
+}
+
+func (s *memoryStore) markTrafficMinuteBucketRatedLocked(stored, bucket *TrafficMinuteBucket) {
+	stored.BilledBytes = stored.TotalBytes
+	stored.Multiplier = bucket.Multiplier
+	stored.RatingStatus = RatingStatusRated
+	stored.UpdatedAt = time.Now().UTC()
+	bucket.BilledBytes = stored.BilledBytes
+	bucket.RatingStatus = stored.RatingStatus
+	bucket.UpdatedAt = stored.UpdatedAt
+}
+
+func (s *memoryStore) RecordTrafficCharge(ctx context.Context, bucket *TrafficMinuteBucket, entry *BillingLedgerEntry, initial *AccountQuotaState, charge func(state *AccountQuotaState)) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry == nil {
+		return errors.New("ledger entry is required")
+	}
+	stored, err := s.pendingTrafficMinuteBucketLocked(bucket)
+	if err != nil {
+		return err
+	}
+	state := cloneQuotaState(s.accountQuotaStates[strings.TrimSpace(entry.AccountUUID)])
+	if state == nil {
+		if initial == nil {
+			return ErrUserNotFound
+		}
+		state = cloneQuotaState(initial)
+	}
+	charge(state)
+	if err := s.insertBillingLedgerEntryLocked(entry); err != nil {
+		return err
+	}
+	s.upsertAccountQuotaStateLocked(state)
+	s.markTrafficMinuteBucketRatedLocked(stored, bucket)
+	return nil
+}
+
+func (s *memoryStore) insertBillingLedgerEntryLocked(entry *BillingLedgerEntry) error {
 	copy := cloneLedgerEntry(entry)
 	if copy == nil {
 		return errors.New("ledger entry is required")
 	}
+	copy.SourceKey = strings.TrimSpace(copy.SourceKey)
+	copy.PricingRuleVersion = strings.TrimSpace(copy.PricingRuleVersion)
+	if copy.SourceKey != "" {
+		for _, existing := range s.billingLedgerEntries {
+			if existing.SourceKey == copy.SourceKey && existing.PricingRuleVersion == copy.PricingRuleVersion {
+				return ErrLedgerEntryExists
+			}
+		}
+	}
 	now := time.Now().UTC()
 	if strings.TrimSpace(copy.ID) == "" {
 		copy.ID = uuid.NewString()
@@ -249,16 +424,36 @@ func (s *memoryStore) UpsertAccountQuotaState(ctx context.Context, state *Accoun
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	copy := cloneQuotaState(state)
-	if copy == nil {
+	if state == nil {
 		return errors.New("quota state is required")
 	}
+	s.upsertAccountQuotaStateLocked(state)
+	return nil
+}
+
+func (s *memoryStore) upsertAccountQuotaStateLocked(state *AccountQuotaState) {
+	copy := cloneQuotaState(state)
 	copy.UpdatedAt = time.Now().UTC()
 	if copy.EffectiveAt.IsZero() {
 		copy.EffectiveAt = copy.UpdatedAt
 	}
 	s.accountQuotaStates[strings.TrimSpace(copy.AccountUUID)] = copy
-	return nil
+}
+
+func (s *memoryStore) UpdateAccountQuotaState(ctx context.Context, accountUUID string, update func(state *AccountQuotaState) bool) (bool, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := cloneQuotaState(s.accountQuotaStates[strings.TrimSpace(accountUUID)])
+	if state == nil {
+		return false, ErrUserNotFound
+	}
+	if !update(state) {
+		return false, nil
+	}
+	s.upsertAccountQuotaStateLocked(state)
+	return true, nil
 }
 
 func (s *memoryStore) GetAccountQuotaState(ctx context.Context, accountUUID string) (*AccountQuotaState, error) {
@@ -273,6 +468,21 @@ func (s *memoryStore) GetAccountQuotaState(ctx context.Context, accountUUID stri
 	return cloneQuotaState(record), nil
 }
 
+func (s *memoryStore) ListAccountQuotaStates(ctx context.Context) ([]AccountQuotaState, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]AccountQuotaState, 0, len(s.accountQuotaStates))
+	for _, record := range s.accountQuotaStates {
+		result = append(result, *cloneQuotaState(record))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AccountUUID < result[j].AccountUUID
+	})
+	return result, nil
+}
+
 func (s *memoryStore) UpsertAccountBillingProfile(ctx context.Context, profile *AccountBillingProfile) error {
 	_ = ctx
 	s.mu.Lock()
@@ -333,6 +543,21 @@ func (s *memoryStore) GetLatestAccountPolicySnapshot(ctx context.Context, accoun
 	return clonePolicySnapshot(record), nil
 }
 
+func (s *memoryStore) ListAccountPolicySnapshots(ctx context.Context) ([]AccountPolicySnapshot, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]AccountPolicySnapshot, 0, len(s.accountPolicySnapshots))
+	for _, record := range s.accountPolicySnapshots {
+		result = append(result, *clonePolicySnapshot(record))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AccountUUID < result[j].AccountUUID
+	})
+	return result, nil
+}
+
 func (s *memoryStore) UpsertNodeHealthSnapshot(ctx context.Context, snapshot *NodeHealthSnapshot) error {
 	_ = ctx
 	s.mu.Lock()
@@ -141,9 +141,140 @@ func (s *postgresStore) UpsertTrafficMinuteBucket(ctx context.Context, bucket *T
 	).Scan(&bucket.CreatedAt, &bucket.UpdatedAt)
 }
 
+func (s *postgresStore) AccumulateTrafficMinuteBucket(ctx context.Context, bucket *TrafficMinuteBucket) error {
+	return accumulateTrafficMinuteBucket(ctx, s.db, bucket)
+}
+
+// RecordTrafficSample adds bucket and moves the checkpoint in one
+// transaction. The checkpoint only moves if it still holds the counters of
+// previous, so two reports computed from the same checkpoint cannot both
+// count their delta.
+func (s *postgresStore) RecordTrafficSample(ctx context.Context, previous, checkpoint *TrafficStatCheckpoint, bucket *TrafficMinuteBucket) error {
+	if checkpoint == nil {
+		return errors.New("checkpoint is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var row *sql.Row
+	if previous == nil {
+		const query = `
+			INSERT INTO traffic_stat_checkpoints (
+				node_id, account_uuid, last_uplink_total, last_downlink_total, last_seen_at, xray_revision, reset_epoch
+			) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (node_id, account_uuid) DO NOTHING
+			RETURNING created_at, updated_at`
+		row = tx.QueryRowContext(
+			ctx,
+			query,
+			strings.TrimSpace(checkpoint.NodeID),
+			strings.TrimSpace(checkpoint.AccountUUID),
+			checkpoint.LastUplinkTotal,
+			checkpoint.LastDownlinkTotal,
+			checkpoint.LastSeenAt.UTC(),
+			strings.TrimSpace(checkpoint.XrayRevision),
+			checkpoint.ResetEpoch,
+		)
+	} else {
+		const query = `
+			UPDATE traffic_stat_checkpoints SET
+				last_uplink_total = $3,
+				last_downlink_total = $4,
+				last_seen_at = $5,
+				xray_revision = $6,
+				reset_epoch = $7,
+				updated_at = now()
+			WHERE node_id = $1 AND account_uuid = $2
+				AND last_uplink_total = $8 AND last_downlink_total = $9
+				AND reset_epoch = $10 AND xray_revision = $11
+			RETURNING created_at, updated_at`
+		row = tx.QueryRowContext(
+			ctx,
+			query,
+			strings.TrimSpace(checkpoint.NodeID),
+			strings.TrimSpace(checkpoint.AccountUUID),
+			checkpoint.LastUplinkTotal,
+			checkpoint.LastDownlinkTotal,
+			checkpoint.LastSeenAt.UTC(),
+			strings.TrimSpace(checkpoint.XrayRevision),
+			checkpoint.ResetEpoch,
+			previous.LastUplinkTotal,
+			previous.LastDownlinkTotal,
+			previous.ResetEpoch,
+			strings.TrimSpace(previous.XrayRevision),
+		)
+	}
+	if err := row.Scan(&checkpoint.CreatedAt, &checkpoint.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTrafficCheckpointChanged
+		}
+		return err
+	}
+
+	if bucket != nil {
+		if err := accumulateTrafficMinuteBucket(ctx, tx, bucket); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func accumulateTrafficMinuteBucket(ctx context.Context, q queryRower, bucket *TrafficMinuteBucket) error {
+	if bucket == nil {
+		return errors.New("bucket is required")
+	}
+
+	multiplier := bucket.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	const query = `
+		INSERT INTO traffic_minute_buckets (
+			bucket_start, node_id, account_uuid, region, line_code, uplink_bytes, downlink_bytes, total_bytes, multiplier, rating_status, source_revision
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (bucket_start, node_id, account_uuid, region, line_code) DO UPDATE SET
+			uplink_bytes = traffic_minute_buckets.uplink_bytes + EXCLUDED.uplink_bytes,
+			downlink_bytes = traffic_minute_buckets.downlink_bytes + EXCLUDED.downlink_bytes,
+			total_bytes = traffic_minute_buckets.total_bytes + EXCLUDED.total_bytes,
+			rating_status = EXCLUDED.rating_status,
+			source_revision = EXCLUDED.source_revision,
+			updated_at = now()
+		RETURNING uplink_bytes, downlink_bytes, total_bytes, billed_bytes, multiplier, rating_status, created_at, updated_at`
+
+	return q.QueryRowContext(
+		ctx,
+		query,
+		bucket.BucketStart.UTC(),
+		strings.TrimSpace(bucket.NodeID),
+		strings.TrimSpace(bucket.AccountUUID),
+		strings.TrimSpace(bucket.Region),
+		strings.TrimSpace(bucket.LineCode),
+		bucket.UplinkBytes,
+		bucket.DownlinkBytes,
+		bucket.TotalBytes,
+		multiplier,
+		RatingStatusPending,
+		strings.TrimSpace(bucket.SourceRevision),
+	).Scan(
+		&bucket.UplinkBytes,
+		&bucket.DownlinkBytes,
+		&bucket.TotalBytes,
+		&bucket.BilledBytes,
+		&bucket.Multiplier,
+		&bucket.RatingStatus,
+		&bucket.CreatedAt,
+		&bucket.UpdatedAt,
+	)
+}
+
 func (s *postgresStore) ListTrafficMinuteBucketsByAccount(ctx context.Context, accountUUID string, start, end time.Time) ([]TrafficMinuteBucket, error) {
 	query := `
-		SELECT bucket_start, node_id, account_uuid, region, line_code, uplink_bytes, downlink_bytes, total_bytes, multiplier, rating_status, source_revision, created_at, updated_at
+		SELECT bucket_start, node_id, account_uuid, region, line_code, uplink_bytes, downlink_bytes, total_bytes, billed_bytes, multiplier, rating_status, source_revision, created_at, updated_at
 		FROM traffic_minute_buckets
 		WHERE account_uuid = $1`
 	args := []any{strings.TrimSpace(accountUUID)}
@@ -175,6 +306,7 @@ func (s *postgresStore) ListTrafficMinuteBucketsByAccount(ctx context.Context, a
 			&record.UplinkBytes,
 			&record.DownlinkBytes,
 			&record.TotalBytes,
+			&record.BilledBytes,
 			&record.Multiplier,
 			&record.RatingStatus,
 			&record.SourceRevision,
@@ -190,7 +322,7 @@ func (s *postgresStore) ListTrafficMinuteBucketsByAccount(ctx context.Context, a
 
 func (s *postgresStore) ListTrafficMinuteBuckets(ctx context.Context) ([]TrafficMinuteBucket, error) {
 	const query = `
-		SELECT bucket_start, node_id, account_uuid, region, line_code, uplink_bytes, downlink_bytes, total_bytes, multiplier, rating_status, source_revision, created_at, updated_at
+		SELECT bucket_start, node_id, account_uuid, region, line_code, uplink_bytes, downlink_bytes, total_bytes, billed_bytes, multiplier, rating_status, source_revision, created_at, updated_at
 		FROM traffic_minute_buckets
 		ORDER BY bucket_start ASC`
 	rows, err := s.db.QueryContext(ctx, query)
@@ -211,6 +343,7 @@ func (s *postgresStore) ListTrafficMinuteBuckets(ctx context.Context) ([]Traffic
 			&record.UplinkBytes,
 			&record.DownlinkBytes,
 			&record.TotalBytes,
+			&record.BilledBytes,
 			&record.Multiplier,
 			&record.RatingStatus,
 			&record.SourceRevision,
@@ -224,7 +357,136 @@ func (s *postgresStore) ListTrafficMinuteBuckets(ctx context.Context) ([]Traffic
 	return result, rows.Err()
 }
 
+func (s *postgresStore) ListPendingTrafficMinuteBuckets(ctx context.Context, before time.Time, limit int) ([]TrafficMinuteBucket, error) {
+	query := `
+		SELECT bucket_start, node_id, account_uuid, region, line_code, uplink_bytes, downlink_bytes, total_bytes, billed_bytes, multiplier, rating_status, source_revision, created_at, updated_at
+		FROM traffic_minute_buckets
+		WHERE rating_status = $1`
+	args := []any{RatingStatusPending}
+	if !before.IsZero() {
+		query += " AND bucket_start < $2"
+		args = append(args, before.UTC())
+	}
+	query += " ORDER BY bucket_start ASC, node_id ASC, account_uuid ASC, region ASC, line_code ASC"
+	if limit > 0 {
+		query += " LIMIT $" + strconv.Itoa(len(args)+1)
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TrafficMinuteBucket
+	for rows.Next() {
+		var record TrafficMinuteBucket
+		if err := rows.Scan(
+			&record.BucketStart,
+			&record.NodeID,
+			&record.AccountUUID,
+			&record.Region,
+			&record.LineCode,
+			&record.UplinkBytes,
+			&record.DownlinkBytes,
+			&record.TotalBytes,
+			&record.BilledBytes,
+			&record.Multiplier,
+			&record.RatingStatus,
+			&record.SourceRevision,
+			&record.CreatedAt,
+			&record.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func (s *postgresStore) InsertBillingLedgerEntry(ctx context.Context, entry *BillingLedgerEntry) error {
+	return insertBillingLedgerEntry(ctx, s.db, entry)
+}
+
+func (s *postgresStore) MarkTrafficMinuteBucketRated(ctx context.Context, bucket *TrafficMinuteBucket) error {
+	return markTrafficMinuteBucketRated(ctx, s.db, bucket)
+}
+
+// markTrafficMinuteBucketRated only touches the rating columns and matches
+// the total being rated, so bytes accumulated since the bucket was listed
+// keep it pending instead of being lost.
+func markTrafficMinuteBucketRated(ctx context.Context, q queryRower, bucket *TrafficMinuteBucket) error {
+	if bucket == nil {
+		return errors.New("bucket is required")
+	}
+
+	const query = `
+		UPDATE traffic_minute_buckets SET
+			billed_bytes = total_bytes,
+			multiplier = $6,
+			rating_status = $7,
+			updated_at = now()
+		WHERE bucket_start = $1 AND node_id = $2 AND account_uuid = $3 AND region = $4 AND line_code = $5
+			AND rating_status = $8 AND total_bytes = $9
+		RETURNING billed_bytes, rating_status, updated_at`
+
+	err := q.QueryRowContext(
+		ctx,
+		query,
+		bucket.BucketStart.UTC(),
+		strings.TrimSpace(bucket.NodeID),
+		strings.TrimSpace(bucket.AccountUUID),
+		strings.TrimSpace(bucket.Region),
+		strings.TrimSpace(bucket.LineCode),
+		bucket.Multiplier,
+		RatingStatusRated,
+		RatingStatusPending,
+		bucket.TotalBytes,
+	).Scan(&bucket.BilledBytes, &bucket.RatingStatus, &bucket.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTrafficBucketChanged
+	}
+	return err
+}
+
+// RecordTrafficCharge marks bucket rated, inserts entry and charges the
+// account in one transaction. The quota state row stays locked from the
+// moment charge reads it until the transaction ends, so concurrent raters
+// and the quota enforcer apply their changes one after another.
+func (s *postgresStore) RecordTrafficCharge(ctx context.Context, bucket *TrafficMinuteBucket, entry *BillingLedgerEntry, initial *AccountQuotaState, charge func(state *AccountQuotaState)) error {
+	if entry == nil {
+		return errors.New("ledger entry is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := markTrafficMinuteBucketRated(ctx, tx, bucket); err != nil {
+		return err
+	}
+	state, err := lockAccountQuotaState(ctx, tx, entry.AccountUUID, initial)
+	if err != nil {
+		return err
+	}
+	charge(state)
+	if err := insertBillingLedgerEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := upsertAccountQuotaState(ctx, tx, state); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertBillingLedgerEntry(ctx context.Context, q queryRower, entry *BillingLedgerEntry) error {
 	if entry == nil {
 		return errors.New("ledger entry is required")
 	}
@@ -234,11 +496,12 @@ func (s *postgresStore) InsertBillingLedgerEntry(ctx context.Context, entry *Bil
 
 	const query = `
 		INSERT INTO billing_ledger (
-			id, account_uuid, bucket_start, bucket_end, entry_type, rated_bytes, amount_delta, balance_after, pricing_rule_version
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			id, account_uuid, bucket_start, bucket_end, entry_type, rated_bytes, amount_delta, balance_after, pricing_rule_version, source_key
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (source_key, pricing_rule_version) WHERE source_key <> '' DO NOTHING
 		RETURNING created_at`
 
-	return s.db.QueryRowContext(
+	err := q.QueryRowContext(
 		ctx,
 		query,
 		entry.ID,
@@ -250,12 +513,17 @@ func (s *postgresStore) InsertBillingLedgerEntry(ctx context.Context, entry *Bil
 		entry.AmountDelta,
 		entry.BalanceAfter,
 		strings.TrimSpace(entry.PricingRuleVersion),
+		strings.TrimSpace(entry.SourceKey),
 	).Scan(&entry.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrLedgerEntryExists
+	}
+	return err
 }
 
 func (s *postgresStore) ListBillingLedgerByAccount(ctx context.Context, accountUUID string, limit int) ([]BillingLedgerEntry, error) {
 	query := `
-		SELECT id, account_uuid, bucket_start, bucket_end, entry_type, rated_bytes, amount_delta, balance_after, pricing_rule_version, created_at
+		SELECT id, account_uuid, bucket_start, bucket_end, entry_type, rated_bytes, amount_delta, balance_after, pricing_rule_version, source_key, created_at
 		FROM billing_ledger
 		WHERE account_uuid = $1
 		ORDER BY created_at DESC`
@@ -283,6 +551,7 @@ func (s *postgresStore) ListBillingLedgerByAccount(ctx context.Context, accountU
 			&entry.AmountDelta,
 			&entry.BalanceAfter,
 			&entry.PricingRuleVersion,
+			&entry.SourceKey,
 			&entry.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -293,6 +562,10 @@ func (s *postgresStore) ListBillingLedgerByAccount(ctx context.Context, accountU
 }
 
 func (s *postgresStore) UpsertAccountQuotaState(ctx context.Context, state *AccountQuotaState) error {
+	return upsertAccountQuotaState(ctx, s.db, state)
+}
+
+func upsertAccountQuotaState(ctx context.Context, q queryRower, state *AccountQuotaState) error {
 	if state == nil {
 		return errors.New("quota state is required")
 	}
@@ -312,7 +585,7 @@ func (s *postgresStore) UpsertAccountQuotaState(ctx context.Context, state *Acco
 			updated_at = now()
 		RETURNING updated_at`
 
-	return s.db.QueryRowContext(
+	return q.QueryRowContext(
 		ctx,
 		query,
 		strings.TrimSpace(state.AccountUUID),
@@ -326,6 +599,85 @@ func (s *postgresStore) UpsertAccountQuotaState(ctx context.Context, state *Acco
 	).Scan(&state.UpdatedAt)
 }
 
+// UpdateAccountQuotaState locks the quota state of accountUUID while
+// update evaluates it and stores the result when update reports a change.
+func (s *postgresStore) UpdateAccountQuotaState(ctx context.Context, accountUUID string, update func(state *AccountQuotaState) bool) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	state, err := lockAccountQuotaState(ctx, tx, accountUUID, nil)
+	if err != nil {
+		return false, err
+	}
+	if !update(state) {
+		return false, nil
+	}
+	if err := upsertAccountQuotaState(ctx, tx, state); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// lockAccountQuotaState reads the quota state of accountUUID with a row lock.
+// When initial is set it is inserted first for accounts without a state, so
+// concurrent callers serialize on the new row too.
+func lockAccountQuotaState(ctx context.Context, tx *sql.Tx, accountUUID string, initial *AccountQuotaState) (*AccountQuotaState, error) {
+	accountUUID = strings.TrimSpace(accountUUID)
+	if initial != nil {
+		const insert = `
+			INSERT INTO account_quota_states (
+				account_uuid, remaining_included_quota, current_balance, arrears, throttle_state, suspend_state, last_rated_bucket_at, effective_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (account_uuid) DO NOTHING`
+		effectiveAt := initial.EffectiveAt
+		if effectiveAt.IsZero() {
+			effectiveAt = time.Now()
+		}
+		if _, err := tx.ExecContext(
+			ctx,
+			insert,
+			accountUUID,
+			initial.RemainingIncludedQuota,
+			initial.CurrentBalance,
+			initial.Arrears,
+			strings.TrimSpace(initial.ThrottleState),
+			strings.TrimSpace(initial.SuspendState),
+			initial.LastRatedBucketAt,
+			effectiveAt.UTC(),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	const query = `
+		SELECT account_uuid, remaining_included_quota, current_balance, arrears, throttle_state, suspend_state, last_rated_bucket_at, effective_at, updated_at
+		FROM account_quota_states
+		WHERE account_uuid = $1
+		FOR UPDATE`
+	var state AccountQuotaState
+	err := tx.QueryRowContext(ctx, query, accountUUID).Scan(
+		&state.AccountUUID,
+		&state.RemainingIncludedQuota,
+		&state.CurrentBalance,
+		&state.Arrears,
+		&state.ThrottleState,
+		&state.SuspendState,
+		&state.LastRatedBucketAt,
+		&state.EffectiveAt,
+		&state.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &state, nil
+}
+
 func (s *postgresStore) GetAccountQuotaState(ctx context.Context, accountUUID string) (*AccountQuotaState, error) {
 	const query = `
 		SELECT account_uuid, remaining_included_quota, current_balance, arrears, throttle_state, suspend_state, last_rated_bucket_at, effective_at, updated_at
@@ -352,6 +704,38 @@ func (s *postgresStore) GetAccountQuotaState(ctx context.Context, accountUUID st
 	return &state, nil
 }
 
+func (s *postgresStore) ListAccountQuotaStates(ctx context.Context) ([]AccountQuotaState, error) {
+	const query = `
+		SELECT account_uuid, remaining_included_quota, current_balance, arrears, throttle_state, suspend_state, last_rated_bucket_at, effective_at, updated_at
+		FROM account_quota_states
+		ORDER BY account_uuid ASC`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AccountQuotaState
+	for rows.Next() {
+		var state AccountQuotaState
+		if err := rows.Scan(
+			&state.AccountUUID,
+			&state.RemainingIncludedQuota,
+			&state.CurrentBalance,
+			&state.Arrears,
+			&state.ThrottleState,
+			&state.SuspendState,
+			&state.LastRatedBucketAt,
+			&state.EffectiveAt,
+			&state.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, state)
+	}
+	return result, rows.Err()
+}
+
 func (s *postgresStore) UpsertAccountBillingProfile(ctx context.Context, profile *AccountBillingProfile) error {
 	if profile == nil {
 		return errors.New("billing profile is required")
@@ -487,6 +871,42 @@ func (s *postgresStore) GetLatestAccountPolicySnapshot(ctx context.Context, acco
 	return &snapshot, nil
 }
 
+func (s *postgresStore) ListAccountPolicySnapshots(ctx context.Context) ([]AccountPolicySnapshot, error) {
+	const query = `
+		SELECT account_uuid, policy_version, auth_state, rate_profile, conn_profile, eligible_node_groups, preferred_strategy, degrade_mode, expires_at, created_at, updated_at
+		FROM account_policy_snapshots
+		ORDER BY account_uuid ASC`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AccountPolicySnapshot
+	for rows.Next() {
+		var snapshot AccountPolicySnapshot
+		var groups []byte
+		if err := rows.Scan(
+			&snapshot.AccountUUID,
+			&snapshot.PolicyVersion,
+			&snapshot.AuthState,
+			&snapshot.RateProfile,
+			&snapshot.ConnProfile,
+			&groups,
+			&snapshot.PreferredStrategy,
+			&snapshot.DegradeMode,
+			&snapshot.ExpiresAt,
+			&snapshot.CreatedAt,
+			&snapshot.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		snapshot.EligibleNodeGroups = decodeStringSlice(groups)
+		result = append(result, snapshot)
+	}
+	return result, rows.Err()
+}
+
 func (s *postgresStore) UpsertNodeHealthSnapshot(ctx context.Context, snapshot *NodeHealthSnapshot) error {
 	if snapshot == nil {
 		return errors.New("node health snapshot is required")
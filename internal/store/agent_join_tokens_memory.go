@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func cloneAgentJoinToken(token *AgentJoinToken) *AgentJoinToken {
+	if token == nil {
+		return nil
+	}
+	clone := *token
+	clone.Groups = cloneStringSlice(token.Groups)
+	if token.UsedAt != nil {
+		usedAt := *token.UsedAt
+		clone.UsedAt = &usedAt
+	}
+	return &clone
+}
+
+func (s *memoryStore) CreateAgentJoinToken(ctx context.Context, token *AgentJoinToken) error {
+	_ = ctx
+	if token == nil {
+		return ErrAgentJoinTokenNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneAgentJoinToken(token)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.AgentID = strings.TrimSpace(stored.AgentID)
+	stored.CreatedAt = time.Now().UTC()
+	stored.ExpiresAt = stored.ExpiresAt.UTC()
+	stored.UsedAt = nil
+	s.agentJoinTokens[stored.ID] = stored
+	*token = *cloneAgentJoinToken(stored)
+	return nil
+}
+
+// ListAgentJoinTokens returns every join token, used or not, newest first.
+func (s *memoryStore) ListAgentJoinTokens(ctx context.Context) ([]AgentJoinToken, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]AgentJoinToken, 0, len(s.agentJoinTokens))
+	for _, token := range s.agentJoinTokens {
+		tokens = append(tokens, *cloneAgentJoinToken(token))
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (s *memoryStore) DeleteAgentJoinToken(ctx context.Context, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id = strings.TrimSpace(id)
+	if _, ok := s.agentJoinTokens[id]; !ok {
+		return ErrAgentJoinTokenNotFound
+	}
+	delete(s.agentJoinTokens, id)
+	return nil
+}
+
+// ConsumeAgentJoinToken marks the unused, unexpired token with tokenHash as
+// used and returns it.
+func (s *memoryStore) ConsumeAgentJoinToken(ctx context.Context, tokenHash string, usedAt time.Time) (*AgentJoinToken, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.agentJoinTokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		if token.UsedAt != nil || !usedAt.Before(token.ExpiresAt) {
+			return nil, ErrAgentJoinTokenNotFound
+		}
+		at := usedAt.UTC()
+		token.UsedAt = &at
+		return cloneAgentJoinToken(token), nil
+	}
+	return nil, ErrAgentJoinTokenNotFound
+}
+
+// RevokeAgentCertificates rejects every certificate issued to agentID before
+// before. The record outlives the agent so that re-creating an agent with
+// the same ID does not revive its old certificates.
+func (s *memoryStore) RevokeAgentCertificates(ctx context.Context, agentID string, before time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agentID = strings.TrimSpace(agentID)
+	before = before.UTC()
+	if current, ok := s.agentCertRevocations[agentID]; !ok || before.After(current) {
+		s.agentCertRevocations[agentID] = before
+	}
+	return nil
+}
+
+// AgentCertificatesRevokedBefore returns the revocation cut-off for agentID,
+// or the zero time when none of its certificates are revoked.
+func (s *memoryStore) AgentCertificatesRevokedBefore(ctx context.Context, agentID string) (time.Time, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.agentCertRevocations[strings.TrimSpace(agentID)], nil
+}
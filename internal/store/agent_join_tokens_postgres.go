@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const agentJoinTokenColumns = "id, agent_id, groups, token_hash, created_by, created_at, expires_at, used_at"
+
+func scanAgentJoinToken(row interface{ Scan(...any) error }) (*AgentJoinToken, error) {
+	var (
+		token  AgentJoinToken
+		groups []byte
+		usedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&token.ID,
+		&token.AgentID,
+		&groups,
+		&token.TokenHash,
+		&token.CreatedBy,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&usedAt,
+	); err != nil {
+		return nil, err
+	}
+	token.Groups = decodeStringSlice(groups)
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if usedAt.Valid {
+		at := usedAt.Time.UTC()
+		token.UsedAt = &at
+	}
+	return &token, nil
+}
+
+func (s *postgresStore) CreateAgentJoinToken(ctx context.Context, token *AgentJoinToken) error {
+	if token == nil {
+		return ErrAgentJoinTokenNotFound
+	}
+	if token.ID == "" {
+		token.ID = uuid.NewString()
+	}
+	groups, err := encodeStringSlice(token.Groups)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO agent_join_tokens (id, agent_id, groups, token_hash, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + agentJoinTokenColumns
+	created, err := scanAgentJoinToken(s.db.QueryRowContext(ctx, query,
+		token.ID,
+		strings.TrimSpace(token.AgentID),
+		groups,
+		token.TokenHash,
+		token.CreatedBy,
+		token.ExpiresAt.UTC(),
+	))
+	if err != nil {
+		return err
+	}
+	*token = *created
+	return nil
+}
+
+// ListAgentJoinTokens returns every join token, used or not, newest first.
+func (s *postgresStore) ListAgentJoinTokens(ctx context.Context) ([]AgentJoinToken, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+agentJoinTokenColumns+" FROM agent_join_tokens ORDER BY created_at DESC, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]AgentJoinToken, 0)
+	for rows.Next() {
+		token, err := scanAgentJoinToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *postgresStore) DeleteAgentJoinToken(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrAgentJoinTokenNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM agent_join_tokens WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAgentJoinTokenNotFound
+	}
+	return nil
+}
+
+// ConsumeAgentJoinToken marks the unused, unexpired token with tokenHash as
+// used. The update is conditional on used_at being NULL so two agents cannot
+// enroll with the same token.
+func (s *postgresStore) ConsumeAgentJoinToken(ctx context.Context, tokenHash string, usedAt time.Time) (*AgentJoinToken, error) {
+	query := `
+		UPDATE agent_join_tokens
+		SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING ` + agentJoinTokenColumns
+	token, err := scanAgentJoinToken(s.db.QueryRowContext(ctx, query, tokenHash, usedAt.UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAgentJoinTokenNotFound
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// RevokeAgentCertificates rejects every certificate issued to agentID before
+// before. The record outlives the agent so that re-creating an agent with
+// the same ID does not revive its old certificates.
+func (s *postgresStore) RevokeAgentCertificates(ctx context.Context, agentID string, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO agent_certificate_revocations (agent_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (agent_id) DO UPDATE
+		SET revoked_before = GREATEST(agent_certificate_revocations.revoked_before, EXCLUDED.revoked_before),
+			updated_at = now()`,
+		strings.TrimSpace(agentID), before.UTC())
+	return err
+}
+
+// AgentCertificatesRevokedBefore returns the revocation cut-off for agentID,
+// or the zero time when none of its certificates are revoked.
+func (s *postgresStore) AgentCertificatesRevokedBefore(ctx context.Context, agentID string) (time.Time, error) {
+	var before time.Time
+	err := s.db.QueryRowContext(ctx,
+		"SELECT revoked_before FROM agent_certificate_revocations WHERE agent_id = $1",
+		strings.TrimSpace(agentID)).Scan(&before)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return before.UTC(), nil
+}
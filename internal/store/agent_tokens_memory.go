@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func cloneAgentToken(token *AgentToken) *AgentToken {
+	if token == nil {
+		return nil
+	}
+	clone := *token
+	if token.ExpiresAt != nil {
+		expiresAt := *token.ExpiresAt
+		clone.ExpiresAt = &expiresAt
+	}
+	return &clone
+}
+
+// CreateAgentToken stores a token for an existing agent.
+func (s *memoryStore) CreateAgentToken(ctx context.Context, token *AgentToken) error {
+	_ = ctx
+	if token == nil {
+		return ErrAgentTokenNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneAgentToken(token)
+	stored.AgentID = strings.TrimSpace(stored.AgentID)
+	if _, ok := s.agents[stored.AgentID]; !ok {
+		return ErrAgentNotFound
+	}
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.CreatedAt = time.Now().UTC()
+	s.agentTokens[stored.ID] = stored
+	*token = *cloneAgentToken(stored)
+	return nil
+}
+
+// ListAgentTokens returns the tokens of agentID, or of every agent when
+// agentID is empty, oldest first. Expired tokens are included.
+func (s *memoryStore) ListAgentTokens(ctx context.Context, agentID string) ([]AgentToken, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agentID = strings.TrimSpace(agentID)
+	tokens := make([]AgentToken, 0)
+	for _, token := range s.agentTokens {
+		if agentID == "" || token.AgentID == agentID {
+			tokens = append(tokens, *cloneAgentToken(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// ExpireAgentTokens makes every token of agentID that would outlive at expire
+// at at.
+func (s *memoryStore) ExpireAgentTokens(ctx context.Context, agentID string, at time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agentID = strings.TrimSpace(agentID)
+	at = at.UTC()
+	for _, token := range s.agentTokens {
+		if token.AgentID != agentID {
+			continue
+		}
+		if token.ExpiresAt == nil || token.ExpiresAt.After(at) {
+			expiresAt := at
+			token.ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteAgentToken(ctx context.Context, agentID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.agentTokens[strings.TrimSpace(id)]
+	if !ok || token.AgentID != strings.TrimSpace(agentID) {
+		return ErrAgentTokenNotFound
+	}
+	delete(s.agentTokens, token.ID)
+	return nil
+}
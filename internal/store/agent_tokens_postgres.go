@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const agentTokenColumns = "id, agent_id, token_hash, created_by, created_at, expires_at"
+
+func scanAgentToken(row interface{ Scan(...any) error }) (*AgentToken, error) {
+	var (
+		token     AgentToken
+		expiresAt sql.NullTime
+	)
+	if err := row.Scan(
+		&token.ID,
+		&token.AgentID,
+		&token.TokenHash,
+		&token.CreatedBy,
+		&token.CreatedAt,
+		&expiresAt,
+	); err != nil {
+		return nil, err
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	if expiresAt.Valid {
+		at := expiresAt.Time.UTC()
+		token.ExpiresAt = &at
+	}
+	return &token, nil
+}
+
+// CreateAgentToken stores a token for an existing agent.
+func (s *postgresStore) CreateAgentToken(ctx context.Context, token *AgentToken) error {
+	if token == nil {
+		return ErrAgentTokenNotFound
+	}
+	if token.ID == "" {
+		token.ID = uuid.NewString()
+	}
+	query := `
+		INSERT INTO agent_tokens (id, agent_id, token_hash, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + agentTokenColumns
+	created, err := scanAgentToken(s.db.QueryRowContext(ctx, query,
+		token.ID,
+		strings.TrimSpace(token.AgentID),
+		token.TokenHash,
+		token.CreatedBy,
+		token.ExpiresAt,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrAgentNotFound
+		}
+		return err
+	}
+	*token = *created
+	return nil
+}
+
+// ListAgentTokens returns the tokens of agentID, or of every agent when
+// agentID is empty, oldest first. Expired tokens are included.
+func (s *postgresStore) ListAgentTokens(ctx context.Context, agentID string) ([]AgentToken, error) {
+	query := "SELECT " + agentTokenColumns + " FROM agent_tokens WHERE ($1 = '' OR agent_id = $1) ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(agentID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]AgentToken, 0)
+	for rows.Next() {
+		token, err := scanAgentToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// ExpireAgentTokens makes every token of agentID that would outlive at expire
+// at at.
+func (s *postgresStore) ExpireAgentTokens(ctx context.Context, agentID string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE agent_tokens
+		SET expires_at = $2
+		WHERE agent_id = $1 AND (expires_at IS NULL OR expires_at > $2)`,
+		strings.TrimSpace(agentID), at.UTC())
+	return err
+}
+
+func (s *postgresStore) DeleteAgentToken(ctx context.Context, agentID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrAgentTokenNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM agent_tokens WHERE id = $1 AND agent_id = $2", id, strings.TrimSpace(agentID))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAgentTokenNotFound
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ListServiceAccounts returns the service accounts of a tenant, or every
+// service account when tenantID is empty, ordered by name.
+func (s *memoryStore) ListServiceAccounts(ctx context.Context, tenantID string) ([]ServiceAccount, error) {
+	_ = ctx
+	tenantID = strings.TrimSpace(tenantID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]ServiceAccount, 0)
+	for _, account := range s.serviceAccounts {
+		if tenantID == "" || account.TenantID == tenantID {
+			accounts = append(accounts, *cloneServiceAccount(account))
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Name != accounts[j].Name {
+			return accounts[i].Name < accounts[j].Name
+		}
+		return accounts[i].ID < accounts[j].ID
+	})
+	return accounts, nil
+}
+
+func (s *memoryStore) GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.serviceAccounts[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrServiceAccountNotFound
+	}
+	return cloneServiceAccount(account), nil
+}
+
+func (s *memoryStore) CreateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	_ = ctx
+	if account == nil {
+		return ErrServiceAccountNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneServiceAccount(account)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.Name = strings.TrimSpace(stored.Name)
+	stored.Description = strings.TrimSpace(stored.Description)
+	stored.TenantID = strings.TrimSpace(stored.TenantID)
+	stored.Permissions = normalizeRolePermissions(stored.Permissions)
+	if s.serviceAccountNameTakenLocked(stored.TenantID, stored.Name, "") {
+		return ErrServiceAccountExists
+	}
+	now := time.Now().UTC()
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.serviceAccounts[stored.ID] = stored
+	*account = *cloneServiceAccount(stored)
+	return nil
+}
+
+// UpdateServiceAccount replaces the name, description, permissions and
+// disabled flag of a service account. The tenant cannot change.
+func (s *memoryStore) UpdateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	_ = ctx
+	if account == nil {
+		return ErrServiceAccountNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.serviceAccounts[strings.TrimSpace(account.ID)]
+	if !ok {
+		return ErrServiceAccountNotFound
+	}
+	name := strings.TrimSpace(account.Name)
+	if s.serviceAccountNameTakenLocked(stored.TenantID, name, stored.ID) {
+		return ErrServiceAccountExists
+	}
+	stored.Name = name
+	stored.Description = strings.TrimSpace(account.Description)
+	stored.Permissions = normalizeRolePermissions(account.Permissions)
+	stored.Disabled = account.Disabled
+	stored.UpdatedAt = time.Now().UTC()
+	*account = *cloneServiceAccount(stored)
+	return nil
+}
+
+// DeleteServiceAccount removes a service account and its tokens.
+func (s *memoryStore) DeleteServiceAccount(ctx context.Context, id string) error {
+	_ = ctx
+	id = strings.TrimSpace(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.serviceAccounts[id]; !ok {
+		return ErrServiceAccountNotFound
+	}
+	delete(s.serviceAccounts, id)
+	for key, token := range s.apiTokens {
+		if token.ServiceAccountID == id {
+			delete(s.apiTokens, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) serviceAccountNameTakenLocked(tenantID, name, exceptID string) bool {
+	for _, account := range s.serviceAccounts {
+		if account.ID != exceptID && account.TenantID == tenantID && strings.EqualFold(account.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	_ = ctx
+	if token == nil {
+		return ErrAPITokenNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneAPIToken(token)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.Name = strings.TrimSpace(stored.Name)
+	stored.UserID = strings.TrimSpace(stored.UserID)
+	stored.ServiceAccountID = strings.TrimSpace(stored.ServiceAccountID)
+	stored.TenantID = strings.TrimSpace(stored.TenantID)
+	stored.Scopes = normalizeRolePermissions(stored.Scopes)
+	if stored.UserID != "" {
+		if _, ok := s.byID[stored.UserID]; !ok {
+			return ErrUserNotFound
+		}
+	} else if _, ok := s.serviceAccounts[stored.ServiceAccountID]; !ok {
+		return ErrServiceAccountNotFound
+	}
+	stored.CreatedAt = time.Now().UTC()
+	stored.ExpiresAt = stored.ExpiresAt.UTC()
+	stored.LastUsedAt = nil
+	s.apiTokens[stored.ID] = stored
+	*token = *cloneAPIToken(stored)
+	return nil
+}
+
+func (s *memoryStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.apiTokens {
+		if token.TokenHash == tokenHash {
+			return cloneAPIToken(token), nil
+		}
+	}
+	return nil, ErrAPITokenNotFound
+}
+
+// ListAPITokens returns the tokens of owner, oldest first.
+func (s *memoryStore) ListAPITokens(ctx context.Context, owner APITokenOwner) ([]APIToken, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]APIToken, 0)
+	for _, token := range s.apiTokens {
+		if token.Owner() == normalizeAPITokenOwner(owner) {
+			tokens = append(tokens, *cloneAPIToken(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (s *memoryStore) DeleteAPIToken(ctx context.Context, owner APITokenOwner, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.apiTokens[strings.TrimSpace(id)]
+	if !ok || token.Owner() != normalizeAPITokenOwner(owner) {
+		return ErrAPITokenNotFound
+	}
+	delete(s.apiTokens, token.ID)
+	return nil
+}
+
+func (s *memoryStore) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.apiTokens[strings.TrimSpace(id)]
+	if !ok {
+		return ErrAPITokenNotFound
+	}
+	at := usedAt.UTC()
+	token.LastUsedAt = &at
+	return nil
+}
+
+func normalizeAPITokenOwner(owner APITokenOwner) APITokenOwner {
+	return APITokenOwner{
+		UserID:           strings.TrimSpace(owner.UserID),
+		ServiceAccountID: strings.TrimSpace(owner.ServiceAccountID),
+	}
+}
+
+func cloneServiceAccount(account *ServiceAccount) *ServiceAccount {
+	cloned := *account
+	cloned.Permissions = append([]string(nil), account.Permissions...)
+	return &cloned
+}
+
+func cloneAPIToken(token *APIToken) *APIToken {
+	cloned := *token
+	cloned.Scopes = append([]string(nil), token.Scopes...)
+	cloned.LastUsedAt = cloneTimePtr(token.LastUsedAt)
+	return &cloned
+}
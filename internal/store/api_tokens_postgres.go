@@ -0,0 +1,335 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	serviceAccountColumns = "id, name, description, tenant_id, permissions, disabled, created_by, created_at, updated_at"
+	apiTokenColumns       = "id, name, user_uuid, service_account_id, tenant_id, scopes, token_hash, created_by, created_at, expires_at, last_used_at"
+)
+
+func scanServiceAccount(row interface{ Scan(...any) error }) (*ServiceAccount, error) {
+	var (
+		account     ServiceAccount
+		permissions []byte
+	)
+	if err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&account.Description,
+		&account.TenantID,
+		&permissions,
+		&account.Disabled,
+		&account.CreatedBy,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	account.Permissions = decodeStringSlice(permissions)
+	account.CreatedAt = account.CreatedAt.UTC()
+	account.UpdatedAt = account.UpdatedAt.UTC()
+	return &account, nil
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (*APIToken, error) {
+	var (
+		token            APIToken
+		userID           sql.NullString
+		serviceAccountID sql.NullString
+		scopes           []byte
+		lastUsedAt       sql.NullTime
+	)
+	if err := row.Scan(
+		&token.ID,
+		&token.Name,
+		&userID,
+		&serviceAccountID,
+		&token.TenantID,
+		&scopes,
+		&token.TokenHash,
+		&token.CreatedBy,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&lastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+	token.UserID = userID.String
+	token.ServiceAccountID = serviceAccountID.String
+	token.Scopes = decodeStringSlice(scopes)
+	if lastUsedAt.Valid {
+		at := lastUsedAt.Time.UTC()
+		token.LastUsedAt = &at
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	return &token, nil
+}
+
+// ListServiceAccounts returns the service accounts of a tenant, or every
+// service account when tenantID is empty, ordered by name.
+func (s *postgresStore) ListServiceAccounts(ctx context.Context, tenantID string) ([]ServiceAccount, error) {
+	query := "SELECT " + serviceAccountColumns + " FROM service_accounts WHERE ($1 = '' OR tenant_id = $1) ORDER BY name, id"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]ServiceAccount, 0)
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts, rows.Err()
+}
+
+func (s *postgresStore) GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return nil, ErrServiceAccountNotFound
+	}
+	query := "SELECT " + serviceAccountColumns + " FROM service_accounts WHERE id = $1"
+	account, err := scanServiceAccount(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServiceAccountNotFound
+	}
+	return account, err
+}
+
+func (s *postgresStore) CreateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	if account == nil {
+		return ErrServiceAccountNotFound
+	}
+	if account.ID == "" {
+		account.ID = uuid.NewString()
+	}
+	account.Name = strings.TrimSpace(account.Name)
+	account.Description = strings.TrimSpace(account.Description)
+	account.TenantID = strings.TrimSpace(account.TenantID)
+	account.Permissions = normalizeRolePermissions(account.Permissions)
+	permissions, err := encodeStringSlice(account.Permissions)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO service_accounts (id, name, description, tenant_id, permissions, disabled, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now(), now())
+		RETURNING created_at, updated_at`
+	err = s.db.QueryRowContext(ctx, query,
+		account.ID,
+		account.Name,
+		account.Description,
+		account.TenantID,
+		permissions,
+		account.Disabled,
+		account.CreatedBy,
+	).Scan(&account.CreatedAt, &account.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrServiceAccountExists
+	}
+	if err != nil {
+		return err
+	}
+	account.CreatedAt = account.CreatedAt.UTC()
+	account.UpdatedAt = account.UpdatedAt.UTC()
+	return nil
+}
+
+// UpdateServiceAccount replaces the name, description, permissions and
+// disabled flag of a service account. The tenant cannot change.
+func (s *postgresStore) UpdateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	if account == nil {
+		return ErrServiceAccountNotFound
+	}
+	if _, err := uuid.Parse(strings.TrimSpace(account.ID)); err != nil {
+		return ErrServiceAccountNotFound
+	}
+	account.Name = strings.TrimSpace(account.Name)
+	account.Description = strings.TrimSpace(account.Description)
+	account.Permissions = normalizeRolePermissions(account.Permissions)
+	permissions, err := encodeStringSlice(account.Permissions)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		UPDATE service_accounts
+		SET name = $2, description = $3, permissions = $4, disabled = $5, updated_at = now()
+		WHERE id = $1
+		RETURNING ` + serviceAccountColumns
+	updated, err := scanServiceAccount(s.db.QueryRowContext(ctx, query,
+		strings.TrimSpace(account.ID),
+		account.Name,
+		account.Description,
+		permissions,
+		account.Disabled,
+	))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrServiceAccountNotFound
+	case isUniqueViolation(err):
+		return ErrServiceAccountExists
+	case err != nil:
+		return err
+	}
+	*account = *updated
+	return nil
+}
+
+// DeleteServiceAccount removes a service account; its tokens go with it
+// through ON DELETE CASCADE.
+func (s *postgresStore) DeleteServiceAccount(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrServiceAccountNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM service_accounts WHERE id = $1", strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	if token == nil {
+		return ErrAPITokenNotFound
+	}
+	if token.ID == "" {
+		token.ID = uuid.NewString()
+	}
+	token.Name = strings.TrimSpace(token.Name)
+	token.UserID = strings.TrimSpace(token.UserID)
+	token.ServiceAccountID = strings.TrimSpace(token.ServiceAccountID)
+	token.TenantID = strings.TrimSpace(token.TenantID)
+	token.Scopes = normalizeRolePermissions(token.Scopes)
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.LastUsedAt = nil
+	missingOwner := ErrServiceAccountNotFound
+	ownerID := token.ServiceAccountID
+	if token.UserID != "" {
+		missingOwner = ErrUserNotFound
+		ownerID = token.UserID
+	}
+	if _, err := uuid.Parse(ownerID); err != nil {
+		return missingOwner
+	}
+	scopes, err := encodeStringSlice(token.Scopes)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO api_tokens (id, name, user_uuid, service_account_id, tenant_id, scopes, token_hash, created_by, created_at, expires_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5, $6, $7, $8, now(), $9)
+		RETURNING created_at`
+	err = s.db.QueryRowContext(ctx, query,
+		token.ID,
+		token.Name,
+		token.UserID,
+		token.ServiceAccountID,
+		token.TenantID,
+		scopes,
+		token.TokenHash,
+		token.CreatedBy,
+		token.ExpiresAt,
+	).Scan(&token.CreatedAt)
+	if isForeignKeyViolation(err) {
+		return missingOwner
+	}
+	if err != nil {
+		return err
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens WHERE token_hash = $1"
+	token, err := scanAPIToken(s.db.QueryRowContext(ctx, query, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPITokenNotFound
+	}
+	return token, err
+}
+
+// ListAPITokens returns the tokens of owner, oldest first.
+func (s *postgresStore) ListAPITokens(ctx context.Context, owner APITokenOwner) ([]APIToken, error) {
+	column, ownerID := apiTokenOwnerColumn(owner)
+	if _, err := uuid.Parse(ownerID); err != nil {
+		return []APIToken{}, nil
+	}
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens WHERE " + column + " = $1 ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *postgresStore) DeleteAPIToken(ctx context.Context, owner APITokenOwner, id string) error {
+	column, ownerID := apiTokenOwnerColumn(owner)
+	if _, err := uuid.Parse(ownerID); err != nil {
+		return ErrAPITokenNotFound
+	}
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrAPITokenNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = $1 AND "+column+" = $2",
+		strings.TrimSpace(id), ownerID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrAPITokenNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $2 WHERE id = $1",
+		strings.TrimSpace(id), usedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// apiTokenOwnerColumn returns the column and value that select the tokens of
+// owner.
+func apiTokenOwnerColumn(owner APITokenOwner) (string, string) {
+	owner = normalizeAPITokenOwner(owner)
+	if owner.UserID != "" {
+		return "user_uuid", owner.UserID
+	}
+	return "service_account_id", owner.ServiceAccountID
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (s *memoryStore) AppendAuditEvent(ctx context.Context, event *AuditEvent) error {
+	_ = ctx
+	if event == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneAuditEvent(event)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now()
+	}
+	stored.CreatedAt = stored.CreatedAt.UTC()
+	s.auditEvents = append(s.auditEvents, stored)
+	*event = *cloneAuditEvent(stored)
+	return nil
+}
+
+// ListAuditEvents returns matching events newest first. Events are appended
+// in time order, so walking the slice backwards yields that order.
+func (s *memoryStore) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := len(s.auditEvents) - 1
+	if after := strings.TrimSpace(filter.After); after != "" {
+		index := slices.IndexFunc(s.auditEvents, func(event *AuditEvent) bool { return event.ID == after })
+		if index < 0 {
+			return nil, ErrAuditCursorNotFound
+		}
+		start = index - 1
+	}
+
+	events := make([]AuditEvent, 0)
+	for i := start; i >= 0; i-- {
+		event := s.auditEvents[i]
+		if !filter.matches(event) {
+			continue
+		}
+		events = append(events, *cloneAuditEvent(event))
+		if filter.Limit > 0 && len(events) >= filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (f AuditEventFilter) matches(event *AuditEvent) bool {
+	switch {
+	case f.TenantID != "" && event.TenantID != f.TenantID,
+		f.ActorID != "" && event.ActorID != f.ActorID,
+		f.TargetType != "" && event.TargetType != f.TargetType,
+		f.TargetID != "" && event.TargetID != f.TargetID,
+		f.ActionPrefix != "" && !strings.HasPrefix(event.Action, f.ActionPrefix),
+		f.Outcome != "" && event.Outcome != f.Outcome,
+		!f.Since.IsZero() && event.CreatedAt.Before(f.Since),
+		!f.Until.IsZero() && !event.CreatedAt.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+func cloneAuditEvent(event *AuditEvent) *AuditEvent {
+	cloned := *event
+	cloned.Before = maps.Clone(event.Before)
+	cloned.After = maps.Clone(event.After)
+	cloned.Metadata = maps.Clone(event.Metadata)
+	return &cloned
+}
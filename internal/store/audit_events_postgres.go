@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const auditEventColumns = "id, tenant_id, actor_id, actor_email, action, outcome, target_type, target_id, ip_address, user_agent, before, after, metadata, created_at"
+
+func scanAuditEvent(row interface{ Scan(...any) error }) (*AuditEvent, error) {
+	var (
+		event                   AuditEvent
+		before, after, metadata []byte
+	)
+	if err := row.Scan(&event.ID, &event.TenantID, &event.ActorID, &event.ActorEmail, &event.Action, &event.Outcome,
+		&event.TargetType, &event.TargetID, &event.IPAddress, &event.UserAgent, &before, &after, &metadata, &event.CreatedAt); err != nil {
+		return nil, err
+	}
+	for _, field := range []struct {
+		raw  []byte
+		into *map[string]any
+	}{{before, &event.Before}, {after, &event.After}, {metadata, &event.Metadata}} {
+		if len(field.raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(field.raw, field.into); err != nil {
+			return nil, err
+		}
+	}
+	event.CreatedAt = event.CreatedAt.UTC()
+	return &event, nil
+}
+
+// encodeAuditFields encodes an optional JSON column, keeping empty maps NULL.
+func encodeAuditFields(fields map[string]any) (any, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+func (s *postgresStore) AppendAuditEvent(ctx context.Context, event *AuditEvent) error {
+	if event == nil {
+		return nil
+	}
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	encoded := make([]any, 0, 3)
+	for _, fields := range []map[string]any{event.Before, event.After, event.Metadata} {
+		value, err := encodeAuditFields(fields)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, value)
+	}
+
+	const query = `
+		INSERT INTO audit_events (id, tenant_id, actor_id, actor_email, action, outcome, target_type, target_id, ip_address, user_agent, before, after, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11::jsonb, $12::jsonb, $13::jsonb, $14)`
+	if _, err := s.db.ExecContext(ctx, query, event.ID, event.TenantID, event.ActorID, event.ActorEmail, event.Action, event.Outcome,
+		event.TargetType, event.TargetID, event.IPAddress, event.UserAgent, encoded[0], encoded[1], encoded[2], event.CreatedAt.UTC()); err != nil {
+		return err
+	}
+	event.CreatedAt = event.CreatedAt.UTC()
+	return nil
+}
+
+// ListAuditEvents returns matching events newest first, paging by keyset on
+// (created_at, id) so pages stay stable while new events are appended.
+func (s *postgresStore) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.TenantID != "" {
+		add("tenant_id = ?", filter.TenantID)
+	}
+	if filter.ActorID != "" {
+		add("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		add("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = ?", filter.TargetID)
+	}
+	if filter.ActionPrefix != "" {
+		add("starts_with(action, ?)", filter.ActionPrefix)
+	}
+	if filter.Outcome != "" {
+		add("outcome = ?", filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < ?", filter.Until.UTC())
+	}
+	if after := strings.TrimSpace(filter.After); after != "" {
+		if _, err := uuid.Parse(after); err != nil {
+			return nil, ErrAuditCursorNotFound
+		}
+		var cursorAt time.Time
+		err := s.db.QueryRowContext(ctx, "SELECT created_at FROM audit_events WHERE id = $1", after).Scan(&cursorAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAuditCursorNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, cursorAt, after)
+		conditions = append(conditions, "(created_at, id) < ($"+strconv.Itoa(len(args)-1)+", $"+strconv.Itoa(len(args))+")")
+	}
+
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]AuditEvent, 0)
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+func (s *memoryStore) GetIdentity(ctx context.Context, provider, externalID string) (*Identity, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.identities[strings.TrimSpace(provider)+":"+strings.TrimSpace(externalID)]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+	found := *identity
+	return &found, nil
+}
+
+// ListIdentitiesByUser returns the user's identities, oldest first.
+func (s *memoryStore) ListIdentitiesByUser(ctx context.Context, userID string) ([]Identity, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := make([]Identity, 0)
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, *identity)
+		}
+	}
+	sortIdentities(identities)
+	return identities, nil
+}
+
+func (s *memoryStore) DeleteIdentity(ctx context.Context, userID, identityID string) (*Identity, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, identity := range s.identities {
+		if identity.ID == identityID && identity.UserID == userID {
+			delete(s.identities, key)
+			removed := *identity
+			return &removed, nil
+		}
+	}
+	return nil, ErrIdentityNotFound
+}
+
+func sortIdentities(identities []Identity) {
+	sort.Slice(identities, func(i, j int) bool {
+		if identities[i].CreatedAt.Equal(identities[j].CreatedAt) {
+			return identities[i].ID < identities[j].ID
+		}
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const identityColumns = "uuid, user_uuid, provider, external_id, created_at, updated_at"
+
+func (s *postgresStore) GetIdentity(ctx context.Context, provider, externalID string) (*Identity, error) {
+	query := "SELECT " + identityColumns + " FROM identities WHERE provider = $1 AND external_id = $2"
+	identity, err := scanIdentity(s.db.QueryRowContext(ctx, query, strings.TrimSpace(provider), strings.TrimSpace(externalID)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	return identity, err
+}
+
+// ListIdentitiesByUser returns the user's identities, oldest first.
+func (s *postgresStore) ListIdentitiesByUser(ctx context.Context, userID string) ([]Identity, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return []Identity{}, nil
+	}
+	query := "SELECT " + identityColumns + " FROM identities WHERE user_uuid = $1"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]Identity, 0)
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortIdentities(identities)
+	return identities, nil
+}
+
+func (s *postgresStore) DeleteIdentity(ctx context.Context, userID, identityID string) (*Identity, error) {
+	if _, err := uuid.Parse(identityID); err != nil {
+		return nil, ErrIdentityNotFound
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrIdentityNotFound
+	}
+	query := "DELETE FROM identities WHERE uuid = $1 AND user_uuid = $2 RETURNING " + identityColumns
+	identity, err := scanIdentity(s.db.QueryRowContext(ctx, query, identityID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	return identity, err
+}
+
+func scanIdentity(row interface{ Scan(dest ...any) error }) (*Identity, error) {
+	var identity Identity
+	if err := row.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.ExternalID,
+		&identity.CreatedAt, &identity.UpdatedAt); err != nil {
+		return nil, err
+	}
+	identity.CreatedAt = identity.CreatedAt.UTC()
+	identity.UpdatedAt = identity.UpdatedAt.UTC()
+	return &identity, nil
+}
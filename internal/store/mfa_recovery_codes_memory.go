@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReplaceMFARecoveryCodes discards the user's existing recovery codes and
+// stores the given hashes in their place. A nil slice removes all codes.
+func (s *memoryStore) ReplaceMFARecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(codeHashes) == 0 {
+		delete(s.mfaRecoveryCodes, userID)
+		return nil
+	}
+	now := time.Now().UTC()
+	codes := make([]*MFARecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, &MFARecoveryCode{
+			ID:        uuid.NewString(),
+			UserID:    userID,
+			CodeHash:  strings.TrimSpace(hash),
+			CreatedAt: now,
+		})
+	}
+	s.mfaRecoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *memoryStore) ListMFARecoveryCodes(ctx context.Context, userID string) ([]MFARecoveryCode, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	codes := make([]MFARecoveryCode, 0, len(s.mfaRecoveryCodes[userID]))
+	for _, code := range s.mfaRecoveryCodes[userID] {
+		codes = append(codes, *cloneMFARecoveryCode(code))
+	}
+	return codes, nil
+}
+
+// ConsumeMFARecoveryCode marks the matching unused code as used. It returns
+// ErrMFARecoveryCodeNotFound when no unused code matches.
+func (s *memoryStore) ConsumeMFARecoveryCode(ctx context.Context, userID, codeHash, ip, userAgent string, usedAt time.Time) (*MFARecoveryCode, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range s.mfaRecoveryCodes[userID] {
+		if code.UsedAt != nil || code.CodeHash != codeHash {
+			continue
+		}
+		used := usedAt.UTC()
+		code.UsedAt = &used
+		code.UsedIP = ip
+		code.UsedUserAgent = userAgent
+		return cloneMFARecoveryCode(code), nil
+	}
+	return nil, ErrMFARecoveryCodeNotFound
+}
+
+func cloneMFARecoveryCode(code *MFARecoveryCode) *MFARecoveryCode {
+	cloned := *code
+	if code.UsedAt != nil {
+		used := *code.UsedAt
+		cloned.UsedAt = &used
+	}
+	return &cloned
+}
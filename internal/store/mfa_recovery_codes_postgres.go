@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const mfaRecoveryCodeColumns = "id, user_uuid, code_hash, created_at, used_at, used_ip, used_user_agent"
+
+func scanMFARecoveryCode(row interface{ Scan(...any) error }) (*MFARecoveryCode, error) {
+	var (
+		code   MFARecoveryCode
+		usedAt sql.NullTime
+	)
+	if err := row.Scan(&code.ID, &code.UserID, &code.CodeHash, &code.CreatedAt, &usedAt, &code.UsedIP, &code.UsedUserAgent); err != nil {
+		return nil, err
+	}
+	code.CreatedAt = code.CreatedAt.UTC()
+	if usedAt.Valid {
+		used := usedAt.Time.UTC()
+		code.UsedAt = &used
+	}
+	return &code, nil
+}
+
+// ReplaceMFARecoveryCodes discards the user's existing recovery codes and
+// stores the given hashes in their place. A nil slice removes all codes.
+func (s *postgresStore) ReplaceMFARecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrUserNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_uuid = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO mfa_recovery_codes (id, user_uuid, code_hash) VALUES ($1, $2, $3)",
+			uuid.NewString(), userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) ListMFARecoveryCodes(ctx context.Context, userID string) ([]MFARecoveryCode, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return []MFARecoveryCode{}, nil
+	}
+	query := "SELECT " + mfaRecoveryCodeColumns + " FROM mfa_recovery_codes WHERE user_uuid = $1 ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make([]MFARecoveryCode, 0)
+	for rows.Next() {
+		code, err := scanMFARecoveryCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, *code)
+	}
+	return codes, rows.Err()
+}
+
+// ConsumeMFARecoveryCode marks the matching unused code as used. The update
+// is conditional on used_at being NULL so concurrent sign-ins cannot spend
+// the same code twice.
+func (s *postgresStore) ConsumeMFARecoveryCode(ctx context.Context, userID, codeHash, ip, userAgent string, usedAt time.Time) (*MFARecoveryCode, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrMFARecoveryCodeNotFound
+	}
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = $3, used_ip = $4, used_user_agent = $5
+		WHERE id = (
+			SELECT id FROM mfa_recovery_codes
+			WHERE user_uuid = $1 AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+		) AND used_at IS NULL
+		RETURNING ` + mfaRecoveryCodeColumns
+	code, err := scanMFARecoveryCode(s.db.QueryRowContext(ctx, query, userID, codeHash, usedAt.UTC(), ip, userAgent))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFARecoveryCodeNotFound
+		}
+		return nil, err
+	}
+	return code, nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *memoryStore) CreateOIDCClient(ctx context.Context, client *OIDCClient) error {
+	_ = ctx
+	if client == nil || strings.TrimSpace(client.ID) == "" {
+		return errors.New("oidc client id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.oidcClients[client.ID]; exists {
+		return ErrOIDCClientExists
+	}
+	now := time.Now().UTC()
+	stored := cloneOIDCClient(client)
+	stored.RedirectURIs = normalizeStringSlice(stored.RedirectURIs)
+	stored.Scopes = normalizeStringSlice(stored.Scopes)
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.oidcClients[stored.ID] = stored
+	*client = *cloneOIDCClient(stored)
+	return nil
+}
+
+func (s *memoryStore) GetOIDCClient(ctx context.Context, id string) (*OIDCClient, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.oidcClients[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrOIDCClientNotFound
+	}
+	return cloneOIDCClient(client), nil
+}
+
+// ListOIDCClients returns every registered client ordered by ID.
+func (s *memoryStore) ListOIDCClients(ctx context.Context) ([]OIDCClient, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := make([]OIDCClient, 0, len(s.oidcClients))
+	for _, client := range s.oidcClients {
+		clients = append(clients, *cloneOIDCClient(client))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+func (s *memoryStore) DeleteOIDCClient(ctx context.Context, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id = strings.TrimSpace(id)
+	if _, ok := s.oidcClients[id]; !ok {
+		return ErrOIDCClientNotFound
+	}
+	delete(s.oidcClients, id)
+	return nil
+}
+
+func cloneOIDCClient(client *OIDCClient) *OIDCClient {
+	cloned := *client
+	cloned.RedirectURIs = cloneStringSlice(client.RedirectURIs)
+	cloned.Scopes = cloneStringSlice(client.Scopes)
+	return &cloned
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const oidcClientColumns = "client_id, name, secret_hash, redirect_uris, scopes, created_at, updated_at"
+
+func (s *postgresStore) CreateOIDCClient(ctx context.Context, client *OIDCClient) error {
+	if client == nil || strings.TrimSpace(client.ID) == "" {
+		return errors.New("oidc client id is required")
+	}
+	redirectURIs, err := encodeStringSlice(client.RedirectURIs)
+	if err != nil {
+		return err
+	}
+	scopes, err := encodeStringSlice(client.Scopes)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO oidc_clients (client_id, name, secret_hash, redirect_uris, scopes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at, updated_at`
+	err = s.db.QueryRowContext(ctx, query, client.ID, client.Name, client.SecretHash, redirectURIs, scopes).
+		Scan(&client.CreatedAt, &client.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrOIDCClientExists
+		}
+		return err
+	}
+	client.RedirectURIs = normalizeStringSlice(client.RedirectURIs)
+	client.Scopes = normalizeStringSlice(client.Scopes)
+	client.CreatedAt = client.CreatedAt.UTC()
+	client.UpdatedAt = client.UpdatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetOIDCClient(ctx context.Context, id string) (*OIDCClient, error) {
+	query := "SELECT " + oidcClientColumns + " FROM oidc_clients WHERE client_id = $1"
+	client, err := scanOIDCClient(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOIDCClientNotFound
+	}
+	return client, err
+}
+
+// ListOIDCClients returns every registered client ordered by ID.
+func (s *postgresStore) ListOIDCClients(ctx context.Context) ([]OIDCClient, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+oidcClientColumns+" FROM oidc_clients ORDER BY client_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := make([]OIDCClient, 0)
+	for rows.Next() {
+		client, err := scanOIDCClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+func (s *postgresStore) DeleteOIDCClient(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM oidc_clients WHERE client_id = $1", strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrOIDCClientNotFound
+	}
+	return nil
+}
+
+func scanOIDCClient(row interface{ Scan(dest ...any) error }) (*OIDCClient, error) {
+	var client OIDCClient
+	var redirectURIs, scopes []byte
+	if err := row.Scan(&client.ID, &client.Name, &client.SecretHash, &redirectURIs, &scopes, &client.CreatedAt, &client.UpdatedAt); err != nil {
+		return nil, err
+	}
+	client.RedirectURIs = decodeStringSlice(redirectURIs)
+	client.Scopes = decodeStringSlice(scopes)
+	client.CreatedAt = client.CreatedAt.UTC()
+	client.UpdatedAt = client.UpdatedAt.UTC()
+	return &client, nil
+}
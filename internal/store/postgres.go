@@ -685,8 +685,18 @@ func (s *postgresStore) ListSubscriptionsByUser(ctx context.Context, userID stri
 
 	const query = `SELECT uuid, user_uuid, provider, payment_method, kind, plan_id, external_id, status, payment_qr, meta, created_at, updated_at, cancelled_at
 FROM subscriptions WHERE user_uuid = $1 ORDER BY created_at DESC`
+	return s.querySubscriptions(ctx, query, normalizedUserID)
+}
 
-	rows, err := s.db.QueryContext(ctx, query, normalizedUserID)
+// ListSubscriptions returns the subscriptions of every user ordered by recency.
+func (s *postgresStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	const query = `SELECT uuid, user_uuid, provider, payment_method, kind, plan_id, external_id, status, payment_qr, meta, created_at, updated_at, cancelled_at
+FROM subscriptions ORDER BY created_at DESC`
+	return s.querySubscriptions(ctx, query)
+}
+
+func (s *postgresStore) querySubscriptions(ctx context.Context, query string, args ...any) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -696,6 +706,7 @@ FROM subscriptions WHERE user_uuid = $1 ORDER BY created_at DESC`
 	for rows.Next() {
 		var (
 			idValue       any
+			userID        string
 			provider      string
 			paymentMethod string
 			kind          string
@@ -708,7 +719,7 @@ FROM subscriptions WHERE user_uuid = $1 ORDER BY created_at DESC`
 			updatedAt     time.Time
 			cancelled     sql.NullTime
 		)
-		if err := rows.Scan(&idValue, &normalizedUserID, &provider, &paymentMethod, &kind, &planID, &externalID, &status, &paymentQR, &metaBytes, &createdAt, &updatedAt, &cancelled); err != nil {
+		if err := rows.Scan(&idValue, &userID, &provider, &paymentMethod, &kind, &planID, &externalID, &status, &paymentQR, &metaBytes, &createdAt, &updatedAt, &cancelled); err != nil {
 			return nil, err
 		}
 
@@ -1143,7 +1154,7 @@ RETURNING uuid, created_at, updated_at`
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgErr.Code == "23505" { // unique_violation
-				return errors.New("identity already exists")
+				return ErrIdentityExists
 			}
 		}
 		return err
@@ -1236,6 +1247,35 @@ func (s *postgresStore) ListBlacklist(ctx context.Context) ([]string, error) {
 	return emails, nil
 }
 
+// CreateAgent registers a new agent, failing with ErrAgentExists when the ID
+// is taken.
+func (s *postgresStore) CreateAgent(ctx context.Context, agent *Agent) error {
+	groups, err := encodeStringSlice(agent.Groups)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO agents (id, name, groups, healthy, last_heartbeat, clients_count, sync_revision)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING created_at, updated_at`
+
+	err = s.db.QueryRowContext(ctx, query,
+		agent.ID,
+		agent.Name,
+		groups,
+		agent.Healthy,
+		agent.LastHeartbeat,
+		agent.ClientsCount,
+		agent.SyncRevision,
+	).Scan(&agent.CreatedAt, &agent.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAgentExists
+	}
+	return err
+}
+
 func (s *postgresStore) UpsertAgent(ctx context.Context, agent *Agent) error {
 	groups, err := encodeStringSlice(agent.Groups)
 	if err != nil {
@@ -1267,15 +1307,15 @@ func (s *postgresStore) UpsertAgent(ctx context.Context, agent *Agent) error {
 }
 
 func (s *postgresStore) GetAgent(ctx context.Context, id string) (*Agent, error) {
-	const query = `SELECT id, name, groups, healthy, last_heartbeat, clients_count, sync_revision, created_at, updated_at FROM agents WHERE id = $1`
+	const query = `SELECT id, name, groups, healthy, last_heartbeat, clients_count, sync_revision, certificate_expires_at, created_at, updated_at FROM agents WHERE id = $1`
 	var a Agent
 	var groups []byte
 	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&a.ID, &a.Name, &groups, &a.Healthy, &a.LastHeartbeat, &a.ClientsCount, &a.SyncRevision, &a.CreatedAt, &a.UpdatedAt,
+		&a.ID, &a.Name, &groups, &a.Healthy, &a.LastHeartbeat, &a.ClientsCount, &a.SyncRevision, &a.CertificateExpiresAt, &a.CreatedAt, &a.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("agent not found")
+			return nil, ErrAgentNotFound
 		}
 		return nil, err
 	}
@@ -1284,7 +1324,7 @@ func (s *postgresStore) GetAgent(ctx context.Context, id string) (*Agent, error)
 }
 
 func (s *postgresStore) ListAgents(ctx context.Context) ([]*Agent, error) {
-	const query = `SELECT id, name, groups, healthy, last_heartbeat, clients_count, sync_revision, created_at, updated_at FROM agents ORDER BY id ASC`
+	const query = `SELECT id, name, groups, healthy, last_heartbeat, clients_count, sync_revision, certificate_expires_at, created_at, updated_at FROM agents ORDER BY id ASC`
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -1296,7 +1336,7 @@ func (s *postgresStore) ListAgents(ctx context.Context) ([]*Agent, error) {
 		var a Agent
 		var groups []byte
 		if err := rows.Scan(
-			&a.ID, &a.Name, &groups, &a.Healthy, &a.LastHeartbeat, &a.ClientsCount, &a.SyncRevision, &a.CreatedAt, &a.UpdatedAt,
+			&a.ID, &a.Name, &groups, &a.Healthy, &a.LastHeartbeat, &a.ClientsCount, &a.SyncRevision, &a.CertificateExpiresAt, &a.CreatedAt, &a.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1312,9 +1352,37 @@ func (s *postgresStore) DeleteAgent(ctx context.Context, id string) error {
 	return err
 }
 
+// RecordAgentCertificate notes that a certificate valid until notAfter was
+// issued to agentID.
+func (s *postgresStore) RecordAgentCertificate(ctx context.Context, agentID string, notAfter time.Time) error {
+	const query = `
+		UPDATE agents
+		SET certificate_expires_at = GREATEST(COALESCE(certificate_expires_at, $2), $2)
+		WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, agentID, notAfter.UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
+
 func (s *postgresStore) DeleteStaleAgents(ctx context.Context, staleThreshold time.Duration) (int, error) {
 	cutoff := time.Now().Add(-staleThreshold)
-	const query = `DELETE FROM agents WHERE last_heartbeat < $1 OR last_heartbeat IS NULL`
+	// Agents created through the admin API hold tokens and are kept until
+	// an administrator deletes them; enrolled agents are kept while their
+	// certificate is valid.
+	const query = `
+		DELETE FROM agents
+		WHERE (last_heartbeat < $1 OR last_heartbeat IS NULL)
+			AND (certificate_expires_at IS NULL OR certificate_expires_at <= now())
+			AND NOT EXISTS (SELECT 1 FROM agent_tokens t WHERE t.agent_id = agents.id)`
 	result, err := s.db.ExecContext(ctx, query, cutoff)
 	if err != nil {
 		return 0, err
@@ -1324,9 +1392,26 @@ func (s *postgresStore) DeleteStaleAgents(ctx context.Context, staleThreshold ti
 }
 
 func (s *postgresStore) CreateSession(ctx context.Context, token, userID string, expiresAt time.Time) error {
-	const query = "INSERT INTO sessions (token, user_uuid, expires_at) VALUES ($1, $2, $3) ON CONFLICT (token) DO UPDATE SET user_uuid = EXCLUDED.user_uuid, expires_at = EXCLUDED.expires_at"
-	_, err := s.db.ExecContext(ctx, query, token, userID, expiresAt.UTC())
-	return err
+	return s.CreateSessionRecord(ctx, &Session{Token: token, UserID: userID, ExpiresAt: expiresAt})
+}
+
+func (s *postgresStore) CreateSessionRecord(ctx context.Context, session *Session) error {
+	if session == nil || strings.TrimSpace(session.Token) == "" {
+		return ErrSessionNotFound
+	}
+	const query = `INSERT INTO sessions (token, user_uuid, expires_at, user_agent, ip_address, auth_method)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (token) DO UPDATE SET user_uuid = EXCLUDED.user_uuid, expires_at = EXCLUDED.expires_at,
+	user_agent = EXCLUDED.user_agent, ip_address = EXCLUDED.ip_address, auth_method = EXCLUDED.auth_method
+RETURNING uuid, created_at, last_seen_at`
+	var createdAt, lastSeenAt time.Time
+	if err := s.db.QueryRowContext(ctx, query, session.Token, session.UserID, session.ExpiresAt.UTC(),
+		session.UserAgent, session.IPAddress, session.AuthMethod).Scan(&session.ID, &createdAt, &lastSeenAt); err != nil {
+		return err
+	}
+	session.CreatedAt = createdAt.UTC()
+	session.LastSeenAt = lastSeenAt.UTC()
+	return nil
 }
 
 func (s *postgresStore) GetSession(ctx context.Context, token string) (string, time.Time, error) {
@@ -1346,8 +1431,68 @@ func (s *postgresStore) GetSession(ctx context.Context, token string) (string, t
 	return userID, expiresAt.UTC(), nil
 }
 
+func (s *postgresStore) TouchSession(ctx context.Context, token string, seenAt time.Time) error {
+	const query = "UPDATE sessions SET last_seen_at = $2 WHERE token = $1 AND last_seen_at <= $3"
+	_, err := s.db.ExecContext(ctx, query, token, seenAt.UTC(), seenAt.Add(-SessionTouchInterval).UTC())
+	return err
+}
+
+const sessionColumns = "uuid, token, user_uuid, user_agent, ip_address, auth_method, created_at, last_seen_at, expires_at"
+
+func (s *postgresStore) ListSessionsByUser(ctx context.Context, userID string) ([]Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_uuid = $1 AND expires_at > now() ORDER BY last_seen_at DESC, uuid"
+	return s.querySessions(ctx, query, userID)
+}
+
 func (s *postgresStore) DeleteSession(ctx context.Context, token string) error {
 	const query = "DELETE FROM sessions WHERE token = $1"
 	_, err := s.db.ExecContext(ctx, query, token)
 	return err
 }
+
+func (s *postgresStore) DeleteSessionByID(ctx context.Context, userID, sessionID string) (*Session, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return nil, ErrSessionNotFound
+	}
+	query := "DELETE FROM sessions WHERE uuid = $1 AND user_uuid = $2 RETURNING " + sessionColumns
+	sessions, err := s.querySessions(ctx, query, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return &sessions[0], nil
+}
+
+func (s *postgresStore) DeleteSessionsByUser(ctx context.Context, userID, exceptToken string) ([]Session, error) {
+	query := "DELETE FROM sessions WHERE user_uuid = $1 AND token <> $2 RETURNING " + sessionColumns
+	sessions, err := s.querySessions(ctx, query, userID, exceptToken)
+	if err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *postgresStore) querySessions(ctx context.Context, query string, args ...any) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.Token, &sess.UserID, &sess.UserAgent, &sess.IPAddress, &sess.AuthMethod,
+			&sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sess.CreatedAt = sess.CreatedAt.UTC()
+		sess.LastSeenAt = sess.LastSeenAt.UTC()
+		sess.ExpiresAt = sess.ExpiresAt.UTC()
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
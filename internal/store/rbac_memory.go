@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ListRoles returns the custom roles ordered by key.
+func (s *memoryStore) ListRoles(ctx context.Context) ([]Role, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := make([]Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, *cloneRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Key < roles[j].Key })
+	return roles, nil
+}
+
+func (s *memoryStore) GetRole(ctx context.Context, key string) (*Role, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, ok := s.roles[normalizeRoleKey(key)]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	return cloneRole(role), nil
+}
+
+func (s *memoryStore) CreateRole(ctx context.Context, role *Role) error {
+	_ = ctx
+	if role == nil {
+		return ErrRoleNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *cloneRole(role)
+	stored.Key = normalizeRoleKey(stored.Key)
+	stored.Description = strings.TrimSpace(stored.Description)
+	stored.Permissions = normalizeRolePermissions(stored.Permissions)
+	if _, ok := s.roles[stored.Key]; ok {
+		return ErrRoleExists
+	}
+	now := time.Now().UTC()
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.roles[stored.Key] = &stored
+	*role = *cloneRole(&stored)
+	return nil
+}
+
+// UpdateRole replaces the description and permissions of a custom role.
+func (s *memoryStore) UpdateRole(ctx context.Context, role *Role) error {
+	_ = ctx
+	if role == nil {
+		return ErrRoleNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.roles[normalizeRoleKey(role.Key)]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	stored.Description = strings.TrimSpace(role.Description)
+	stored.Permissions = normalizeRolePermissions(role.Permissions)
+	stored.UpdatedAt = time.Now().UTC()
+	*role = *cloneRole(stored)
+	return nil
+}
+
+// DeleteRole removes a custom role together with its bindings.
+func (s *memoryStore) DeleteRole(ctx context.Context, key string) error {
+	_ = ctx
+	key = normalizeRoleKey(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.roles[key]; !ok {
+		return ErrRoleNotFound
+	}
+	delete(s.roles, key)
+	for id, binding := range s.roleBindings {
+		if binding.RoleKey == key {
+			delete(s.roleBindings, id)
+		}
+	}
+	return nil
+}
+
+// ListRoleBindings returns the role bindings of a user, oldest first.
+func (s *memoryStore) ListRoleBindings(ctx context.Context, userID string) ([]RoleBinding, error) {
+	_ = ctx
+	userID = strings.TrimSpace(userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bindings := make([]RoleBinding, 0)
+	for _, binding := range s.roleBindings {
+		if binding.UserID == userID {
+			bindings = append(bindings, *binding)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		if !bindings[i].CreatedAt.Equal(bindings[j].CreatedAt) {
+			return bindings[i].CreatedAt.Before(bindings[j].CreatedAt)
+		}
+		return bindings[i].ID < bindings[j].ID
+	})
+	return bindings, nil
+}
+
+func (s *memoryStore) CreateRoleBinding(ctx context.Context, binding *RoleBinding) error {
+	_ = ctx
+	if binding == nil {
+		return ErrRoleBindingNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *binding
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.UserID = strings.TrimSpace(stored.UserID)
+	stored.RoleKey = normalizeRoleKey(stored.RoleKey)
+	stored.TenantID = strings.TrimSpace(stored.TenantID)
+	if _, ok := s.byID[stored.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := s.roles[stored.RoleKey]; !ok {
+		return ErrRoleNotFound
+	}
+	for _, existing := range s.roleBindings {
+		if existing.UserID == stored.UserID && existing.RoleKey == stored.RoleKey && existing.TenantID == stored.TenantID {
+			return ErrRoleBindingExists
+		}
+	}
+	stored.CreatedAt = time.Now().UTC()
+	s.roleBindings[stored.ID] = &stored
+	*binding = stored
+	return nil
+}
+
+func (s *memoryStore) DeleteRoleBinding(ctx context.Context, userID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	binding, ok := s.roleBindings[strings.TrimSpace(id)]
+	if !ok || binding.UserID != strings.TrimSpace(userID) {
+		return ErrRoleBindingNotFound
+	}
+	delete(s.roleBindings, binding.ID)
+	return nil
+}
+
+func cloneRole(role *Role) *Role {
+	cloned := *role
+	cloned.Permissions = append([]string(nil), role.Permissions...)
+	return &cloned
+}
+
+func normalizeRoleKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// normalizeRolePermissions trims, de-duplicates and sorts permission keys.
+func normalizeRolePermissions(permissions []string) []string {
+	seen := make(map[string]struct{}, len(permissions))
+	normalized := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		permission = strings.TrimSpace(permission)
+		if permission == "" {
+			continue
+		}
+		if _, ok := seen[permission]; ok {
+			continue
+		}
+		seen[permission] = struct{}{}
+		normalized = append(normalized, permission)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Custom roles share rbac_roles and rbac_role_permissions with the seeded
+// built-in rows; the custom flag keeps the two apart.
+
+const roleBindingColumns = "id, user_uuid, role_key, tenant_id, created_by, created_at"
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// ListRoles returns the custom roles ordered by key.
+func (s *postgresStore) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT role_key, description, created_at, updated_at FROM rbac_roles WHERE custom ORDER BY role_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]Role, 0)
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Key, &role.Description, &role.CreatedAt, &role.UpdatedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Permissions, err = s.rolePermissions(ctx, roles[i].Key); err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+func (s *postgresStore) GetRole(ctx context.Context, key string) (*Role, error) {
+	var role Role
+	err := s.db.QueryRowContext(ctx, `SELECT role_key, description, created_at, updated_at FROM rbac_roles WHERE role_key = $1 AND custom`,
+		normalizeRoleKey(key)).Scan(&role.Key, &role.Description, &role.CreatedAt, &role.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role.Permissions, err = s.rolePermissions(ctx, role.Key); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (s *postgresStore) CreateRole(ctx context.Context, role *Role) error {
+	if role == nil {
+		return ErrRoleNotFound
+	}
+	role.Key = normalizeRoleKey(role.Key)
+	role.Description = strings.TrimSpace(role.Description)
+	role.Permissions = normalizeRolePermissions(role.Permissions)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO rbac_roles (role_key, description, custom, created_at, updated_at)
+		VALUES ($1, $2, TRUE, now(), now())
+		RETURNING created_at, updated_at`, role.Key, role.Description).Scan(&role.CreatedAt, &role.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrRoleExists
+	}
+	if err != nil {
+		return err
+	}
+	if err := replaceRolePermissions(ctx, tx, role.Key, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateRole replaces the description and permissions of a custom role.
+func (s *postgresStore) UpdateRole(ctx context.Context, role *Role) error {
+	if role == nil {
+		return ErrRoleNotFound
+	}
+	role.Key = normalizeRoleKey(role.Key)
+	role.Description = strings.TrimSpace(role.Description)
+	role.Permissions = normalizeRolePermissions(role.Permissions)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE rbac_roles SET description = $2, updated_at = now()
+		WHERE role_key = $1 AND custom
+		RETURNING created_at, updated_at`, role.Key, role.Description).Scan(&role.CreatedAt, &role.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rbac_role_permissions WHERE role_key = $1`, role.Key); err != nil {
+		return err
+	}
+	if err := replaceRolePermissions(ctx, tx, role.Key, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRole removes a custom role; its permissions and bindings go with it
+// through ON DELETE CASCADE.
+func (s *postgresStore) DeleteRole(ctx context.Context, key string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM rbac_roles WHERE role_key = $1 AND custom`, normalizeRoleKey(key))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) rolePermissions(ctx context.Context, key string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT permission_key FROM rbac_role_permissions WHERE role_key = $1 AND enabled ORDER BY permission_key`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := make([]string, 0)
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func replaceRolePermissions(ctx context.Context, tx *sql.Tx, key string, permissions []string) error {
+	for _, permission := range permissions {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO rbac_role_permissions (role_key, permission_key, enabled)
+			VALUES ($1, $2, TRUE)`, key, permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanRoleBinding(row interface{ Scan(...any) error }) (*RoleBinding, error) {
+	var binding RoleBinding
+	if err := row.Scan(
+		&binding.ID,
+		&binding.UserID,
+		&binding.RoleKey,
+		&binding.TenantID,
+		&binding.CreatedBy,
+		&binding.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	binding.CreatedAt = binding.CreatedAt.UTC()
+	return &binding, nil
+}
+
+// ListRoleBindings returns the role bindings of a user, oldest first.
+func (s *postgresStore) ListRoleBindings(ctx context.Context, userID string) ([]RoleBinding, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(userID)); err != nil {
+		return []RoleBinding{}, nil
+	}
+	query := "SELECT " + roleBindingColumns + " FROM rbac_role_bindings WHERE user_uuid = $1 ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(userID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bindings := make([]RoleBinding, 0)
+	for rows.Next() {
+		binding, err := scanRoleBinding(rows)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, *binding)
+	}
+	return bindings, rows.Err()
+}
+
+func (s *postgresStore) CreateRoleBinding(ctx context.Context, binding *RoleBinding) error {
+	if binding == nil {
+		return ErrRoleBindingNotFound
+	}
+	if binding.ID == "" {
+		binding.ID = uuid.NewString()
+	}
+	binding.UserID = strings.TrimSpace(binding.UserID)
+	binding.RoleKey = normalizeRoleKey(binding.RoleKey)
+	binding.TenantID = strings.TrimSpace(binding.TenantID)
+	if _, err := uuid.Parse(binding.UserID); err != nil {
+		return ErrUserNotFound
+	}
+
+	const query = `
+		INSERT INTO rbac_role_bindings (id, user_uuid, role_key, tenant_id, created_by, created_at)
+		SELECT $1, $2, r.role_key, $4, $5, now()
+		FROM rbac_roles r
+		WHERE r.role_key = $3 AND r.custom
+		RETURNING created_at`
+	var createdAt time.Time
+	err := s.db.QueryRowContext(ctx, query,
+		binding.ID,
+		binding.UserID,
+		binding.RoleKey,
+		binding.TenantID,
+		binding.CreatedBy,
+	).Scan(&createdAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRoleNotFound
+	case isUniqueViolation(err):
+		return ErrRoleBindingExists
+	case isForeignKeyViolation(err):
+		return ErrUserNotFound
+	case err != nil:
+		return err
+	}
+	binding.CreatedAt = createdAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) DeleteRoleBinding(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(strings.TrimSpace(userID)); err != nil {
+		return ErrRoleBindingNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM rbac_role_bindings WHERE user_uuid = $1 AND id = $2",
+		strings.TrimSpace(userID), strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrRoleBindingNotFound
+	}
+	return nil
+}
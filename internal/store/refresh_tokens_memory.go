@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+func cloneRefreshToken(src *RefreshToken) *RefreshToken {
+	if src == nil {
+		return nil
+	}
+	copy := *src
+	if src.UsedAt != nil {
+		usedAt := *src.UsedAt
+		copy.UsedAt = &usedAt
+	}
+	if src.RevokedAt != nil {
+		revokedAt := *src.RevokedAt
+		copy.RevokedAt = &revokedAt
+	}
+	return &copy
+}
+
+func (s *memoryStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	_ = ctx
+	if token == nil || strings.TrimSpace(token.ID) == "" {
+		return errors.New("refresh token id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.refreshTokens[token.ID]; exists {
+		return errors.New("refresh token already exists")
+	}
+	stored := cloneRefreshToken(token)
+	if stored.FamilyID == "" {
+		stored.FamilyID = stored.ID
+	}
+	if stored.IssuedAt.IsZero() {
+		stored.IssuedAt = time.Now().UTC()
+	}
+	s.refreshTokens[stored.ID] = stored
+	*token = *cloneRefreshToken(stored)
+	return nil
+}
+
+func (s *memoryStore) GetRefreshToken(ctx context.Context, id string) (*RefreshToken, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.refreshTokens[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return cloneRefreshToken(token), nil
+}
+
+func (s *memoryStore) RotateRefreshToken(ctx context.Context, usedID string, next *RefreshToken) error {
+	_ = ctx
+	if next == nil || strings.TrimSpace(next.ID) == "" {
+		return errors.New("next refresh token id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.refreshTokens[strings.TrimSpace(usedID)]
+	if !ok {
+		return ErrRefreshTokenNotFound
+	}
+	if used.UsedAt != nil || used.RevokedAt != nil {
+		return ErrRefreshTokenConsumed
+	}
+
+	now := time.Now().UTC()
+	used.UsedAt = &now
+	used.ReplacedBy = next.ID
+
+	stored := cloneRefreshToken(next)
+	stored.FamilyID = used.FamilyID
+	stored.UserID = used.UserID
+	stored.ParentID = used.ID
+	if stored.IssuedAt.IsZero() {
+		stored.IssuedAt = now
+	}
+	s.refreshTokens[stored.ID] = stored
+	*next = *cloneRefreshToken(stored)
+	return nil
+}
+
+func (s *memoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID, reason string) (int, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revokeRefreshTokensLocked(func(token *RefreshToken) bool { return token.FamilyID == familyID }, reason), nil
+}
+
+func (s *memoryStore) RevokeRefreshTokensByUser(ctx context.Context, userID, reason string) (int, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revokeRefreshTokensLocked(func(token *RefreshToken) bool { return token.UserID == userID }, reason), nil
+}
+
+func (s *memoryStore) revokeRefreshTokensLocked(match func(*RefreshToken) bool, reason string) int {
+	now := time.Now().UTC()
+	revoked := 0
+	for _, token := range s.refreshTokens {
+		if token.RevokedAt != nil || !match(token) {
+			continue
+		}
+		revokedAt := now
+		token.RevokedAt = &revokedAt
+		token.RevokeReason = reason
+		revoked++
+	}
+	return revoked
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const refreshTokenColumns = "id, family_id, user_uuid, COALESCE(parent_id::text, ''), issued_at, expires_at, used_at, replaced_by, revoked_at, revoke_reason"
+
+func scanRefreshToken(row interface{ Scan(...any) error }) (*RefreshToken, error) {
+	var (
+		token     RefreshToken
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+	if err := row.Scan(&token.ID, &token.FamilyID, &token.UserID, &token.ParentID, &token.IssuedAt, &token.ExpiresAt,
+		&usedAt, &token.ReplacedBy, &revokedAt, &token.RevokeReason); err != nil {
+		return nil, err
+	}
+	token.IssuedAt = token.IssuedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if usedAt.Valid {
+		value := usedAt.Time.UTC()
+		token.UsedAt = &value
+	}
+	if revokedAt.Valid {
+		value := revokedAt.Time.UTC()
+		token.RevokedAt = &value
+	}
+	return &token, nil
+}
+
+func (s *postgresStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	if token == nil || strings.TrimSpace(token.ID) == "" {
+		return errors.New("refresh token id is required")
+	}
+	if token.FamilyID == "" {
+		token.FamilyID = token.ID
+	}
+
+	const query = `
+		INSERT INTO refresh_tokens (id, family_id, user_uuid, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING issued_at`
+	if err := s.db.QueryRowContext(ctx, query, token.ID, token.FamilyID, token.UserID, token.ExpiresAt.UTC()).Scan(&token.IssuedAt); err != nil {
+		return err
+	}
+	token.IssuedAt = token.IssuedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetRefreshToken(ctx context.Context, id string) (*RefreshToken, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return nil, ErrRefreshTokenNotFound
+	}
+	query := "SELECT " + refreshTokenColumns + " FROM refresh_tokens WHERE id = $1"
+	token, err := scanRefreshToken(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return token, err
+}
+
+// RotateRefreshToken consumes usedID and inserts next in one statement, so
+// two concurrent refreshes with the same token cannot both succeed.
+func (s *postgresStore) RotateRefreshToken(ctx context.Context, usedID string, next *RefreshToken) error {
+	if next == nil || strings.TrimSpace(next.ID) == "" {
+		return errors.New("next refresh token id is required")
+	}
+	if _, err := uuid.Parse(strings.TrimSpace(usedID)); err != nil {
+		return ErrRefreshTokenNotFound
+	}
+
+	query := `
+		WITH used AS (
+			UPDATE refresh_tokens SET used_at = now(), replaced_by = $2
+			WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+			RETURNING id, family_id, user_uuid
+		)
+		INSERT INTO refresh_tokens (id, family_id, user_uuid, parent_id, expires_at)
+		SELECT $2, used.family_id, used.user_uuid, used.id, $3 FROM used
+		RETURNING ` + refreshTokenColumns
+	rotated, err := scanRefreshToken(s.db.QueryRowContext(ctx, query, strings.TrimSpace(usedID), next.ID, next.ExpiresAt.UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, lookupErr := s.GetRefreshToken(ctx, usedID); lookupErr != nil {
+			return lookupErr
+		}
+		return ErrRefreshTokenConsumed
+	}
+	if err != nil {
+		return err
+	}
+	*next = *rotated
+	return nil
+}
+
+func (s *postgresStore) RevokeRefreshTokenFamily(ctx context.Context, familyID, reason string) (int, error) {
+	const query = "UPDATE refresh_tokens SET revoked_at = now(), revoke_reason = $2 WHERE family_id = $1 AND revoked_at IS NULL"
+	return s.execRevokeRefreshTokens(ctx, query, familyID, reason)
+}
+
+func (s *postgresStore) RevokeRefreshTokensByUser(ctx context.Context, userID, reason string) (int, error) {
+	const query = "UPDATE refresh_tokens SET revoked_at = now(), revoke_reason = $2 WHERE user_uuid = $1 AND revoked_at IS NULL"
+	return s.execRevokeRefreshTokens(ctx, query, userID, reason)
+}
+
+func (s *postgresStore) execRevokeRefreshTokens(ctx context.Context, query, id, reason string) (int, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return 0, nil
+	}
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(id), reason)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := result.RowsAffected()
+	return int(count), nil
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (s *memoryStore) CreateSCIMToken(ctx context.Context, token *SCIMToken) error {
+	_ = ctx
+	if token == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneSCIMToken(token)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.TenantID = strings.TrimSpace(stored.TenantID)
+	stored.CreatedAt = time.Now().UTC()
+	stored.LastUsedAt = nil
+	s.scimTokens[stored.ID] = stored
+	*token = *cloneSCIMToken(stored)
+	return nil
+}
+
+func (s *memoryStore) GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.scimTokens {
+		if token.TokenHash == tokenHash {
+			return cloneSCIMToken(token), nil
+		}
+	}
+	return nil, ErrSCIMTokenNotFound
+}
+
+// ListSCIMTokens returns the tokens of a tenant, oldest first.
+func (s *memoryStore) ListSCIMTokens(ctx context.Context, tenantID string) ([]SCIMToken, error) {
+	_ = ctx
+	tenantID = strings.TrimSpace(tenantID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]SCIMToken, 0)
+	for _, token := range s.scimTokens {
+		if token.TenantID == tenantID {
+			tokens = append(tokens, *cloneSCIMToken(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (s *memoryStore) DeleteSCIMToken(ctx context.Context, tenantID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.scimTokens[strings.TrimSpace(id)]
+	if !ok || token.TenantID != strings.TrimSpace(tenantID) {
+		return ErrSCIMTokenNotFound
+	}
+	delete(s.scimTokens, token.ID)
+	return nil
+}
+
+func (s *memoryStore) TouchSCIMToken(ctx context.Context, id string, usedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.scimTokens[strings.TrimSpace(id)]
+	if !ok {
+		return ErrSCIMTokenNotFound
+	}
+	at := usedAt.UTC()
+	token.LastUsedAt = &at
+	return nil
+}
+
+func (s *memoryStore) CreateSCIMGroup(ctx context.Context, group *SCIMGroup) error {
+	_ = ctx
+	if group == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *group
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.TenantID = strings.TrimSpace(stored.TenantID)
+	stored.DisplayName = strings.TrimSpace(stored.DisplayName)
+	if s.scimGroupNameTakenLocked(stored.TenantID, stored.DisplayName, stored.ID) {
+		return ErrSCIMGroupExists
+	}
+	now := time.Now().UTC()
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.scimGroups[stored.ID] = &stored
+	*group = stored
+	return nil
+}
+
+func (s *memoryStore) GetSCIMGroup(ctx context.Context, tenantID, id string) (*SCIMGroup, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group, ok := s.scimGroups[strings.TrimSpace(id)]
+	if !ok || group.TenantID != strings.TrimSpace(tenantID) {
+		return nil, ErrSCIMGroupNotFound
+	}
+	groupCopy := *group
+	return &groupCopy, nil
+}
+
+// ListSCIMGroups returns the groups of a tenant, oldest first.
+func (s *memoryStore) ListSCIMGroups(ctx context.Context, tenantID string) ([]SCIMGroup, error) {
+	_ = ctx
+	tenantID = strings.TrimSpace(tenantID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]SCIMGroup, 0)
+	for _, group := range s.scimGroups {
+		if group.TenantID == tenantID {
+			groups = append(groups, *group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if !groups[i].CreatedAt.Equal(groups[j].CreatedAt) {
+			return groups[i].CreatedAt.Before(groups[j].CreatedAt)
+		}
+		return groups[i].ID < groups[j].ID
+	})
+	return groups, nil
+}
+
+func (s *memoryStore) UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) error {
+	_ = ctx
+	if group == nil {
+		return ErrSCIMGroupNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.scimGroups[group.ID]
+	if !ok || stored.TenantID != strings.TrimSpace(group.TenantID) {
+		return ErrSCIMGroupNotFound
+	}
+	displayName := strings.TrimSpace(group.DisplayName)
+	if s.scimGroupNameTakenLocked(stored.TenantID, displayName, stored.ID) {
+		return ErrSCIMGroupExists
+	}
+	stored.DisplayName = displayName
+	stored.ExternalID = group.ExternalID
+	stored.UpdatedAt = time.Now().UTC()
+	*group = *stored
+	return nil
+}
+
+func (s *memoryStore) DeleteSCIMGroup(ctx context.Context, tenantID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.scimGroups[strings.TrimSpace(id)]
+	if !ok || group.TenantID != strings.TrimSpace(tenantID) {
+		return ErrSCIMGroupNotFound
+	}
+	delete(s.scimGroups, group.ID)
+	return nil
+}
+
+func (s *memoryStore) scimGroupNameTakenLocked(tenantID, displayName, exceptID string) bool {
+	for _, group := range s.scimGroups {
+		if group.ID != exceptID && group.TenantID == tenantID && strings.EqualFold(group.DisplayName, displayName) {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneSCIMToken(token *SCIMToken) *SCIMToken {
+	cloned := *token
+	cloned.LastUsedAt = cloneTimePtr(token.LastUsedAt)
+	return &cloned
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	scimTokenColumns = "id, tenant_id, description, token_hash, created_at, last_used_at"
+	scimGroupColumns = "id, tenant_id, display_name, external_id, created_at, updated_at"
+)
+
+func scanSCIMToken(row interface{ Scan(...any) error }) (*SCIMToken, error) {
+	var (
+		token      SCIMToken
+		lastUsedAt sql.NullTime
+	)
+	if err := row.Scan(&token.ID, &token.TenantID, &token.Description, &token.TokenHash, &token.CreatedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		at := lastUsedAt.Time.UTC()
+		token.LastUsedAt = &at
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	return &token, nil
+}
+
+func scanSCIMGroup(row interface{ Scan(...any) error }) (*SCIMGroup, error) {
+	var group SCIMGroup
+	if err := row.Scan(&group.ID, &group.TenantID, &group.DisplayName, &group.ExternalID, &group.CreatedAt, &group.UpdatedAt); err != nil {
+		return nil, err
+	}
+	group.CreatedAt = group.CreatedAt.UTC()
+	group.UpdatedAt = group.UpdatedAt.UTC()
+	return &group, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (s *postgresStore) CreateSCIMToken(ctx context.Context, token *SCIMToken) error {
+	if token == nil {
+		return nil
+	}
+	if token.ID == "" {
+		token.ID = uuid.NewString()
+	}
+	token.TenantID = strings.TrimSpace(token.TenantID)
+	token.LastUsedAt = nil
+
+	const query = `
+		INSERT INTO scim_tokens (id, tenant_id, description, token_hash, created_at)
+		VALUES ($1, $2, $3, $4, now())
+		RETURNING created_at`
+	if err := s.db.QueryRowContext(ctx, query, token.ID, token.TenantID, token.Description, token.TokenHash).Scan(&token.CreatedAt); err != nil {
+		return err
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error) {
+	query := "SELECT " + scimTokenColumns + " FROM scim_tokens WHERE token_hash = $1"
+	token, err := scanSCIMToken(s.db.QueryRowContext(ctx, query, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSCIMTokenNotFound
+	}
+	return token, err
+}
+
+// ListSCIMTokens returns the tokens of a tenant, oldest first.
+func (s *postgresStore) ListSCIMTokens(ctx context.Context, tenantID string) ([]SCIMToken, error) {
+	query := "SELECT " + scimTokenColumns + " FROM scim_tokens WHERE tenant_id = $1 ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]SCIMToken, 0)
+	for rows.Next() {
+		token, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *postgresStore) DeleteSCIMToken(ctx context.Context, tenantID, id string) error {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrSCIMTokenNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM scim_tokens WHERE id = $1 AND tenant_id = $2",
+		strings.TrimSpace(id), strings.TrimSpace(tenantID))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrSCIMTokenNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) TouchSCIMToken(ctx context.Context, id string, usedAt time.Time) error {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrSCIMTokenNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "UPDATE scim_tokens SET last_used_at = $2 WHERE id = $1",
+		strings.TrimSpace(id), usedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrSCIMTokenNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) CreateSCIMGroup(ctx context.Context, group *SCIMGroup) error {
+	if group == nil {
+		return nil
+	}
+	if group.ID == "" {
+		group.ID = uuid.NewString()
+	}
+	group.TenantID = strings.TrimSpace(group.TenantID)
+	group.DisplayName = strings.TrimSpace(group.DisplayName)
+
+	const query = `
+		INSERT INTO scim_groups (id, tenant_id, display_name, external_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, now(), now())
+		RETURNING created_at, updated_at`
+	err := s.db.QueryRowContext(ctx, query, group.ID, group.TenantID, group.DisplayName, group.ExternalID).
+		Scan(&group.CreatedAt, &group.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrSCIMGroupExists
+	}
+	if err != nil {
+		return err
+	}
+	group.CreatedAt = group.CreatedAt.UTC()
+	group.UpdatedAt = group.UpdatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetSCIMGroup(ctx context.Context, tenantID, id string) (*SCIMGroup, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return nil, ErrSCIMGroupNotFound
+	}
+	query := "SELECT " + scimGroupColumns + " FROM scim_groups WHERE id = $1 AND tenant_id = $2"
+	group, err := scanSCIMGroup(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id), strings.TrimSpace(tenantID)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSCIMGroupNotFound
+	}
+	return group, err
+}
+
+// ListSCIMGroups returns the groups of a tenant, oldest first.
+func (s *postgresStore) ListSCIMGroups(ctx context.Context, tenantID string) ([]SCIMGroup, error) {
+	query := "SELECT " + scimGroupColumns + " FROM scim_groups WHERE tenant_id = $1 ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]SCIMGroup, 0)
+	for rows.Next() {
+		group, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+	return groups, rows.Err()
+}
+
+func (s *postgresStore) UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) error {
+	if group == nil {
+		return ErrSCIMGroupNotFound
+	}
+	if _, err := uuid.Parse(group.ID); err != nil {
+		return ErrSCIMGroupNotFound
+	}
+	group.DisplayName = strings.TrimSpace(group.DisplayName)
+
+	const query = `
+		UPDATE scim_groups
+		SET display_name = $3, external_id = $4, updated_at = now()
+		WHERE id = $1 AND tenant_id = $2
+		RETURNING created_at, updated_at`
+	err := s.db.QueryRowContext(ctx, query, group.ID, strings.TrimSpace(group.TenantID), group.DisplayName, group.ExternalID).
+		Scan(&group.CreatedAt, &group.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSCIMGroupNotFound
+	}
+	if isUniqueViolation(err) {
+		return ErrSCIMGroupExists
+	}
+	if err != nil {
+		return err
+	}
+	group.CreatedAt = group.CreatedAt.UTC()
+	group.UpdatedAt = group.UpdatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) DeleteSCIMGroup(ctx context.Context, tenantID, id string) error {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrSCIMGroupNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM scim_groups WHERE id = $1 AND tenant_id = $2",
+		strings.TrimSpace(id), strings.TrimSpace(tenantID))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrSCIMGroupNotFound
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *memoryStore) CreateSigningKey(ctx context.Context, key *SigningKey) error {
+	_ = ctx
+	if key == nil || strings.TrimSpace(key.ID) == "" {
+		return errors.New("signing key id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.signingKeys[key.ID]; exists {
+		return errors.New("signing key already exists")
+	}
+	stored := *key
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now().UTC()
+	}
+	stored.RetiredAt = nil
+	s.signingKeys[stored.ID] = &stored
+	*key = stored
+	return nil
+}
+
+// ListSigningKeys returns keys that have not been retired, oldest first.
+func (s *memoryStore) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]SigningKey, 0, len(s.signingKeys))
+	for _, key := range s.signingKeys {
+		if key.RetiredAt == nil {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (s *memoryStore) RetireSigningKey(ctx context.Context, id string, retiredAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.signingKeys[id]
+	if !ok || key.RetiredAt != nil {
+		return nil
+	}
+	at := retiredAt.UTC()
+	key.RetiredAt = &at
+	return nil
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+func (s *postgresStore) CreateSigningKey(ctx context.Context, key *SigningKey) error {
+	if key == nil || strings.TrimSpace(key.ID) == "" {
+		return errors.New("signing key id is required")
+	}
+
+	const query = `
+		INSERT INTO jwt_signing_keys (kid, algorithm, private_key_pem, not_before)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+	if err := s.db.QueryRowContext(ctx, query, key.ID, key.Algorithm, key.PrivateKeyPEM, key.NotBefore.UTC()).Scan(&key.CreatedAt); err != nil {
+		return err
+	}
+	key.CreatedAt = key.CreatedAt.UTC()
+	key.RetiredAt = nil
+	return nil
+}
+
+// ListSigningKeys returns keys that have not been retired, oldest first.
+func (s *postgresStore) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	const query = `
+		SELECT kid, algorithm, private_key_pem, not_before, created_at
+		FROM jwt_signing_keys
+		WHERE retired_at IS NULL
+		ORDER BY created_at, kid`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]SigningKey, 0)
+	for rows.Next() {
+		var key SigningKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKeyPEM, &key.NotBefore, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		key.NotBefore = key.NotBefore.UTC()
+		key.CreatedAt = key.CreatedAt.UTC()
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresStore) RetireSigningKey(ctx context.Context, id string, retiredAt time.Time) error {
+	const query = "UPDATE jwt_signing_keys SET retired_at = $2 WHERE kid = $1 AND retired_at IS NULL"
+	_, err := s.db.ExecContext(ctx, query, id, retiredAt.UTC())
+	return err
+}
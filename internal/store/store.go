@@ -60,6 +60,331 @@ type Identity struct {
 	UpdatedAt  time.Time
 }
 
+// Session describes a signed-in device or browser. Token is the bearer secret
+// and must never be returned to clients; ID identifies the session instead.
+type Session struct {
+	ID         string
+	Token      string
+	UserID     string
+	UserAgent  string
+	IPAddress  string
+	AuthMethod string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// RefreshToken records an issued refresh token by its JWT ID. Tokens rotated
+// from the same sign-in share a FamilyID so a replayed token can invalidate
+// every descendant at once.
+type RefreshToken struct {
+	ID           string
+	FamilyID     string
+	UserID       string
+	ParentID     string
+	IssuedAt     time.Time
+	ExpiresAt    time.Time
+	UsedAt       *time.Time
+	ReplacedBy   string
+	RevokedAt    *time.Time
+	RevokeReason string
+}
+
+// SigningKey is an asymmetric JWT signing key generated and kept by the
+// service. A key signs once NotBefore has passed and remains published for
+// verification until it is retired.
+type SigningKey struct {
+	ID            string
+	Algorithm     string
+	PrivateKeyPEM string
+	NotBefore     time.Time
+	CreatedAt     time.Time
+	RetiredAt     *time.Time
+}
+
+// WebAuthnCredential is a passkey or security key registered by a user. ID
+// is the base64url-encoded credential ID and SignCount is the authenticator
+// signature counter seen on the last successful assertion.
+type WebAuthnCredential struct {
+	ID              string
+	UserID          string
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      *time.Time
+}
+
+// MFARecoveryCode is a single-use code that stands in for a lost second
+// factor. CodeHash is the hex SHA-256 of the normalized code; the Used*
+// fields record the sign-in that consumed it.
+type MFARecoveryCode struct {
+	ID            string
+	UserID        string
+	CodeHash      string
+	CreatedAt     time.Time
+	UsedAt        *time.Time
+	UsedIP        string
+	UsedUserAgent string
+}
+
+// AuditEvent is an append-only record of a security-relevant or administrative
+// action. ActorID is the user who acted and TargetType/TargetID what was acted
+// on; for sign-ins both point at the same user. Before and After hold only the
+// fields the action changed.
+type AuditEvent struct {
+	ID         string
+	TenantID   string
+	ActorID    string
+	ActorEmail string
+	Action     string
+	Outcome    string
+	TargetType string
+	TargetID   string
+	IPAddress  string
+	UserAgent  string
+	Before     map[string]any
+	After      map[string]any
+	Metadata   map[string]any
+	CreatedAt  time.Time
+}
+
+// Audit event outcomes.
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEventFilter narrows ListAuditEvents. Empty fields match everything;
+// ActionPrefix matches Action by prefix. Events are returned newest first, and
+// After, when set, is the ID of the last event of the previous page.
+type AuditEventFilter struct {
+	TenantID     string
+	ActorID      string
+	TargetType   string
+	TargetID     string
+	ActionPrefix string
+	Outcome      string
+	Since        time.Time
+	Until        time.Time
+	After        string
+	Limit        int
+}
+
+// OutboxEvent is an account lifecycle event kept until it has been delivered
+// to every webhook subscribed to its type. SubjectType/SubjectID name the
+// record the event is about and Data carries its type-specific payload.
+type OutboxEvent struct {
+	ID          string
+	Type        string
+	SubjectType string
+	SubjectID   string
+	Data        map[string]any
+	CreatedAt   time.Time
+}
+
+// WebhookSubscription is an endpoint that receives lifecycle events. Secret
+// signs every delivery, so unlike other credentials it is kept in clear.
+// An empty EventTypes subscribes to every event type.
+type WebhookSubscription struct {
+	ID          string
+	URL         string
+	Description string
+	Secret      string
+	EventTypes  []string
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Accepts reports whether the subscription wants events of eventType.
+func (s *WebhookSubscription) Accepts(eventType string) bool {
+	if s == nil || !s.Active {
+		return false
+	}
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, accepted := range s.EventTypes {
+		if accepted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery tracks sending one event to one subscription. A pending
+// delivery is retried at NextAttemptAt until it succeeds or runs out of
+// attempts; the Last* fields describe the most recent attempt.
+type WebhookDelivery struct {
+	ID             string
+	EventID        string
+	SubscriptionID string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastAttemptAt  *time.Time
+	ResponseStatus int
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDeliveryFilter narrows ListWebhookDeliveries. Empty fields match
+// everything; deliveries are returned newest first.
+type WebhookDeliveryFilter struct {
+	SubscriptionID string
+	EventID        string
+	Status         string
+	Limit          int
+}
+
+// SCIMToken authenticates a tenant's identity provider against the SCIM
+// endpoint. Only the SHA-256 hash of the token is kept.
+type SCIMToken struct {
+	ID          string
+	TenantID    string
+	Description string
+	TokenHash   string
+	CreatedAt   time.Time
+	LastUsedAt  *time.Time
+}
+
+// SCIMGroup is a group provisioned by a tenant's identity provider. Members
+// carry DisplayName in User.Groups, so provisioned groups take part in
+// group-based routing like any other group. DisplayName is unique within the
+// tenant, ignoring case.
+type SCIMGroup struct {
+	ID          string
+	TenantID    string
+	DisplayName string
+	ExternalID  string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Role is a custom permission set defined by administrators. Built-in roles
+// such as operator are defined in code and never stored as Role.
+type Role struct {
+	Key         string
+	Description string
+	Permissions []string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RoleBinding grants a role to a user, platform-wide when TenantID is empty
+// and within that tenant otherwise. A user holds each role at most once per
+// scope.
+type RoleBinding struct {
+	ID        string
+	UserID    string
+	RoleKey   string
+	TenantID  string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// Prefixes of API token secrets. They make tokens recognisable to secret
+// scanners and tell personal tokens apart from service account tokens.
+const (
+	PersonalAccessTokenPrefix = "pat_"
+	ServiceAccountTokenPrefix = "sat_"
+)
+
+// IsAPIToken reports whether token looks like an API token secret.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix) || strings.HasPrefix(token, ServiceAccountTokenPrefix)
+}
+
+// ServiceAccount is a non-human principal for integrations. It holds
+// Permissions directly instead of a role; a service account with a TenantID
+// only acts within that tenant.
+type ServiceAccount struct {
+	ID          string
+	Name        string
+	Description string
+	TenantID    string
+	Permissions []string
+	Disabled    bool
+	CreatedBy   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// APITokenOwner identifies whose API tokens are meant: a user's personal
+// tokens or a service account's tokens. Exactly one field is set.
+type APITokenOwner struct {
+	UserID           string
+	ServiceAccountID string
+}
+
+// APIToken is a bearer token for a user or a service account. Scopes are
+// permission strings and narrow what the owner may do with the token; a
+// token with a TenantID only reaches that tenant. Only the SHA-256 hash of
+// the token is kept.
+type APIToken struct {
+	ID               string
+	Name             string
+	UserID           string
+	ServiceAccountID string
+	TenantID         string
+	Scopes           []string
+	TokenHash        string
+	CreatedBy        string
+	CreatedAt        time.Time
+	ExpiresAt        time.Time
+	LastUsedAt       *time.Time
+}
+
+// Owner returns the owner of the token.
+func (t *APIToken) Owner() APITokenOwner {
+	return APITokenOwner{UserID: t.UserID, ServiceAccountID: t.ServiceAccountID}
+}
+
+// OIDCClient is an application registered to sign users in through the
+// built-in OpenID Connect provider. SecretHash is a bcrypt hash; it is empty
+// for public clients, which rely on PKCE alone.
+type OIDCClient struct {
+	ID           string
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	Scopes       []string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Public reports whether the client has no secret.
+func (c *OIDCClient) Public() bool {
+	return c != nil && c.SecretHash == ""
+}
+
+// Refresh token revocation reasons.
+const (
+	RefreshTokenRevokedReuse           = "reuse_detected"
+	RefreshTokenRevokedPasswordChanged = "password_changed"
+	RefreshTokenRevokedMFADisabled     = "mfa_disabled"
+	RefreshTokenRevokedUserPaused      = "user_paused"
+	RefreshTokenRevokedSignedOut       = "signed_out"
+)
+
+// SessionTouchInterval is the minimum gap between two last-seen updates of
+// the same session, so authenticated requests do not write on every call.
+const SessionTouchInterval = time.Minute
+
 // Agent represents a registered agent instance with health tracking.
 type Agent struct {
 	ID            string     `json:"id"`
@@ -69,8 +394,48 @@ type Agent struct {
 	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
 	ClientsCount  int        `json:"clientsCount"`
 	SyncRevision  string     `json:"syncRevision,omitempty"`
-	CreatedAt     time.Time  `json:"createdAt"`
-	UpdatedAt     time.Time  `json:"updatedAt"`
+	// CertificateExpiresAt is when the latest client certificate issued to
+	// the agent expires. Status reports do not change it.
+	CertificateExpiresAt *time.Time `json:"certificateExpiresAt,omitempty"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+}
+
+// AgentJoinTokenPrefix marks agent join tokens.
+const AgentJoinTokenPrefix = "ajt_"
+
+// AgentJoinToken is a one-time token an agent exchanges, together with a
+// certificate signing request, for its first client certificate. The token
+// fixes the agent ID and groups the certificate is issued for.
+type AgentJoinToken struct {
+	ID        string
+	AgentID   string
+	Groups    []string
+	TokenHash string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// AgentTokenPrefix marks agent tokens issued through the admin API.
+const AgentTokenPrefix = "agt_"
+
+// AgentToken is a bearer token issued to a single agent through the admin
+// API. Only its SHA-256 hash is stored. A token rotated out keeps working
+// until ExpiresAt so the agent can pick up its replacement.
+type AgentToken struct {
+	ID        string
+	AgentID   string
+	TokenHash string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// ActiveAt reports whether the token is accepted at now.
+func (t *AgentToken) ActiveAt(now time.Time) bool {
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
 }
 
 const (
@@ -78,6 +443,13 @@ const (
 	RatingStatusRated   = "rated"
 )
 
+const (
+	ThrottleStateNormal    = "normal"
+	ThrottleStateThrottled = "throttled"
+	SuspendStateActive     = "active"
+	SuspendStateSuspended  = "suspended"
+)
+
 type TrafficStatCheckpoint struct {
 	NodeID            string
 	AccountUUID       string
@@ -99,6 +471,7 @@ type TrafficMinuteBucket struct {
 	UplinkBytes    int64
 	DownlinkBytes  int64
 	TotalBytes     int64
+	BilledBytes    int64
 	Multiplier     float64
 	RatingStatus   string
 	SourceRevision string
@@ -116,6 +489,7 @@ type BillingLedgerEntry struct {
 	AmountDelta        float64
 	BalanceAfter       float64
 	PricingRuleVersion string
+	SourceKey          string
 	CreatedAt          time.Time
 }
 
@@ -196,8 +570,12 @@ type Store interface {
 
 	UpsertSubscription(ctx context.Context, subscription *Subscription) error
 	ListSubscriptionsByUser(ctx context.Context, userID string) ([]Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
 	CancelSubscription(ctx context.Context, userID, externalID string, cancelledAt time.Time) (*Subscription, error)
 	CreateIdentity(ctx context.Context, identity *Identity) error
+	GetIdentity(ctx context.Context, provider, externalID string) (*Identity, error)
+	ListIdentitiesByUser(ctx context.Context, userID string) ([]Identity, error)
+	DeleteIdentity(ctx context.Context, userID, identityID string) (*Identity, error)
 	ListUsers(ctx context.Context) ([]User, error)
 	DeleteUser(ctx context.Context, id string) error
 
@@ -209,41 +587,179 @@ type Store interface {
 
 	// Session management
 	CreateSession(ctx context.Context, token, userID string, expiresAt time.Time) error
+	CreateSessionRecord(ctx context.Context, session *Session) error
 	GetSession(ctx context.Context, token string) (string, time.Time, error)
+	TouchSession(ctx context.Context, token string, seenAt time.Time) error
+	ListSessionsByUser(ctx context.Context, userID string) ([]Session, error)
 	DeleteSession(ctx context.Context, token string) error
+	DeleteSessionByID(ctx context.Context, userID, sessionID string) (*Session, error)
+	DeleteSessionsByUser(ctx context.Context, userID, exceptToken string) ([]Session, error)
+
+	// Refresh tokens
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedID string, next *RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID, reason string) (int, error)
+	RevokeRefreshTokensByUser(ctx context.Context, userID, reason string) (int, error)
+
+	// JWT signing keys
+	CreateSigningKey(ctx context.Context, key *SigningKey) error
+	ListSigningKeys(ctx context.Context) ([]SigningKey, error)
+	RetireSigningKey(ctx context.Context, id string, retiredAt time.Time) error
+
+	// OpenID Connect clients
+	CreateOIDCClient(ctx context.Context, client *OIDCClient) error
+	GetOIDCClient(ctx context.Context, id string) (*OIDCClient, error)
+	ListOIDCClients(ctx context.Context) ([]OIDCClient, error)
+	DeleteOIDCClient(ctx context.Context, id string) error
+
+	// WebAuthn credentials
+	CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+	ListWebAuthnCredentialsByUser(ctx context.Context, userID string) ([]WebAuthnCredential, error)
+	UpdateWebAuthnCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error
+	DeleteWebAuthnCredential(ctx context.Context, userID, id string) error
+
+	// MFA recovery codes
+	ReplaceMFARecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	ListMFARecoveryCodes(ctx context.Context, userID string) ([]MFARecoveryCode, error)
+	ConsumeMFARecoveryCode(ctx context.Context, userID, codeHash, ip, userAgent string, usedAt time.Time) (*MFARecoveryCode, error)
+
+	// Audit log
+	AppendAuditEvent(ctx context.Context, event *AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error)
+
+	// Lifecycle events and webhooks
+	AppendOutboxEvent(ctx context.Context, event *OutboxEvent) error
+	GetOutboxEvent(ctx context.Context, id string) (*OutboxEvent, error)
+	ListOutboxEvents(ctx context.Context, eventType string, limit int) ([]OutboxEvent, error)
+	CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	UpdateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	DeleteWebhookSubscription(ctx context.Context, id string) error
+	EnqueueWebhookDeliveries(ctx context.Context, eventID, subscriptionID string) ([]WebhookDelivery, error)
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]WebhookDelivery, error)
+
+	// SCIM provisioning
+	CreateSCIMToken(ctx context.Context, token *SCIMToken) error
+	GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error)
+	ListSCIMTokens(ctx context.Context, tenantID string) ([]SCIMToken, error)
+	DeleteSCIMToken(ctx context.Context, tenantID, id string) error
+	TouchSCIMToken(ctx context.Context, id string, usedAt time.Time) error
+	CreateSCIMGroup(ctx context.Context, group *SCIMGroup) error
+	GetSCIMGroup(ctx context.Context, tenantID, id string) (*SCIMGroup, error)
+	ListSCIMGroups(ctx context.Context, tenantID string) ([]SCIMGroup, error)
+	UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) error
+	DeleteSCIMGroup(ctx context.Context, tenantID, id string) error
+
+	// Roles and role bindings
+	ListRoles(ctx context.Context) ([]Role, error)
+	GetRole(ctx context.Context, key string) (*Role, error)
+	CreateRole(ctx context.Context, role *Role) error
+	UpdateRole(ctx context.Context, role *Role) error
+	DeleteRole(ctx context.Context, key string) error
+	ListRoleBindings(ctx context.Context, userID string) ([]RoleBinding, error)
+	CreateRoleBinding(ctx context.Context, binding *RoleBinding) error
+	DeleteRoleBinding(ctx context.Context, userID, id string) error
+
+	// Service accounts and API tokens
+	ListServiceAccounts(ctx context.Context, tenantID string) ([]ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error)
+	CreateServiceAccount(ctx context.Context, account *ServiceAccount) error
+	UpdateServiceAccount(ctx context.Context, account *ServiceAccount) error
+	DeleteServiceAccount(ctx context.Context, id string) error
+	CreateAPIToken(ctx context.Context, token *APIToken) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	ListAPITokens(ctx context.Context, owner APITokenOwner) ([]APIToken, error)
+	DeleteAPIToken(ctx context.Context, owner APITokenOwner, id string) error
+	TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error
 
 	// Agent management
+	CreateAgent(ctx context.Context, agent *Agent) error
 	UpsertAgent(ctx context.Context, agent *Agent) error
 	GetAgent(ctx context.Context, id string) (*Agent, error)
 	ListAgents(ctx context.Context) ([]*Agent, error)
 	DeleteAgent(ctx context.Context, id string) error
 	DeleteStaleAgents(ctx context.Context, staleThreshold time.Duration) (int, error)
+	RecordAgentCertificate(ctx context.Context, agentID string, notAfter time.Time) error
+	CreateAgentToken(ctx context.Context, token *AgentToken) error
+	ListAgentTokens(ctx context.Context, agentID string) ([]AgentToken, error)
+	ExpireAgentTokens(ctx context.Context, agentID string, at time.Time) error
+	DeleteAgentToken(ctx context.Context, agentID, id string) error
+	CreateAgentJoinToken(ctx context.Context, token *AgentJoinToken) error
+	ListAgentJoinTokens(ctx context.Context) ([]AgentJoinToken, error)
+	DeleteAgentJoinToken(ctx context.Context, id string) error
+	ConsumeAgentJoinToken(ctx context.Context, tokenHash string, usedAt time.Time) (*AgentJoinToken, error)
+	RevokeAgentCertificates(ctx context.Context, agentID string, before time.Time) error
+	AgentCertificatesRevokedBefore(ctx context.Context, agentID string) (time.Time, error)
 
 	UpsertTrafficStatCheckpoint(ctx context.Context, checkpoint *TrafficStatCheckpoint) error
 	GetTrafficStatCheckpoint(ctx context.Context, nodeID, accountUUID string) (*TrafficStatCheckpoint, error)
 	ListTrafficStatCheckpoints(ctx context.Context) ([]TrafficStatCheckpoint, error)
 	UpsertTrafficMinuteBucket(ctx context.Context, bucket *TrafficMinuteBucket) error
+	AccumulateTrafficMinuteBucket(ctx context.Context, bucket *TrafficMinuteBucket) error
+	// RecordTrafficSample adds bucket, when set, and stores checkpoint
+	// atomically, provided the stored checkpoint still holds the counters of
+	// previous (nil when there was none). Otherwise it returns
+	// ErrTrafficCheckpointChanged and records nothing.
+	RecordTrafficSample(ctx context.Context, previous, checkpoint *TrafficStatCheckpoint, bucket *TrafficMinuteBucket) error
 	ListTrafficMinuteBucketsByAccount(ctx context.Context, accountUUID string, start, end time.Time) ([]TrafficMinuteBucket, error)
 	ListTrafficMinuteBuckets(ctx context.Context) ([]TrafficMinuteBucket, error)
+	ListPendingTrafficMinuteBuckets(ctx context.Context, before time.Time, limit int) ([]TrafficMinuteBucket, error)
+	// MarkTrafficMinuteBucketRated marks bucket rated and all of its bytes
+	// billed, provided the stored bucket is still pending with
+	// bucket.TotalBytes. Otherwise it returns ErrTrafficBucketChanged.
+	MarkTrafficMinuteBucketRated(ctx context.Context, bucket *TrafficMinuteBucket) error
 	InsertBillingLedgerEntry(ctx context.Context, entry *BillingLedgerEntry) error
+	// RecordTrafficCharge marks bucket rated as MarkTrafficMinuteBucketRated
+	// does, inserts entry and stores the account quota state atomically.
+	// charge adjusts the locked current state, or initial when the account
+	// has none, and may complete entry. When the ledger already holds entry
+	// it returns ErrLedgerEntryExists and records nothing.
+	RecordTrafficCharge(ctx context.Context, bucket *TrafficMinuteBucket, entry *BillingLedgerEntry, initial *AccountQuotaState, charge func(state *AccountQuotaState)) error
 	ListBillingLedgerByAccount(ctx context.Context, accountUUID string, limit int) ([]BillingLedgerEntry, error)
 	UpsertAccountQuotaState(ctx context.Context, state *AccountQuotaState) error
+	// UpdateAccountQuotaState passes the locked quota state of accountUUID to
+	// update and stores it when update reports a change. It returns
+	// ErrUserNotFound when the account has no quota state.
+	UpdateAccountQuotaState(ctx context.Context, accountUUID string, update func(state *AccountQuotaState) bool) (bool, error)
 	GetAccountQuotaState(ctx context.Context, accountUUID string) (*AccountQuotaState, error)
+	ListAccountQuotaStates(ctx context.Context) ([]AccountQuotaState, error)
 	UpsertAccountBillingProfile(ctx context.Context, profile *AccountBillingProfile) error
 	GetAccountBillingProfile(ctx context.Context, accountUUID string) (*AccountBillingProfile, error)
 	UpsertAccountPolicySnapshot(ctx context.Context, snapshot *AccountPolicySnapshot) error
 	GetLatestAccountPolicySnapshot(ctx context.Context, accountUUID string) (*AccountPolicySnapshot, error)
+	ListAccountPolicySnapshots(ctx context.Context) ([]AccountPolicySnapshot, error)
 	UpsertNodeHealthSnapshot(ctx context.Context, snapshot *NodeHealthSnapshot) error
 	ListLatestNodeHealthSnapshots(ctx context.Context) ([]NodeHealthSnapshot, error)
 	InsertSchedulerDecision(ctx context.Context, decision *SchedulerDecision) error
 	ListRecentSchedulerDecisions(ctx context.Context, limit int) ([]SchedulerDecision, error)
 
 	EnsureTenant(ctx context.Context, tenant *Tenant) error
+	GetTenant(ctx context.Context, id string) (*Tenant, error)
 	EnsureTenantDomain(ctx context.Context, domain *TenantDomain) error
 	UpsertTenantMembership(ctx context.Context, membership *TenantMembership) error
 	ResolveTenantByHost(ctx context.Context, host string) (*Tenant, *TenantDomain, error)
 	ListTenantMembershipsByUser(ctx context.Context, userID string) ([]TenantMembership, error)
 	GetTenantMembership(ctx context.Context, tenantID, userID string) (*TenantMembership, error)
+	ListTenantMemberships(ctx context.Context, tenantID string) ([]TenantMembership, error)
+	DeleteTenantMembership(ctx context.Context, tenantID, userID string) error
+	SetTenantMembershipExternalID(ctx context.Context, tenantID, userID, externalID string) error
+	ListTenants(ctx context.Context) ([]Tenant, error)
+	DeleteTenant(ctx context.Context, id string) error
+	ListTenantDomains(ctx context.Context, tenantID string) ([]TenantDomain, error)
+	GetTenantDomain(ctx context.Context, tenantID, id string) (*TenantDomain, error)
+	CreateTenantDomain(ctx context.Context, domain *TenantDomain) error
+	SetTenantDomainStatus(ctx context.Context, tenantID, id, status string) error
+	SetPrimaryTenantDomain(ctx context.Context, tenantID, id string) error
+	DeleteTenantDomain(ctx context.Context, tenantID, id string) error
+	CreateTenantInvitation(ctx context.Context, invitation *TenantInvitation) error
+	GetTenantInvitationByHash(ctx context.Context, tokenHash string) (*TenantInvitation, error)
+	ListTenantInvitations(ctx context.Context, tenantID string) ([]TenantInvitation, error)
+	DeleteTenantInvitation(ctx context.Context, tenantID, id string) error
+	MarkTenantInvitationAccepted(ctx context.Context, id string, acceptedAt time.Time) error
 	GetXWorkmateProfile(ctx context.Context, tenantID, userID, scope string) (*XWorkmateProfile, error)
 	UpsertXWorkmateProfile(ctx context.Context, profile *XWorkmateProfile) error
 }
@@ -257,6 +773,36 @@ var (
 	ErrMFANotSupported            = errors.New("mfa is not supported by the current store schema")
 	ErrSuperAdminCountingDisabled = errors.New("super administrator counting is disabled")
 	ErrSubscriptionNotFound       = errors.New("subscription not found")
+	ErrLedgerEntryExists          = errors.New("billing ledger entry already exists")
+	ErrTrafficCheckpointChanged   = errors.New("traffic checkpoint changed concurrently")
+	ErrTrafficBucketChanged       = errors.New("traffic minute bucket changed concurrently")
+	ErrRefreshTokenNotFound       = errors.New("refresh token not found")
+	ErrRefreshTokenConsumed       = errors.New("refresh token already used or revoked")
+	ErrOIDCClientNotFound         = errors.New("oidc client not found")
+	ErrOIDCClientExists           = errors.New("oidc client already exists")
+	ErrIdentityNotFound           = errors.New("identity not found")
+	ErrIdentityExists             = errors.New("identity already exists")
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already exists")
+	ErrMFARecoveryCodeNotFound    = errors.New("mfa recovery code not found or already used")
+	ErrAuditCursorNotFound        = errors.New("audit cursor not found")
+	ErrOutboxEventNotFound        = errors.New("outbox event not found")
+	ErrWebhookNotFound            = errors.New("webhook subscription not found")
+	ErrWebhookDeliveryNotFound    = errors.New("webhook delivery not found")
+	ErrSCIMTokenNotFound          = errors.New("scim token not found")
+	ErrSCIMGroupNotFound          = errors.New("scim group not found")
+	ErrSCIMGroupExists            = errors.New("scim group already exists")
+	ErrRoleNotFound               = errors.New("role not found")
+	ErrRoleExists                 = errors.New("role already exists")
+	ErrRoleBindingNotFound        = errors.New("role binding not found")
+	ErrRoleBindingExists          = errors.New("role binding already exists")
+	ErrServiceAccountNotFound     = errors.New("service account not found")
+	ErrServiceAccountExists       = errors.New("service account already exists")
+	ErrAPITokenNotFound           = errors.New("api token not found")
+	ErrAgentNotFound              = errors.New("agent not found")
+	ErrAgentExists                = errors.New("agent already exists")
+	ErrAgentTokenNotFound         = errors.New("agent token not found")
+	ErrAgentJoinTokenNotFound     = errors.New("agent join token not found, used or expired")
 )
 
 // memoryStore provides an in-memory implementation of Store. It is suitable for
@@ -271,7 +817,7 @@ type memoryStore struct {
 	subscriptions           map[string]map[string]*Subscription
 	identities              map[string]*Identity
 	agents                  map[string]*Agent
-	sessions                map[string]*sessionRecord
+	sessions                map[string]*Session
 	tenants                 map[string]*Tenant
 	tenantDomains           map[string]*TenantDomain
 	tenantMemberships       map[string]map[string]*TenantMembership
@@ -284,11 +830,25 @@ type memoryStore struct {
 	accountPolicySnapshots  map[string]*AccountPolicySnapshot
 	nodeHealthSnapshots     map[string]*NodeHealthSnapshot
 	schedulerDecisions      map[string]*SchedulerDecision
-}
-
-type sessionRecord struct {
-	UserID    string
-	ExpiresAt time.Time
+	refreshTokens           map[string]*RefreshToken
+	signingKeys             map[string]*SigningKey
+	oidcClients             map[string]*OIDCClient
+	webauthnCredentials     map[string]*WebAuthnCredential
+	mfaRecoveryCodes        map[string][]*MFARecoveryCode
+	auditEvents             []*AuditEvent
+	outboxEvents            []*OutboxEvent
+	webhookSubscriptions    map[string]*WebhookSubscription
+	webhookDeliveries       []*WebhookDelivery
+	scimTokens              map[string]*SCIMToken
+	scimGroups              map[string]*SCIMGroup
+	tenantInvitations       map[string]*TenantInvitation
+	roles                   map[string]*Role
+	roleBindings            map[string]*RoleBinding
+	serviceAccounts         map[string]*ServiceAccount
+	apiTokens               map[string]*APIToken
+	agentJoinTokens         map[string]*AgentJoinToken
+	agentTokens             map[string]*AgentToken
+	agentCertRevocations    map[string]time.Time
 }
 
 var ErrSessionNotFound = errors.New("session not found")
@@ -317,7 +877,7 @@ func newMemoryStore(allowSuperAdminCounting bool) Store {
 		subscriptions:           make(map[string]map[string]*Subscription),
 		identities:              make(map[string]*Identity),
 		agents:                  make(map[string]*Agent),
-		sessions:                make(map[string]*sessionRecord),
+		sessions:                make(map[string]*Session),
 		tenants:                 make(map[string]*Tenant),
 		tenantDomains:           make(map[string]*TenantDomain),
 		tenantMemberships:       make(map[string]map[string]*TenantMembership),
@@ -330,6 +890,22 @@ func newMemoryStore(allowSuperAdminCounting bool) Store {
 		accountPolicySnapshots:  make(map[string]*AccountPolicySnapshot),
 		nodeHealthSnapshots:     make(map[string]*NodeHealthSnapshot),
 		schedulerDecisions:      make(map[string]*SchedulerDecision),
+		refreshTokens:           make(map[string]*RefreshToken),
+		signingKeys:             make(map[string]*SigningKey),
+		oidcClients:             make(map[string]*OIDCClient),
+		webauthnCredentials:     make(map[string]*WebAuthnCredential),
+		mfaRecoveryCodes:        make(map[string][]*MFARecoveryCode),
+		webhookSubscriptions:    make(map[string]*WebhookSubscription),
+		scimTokens:              make(map[string]*SCIMToken),
+		scimGroups:              make(map[string]*SCIMGroup),
+		tenantInvitations:       make(map[string]*TenantInvitation),
+		roles:                   make(map[string]*Role),
+		roleBindings:            make(map[string]*RoleBinding),
+		serviceAccounts:         make(map[string]*ServiceAccount),
+		apiTokens:               make(map[string]*APIToken),
+		agentJoinTokens:         make(map[string]*AgentJoinToken),
+		agentTokens:             make(map[string]*AgentToken),
+		agentCertRevocations:    make(map[string]time.Time),
 	}
 }
 
@@ -594,6 +1170,25 @@ func (s *memoryStore) ListSubscriptionsByUser(ctx context.Context, userID string
 	return result, nil
 }
 
+// ListSubscriptions returns the subscriptions of every user.
+func (s *memoryStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Subscription, 0)
+	for _, subs := range s.subscriptions {
+		for _, sub := range subs {
+			result = append(result, *cloneSubscription(sub))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
 // CancelSubscription marks a subscription as cancelled.
 func (s *memoryStore) CancelSubscription(ctx context.Context, userID, externalID string, cancelledAt time.Time) (*Subscription, error) {
 	_ = ctx
@@ -753,6 +1348,14 @@ func cloneStringSlice(values []string) []string {
 	return clone
 }
 
+func cloneTimePtr(value *time.Time) *time.Time {
+	if value == nil {
+		return nil
+	}
+	clone := *value
+	return &clone
+}
+
 func cloneSubscription(sub *Subscription) *Subscription {
 	if sub == nil {
 		return nil
@@ -851,7 +1454,7 @@ func (s *memoryStore) CreateIdentity(ctx context.Context, identity *Identity) er
 
 	key := identity.Provider + ":" + identity.ExternalID
 	if _, exists := s.identities[key]; exists {
-		return errors.New("identity already exists")
+		return ErrIdentityExists
 	}
 
 	stored := *identity
@@ -888,6 +1491,16 @@ func (s *memoryStore) DeleteUser(ctx context.Context, id string) error {
 	delete(s.byID, id)
 	delete(s.byEmail, strings.ToLower(user.Email))
 	delete(s.byName, strings.ToLower(user.Name))
+	for key, binding := range s.roleBindings {
+		if binding.UserID == id {
+			delete(s.roleBindings, key)
+		}
+	}
+	for key, token := range s.apiTokens {
+		if token.UserID == id {
+			delete(s.apiTokens, key)
+		}
+	}
 	return nil
 }
 
@@ -907,6 +1520,27 @@ func (s *memoryStore) ListBlacklist(ctx context.Context) ([]string, error) {
 	return []string{}, nil
 }
 
+// CreateAgent registers a new agent, failing with ErrAgentExists when the ID
+// is taken.
+func (s *memoryStore) CreateAgent(ctx context.Context, agent *Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.agents[agent.ID]; exists {
+		return ErrAgentExists
+	}
+	now := time.Now().UTC()
+	stored := *agent
+	stored.Groups = cloneStringSlice(agent.Groups)
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.agents[agent.ID] = &stored
+
+	*agent = stored
+	agent.Groups = cloneStringSlice(stored.Groups)
+	return nil
+}
+
 func (s *memoryStore) UpsertAgent(ctx context.Context, agent *Agent) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -939,7 +1573,7 @@ func (s *memoryStore) GetAgent(ctx context.Context, id string) (*Agent, error) {
 
 	agent, ok := s.agents[id]
 	if !ok {
-		return nil, errors.New("agent not found")
+		return nil, ErrAgentNotFound
 	}
 	clone := *agent
 	clone.Groups = cloneStringSlice(agent.Groups)
@@ -967,6 +1601,28 @@ func (s *memoryStore) DeleteAgent(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.agents, id)
+	for key, token := range s.agentTokens {
+		if token.AgentID == id {
+			delete(s.agentTokens, key)
+		}
+	}
+	return nil
+}
+
+// RecordAgentCertificate notes that a certificate valid until notAfter was
+// issued to agentID.
+func (s *memoryStore) RecordAgentCertificate(ctx context.Context, agentID string, notAfter time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return ErrAgentNotFound
+	}
+	expiresAt := notAfter.UTC()
+	if agent.CertificateExpiresAt == nil || expiresAt.After(*agent.CertificateExpiresAt) {
+		agent.CertificateExpiresAt = &expiresAt
+	}
 	return nil
 }
 
@@ -974,9 +1630,24 @@ func (s *memoryStore) DeleteStaleAgents(ctx context.Context, staleThreshold time
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	cutoff := time.Now().Add(-staleThreshold)
+	// Agents created through the admin API hold tokens and are kept until
+	// an administrator deletes them; enrolled agents are kept while their
+	// certificate is valid.
+	managed := make(map[string]struct{}, len(s.agentTokens))
+	for _, token := range s.agentTokens {
+		managed[token.AgentID] = struct{}{}
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-staleThreshold)
 	count := 0
 	for id, agent := range s.agents {
+		if _, ok := managed[id]; ok {
+			continue
+		}
+		if agent.CertificateExpiresAt != nil && agent.CertificateExpiresAt.After(now) {
+			continue
+		}
 		if agent.LastHeartbeat == nil || agent.LastHeartbeat.Before(cutoff) {
 			delete(s.agents, id)
 			count++
@@ -986,12 +1657,29 @@ func (s *memoryStore) DeleteStaleAgents(ctx context.Context, staleThreshold time
 }
 
 func (s *memoryStore) CreateSession(ctx context.Context, token, userID string, expiresAt time.Time) error {
+	return s.CreateSessionRecord(ctx, &Session{Token: token, UserID: userID, ExpiresAt: expiresAt})
+}
+
+func (s *memoryStore) CreateSessionRecord(ctx context.Context, session *Session) error {
+	if session == nil || strings.TrimSpace(session.Token) == "" {
+		return ErrSessionNotFound
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.sessions[token] = &sessionRecord{
-		UserID:    userID,
-		ExpiresAt: expiresAt,
+
+	now := time.Now().UTC()
+	stored := *session
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = now
+	}
+	if stored.LastSeenAt.IsZero() {
+		stored.LastSeenAt = stored.CreatedAt
 	}
+	s.sessions[stored.Token] = &stored
+	*session = stored
 	return nil
 }
 
@@ -1008,9 +1696,75 @@ func (s *memoryStore) GetSession(ctx context.Context, token string) (string, tim
 	return sess.UserID, sess.ExpiresAt, nil
 }
 
+func (s *memoryStore) TouchSession(ctx context.Context, token string, seenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if seenAt.Sub(sess.LastSeenAt) >= SessionTouchInterval {
+		sess.LastSeenAt = seenAt.UTC()
+	}
+	return nil
+}
+
+func (s *memoryStore) ListSessionsByUser(ctx context.Context, userID string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0)
+	for _, sess := range s.sessions {
+		if sess.UserID == userID && now.Before(sess.ExpiresAt) {
+			sessions = append(sessions, *sess)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
 func (s *memoryStore) DeleteSession(ctx context.Context, token string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.sessions, token)
 	return nil
 }
+
+func (s *memoryStore) DeleteSessionByID(ctx context.Context, userID, sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, sess := range s.sessions {
+		if sess.ID == sessionID && sess.UserID == userID {
+			delete(s.sessions, token)
+			removed := *sess
+			return &removed, nil
+		}
+	}
+	return nil, ErrSessionNotFound
+}
+
+func (s *memoryStore) DeleteSessionsByUser(ctx context.Context, userID, exceptToken string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := make([]Session, 0)
+	for token, sess := range s.sessions {
+		if sess.UserID != userID || (exceptToken != "" && token == exceptToken) {
+			continue
+		}
+		removed = append(removed, *sess)
+		delete(s.sessions, token)
+	}
+	sortSessions(removed)
+	return removed, nil
+}
+
+// sortSessions orders sessions by most recent activity first.
+func sortSessions(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+}
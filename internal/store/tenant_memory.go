@@ -0,0 +1,319 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ListTenants returns every tenant ordered by name.
+func (s *memoryStore) ListTenants(ctx context.Context) ([]Tenant, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenants := make([]Tenant, 0, len(s.tenants))
+	for _, tenant := range s.tenants {
+		tenants = append(tenants, *tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		if tenants[i].Name == tenants[j].Name {
+			return tenants[i].ID < tenants[j].ID
+		}
+		return tenants[i].Name < tenants[j].Name
+	})
+	return tenants, nil
+}
+
+// DeleteTenant removes a tenant together with its domains, memberships,
+// invitations, SCIM tokens and groups, role bindings, service accounts, API
+// tokens and XWorkmate profiles. User accounts are left in place.
+func (s *memoryStore) DeleteTenant(ctx context.Context, id string) error {
+	_ = ctx
+	id = strings.TrimSpace(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[id]; !ok {
+		return ErrTenantNotFound
+	}
+	delete(s.tenants, id)
+	delete(s.tenantMemberships, id)
+	for key, domain := range s.tenantDomains {
+		if domain.TenantID == id {
+			delete(s.tenantDomains, key)
+		}
+	}
+	for key, invitation := range s.tenantInvitations {
+		if invitation.TenantID == id {
+			delete(s.tenantInvitations, key)
+		}
+	}
+	for key, token := range s.scimTokens {
+		if token.TenantID == id {
+			delete(s.scimTokens, key)
+		}
+	}
+	for key, group := range s.scimGroups {
+		if group.TenantID == id {
+			delete(s.scimGroups, key)
+		}
+	}
+	for key, binding := range s.roleBindings {
+		if binding.TenantID == id {
+			delete(s.roleBindings, key)
+		}
+	}
+	for key, account := range s.serviceAccounts {
+		if account.TenantID == id {
+			delete(s.serviceAccounts, key)
+		}
+	}
+	for key, token := range s.apiTokens {
+		if token.TenantID == id {
+			delete(s.apiTokens, key)
+		}
+	}
+	for key, profile := range s.xworkmateProfiles {
+		if profile.TenantID == id {
+			delete(s.xworkmateProfiles, key)
+		}
+	}
+	return nil
+}
+
+// ListTenantDomains returns the domains of a tenant, primary first and then
+// oldest first.
+func (s *memoryStore) ListTenantDomains(ctx context.Context, tenantID string) ([]TenantDomain, error) {
+	_ = ctx
+	tenantID = strings.TrimSpace(tenantID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domains := make([]TenantDomain, 0)
+	for _, domain := range s.tenantDomains {
+		if domain.TenantID == tenantID {
+			domains = append(domains, *domain)
+		}
+	}
+	sortTenantDomains(domains)
+	return domains, nil
+}
+
+func (s *memoryStore) GetTenantDomain(ctx context.Context, tenantID, id string) (*TenantDomain, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domain := s.tenantDomainByIDLocked(tenantID, id)
+	if domain == nil {
+		return nil, ErrTenantDomainNotFound
+	}
+	domainCopy := *domain
+	return &domainCopy, nil
+}
+
+// CreateTenantDomain adds a domain to a tenant. Unlike EnsureTenantDomain it
+// never takes over a domain that is already registered, by this tenant or
+// another one.
+func (s *memoryStore) CreateTenantDomain(ctx context.Context, domain *TenantDomain) error {
+	_ = ctx
+	if domain == nil {
+		return ErrTenantNotFound
+	}
+
+	NormalizeTenantDomain(domain)
+	if domain.Domain == "" || domain.TenantID == "" {
+		return ErrTenantNotFound
+	}
+	if domain.ID == "" {
+		domain.ID = uuid.NewString()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[domain.TenantID]; !ok {
+		return ErrTenantNotFound
+	}
+	if _, ok := s.tenantDomains[domain.Domain]; ok {
+		return ErrTenantDomainExists
+	}
+	now := time.Now().UTC()
+	stored := *domain
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.tenantDomains[stored.Domain] = &stored
+	*domain = stored
+	return nil
+}
+
+func (s *memoryStore) SetTenantDomainStatus(ctx context.Context, tenantID, id, status string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	domain := s.tenantDomainByIDLocked(tenantID, id)
+	if domain == nil {
+		return ErrTenantDomainNotFound
+	}
+	domain.Status = NormalizeTenantDomainStatus(status)
+	domain.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// SetPrimaryTenantDomain makes a domain the tenant's primary one and clears
+// the flag on its other domains.
+func (s *memoryStore) SetPrimaryTenantDomain(ctx context.Context, tenantID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	primary := s.tenantDomainByIDLocked(tenantID, id)
+	if primary == nil {
+		return ErrTenantDomainNotFound
+	}
+	now := time.Now().UTC()
+	for _, domain := range s.tenantDomains {
+		if domain.TenantID != primary.TenantID || domain.IsPrimary == (domain == primary) {
+			continue
+		}
+		domain.IsPrimary = domain == primary
+		domain.UpdatedAt = now
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteTenantDomain(ctx context.Context, tenantID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	domain := s.tenantDomainByIDLocked(tenantID, id)
+	if domain == nil {
+		return ErrTenantDomainNotFound
+	}
+	delete(s.tenantDomains, domain.Domain)
+	return nil
+}
+
+func (s *memoryStore) tenantDomainByIDLocked(tenantID, id string) *TenantDomain {
+	tenantID = strings.TrimSpace(tenantID)
+	id = strings.TrimSpace(id)
+	for _, domain := range s.tenantDomains {
+		if domain.ID == id && domain.TenantID == tenantID {
+			return domain
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateTenantInvitation(ctx context.Context, invitation *TenantInvitation) error {
+	_ = ctx
+	if invitation == nil {
+		return ErrTenantInvitationNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *invitation
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.TenantID = strings.TrimSpace(stored.TenantID)
+	stored.Email = strings.ToLower(strings.TrimSpace(stored.Email))
+	stored.Role = NormalizeTenantMembershipRole(stored.Role)
+	stored.ExpiresAt = stored.ExpiresAt.UTC()
+	stored.AcceptedAt = nil
+	stored.CreatedAt = time.Now().UTC()
+	s.tenantInvitations[stored.ID] = &stored
+	*invitation = stored
+	return nil
+}
+
+func (s *memoryStore) GetTenantInvitationByHash(ctx context.Context, tokenHash string) (*TenantInvitation, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, invitation := range s.tenantInvitations {
+		if invitation.TokenHash == tokenHash {
+			return cloneTenantInvitation(invitation), nil
+		}
+	}
+	return nil, ErrTenantInvitationNotFound
+}
+
+// ListTenantInvitations returns the invitations of a tenant, accepted and
+// expired ones included, oldest first.
+func (s *memoryStore) ListTenantInvitations(ctx context.Context, tenantID string) ([]TenantInvitation, error) {
+	_ = ctx
+	tenantID = strings.TrimSpace(tenantID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	invitations := make([]TenantInvitation, 0)
+	for _, invitation := range s.tenantInvitations {
+		if invitation.TenantID == tenantID {
+			invitations = append(invitations, *cloneTenantInvitation(invitation))
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		if !invitations[i].CreatedAt.Equal(invitations[j].CreatedAt) {
+			return invitations[i].CreatedAt.Before(invitations[j].CreatedAt)
+		}
+		return invitations[i].ID < invitations[j].ID
+	})
+	return invitations, nil
+}
+
+func (s *memoryStore) DeleteTenantInvitation(ctx context.Context, tenantID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, ok := s.tenantInvitations[strings.TrimSpace(id)]
+	if !ok || invitation.TenantID != strings.TrimSpace(tenantID) {
+		return ErrTenantInvitationNotFound
+	}
+	delete(s.tenantInvitations, invitation.ID)
+	return nil
+}
+
+// MarkTenantInvitationAccepted records that an invitation was used. It fails
+// with ErrTenantInvitationNotFound when the invitation was already accepted,
+// so concurrent attempts cannot both succeed.
+func (s *memoryStore) MarkTenantInvitationAccepted(ctx context.Context, id string, acceptedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, ok := s.tenantInvitations[strings.TrimSpace(id)]
+	if !ok || invitation.AcceptedAt != nil {
+		return ErrTenantInvitationNotFound
+	}
+	at := acceptedAt.UTC()
+	invitation.AcceptedAt = &at
+	return nil
+}
+
+func cloneTenantInvitation(invitation *TenantInvitation) *TenantInvitation {
+	cloned := *invitation
+	cloned.AcceptedAt = cloneTimePtr(invitation.AcceptedAt)
+	return &cloned
+}
+
+func sortTenantDomains(domains []TenantDomain) {
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].IsPrimary != domains[j].IsPrimary {
+			return domains[i].IsPrimary
+		}
+		if !domains[i].CreatedAt.Equal(domains[j].CreatedAt) {
+			return domains[i].CreatedAt.Before(domains[j].CreatedAt)
+		}
+		return domains[i].Domain < domains[j].Domain
+	})
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tenantDomainColumns     = "id, tenant_id, domain, kind, is_primary, status, verification_token, created_at, updated_at"
+	tenantInvitationColumns = "id, tenant_id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at"
+)
+
+func scanTenantDomain(row interface{ Scan(...any) error }) (*TenantDomain, error) {
+	var domain TenantDomain
+	if err := row.Scan(
+		&domain.ID,
+		&domain.TenantID,
+		&domain.Domain,
+		&domain.Kind,
+		&domain.IsPrimary,
+		&domain.Status,
+		&domain.VerificationToken,
+		&domain.CreatedAt,
+		&domain.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+func scanTenantInvitation(row interface{ Scan(...any) error }) (*TenantInvitation, error) {
+	var (
+		invitation TenantInvitation
+		acceptedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&invitation.ID,
+		&invitation.TenantID,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.TokenHash,
+		&invitation.InvitedBy,
+		&invitation.ExpiresAt,
+		&acceptedAt,
+		&invitation.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if acceptedAt.Valid {
+		at := acceptedAt.Time.UTC()
+		invitation.AcceptedAt = &at
+	}
+	invitation.ExpiresAt = invitation.ExpiresAt.UTC()
+	invitation.CreatedAt = invitation.CreatedAt.UTC()
+	return &invitation, nil
+}
+
+// ListTenants returns every tenant ordered by name.
+func (s *postgresStore) ListTenants(ctx context.Context) ([]Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, edition, created_at, updated_at FROM tenants ORDER BY name ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make([]Tenant, 0)
+	for rows.Next() {
+		var tenant Tenant
+		if err := rows.Scan(&tenant.ID, &tenant.Name, &tenant.Edition, &tenant.CreatedAt, &tenant.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+// DeleteTenant removes a tenant together with its domains, memberships,
+// invitations, SCIM tokens and groups, role bindings, service accounts, API
+// tokens and XWorkmate profiles. User accounts are left in place.
+func (s *postgresStore) DeleteTenant(ctx context.Context, id string) error {
+	id = strings.TrimSpace(id)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{
+		"tenant_domains",
+		"tenant_memberships",
+		"tenant_invitations",
+		"scim_tokens",
+		"scim_groups",
+		"rbac_role_bindings",
+		"api_tokens",
+		"service_accounts",
+		"xworkmate_profiles",
+	} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE tenant_id = $1", id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM tenants WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantNotFound
+	}
+	return tx.Commit()
+}
+
+// ListTenantDomains returns the domains of a tenant, primary first and then
+// oldest first.
+func (s *postgresStore) ListTenantDomains(ctx context.Context, tenantID string) ([]TenantDomain, error) {
+	query := "SELECT " + tenantDomainColumns + " FROM tenant_domains WHERE tenant_id = $1 ORDER BY is_primary DESC, created_at ASC, domain ASC"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := make([]TenantDomain, 0)
+	for rows.Next() {
+		domain, err := scanTenantDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, *domain)
+	}
+	return domains, rows.Err()
+}
+
+func (s *postgresStore) GetTenantDomain(ctx context.Context, tenantID, id string) (*TenantDomain, error) {
+	query := "SELECT " + tenantDomainColumns + " FROM tenant_domains WHERE tenant_id = $1 AND id = $2"
+	domain, err := scanTenantDomain(s.db.QueryRowContext(ctx, query, strings.TrimSpace(tenantID), strings.TrimSpace(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantDomainNotFound
+	}
+	return domain, err
+}
+
+// CreateTenantDomain adds a domain to a tenant. Unlike EnsureTenantDomain it
+// never takes over a domain that is already registered, by this tenant or
+// another one.
+func (s *postgresStore) CreateTenantDomain(ctx context.Context, domain *TenantDomain) error {
+	if domain == nil {
+		return ErrTenantNotFound
+	}
+
+	NormalizeTenantDomain(domain)
+	if domain.Domain == "" || domain.TenantID == "" {
+		return ErrTenantNotFound
+	}
+	if domain.ID == "" {
+		domain.ID = uuid.NewString()
+	}
+
+	const query = `INSERT INTO tenant_domains (id, tenant_id, domain, kind, is_primary, status, verification_token, created_at, updated_at)
+SELECT $1, t.id, $3, $4, $5, $6, $7, now(), now()
+FROM tenants t
+WHERE t.id = $2
+RETURNING created_at, updated_at`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		domain.ID,
+		domain.TenantID,
+		domain.Domain,
+		domain.Kind,
+		domain.IsPrimary,
+		domain.Status,
+		domain.VerificationToken,
+	).Scan(&domain.CreatedAt, &domain.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrTenantNotFound
+	case isUniqueViolation(err):
+		return ErrTenantDomainExists
+	}
+	return err
+}
+
+func (s *postgresStore) SetTenantDomainStatus(ctx context.Context, tenantID, id, status string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE tenant_domains SET status = $3, updated_at = now() WHERE tenant_id = $1 AND id = $2",
+		strings.TrimSpace(tenantID), strings.TrimSpace(id), NormalizeTenantDomainStatus(status))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantDomainNotFound
+	}
+	return nil
+}
+
+// SetPrimaryTenantDomain makes a domain the tenant's primary one and clears
+// the flag on its other domains.
+func (s *postgresStore) SetPrimaryTenantDomain(ctx context.Context, tenantID, id string) error {
+	tenantID = strings.TrimSpace(tenantID)
+	id = strings.TrimSpace(id)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM tenant_domains WHERE tenant_id = $1 AND id = $2)",
+		tenantID, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTenantDomainNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tenant_domains
+SET is_primary = (id = $2), updated_at = now()
+WHERE tenant_id = $1 AND is_primary <> (id = $2)`, tenantID, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) DeleteTenantDomain(ctx context.Context, tenantID, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tenant_domains WHERE tenant_id = $1 AND id = $2",
+		strings.TrimSpace(tenantID), strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantDomainNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) CreateTenantInvitation(ctx context.Context, invitation *TenantInvitation) error {
+	if invitation == nil {
+		return ErrTenantInvitationNotFound
+	}
+	if invitation.ID == "" {
+		invitation.ID = uuid.NewString()
+	}
+	invitation.TenantID = strings.TrimSpace(invitation.TenantID)
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	invitation.Role = NormalizeTenantMembershipRole(invitation.Role)
+	invitation.ExpiresAt = invitation.ExpiresAt.UTC()
+	invitation.AcceptedAt = nil
+
+	const query = `
+		INSERT INTO tenant_invitations (id, tenant_id, email, role, token_hash, invited_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+		RETURNING created_at`
+	if err := s.db.QueryRowContext(ctx, query,
+		invitation.ID,
+		invitation.TenantID,
+		invitation.Email,
+		invitation.Role,
+		invitation.TokenHash,
+		invitation.InvitedBy,
+		invitation.ExpiresAt,
+	).Scan(&invitation.CreatedAt); err != nil {
+		return err
+	}
+	invitation.CreatedAt = invitation.CreatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetTenantInvitationByHash(ctx context.Context, tokenHash string) (*TenantInvitation, error) {
+	query := "SELECT " + tenantInvitationColumns + " FROM tenant_invitations WHERE token_hash = $1"
+	invitation, err := scanTenantInvitation(s.db.QueryRowContext(ctx, query, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantInvitationNotFound
+	}
+	return invitation, err
+}
+
+// ListTenantInvitations returns the invitations of a tenant, accepted and
+// expired ones included, oldest first.
+func (s *postgresStore) ListTenantInvitations(ctx context.Context, tenantID string) ([]TenantInvitation, error) {
+	query := "SELECT " + tenantInvitationColumns + " FROM tenant_invitations WHERE tenant_id = $1 ORDER BY created_at, id"
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := make([]TenantInvitation, 0)
+	for rows.Next() {
+		invitation, err := scanTenantInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *invitation)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *postgresStore) DeleteTenantInvitation(ctx context.Context, tenantID, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tenant_invitations WHERE tenant_id = $1 AND id = $2",
+		strings.TrimSpace(tenantID), strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantInvitationNotFound
+	}
+	return nil
+}
+
+// MarkTenantInvitationAccepted records that an invitation was used. It fails
+// with ErrTenantInvitationNotFound when the invitation was already accepted,
+// so concurrent attempts cannot both succeed.
+func (s *postgresStore) MarkTenantInvitationAccepted(ctx context.Context, id string, acceptedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE tenant_invitations SET accepted_at = $2 WHERE id = $1 AND accepted_at IS NULL",
+		strings.TrimSpace(id), acceptedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantInvitationNotFound
+	}
+	return nil
+}
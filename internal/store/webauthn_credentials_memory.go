@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *memoryStore) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	_ = ctx
+	if credential == nil || strings.TrimSpace(credential.ID) == "" || strings.TrimSpace(credential.UserID) == "" {
+		return errors.New("webauthn credential id and user id are required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.webauthnCredentials[credential.ID]; exists {
+		return ErrWebAuthnCredentialExists
+	}
+	credential.CreatedAt = time.Now().UTC()
+	credential.LastUsedAt = nil
+	s.webauthnCredentials[credential.ID] = cloneWebAuthnCredential(credential)
+	return nil
+}
+
+// ListWebAuthnCredentialsByUser returns the user's credentials, oldest first.
+func (s *memoryStore) ListWebAuthnCredentialsByUser(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credentials := make([]WebAuthnCredential, 0)
+	for _, credential := range s.webauthnCredentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, *cloneWebAuthnCredential(credential))
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		if credentials[i].CreatedAt.Equal(credentials[j].CreatedAt) {
+			return credentials[i].ID < credentials[j].ID
+		}
+		return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+	})
+	return credentials, nil
+}
+
+func (s *memoryStore) UpdateWebAuthnCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webauthnCredentials[id]
+	if !ok {
+		return ErrWebAuthnCredentialNotFound
+	}
+	used := usedAt.UTC()
+	credential.SignCount = signCount
+	credential.BackupState = backupState
+	credential.LastUsedAt = &used
+	return nil
+}
+
+func (s *memoryStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webauthnCredentials[id]
+	if !ok || credential.UserID != userID {
+		return ErrWebAuthnCredentialNotFound
+	}
+	delete(s.webauthnCredentials, id)
+	return nil
+}
+
+func cloneWebAuthnCredential(credential *WebAuthnCredential) *WebAuthnCredential {
+	cloned := *credential
+	cloned.PublicKey = append([]byte(nil), credential.PublicKey...)
+	cloned.AAGUID = append([]byte(nil), credential.AAGUID...)
+	cloned.Transports = cloneStringSlice(credential.Transports)
+	if credential.LastUsedAt != nil {
+		used := *credential.LastUsedAt
+		cloned.LastUsedAt = &used
+	}
+	return &cloned
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const webauthnCredentialColumns = "credential_id, user_uuid, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, created_at, last_used_at"
+
+func (s *postgresStore) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	if credential == nil || strings.TrimSpace(credential.ID) == "" || strings.TrimSpace(credential.UserID) == "" {
+		return errors.New("webauthn credential id and user id are required")
+	}
+	transports, err := encodeStringSlice(credential.Transports)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO webauthn_credentials (credential_id, user_uuid, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING created_at`
+	err = s.db.QueryRowContext(ctx, query, credential.ID, credential.UserID, credential.Name, credential.PublicKey,
+		credential.AttestationType, transports, credential.AAGUID, int64(credential.SignCount),
+		credential.BackupEligible, credential.BackupState).Scan(&credential.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrWebAuthnCredentialExists
+		}
+		return err
+	}
+	credential.CreatedAt = credential.CreatedAt.UTC()
+	credential.LastUsedAt = nil
+	return nil
+}
+
+// ListWebAuthnCredentialsByUser returns the user's credentials, oldest first.
+func (s *postgresStore) ListWebAuthnCredentialsByUser(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return []WebAuthnCredential{}, nil
+	}
+	query := "SELECT " + webauthnCredentialColumns + " FROM webauthn_credentials WHERE user_uuid = $1 ORDER BY created_at, credential_id"
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make([]WebAuthnCredential, 0)
+	for rows.Next() {
+		var (
+			credential WebAuthnCredential
+			transports []byte
+			signCount  int64
+			lastUsedAt sql.NullTime
+		)
+		if err := rows.Scan(&credential.ID, &credential.UserID, &credential.Name, &credential.PublicKey,
+			&credential.AttestationType, &transports, &credential.AAGUID, &signCount,
+			&credential.BackupEligible, &credential.BackupState, &credential.CreatedAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		credential.Transports = decodeStringSlice(transports)
+		credential.SignCount = uint32(signCount)
+		credential.CreatedAt = credential.CreatedAt.UTC()
+		if lastUsedAt.Valid {
+			used := lastUsedAt.Time.UTC()
+			credential.LastUsedAt = &used
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (s *postgresStore) UpdateWebAuthnCredentialUsage(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error {
+	const query = "UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = $4 WHERE credential_id = $1"
+	result, err := s.db.ExecContext(ctx, query, id, int64(signCount), backupState, usedAt.UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrWebAuthnCredentialNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE credential_id = $1 AND user_uuid = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
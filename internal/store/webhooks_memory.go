@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AppendOutboxEvent stores event and queues a delivery for every active
+// subscription that accepts its type.
+func (s *memoryStore) AppendOutboxEvent(ctx context.Context, event *OutboxEvent) error {
+	_ = ctx
+	if event == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := cloneOutboxEvent(event)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now()
+	}
+	stored.CreatedAt = stored.CreatedAt.UTC()
+	s.outboxEvents = append(s.outboxEvents, stored)
+	for _, subscription := range s.sortedWebhookSubscriptionsLocked() {
+		if subscription.Accepts(stored.Type) {
+			s.queueWebhookDeliveryLocked(stored.ID, subscription.ID, stored.CreatedAt)
+		}
+	}
+	*event = *cloneOutboxEvent(stored)
+	return nil
+}
+
+func (s *memoryStore) GetOutboxEvent(ctx context.Context, id string) (*OutboxEvent, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event := s.outboxEventLocked(strings.TrimSpace(id))
+	if event == nil {
+		return nil, ErrOutboxEventNotFound
+	}
+	return cloneOutboxEvent(event), nil
+}
+
+// ListOutboxEvents returns the newest events, optionally of one type only.
+func (s *memoryStore) ListOutboxEvents(ctx context.Context, eventType string, limit int) ([]OutboxEvent, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]OutboxEvent, 0)
+	for i := len(s.outboxEvents) - 1; i >= 0; i-- {
+		event := s.outboxEvents[i]
+		if eventType != "" && event.Type != eventType {
+			continue
+		}
+		events = append(events, *cloneOutboxEvent(event))
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryStore) CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	_ = ctx
+	if subscription == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	stored := cloneWebhookSubscription(subscription)
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+	stored.EventTypes = normalizeStringSlice(stored.EventTypes)
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.webhookSubscriptions[stored.ID] = stored
+	*subscription = *cloneWebhookSubscription(stored)
+	return nil
+}
+
+func (s *memoryStore) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscription, ok := s.webhookSubscriptions[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return cloneWebhookSubscription(subscription), nil
+}
+
+// ListWebhookSubscriptions returns every subscription, oldest first.
+func (s *memoryStore) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscriptions := make([]WebhookSubscription, 0, len(s.webhookSubscriptions))
+	for _, subscription := range s.sortedWebhookSubscriptionsLocked() {
+		subscriptions = append(subscriptions, *cloneWebhookSubscription(subscription))
+	}
+	return subscriptions, nil
+}
+
+func (s *memoryStore) UpdateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	_ = ctx
+	if subscription == nil {
+		return ErrWebhookNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.webhookSubscriptions[subscription.ID]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	stored.URL = subscription.URL
+	stored.Description = subscription.Description
+	stored.Secret = subscription.Secret
+	stored.EventTypes = normalizeStringSlice(subscription.EventTypes)
+	stored.Active = subscription.Active
+	stored.UpdatedAt = time.Now().UTC()
+	*subscription = *cloneWebhookSubscription(stored)
+	return nil
+}
+
+// DeleteWebhookSubscription removes the subscription together with its
+// delivery log.
+func (s *memoryStore) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id = strings.TrimSpace(id)
+	if _, ok := s.webhookSubscriptions[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhookSubscriptions, id)
+	kept := s.webhookDeliveries[:0]
+	for _, delivery := range s.webhookDeliveries {
+		if delivery.SubscriptionID != id {
+			kept = append(kept, delivery)
+		}
+	}
+	s.webhookDeliveries = kept
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues the event again. With a subscription ID
+// only that subscription receives it, whatever its event types; otherwise
+// every active subscription accepting the event type does.
+func (s *memoryStore) EnqueueWebhookDeliveries(ctx context.Context, eventID, subscriptionID string) ([]WebhookDelivery, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event := s.outboxEventLocked(strings.TrimSpace(eventID))
+	if event == nil {
+		return nil, ErrOutboxEventNotFound
+	}
+	var targets []*WebhookSubscription
+	if subscriptionID = strings.TrimSpace(subscriptionID); subscriptionID != "" {
+		subscription, ok := s.webhookSubscriptions[subscriptionID]
+		if !ok {
+			return nil, ErrWebhookNotFound
+		}
+		targets = append(targets, subscription)
+	} else {
+		for _, subscription := range s.sortedWebhookSubscriptionsLocked() {
+			if subscription.Accepts(event.Type) {
+				targets = append(targets, subscription)
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	deliveries := make([]WebhookDelivery, 0, len(targets))
+	for _, subscription := range targets {
+		deliveries = append(deliveries, *s.queueWebhookDeliveryLocked(event.ID, subscription.ID, now))
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDeliveries returns pending deliveries of active subscriptions
+// that are due at now, pushing their next attempt back by lease so no other
+// dispatcher picks them up meanwhile.
+func (s *memoryStore) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]*WebhookDelivery, 0)
+	for _, delivery := range s.webhookDeliveries {
+		if delivery.Status != WebhookDeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		if subscription, ok := s.webhookSubscriptions[delivery.SubscriptionID]; !ok || !subscription.Active {
+			continue
+		}
+		due = append(due, delivery)
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]WebhookDelivery, 0, len(due))
+	for _, delivery := range due {
+		delivery.NextAttemptAt = now.Add(lease).UTC()
+		claimed = append(claimed, *cloneWebhookDelivery(delivery))
+	}
+	return claimed, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt.
+func (s *memoryStore) UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_ = ctx
+	if delivery == nil {
+		return ErrWebhookDeliveryNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.webhookDeliveries {
+		if stored.ID != delivery.ID {
+			continue
+		}
+		stored.Status = delivery.Status
+		stored.Attempts = delivery.Attempts
+		stored.NextAttemptAt = delivery.NextAttemptAt.UTC()
+		stored.LastAttemptAt = cloneTimePtr(delivery.LastAttemptAt)
+		stored.ResponseStatus = delivery.ResponseStatus
+		stored.LastError = delivery.LastError
+		stored.DeliveredAt = cloneTimePtr(delivery.DeliveredAt)
+		return nil
+	}
+	return ErrWebhookDeliveryNotFound
+}
+
+// ListWebhookDeliveries returns matching deliveries newest first.
+func (s *memoryStore) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]WebhookDelivery, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for i := len(s.webhookDeliveries) - 1; i >= 0; i-- {
+		delivery := s.webhookDeliveries[i]
+		switch {
+		case filter.SubscriptionID != "" && delivery.SubscriptionID != filter.SubscriptionID,
+			filter.EventID != "" && delivery.EventID != filter.EventID,
+			filter.Status != "" && delivery.Status != filter.Status:
+			continue
+		}
+		deliveries = append(deliveries, *cloneWebhookDelivery(delivery))
+		if filter.Limit > 0 && len(deliveries) >= filter.Limit {
+			break
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *memoryStore) outboxEventLocked(id string) *OutboxEvent {
+	for _, event := range s.outboxEvents {
+		if event.ID == id {
+			return event
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) sortedWebhookSubscriptionsLocked() []*WebhookSubscription {
+	subscriptions := make([]*WebhookSubscription, 0, len(s.webhookSubscriptions))
+	for _, subscription := range s.webhookSubscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions
+}
+
+func (s *memoryStore) queueWebhookDeliveryLocked(eventID, subscriptionID string, now time.Time) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		ID:             uuid.NewString(),
+		EventID:        eventID,
+		SubscriptionID: subscriptionID,
+		Status:         WebhookDeliveryPending,
+		NextAttemptAt:  now.UTC(),
+		CreatedAt:      now.UTC(),
+	}
+	s.webhookDeliveries = append(s.webhookDeliveries, delivery)
+	return cloneWebhookDelivery(delivery)
+}
+
+func cloneOutboxEvent(event *OutboxEvent) *OutboxEvent {
+	cloned := *event
+	cloned.Data = maps.Clone(event.Data)
+	return &cloned
+}
+
+func cloneWebhookSubscription(subscription *WebhookSubscription) *WebhookSubscription {
+	cloned := *subscription
+	cloned.EventTypes = cloneStringSlice(subscription.EventTypes)
+	return &cloned
+}
+
+func cloneWebhookDelivery(delivery *WebhookDelivery) *WebhookDelivery {
+	cloned := *delivery
+	cloned.LastAttemptAt = cloneTimePtr(delivery.LastAttemptAt)
+	cloned.DeliveredAt = cloneTimePtr(delivery.DeliveredAt)
+	return &cloned
+}
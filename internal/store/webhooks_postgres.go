@@ -0,0 +1,407 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	outboxEventColumns         = "id, event_type, subject_type, subject_id, data, created_at"
+	webhookSubscriptionColumns = "id, url, description, secret, event_types, active, created_at, updated_at"
+	webhookDeliveryColumns     = "id, event_id, subscription_id, status, attempts, next_attempt_at, last_attempt_at, response_status, last_error, delivered_at, created_at"
+)
+
+func scanOutboxEvent(row interface{ Scan(...any) error }) (*OutboxEvent, error) {
+	var (
+		event OutboxEvent
+		data  []byte
+	)
+	if err := row.Scan(&event.ID, &event.Type, &event.SubjectType, &event.SubjectID, &data, &event.CreatedAt); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &event.Data); err != nil {
+			return nil, err
+		}
+	}
+	event.CreatedAt = event.CreatedAt.UTC()
+	return &event, nil
+}
+
+func scanWebhookSubscription(row interface{ Scan(...any) error }) (*WebhookSubscription, error) {
+	var (
+		subscription WebhookSubscription
+		eventTypes   []byte
+	)
+	if err := row.Scan(&subscription.ID, &subscription.URL, &subscription.Description, &subscription.Secret, &eventTypes,
+		&subscription.Active, &subscription.CreatedAt, &subscription.UpdatedAt); err != nil {
+		return nil, err
+	}
+	subscription.EventTypes = decodeStringSlice(eventTypes)
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	subscription.UpdatedAt = subscription.UpdatedAt.UTC()
+	return &subscription, nil
+}
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var (
+		delivery                   WebhookDelivery
+		lastAttemptAt, deliveredAt sql.NullTime
+	)
+	if err := row.Scan(&delivery.ID, &delivery.EventID, &delivery.SubscriptionID, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &lastAttemptAt, &delivery.ResponseStatus, &delivery.LastError, &deliveredAt, &delivery.CreatedAt); err != nil {
+		return nil, err
+	}
+	if lastAttemptAt.Valid {
+		at := lastAttemptAt.Time.UTC()
+		delivery.LastAttemptAt = &at
+	}
+	if deliveredAt.Valid {
+		at := deliveredAt.Time.UTC()
+		delivery.DeliveredAt = &at
+	}
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	delivery.CreatedAt = delivery.CreatedAt.UTC()
+	return &delivery, nil
+}
+
+func collectWebhookDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// AppendOutboxEvent stores event and, in the same transaction, queues a
+// delivery for every active subscription that accepts its type.
+func (s *postgresStore) AppendOutboxEvent(ctx context.Context, event *OutboxEvent) error {
+	if event == nil {
+		return nil
+	}
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	event.CreatedAt = event.CreatedAt.UTC()
+	data, err := encodeAuditFields(event.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO event_outbox (id, event_type, subject_type, subject_id, data, created_at)
+		VALUES ($1, $2, $3, $4, $5::jsonb, $6)`,
+		event.ID, event.Type, event.SubjectType, event.SubjectID, data, event.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, event_id, subscription_id, next_attempt_at, created_at)
+		SELECT gen_random_uuid(), $1, id, $2, $2
+		FROM webhook_subscriptions
+		WHERE active AND (event_types = '[]'::jsonb OR event_types @> jsonb_build_array($3::text))
+		ORDER BY created_at, id`,
+		event.ID, event.CreatedAt, event.Type); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) GetOutboxEvent(ctx context.Context, id string) (*OutboxEvent, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return nil, ErrOutboxEventNotFound
+	}
+	query := "SELECT " + outboxEventColumns + " FROM event_outbox WHERE id = $1"
+	event, err := scanOutboxEvent(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOutboxEventNotFound
+	}
+	return event, err
+}
+
+// ListOutboxEvents returns the newest events, optionally of one type only.
+func (s *postgresStore) ListOutboxEvents(ctx context.Context, eventType string, limit int) ([]OutboxEvent, error) {
+	var args []any
+	query := "SELECT " + outboxEventColumns + " FROM event_outbox"
+	if eventType != "" {
+		args = append(args, eventType)
+		query += " WHERE event_type = $1"
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]OutboxEvent, 0)
+	for rows.Next() {
+		event, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+func (s *postgresStore) CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	if subscription == nil {
+		return nil
+	}
+	if subscription.ID == "" {
+		subscription.ID = uuid.NewString()
+	}
+	eventTypes, err := encodeStringSlice(subscription.EventTypes)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO webhook_subscriptions (id, url, description, secret, event_types, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at, updated_at`
+	if err := s.db.QueryRowContext(ctx, query, subscription.ID, subscription.URL, subscription.Description, subscription.Secret,
+		eventTypes, subscription.Active).Scan(&subscription.CreatedAt, &subscription.UpdatedAt); err != nil {
+		return err
+	}
+	subscription.EventTypes = normalizeStringSlice(subscription.EventTypes)
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	subscription.UpdatedAt = subscription.UpdatedAt.UTC()
+	return nil
+}
+
+func (s *postgresStore) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	query := "SELECT " + webhookSubscriptionColumns + " FROM webhook_subscriptions WHERE id = $1"
+	subscription, err := scanWebhookSubscription(s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	return subscription, err
+}
+
+// ListWebhookSubscriptions returns every subscription, oldest first.
+func (s *postgresStore) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := make([]WebhookSubscription, 0)
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (s *postgresStore) UpdateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	if subscription == nil {
+		return ErrWebhookNotFound
+	}
+	if _, err := uuid.Parse(subscription.ID); err != nil {
+		return ErrWebhookNotFound
+	}
+	eventTypes, err := encodeStringSlice(subscription.EventTypes)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		UPDATE webhook_subscriptions
+		SET url = $2, description = $3, secret = $4, event_types = $5, active = $6, updated_at = now()
+		WHERE id = $1
+		RETURNING created_at, updated_at`
+	err = s.db.QueryRowContext(ctx, query, subscription.ID, subscription.URL, subscription.Description, subscription.Secret,
+		eventTypes, subscription.Active).Scan(&subscription.CreatedAt, &subscription.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	subscription.EventTypes = normalizeStringSlice(subscription.EventTypes)
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	subscription.UpdatedAt = subscription.UpdatedAt.UTC()
+	return nil
+}
+
+// DeleteWebhookSubscription removes the subscription; its delivery log goes
+// with it through the foreign key.
+func (s *postgresStore) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(strings.TrimSpace(id)); err != nil {
+		return ErrWebhookNotFound
+	}
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", strings.TrimSpace(id))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues the event again. With a subscription ID
+// only that subscription receives it, whatever its event types; otherwise
+// every active subscription accepting the event type does.
+func (s *postgresStore) EnqueueWebhookDeliveries(ctx context.Context, eventID, subscriptionID string) ([]WebhookDelivery, error) {
+	event, err := s.GetOutboxEvent(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{event.ID, time.Now().UTC()}
+	query := `
+		INSERT INTO webhook_deliveries (id, event_id, subscription_id, next_attempt_at, created_at)
+		SELECT gen_random_uuid(), $1, id, $2, $2
+		FROM webhook_subscriptions`
+	if subscriptionID = strings.TrimSpace(subscriptionID); subscriptionID != "" {
+		if _, err := s.GetWebhookSubscription(ctx, subscriptionID); err != nil {
+			return nil, err
+		}
+		args = append(args, subscriptionID)
+		query += " WHERE id = $3"
+	} else {
+		args = append(args, event.Type)
+		query += " WHERE active AND (event_types = '[]'::jsonb OR event_types @> jsonb_build_array($3::text))"
+	}
+	query += " RETURNING " + webhookDeliveryColumns
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhookDeliveries(rows)
+}
+
+// ClaimWebhookDeliveries returns pending deliveries of active subscriptions
+// that are due at now, pushing their next attempt back by lease so other
+// replicas skip them. SKIP LOCKED keeps concurrent claims from overlapping.
+func (s *postgresStore) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	query := `
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhook_subscriptions s ON s.id = d.subscription_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= $1 AND s.active
+			ORDER BY d.next_attempt_at
+			LIMIT $3
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+	rows, err := s.db.QueryContext(ctx, query, now.UTC(), now.Add(lease).UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhookDeliveries(rows)
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt.
+func (s *postgresStore) UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	if delivery == nil {
+		return ErrWebhookDeliveryNotFound
+	}
+	if _, err := uuid.Parse(delivery.ID); err != nil {
+		return ErrWebhookDeliveryNotFound
+	}
+	const query = `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = $5, response_status = $6, last_error = $7, delivered_at = $8
+		WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC(),
+		delivery.LastAttemptAt, delivery.ResponseStatus, delivery.LastError, delivery.DeliveredAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebhookDeliveryNotFound
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns matching deliveries newest first.
+func (s *postgresStore) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]WebhookDelivery, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	for _, field := range []struct {
+		column, value string
+		id            bool
+	}{
+		{"subscription_id", filter.SubscriptionID, true},
+		{"event_id", filter.EventID, true},
+		{"status", filter.Status, false},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if _, err := uuid.Parse(field.value); field.id && err != nil {
+			return []WebhookDelivery{}, nil
+		}
+		args = append(args, field.value)
+		conditions = append(conditions, field.column+" = $"+strconv.Itoa(len(args)))
+	}
+
+	query := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhookDeliveries(rows)
+}
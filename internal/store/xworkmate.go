@@ -46,6 +46,9 @@ const (
 var (
 	ErrTenantNotFound           = errors.New("tenant not found")
 	ErrTenantMembershipNotFound = errors.New("tenant membership not found")
+	ErrTenantDomainNotFound     = errors.New("tenant domain not found")
+	ErrTenantDomainExists       = errors.New("tenant domain already exists")
+	ErrTenantInvitationNotFound = errors.New("tenant invitation not found")
 	ErrXWorkmateProfileNotFound = errors.New("xworkmate profile not found")
 )
 
@@ -57,21 +60,33 @@ type Tenant struct {
 	UpdatedAt time.Time
 }
 
+// TenantDomain is a hostname that resolves to a tenant. Custom domains start
+// out pending and only resolve once verified; VerificationToken is the value
+// the tenant publishes in DNS to prove it controls the domain.
 type TenantDomain struct {
-	ID        string
-	TenantID  string
-	Domain    string
-	Kind      string
-	IsPrimary bool
-	Status    string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID                string
+	TenantID          string
+	Domain            string
+	Kind              string
+	IsPrimary         bool
+	Status            string
+	VerificationToken string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
+// TenantMembership links a user to a tenant. ExternalID is the identifier
+// the tenant's identity provider uses for the user when provisioning it over
+// SCIM; it is kept apart from the role and only changed through
+// SetTenantMembershipExternalID. Provisioned is set when SCIM created the
+// account along with the membership; it is only stored when the membership
+// is first created.
 type TenantMembership struct {
 	TenantID      string
 	UserID        string
 	Role          string
+	ExternalID    string
+	Provisioned   bool
 	TenantName    string
 	TenantEdition string
 	Domain        string
@@ -79,6 +94,21 @@ type TenantMembership struct {
 	UpdatedAt     time.Time
 }
 
+// TenantInvitation asks the owner of Email to join a tenant with Role. Only
+// the SHA-256 of the token sent by email is stored. An invitation can be
+// accepted once, before ExpiresAt.
+type TenantInvitation struct {
+	ID         string
+	TenantID   string
+	Email      string
+	Role       string
+	TokenHash  string
+	InvitedBy  string
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	CreatedAt  time.Time
+}
+
 type XWorkmateProfile struct {
 	ID                 string
 	TenantID           string
@@ -55,6 +55,19 @@ func (s *memoryStore) EnsureTenant(ctx context.Context, tenant *Tenant) error {
 	return nil
 }
 
+func (s *memoryStore) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant, ok := s.tenants[strings.TrimSpace(id)]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	tenantCopy := *tenant
+	return &tenantCopy, nil
+}
+
 func (s *memoryStore) EnsureTenantDomain(ctx context.Context, domain *TenantDomain) error {
 	_ = ctx
 	if domain == nil {
@@ -86,14 +99,15 @@ func (s *memoryStore) EnsureTenantDomain(ctx context.Context, domain *TenantDoma
 	}
 
 	stored := &TenantDomain{
-		ID:        domain.ID,
-		TenantID:  domain.TenantID,
-		Domain:    domain.Domain,
-		Kind:      domain.Kind,
-		IsPrimary: domain.IsPrimary,
-		Status:    domain.Status,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:                domain.ID,
+		TenantID:          domain.TenantID,
+		Domain:            domain.Domain,
+		Kind:              domain.Kind,
+		IsPrimary:         domain.IsPrimary,
+		Status:            domain.Status,
+		VerificationToken: domain.VerificationToken,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 	s.tenantDomains[stored.Domain] = stored
 	domain.CreatedAt = stored.CreatedAt
@@ -129,11 +143,12 @@ func (s *memoryStore) UpsertTenantMembership(ctx context.Context, membership *Te
 	}
 
 	stored := &TenantMembership{
-		TenantID:  membership.TenantID,
-		UserID:    membership.UserID,
-		Role:      membership.Role,
-		CreatedAt: now,
-		UpdatedAt: now,
+		TenantID:    membership.TenantID,
+		UserID:      membership.UserID,
+		Role:        membership.Role,
+		Provisioned: membership.Provisioned,
+		CreatedAt:   now,
+		UpdatedAt:   now,
 	}
 	s.tenantMemberships[membership.TenantID][membership.UserID] = stored
 	membership.CreatedAt = stored.CreatedAt
@@ -163,7 +178,7 @@ func (s *memoryStore) ResolveTenantByHost(ctx context.Context, host string) (*Te
 	}
 
 	domain, ok := s.tenantDomains[normalizedHost]
-	if !ok {
+	if !ok || domain.Status != TenantDomainStatusVerified {
 		return nil, nil, ErrTenantNotFound
 	}
 	tenant, ok := s.tenants[domain.TenantID]
@@ -245,6 +260,56 @@ func (s *memoryStore) GetTenantMembership(ctx context.Context, tenantID, userID
 	return &entry, nil
 }
 
+// ListTenantMemberships returns the members of a tenant, oldest first.
+func (s *memoryStore) ListTenantMemberships(ctx context.Context, tenantID string) ([]TenantMembership, error) {
+	_ = ctx
+	normalizedTenantID := strings.TrimSpace(tenantID)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]TenantMembership, 0, len(s.tenantMemberships[normalizedTenantID]))
+	for _, member := range s.tenantMemberships[normalizedTenantID] {
+		result = append(result, *member)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].UserID < result[j].UserID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (s *memoryStore) DeleteTenantMembership(ctx context.Context, tenantID, userID string) error {
+	_ = ctx
+	normalizedTenantID := strings.TrimSpace(tenantID)
+	normalizedUserID := strings.TrimSpace(userID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenantMemberships[normalizedTenantID][normalizedUserID]; !ok {
+		return ErrTenantMembershipNotFound
+	}
+	delete(s.tenantMemberships[normalizedTenantID], normalizedUserID)
+	return nil
+}
+
+func (s *memoryStore) SetTenantMembershipExternalID(ctx context.Context, tenantID, userID, externalID string) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	member, ok := s.tenantMemberships[strings.TrimSpace(tenantID)][strings.TrimSpace(userID)]
+	if !ok {
+		return ErrTenantMembershipNotFound
+	}
+	member.ExternalID = strings.TrimSpace(externalID)
+	member.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
 func (s *memoryStore) GetXWorkmateProfile(ctx context.Context, tenantID, userID, scope string) (*XWorkmateProfile, error) {
 	_ = ctx
 	key := tenantProfileKey(tenantID, userID, scope)
@@ -31,6 +31,24 @@ RETURNING created_at, updated_at`
 	return s.db.QueryRowContext(ctx, query, tenant.ID, tenant.Name, tenant.Edition).Scan(&tenant.CreatedAt, &tenant.UpdatedAt)
 }
 
+func (s *postgresStore) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	tenant := &Tenant{}
+	query := `SELECT id, name, edition, created_at, updated_at FROM tenants WHERE id = $1`
+	if err := s.db.QueryRowContext(ctx, query, strings.TrimSpace(id)).Scan(
+		&tenant.ID,
+		&tenant.Name,
+		&tenant.Edition,
+		&tenant.CreatedAt,
+		&tenant.UpdatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	return tenant, nil
+}
+
 func (s *postgresStore) EnsureTenantDomain(ctx context.Context, domain *TenantDomain) error {
 	if domain == nil {
 		return ErrTenantNotFound
@@ -69,14 +87,14 @@ func (s *postgresStore) UpsertTenantMembership(ctx context.Context, membership *
 	}
 
 	NormalizeTenantMembership(membership)
-	query := `INSERT INTO tenant_memberships (tenant_id, user_id, role, created_at, updated_at)
-VALUES ($1, $2, $3, now(), now())
+	query := `INSERT INTO tenant_memberships (tenant_id, user_id, role, provisioned, created_at, updated_at)
+VALUES ($1, $2, $3, $4, now(), now())
 ON CONFLICT (tenant_id, user_id) DO UPDATE
 SET role = EXCLUDED.role,
     updated_at = now()
 RETURNING created_at, updated_at`
 
-	return s.db.QueryRowContext(ctx, query, membership.TenantID, membership.UserID, membership.Role).Scan(&membership.CreatedAt, &membership.UpdatedAt)
+	return s.db.QueryRowContext(ctx, query, membership.TenantID, membership.UserID, membership.Role, membership.Provisioned).Scan(&membership.CreatedAt, &membership.UpdatedAt)
 }
 
 func (s *postgresStore) ResolveTenantByHost(ctx context.Context, host string) (*Tenant, *TenantDomain, error) {
@@ -155,7 +173,7 @@ func scanTenantResolutionRow(row *sql.Row) (*Tenant, *TenantDomain, error) {
 }
 
 func (s *postgresStore) ListTenantMembershipsByUser(ctx context.Context, userID string) ([]TenantMembership, error) {
-	query := `SELECT tm.tenant_id, tm.user_id, tm.role, tm.created_at, tm.updated_at,
+	query := `SELECT tm.tenant_id, tm.user_id, tm.role, tm.external_id, tm.provisioned, tm.created_at, tm.updated_at,
   COALESCE(t.name, ''), COALESCE(t.edition, ''), COALESCE(td.domain, '')
 FROM tenant_memberships tm
 JOIN tenants t ON t.id = tm.tenant_id
@@ -176,6 +194,8 @@ ORDER BY t.name ASC, tm.tenant_id ASC`
 			&membership.TenantID,
 			&membership.UserID,
 			&membership.Role,
+			&membership.ExternalID,
+			&membership.Provisioned,
 			&membership.CreatedAt,
 			&membership.UpdatedAt,
 			&membership.TenantName,
@@ -195,7 +215,7 @@ ORDER BY t.name ASC, tm.tenant_id ASC`
 }
 
 func (s *postgresStore) GetTenantMembership(ctx context.Context, tenantID, userID string) (*TenantMembership, error) {
-	query := `SELECT tm.tenant_id, tm.user_id, tm.role, tm.created_at, tm.updated_at,
+	query := `SELECT tm.tenant_id, tm.user_id, tm.role, tm.external_id, tm.provisioned, tm.created_at, tm.updated_at,
   COALESCE(t.name, ''), COALESCE(t.edition, ''), COALESCE(td.domain, '')
 FROM tenant_memberships tm
 JOIN tenants t ON t.id = tm.tenant_id
@@ -208,6 +228,8 @@ LIMIT 1`
 		&membership.TenantID,
 		&membership.UserID,
 		&membership.Role,
+		&membership.ExternalID,
+		&membership.Provisioned,
 		&membership.CreatedAt,
 		&membership.UpdatedAt,
 		&membership.TenantName,
@@ -223,6 +245,73 @@ LIMIT 1`
 	return membership, nil
 }
 
+// ListTenantMemberships returns the members of a tenant, oldest first.
+func (s *postgresStore) ListTenantMemberships(ctx context.Context, tenantID string) ([]TenantMembership, error) {
+	query := `SELECT tm.tenant_id, tm.user_id, tm.role, tm.external_id, tm.provisioned, tm.created_at, tm.updated_at,
+  COALESCE(t.name, ''), COALESCE(t.edition, ''), COALESCE(td.domain, '')
+FROM tenant_memberships tm
+JOIN tenants t ON t.id = tm.tenant_id
+LEFT JOIN tenant_domains td ON td.tenant_id = tm.tenant_id AND td.is_primary = TRUE
+WHERE tm.tenant_id = $1
+ORDER BY tm.created_at ASC, tm.user_id ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, strings.TrimSpace(tenantID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]TenantMembership, 0)
+	for rows.Next() {
+		var membership TenantMembership
+		if err := rows.Scan(
+			&membership.TenantID,
+			&membership.UserID,
+			&membership.Role,
+			&membership.ExternalID,
+			&membership.Provisioned,
+			&membership.CreatedAt,
+			&membership.UpdatedAt,
+			&membership.TenantName,
+			&membership.TenantEdition,
+			&membership.Domain,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, membership)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *postgresStore) DeleteTenantMembership(ctx context.Context, tenantID, userID string) error {
+	query := `DELETE FROM tenant_memberships WHERE tenant_id = $1 AND user_id = $2`
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(tenantID), strings.TrimSpace(userID))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantMembershipNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) SetTenantMembershipExternalID(ctx context.Context, tenantID, userID, externalID string) error {
+	query := `UPDATE tenant_memberships SET external_id = $3, updated_at = now() WHERE tenant_id = $1 AND user_id = $2`
+	result, err := s.db.ExecContext(ctx, query, strings.TrimSpace(tenantID), strings.TrimSpace(userID), strings.TrimSpace(externalID))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTenantMembershipNotFound
+	}
+	return nil
+}
+
 func (s *postgresStore) GetXWorkmateProfile(ctx context.Context, tenantID, userID, scope string) (*XWorkmateProfile, error) {
 	profile := &XWorkmateProfile{}
 	var secretLocatorsJSON string
@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNormalizeHostname(t *testing.T) {
@@ -202,3 +204,95 @@ func TestMemoryStorePersistsExplicitSecretLocators(t *testing.T) {
 		t.Fatalf("expected openclaw locator to back legacy fields, got %#v", profile)
 	}
 }
+
+func TestMemoryStoreManagesTenantDomainsAndInvitations(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore()
+
+	tenant := &Tenant{ID: "tenant-acme", Name: "Acme", Edition: TenantPrivateEdition}
+	if err := st.EnsureTenant(ctx, tenant); err != nil {
+		t.Fatalf("ensure tenant: %v", err)
+	}
+	generated := &TenantDomain{
+		TenantID:  tenant.ID,
+		Domain:    "xw-acme.svc.plus",
+		Kind:      TenantDomainKindGenerated,
+		IsPrimary: true,
+		Status:    TenantDomainStatusVerified,
+	}
+	if err := st.EnsureTenantDomain(ctx, generated); err != nil {
+		t.Fatalf("ensure generated domain: %v", err)
+	}
+
+	custom := &TenantDomain{
+		TenantID:          tenant.ID,
+		Domain:            "Login.Acme.example",
+		Kind:              TenantDomainKindCustom,
+		Status:            TenantDomainStatusPending,
+		VerificationToken: "token",
+	}
+	if err := st.CreateTenantDomain(ctx, custom); err != nil {
+		t.Fatalf("create custom domain: %v", err)
+	}
+	if custom.Domain != "login.acme.example" || custom.ID == "" {
+		t.Fatalf("expected normalised domain with an id, got %#v", custom)
+	}
+	if err := st.CreateTenantDomain(ctx, &TenantDomain{TenantID: tenant.ID, Domain: "login.acme.example"}); !errors.Is(err, ErrTenantDomainExists) {
+		t.Fatalf("expected duplicate domain to be rejected, got %v", err)
+	}
+	if _, _, err := st.ResolveTenantByHost(ctx, "login.acme.example"); !errors.Is(err, ErrTenantNotFound) {
+		t.Fatalf("expected pending domain not to resolve, got %v", err)
+	}
+
+	if err := st.SetTenantDomainStatus(ctx, tenant.ID, custom.ID, TenantDomainStatusVerified); err != nil {
+		t.Fatalf("verify domain: %v", err)
+	}
+	if err := st.SetPrimaryTenantDomain(ctx, tenant.ID, custom.ID); err != nil {
+		t.Fatalf("set primary domain: %v", err)
+	}
+	resolved, domain, err := st.ResolveTenantByHost(ctx, "login.acme.example")
+	if err != nil || resolved.ID != tenant.ID || !domain.IsPrimary {
+		t.Fatalf("expected verified primary domain to resolve, got %#v %#v %v", resolved, domain, err)
+	}
+	domains, err := st.ListTenantDomains(ctx, tenant.ID)
+	if err != nil || len(domains) != 2 || domains[0].ID != custom.ID || domains[1].IsPrimary {
+		t.Fatalf("expected a single primary listed first, got %#v %v", domains, err)
+	}
+	if err := st.DeleteTenantDomain(ctx, "tenant-other", custom.ID); !errors.Is(err, ErrTenantDomainNotFound) {
+		t.Fatalf("expected domain of another tenant to be hidden, got %v", err)
+	}
+
+	invitation := &TenantInvitation{
+		TenantID:  tenant.ID,
+		Email:     " Invitee@Example.com ",
+		Role:      TenantMembershipRoleAdmin,
+		TokenHash: "hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := st.CreateTenantInvitation(ctx, invitation); err != nil {
+		t.Fatalf("create invitation: %v", err)
+	}
+	loaded, err := st.GetTenantInvitationByHash(ctx, "hash")
+	if err != nil || loaded.Email != "invitee@example.com" || loaded.Role != TenantMembershipRoleAdmin {
+		t.Fatalf("expected invitation by hash, got %#v %v", loaded, err)
+	}
+	if err := st.MarkTenantInvitationAccepted(ctx, invitation.ID, time.Now()); err != nil {
+		t.Fatalf("accept invitation: %v", err)
+	}
+	if err := st.MarkTenantInvitationAccepted(ctx, invitation.ID, time.Now()); !errors.Is(err, ErrTenantInvitationNotFound) {
+		t.Fatalf("expected second acceptance to fail, got %v", err)
+	}
+
+	if err := st.DeleteTenant(ctx, tenant.ID); err != nil {
+		t.Fatalf("delete tenant: %v", err)
+	}
+	if _, _, err := st.ResolveTenantByHost(ctx, "xw-acme.svc.plus"); !errors.Is(err, ErrTenantNotFound) {
+		t.Fatalf("expected deleted tenant domains to be gone, got %v", err)
+	}
+	if invitations, err := st.ListTenantInvitations(ctx, tenant.ID); err != nil || len(invitations) != 0 {
+		t.Fatalf("expected deleted tenant invitations to be gone, got %#v %v", invitations, err)
+	}
+	if err := st.DeleteTenant(ctx, tenant.ID); !errors.Is(err, ErrTenantNotFound) {
+		t.Fatalf("expected second delete to report not found, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Package webhook delivers account lifecycle events to subscribed HTTP
+// endpoints. Handlers append events to the outbox in the store; a Dispatcher
+// later claims the queued deliveries, posts each event signed with the
+// subscription secret, and retries failures with exponential backoff.
+// Deliveries are at least once and unordered, so receivers should dedupe on
+// the event ID.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"account/internal/store"
+)
+
+// Lifecycle event types.
+const (
+	EventUserCreated         = "user.created"
+	EventUserVerified        = "user.verified"
+	EventUserRoleChanged     = "user.role_changed"
+	EventUserPaused          = "user.paused"
+	EventUserResumed         = "user.resumed"
+	EventUserDeleted         = "user.deleted"
+	EventUserUUIDRenewed     = "user.uuid_renewed"
+	EventSubscriptionUpdated = "subscription.updated"
+)
+
+// EventTypes lists every event type a subscription may ask for.
+var EventTypes = []string{
+	EventUserCreated,
+	EventUserVerified,
+	EventUserRoleChanged,
+	EventUserPaused,
+	EventUserResumed,
+	EventUserDeleted,
+	EventUserUUIDRenewed,
+	EventSubscriptionUpdated,
+}
+
+// Request headers set on every delivery.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	EventIDHeader   = "X-Webhook-Event-Id"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const (
+	// DefaultMaxAttempts gives up on a delivery after roughly a day of retries.
+	DefaultMaxAttempts = 10
+	// DefaultTimeout bounds a single delivery request.
+	DefaultTimeout = 10 * time.Second
+
+	defaultBatchSize  = 20
+	initialRetryDelay = 30 * time.Second
+	maxRetryDelay     = 6 * time.Hour
+	maxErrorLength    = 512
+)
+
+// Payload is the JSON body posted to subscribers.
+type Payload struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Subject   Subject        `json:"subject"`
+	Data      map[string]any `json:"data"`
+}
+
+// Subject names the record an event is about.
+type Subject struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// NewPayload builds the delivery body of event.
+func NewPayload(event *store.OutboxEvent) Payload {
+	data := event.Data
+	if data == nil {
+		data = map[string]any{}
+	}
+	return Payload{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt.UTC(),
+		Subject:   Subject{Type: event.SubjectType, ID: event.SubjectID},
+		Data:      data,
+	}
+}
+
+// Sign returns the signature header value for body sent at timestamp:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + unix + ",v1=" + signature(secret, unix, body)
+}
+
+// Verify checks a signature header produced by Sign. Signatures older than
+// tolerance are rejected; a zero tolerance accepts any age.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) bool {
+	var (
+		timestamp  string
+		signatures []string
+	)
+	for _, part := range strings.Split(header, ",") {
+		piece := strings.TrimSpace(part)
+		switch {
+		case strings.HasPrefix(piece, "t="):
+			timestamp = strings.TrimPrefix(piece, "t=")
+		case strings.HasPrefix(piece, "v1="):
+			signatures = append(signatures, strings.TrimPrefix(piece, "v1="))
+		}
+	}
+	if secret == "" || timestamp == "" || len(signatures) == 0 {
+		return false
+	}
+	if tolerance > 0 {
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || now.Sub(time.Unix(unix, 0)).Abs() > tolerance {
+			return false
+		}
+	}
+	expected := signature(secret, timestamp, body)
+	for _, candidate := range signatures {
+		if hmac.Equal([]byte(expected), []byte(candidate)) {
+			return true
+		}
+	}
+	return false
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RetryDelay is the wait after the given number of failed attempts: 30s,
+// doubling each time up to six hours.
+func RetryDelay(attempts int) time.Duration {
+	delay := initialRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// Store captures the persistence operations the dispatcher needs.
+type Store interface {
+	GetOutboxEvent(ctx context.Context, id string) (*store.OutboxEvent, error)
+	GetWebhookSubscription(ctx context.Context, id string) (*store.WebhookSubscription, error)
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]store.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *store.WebhookDelivery) error
+}
+
+// Dispatcher sends queued deliveries. Several dispatchers may share a store:
+// claimed deliveries are leased to one of them until the lease runs out.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	now    func() time.Time
+
+	// MaxAttempts is the number of attempts after which a delivery is marked
+	// failed.
+	MaxAttempts int
+	// BatchSize caps the deliveries claimed, and sent concurrently, per call
+	// to Dispatch.
+	BatchSize int
+}
+
+// NewDispatcher returns a Dispatcher posting through client, or through
+// NewClient with DefaultTimeout and no allowed networks when client is nil.
+// Redirects are never followed: a 3xx response is a failed attempt.
+func NewDispatcher(st Store, client *http.Client) *Dispatcher {
+	if client == nil {
+		client = NewClient(DefaultTimeout, nil)
+	}
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &Dispatcher{
+		store:       st,
+		client:      &noRedirects,
+		now:         time.Now,
+		MaxAttempts: DefaultMaxAttempts,
+		BatchSize:   defaultBatchSize,
+	}
+}
+
+// Dispatch makes one attempt at every due delivery and returns how many
+// succeeded.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	// The lease outlasts the slowest possible attempt so a delivery is never
+	// claimed twice while it is still in flight.
+	lease := 2 * d.client.Timeout
+	if lease <= 0 {
+		lease = 2 * DefaultTimeout
+	}
+	deliveries, err := d.store.ClaimWebhookDeliveries(ctx, d.now(), lease, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		errs      []error
+	)
+	for i := range deliveries {
+		wg.Add(1)
+		go func(delivery *store.WebhookDelivery) {
+			defer wg.Done()
+			ok, err := d.deliver(ctx, delivery)
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				succeeded++
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(&deliveries[i])
+	}
+	wg.Wait()
+	return succeeded, errors.Join(errs...)
+}
+
+// deliver attempts delivery and records the outcome. The returned error only
+// reports failures to record it; a failed attempt is not an error.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *store.WebhookDelivery) (bool, error) {
+	status, attemptErr := d.attempt(ctx, delivery)
+
+	now := d.now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = status
+	if attemptErr == nil {
+		delivery.Status = store.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	} else {
+		delivery.LastError = truncate(attemptErr.Error(), maxErrorLength)
+		if errors.Is(attemptErr, errUndeliverable) || delivery.Attempts >= d.MaxAttempts {
+			delivery.Status = store.WebhookDeliveryFailed
+		} else {
+			delivery.NextAttemptAt = now.Add(RetryDelay(delivery.Attempts))
+		}
+	}
+	if err := d.store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		return false, fmt.Errorf("record webhook delivery %s: %w", delivery.ID, err)
+	}
+	return attemptErr == nil, nil
+}
+
+// errUndeliverable marks attempts that retrying cannot fix.
+var errUndeliverable = errors.New("undeliverable")
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery *store.WebhookDelivery) (int, error) {
+	event, err := d.store.GetOutboxEvent(ctx, delivery.EventID)
+	if errors.Is(err, store.ErrOutboxEventNotFound) {
+		return 0, fmt.Errorf("%w: event no longer exists", errUndeliverable)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("load event: %w", err)
+	}
+	subscription, err := d.store.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if errors.Is(err, store.ErrWebhookNotFound) {
+		return 0, fmt.Errorf("%w: subscription no longer exists", errUndeliverable)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("load subscription: %w", err)
+	}
+
+	body, err := json.Marshal(NewPayload(event))
+	if err != nil {
+		return 0, fmt.Errorf("%w: encode payload: %v", errUndeliverable, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errUndeliverable, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "account-webhooks/1")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(EventIDHeader, event.ID)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, d.now(), body))
+
+	resp, err := d.client.Do(req)
+	if errors.Is(err, ErrBlockedAddress) {
+		return 0, fmt.Errorf("%w: %v", errUndeliverable, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncate(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	return value[:limit]
+}
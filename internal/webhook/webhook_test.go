@@ -0,0 +1,251 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"account/internal/store"
+)
+
+func newTestDispatcher(t *testing.T, handler http.HandlerFunc) (*Dispatcher, store.Store, *store.WebhookSubscription, *time.Time) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	st := store.NewMemoryStore()
+	subscription := &store.WebhookSubscription{URL: server.URL, Secret: "whsec_test", Active: true}
+	if err := st.CreateWebhookSubscription(context.Background(), subscription); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+
+	// Appended events are stamped with the wall clock, so start the
+	// dispatcher's clock ahead of them to make their deliveries due.
+	now := time.Now().UTC().Add(time.Second)
+	dispatcher := NewDispatcher(st, server.Client())
+	dispatcher.now = func() time.Time { return now }
+	return dispatcher, st, subscription, &now
+}
+
+func listDeliveries(t *testing.T, st store.Store) []store.WebhookDelivery {
+	t.Helper()
+	deliveries, err := st.ListWebhookDeliveries(context.Background(), store.WebhookDeliveryFilter{})
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	return deliveries
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"evt"}`)
+	sentAt := time.Unix(1777000000, 0)
+	header := Sign("secret", sentAt, body)
+
+	if !Verify("secret", header, body, sentAt.Add(time.Minute), 5*time.Minute) {
+		t.Fatalf("expected signature to verify")
+	}
+	if Verify("other", header, body, sentAt, 0) {
+		t.Fatalf("expected wrong secret to fail")
+	}
+	if Verify("secret", header, []byte(`{"id":"forged"}`), sentAt, 0) {
+		t.Fatalf("expected tampered body to fail")
+	}
+	if Verify("secret", header, body, sentAt.Add(time.Hour), 5*time.Minute) {
+		t.Fatalf("expected stale signature to fail")
+	}
+	if !Verify("secret", header, body, sentAt.Add(time.Hour), 0) {
+		t.Fatalf("expected zero tolerance to accept any age")
+	}
+}
+
+func TestRetryDelayBacksOffToCap(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: 6 * time.Hour,
+	}
+	for attempts, want := range cases {
+		if got := RetryDelay(attempts); got != want {
+			t.Fatalf("RetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestDispatchRetriesUntilDelivered(t *testing.T) {
+	var calls atomic.Int32
+	dispatcher, st, subscription, now := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("whsec_test", r.Header.Get(SignatureHeader), body, time.Now(), 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	ctx := context.Background()
+	if err := st.AppendOutboxEvent(ctx, &store.OutboxEvent{Type: EventUserPaused, SubjectType: "user", SubjectID: "u1"}); err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected first attempt to fail, got %d (%v)", sent, err)
+	}
+	delivery := listDeliveries(t, st)[0]
+	if delivery.Status != store.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected a pending retry after a 500, got %+v", delivery)
+	}
+	if !delivery.NextAttemptAt.Equal(now.Add(RetryDelay(1))) {
+		t.Fatalf("expected retry at %s, got %s", now.Add(RetryDelay(1)), delivery.NextAttemptAt)
+	}
+	if sent, _ := dispatcher.Dispatch(ctx); sent != 0 || calls.Load() != 1 {
+		t.Fatalf("expected no attempt before the retry is due")
+	}
+
+	*now = now.Add(RetryDelay(1))
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 1 {
+		t.Fatalf("expected retry to succeed, got %d (%v)", sent, err)
+	}
+	delivery = listDeliveries(t, st)[0]
+	if delivery.Status != store.WebhookDeliverySucceeded || delivery.Attempts != 2 || delivery.DeliveredAt == nil || delivery.LastError != "" {
+		t.Fatalf("expected delivery to succeed on the second attempt, got %+v", delivery)
+	}
+	if delivery.SubscriptionID != subscription.ID {
+		t.Fatalf("unexpected subscription %q", delivery.SubscriptionID)
+	}
+}
+
+func TestDispatchGivesUpAfterMaxAttempts(t *testing.T) {
+	dispatcher, st, _, now := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	dispatcher.MaxAttempts = 2
+	ctx := context.Background()
+	if err := st.AppendOutboxEvent(ctx, &store.OutboxEvent{Type: EventUserDeleted}); err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err := dispatcher.Dispatch(ctx); err != nil {
+			t.Fatalf("dispatch %d: %v", i, err)
+		}
+		*now = now.Add(maxRetryDelay)
+	}
+	delivery := listDeliveries(t, st)[0]
+	if delivery.Status != store.WebhookDeliveryFailed || delivery.Attempts != 2 || delivery.LastError == "" {
+		t.Fatalf("expected delivery to fail after two attempts, got %+v", delivery)
+	}
+}
+
+func TestDispatchSkipsInactiveSubscriptions(t *testing.T) {
+	var calls atomic.Int32
+	dispatcher, st, subscription, _ := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+	ctx := context.Background()
+	subscription.EventTypes = []string{EventUserCreated}
+	if err := st.UpdateWebhookSubscription(ctx, subscription); err != nil {
+		t.Fatalf("update subscription: %v", err)
+	}
+	if err := st.AppendOutboxEvent(ctx, &store.OutboxEvent{Type: EventUserPaused}); err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+	if len(listDeliveries(t, st)) != 0 {
+		t.Fatalf("expected unsubscribed event type not to be queued")
+	}
+
+	if err := st.AppendOutboxEvent(ctx, &store.OutboxEvent{Type: EventUserCreated}); err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+	subscription.Active = false
+	if err := st.UpdateWebhookSubscription(ctx, subscription); err != nil {
+		t.Fatalf("disable subscription: %v", err)
+	}
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 0 || calls.Load() != 0 {
+		t.Fatalf("expected disabled subscription to be skipped, got %d (%v)", sent, err)
+	}
+	if delivery := listDeliveries(t, st)[0]; delivery.Status != store.WebhookDeliveryPending {
+		t.Fatalf("expected delivery to wait for the subscription to be enabled, got %+v", delivery)
+	}
+}
+
+func TestAddressAllowed(t *testing.T) {
+	allowed, err := ParseNetworks([]string{"10.1.0.0/16", "fd00::1"})
+	if err != nil {
+		t.Fatalf("parse networks: %v", err)
+	}
+	for addr, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.2.0.1":        false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.100.100.200": false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+		"10.1.2.3":        true,
+		"fd00::1":         true,
+	} {
+		if got := AddressAllowed(netip.MustParseAddr(addr), allowed); got != want {
+			t.Errorf("%s: expected %v, got %v", addr, want, got)
+		}
+	}
+	if _, err := ParseNetworks([]string{"not-a-network"}); err == nil {
+		t.Fatalf("expected an invalid network to be rejected")
+	}
+}
+
+func TestDispatchRefusesBlockedAddressesAndRedirects(t *testing.T) {
+	var calls atomic.Int32
+	testDispatcher, st, _, now := newTestDispatcher(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	ctx := context.Background()
+	if err := st.AppendOutboxEvent(ctx, &store.OutboxEvent{Type: EventUserPaused}); err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+
+	dispatcher := NewDispatcher(st, NewClient(time.Second, nil))
+	dispatcher.now = testDispatcher.now
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected the loopback subscriber to be refused, got %d (%v)", sent, err)
+	}
+	delivery := listDeliveries(t, st)[0]
+	if calls.Load() != 0 || delivery.Status != store.WebhookDeliveryFailed || !strings.Contains(delivery.LastError, ErrBlockedAddress.Error()) {
+		t.Fatalf("expected the delivery to fail without a request, got %d calls and %+v", calls.Load(), delivery)
+	}
+
+	if err := st.AppendOutboxEvent(ctx, &store.OutboxEvent{Type: EventUserResumed}); err != nil {
+		t.Fatalf("append event: %v", err)
+	}
+	dispatcher = NewDispatcher(st, NewClient(time.Second, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}))
+	dispatcher.now = func() time.Time { return *now }
+	if sent, err := dispatcher.Dispatch(ctx); err != nil || sent != 0 {
+		t.Fatalf("expected a redirect to fail the attempt, got %d (%v)", sent, err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected the redirect not to be followed, got %d calls", calls.Load())
+	}
+	redirected := 0
+	for _, delivery := range listDeliveries(t, st) {
+		if delivery.Status == store.WebhookDeliveryPending && delivery.ResponseStatus == http.StatusFound {
+			redirected++
+		}
+	}
+	if redirected != 1 {
+		t.Fatalf("expected the redirect to be recorded as a failed attempt, got %+v", listDeliveries(t, st))
+	}
+}
@@ -23,8 +23,17 @@ type Client struct {
 	ID    string
 	Email string
 	Flow  string
+	// Throttled places the client on ThrottledLevel so the template's policy
+	// for that level applies to its connections.
+	Throttled bool
 }
 
+// ThrottledLevel is the Xray policy level assigned to throttled clients. The
+// bundled templates give it shorter timeouts and a smaller per-connection
+// buffer; Xray has no per-user bandwidth cap, so hard limits have to be
+// enforced outside of it.
+const ThrottledLevel = 1
+
 // Generator updates the Xray configuration file based on a template and a set of
 // active clients.
 type Generator struct {
@@ -169,6 +178,9 @@ func replaceClients(root map[string]interface{}, clients []Client) error {
 			}
 			entry["flow"] = flow
 		}
+		if client.Throttled {
+			entry["level"] = ThrottledLevel
+		}
 		clientObjects = append(clientObjects, entry)
 	}
 
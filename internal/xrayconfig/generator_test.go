@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -15,6 +16,7 @@ type testConfig struct {
 				ID    string `json:"id"`
 				Email string `json:"email,omitempty"`
 				Flow  string `json:"flow,omitempty"`
+				Level *int   `json:"level,omitempty"`
 			} `json:"clients"`
 		} `json:"settings"`
 	} `json:"inbounds"`
@@ -43,7 +45,7 @@ func TestGeneratorGenerate(t *testing.T) {
 
 	clients := []Client{
 		{ID: "uuid-a", Email: "a@demo", Flow: "xtls-rprx-vision"},
-		{ID: "uuid-b"},
+		{ID: "uuid-b", Throttled: true},
 	}
 
 	if err := gen.Generate(clients); err != nil {
@@ -80,6 +82,12 @@ func TestGeneratorGenerate(t *testing.T) {
 	if clientsSection[1].ID != "uuid-b" || clientsSection[1].Email != "" || clientsSection[1].Flow != DefaultFlow {
 		t.Fatalf("unexpected second client: %+v", clientsSection[1])
 	}
+	if clientsSection[0].Level != nil {
+		t.Fatalf("expected no level for unthrottled client, got %d", *clientsSection[0].Level)
+	}
+	if clientsSection[1].Level == nil || *clientsSection[1].Level != ThrottledLevel {
+		t.Fatalf("expected throttled client on level %d, got %v", ThrottledLevel, clientsSection[1].Level)
+	}
 
 	onDisk, err := os.ReadFile(outputPath)
 	if err != nil {
@@ -120,3 +128,79 @@ func TestGeneratorRenderUsesDefaultDefinition(t *testing.T) {
 		t.Fatal("expected render output to end with newline")
 	}
 }
+
+func TestTemplatesEnableUserTrafficStats(t *testing.T) {
+	shipped, err := os.ReadFile(filepath.Join("..", "..", "config", "xray.config.template.json"))
+	if err != nil {
+		t.Fatalf("read shipped template: %v", err)
+	}
+	templates := map[string]Definition{
+		"tcp":     TCPDefinition(),
+		"xhttp":   XHTTPDefinition(),
+		"server":  JSONDefinition{Raw: serverTemplateJSON},
+		"shipped": JSONDefinition{Raw: shipped},
+	}
+	for name, definition := range templates {
+		gen := Generator{Definition: definition, Domain: "node.example.com"}
+		raw, err := gen.Render([]Client{{ID: "uuid-a", Email: "a@demo"}})
+		if err != nil {
+			t.Fatalf("%s: render: %v", name, err)
+		}
+		var cfg struct {
+			Stats *struct{} `json:"stats"`
+			API   struct {
+				Tag      string   `json:"tag"`
+				Services []string `json:"services"`
+			} `json:"api"`
+			Inbounds []struct {
+				Tag      string `json:"tag"`
+				Listen   string `json:"listen"`
+				Port     int    `json:"port"`
+				Protocol string `json:"protocol"`
+			} `json:"inbounds"`
+			Routing struct {
+				Rules []struct {
+					InboundTag  []string `json:"inboundTag"`
+					OutboundTag string   `json:"outboundTag"`
+				} `json:"rules"`
+			} `json:"routing"`
+			Policy struct {
+				Levels map[string]struct {
+					StatsUserUplink   bool `json:"statsUserUplink"`
+					StatsUserDownlink bool `json:"statsUserDownlink"`
+				} `json:"levels"`
+			} `json:"policy"`
+		}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			t.Fatalf("%s: decode output: %v", name, err)
+		}
+
+		if cfg.Stats == nil {
+			t.Fatalf("%s: expected stats to be enabled", name)
+		}
+		if cfg.API.Tag != "api" || len(cfg.API.Services) != 1 || cfg.API.Services[0] != "StatsService" {
+			t.Fatalf("%s: expected the StatsService api, got %+v", name, cfg.API)
+		}
+		if len(cfg.Inbounds) < 2 || cfg.Inbounds[0].Tag == "api" {
+			t.Fatalf("%s: expected the client inbound to stay first, got %+v", name, cfg.Inbounds)
+		}
+		apiInbound := cfg.Inbounds[len(cfg.Inbounds)-1]
+		if apiInbound.Tag != "api" || apiInbound.Protocol != "dokodemo-door" || apiInbound.Listen != "127.0.0.1" || apiInbound.Port != 10085 {
+			t.Fatalf("%s: expected a loopback api inbound on 10085, got %+v", name, apiInbound)
+		}
+		if len(cfg.Routing.Rules) == 0 || cfg.Routing.Rules[0].OutboundTag != "api" ||
+			len(cfg.Routing.Rules[0].InboundTag) != 1 || cfg.Routing.Rules[0].InboundTag[0] != "api" {
+			t.Fatalf("%s: expected api traffic to be routed first, got %+v", name, cfg.Routing.Rules)
+		}
+		for _, level := range []string{"0", strconv.Itoa(ThrottledLevel)} {
+			if _, ok := cfg.Policy.Levels[level]; !ok {
+				t.Fatalf("%s: expected a policy for level %s, got %+v", name, level, cfg.Policy.Levels)
+			}
+		}
+		for level, policy := range cfg.Policy.Levels {
+			if !policy.StatsUserUplink || !policy.StatsUserDownlink {
+				t.Fatalf("%s: expected level %s to count user traffic, got %+v", name, level, policy)
+			}
+		}
+	}
+}
@@ -9,6 +9,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// Quota states as written by the accounting control plane.
+const (
+	throttleStateThrottled = "throttled"
+	suspendStateSuspended  = "suspended"
+)
+
 // GormClientSource reads Xray client credentials from the users table using GORM.
 type GormClientSource struct {
 	DB     *gorm.DB
@@ -26,22 +32,33 @@ func NewGormClientSource(db *gorm.DB) (*GormClientSource, error) {
 	}, nil
 }
 
-// ListClients returns all users ordered by creation time.
+// ListClients returns all users ordered by creation time. When the accounting
+// tables are present, suspended accounts are left out and throttled accounts
+// are tagged so they land on ThrottledLevel.
 func (s *GormClientSource) ListClients(ctx context.Context) ([]Client, error) {
 	if s == nil || s.DB == nil {
 		return nil, errors.New("gorm client source is not configured")
 	}
 
 	type row struct {
-		ProxyUUID string  `gorm:"column:proxy_uuid"`
-		Email     *string `gorm:"column:email"`
+		ProxyUUID     string  `gorm:"column:proxy_uuid"`
+		Email         *string `gorm:"column:email"`
+		ThrottleState *string `gorm:"column:throttle_state"`
+	}
+
+	query := s.DB.WithContext(ctx).Table("users")
+	if s.DB.Migrator().HasTable("account_quota_states") {
+		query = query.
+			Select("users.proxy_uuid, users.email, account_quota_states.throttle_state").
+			Joins("LEFT JOIN account_quota_states ON account_quota_states.account_uuid = users.uuid").
+			Where("account_quota_states.suspend_state IS NULL OR account_quota_states.suspend_state <> ?", suspendStateSuspended)
+	} else {
+		query = query.Select("users.proxy_uuid, users.email")
 	}
 
 	var rows []row
-	if err := s.DB.WithContext(ctx).
-		Table("users").
-		Select("proxy_uuid, email").
-		Order("created_at ASC, proxy_uuid ASC").
+	if err := query.
+		Order("users.created_at ASC, users.proxy_uuid ASC").
 		Find(&rows).Error; err != nil {
 		if s.Logger != nil {
 			s.Logger.Error("failed to list clients from users table", "err", err)
@@ -59,6 +76,9 @@ func (s *GormClientSource) ListClients(ctx context.Context) ([]Client, error) {
 		if r.Email != nil {
 			client.Email = strings.TrimSpace(*r.Email)
 		}
+		if r.ThrottleState != nil && strings.TrimSpace(*r.ThrottleState) == throttleStateThrottled {
+			client.Throttled = true
+		}
 		clients = append(clients, client)
 	}
 	return clients, nil
@@ -51,3 +51,61 @@ func TestNewGormClientSourceNilDB(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestGormClientSourceAppliesQuotaStates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:quota_states?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE users (uuid TEXT PRIMARY KEY, proxy_uuid TEXT, email TEXT, created_at TIMESTAMP)`,
+		`CREATE TABLE account_quota_states (account_uuid TEXT PRIMARY KEY, throttle_state TEXT, suspend_state TEXT)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+
+	base := time.Now().Add(-time.Hour)
+	for i, user := range []struct{ uuid, proxy string }{
+		{"uuid-normal", "proxy-normal"},
+		{"uuid-throttled", "proxy-throttled"},
+		{"uuid-suspended", "proxy-suspended"},
+		{"uuid-untracked", "proxy-untracked"},
+	} {
+		if err := db.Exec(`INSERT INTO users (uuid, proxy_uuid, email, created_at) VALUES (?, ?, ?, ?)`, user.uuid, user.proxy, nil, base.Add(time.Duration(i)*time.Minute)).Error; err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+	}
+	for _, state := range [][3]string{
+		{"uuid-normal", "normal", "active"},
+		{"uuid-throttled", "throttled", "active"},
+		{"uuid-suspended", "throttled", "suspended"},
+	} {
+		if err := db.Exec(`INSERT INTO account_quota_states (account_uuid, throttle_state, suspend_state) VALUES (?, ?, ?)`, state[0], state[1], state[2]).Error; err != nil {
+			t.Fatalf("insert quota state: %v", err)
+		}
+	}
+
+	source, err := NewGormClientSource(db)
+	if err != nil {
+		t.Fatalf("new source: %v", err)
+	}
+	clients, err := source.ListClients(context.Background())
+	if err != nil {
+		t.Fatalf("list clients: %v", err)
+	}
+	want := []Client{
+		{ID: "proxy-normal"},
+		{ID: "proxy-throttled", Throttled: true},
+		{ID: "proxy-untracked"},
+	}
+	if len(clients) != len(want) {
+		t.Fatalf("expected %d clients, got %+v", len(want), clients)
+	}
+	for i := range want {
+		if clients[i] != want[i] {
+			t.Fatalf("client %d: expected %+v, got %+v", i, want[i], clients[i])
+		}
+	}
+}
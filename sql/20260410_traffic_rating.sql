@@ -0,0 +1,10 @@
+ALTER TABLE public.billing_ledger
+  ADD COLUMN IF NOT EXISTS source_key TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_billing_ledger_source_pricing
+  ON public.billing_ledger (source_key, pricing_rule_version)
+  WHERE source_key <> '';
+
+CREATE INDEX IF NOT EXISTS idx_traffic_minute_buckets_pending
+  ON public.traffic_minute_buckets (bucket_start ASC)
+  WHERE rating_status = 'pending';
@@ -0,0 +1,15 @@
+-- Short-lived authentication state shared between replicas
+-- Migration: 20260412_auth_ephemeral_state.sql
+
+CREATE TABLE IF NOT EXISTS public.auth_ephemeral_state (
+  namespace TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value BYTEA NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (namespace, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_ephemeral_state_expires
+  ON public.auth_ephemeral_state (expires_at);
+
+COMMENT ON TABLE public.auth_ephemeral_state IS 'MFA challenges, verification codes, password resets and OAuth exchange codes with TTL expiry';
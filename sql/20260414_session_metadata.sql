@@ -0,0 +1,23 @@
+-- Session inventory metadata for listing and remote revocation
+-- Migration: 20260414_session_metadata.sql
+
+ALTER TABLE public.sessions
+  ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS ip_address TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS auth_method TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE public.sessions SET last_seen_at = created_at WHERE last_seen_at > created_at;
+
+COMMENT ON COLUMN public.sessions.auth_method IS 'How the session was established, e.g. password, mfa_totp, oauth:github';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.users.sessions.read', 'read user sessions'),
+  ('admin.users.sessions.write', 'revoke user sessions')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled)
+SELECT 'operator', permission_key, true
+FROM public.rbac_permissions
+WHERE permission_key IN ('admin.users.sessions.read', 'admin.users.sessions.write')
+ON CONFLICT (role_key, permission_key) DO NOTHING;
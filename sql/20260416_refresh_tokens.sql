@@ -0,0 +1,21 @@
+-- Rotating refresh tokens with family-wide revocation
+-- Migration: 20260416_refresh_tokens.sql
+
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+  id UUID PRIMARY KEY,
+  family_id UUID NOT NULL,
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  parent_id UUID,
+  issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  replaced_by TEXT NOT NULL DEFAULT '',
+  revoked_at TIMESTAMPTZ,
+  revoke_reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON public.refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON public.refresh_tokens (user_uuid) WHERE revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON public.refresh_tokens (expires_at);
+
+COMMENT ON TABLE public.refresh_tokens IS 'Refresh token JWT IDs; a replayed (already used) token revokes its whole family';
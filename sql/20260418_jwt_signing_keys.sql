@@ -0,0 +1,16 @@
+-- Asymmetric JWT signing keys generated by the service
+-- Migration: 20260418_jwt_signing_keys.sql
+
+CREATE TABLE IF NOT EXISTS public.jwt_signing_keys (
+  kid TEXT PRIMARY KEY,
+  algorithm TEXT NOT NULL,
+  private_key_pem TEXT NOT NULL,
+  not_before TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  retired_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_jwt_signing_keys_active
+  ON public.jwt_signing_keys (created_at) WHERE retired_at IS NULL;
+
+COMMENT ON TABLE public.jwt_signing_keys IS 'RS256/EdDSA access token signing keys; public halves are served at /.well-known/jwks.json';
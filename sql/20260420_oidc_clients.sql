@@ -0,0 +1,24 @@
+-- Applications registered with the built-in OpenID Connect provider
+-- Migration: 20260420_oidc_clients.sql
+
+CREATE TABLE IF NOT EXISTS public.oidc_clients (
+  client_id TEXT PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT '',
+  secret_hash TEXT NOT NULL DEFAULT '',
+  redirect_uris JSONB NOT NULL DEFAULT '[]'::jsonb,
+  scopes JSONB NOT NULL DEFAULT '[]'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON COLUMN public.oidc_clients.secret_hash IS 'bcrypt hash of the client secret; empty for public (PKCE only) clients';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.oidc.clients.read', 'read oidc clients'),
+  ('admin.oidc.clients.write', 'register and delete oidc clients')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.oidc.clients.read', true),
+  ('operator', 'admin.oidc.clients.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
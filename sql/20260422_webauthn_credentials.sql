@@ -0,0 +1,22 @@
+-- Passkeys and security keys registered for WebAuthn sign-in
+-- Migration: 20260422_webauthn_credentials.sql
+
+CREATE TABLE IF NOT EXISTS public.webauthn_credentials (
+  credential_id TEXT PRIMARY KEY,
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  name TEXT NOT NULL DEFAULT '',
+  public_key BYTEA NOT NULL,
+  attestation_type TEXT NOT NULL DEFAULT '',
+  transports JSONB NOT NULL DEFAULT '[]'::jsonb,
+  aaguid BYTEA,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  backup_eligible BOOLEAN NOT NULL DEFAULT false,
+  backup_state BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_idx ON public.webauthn_credentials (user_uuid);
+
+COMMENT ON COLUMN public.webauthn_credentials.credential_id IS 'base64url-encoded WebAuthn credential ID';
+COMMENT ON COLUMN public.webauthn_credentials.sign_count IS 'authenticator signature counter from the last successful assertion';
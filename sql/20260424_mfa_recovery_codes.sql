@@ -0,0 +1,17 @@
+-- Single-use MFA recovery codes
+-- Migration: 20260424_mfa_recovery_codes.sql
+
+CREATE TABLE IF NOT EXISTS public.mfa_recovery_codes (
+  id UUID PRIMARY KEY,
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  used_at TIMESTAMPTZ,
+  used_ip TEXT NOT NULL DEFAULT '',
+  used_user_agent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_idx ON public.mfa_recovery_codes (user_uuid);
+
+COMMENT ON COLUMN public.mfa_recovery_codes.code_hash IS 'hex SHA-256 of the normalized recovery code';
+COMMENT ON COLUMN public.mfa_recovery_codes.used_at IS 'set when the code is spent; each code signs in at most once';
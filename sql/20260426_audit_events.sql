@@ -0,0 +1,49 @@
+-- Append-only audit log for security and admin actions
+-- Migration: 20260426_audit_events.sql
+
+CREATE TABLE IF NOT EXISTS public.audit_events (
+  id UUID PRIMARY KEY,
+  tenant_id TEXT NOT NULL DEFAULT '',
+  actor_id TEXT NOT NULL DEFAULT '',
+  actor_email TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL,
+  outcome TEXT NOT NULL DEFAULT 'success',
+  target_type TEXT NOT NULL DEFAULT '',
+  target_id TEXT NOT NULL DEFAULT '',
+  ip_address TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  before JSONB,
+  after JSONB,
+  metadata JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_idx ON public.audit_events (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON public.audit_events (target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON public.audit_events (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_events_tenant_idx ON public.audit_events (tenant_id, created_at DESC);
+
+COMMENT ON COLUMN public.audit_events.actor_id IS 'user uuid of the actor; not a foreign key so events outlive deleted users';
+COMMENT ON COLUMN public.audit_events.before IS 'previous values of the fields the action changed';
+COMMENT ON COLUMN public.audit_events.after IS 'new values of the fields the action changed';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.audit.read', 'read audit log')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.audit.read', true)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
+
+-- Rows are never updated or deleted by the service.
+CREATE OR REPLACE FUNCTION public.audit_events_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON public.audit_events;
+CREATE TRIGGER audit_events_append_only
+  BEFORE UPDATE OR DELETE ON public.audit_events
+  FOR EACH ROW EXECUTE FUNCTION public.audit_events_append_only();
@@ -0,0 +1,56 @@
+-- Account lifecycle event outbox and outbound webhook deliveries
+-- Migration: 20260428_webhooks.sql
+
+CREATE TABLE IF NOT EXISTS public.event_outbox (
+  id UUID PRIMARY KEY,
+  event_type TEXT NOT NULL,
+  subject_type TEXT NOT NULL DEFAULT '',
+  subject_id TEXT NOT NULL DEFAULT '',
+  data JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS event_outbox_created_idx ON public.event_outbox (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS event_outbox_type_idx ON public.event_outbox (event_type, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS public.webhook_subscriptions (
+  id UUID PRIMARY KEY,
+  url TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  secret TEXT NOT NULL,
+  event_types JSONB NOT NULL DEFAULT '[]'::jsonb,
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON COLUMN public.webhook_subscriptions.secret IS 'HMAC-SHA256 signing secret; kept in clear because every delivery is signed with it';
+COMMENT ON COLUMN public.webhook_subscriptions.event_types IS 'event types to deliver; an empty array subscribes to all of them';
+
+CREATE TABLE IF NOT EXISTS public.webhook_deliveries (
+  id UUID PRIMARY KEY,
+  event_id UUID NOT NULL REFERENCES public.event_outbox(id) ON DELETE CASCADE,
+  subscription_id UUID NOT NULL REFERENCES public.webhook_subscriptions(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_attempt_at TIMESTAMPTZ,
+  response_status INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON public.webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx ON public.webhook_deliveries (subscription_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON public.webhook_deliveries (event_id);
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.webhooks.read', 'read webhook subscriptions and deliveries'),
+  ('admin.webhooks.write', 'manage webhook subscriptions and replay events')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.webhooks.read', true),
+  ('operator', 'admin.webhooks.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
@@ -0,0 +1,42 @@
+-- SCIM 2.0 provisioning: per-tenant bearer tokens and provisioned groups
+-- Migration: 20260430_scim.sql
+
+CREATE TABLE IF NOT EXISTS public.scim_tokens (
+  id UUID PRIMARY KEY,
+  tenant_id TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  token_hash TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS scim_tokens_tenant_idx ON public.scim_tokens (tenant_id, created_at);
+
+COMMENT ON COLUMN public.scim_tokens.token_hash IS 'hex SHA-256 of the bearer token; the token itself is shown once';
+
+CREATE TABLE IF NOT EXISTS public.scim_groups (
+  id UUID PRIMARY KEY,
+  tenant_id TEXT NOT NULL,
+  display_name TEXT NOT NULL,
+  external_id TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS scim_groups_tenant_name_idx ON public.scim_groups (tenant_id, lower(display_name));
+
+COMMENT ON COLUMN public.scim_groups.display_name IS 'mirrored into the groups of every member';
+
+-- tenant_memberships is managed by the service's schema auto-migration, which
+-- adds this column as well; the statement covers databases migrated by hand.
+ALTER TABLE IF EXISTS public.tenant_memberships ADD COLUMN IF NOT EXISTS external_id TEXT NOT NULL DEFAULT '';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.scim.read', 'list tenant scim tokens'),
+  ('admin.scim.write', 'issue and revoke tenant scim tokens')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.scim.read', true),
+  ('operator', 'admin.scim.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
@@ -0,0 +1,34 @@
+-- Tenant management: custom domain verification and member invitations
+-- Migration: 20260501_tenant_management.sql
+
+-- tenant_domains is managed by the service's schema auto-migration, which
+-- adds this column as well; the statement covers databases migrated by hand.
+ALTER TABLE IF EXISTS public.tenant_domains ADD COLUMN IF NOT EXISTS verification_token TEXT NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN public.tenant_domains.verification_token IS 'published as a TXT record on _xcontrol-challenge.<domain> to verify a custom domain';
+
+CREATE TABLE IF NOT EXISTS public.tenant_invitations (
+  id TEXT PRIMARY KEY,
+  tenant_id TEXT NOT NULL,
+  email TEXT NOT NULL,
+  role TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  invited_by TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMPTZ NOT NULL,
+  accepted_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS tenant_invitations_tenant_idx ON public.tenant_invitations (tenant_id, created_at);
+
+COMMENT ON COLUMN public.tenant_invitations.token_hash IS 'hex SHA-256 of the emailed invitation token';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.tenants.read', 'read tenants, domains, members and invitations'),
+  ('admin.tenants.write', 'manage tenants, domains, members and invitations')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.tenants.read', true),
+  ('operator', 'admin.tenants.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
@@ -0,0 +1,30 @@
+-- Custom roles and role bindings for the RBAC policy engine
+-- Migration: 20260502_rbac_engine.sql
+
+-- Built-in roles are defined by the service; rows created through the roles
+-- API are marked custom.
+ALTER TABLE public.rbac_roles ADD COLUMN IF NOT EXISTS custom BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS public.rbac_role_bindings (
+  id TEXT PRIMARY KEY,
+  user_uuid UUID NOT NULL REFERENCES public.users(uuid) ON DELETE CASCADE,
+  role_key TEXT NOT NULL REFERENCES public.rbac_roles(role_key) ON DELETE CASCADE,
+  tenant_id TEXT NOT NULL DEFAULT '',
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (user_uuid, role_key, tenant_id)
+);
+
+CREATE INDEX IF NOT EXISTS rbac_role_bindings_tenant_idx ON public.rbac_role_bindings (tenant_id) WHERE tenant_id <> '';
+
+COMMENT ON COLUMN public.rbac_role_bindings.tenant_id IS 'empty for platform-wide bindings, otherwise the tenant the role applies to';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.roles.read', 'read roles and role bindings'),
+  ('admin.roles.write', 'manage custom roles and role bindings')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.roles.read', true),
+  ('operator', 'admin.roles.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
@@ -0,0 +1,50 @@
+-- Service accounts and scoped API tokens for integrations
+-- Migration: 20260503_api_tokens.sql
+
+CREATE TABLE IF NOT EXISTS public.service_accounts (
+  id UUID PRIMARY KEY,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  tenant_id TEXT NOT NULL DEFAULT '',
+  permissions JSONB NOT NULL DEFAULT '[]'::jsonb,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS service_accounts_tenant_name_idx ON public.service_accounts (tenant_id, lower(name));
+
+COMMENT ON COLUMN public.service_accounts.tenant_id IS 'empty for platform service accounts, otherwise the only tenant the account acts in';
+
+CREATE TABLE IF NOT EXISTS public.api_tokens (
+  id UUID PRIMARY KEY,
+  name TEXT NOT NULL,
+  user_uuid UUID REFERENCES public.users(uuid) ON DELETE CASCADE,
+  service_account_id UUID REFERENCES public.service_accounts(id) ON DELETE CASCADE,
+  tenant_id TEXT NOT NULL DEFAULT '',
+  scopes JSONB NOT NULL DEFAULT '[]'::jsonb,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ,
+  CONSTRAINT api_tokens_single_owner_ck CHECK ((user_uuid IS NULL) <> (service_account_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON public.api_tokens (user_uuid, created_at) WHERE user_uuid IS NOT NULL;
+CREATE INDEX IF NOT EXISTS api_tokens_service_account_idx ON public.api_tokens (service_account_id, created_at) WHERE service_account_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS api_tokens_tenant_idx ON public.api_tokens (tenant_id) WHERE tenant_id <> '';
+
+COMMENT ON COLUMN public.api_tokens.token_hash IS 'hex SHA-256 of the bearer token; the token itself is shown once';
+COMMENT ON COLUMN public.api_tokens.scopes IS 'admin.* permission strings the token is limited to';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.service_accounts.read', 'read service accounts and their tokens'),
+  ('admin.service_accounts.write', 'manage service accounts and issue and revoke their tokens')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.service_accounts.read', true),
+  ('operator', 'admin.service_accounts.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
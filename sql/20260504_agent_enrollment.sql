@@ -0,0 +1,27 @@
+-- One-time join tokens for agent certificate enrollment
+-- Migration: 20260504_agent_enrollment.sql
+
+CREATE TABLE IF NOT EXISTS public.agent_join_tokens (
+  id UUID PRIMARY KEY,
+  agent_id TEXT NOT NULL,
+  groups JSONB NOT NULL DEFAULT '[]'::jsonb,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS agent_join_tokens_agent_idx ON public.agent_join_tokens (agent_id, created_at);
+
+COMMENT ON TABLE public.agent_join_tokens IS 'one-time tokens agents exchange with a CSR for their first client certificate';
+COMMENT ON COLUMN public.agent_join_tokens.token_hash IS 'hex SHA-256 of the join token; the token itself is shown once';
+COMMENT ON COLUMN public.agent_join_tokens.used_at IS 'set when an agent enrolls; a used token cannot enroll again';
+
+INSERT INTO public.rbac_permissions (permission_key, description) VALUES
+  ('admin.agents.write', 'issue agent join tokens and manage agent credentials')
+ON CONFLICT (permission_key) DO NOTHING;
+
+INSERT INTO public.rbac_role_permissions (role_key, permission_key, enabled) VALUES
+  ('operator', 'admin.agents.write', false)
+ON CONFLICT (role_key, permission_key) DO NOTHING;
@@ -0,0 +1,17 @@
+-- Bearer tokens for agents created through the admin API
+-- Migration: 20260505_agent_tokens.sql
+
+CREATE TABLE IF NOT EXISTS public.agent_tokens (
+  id UUID PRIMARY KEY,
+  agent_id TEXT NOT NULL REFERENCES public.agents(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS agent_tokens_agent_idx ON public.agent_tokens (agent_id, created_at);
+
+COMMENT ON TABLE public.agent_tokens IS 'bearer tokens of managed agents; loaded into the agent registry on start and every minute';
+COMMENT ON COLUMN public.agent_tokens.token_hash IS 'hex SHA-256 of the agent token; the token itself is shown once';
+COMMENT ON COLUMN public.agent_tokens.expires_at IS 'set when the token is rotated out; NULL means the token does not expire';
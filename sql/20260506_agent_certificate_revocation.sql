@@ -0,0 +1,15 @@
+-- Agent certificate tracking and per-agent certificate revocation
+-- Migration: 20260506_agent_certificate_revocation.sql
+
+ALTER TABLE public.agents ADD COLUMN IF NOT EXISTS certificate_expires_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN public.agents.certificate_expires_at IS 'expiry of the latest certificate issued to the agent; stale cleanup keeps the agent until then';
+
+CREATE TABLE IF NOT EXISTS public.agent_certificate_revocations (
+  agent_id TEXT PRIMARY KEY,
+  revoked_before TIMESTAMPTZ NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE public.agent_certificate_revocations IS 'certificates issued to the agent before revoked_before are rejected; kept after the agent is deleted';
+COMMENT ON COLUMN public.agent_certificate_revocations.revoked_before IS 'compared with the issuance time encoded in the certificate serial';
@@ -0,0 +1,8 @@
+-- SCIM: remember which tenant memberships came with a provisioned account
+-- Migration: 20260507_scim_provisioned_memberships.sql
+
+-- tenant_memberships is managed by the service's schema auto-migration, which
+-- adds this column as well; the statement covers databases migrated by hand.
+ALTER TABLE IF EXISTS public.tenant_memberships ADD COLUMN IF NOT EXISTS provisioned BOOLEAN NOT NULL DEFAULT false;
+
+COMMENT ON COLUMN public.tenant_memberships.provisioned IS 'the tenant created the account over SCIM; with no other tenant it may change the email, password and active state';
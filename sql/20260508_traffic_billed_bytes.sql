@@ -0,0 +1,11 @@
+-- Track the bytes of each traffic minute bucket already charged, so bytes
+-- that arrive after a bucket was rated are billed on their own
+-- Migration: 20260508_traffic_billed_bytes.sql
+
+ALTER TABLE public.traffic_minute_buckets
+  ADD COLUMN IF NOT EXISTS billed_bytes BIGINT NOT NULL DEFAULT 0;
+
+-- Buckets rated before this column existed were billed in full.
+UPDATE public.traffic_minute_buckets
+  SET billed_bytes = total_bytes
+  WHERE rating_status = 'rated' AND billed_bytes = 0;